@@ -10,10 +10,10 @@ package main
 import (
 	"os"
 
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/signals"
-	"github.com/mongodb/mongo-tools-common/util"
 	"github.com/mongodb/mongo-tools/bsondump"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/signals"
+	"github.com/mongodb/mongo-tools/common/util"
 )
 
 var (
@@ -27,8 +27,10 @@ func main() {
 	if err != nil {
 		log.Logvf(log.Always, "%v", err)
 		log.Logvf(log.Always, util.ShortUsage("bsondump"))
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitBadOptions)
 	}
+	defer util.Shutdown()
+	defer util.RecoverCrash()
 
 	// print help, if specified
 	if opts.PrintHelp(false) {
@@ -45,13 +47,13 @@ func main() {
 	dumper, err := bsondump.New(opts)
 	if err != nil {
 		log.Logv(log.Always, err.Error())
-		os.Exit(util.ExitFailure)
+		util.Exit(util.FailureExitCode(err))
 	}
 	defer func() {
 		err := dumper.Close()
 		if err != nil {
 			log.Logvf(log.Always, "error cleaning up: %v", err)
-			os.Exit(util.ExitFailure)
+			util.Exit(util.ExitFailure)
 		}
 	}()
 
@@ -67,6 +69,6 @@ func main() {
 	log.Logvf(log.Always, "%v objects found", numFound)
 	if err != nil {
 		log.Logv(log.Always, err.Error())
-		os.Exit(util.ExitFailure)
+		util.Exit(util.FailureExitCode(err))
 	}
 }
@@ -9,8 +9,8 @@ package bsondump
 import (
 	"fmt"
 
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/options"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
 )
 
 var Usage = `<options> <file>
@@ -78,6 +78,10 @@ func ParseOptions(rawArgs []string, versionStr, gitCommit string) (Options, erro
 		outputOpts.BSONFileName = args[0]
 	}
 
+	if outputOpts.OutFileName == "" && toolOpts.LogsToStdout() {
+		return Options{}, fmt.Errorf("--logSplitStreams and --progressJson=- require --outFile, since output is written to stdout by default")
+	}
+
 	switch outputOpts.Type {
 	case "", DebugOutputType, JSONOutputType:
 		return Options{toolOpts, outputOpts}, nil
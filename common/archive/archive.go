@@ -0,0 +1,149 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package archive
+
+import (
+	"io"
+
+	"github.com/mongodb/mongo-tools/common/encryption"
+	"github.com/mongodb/mongo-tools/common/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// archiveLog logs on behalf of the "archive" component, so archive muxing and
+// demuxing can be traced independently of other output.
+var archiveLog = log.Component("archive")
+
+// NamespaceHeader is a data structure that, as BSON, is found in archives where it indicates
+// that either the subsequent stream of BSON belongs to this new namespace, or that the
+// indicated namespace will have no more documents (EOF)
+type NamespaceHeader struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	EOF        bool   `bson:"EOF"`
+	CRC        int64  `bson:"CRC"`
+}
+
+// CollectionMetadata is a data structure that, as BSON, is found in the prelude of the archive.
+// There is one CollectionMetadata per collection that will be in the archive.
+type CollectionMetadata struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	Metadata   string `bson:"metadata"`
+	Size       int    `bson:"size"`
+	// Documents is the number of documents the collection was estimated to
+	// have when the archive was started, or nil in archives that don't
+	// record it
+	Documents *int64 `bson:"documents,omitempty"`
+}
+
+// Header is a data structure that, as BSON, is found immediately after the magic
+// number in the archive, before any CollectionMetadatas. It is the home of any archive level information
+type Header struct {
+	ConcurrentCollections int32  `bson:"concurrent_collections"`
+	FormatVersion         string `bson:"version"`
+	ServerVersion         string `bson:"server_version"`
+	ToolVersion           string `bson:"tool_version"`
+	// Incremental is set for incremental dumps
+	Incremental *IncrementalRange `bson:"incremental,omitempty"`
+	// Sharded is set for dumps of a sharded cluster taken by reading each
+	// shard at the same cluster time
+	Sharded *ShardedSnapshot `bson:"sharded,omitempty"`
+	// Encryption is set for encrypted archives, whose contents after the
+	// header are compressed and then encrypted with the key it describes
+	Encryption *encryption.KeyInfo `bson:"encryption,omitempty"`
+}
+
+// IncrementalMetadataFile is the file in the root of a directory dump that
+// holds the IncrementalRange of an incremental dump.
+const IncrementalMetadataFile = "incremental.json"
+
+// EncryptionMetadataFile is the file in the root of a directory dump that
+// holds the encryption.KeyInfo of an encrypted dump.
+const EncryptionMetadataFile = "encryption.json"
+
+// ManifestFile is the file in the root of a directory dump that lists its
+// files with their sizes and checksums.
+const ManifestFile = "manifest.json"
+
+// IncrementalRange is the window of oplog entries captured by an incremental
+// dump. Entries after Since, up to and including Until, are in the dump's oplog,
+// and are applied with --oplogReplay on top of a restore of the dump taken at Since.
+type IncrementalRange struct {
+	Since primitive.Timestamp `bson:"since" json:"since"`
+	Until primitive.Timestamp `bson:"until" json:"until"`
+}
+
+// ShardedSnapshot records how a sharded cluster was dumped: the shards that
+// were read, the cluster time they were all read at, and the shard key of
+// each sharded collection, so that a restore can recreate the distribution.
+type ShardedSnapshot struct {
+	ClusterTime primitive.Timestamp `bson:"cluster_time"`
+	Shards      []ShardInfo         `bson:"shards"`
+	Collections []ShardedCollection `bson:"collections,omitempty"`
+}
+
+// ShardInfo identifies a shard of a sharded cluster.
+type ShardInfo struct {
+	ID   string `bson:"_id"`
+	Host string `bson:"host"`
+}
+
+// ShardedCollection is the shard key of a sharded collection.
+type ShardedCollection struct {
+	Namespace string `bson:"ns"`
+	Key       bson.D `bson:"key"`
+	Unique    bool   `bson:"unique,omitempty"`
+}
+
+// ShardingMetadata records how a collection dumped through mongos was
+// sharded: its shard key, the shard key values its chunks were split at and
+// its zone ranges, so that mongorestore --shardCollections can shard and
+// pre-split it before inserting its documents. It is written to the
+// collection's metadata.
+type ShardingMetadata struct {
+	Key         bson.D      `bson:"key"`
+	Unique      bool        `bson:"unique,omitempty"`
+	SplitPoints []bson.D    `bson:"splitPoints,omitempty"`
+	Zones       []ShardZone `bson:"zones,omitempty"`
+}
+
+// ShardZone is a range of shard key values assigned to a zone, with Min
+// inclusive and Max exclusive.
+type ShardZone struct {
+	Zone string `bson:"zone"`
+	Min  bson.D `bson:"min"`
+	Max  bson.D `bson:"max"`
+}
+
+const minBSONSize = 4 + 1 // an empty BSON document should be exactly five bytes long
+
+var terminator int32 = -1
+var terminatorBytes = []byte{0xFF, 0xFF, 0xFF, 0xFF} // TODO, rectify this with terminator
+
+// MagicNumber is four bytes that are found at the beginning of the archive that indicate that
+// the byte stream is an archive, as opposed to anything else, including a stream of BSON documents
+const MagicNumber uint32 = 0x8199e26d
+const archiveFormatVersion = "0.1"
+
+// Writer is the top level object to contain information about archives in mongodump
+type Writer struct {
+	Out     io.WriteCloser
+	Prelude *Prelude
+	Mux     *Multiplexer
+	// Body is where everything after the header is written, which is Out
+	// unless the archive is encrypted
+	Body io.Writer
+}
+
+// Reader is the top level object to contain information about archives in mongorestore
+type Reader struct {
+	In      io.ReadCloser
+	Demux   *Demultiplexer
+	Prelude *Prelude
+}
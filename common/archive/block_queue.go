@@ -0,0 +1,103 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package archive
+
+import (
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// blockQueue holds the bodies of a namespace read from the archive until its
+// consumer reads them. put waits while the queue holds limit bytes, or while
+// the budget shared with other buffers is spent, which applies backpressure
+// to the demultiplexer, though a single body is always accepted into an
+// empty queue.
+type blockQueue struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	blocks [][]byte
+	size   int
+	limit  int
+	budget *util.MemoryBudget
+	ended  bool // no more blocks will be put
+	closed bool // no more blocks will be read
+}
+
+func newBlockQueue(limit int, budget *util.MemoryBudget) *blockQueue {
+	queue := &blockQueue{limit: limit, budget: budget}
+	queue.cond = sync.NewCond(&queue.mutex)
+	return queue
+}
+
+// put adds block to the queue, waiting for room for it. It returns
+// errInterrupted if the consumer has stopped reading.
+func (queue *blockQueue) put(block []byte) error {
+	queue.mutex.Lock()
+	for queue.size > 0 && queue.size+len(block) > queue.limit && !queue.closed {
+		queue.cond.Wait()
+	}
+	empty := queue.size == 0
+	queue.mutex.Unlock()
+	// the budget is waited for without the lock, so that the consumer can
+	// drain the queue meanwhile; only the demultiplexer puts blocks
+	if empty {
+		queue.budget.Grow(int64(len(block)))
+	} else {
+		queue.budget.Acquire(int64(len(block)))
+	}
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if queue.closed {
+		queue.budget.Release(int64(len(block)))
+		return errInterrupted
+	}
+	queue.blocks = append(queue.blocks, block)
+	queue.size += len(block)
+	queue.cond.Broadcast()
+	return nil
+}
+
+// get removes the oldest block from the queue, waiting for one to be put. It
+// returns false once the queue has ended and is empty.
+func (queue *blockQueue) get() ([]byte, bool) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	for len(queue.blocks) == 0 && !queue.ended && !queue.closed {
+		queue.cond.Wait()
+	}
+	if len(queue.blocks) == 0 {
+		return nil, false
+	}
+	block := queue.blocks[0]
+	queue.blocks[0] = nil
+	queue.blocks = queue.blocks[1:]
+	queue.size -= len(block)
+	queue.budget.Release(int64(len(block)))
+	queue.cond.Broadcast()
+	return block, true
+}
+
+// end marks that no more blocks will be put.
+func (queue *blockQueue) end() {
+	queue.mutex.Lock()
+	queue.ended = true
+	queue.cond.Broadcast()
+	queue.mutex.Unlock()
+}
+
+// close marks that no more blocks will be read, releasing a waiting put.
+func (queue *blockQueue) close() {
+	queue.mutex.Lock()
+	queue.closed = true
+	queue.blocks = nil
+	queue.budget.Release(int64(queue.size))
+	queue.size = 0
+	queue.cond.Broadcast()
+	queue.mutex.Unlock()
+}
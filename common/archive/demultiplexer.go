@@ -15,9 +15,10 @@ import (
 	"sync"
 	"sync/atomic"
 
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/intents"
-	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
@@ -37,10 +38,13 @@ const (
 
 // Demultiplexer implements Parser.
 type Demultiplexer struct {
-	In io.Reader
+	read  int64 // updated atomically, aligned at the beginning of the struct
+	total int64
+	In    io.Reader
 	//TODO wrap up these three into a structure
 	outs             map[string]DemuxOut
 	lengths          map[string]int64
+	sizes            map[string]int64
 	currentNamespace string
 	buf              [db.MaxBSONSize]byte
 
@@ -52,30 +56,48 @@ type Demultiplexer struct {
 	NamespaceErrorChan chan error
 
 	NamespaceStatus map[string]int
+
+	// BufferSize is the number of bytes of each namespace that are held in
+	// memory for its consumer, so that the demultiplexer only waits for a
+	// consumer whose buffer is full. When it is 0, each body is handed to its
+	// consumer as it is read.
+	BufferSize int
+
+	// Budget, if set, bounds the bytes held in the buffers of all namespaces
+	// together with the other buffers sharing it.
+	Budget *util.MemoryBudget
 }
 
 func CreateDemux(namespaceMetadatas []*CollectionMetadata, in io.Reader) *Demultiplexer {
 	demux := &Demultiplexer{
 		NamespaceStatus: make(map[string]int),
 		In:              in,
+		sizes:           make(map[string]int64),
 	}
 	for _, cm := range namespaceMetadatas {
 		ns := cm.Database + "." + cm.Collection
 		demux.NamespaceStatus[ns] = NamespaceUnopened
-
+		demux.sizes[ns] = int64(cm.Size)
+		demux.total += int64(cm.Size)
 	}
 	return demux
 }
 
+// Progress returns the number of bytes of collection data demultiplexed so
+// far and the total recorded in the prelude, as a progress.Progressor.
+func (demux *Demultiplexer) Progress() (int64, int64) {
+	return atomic.LoadInt64(&demux.read), demux.total
+}
+
 // Run creates and runs a parser with the Demultiplexer as a consumer
 func (demux *Demultiplexer) Run() error {
 	parser := Parser{In: demux.In}
 	err := parser.ReadAllBlocks(demux)
 	if len(demux.outs) > 0 {
-		log.Logvf(log.Always, "demux finishing when there are still outs (%v)", len(demux.outs))
+		archiveLog.Logvf(log.Always, "demux finishing when there are still outs (%v)", len(demux.outs))
 	}
 
-	log.Logvf(log.DebugLow, "demux finishing (err:%v)", err)
+	archiveLog.Logvf(log.DebugLow, "demux finishing (err:%v)", err)
 	return err
 }
 
@@ -116,7 +138,7 @@ func (demux *Demultiplexer) HeaderBSON(buf []byte) error {
 	if err != nil {
 		return newWrappedError("header bson doesn't unmarshal as a collection header", err)
 	}
-	log.Logvf(log.DebugHigh, "demux namespaceHeader: %v", colHeader)
+	archiveLog.Logvf(log.DebugHigh, "demux namespaceHeader: %v", colHeader)
 	if colHeader.Collection == "" {
 		return newError("collection header is missing a Collection")
 	}
@@ -158,11 +180,11 @@ func (demux *Demultiplexer) HeaderBSON(buf []byte) error {
 					colHeader.CRC,
 				)
 			}
-			log.Logvf(log.DebugHigh,
+			archiveLog.Logvf(log.DebugHigh,
 				"demux checksum for namespace %v is correct (%v), %v bytes",
 				demux.currentNamespace, crc, length)
 		} else {
-			log.Logvf(log.DebugHigh,
+			archiveLog.Logvf(log.DebugHigh,
 				"demux checksum for namespace %v was not calculated.",
 				demux.currentNamespace)
 		}
@@ -177,7 +199,7 @@ func (demux *Demultiplexer) HeaderBSON(buf []byte) error {
 
 // End is part of the ParserConsumer interface and receives the end of archive notification.
 func (demux *Demultiplexer) End() error {
-	log.Logvf(log.DebugHigh, "demux End")
+	archiveLog.Logvf(log.DebugHigh, "demux End")
 	var err error
 	if len(demux.outs) != 0 {
 		openNss := []string{}
@@ -212,6 +234,7 @@ func (demux *Demultiplexer) BodyBSON(buf []byte) error {
 	}
 
 	demux.lengths[demux.currentNamespace] += int64(len(buf))
+	atomic.AddInt64(&demux.read, int64(len(buf)))
 
 	out, ok := demux.outs[demux.currentNamespace]
 	if !ok {
@@ -227,7 +250,7 @@ func (demux *Demultiplexer) Open(ns string, out DemuxOut) {
 	// or while the demutiplexer is inside of the NamespaceChan NamespaceErrorChan conversation
 	// I think that we don't need to lock outs, but I suspect that if the implementation changes
 	// we may need to lock when outs is accessed
-	log.Logvf(log.DebugHigh, "demux Open")
+	archiveLog.Logvf(log.DebugHigh, "demux Open")
 	if demux.outs == nil {
 		demux.outs = make(map[string]DemuxOut)
 		demux.lengths = make(map[string]int64)
@@ -236,6 +259,22 @@ func (demux *Demultiplexer) Open(ns string, out DemuxOut) {
 	demux.lengths[ns] = 0
 }
 
+// SkipMuted closes the namespaces opened with a MutedCollection, whose
+// blocks won't be read from In, and returns them. It must be called before
+// Run, with In set to a reader of the archive without those blocks.
+func (demux *Demultiplexer) SkipMuted() map[string]bool {
+	skipped := map[string]bool{}
+	for ns, out := range demux.outs {
+		if _, ok := out.(*MutedCollection); ok {
+			skipped[ns] = true
+			delete(demux.outs, ns)
+			demux.NamespaceStatus[ns] = NamespaceClosed
+			demux.total -= demux.sizes[ns]
+		}
+	}
+	return skipped
+}
+
 // RegularCollectionReceiver implements the intents.file interface.
 type RegularCollectionReceiver struct {
 	pos              int64 // updated atomically, aligned at the beginning of the struct
@@ -246,6 +285,7 @@ type RegularCollectionReceiver struct {
 	Demux            *Demultiplexer
 	partialReadArray []byte
 	partialReadBuf   []byte
+	queue            *blockQueue
 	hash             hash.Hash64
 	closeOnce        sync.Once
 	endOnce          sync.Once
@@ -270,6 +310,19 @@ func (receiver *RegularCollectionReceiver) Read(r []byte) (int, error) {
 		atomic.AddInt64(&receiver.pos, int64(copyLen))
 		return copyLen, nil
 	}
+	if receiver.queue != nil {
+		block, ok := receiver.queue.get()
+		if !ok {
+			return 0, receiver.err
+		}
+		receiver.hash.Write(block)
+		copyLen := copy(r, block)
+		if copyLen < len(block) {
+			receiver.partialReadBuf = block[copyLen:]
+		}
+		atomic.AddInt64(&receiver.pos, int64(copyLen))
+		return copyLen, nil
+	}
 	// Since we're the "reader" here, not the "writer" we need to start with a read, in case the chan is closed
 	wLen, ok := <-receiver.readLenChan
 	if !ok {
@@ -319,6 +372,9 @@ func (receiver *RegularCollectionReceiver) Open() error {
 		receiver.readLenChan = make(chan int)
 		receiver.readBufChan = make(chan []byte)
 		receiver.hash = crc64.New(crc64.MakeTable(crc64.ECMA))
+		if receiver.Demux.BufferSize > 0 {
+			receiver.queue = newBlockQueue(receiver.Demux.BufferSize, receiver.Demux.Budget)
+		}
 		receiver.Demux.Open(receiver.Origin, receiver)
 	})
 	return nil
@@ -334,6 +390,15 @@ func (receiver *RegularCollectionReceiver) ReleaseIOBuffer() {
 
 // Write is part of the DemuxOut interface.
 func (receiver *RegularCollectionReceiver) Write(buf []byte) (int, error) {
+	if receiver.queue != nil {
+		// the parser reuses buf, so the queue holds a copy
+		block := make([]byte, len(buf))
+		copy(block, buf)
+		if err := receiver.queue.put(block); err != nil {
+			return 0, err
+		}
+		return len(buf), nil
+	}
 	//  As a writer, we need to write first, so that the reader can properly detect EOF
 	//  Additionally, the reader needs to know the write size, so that it can give us a
 	//  properly sized buffer. Sending the incoming buffersize fills both of these needs.
@@ -367,6 +432,9 @@ func (receiver *RegularCollectionReceiver) Close() error {
 	// Close must be idempotent and repeat channel closes panic; only do once.
 	receiver.closeOnce.Do(func() {
 		close(receiver.readBufChan)
+		if receiver.queue != nil {
+			receiver.queue.close()
+		}
 	})
 	return nil
 }
@@ -378,6 +446,9 @@ func (receiver *RegularCollectionReceiver) End() {
 	// To keep this idempotent, close the channel only once.
 	receiver.endOnce.Do(func() {
 		close(receiver.readLenChan)
+		if receiver.queue != nil {
+			receiver.queue.end()
+		}
 	})
 	<-receiver.readBufChan
 }
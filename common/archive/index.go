@@ -0,0 +1,92 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package archive
+
+import (
+	"io"
+	"sort"
+)
+
+// IndexFormatVersion is the version of the archive index format.
+const IndexFormatVersion = 1
+
+// IndexSuffix is appended to the path of an archive for the path of the
+// index mongodump --archiveIndex writes next to it.
+const IndexSuffix = ".index.json"
+
+// Index records where the blocks of each namespace are in an archive, so
+// that the namespaces of an archive on seekable media can be read without
+// reading the others. It only describes archives that are neither
+// compressed nor encrypted, whose blocks are written as they are.
+type Index struct {
+	FormatVersion int `json:"formatVersion"`
+	// Size is the size of the archive indexed, which an archive that
+	// doesn't match it isn't read with.
+	Size       int64                   `json:"size"`
+	Namespaces map[string][]BlockRange `json:"namespaces"`
+
+	position   func() int64
+	blockStart int64
+}
+
+// BlockRange is the byte range of a block of an archive: its header, its
+// bodies and its terminator.
+type BlockRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// NewIndex returns an Index for a Multiplexer to record its blocks in.
+// position returns the number of bytes of the archive written so far.
+func NewIndex(position func() int64) *Index {
+	return &Index{
+		FormatVersion: IndexFormatVersion,
+		Namespaces:    map[string][]BlockRange{},
+		position:      position,
+	}
+}
+
+// startBlock records that a block is started at the current position.
+func (index *Index) startBlock() {
+	if index == nil {
+		return
+	}
+	index.blockStart = index.position()
+}
+
+// endBlock records the block of ns ending at the current position.
+func (index *Index) endBlock(ns string) {
+	if index == nil {
+		return
+	}
+	end := index.position()
+	index.Namespaces[ns] = append(index.Namespaces[ns], BlockRange{Offset: index.blockStart, Length: end - index.blockStart})
+	index.Size = end
+}
+
+// Reader returns a reader of the blocks of the archive in that aren't of
+// the skipped namespaces, in the order they were written.
+func (index *Index) Reader(in io.ReaderAt, skipped map[string]bool) io.Reader {
+	var ranges []BlockRange
+	for ns, blocks := range index.Namespaces {
+		if !skipped[ns] {
+			ranges = append(ranges, blocks...)
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Offset < ranges[j].Offset })
+	var readers []io.Reader
+	for i := 0; i < len(ranges); i++ {
+		// consecutive blocks are read as one
+		block := ranges[i]
+		for i+1 < len(ranges) && ranges[i+1].Offset == block.Offset+block.Length {
+			block.Length += ranges[i+1].Length
+			i++
+		}
+		readers = append(readers, io.NewSectionReader(in, block.Offset, block.Length))
+	}
+	return io.MultiReader(readers...)
+}
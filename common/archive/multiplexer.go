@@ -13,9 +13,9 @@ import (
 	"io"
 	"reflect"
 
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/intents"
-	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
@@ -36,6 +36,8 @@ type Multiplexer struct {
 	ins              []*MuxIn
 	selectCases      []reflect.SelectCase
 	currentNamespace string
+	// Index, if set, records the blocks written to Out
+	Index *Index
 }
 
 type notifier interface {
@@ -74,7 +76,7 @@ func (mux *Multiplexer) Run() {
 		EOF := !notEOF
 		if index == 0 { //Control index
 			if EOF {
-				log.Logvf(log.DebugLow, "Mux finish")
+				archiveLog.Logvf(log.DebugLow, "Mux finish")
 				mux.Out.Close()
 				if completionErr != nil {
 					mux.Completed <- completionErr
@@ -91,7 +93,7 @@ func (mux *Multiplexer) Run() {
 				mux.Completed <- fmt.Errorf("non MuxIn received on Control chan") // one for the MuxIn.Open
 				return
 			}
-			log.Logvf(log.DebugLow, "Mux open namespace %v", muxIn.Intent.Namespace())
+			archiveLog.Logvf(log.DebugLow, "Mux open namespace %v", muxIn.Intent.Namespace())
 			mux.selectCases = append(mux.selectCases, reflect.SelectCase{
 				Dir:  reflect.SelectRecv,
 				Chan: reflect.ValueOf(muxIn.writeChan),
@@ -114,7 +116,7 @@ func (mux *Multiplexer) Run() {
 					mux.Out = &nopCloseNopWriter{}
 					completionErr = err
 				}
-				log.Logvf(log.DebugLow, "Mux close namespace %v", mux.ins[index].Intent.Namespace())
+				archiveLog.Logvf(log.DebugLow, "Mux close namespace %v", mux.ins[index].Intent.Namespace())
 				mux.currentNamespace = ""
 				mux.selectCases = append(mux.selectCases[:index], mux.selectCases[index+1:]...)
 				mux.ins = append(mux.ins[:index], mux.ins[index+1:]...)
@@ -159,7 +161,9 @@ func (mux *Multiplexer) formatBody(in *MuxIn, bsonBytes []byte) error {
 			if l != len(terminatorBytes) {
 				return io.ErrShortWrite
 			}
+			mux.Index.endBlock(mux.currentNamespace)
 		}
+		mux.Index.startBlock()
 		header, err := bson.Marshal(NamespaceHeader{
 			Database:   in.Intent.DB,
 			Collection: in.Intent.C,
@@ -194,7 +198,9 @@ func (mux *Multiplexer) formatEOF(index int, in *MuxIn) error {
 		if l != len(terminatorBytes) {
 			return io.ErrShortWrite
 		}
+		mux.Index.endBlock(mux.currentNamespace)
 	}
+	mux.Index.startBlock()
 	eofHeader, err := bson.Marshal(NamespaceHeader{
 		Database:   in.Intent.DB,
 		Collection: in.Intent.C,
@@ -218,6 +224,7 @@ func (mux *Multiplexer) formatEOF(index int, in *MuxIn) error {
 	if l != len(terminatorBytes) {
 		return io.ErrShortWrite
 	}
+	mux.Index.endBlock(in.Intent.Namespace())
 	return nil
 }
 
@@ -249,7 +256,7 @@ func (muxIn *MuxIn) Pos() int64 {
 // formatEOF to occur.
 func (muxIn *MuxIn) Close() error {
 	// the mux side of this gets closed in the mux when it gets an eof on the read
-	log.Logvf(log.DebugHigh, "MuxIn close %v", muxIn.Intent.Namespace())
+	archiveLog.Logvf(log.DebugHigh, "MuxIn close %v", muxIn.Intent.Namespace())
 	if bufferWrites {
 		muxIn.writeChan <- muxIn.buf
 		length := <-muxIn.writeLenChan
@@ -270,7 +277,7 @@ func (muxIn *MuxIn) Close() error {
 // Open is implemented in Mux.open, but in short, it creates chans and a select case
 // and adds the SelectCase and the MuxIn in to the Multiplexer.
 func (muxIn *MuxIn) Open() error {
-	log.Logvf(log.DebugHigh, "MuxIn open %v", muxIn.Intent.Namespace())
+	archiveLog.Logvf(log.DebugHigh, "MuxIn open %v", muxIn.Intent.Namespace())
 	muxIn.writeChan = make(chan []byte)
 	muxIn.writeLenChan = make(chan int)
 	muxIn.writeCloseFinishedChan = make(chan struct{})
@@ -13,9 +13,10 @@ import (
 	"path/filepath"
 	"sync/atomic"
 
-	"github.com/mongodb/mongo-tools-common/intents"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/encryption"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
@@ -61,6 +62,10 @@ type Prelude struct {
 	DBS                    []string
 	NamespaceMetadatas     []*CollectionMetadata
 	NamespaceMetadatasByDB map[string][]*CollectionMetadata
+
+	// Decrypt is called when the header shows the archive is encrypted, and
+	// returns a reader of the rest of the archive decrypted from in
+	Decrypt func(info *encryption.KeyInfo, in io.Reader) (io.Reader, error)
 }
 
 // Read consumes and checks the magic number at the beginning of the archive,
@@ -87,8 +92,13 @@ func (prelude *Prelude) Read(in io.Reader) error {
 	}
 
 	parser := Parser{In: in}
-	parserConsumer := &preludeParserConsumer{prelude: prelude}
-	return parser.ReadBlock(parserConsumer)
+	parserConsumer := &preludeParserConsumer{prelude: prelude, parser: &parser}
+	err = parser.ReadBlock(parserConsumer)
+	if parserConsumer.decryptErr != nil {
+		// not a corrupt archive, so not wrapped as a parser error
+		return parserConsumer.decryptErr
+	}
+	return err
 }
 
 // NewPrelude generates a Prelude using the contents of an intent.Manager.
@@ -104,6 +114,8 @@ func NewPrelude(manager *intents.Manager, concurrentColls int, serverVersion, to
 	}
 	allIntents := manager.Intents()
 	for _, intent := range allIntents {
+		// the Size of a dump intent is its collection's estimated count
+		documents := intent.Size
 		if intent.MetadataFile != nil {
 			archiveMetadata, ok := intent.MetadataFile.(*MetadataFile)
 			if !ok {
@@ -113,11 +125,13 @@ func NewPrelude(manager *intents.Manager, concurrentColls int, serverVersion, to
 				Database:   intent.DB,
 				Collection: intent.C,
 				Metadata:   archiveMetadata.Buffer.String(),
+				Documents:  &documents,
 			})
 		} else {
 			prelude.AddMetadata(&CollectionMetadata{
 				Database:   intent.DB,
 				Collection: intent.C,
+				Documents:  &documents,
 			})
 		}
 	}
@@ -135,11 +149,17 @@ func (prelude *Prelude) AddMetadata(cm *CollectionMetadata) {
 		prelude.DBS = append(prelude.DBS, cm.Database)
 	}
 	prelude.NamespaceMetadatasByDB[cm.Database] = append(prelude.NamespaceMetadatasByDB[cm.Database], cm)
-	log.Logvf(log.Info, "archive prelude %v.%v", cm.Database, cm.Collection)
+	archiveLog.Logvf(log.Info, "archive prelude %v.%v", cm.Database, cm.Collection)
 }
 
 // Write writes the archive header.
 func (prelude *Prelude) Write(out io.Writer) error {
+	return prelude.WriteWithBody(out, out)
+}
+
+// WriteWithBody writes the magic number and header to out, and the
+// collection metadata to body.
+func (prelude *Prelude) WriteWithBody(out, body io.Writer) error {
 	magicNumberBytes := make([]byte, 4)
 	for i := range magicNumberBytes {
 		magicNumberBytes[i] = byte(uint32(MagicNumber) >> uint(i*8))
@@ -161,12 +181,12 @@ func (prelude *Prelude) Write(out io.Writer) error {
 		if err != nil {
 			return err
 		}
-		_, err = out.Write(buf)
+		_, err = body.Write(buf)
 		if err != nil {
 			return err
 		}
 	}
-	_, err = out.Write(terminatorBytes)
+	_, err = body.Write(terminatorBytes)
 	if err != nil {
 		return err
 	}
@@ -175,16 +195,27 @@ func (prelude *Prelude) Write(out io.Writer) error {
 
 // preludeParserConsumer wraps a Prelude, and implements ParserConsumer.
 type preludeParserConsumer struct {
-	prelude *Prelude
+	prelude    *Prelude
+	parser     *Parser
+	decryptErr error
 }
 
 // HeaderBSON is part of the ParserConsumer interface, it unmarshals archive Headers.
+// The collection metadata of an encrypted archive is read through Decrypt.
 func (hpc *preludeParserConsumer) HeaderBSON(data []byte) error {
 	hpc.prelude.Header = &Header{}
 	err := bson.Unmarshal(data, hpc.prelude.Header)
 	if err != nil {
 		return err
 	}
+	if info := hpc.prelude.Header.Encryption; info != nil {
+		if hpc.prelude.Decrypt == nil {
+			hpc.decryptErr = fmt.Errorf("archive is encrypted, and cannot be read without its key")
+			return hpc.decryptErr
+		}
+		hpc.parser.In, hpc.decryptErr = hpc.prelude.Decrypt(info, hpc.parser.In)
+		return hpc.decryptErr
+	}
 	return nil
 }
 
@@ -0,0 +1,217 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+// Package compression implements the codecs used to compress dump files and
+// archives. A codec is named on the command line as <name>[:level], and is
+// recognized in existing output by its file extension or magic number.
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec names.
+const (
+	GzipName = "gzip"
+	ZstdName = "zstd"
+	LZ4Name  = "lz4"
+)
+
+// Codec is a compression format and the level to compress at. The zero
+// value means no compression; a zero Level means the format's default.
+// Workers is the number of goroutines gzip and zstd writers compress a
+// stream with, and zstd and lz4 readers decompress one with; zero or one
+// uses the goroutine that writes or reads.
+type Codec struct {
+	Name    string
+	Level   int
+	Workers int
+}
+
+// None is the codec for uncompressed data.
+var None = Codec{}
+
+// Gzip is the codec used by --gzip.
+var Gzip = Codec{Name: GzipName}
+
+// formats describes each supported codec.
+var formats = []struct {
+	name      string
+	extension string
+	magic     []byte
+	// minLevel and maxLevel are zero for codecs without levels
+	minLevel int
+	maxLevel int
+}{
+	{GzipName, ".gz", []byte{0x1f, 0x8b}, gzip.BestSpeed, gzip.BestCompression},
+	{ZstdName, ".zst", []byte{0x28, 0xb5, 0x2f, 0xfd}, 1, 22},
+	{LZ4Name, ".lz4", []byte{0x04, 0x22, 0x4d, 0x18}, 0, 0},
+}
+
+// Names returns the names of the supported codecs, for messages.
+func Names() []string {
+	var names []string
+	for _, format := range formats {
+		names = append(names, format.name)
+	}
+	return names
+}
+
+// Parse returns the codec for a spec of the form <name>[:level]. The name
+// "none" is accepted for no compression.
+func Parse(spec string) (Codec, error) {
+	name, levelSpec := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, levelSpec = spec[:i], spec[i+1:]
+	}
+	name = strings.ToLower(name)
+	if name == "none" && levelSpec == "" {
+		return None, nil
+	}
+	for _, format := range formats {
+		if format.name != name {
+			continue
+		}
+		codec := Codec{Name: name}
+		if levelSpec == "" {
+			return codec, nil
+		}
+		if format.maxLevel == 0 {
+			return None, fmt.Errorf("%v compression does not have levels", name)
+		}
+		level, err := strconv.Atoi(levelSpec)
+		if err != nil || level < format.minLevel || level > format.maxLevel {
+			return None, fmt.Errorf("%v compression level must be between %v and %v",
+				name, format.minLevel, format.maxLevel)
+		}
+		codec.Level = level
+		return codec, nil
+	}
+	return None, fmt.Errorf("unknown compression '%v', expected one of %v", name, strings.Join(Names(), ", "))
+}
+
+// IsNone returns true if the codec does not compress.
+func (c Codec) IsNone() bool {
+	return c.Name == ""
+}
+
+// String returns the codec in the form accepted by Parse.
+func (c Codec) String() string {
+	switch {
+	case c.IsNone():
+		return "none"
+	case c.Level != 0:
+		return fmt.Sprintf("%v:%v", c.Name, c.Level)
+	}
+	return c.Name
+}
+
+// Extension returns the suffix of files compressed with the codec, or an
+// empty string for no compression.
+func (c Codec) Extension() string {
+	for _, format := range formats {
+		if format.name == c.Name {
+			return format.extension
+		}
+	}
+	return ""
+}
+
+// FromExtension returns the codec whose extension ends name, or None.
+func FromExtension(name string) Codec {
+	for _, format := range formats {
+		if strings.HasSuffix(name, format.extension) {
+			return Codec{Name: format.name}
+		}
+	}
+	return None
+}
+
+// Detect returns the codec of the stream in r from its magic number, without
+// consuming any input. It returns None for data that is not compressed.
+func Detect(r *bufio.Reader) (Codec, error) {
+	for _, format := range formats {
+		magic, err := r.Peek(len(format.magic))
+		if err != nil && err != io.EOF {
+			return None, err
+		}
+		if bytes.Equal(magic, format.magic) {
+			return Codec{Name: format.name}, nil
+		}
+	}
+	return None, nil
+}
+
+// Writer is a compressing writer that can be reused for another stream.
+// Close flushes the stream but does not close the underlying writer.
+type Writer interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// NewWriter returns a writer that compresses to w.
+func (c Codec) NewWriter(w io.Writer) (Writer, error) {
+	switch c.Name {
+	case GzipName:
+		level := gzip.DefaultCompression
+		if c.Level != 0 {
+			level = c.Level
+		}
+		if c.Workers > 1 {
+			return newParallelGzipWriter(w, level, c.Workers)
+		}
+		return gzip.NewWriterLevel(w, level)
+	case ZstdName:
+		level := zstd.SpeedDefault
+		if c.Level != 0 {
+			level = zstd.EncoderLevelFromZstd(c.Level)
+		}
+		// callers compress many streams at once, so each one stays on a
+		// single goroutine unless it is given more workers
+		concurrency := 1
+		if c.Workers > 1 {
+			concurrency = c.Workers
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(concurrency))
+	case LZ4Name:
+		return newLZ4Writer(w), nil
+	}
+	return nil, fmt.Errorf("cannot compress with '%v'", c)
+}
+
+// NewReader returns a reader that decompresses r. Closing it releases the
+// decompressor but does not close r. Workers is the number of goroutines
+// that zstd streams and the independent blocks of lz4 streams are
+// decompressed with; gzip streams are always decompressed on one.
+func (c Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	switch c.Name {
+	case GzipName:
+		return gzip.NewReader(r)
+	case ZstdName:
+		concurrency := 1
+		if c.Workers > 1 {
+			concurrency = c.Workers
+		}
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(concurrency))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case LZ4Name:
+		lr := newLZ4Reader(r)
+		lr.workers = c.Workers
+		return lr, nil
+	}
+	return nil, fmt.Errorf("cannot decompress '%v'", c)
+}
@@ -0,0 +1,548 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package compression
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// This file implements the LZ4 frame format, which is what the lz4 command
+// line tool reads and writes. Frames are written with independent 4MB blocks
+// and a content checksum. Reading also supports linked blocks, block
+// checksums, concatenated frames and skippable frames, but not dictionaries.
+
+const (
+	lz4Magic          = 0x184d2204
+	lz4SkippableMagic = 0x184d2a50
+	lz4SkippableMask  = 0xfffffff0
+
+	lz4Version         = 0x40
+	lz4FlagIndependent = 0x20
+	lz4FlagBlockSum    = 0x10
+	lz4FlagContentSize = 0x08
+	lz4FlagContentSum  = 0x04
+	lz4FlagDictID      = 0x01
+
+	lz4UncompressedBit = 0x80000000
+
+	lz4MinMatch     = 4
+	lz4MatchLimit   = 12 // matches start at least this far from the end of a block
+	lz4LastLiterals = 5  // the end of a block is always literals
+	lz4MaxOffset    = 65535
+	lz4WindowSize   = 64 << 10
+	lz4HashLog      = 16
+)
+
+// lz4BlockSize is the size of the blocks written, which is recorded in the
+// frame descriptor as size ID 7.
+const lz4BlockSize = 4 << 20
+
+var errLZ4Corrupt = errors.New("lz4: corrupt input")
+
+// lz4BlockSizes maps the block size IDs of the frame descriptor to sizes.
+var lz4BlockSizes = map[byte]int{4: 64 << 10, 5: 256 << 10, 6: 1 << 20, 7: 4 << 20}
+
+type lz4Writer struct {
+	w           io.Writer
+	buf         []byte
+	out         []byte
+	table       []int32
+	sum         xxh32
+	wroteHeader bool
+	closed      bool
+	err         error
+}
+
+func newLZ4Writer(w io.Writer) *lz4Writer {
+	lw := &lz4Writer{
+		buf:   make([]byte, 0, lz4BlockSize),
+		table: make([]int32, 1<<lz4HashLog),
+	}
+	lw.Reset(w)
+	return lw
+}
+
+// Reset discards any unwritten data and starts a new frame on w.
+func (lw *lz4Writer) Reset(w io.Writer) {
+	lw.w = w
+	lw.buf = lw.buf[:0]
+	lw.sum.reset()
+	lw.wroteHeader = false
+	lw.closed = false
+	lw.err = nil
+}
+
+func (lw *lz4Writer) writeHeader() {
+	if lw.wroteHeader {
+		return
+	}
+	lw.wroteHeader = true
+	header := make([]byte, 7)
+	binary.LittleEndian.PutUint32(header, lz4Magic)
+	header[4] = lz4Version | lz4FlagIndependent | lz4FlagContentSum
+	header[5] = 7 << 4
+	header[6] = lz4HeaderChecksum(header[4:6])
+	_, lw.err = lw.w.Write(header)
+}
+
+func (lw *lz4Writer) Write(p []byte) (int, error) {
+	if lw.closed {
+		return 0, errors.New("lz4: write after close")
+	}
+	lw.writeHeader()
+	written := 0
+	for len(p) > 0 && lw.err == nil {
+		n := copy(lw.buf[len(lw.buf):cap(lw.buf)], p)
+		lw.buf = lw.buf[:len(lw.buf)+n]
+		p = p[n:]
+		written += n
+		if len(lw.buf) == cap(lw.buf) {
+			lw.flushBlock()
+		}
+	}
+	return written, lw.err
+}
+
+// flushBlock writes the buffered data as a block, uncompressed if it does
+// not compress.
+func (lw *lz4Writer) flushBlock() {
+	if len(lw.buf) == 0 || lw.err != nil {
+		return
+	}
+	lw.sum.Write(lw.buf)
+	lw.out = lz4CompressBlock(append(lw.out[:0], 0, 0, 0, 0), lw.buf, lw.table)
+	size := uint32(len(lw.out) - 4)
+	if int(size) >= len(lw.buf) {
+		lw.out = append(lw.out[:4], lw.buf...)
+		size = uint32(len(lw.buf)) | lz4UncompressedBit
+	}
+	binary.LittleEndian.PutUint32(lw.out, size)
+	_, lw.err = lw.w.Write(lw.out)
+	lw.buf = lw.buf[:0]
+}
+
+// Close ends the frame. It does not close the underlying writer.
+func (lw *lz4Writer) Close() error {
+	if lw.closed {
+		return lw.err
+	}
+	lw.closed = true
+	lw.writeHeader()
+	lw.flushBlock()
+	if lw.err != nil {
+		return lw.err
+	}
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer[4:], lw.sum.Sum32())
+	_, lw.err = lw.w.Write(trailer)
+	return lw.err
+}
+
+// lz4CompressBlock appends the compressed form of src to dst, using table as
+// the hash table of previous positions.
+func lz4CompressBlock(dst, src []byte, table []int32) []byte {
+	for i := range table {
+		table[i] = 0
+	}
+	anchor := 0
+	if len(src) > lz4MatchLimit {
+		limit := len(src) - lz4MatchLimit
+		matchEnd := len(src) - lz4LastLiterals
+		for i := 0; i < limit; {
+			seq := binary.LittleEndian.Uint32(src[i:])
+			h := (seq * 2654435761) >> (32 - lz4HashLog)
+			ref := int(table[h]) - 1
+			table[h] = int32(i + 1)
+			if ref < 0 || i-ref > lz4MaxOffset || binary.LittleEndian.Uint32(src[ref:]) != seq {
+				// skip faster through data that doesn't compress
+				i += 1 + (i-anchor)>>6
+				continue
+			}
+			for i > anchor && ref > 0 && src[i-1] == src[ref-1] {
+				i--
+				ref--
+			}
+			length := lz4MinMatch
+			for i+length < matchEnd && src[i+length] == src[ref+length] {
+				length++
+			}
+			dst = lz4AppendSequence(dst, src[anchor:i], i-ref, length)
+			i += length
+			anchor = i
+		}
+	}
+	return lz4AppendSequence(dst, src[anchor:], 0, 0)
+}
+
+// lz4AppendSequence appends literals followed by a match, or just literals
+// if length is zero.
+func lz4AppendSequence(dst, literals []byte, offset, length int) []byte {
+	token := byte(15 << 4)
+	if len(literals) < 15 {
+		token = byte(len(literals) << 4)
+	}
+	matchLength := length - lz4MinMatch
+	if length > 0 {
+		if matchLength < 15 {
+			token |= byte(matchLength)
+		} else {
+			token |= 15
+		}
+	}
+	dst = append(dst, token)
+	if len(literals) >= 15 {
+		dst = lz4AppendLength(dst, len(literals)-15)
+	}
+	dst = append(dst, literals...)
+	if length == 0 {
+		return dst
+	}
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if matchLength >= 15 {
+		dst = lz4AppendLength(dst, matchLength-15)
+	}
+	return dst
+}
+
+func lz4AppendLength(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+// lz4DecompressBlock appends the decompressed form of src to dst. Matches
+// may refer back into the existing contents of dst, and the block may
+// decompress to at most max bytes.
+func lz4DecompressBlock(dst, src []byte, max int) ([]byte, error) {
+	start := len(dst)
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+		literals := int(token >> 4)
+		if literals == 15 {
+			n, next, err := lz4ReadLength(src, i)
+			if err != nil {
+				return nil, err
+			}
+			literals, i = literals+n, next
+		}
+		if literals > len(src)-i || len(dst)-start+literals > max {
+			return nil, errLZ4Corrupt
+		}
+		dst = append(dst, src[i:i+literals]...)
+		i += literals
+		if i == len(src) {
+			// the last sequence has no match
+			break
+		}
+
+		if len(src)-i < 2 {
+			return nil, errLZ4Corrupt
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		length := int(token & 15)
+		if length == 15 {
+			n, next, err := lz4ReadLength(src, i)
+			if err != nil {
+				return nil, err
+			}
+			length, i = length+n, next
+		}
+		length += lz4MinMatch
+		if offset == 0 || offset > len(dst) || len(dst)-start+length > max {
+			return nil, errLZ4Corrupt
+		}
+		pos := len(dst) - offset
+		if offset >= length {
+			dst = append(dst, dst[pos:pos+length]...)
+			continue
+		}
+		// the match overlaps the bytes it produces
+		for j := 0; j < length; j++ {
+			dst = append(dst, dst[pos+j])
+		}
+	}
+	return dst, nil
+}
+
+func lz4ReadLength(src []byte, i int) (int, int, error) {
+	n := 0
+	for {
+		if i >= len(src) {
+			return 0, 0, errLZ4Corrupt
+		}
+		b := src[i]
+		i++
+		n += int(b)
+		if b != 255 {
+			return n, i, nil
+		}
+	}
+}
+
+func lz4HeaderChecksum(descriptor []byte) byte {
+	var sum xxh32
+	sum.reset()
+	sum.Write(descriptor)
+	return byte(sum.Sum32() >> 8)
+}
+
+type lz4Reader struct {
+	r     io.Reader
+	frame bool // whether a frame has started and not ended
+	read  bool // whether any frame has been read
+
+	independent bool
+	blockSum    bool
+	contentSum  bool
+	blockMax    int
+	sum         xxh32
+
+	block []byte
+	buf   []byte // the window of earlier output, followed by out
+	out   []byte
+	err   error
+
+	// workers is the number of goroutines independent blocks are
+	// decompressed on. With more than one, up to that many blocks are read
+	// ahead into pending, and ended is set once the end of the frame has
+	// been read behind them.
+	workers int
+	pending []*lz4PendingBlock
+	ended   bool
+}
+
+// lz4PendingBlock is a block being decompressed by another goroutine.
+type lz4PendingBlock struct {
+	done chan struct{}
+	out  []byte
+	err  error
+}
+
+func newLZ4Reader(r io.Reader) *lz4Reader {
+	return &lz4Reader{r: r}
+}
+
+func (lr *lz4Reader) Read(p []byte) (int, error) {
+	for len(lr.out) == 0 {
+		if lr.err != nil {
+			return 0, lr.err
+		}
+		lr.err = lr.next()
+	}
+	n := copy(p, lr.out)
+	lr.out = lr.out[n:]
+	return n, nil
+}
+
+// Close releases the reader's buffers. It does not close the underlying reader.
+func (lr *lz4Reader) Close() error {
+	lr.buf, lr.block, lr.out, lr.pending = nil, nil, nil, nil
+	lr.err = errors.New("lz4: read after close")
+	return nil
+}
+
+// next reads the next frame header or block.
+func (lr *lz4Reader) next() error {
+	if !lr.frame {
+		return lr.readFrameHeader()
+	}
+	if lr.independent && lr.workers > 1 {
+		return lr.nextParallel()
+	}
+	block, uncompressed, end, err := lr.readBlock(lr.block)
+	if err != nil {
+		return err
+	}
+	if end {
+		return lr.endFrame()
+	}
+	lr.block = block
+
+	if lr.independent {
+		lr.buf = lr.buf[:0]
+	} else if len(lr.buf) > lz4WindowSize {
+		lr.buf = lr.buf[:copy(lr.buf, lr.buf[len(lr.buf)-lz4WindowSize:])]
+	}
+	start := len(lr.buf)
+	if uncompressed {
+		lr.buf = append(lr.buf, block...)
+	} else {
+		buf, err := lz4DecompressBlock(lr.buf, block, lr.blockMax)
+		if err != nil {
+			return err
+		}
+		lr.buf = buf
+	}
+	lr.out = lr.buf[start:]
+	lr.sum.Write(lr.out)
+	return nil
+}
+
+// nextParallel reads ahead up to workers independent blocks, decompressing
+// each on its own goroutine, and returns the output of the first.
+func (lr *lz4Reader) nextParallel() error {
+	for !lr.ended && len(lr.pending) < lr.workers {
+		block, uncompressed, end, err := lr.readBlock(nil)
+		if err != nil {
+			return err
+		}
+		if end {
+			lr.ended = true
+			break
+		}
+		pending := &lz4PendingBlock{done: make(chan struct{})}
+		lr.pending = append(lr.pending, pending)
+		go func(max int) {
+			defer close(pending.done)
+			if uncompressed {
+				pending.out = block
+				return
+			}
+			pending.out, pending.err = lz4DecompressBlock(make([]byte, 0, max), block, max)
+		}(lr.blockMax)
+	}
+	if len(lr.pending) == 0 {
+		lr.ended = false
+		return lr.endFrame()
+	}
+	pending := lr.pending[0]
+	<-pending.done
+	lr.pending = lr.pending[1:]
+	if pending.err != nil {
+		return pending.err
+	}
+	lr.out = pending.out
+	lr.sum.Write(lr.out)
+	return nil
+}
+
+// readBlock reads the next block of the frame into buf, checking its
+// checksum. It returns end at the end mark of the frame.
+func (lr *lz4Reader) readBlock(buf []byte) (block []byte, uncompressed, end bool, err error) {
+	var word [4]byte
+	if _, err := io.ReadFull(lr.r, word[:]); err != nil {
+		return nil, false, false, unexpected(err)
+	}
+	size := binary.LittleEndian.Uint32(word[:])
+	if size == 0 {
+		return nil, false, true, nil
+	}
+
+	uncompressed = size&lz4UncompressedBit != 0
+	size &^= lz4UncompressedBit
+	if int(size) > lr.blockMax {
+		return nil, false, false, errLZ4Corrupt
+	}
+	if cap(buf) < int(size) {
+		buf = make([]byte, size)
+	}
+	block = buf[:size]
+	if _, err := io.ReadFull(lr.r, block); err != nil {
+		return nil, false, false, unexpected(err)
+	}
+	if lr.blockSum {
+		if _, err := io.ReadFull(lr.r, word[:]); err != nil {
+			return nil, false, false, unexpected(err)
+		}
+		var sum xxh32
+		sum.reset()
+		sum.Write(block)
+		if binary.LittleEndian.Uint32(word[:]) != sum.Sum32() {
+			return nil, false, false, errors.New("lz4: block checksum mismatch")
+		}
+	}
+	return block, uncompressed, false, nil
+}
+
+// endFrame checks the content checksum that follows the end mark of a
+// frame.
+func (lr *lz4Reader) endFrame() error {
+	lr.frame = false
+	if !lr.contentSum {
+		return nil
+	}
+	var word [4]byte
+	if _, err := io.ReadFull(lr.r, word[:]); err != nil {
+		return unexpected(err)
+	}
+	if binary.LittleEndian.Uint32(word[:]) != lr.sum.Sum32() {
+		return errors.New("lz4: content checksum mismatch")
+	}
+	return nil
+}
+
+func (lr *lz4Reader) readFrameHeader() error {
+	var word [4]byte
+	if _, err := io.ReadFull(lr.r, word[:]); err == io.EOF && lr.read {
+		return io.EOF
+	} else if err != nil {
+		return unexpected(err)
+	}
+	magic := binary.LittleEndian.Uint32(word[:])
+	if magic&lz4SkippableMask == lz4SkippableMagic {
+		if _, err := io.ReadFull(lr.r, word[:]); err != nil {
+			return unexpected(err)
+		}
+		_, err := io.CopyN(ioutil.Discard, lr.r, int64(binary.LittleEndian.Uint32(word[:])))
+		return unexpected(err)
+	}
+	if magic != lz4Magic {
+		return errors.New("lz4: invalid header")
+	}
+
+	descriptor := make([]byte, 2, 10)
+	if _, err := io.ReadFull(lr.r, descriptor); err != nil {
+		return unexpected(err)
+	}
+	flags := descriptor[0]
+	if flags&0xc0 != lz4Version {
+		return fmt.Errorf("lz4: unsupported frame version %v", flags>>6)
+	}
+	if flags&lz4FlagDictID != 0 {
+		return errors.New("lz4: frames with dictionaries are not supported")
+	}
+	lr.blockMax = lz4BlockSizes[(descriptor[1]>>4)&7]
+	if lr.blockMax == 0 {
+		return errLZ4Corrupt
+	}
+	if flags&lz4FlagContentSize != 0 {
+		descriptor = descriptor[:10]
+		if _, err := io.ReadFull(lr.r, descriptor[2:]); err != nil {
+			return unexpected(err)
+		}
+	}
+	if _, err := io.ReadFull(lr.r, word[:1]); err != nil {
+		return unexpected(err)
+	}
+	if word[0] != lz4HeaderChecksum(descriptor) {
+		return errors.New("lz4: header checksum mismatch")
+	}
+
+	lr.independent = flags&lz4FlagIndependent != 0
+	lr.blockSum = flags&lz4FlagBlockSum != 0
+	lr.contentSum = flags&lz4FlagContentSum != 0
+	lr.sum.reset()
+	lr.buf = lr.buf[:0]
+	lr.frame, lr.read = true, true
+	return nil
+}
+
+// unexpected treats the end of input as an error, since the format says
+// more should follow.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package compression
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// frames written by the lz4 command line tool, version 1.9.4
+const (
+	// printf '' | lz4 -c
+	lz4EmptyFrame = "04224d186440a700000000055dcc02"
+	// lz4 -c, lz4 -c -BD -BX and lz4 -c --content-size of lz4Text
+	lz4TextFrame         = "04224d186440a7160000003f61626303000e642068656c6c6f060050656c6c6f0a000000003d33d504"
+	lz4TextBlockSumFrame = "04224d187440bd160000003f61626303000e642068656c6c6f060050656c6c6f0acd0e4553000000003d33d504"
+	lz4TextSizeFrame     = "04224d186c403700000000000000de160000003f61626303000e642068656c6c6f060050656c6c6f0a000000003d33d504"
+	lz4Text              = "abcabcabcabcabcabcabcabcabcabcabcabc hello hello hello\n"
+)
+
+func lz4Compress(data []byte) []byte {
+	var out bytes.Buffer
+	w := newLZ4Writer(&out)
+	_, err := w.Write(data)
+	So(err, ShouldBeNil)
+	So(w.Close(), ShouldBeNil)
+	return out.Bytes()
+}
+
+func lz4Decompress(frame []byte, workers int) ([]byte, error) {
+	r, err := Codec{Name: LZ4Name, Workers: workers}.NewReader(bytes.NewReader(frame))
+	So(err, ShouldBeNil)
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestLZ4(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	random := rand.New(rand.NewSource(1))
+	incompressible := make([]byte, 2*lz4BlockSize+1000)
+	random.Read(incompressible)
+	var text bytes.Buffer
+	for text.Len() < 2*lz4BlockSize+1000 {
+		fmt.Fprintf(&text, `{"_id":%v,"name":"user%v","tags":["a","b"]}`, random.Intn(100000), random.Intn(50))
+	}
+	compressible := text.Bytes()
+
+	Convey("Frames written by the lz4 tool should be read", t, func() {
+		for _, workers := range []int{0, 4} {
+			data, err := lz4Decompress(mustDecodeHex(lz4EmptyFrame), workers)
+			So(err, ShouldBeNil)
+			So(data, ShouldBeEmpty)
+			for _, frame := range []string{lz4TextFrame, lz4TextBlockSumFrame, lz4TextSizeFrame} {
+				data, err = lz4Decompress(mustDecodeHex(frame), workers)
+				So(err, ShouldBeNil)
+				So(string(data), ShouldEqual, lz4Text)
+			}
+		}
+	})
+
+	Convey("An empty input should be an empty frame with 4MB blocks", t, func() {
+		So(hex.EncodeToString(lz4Compress(nil)), ShouldEqual, "04224d186470b9"+"00000000"+"055dcc02")
+	})
+
+	Convey("Data should survive a round trip", t, func() {
+		inputs := map[string][]byte{
+			"short":           []byte("a"),
+			"text":            []byte(lz4Text),
+			"compressible":    compressible,
+			"incompressible":  incompressible,
+			"a repeated byte": bytes.Repeat([]byte{'x'}, lz4BlockSize+1),
+		}
+		for name, input := range inputs {
+			Convey(name, func() {
+				frame := lz4Compress(input)
+				if len(input) > 1000 && name != "incompressible" {
+					So(len(frame), ShouldBeLessThan, len(input)/2)
+				}
+				for _, workers := range []int{0, 4} {
+					data, err := lz4Decompress(frame, workers)
+					So(err, ShouldBeNil)
+					So(bytes.Equal(data, input), ShouldBeTrue)
+				}
+			})
+		}
+	})
+
+	Convey("Incompressible blocks should be stored uncompressed", t, func() {
+		frame := lz4Compress(incompressible)
+		So(len(frame), ShouldEqual, len(incompressible)+7+3*4+4+4)
+	})
+
+	Convey("Writes of any size should give the same frame", t, func() {
+		expected := lz4Compress(compressible)
+		var out bytes.Buffer
+		w := newLZ4Writer(&out)
+		for data := compressible; len(data) > 0; {
+			n := random.Intn(100000) + 1
+			if n > len(data) {
+				n = len(data)
+			}
+			_, err := w.Write(data[:n])
+			So(err, ShouldBeNil)
+			data = data[n:]
+		}
+		So(w.Close(), ShouldBeNil)
+		So(bytes.Equal(out.Bytes(), expected), ShouldBeTrue)
+		_, err := w.Write([]byte("more"))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Concatenated and skippable frames should be read as one stream", t, func() {
+		skippable := mustDecodeHex("502a4d1803000000616263")
+		stream := append(append(mustDecodeHex(lz4TextFrame), skippable...), lz4Compress([]byte("more"))...)
+		data, err := lz4Decompress(stream, 0)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, lz4Text+"more")
+	})
+
+	Convey("Corrupt frames should fail", t, func() {
+		corrupt := func(frame []byte, i int) []byte {
+			frame = append([]byte{}, frame...)
+			frame[i] ^= 0xff
+			return frame
+		}
+		frame := mustDecodeHex(lz4TextBlockSumFrame)
+		_, err := lz4Decompress(corrupt(frame, 6), 0)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "header checksum")
+		_, err = lz4Decompress(corrupt(frame, 12), 0)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "block checksum")
+		_, err = lz4Decompress(corrupt(frame, len(frame)-1), 0)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "content checksum")
+
+		frame = lz4Compress(compressible)
+		for _, workers := range []int{0, 4} {
+			_, err = lz4Decompress(corrupt(frame, len(frame)/2), workers)
+			So(err, ShouldNotBeNil)
+			_, err = lz4Decompress(frame[:len(frame)-10], workers)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
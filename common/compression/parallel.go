@@ -0,0 +1,168 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+// parallelBlockSize is how much input each gzip member written by a
+// parallelGzipWriter holds.
+const parallelBlockSize = 1 << 20
+
+// parallelBlock is a block of input being compressed, and its result.
+type parallelBlock struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// parallelGzipWriter compresses its input on several goroutines. The input is
+// cut into blocks that are compressed independently as gzip members, which
+// are written in order; readers of gzip streams decompress the concatenated
+// members as one stream. About twice as many blocks as there are workers are
+// held in memory at most.
+type parallelGzipWriter struct {
+	w       io.Writer
+	workers int
+	pool    sync.Pool
+
+	buf    []byte
+	queued bool
+	// queue holds the blocks in the order they are written, and is nil
+	// until the first block is queued
+	queue   chan *parallelBlock
+	sem     chan struct{}
+	written chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func newParallelGzipWriter(w io.Writer, level, workers int) (*parallelGzipWriter, error) {
+	// check the level the way gzip.NewWriterLevel does
+	if _, err := gzip.NewWriterLevel(nil, level); err != nil {
+		return nil, err
+	}
+	pw := &parallelGzipWriter{workers: workers}
+	pw.pool.New = func() interface{} {
+		gz, _ := gzip.NewWriterLevel(nil, level)
+		return gz
+	}
+	pw.Reset(w)
+	return pw, nil
+}
+
+// Reset discards the writer's state so that it writes a new stream to w. The
+// previous stream must have been closed.
+func (pw *parallelGzipWriter) Reset(w io.Writer) {
+	pw.w = w
+	pw.buf = make([]byte, 0, parallelBlockSize)
+	pw.queued = false
+	pw.queue = nil
+	pw.err = nil
+}
+
+// Write buffers p, compressing each block that fills up.
+func (pw *parallelGzipWriter) Write(p []byte) (int, error) {
+	if err := pw.getErr(); err != nil {
+		return 0, err
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(pw.buf[len(pw.buf):cap(pw.buf)], p)
+		pw.buf = pw.buf[:len(pw.buf)+n]
+		p = p[n:]
+		written += n
+		if len(pw.buf) == cap(pw.buf) {
+			pw.queueBlock()
+		}
+	}
+	return written, pw.getErr()
+}
+
+// Close compresses the rest of the input and waits for every block to be
+// written. It does not close the underlying writer.
+func (pw *parallelGzipWriter) Close() error {
+	// an empty stream is still written as one gzip member
+	if len(pw.buf) > 0 || !pw.queued {
+		pw.queueBlock()
+	}
+	close(pw.queue)
+	<-pw.written
+	pw.queue = nil
+	return pw.getErr()
+}
+
+// queueBlock starts compressing the buffered input and queues it to be
+// written.
+func (pw *parallelGzipWriter) queueBlock() {
+	if pw.queue == nil {
+		pw.queue = make(chan *parallelBlock, pw.workers)
+		pw.sem = make(chan struct{}, pw.workers)
+		pw.written = make(chan struct{})
+		go pw.writeBlocks(pw.w, pw.queue, pw.written)
+	}
+	block := &parallelBlock{done: make(chan struct{})}
+	input := pw.buf
+	pw.buf = make([]byte, 0, parallelBlockSize)
+	pw.queued = true
+
+	pw.sem <- struct{}{}
+	go func() {
+		defer func() { <-pw.sem }()
+		block.data, block.err = pw.compress(input)
+		close(block.done)
+	}()
+	pw.queue <- block
+}
+
+// compress returns input as a gzip member.
+func (pw *parallelGzipWriter) compress(input []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.Grow(len(input)/2 + 64)
+	gz := pw.pool.Get().(*gzip.Writer)
+	defer pw.pool.Put(gz)
+	gz.Reset(&out)
+	if _, err := gz.Write(input); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// writeBlocks writes each queued block once it is compressed, until the
+// queue is closed. Blocks after an error are discarded.
+func (pw *parallelGzipWriter) writeBlocks(w io.Writer, queue <-chan *parallelBlock, written chan<- struct{}) {
+	defer close(written)
+	for block := range queue {
+		<-block.done
+		if pw.getErr() != nil {
+			continue
+		}
+		err := block.err
+		if err == nil {
+			_, err = w.Write(block.data)
+		}
+		if err != nil {
+			pw.mu.Lock()
+			pw.err = err
+			pw.mu.Unlock()
+		}
+	}
+}
+
+func (pw *parallelGzipWriter) getErr() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.err
+}
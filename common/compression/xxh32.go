@@ -0,0 +1,90 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package compression
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	xxhPrime1 uint32 = 2654435761
+	xxhPrime2 uint32 = 2246822519
+	xxhPrime3 uint32 = 3266489917
+	xxhPrime4 uint32 = 668265263
+	xxhPrime5 uint32 = 374761393
+)
+
+// xxh32 computes the 32-bit xxHash, with a seed of zero, that LZ4 frames use
+// for their checksums. Call reset before use.
+type xxh32 struct {
+	v     [4]uint32
+	total int
+	mem   [16]byte
+	n     int
+}
+
+func (x *xxh32) reset() {
+	var seed uint32
+	x.v = [4]uint32{seed + xxhPrime1 + xxhPrime2, seed + xxhPrime2, seed, seed - xxhPrime1}
+	x.total = 0
+	x.n = 0
+}
+
+func xxhRound(v, input uint32) uint32 {
+	return bits.RotateLeft32(v+input*xxhPrime2, 13) * xxhPrime1
+}
+
+func (x *xxh32) stripe(b []byte) {
+	for i := range x.v {
+		x.v[i] = xxhRound(x.v[i], binary.LittleEndian.Uint32(b[4*i:]))
+	}
+}
+
+func (x *xxh32) Write(b []byte) {
+	x.total += len(b)
+	if x.n > 0 {
+		n := copy(x.mem[x.n:], b)
+		x.n += n
+		b = b[n:]
+		if x.n < len(x.mem) {
+			return
+		}
+		x.stripe(x.mem[:])
+		x.n = 0
+	}
+	for ; len(b) >= len(x.mem); b = b[len(x.mem):] {
+		x.stripe(b)
+	}
+	x.n = copy(x.mem[:], b)
+}
+
+func (x *xxh32) Sum32() uint32 {
+	var h uint32
+	if x.total >= len(x.mem) {
+		h = bits.RotateLeft32(x.v[0], 1) + bits.RotateLeft32(x.v[1], 7) +
+			bits.RotateLeft32(x.v[2], 12) + bits.RotateLeft32(x.v[3], 18)
+	} else {
+		h = xxhPrime5
+	}
+	h += uint32(x.total)
+
+	tail := x.mem[:x.n]
+	for ; len(tail) >= 4; tail = tail[4:] {
+		h = bits.RotateLeft32(h+binary.LittleEndian.Uint32(tail)*xxhPrime3, 17) * xxhPrime4
+	}
+	for _, b := range tail {
+		h = bits.RotateLeft32(h+uint32(b)*xxhPrime5, 11) * xxhPrime1
+	}
+
+	h ^= h >> 15
+	h *= xxhPrime2
+	h ^= h >> 13
+	h *= xxhPrime3
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,59 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package compression
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func xxh32Sum(b []byte) uint32 {
+	var x xxh32
+	x.reset()
+	x.Write(b)
+	return x.Sum32()
+}
+
+func TestXXH32(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Sums should match the reference implementation", t, func() {
+		So(xxh32Sum(nil), ShouldEqual, uint32(0x02cc5d05))
+		So(xxh32Sum([]byte("abc")), ShouldEqual, uint32(0x32d153ff))
+		So(xxh32Sum([]byte("Nobody inspects the spammish repetition")), ShouldEqual, uint32(0xe2293b2f))
+	})
+
+	Convey("Writing in pieces should give the sum of the whole input", t, func() {
+		data := make([]byte, 1000)
+		rand.New(rand.NewSource(1)).Read(data)
+		expected := xxh32Sum(data)
+		for _, size := range []int{1, 3, 15, 16, 17, 100} {
+			var x xxh32
+			x.reset()
+			for i := 0; i < len(data); i += size {
+				end := i + size
+				if end > len(data) {
+					end = len(data)
+				}
+				x.Write(data[i:end])
+			}
+			So(x.Sum32(), ShouldEqual, expected)
+		}
+	})
+
+	Convey("Resetting should start a new sum", t, func() {
+		var x xxh32
+		x.reset()
+		x.Write([]byte("something else"))
+		x.reset()
+		x.Write([]byte("abc"))
+		So(x.Sum32(), ShouldEqual, uint32(0x32d153ff))
+	})
+}
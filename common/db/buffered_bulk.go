@@ -0,0 +1,304 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BufferedBulkInserter implements a bufio.Writer-like design for queuing up
+// documents and inserting them in bulk when the given doc limit (or max
+// message size) is reached. Must be flushed at the end to ensure that all
+// documents are written.
+type BufferedBulkInserter struct {
+	collection    *mongo.Collection
+	writeModels   []mongo.WriteModel
+	docLimit      int
+	docCount      int
+	bulkWriteOpts *options.BulkWriteOptions
+	upsert        bool
+	onWriteError  func(mongo.BulkWriteError)
+	retries       int
+	retryBackoff  time.Duration
+}
+
+// MaxRetryBackoff is the longest a BufferedBulkInserter waits before
+// retrying a bulk write.
+const MaxRetryBackoff = 30 * time.Second
+
+// transientErrorCodes are the codes of the errors returned while a replica
+// set elects a new primary, or a node shuts down or can't be reached.
+var transientErrorCodes = map[int]bool{
+	6:     true, // HostUnreachable
+	7:     true, // HostNotFound
+	89:    true, // NetworkTimeout
+	91:    true, // ShutdownInProgress
+	189:   true, // PrimarySteppedDown
+	9001:  true, // SocketException
+	10107: true, // NotWritablePrimary
+	11600: true, // InterruptedAtShutdown
+	11602: true, // InterruptedDueToReplStateChange
+	13435: true, // NotPrimaryNoSecondaryOk
+	13436: true, // NotPrimaryOrSecondary
+}
+
+func newBufferedBulkInserter(collection *mongo.Collection, docLimit int, ordered bool) *BufferedBulkInserter {
+	bb := &BufferedBulkInserter{
+		collection:    collection,
+		bulkWriteOpts: options.BulkWrite().SetOrdered(ordered),
+		docLimit:      docLimit,
+		writeModels:   make([]mongo.WriteModel, 0, docLimit),
+	}
+	return bb
+}
+
+// NewOrderedBufferedBulkInserter returns an initialized BufferedBulkInserter for performing ordered bulk writes.
+func NewOrderedBufferedBulkInserter(collection *mongo.Collection, docLimit int) *BufferedBulkInserter {
+	return newBufferedBulkInserter(collection, docLimit, true)
+}
+
+// NewOrderedBufferedBulkInserter returns an initialized BufferedBulkInserter for performing unordered bulk writes.
+func NewUnorderedBufferedBulkInserter(collection *mongo.Collection, docLimit int) *BufferedBulkInserter {
+	return newBufferedBulkInserter(collection, docLimit, false)
+}
+
+func (bb *BufferedBulkInserter) SetOrdered(ordered bool) *BufferedBulkInserter {
+	bb.bulkWriteOpts.SetOrdered(ordered)
+	return bb
+}
+
+func (bb *BufferedBulkInserter) SetBypassDocumentValidation(bypass bool) *BufferedBulkInserter {
+	bb.bulkWriteOpts.SetBypassDocumentValidation(bypass)
+	return bb
+}
+
+func (bb *BufferedBulkInserter) SetUpsert(upsert bool) *BufferedBulkInserter {
+	bb.upsert = upsert
+	return bb
+}
+
+// SetWriteErrorHandler sets a function that is called with each write error
+// of a bulk write, whose Request is the write that failed.
+func (bb *BufferedBulkInserter) SetWriteErrorHandler(handler func(mongo.BulkWriteError)) *BufferedBulkInserter {
+	bb.onWriteError = handler
+	return bb
+}
+
+// SetRetries sets the number of times a bulk write that fails with a
+// transient error is retried, waiting backoff before the first retry and
+// twice as long before each of the others, up to MaxRetryBackoff.
+func (bb *BufferedBulkInserter) SetRetries(retries int, backoff time.Duration) *BufferedBulkInserter {
+	bb.retries = retries
+	bb.retryBackoff = backoff
+	return bb
+}
+
+// IsTransientError returns whether err is a network error or an error of a
+// primary stepping down or a node shutting down, after which a write can be
+// retried.
+func IsTransientError(err error) bool {
+	switch mongoErr := err.(type) {
+	case nil:
+		return false
+	case mongo.CommandError:
+		return mongoErr.HasErrorLabel("NetworkError") || mongoErr.HasErrorLabel("RetryableWriteError") ||
+			transientErrorCodes[int(mongoErr.Code)]
+	case mongo.BulkWriteException:
+		if mongoErr.HasErrorLabel("NetworkError") || mongoErr.HasErrorLabel("RetryableWriteError") {
+			return true
+		}
+		if mongoErr.WriteConcernError != nil && transientErrorCodes[mongoErr.WriteConcernError.Code] {
+			return true
+		}
+		for _, writeErr := range mongoErr.WriteErrors {
+			if transientErrorCodes[writeErr.Code] {
+				return true
+			}
+		}
+		return false
+	}
+	// no primary can be selected while one is elected
+	return strings.HasPrefix(err.Error(), "server selection error")
+}
+
+// throw away the old bulk and init a new one
+func (bb *BufferedBulkInserter) resetBulk() {
+	bb.writeModels = bb.writeModels[:0]
+	bb.docCount = 0
+}
+
+// Insert adds a document to the buffer for bulk insertion. If the buffer becomes full, the bulk write is performed, returning
+// any error that occurs.
+func (bb *BufferedBulkInserter) Insert(doc interface{}) (*mongo.BulkWriteResult, error) {
+	rawBytes, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("bson encoding error: %v", err)
+	}
+
+	return bb.InsertRaw(rawBytes)
+}
+
+// Update adds a document to the buffer for bulk update. If the buffer becomes full, the bulk write is performed, returning
+// any error that occurs.
+func (bb *BufferedBulkInserter) Update(selector, update bson.D) (*mongo.BulkWriteResult, error) {
+	return bb.addModel(mongo.NewUpdateOneModel().SetFilter(selector).SetUpdate(update).SetUpsert(bb.upsert))
+}
+
+// Replace adds a document to the buffer for bulk replacement. If the buffer becomes full, the bulk write is performed, returning
+// any error that occurs.
+func (bb *BufferedBulkInserter) Replace(selector, replacement bson.D) (*mongo.BulkWriteResult, error) {
+	return bb.addModel(mongo.NewReplaceOneModel().SetFilter(selector).SetReplacement(replacement).SetUpsert(bb.upsert))
+}
+
+// InsertRaw adds a document, represented as raw bson bytes, to the buffer for bulk insertion. If the buffer becomes full,
+// the bulk write is performed, returning any error that occurs.
+func (bb *BufferedBulkInserter) InsertRaw(rawBytes []byte) (*mongo.BulkWriteResult, error) {
+	return bb.addModel(mongo.NewInsertOneModel().SetDocument(rawBytes))
+}
+
+// Delete adds a document to the buffer for bulk removal. If the buffer becomes full, the bulk delete is performed, returning
+// any error that occurs.
+func (bb *BufferedBulkInserter) Delete(selector, replacement bson.D) (*mongo.BulkWriteResult, error) {
+	return bb.addModel(mongo.NewDeleteOneModel().SetFilter(selector))
+}
+
+// addModel adds a WriteModel to the buffer. If the buffer becomes full, the bulk write is performed, returning any error
+// that occurs.
+func (bb *BufferedBulkInserter) addModel(model mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+	bb.docCount++
+	bb.writeModels = append(bb.writeModels, model)
+
+	if bb.docCount >= bb.docLimit {
+		return bb.Flush()
+	}
+
+	return nil, nil
+}
+
+// Flush writes all buffered documents in one bulk write and then resets the buffer.
+func (bb *BufferedBulkInserter) Flush() (*mongo.BulkWriteResult, error) {
+	if bb.docCount == 0 {
+		return nil, nil
+	}
+
+	defer bb.resetBulk()
+	result, err := bb.collection.BulkWrite(context.Background(), bb.writeModels, bb.bulkWriteOpts)
+	// written records the models that a failed attempt may have written
+	written := map[int]bool{}
+	backoff := bb.retryBackoff
+	for attempt := 1; attempt <= bb.retries && IsTransientError(err); attempt++ {
+		log.Logvf(log.Always, "retrying a bulk write to %v in %v (attempt %v of %v) after error: %v",
+			bb.collection.Name(), backoff, attempt, bb.retries, err)
+		if attempt == 1 {
+			markWritten(written, 0, len(bb.writeModels), err, bb.ordered())
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > MaxRetryBackoff {
+			backoff = MaxRetryBackoff
+		}
+		result, err = bb.replay(bb.writeModels, written)
+	}
+	if bwe, ok := err.(mongo.BulkWriteException); ok && bb.onWriteError != nil {
+		for _, writeErr := range bwe.WriteErrors {
+			bb.onWriteError(writeErr)
+		}
+	}
+	return result, err
+}
+
+func (bb *BufferedBulkInserter) ordered() bool {
+	return bb.bulkWriteOpts.Ordered == nil || *bb.bulkWriteOpts.Ordered
+}
+
+// markWritten records in written which of the n models at offset may have
+// been written by a bulk write that failed with err: every model, unless the
+// server reported which writes failed, in which case the others were
+// written, up to the first failure of an ordered write.
+func markWritten(written map[int]bool, offset, n int, err error, ordered bool) {
+	bwe, ok := err.(mongo.BulkWriteException)
+	if !ok {
+		for i := 0; i < n; i++ {
+			written[offset+i] = true
+		}
+		return
+	}
+	failed := map[int]bool{}
+	end := n
+	for _, writeErr := range bwe.WriteErrors {
+		failed[writeErr.Index] = true
+		if ordered && writeErr.Index < end {
+			end = writeErr.Index
+		}
+	}
+	for i := 0; i < end; i++ {
+		if !failed[i] {
+			written[offset+i] = true
+		}
+	}
+}
+
+// replay writes models again after a transient error, and returns the result
+// of writing them, as if they had been written once. Models that an earlier
+// attempt may have written are in written, and the duplicate key errors of
+// those that are inserts are counted as inserted documents, continuing an
+// ordered write after them. Other duplicate key errors are write errors, for
+// documents that existed before the write.
+func (bb *BufferedBulkInserter) replay(models []mongo.WriteModel, written map[int]bool) (*mongo.BulkWriteResult, error) {
+	total := &mongo.BulkWriteResult{}
+	ordered := bb.ordered()
+	for offset := 0; offset < len(models); {
+		result, err := bb.collection.BulkWrite(context.Background(), models[offset:], bb.bulkWriteOpts)
+		if result != nil {
+			total.InsertedCount += result.InsertedCount
+			total.MatchedCount += result.MatchedCount
+			total.ModifiedCount += result.ModifiedCount
+			total.DeletedCount += result.DeletedCount
+			total.UpsertedCount += result.UpsertedCount
+		}
+		bwe, ok := err.(mongo.BulkWriteException)
+		if !ok {
+			if err != nil {
+				markWritten(written, offset, len(models)-offset, err, ordered)
+			}
+			return total, err
+		}
+		if IsTransientError(bwe) {
+			markWritten(written, offset, len(models)-offset, err, ordered)
+		}
+		var writeErrors []mongo.BulkWriteError
+		last := -1
+		for _, writeErr := range bwe.WriteErrors {
+			_, insert := writeErr.Request.(*mongo.InsertOneModel)
+			if insert && writeErr.Code == ErrDuplicateKeyCode && written[offset+writeErr.Index] {
+				total.InsertedCount++
+				last = writeErr.Index
+				continue
+			}
+			// the index is of the models being replayed
+			writeErr.Index += offset
+			writeErrors = append(writeErrors, writeErr)
+		}
+		bwe.WriteErrors = writeErrors
+		if len(writeErrors) > 0 || bwe.WriteConcernError != nil || IsTransientError(bwe) {
+			return total, bwe
+		}
+		if !ordered || last < 0 {
+			return total, nil
+		}
+		// an ordered write stops at the duplicate
+		offset += last + 1
+	}
+	return total, nil
+}
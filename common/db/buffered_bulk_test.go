@@ -0,0 +1,61 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestMarkWritten(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	writeErrors := func(codes map[int]int) mongo.BulkWriteException {
+		bwe := mongo.BulkWriteException{}
+		for index, code := range codes {
+			bwe.WriteErrors = append(bwe.WriteErrors, mongo.BulkWriteError{
+				WriteError: mongo.WriteError{Index: index, Code: code},
+			})
+		}
+		return bwe
+	}
+
+	Convey("When a bulk write fails", t, func() {
+		written := map[int]bool{}
+
+		Convey("without a server response, every model may have been written", func() {
+			markWritten(written, 2, 3, errors.New("connection reset"), true)
+			So(written, ShouldResemble, map[int]bool{2: true, 3: true, 4: true})
+		})
+
+		Convey("an ordered write stops at its first failed write", func() {
+			markWritten(written, 0, 5, writeErrors(map[int]int{3: 10107, 1: ErrDuplicateKeyCode}), true)
+			So(written, ShouldResemble, map[int]bool{0: true})
+		})
+
+		Convey("an unordered write writes every model that didn't fail", func() {
+			markWritten(written, 10, 4, writeErrors(map[int]int{1: ErrDuplicateKeyCode, 2: 10107}), false)
+			So(written, ShouldResemble, map[int]bool{10: true, 13: true})
+		})
+
+		Convey("a write concern error may follow every write", func() {
+			bwe := mongo.BulkWriteException{WriteConcernError: &mongo.WriteConcernError{Code: 91}}
+			markWritten(written, 0, 2, bwe, true)
+			So(written, ShouldResemble, map[int]bool{0: true, 1: true})
+		})
+
+		Convey("models written by earlier attempts stay written", func() {
+			written[0] = true
+			markWritten(written, 1, 2, writeErrors(map[int]int{0: 10107}), true)
+			So(written, ShouldResemble, map[int]bool{0: true})
+		})
+	})
+}
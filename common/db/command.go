@@ -10,7 +10,7 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/mongodb/mongo-tools-common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	mopt "go.mongodb.org/mongo-driver/mongo/options"
@@ -21,9 +21,10 @@ import (
 	"sync"
 	"time"
 
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/options"
-	"github.com/mongodb/mongo-tools-common/password"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/password"
+	"github.com/mongodb/mongo-tools/common/tracing"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	mopt "go.mongodb.org/mongo-driver/mongo/options"
@@ -80,6 +81,12 @@ const (
 	continueThroughErrorFormat = "continuing through error: %v"
 )
 
+var networkLog = log.Component("network")
+
+// ignoredErrorLimiter keeps runs that continue through many ignorable errors,
+// such as duplicate keys, from flooding the log with repeated messages.
+var ignoredErrorLimiter = log.NewLimiter(0, time.Minute)
+
 // Used to manage database sessions
 type SessionProvider struct {
 	sync.Mutex
@@ -117,7 +124,7 @@ func (sp *SessionProvider) DB(name string) *mongo.Database {
 }
 
 // NewSessionProvider constructs a session provider, including a connected client.
-func NewSessionProvider(opts options.ToolOptions) (*SessionProvider, error) {
+func NewSessionProvider(opts options.ToolOptions) (provider *SessionProvider, err error) {
 	// finalize auth options, filling in missing passwords
 	if opts.Auth.ShouldAskForPassword() {
 		pass, err := password.Prompt()
@@ -125,20 +132,27 @@ func NewSessionProvider(opts options.ToolOptions) (*SessionProvider, error) {
 			return nil, fmt.Errorf("error reading password: %v", err)
 		}
 		opts.Auth.Password = pass
+		log.AddSecret(pass)
 	}
 
+	ctx, span := tracing.Start(context.Background(), "connect", "hosts", strings.Join(opts.URI.GetConnectionAddrs(), ","))
+	defer func() { span.End(err) }()
+	connectLog := networkLog.WithFields(span.LogFields()...)
+
 	client, err := configureClient(opts)
 	if err != nil {
 		return nil, fmt.Errorf("error configuring the connector: %v", err)
 	}
-	err = client.Connect(context.Background())
+	connectLog.Logvf(log.DebugLow, "connecting to %v", strings.Join(opts.URI.GetConnectionAddrs(), ","))
+	err = client.Connect(ctx)
 	if err != nil {
 		return nil, err
 	}
-	err = client.Ping(context.Background(), nil)
+	err = client.Ping(ctx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("could not connect to server: %v", err)
 	}
+	connectLog.Logv(log.DebugLow, "connected to server")
 
 	// create the provider
 	return &SessionProvider{client: client}, nil
@@ -478,10 +492,10 @@ func FilterError(stopOnError bool, err error) error {
 		// Just log the error but don't propagate it.
 		if bwe, ok := err.(mongo.BulkWriteException); ok {
 			for _, be := range bwe.WriteErrors {
-				log.Logvf(log.Always, continueThroughErrorFormat, be.Message)
+				ignoredErrorLimiter.Logvf(fmt.Sprintf("write error code %v", be.Code), log.Always, continueThroughErrorFormat, be.Message)
 			}
 		} else {
-			log.Logvf(log.Always, continueThroughErrorFormat, err)
+			ignoredErrorLimiter.Logvf("ignored error", log.Always, continueThroughErrorFormat, err)
 		}
 		return nil
 	}
@@ -489,6 +503,19 @@ func FilterError(stopOnError bool, err error) error {
 	return err
 }
 
+// SetIgnoredErrorLimit limits the messages about ignored errors to the given
+// number per minute for each error code, summarizing the rest. Zero, the
+// default, logs all of them. It must be called before any writes.
+func SetIgnoredErrorLimit(perMinute int) {
+	ignoredErrorLimiter = log.NewLimiter(perMinute, time.Minute)
+}
+
+// FlushIgnoredErrors writes summaries of any ignored errors whose log messages
+// were collapsed or rate limited.
+func FlushIgnoredErrors() {
+	ignoredErrorLimiter.Flush()
+}
+
 // Returns whether the tools can continue when encountering the given error.
 // Currently, only DuplicateKeyErrors are ignorable.
 func CanIgnoreError(err error) bool {
@@ -12,12 +12,16 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools/common/log"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// TimeseriesBucketsPrefix prefixes the name of the collection that holds the
+// buckets of a time-series collection.
+const TimeseriesBucketsPrefix = "system.buckets."
+
 type CollectionInfo struct {
 	Name    string `bson:"name"`
 	Type    string `bson:"type"`
@@ -2,7 +2,7 @@ package db
 
 import (
 	"fmt"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/util"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
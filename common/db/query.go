@@ -0,0 +1,152 @@
+package db
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopt "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeferredQuery represents a deferred query
+type DeferredQuery struct {
+	Coll      *mongo.Collection
+	Filter    interface{}
+	Hint      interface{}
+	Sort      interface{}
+	Skip      int64
+	LogReplay bool
+	// Min and Max bound the query to a range of the hinted index, with Min
+	// inclusive and Max exclusive.
+	Min interface{}
+	Max interface{}
+	// AtClusterTime, if set, reads with snapshot read concern at the given
+	// cluster time.
+	AtClusterTime *primitive.Timestamp
+	// Pipeline, if set, runs the query as an aggregation, with these stages
+	// applied to the documents the filter matches.
+	Pipeline bson.A
+	// BatchSize, if set, is the number of documents the server returns in
+	// each batch.
+	BatchSize int32
+}
+
+// EstimatedDocumentCount issues a count command.
+func (q *DeferredQuery) EstimatedDocumentCount() (int, error) {
+	opt := mopt.EstimatedDocumentCount()
+	c, err := q.Coll.EstimatedDocumentCount(nil, opt)
+	return int(c), err
+}
+
+// Iter executes a find query and returns a cursor.
+func (q *DeferredQuery) Iter() (*mongo.Cursor, error) {
+	if q.Pipeline != nil {
+		return q.aggregateIter()
+	}
+	if q.AtClusterTime != nil {
+		return q.snapshotIter()
+	}
+	opts := mopt.Find()
+	if q.Hint != nil {
+		opts.SetHint(q.Hint)
+	}
+	if q.Sort != nil {
+		opts.SetSort(q.Sort)
+	}
+	if q.Min != nil {
+		opts.SetMin(q.Min)
+	}
+	if q.Max != nil {
+		opts.SetMax(q.Max)
+	}
+	if q.Skip > 0 {
+		opts.SetSkip(q.Skip)
+	}
+	if q.LogReplay {
+		opts.SetOplogReplay(true)
+	}
+	if q.BatchSize > 0 {
+		opts.SetBatchSize(q.BatchSize)
+	}
+	filter := q.Filter
+	if filter == nil {
+		filter = bson.D{}
+	}
+	return q.Coll.Find(nil, filter, opts)
+}
+
+// snapshotIter runs the query as a find command, since the driver's read
+// concern options can't specify atClusterTime.
+func (q *DeferredQuery) snapshotIter() (*mongo.Cursor, error) {
+	filter := q.Filter
+	if filter == nil {
+		filter = bson.D{}
+	}
+	cmd := bson.D{{"find", q.Coll.Name()}, {"filter", filter}}
+	if q.Hint != nil {
+		cmd = append(cmd, bson.E{"hint", q.Hint})
+	}
+	if q.Sort != nil {
+		cmd = append(cmd, bson.E{"sort", q.Sort})
+	}
+	if q.Min != nil {
+		cmd = append(cmd, bson.E{"min", q.Min})
+	}
+	if q.Max != nil {
+		cmd = append(cmd, bson.E{"max", q.Max})
+	}
+	if q.Skip > 0 {
+		cmd = append(cmd, bson.E{"skip", q.Skip})
+	}
+	cmd = append(cmd, bson.E{"readConcern", bson.D{
+		{"level", "snapshot"},
+		{"atClusterTime", *q.AtClusterTime},
+	}})
+	return q.Coll.Database().RunCommandCursor(nil, cmd)
+}
+
+// aggregateIter runs the query as an aggregation of its pipeline. The filter
+// and sort come before the pipeline's stages, and the skip after them, so that
+// it skips results already read.
+func (q *DeferredQuery) aggregateIter() (*mongo.Cursor, error) {
+	if q.Min != nil || q.Max != nil {
+		return nil, fmt.Errorf("index bounds cannot be used with an aggregation pipeline")
+	}
+	pipeline := bson.A{}
+	if q.Filter != nil {
+		pipeline = append(pipeline, bson.D{{"$match", q.Filter}})
+	}
+	if q.Sort != nil {
+		pipeline = append(pipeline, bson.D{{"$sort", q.Sort}})
+	}
+	pipeline = append(pipeline, q.Pipeline...)
+	if q.Skip > 0 {
+		pipeline = append(pipeline, bson.D{{"$skip", q.Skip}})
+	}
+
+	if q.AtClusterTime != nil {
+		cmd := bson.D{
+			{"aggregate", q.Coll.Name()},
+			{"pipeline", pipeline},
+			{"cursor", bson.D{}},
+			{"allowDiskUse", true},
+		}
+		if q.Hint != nil {
+			cmd = append(cmd, bson.E{"hint", q.Hint})
+		}
+		cmd = append(cmd, bson.E{"readConcern", bson.D{
+			{"level", "snapshot"},
+			{"atClusterTime", *q.AtClusterTime},
+		}})
+		return q.Coll.Database().RunCommandCursor(nil, cmd)
+	}
+	opts := mopt.Aggregate().SetAllowDiskUse(true)
+	if q.Hint != nil {
+		opts.SetHint(q.Hint)
+	}
+	if q.BatchSize > 0 {
+		opts.SetBatchSize(q.BatchSize)
+	}
+	return q.Coll.Aggregate(nil, pipeline, opts)
+}
@@ -10,7 +10,7 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/mongodb/mongo-tools-common/json"
+	"github.com/mongodb/mongo-tools/common/json"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.mongodb.org/mongo-driver/tag"
 	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
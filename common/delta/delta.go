@@ -0,0 +1,185 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+// Package delta encodes a stream as the differences from a base file, the way
+// rsync does: the base is cut into blocks that are found in the stream by a
+// rolling checksum, and the stream is written as copies of those blocks and
+// literal data between them. A delta is applied to the same base to
+// reconstruct the stream, and records checksums of both to detect a wrong or
+// changed base.
+//
+// A delta starts with Magic, a version byte, the block size as a uint32, the
+// size of the base as a uint64 and the SHA-256 of the base. It is followed by
+// operations, each a byte naming it:
+//
+//	copy:    uint64 index of the first block, uint32 number of blocks
+//	literal: uint32 length, then that many bytes
+//	end:     the SHA-256 of the stream
+//
+// Integers are little-endian.
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Magic starts every delta.
+var Magic = []byte("MDBDELTA")
+
+// DefaultBlockSize is the size of the blocks of the base that are matched.
+const DefaultBlockSize = 16 << 10
+
+const (
+	formatVersion = 1
+
+	opCopy    = 1
+	opLiteral = 2
+	opEnd     = 3
+
+	// maxLiteral is the most data written in one literal operation, which
+	// bounds how much unmatched data is buffered
+	maxLiteral = 1 << 20
+)
+
+// IsDelta returns whether data, the start of a stream, is a delta.
+func IsDelta(data []byte) bool {
+	return bytes.HasPrefix(data, Magic)
+}
+
+// header is the start of a delta.
+type header struct {
+	BlockSize uint32
+	BaseSize  uint64
+	BaseHash  [sha256.Size]byte
+}
+
+func (h *header) write(w io.Writer) error {
+	if _, err := w.Write(Magic); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{formatVersion}); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, h)
+}
+
+func readHeader(r io.Reader) (*header, error) {
+	start := make([]byte, len(Magic)+1)
+	if _, err := io.ReadFull(r, start); err != nil {
+		return nil, fmt.Errorf("error reading delta header: %v", err)
+	}
+	if !IsDelta(start) {
+		return nil, fmt.Errorf("not a delta")
+	}
+	if version := start[len(Magic)]; version != formatVersion {
+		return nil, fmt.Errorf("unsupported delta format version %v", version)
+	}
+	h := &header{}
+	if err := binary.Read(r, binary.LittleEndian, h); err != nil {
+		return nil, fmt.Errorf("error reading delta header: %v", err)
+	}
+	if h.BlockSize == 0 {
+		return nil, fmt.Errorf("invalid delta block size 0")
+	}
+	return h, nil
+}
+
+// baseBlock is a block of the base, by its index.
+type baseBlock struct {
+	index  uint64
+	strong [sha256.Size]byte
+}
+
+// Signature describes the blocks of a base, for a Writer to find them.
+type Signature struct {
+	header
+	// blocks holds the blocks of the base by their weak checksum
+	blocks map[uint32][]baseBlock
+}
+
+// NewSignature reads a base and returns its signature with blocks of the
+// given size. A partial block at the end of the base is never matched.
+func NewSignature(base io.Reader, blockSize int) (*Signature, error) {
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("invalid delta block size %v", blockSize)
+	}
+	sig := &Signature{blocks: map[uint32][]baseBlock{}}
+	sig.BlockSize = uint32(blockSize)
+	hash := sha256.New()
+	r := bufio.NewReaderSize(io.TeeReader(base, hash), blockSize)
+	block := make([]byte, blockSize)
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(r, block)
+		sig.BaseSize += uint64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		weak := newChecksum(block).sum()
+		sig.blocks[weak] = append(sig.blocks[weak], baseBlock{index: index, strong: sha256.Sum256(block)})
+	}
+	copy(sig.BaseHash[:], hash.Sum(nil))
+	return sig, nil
+}
+
+// Blocks returns the number of blocks that can be matched.
+func (sig *Signature) Blocks() int {
+	n := 0
+	for _, blocks := range sig.blocks {
+		n += len(blocks)
+	}
+	return n
+}
+
+// match returns the index of a block of the base holding data, whose weak
+// checksum is weak.
+func (sig *Signature) match(weak uint32, data []byte) (uint64, bool) {
+	candidates, ok := sig.blocks[weak]
+	if !ok {
+		return 0, false
+	}
+	strong := sha256.Sum256(data)
+	for _, block := range candidates {
+		if block.strong == strong {
+			return block.index, true
+		}
+	}
+	return 0, false
+}
+
+// checksum is the rolling checksum of rsync over a window of data.
+type checksum struct {
+	a, b   uint32
+	length uint32
+}
+
+func newChecksum(data []byte) checksum {
+	c := checksum{length: uint32(len(data))}
+	for i, x := range data {
+		c.a += uint32(x)
+		c.b += uint32(len(data)-i) * uint32(x)
+	}
+	c.a &= 0xffff
+	c.b &= 0xffff
+	return c
+}
+
+// roll moves the window one byte forward, removing out and adding in.
+func (c *checksum) roll(out, in byte) {
+	c.a = (c.a - uint32(out) + uint32(in)) & 0xffff
+	c.b = (c.b - c.length*uint32(out) + c.a) & 0xffff
+}
+
+func (c checksum) sum() uint32 {
+	return c.a | c.b<<16
+}
@@ -0,0 +1,130 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Reader reconstructs a stream from its delta and base.
+type Reader struct {
+	r      *bufio.Reader
+	base   io.ReaderAt
+	header *header
+	hash   hash.Hash
+
+	// the operation being read: remaining bytes of a copy from offset in the
+	// base, or of a literal
+	copying   bool
+	offset    int64
+	remaining int64
+	done      bool
+}
+
+// NewReader returns a reader of the stream a delta from r reconstructs with
+// base, which has the given size. The base is read once to check that it is
+// the one the delta was written with.
+func NewReader(r io.Reader, base io.ReaderAt, size int64) (*Reader, error) {
+	dr := &Reader{r: bufio.NewReader(r), base: base, hash: sha256.New()}
+	var err error
+	if dr.header, err = readHeader(dr.r); err != nil {
+		return nil, err
+	}
+	if uint64(size) != dr.header.BaseSize {
+		return nil, fmt.Errorf("the delta base is %v bytes, but the delta was written from one of %v bytes",
+			size, dr.header.BaseSize)
+	}
+	baseHash := sha256.New()
+	if _, err = io.Copy(baseHash, io.NewSectionReader(base, 0, size)); err != nil {
+		return nil, fmt.Errorf("error reading the delta base: %v", err)
+	}
+	if !bytes.Equal(baseHash.Sum(nil), dr.header.BaseHash[:]) {
+		return nil, fmt.Errorf("the delta base is not the one the delta was written from")
+	}
+	return dr, nil
+}
+
+// Read reads the reconstructed stream. It fails at the end of the delta if
+// the stream doesn't have the checksum it was written with.
+func (dr *Reader) Read(p []byte) (int, error) {
+	for dr.remaining == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+		if err := dr.next(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > dr.remaining {
+		p = p[:dr.remaining]
+	}
+	var n int
+	var err error
+	if dr.copying {
+		n, err = dr.base.ReadAt(p, dr.offset)
+		if err == io.EOF && n == len(p) {
+			err = nil
+		}
+		dr.offset += int64(n)
+	} else {
+		n, err = io.ReadFull(dr.r, p)
+	}
+	dr.remaining -= int64(n)
+	dr.hash.Write(p[:n])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("delta is truncated")
+	}
+	return n, err
+}
+
+// next reads the next operation of the delta.
+func (dr *Reader) next() error {
+	op, err := dr.r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("delta is truncated: %v", err)
+	}
+	switch op {
+	case opCopy:
+		var first uint64
+		var blocks uint32
+		if err = binary.Read(dr.r, binary.LittleEndian, &first); err == nil {
+			err = binary.Read(dr.r, binary.LittleEndian, &blocks)
+		}
+		if err != nil {
+			return fmt.Errorf("delta is truncated: %v", err)
+		}
+		size := uint64(dr.header.BlockSize)
+		if (first+uint64(blocks))*size > dr.header.BaseSize {
+			return fmt.Errorf("delta copies blocks past the end of its base")
+		}
+		dr.copying, dr.offset, dr.remaining = true, int64(first*size), int64(uint64(blocks)*size)
+	case opLiteral:
+		var length uint32
+		if err = binary.Read(dr.r, binary.LittleEndian, &length); err != nil {
+			return fmt.Errorf("delta is truncated: %v", err)
+		}
+		dr.copying, dr.remaining = false, int64(length)
+	case opEnd:
+		sum := make([]byte, sha256.Size)
+		if _, err = io.ReadFull(dr.r, sum); err != nil {
+			return fmt.Errorf("delta is truncated: %v", err)
+		}
+		if !bytes.Equal(sum, dr.hash.Sum(nil)) {
+			return fmt.Errorf("the reconstructed stream does not match the checksum of the delta")
+		}
+		dr.done = true
+	default:
+		return fmt.Errorf("invalid delta operation %v", op)
+	}
+	return nil
+}
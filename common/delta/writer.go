@@ -0,0 +1,202 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package delta
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Writer writes a stream as a delta from the base of a signature. The delta
+// is only complete once the writer has been closed.
+type Writer struct {
+	w    *bufio.Writer
+	sig  *Signature
+	hash hash.Hash
+	err  error
+
+	// buf holds the data not yet written to the delta: the literal from lit
+	// and the window being matched from start
+	buf     []byte
+	lit     int
+	start   int
+	window  checksum
+	rolling bool
+	checked bool
+
+	// copyFirst and copyBlocks are a run of matched blocks not yet written
+	copyFirst  uint64
+	copyBlocks uint32
+
+	// Copied and Literal are how many bytes of the stream were written as
+	// copies of the base and as literal data
+	Copied  int64
+	Literal int64
+}
+
+// NewWriter returns a writer of a delta from the base of sig to w.
+func NewWriter(w io.Writer, sig *Signature) (*Writer, error) {
+	dw := &Writer{w: bufio.NewWriter(w), sig: sig, hash: sha256.New()}
+	if err := sig.header.write(dw.w); err != nil {
+		return nil, err
+	}
+	return dw, nil
+}
+
+// Write finds the blocks of the base in p and the data buffered before it,
+// and writes the delta of the data that can't match a block anymore.
+func (dw *Writer) Write(p []byte) (int, error) {
+	if dw.err != nil {
+		return 0, dw.err
+	}
+	dw.hash.Write(p)
+	dw.buf = append(dw.buf, p...)
+	if dw.err = dw.match(); dw.err != nil {
+		return 0, dw.err
+	}
+	// drop the data already written, once it is most of the buffer
+	if dw.lit > 0 && dw.lit >= len(dw.buf)/2 {
+		n := copy(dw.buf, dw.buf[dw.lit:])
+		dw.buf = dw.buf[:n]
+		dw.start -= dw.lit
+		dw.lit = 0
+	}
+	return len(p), nil
+}
+
+// match moves the window through the buffered data, writing a copy for each
+// block of the base it matches and literals of the data between them.
+func (dw *Writer) match() error {
+	size := int(dw.sig.BlockSize)
+	for len(dw.buf)-dw.start >= size {
+		window := dw.buf[dw.start : dw.start+size]
+		if !dw.rolling {
+			dw.window = newChecksum(window)
+			dw.rolling, dw.checked = true, false
+		}
+		if !dw.checked {
+			dw.checked = true
+			if index, ok := dw.sig.match(dw.window.sum(), window); ok {
+				if err := dw.writeLiteral(dw.start); err != nil {
+					return err
+				}
+				if err := dw.addCopy(index); err != nil {
+					return err
+				}
+				dw.start += size
+				dw.lit = dw.start
+				dw.rolling = false
+				continue
+			}
+		}
+		// the window can only move once the byte after it is written
+		if len(dw.buf)-dw.start == size {
+			break
+		}
+		dw.window.roll(dw.buf[dw.start], dw.buf[dw.start+size])
+		dw.start++
+		dw.checked = false
+		if dw.start-dw.lit >= maxLiteral {
+			if err := dw.writeLiteral(dw.start); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addCopy adds a block to the run of copies, writing the run first if the
+// block doesn't follow it.
+func (dw *Writer) addCopy(index uint64) error {
+	if dw.copyBlocks > 0 && dw.copyFirst+uint64(dw.copyBlocks) == index {
+		dw.copyBlocks++
+	} else {
+		if err := dw.writeCopy(); err != nil {
+			return err
+		}
+		dw.copyFirst, dw.copyBlocks = index, 1
+	}
+	dw.Copied += int64(dw.sig.BlockSize)
+	return nil
+}
+
+// writeCopy writes the run of copies, if there is one.
+func (dw *Writer) writeCopy() error {
+	if dw.copyBlocks == 0 {
+		return nil
+	}
+	if err := dw.w.WriteByte(opCopy); err != nil {
+		return err
+	}
+	if err := binary.Write(dw.w, binary.LittleEndian, dw.copyFirst); err != nil {
+		return err
+	}
+	if err := binary.Write(dw.w, binary.LittleEndian, dw.copyBlocks); err != nil {
+		return err
+	}
+	dw.copyBlocks = 0
+	return nil
+}
+
+// writeLiteral writes the buffered data from lit up to end as a literal,
+// after the run of copies before it.
+func (dw *Writer) writeLiteral(end int) error {
+	if end == dw.lit {
+		return nil
+	}
+	if err := dw.writeCopy(); err != nil {
+		return err
+	}
+	data := dw.buf[dw.lit:end]
+	if err := dw.w.WriteByte(opLiteral); err != nil {
+		return err
+	}
+	if err := binary.Write(dw.w, binary.LittleEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	if _, err := dw.w.Write(data); err != nil {
+		return err
+	}
+	dw.Literal += int64(len(data))
+	dw.lit = end
+	return nil
+}
+
+// Close writes the rest of the stream as a literal and ends the delta with
+// the checksum of the stream. It does not close the underlying writer.
+func (dw *Writer) Close() error {
+	if dw.err != nil {
+		return dw.err
+	}
+	for dw.lit < len(dw.buf) {
+		end := len(dw.buf)
+		if end-dw.lit > maxLiteral {
+			end = dw.lit + maxLiteral
+		}
+		if dw.err = dw.writeLiteral(end); dw.err != nil {
+			return dw.err
+		}
+	}
+	if dw.err = dw.writeCopy(); dw.err != nil {
+		return dw.err
+	}
+	if dw.err = dw.w.WriteByte(opEnd); dw.err != nil {
+		return dw.err
+	}
+	if _, dw.err = dw.w.Write(dw.hash.Sum(nil)); dw.err != nil {
+		return dw.err
+	}
+	if dw.err = dw.w.Flush(); dw.err != nil {
+		return dw.err
+	}
+	dw.err = fmt.Errorf("write to closed delta")
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package encryption
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/storage"
+)
+
+// KeySize is the size of data keys and local master keys, for AES-256.
+const KeySize = 32
+
+// Algorithm is the only supported encryption algorithm.
+const Algorithm = "AES-256-GCM"
+
+// Key providers.
+const (
+	LocalProvider = "local"
+	AWSProvider   = "aws"
+)
+
+// KeyInfo describes how a dump was encrypted. The data key is stored
+// encrypted with a master key, which is never stored.
+type KeyInfo struct {
+	Algorithm string `bson:"algorithm" json:"algorithm"`
+	Provider  string `bson:"provider" json:"provider"`
+	// MasterKeyID identifies the master key: a fingerprint of a local key,
+	// or the ARN of a KMS key
+	MasterKeyID  string `bson:"master_key_id,omitempty" json:"masterKeyId,omitempty"`
+	EncryptedKey []byte `bson:"encrypted_key" json:"encryptedKey"`
+	// Compression is the codec data was compressed with before it was
+	// encrypted, for archives whose compression can't be detected
+	Compression string `bson:"compression,omitempty" json:"compression,omitempty"`
+}
+
+// KeyProvider creates data keys and recovers them from their KeyInfo.
+type KeyProvider interface {
+	NewDataKey() ([]byte, *KeyInfo, error)
+	DataKey(info *KeyInfo) ([]byte, error)
+}
+
+// NewKeyProvider returns the provider for --keyFile or --kmsProvider, only
+// one of which may be set. A KMS provider is named as aws[:<keyId>], where
+// the key ID is needed to create data keys but not to decrypt them.
+func NewKeyProvider(keyFile, kmsProvider string) (KeyProvider, error) {
+	switch {
+	case keyFile != "" && kmsProvider != "":
+		return nil, fmt.Errorf("cannot use both --keyFile and --kmsProvider")
+	case keyFile != "":
+		return newLocalProvider(keyFile)
+	case kmsProvider != "":
+		name, keyID := kmsProvider, ""
+		if i := strings.Index(kmsProvider, ":"); i >= 0 {
+			name, keyID = kmsProvider[:i], kmsProvider[i+1:]
+		}
+		if name != AWSProvider {
+			return nil, fmt.Errorf("unknown KMS provider '%v', expected aws:<keyId>", name)
+		}
+		kms, err := storage.NewAWSKMS()
+		if err != nil {
+			return nil, err
+		}
+		return &awsProvider{kms: kms, keyID: keyID}, nil
+	}
+	return nil, fmt.Errorf("one of --keyFile or --kmsProvider is required")
+}
+
+// localProvider wraps data keys with a master key read from a file.
+type localProvider struct {
+	master []byte
+	id     string
+}
+
+// newLocalProvider reads a base64-encoded 256-bit master key from path.
+func newLocalProvider(path string) (*localProvider, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %v", err)
+	}
+	master, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(master) != KeySize {
+		return nil, fmt.Errorf("key file %v must contain a base64-encoded %v-byte key", path, KeySize)
+	}
+	sum := sha256.Sum256(master)
+	return &localProvider{master: master, id: hex.EncodeToString(sum[:8])}, nil
+}
+
+func (p *localProvider) NewDataKey() ([]byte, *KeyInfo, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, nil, err
+	}
+	gcm, err := newGCM(p.master)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+	info := &KeyInfo{
+		Algorithm:    Algorithm,
+		Provider:     LocalProvider,
+		MasterKeyID:  p.id,
+		EncryptedKey: gcm.Seal(nonce, nonce, key, nil),
+	}
+	return key, info, nil
+}
+
+func (p *localProvider) DataKey(info *KeyInfo) ([]byte, error) {
+	if err := checkKeyInfo(info, LocalProvider); err != nil {
+		return nil, err
+	}
+	if info.MasterKeyID != p.id {
+		return nil, fmt.Errorf("dump was encrypted with a different key (key ID %v, not %v)", info.MasterKeyID, p.id)
+	}
+	gcm, err := newGCM(p.master)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.EncryptedKey) < gcm.NonceSize() {
+		return nil, fmt.Errorf("dump has an invalid encrypted data key")
+	}
+	nonce, sealed := info.EncryptedKey[:gcm.NonceSize()], info.EncryptedKey[gcm.NonceSize():]
+	key, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting the data key: %v", err)
+	}
+	return key, nil
+}
+
+// awsProvider creates and decrypts data keys with AWS KMS.
+type awsProvider struct {
+	kms   *storage.AWSKMS
+	keyID string
+}
+
+func (p *awsProvider) NewDataKey() ([]byte, *KeyInfo, error) {
+	if p.keyID == "" {
+		return nil, nil, fmt.Errorf("--kmsProvider must give a key to encrypt with, as aws:<keyId>")
+	}
+	key, encrypted, err := p.kms.GenerateDataKey(p.keyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	info := &KeyInfo{
+		Algorithm:    Algorithm,
+		Provider:     AWSProvider,
+		MasterKeyID:  p.keyID,
+		EncryptedKey: encrypted,
+	}
+	return key, info, nil
+}
+
+func (p *awsProvider) DataKey(info *KeyInfo) ([]byte, error) {
+	if err := checkKeyInfo(info, AWSProvider); err != nil {
+		return nil, err
+	}
+	return p.kms.Decrypt(info.EncryptedKey)
+}
+
+func checkKeyInfo(info *KeyInfo, provider string) error {
+	if info.Algorithm != Algorithm {
+		return fmt.Errorf("unsupported encryption algorithm '%v'", info.Algorithm)
+	}
+	if info.Provider != provider {
+		return fmt.Errorf("dump was encrypted with a %v key, not a %v key", info.Provider, provider)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+// Package encryption encrypts dump files and archives with AES-256-GCM.
+//
+// A stream is a header of four magic bytes, a version byte and a random
+// seven byte nonce prefix, followed by segments of at most 64KB of
+// plaintext. Each segment is a four byte big-endian length, whose high bit
+// marks the final segment, and the sealed segment. A segment's nonce is the
+// prefix, its four byte index and the final flag, so segments can't be
+// reordered and a truncated stream is detected.
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	streamVersion = 1
+	prefixSize    = 7
+	segmentSize   = 64 << 10
+	finalFlag     = 1 << 31
+)
+
+var streamMagic = []byte{'M', 'T', 'E', 'S'}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %v bytes, not %v", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(prefix []byte, index uint32, final bool) []byte {
+	nonce := make([]byte, prefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], index)
+	if final {
+		nonce[prefixSize+4] = 1
+	}
+	return nonce
+}
+
+// writer encrypts to an underlying writer.
+type writer struct {
+	out    io.Writer
+	gcm    cipher.AEAD
+	header []byte
+	prefix []byte
+	index  uint32
+	buf    []byte
+	closed bool
+}
+
+// NewWriter returns a writer that encrypts to w with key. Nothing is written
+// to w until the first segment is, and Close writes the final segment but
+// does not close w.
+func NewWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(streamMagic)+1+prefixSize)
+	copy(header, streamMagic)
+	header[len(streamMagic)] = streamVersion
+	prefix := header[len(streamMagic)+1:]
+	if _, err = io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, err
+	}
+	return &writer{out: w, gcm: gcm, header: header, prefix: prefix, buf: make([]byte, 0, segmentSize)}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("write to closed encrypted stream")
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(w.buf) == segmentSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):segmentSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *writer) seal(final bool) error {
+	if w.index == ^uint32(0) {
+		return fmt.Errorf("encrypted stream is too long")
+	}
+	sealed := w.gcm.Seal(nil, segmentNonce(w.prefix, w.index, final), w.buf, nil)
+	length := uint32(len(sealed))
+	if final {
+		length |= finalFlag
+	}
+	if w.index == 0 {
+		if _, err := w.out.Write(w.header); err != nil {
+			return err
+		}
+	}
+	var lengthBytes [4]byte
+	binary.BigEndian.PutUint32(lengthBytes[:], length)
+	if _, err := w.out.Write(lengthBytes[:]); err != nil {
+		return err
+	}
+	if _, err := w.out.Write(sealed); err != nil {
+		return err
+	}
+	w.index++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close writes the final segment.
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(true)
+}
+
+// reader decrypts from an underlying reader.
+type reader struct {
+	in     io.Reader
+	gcm    cipher.AEAD
+	prefix []byte
+	index  uint32
+	plain  []byte
+	final  bool
+	err    error
+}
+
+// NewReader returns a reader that decrypts r with key. A stream that was
+// encrypted with another key, modified or truncated is an error.
+func NewReader(r io.Reader, key []byte) (io.Reader, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(streamMagic)+1+prefixSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("error reading encrypted stream header: %v", err)
+	}
+	if !bytes.Equal(header[:len(streamMagic)], streamMagic) {
+		return nil, fmt.Errorf("data is not encrypted by mongodump")
+	}
+	if version := header[len(streamMagic)]; version != streamVersion {
+		return nil, fmt.Errorf("unsupported encrypted stream version %v", version)
+	}
+	return &reader{in: r, gcm: gcm, prefix: header[len(streamMagic)+1:]}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.open()
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// open decrypts the next segment, or checks that nothing follows the final
+// segment.
+func (r *reader) open() error {
+	if r.final {
+		var extra [1]byte
+		if n, _ := io.ReadFull(r.in, extra[:]); n > 0 {
+			return fmt.Errorf("unexpected data after the end of the encrypted stream")
+		}
+		return io.EOF
+	}
+	var lengthBytes [4]byte
+	if _, err := io.ReadFull(r.in, lengthBytes[:]); err != nil {
+		return truncated(err)
+	}
+	length := binary.BigEndian.Uint32(lengthBytes[:])
+	final := length&finalFlag != 0
+	length &^= finalFlag
+	if length < uint32(r.gcm.Overhead()) || length > uint32(segmentSize+r.gcm.Overhead()) {
+		return fmt.Errorf("encrypted stream is corrupt: invalid segment length %v", length)
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(r.in, sealed); err != nil {
+		return truncated(err)
+	}
+	plain, err := r.gcm.Open(sealed[:0], segmentNonce(r.prefix, r.index, final), sealed, nil)
+	if err != nil {
+		return fmt.Errorf("error decrypting: the key is wrong or the data was modified")
+	}
+	r.index++
+	r.plain = plain
+	r.final = final
+	return nil
+}
+
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("encrypted stream is truncated")
+	}
+	return err
+}
@@ -11,8 +11,8 @@ import (
 	"fmt"
 	"io"
 
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
@@ -130,6 +130,26 @@ func (it *Intent) IsView() bool {
 	return isView
 }
 
+// IsTimeseries returns whether the intent is of a time-series collection,
+// whose documents are stored in buckets in a system.buckets collection.
+func (it *Intent) IsTimeseries() bool {
+	if it.Options == nil {
+		return false
+	}
+	_, isTimeseries := it.Options["timeseries"]
+	return isTimeseries
+}
+
+// IsCapped returns whether the intent is of a capped collection, whose
+// documents are kept in insertion order.
+func (it *Intent) IsCapped() bool {
+	if it.Options == nil {
+		return false
+	}
+	capped, _ := it.Options["capped"].(bool)
+	return capped
+}
+
 func (it *Intent) MergeIntent(newIt *Intent) {
 	// merge new intent into old intent
 	if it.BSONFile == nil {
@@ -365,6 +385,16 @@ func (mgr *Manager) GetDestinationConflicts() (errs []DestinationConflictError)
 	return
 }
 
+// SourceNamespace returns the namespace an intent was put with, which is
+// the namespace it has in the dump. It is the intent's own namespace if it
+// was put without one, or more than one source maps to it.
+func (mgr *Manager) SourceNamespace(intent *Intent) string {
+	if srcs := mgr.destinations[intent.Namespace()]; len(srcs) == 1 {
+		return srcs[0]
+	}
+	return intent.Namespace()
+}
+
 // Intents returns a slice containing all of the intents in the manager.
 // Intents is not thread safe
 func (mgr *Manager) Intents() []*Intent {
@@ -478,8 +508,8 @@ func (mgr *Manager) Finalize(pType PriorityType) {
 	default:
 		panic("cannot initialize IntentPrioritizer with unknown type")
 	}
-	// release these for the garbage collector and to ensure code correctness
-	mgr.intents = nil
+	// release this to ensure code correctness; the intents are kept for
+	// Intents(), which is used once the collections are restored
 	mgr.intentsByDiscoveryOrder = nil
 }
 
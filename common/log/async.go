@@ -0,0 +1,130 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"sync"
+)
+
+// DefaultAsyncBufferSize is the number of entries queued in async mode
+// before callers block.
+const DefaultAsyncBufferSize = 4096
+
+// asyncWriter queues entries on a bounded channel and writes them from a
+// background goroutine, so that logging callers only contend on the channel
+// rather than on formatting and I/O.
+type asyncWriter struct {
+	logger *ToolLogger
+	queue  chan asyncItem
+	done   chan struct{}
+
+	// held for reading while sending on the queue, and for writing while
+	// closing it
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// asyncItem is either an entry to write or a flush marker, which is
+// signalled once every entry queued before it has been written.
+type asyncItem struct {
+	entry   *Entry
+	flushed chan struct{}
+}
+
+// SetAsync switches the logger to asynchronous mode, queueing up to
+// bufferSize entries for a background writer. When the queue is full,
+// callers block until there is room, so no entries are dropped. Flush or
+// Close must be called before the process exits, or queued entries are lost.
+// SetAsync should be called before logging begins.
+func (tl *ToolLogger) SetAsync(bufferSize int) {
+	tl.Close()
+	if bufferSize <= 0 {
+		bufferSize = DefaultAsyncBufferSize
+	}
+	aw := &asyncWriter{
+		logger: tl,
+		queue:  make(chan asyncItem, bufferSize),
+		done:   make(chan struct{}),
+	}
+	go aw.run()
+	tl.async = aw
+}
+
+// Flush blocks until every entry logged so far has been written. It does
+// nothing if the logger is not in async mode.
+func (tl *ToolLogger) Flush() {
+	if tl.async != nil {
+		tl.async.flush()
+	}
+}
+
+// Close writes any queued entries and stops the background writer. Entries
+// logged after Close are written synchronously.
+func (tl *ToolLogger) Close() {
+	if tl.async != nil {
+		tl.async.close()
+	}
+}
+
+func SetAsync(bufferSize int) {
+	globalToolLogger.SetAsync(bufferSize)
+}
+
+func Flush() {
+	globalToolLogger.Flush()
+}
+
+func Close() {
+	globalToolLogger.Close()
+}
+
+func (aw *asyncWriter) run() {
+	for item := range aw.queue {
+		if item.entry != nil {
+			aw.logger.writeLocked(item.entry)
+		}
+		if item.flushed != nil {
+			close(item.flushed)
+		}
+	}
+	close(aw.done)
+}
+
+// enqueue queues the entry, returning false if the writer has been closed.
+func (aw *asyncWriter) enqueue(entry *Entry) bool {
+	aw.mutex.RLock()
+	defer aw.mutex.RUnlock()
+	if aw.closed {
+		return false
+	}
+	aw.queue <- asyncItem{entry: entry}
+	return true
+}
+
+func (aw *asyncWriter) flush() {
+	aw.mutex.RLock()
+	if aw.closed {
+		aw.mutex.RUnlock()
+		return
+	}
+	flushed := make(chan struct{})
+	aw.queue <- asyncItem{flushed: flushed}
+	aw.mutex.RUnlock()
+	<-flushed
+}
+
+func (aw *asyncWriter) close() {
+	aw.mutex.Lock()
+	if aw.closed {
+		aw.mutex.Unlock()
+		return
+	}
+	aw.closed = true
+	close(aw.queue)
+	aw.mutex.Unlock()
+	<-aw.done
+}
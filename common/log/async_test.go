@@ -0,0 +1,131 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// blockingWriter blocks every write until it is released.
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+	lines   []string
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.started <- struct{}{}
+	<-w.release
+	w.lines = append(w.lines, string(p))
+	return len(p), nil
+}
+
+func TestAsyncWriter(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a logger in async mode", t, func() {
+		tl, buf := newTestLogger(Info)
+		tl.SetAsync(8)
+		defer tl.Close()
+
+		Convey("entries should be written in the order they were logged", func() {
+			for i := 0; i < 100; i++ {
+				tl.Logvf(Always, "message %v", i)
+			}
+			tl.Flush()
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			So(lines, ShouldHaveLength, 100)
+			for i, line := range lines {
+				So(line, ShouldEqual, fmt.Sprintf("message %v", i))
+			}
+		})
+
+		Convey("each goroutine's entries should keep their order", func() {
+			var wg sync.WaitGroup
+			for g := 0; g < 4; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < 50; i++ {
+						tl.Logvf(Always, "%v %v", g, i)
+					}
+				}(g)
+			}
+			wg.Wait()
+			tl.Flush()
+
+			next := map[string]int{}
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			So(lines, ShouldHaveLength, 200)
+			for _, line := range lines {
+				var g string
+				var i int
+				fmt.Sscan(line, &g, &i)
+				So(i, ShouldEqual, next[g])
+				next[g]++
+			}
+		})
+
+		Convey("Close should write the queued entries", func() {
+			tl.Logv(Always, "first")
+			tl.Logv(Always, "second")
+			tl.Close()
+			So(buf.String(), ShouldEqual, "first\nsecond\n")
+
+			Convey("and later entries should be written synchronously", func() {
+				tl.Logv(Always, "after close")
+				So(buf.String(), ShouldEqual, "first\nsecond\nafter close\n")
+				tl.Flush()
+				tl.Close()
+			})
+		})
+	})
+
+	Convey("With a logger in async mode whose writer blocks", t, func() {
+		tl, _ := newTestLogger(Info)
+		writer := &blockingWriter{started: make(chan struct{}, 10), release: make(chan struct{})}
+		tl.SetWriter(writer)
+		tl.SetAsync(1)
+
+		Convey("a full queue should block callers rather than drop entries", func() {
+			tl.Logv(Always, "being written")
+			<-writer.started
+			tl.Logv(Always, "queued")
+
+			logged := make(chan struct{})
+			go func() {
+				tl.Logv(Always, "blocked")
+				close(logged)
+			}()
+			select {
+			case <-logged:
+				So("the caller did not block", ShouldBeEmpty)
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			close(writer.release)
+			<-logged
+			tl.Close()
+			So(writer.lines, ShouldResemble, []string{"being written\n", "queued\n", "blocked\n"})
+		})
+	})
+
+	Convey("Flush and Close should do nothing without async mode", t, func() {
+		tl, buf := newTestLogger(Info)
+		tl.Flush()
+		tl.Close()
+		tl.Logv(Always, "sync")
+		So(buf.String(), ShouldEqual, "sync\n")
+	})
+}
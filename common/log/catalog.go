@@ -0,0 +1,110 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// MessageID identifies a user-facing message independently of its wording.
+// IDs have the form <tool>.<name>, e.g. "dump.doneDumping", and are never
+// reused for a message with different arguments.
+type MessageID string
+
+// MessageIDField is the entry field holding the ID of a catalog message.
+const MessageIDField = "msgId"
+
+// catalog holds the default (English) format string for every registered
+// message, and the translations loaded with LoadCatalog.
+var catalog = struct {
+	sync.RWMutex
+	defaults     map[MessageID]string
+	translations map[MessageID]string
+}{
+	defaults:     map[MessageID]string{},
+	translations: map[MessageID]string{},
+}
+
+// RegisterMessages adds default format strings to the catalog. Packages
+// register their messages from an init function.
+func RegisterMessages(messages map[MessageID]string) {
+	catalog.Lock()
+	defer catalog.Unlock()
+	for id, format := range messages {
+		catalog.defaults[id] = format
+	}
+}
+
+// SetCatalog replaces the translated format strings. Translations may use
+// explicit argument indexes, e.g. "%[2]v", to reorder arguments. Messages
+// missing from translations fall back to their defaults.
+func SetCatalog(translations map[MessageID]string) {
+	catalog.Lock()
+	defer catalog.Unlock()
+	catalog.translations = map[MessageID]string{}
+	for id, format := range translations {
+		catalog.translations[id] = format
+	}
+}
+
+// LoadCatalog reads translations from a JSON file holding an object that
+// maps message IDs to format strings, and installs them with SetCatalog.
+func LoadCatalog(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	translations := map[MessageID]string{}
+	if err = json.Unmarshal(data, &translations); err != nil {
+		return fmt.Errorf("error parsing message catalog %v: %v", path, err)
+	}
+	SetCatalog(translations)
+	return nil
+}
+
+// MessageFormat returns the format string for id: its translation if there
+// is one, its default otherwise, or the ID itself if it was never registered.
+func MessageFormat(id MessageID) string {
+	catalog.RLock()
+	defer catalog.RUnlock()
+	if format, ok := catalog.translations[id]; ok {
+		return format
+	}
+	if format, ok := catalog.defaults[id]; ok {
+		return format
+	}
+	return string(id)
+}
+
+// Msg formats the message with the given ID.
+func Msg(id MessageID, a ...interface{}) string {
+	return fmt.Sprintf(MessageFormat(id), a...)
+}
+
+// Logmf logs the message with the given ID, adding the ID to the entry as
+// the MessageIDField field.
+func (tl *ToolLogger) Logmf(minVerb int, id MessageID, a ...interface{}) {
+	if tl.enabled("", minVerb) {
+		tl.log("", minVerb, severityForVerbosity(minVerb), Msg(id, a...), []Field{{MessageIDField, string(id)}})
+	}
+}
+
+// Logmf logs the message with the given ID, adding the ID to the entry as
+// the MessageIDField field.
+func (fl *FieldLogger) Logmf(minVerb int, id MessageID, a ...interface{}) {
+	if fl.logger.enabled(fl.component, minVerb) {
+		fl.logger.log(fl.component, minVerb, severityForVerbosity(minVerb), Msg(id, a...),
+			fl.withFields([]Field{{MessageIDField, string(id)}}))
+	}
+}
+
+func Logmf(minVerb int, id MessageID, a ...interface{}) {
+	globalToolLogger.Logmf(minVerb, id, a...)
+}
@@ -0,0 +1,81 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Values accepted for the color mode
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// ANSI escape sequences used by ColorFormatter
+const (
+	ansiReset  = "\x1b[0m"
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiDim    = "\x1b[2m"
+)
+
+// ColorFormatter wraps a line-oriented formatter, highlighting errors and
+// warnings and dimming trace output with ANSI escape sequences. Info and
+// debug lines are left untouched.
+type ColorFormatter struct {
+	Formatter Formatter
+}
+
+func (cf ColorFormatter) Format(entry *Entry) []byte {
+	line := cf.Formatter.Format(entry)
+	var color string
+	switch entry.Severity {
+	case SeverityError:
+		color = ansiRed
+	case SeverityWarning:
+		color = ansiYellow
+	case SeverityTrace:
+		color = ansiDim
+	default:
+		return line
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString(color)
+	buf.Write(bytes.TrimRight(line, "\n"))
+	buf.WriteString(ansiReset)
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// ShouldColor decides whether output to the given file should be colorized.
+// In auto mode, output is colorized only when the file is a terminal that
+// understands ANSI escapes and neither NO_COLOR nor TERM=dumb is set.
+func ShouldColor(mode string, file *os.File) (bool, error) {
+	switch mode {
+	case ColorNever:
+		return false, nil
+	case ColorAlways:
+		enableANSI(file)
+		return true, nil
+	case "", ColorAuto:
+		if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+			return false, nil
+		}
+		if !terminal.IsTerminal(int(file.Fd())) {
+			return false, nil
+		}
+		return enableANSI(file), nil
+	}
+	return false, fmt.Errorf("unknown color mode '%v', expected '%v', '%v' or '%v'",
+		mode, ColorAuto, ColorAlways, ColorNever)
+}
@@ -0,0 +1,20 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+//go:build !windows
+// +build !windows
+
+package log
+
+import (
+	"os"
+)
+
+// enableANSI reports whether the terminal understands ANSI escapes, which
+// all supported non-Windows terminals do.
+func enableANSI(file *os.File) bool {
+	return true
+}
@@ -0,0 +1,173 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Names accepted for verbosity levels in component verbosity settings
+var verbosityNames = map[string]int{
+	"quiet":  -1,
+	"always": Always,
+	"info":   Info,
+	"debug":  DebugLow,
+	"trace":  DebugHigh,
+}
+
+var (
+	componentMutex sync.Mutex
+	components     = map[string]bool{}
+)
+
+// RegisterComponent records a component name so that its verbosity can be
+// configured. Components are dot-separated, e.g. "restore.indexes", and
+// inherit the verbosity of their closest configured parent.
+func RegisterComponent(name string) {
+	componentMutex.Lock()
+	defer componentMutex.Unlock()
+	components[name] = true
+}
+
+// RegisteredComponents returns the sorted names of all registered components.
+func RegisteredComponents() []string {
+	componentMutex.Lock()
+	defer componentMutex.Unlock()
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isKnownComponent returns true if the name is a registered component or the
+// parent of one.
+func isKnownComponent(name string) bool {
+	componentMutex.Lock()
+	defer componentMutex.Unlock()
+	if components[name] {
+		return true
+	}
+	for registered := range components {
+		if strings.HasPrefix(registered, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseVerbosity parses a verbosity level given by name (quiet, always,
+// info, debug, trace) or as a number.
+func ParseVerbosity(name string) (int, error) {
+	if level, ok := verbosityNames[strings.ToLower(name)]; ok {
+		return level, nil
+	}
+	level, err := strconv.Atoi(name)
+	if err != nil {
+		return 0, fmt.Errorf("invalid verbosity level '%v'", name)
+	}
+	return level, nil
+}
+
+// ParseComponentVerbosity parses a comma-separated list of component=level
+// pairs, e.g. "archive=trace,network=debug". Levels may be given by name
+// (quiet, always, info, debug, trace) or as a number. Like --quiet, quiet
+// silences every message of the component, including its errors.
+func ParseComponentVerbosity(spec string) (map[string]int, error) {
+	levels := map[string]int{}
+	if spec == "" {
+		return levels, nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid component verbosity '%v', expected <component>=<level>", pair)
+		}
+		name, levelName := parts[0], strings.ToLower(parts[1])
+		if !isKnownComponent(name) {
+			return nil, fmt.Errorf("unknown log component '%v' (known components: %v)",
+				name, strings.Join(RegisteredComponents(), ", "))
+		}
+		level, err := ParseVerbosity(levelName)
+		if err != nil || level < verbosityNames["quiet"] {
+			return nil, fmt.Errorf("invalid verbosity level '%v' for component '%v'", parts[1], name)
+		}
+		levels[name] = level
+	}
+	return levels, nil
+}
+
+// SetComponentVerbosity overrides the verbosity of the given components.
+// Components without an override use the logger's verbosity, and a quiet
+// logger stays quiet whatever its components are set to.
+func (tl *ToolLogger) SetComponentVerbosity(levels map[string]int) {
+	tl.componentVerbosity = levels
+}
+
+// verbosityFor returns the verbosity in effect for a component, walking up
+// the dotted component hierarchy until an override is found.
+func (tl *ToolLogger) verbosityFor(component string) int {
+	for component != "" && len(tl.componentVerbosity) > 0 && tl.verbosity >= 0 {
+		if level, ok := tl.componentVerbosity[component]; ok {
+			return level
+		}
+		idx := strings.LastIndex(component, ".")
+		if idx < 0 {
+			break
+		}
+		component = component[:idx]
+	}
+	return tl.verbosity
+}
+
+// Component returns a logger for the named component, registering it.
+func (tl *ToolLogger) Component(name string) *ComponentLogger {
+	RegisterComponent(name)
+	return &ComponentLogger{logger: tl, name: name}
+}
+
+// ComponentLogger writes messages on behalf of a single component. Its
+// verbosity can be set independently from the rest of the logger.
+type ComponentLogger struct {
+	logger *ToolLogger
+	name   string
+}
+
+// Name returns the component's name.
+func (cl *ComponentLogger) Name() string {
+	return cl.name
+}
+
+func (cl *ComponentLogger) Logvf(minVerb int, format string, a ...interface{}) {
+	cl.logger.logvf(cl.name, minVerb, format, a...)
+}
+
+func (cl *ComponentLogger) Logv(minVerb int, msg string) {
+	cl.logger.logkv(cl.name, minVerb, msg, nil)
+}
+
+func (cl *ComponentLogger) Logkv(minVerb int, msg string, keyvals ...interface{}) {
+	cl.logger.logkv(cl.name, minVerb, msg, keyvals)
+}
+
+// IsInVerbosity returns true if messages at the given level would be written
+// for this component.
+func (cl *ComponentLogger) IsInVerbosity(minVerb int) bool {
+	return minVerb >= 0 && cl.logger.enabled(cl.name, minVerb)
+}
+
+// Writer returns an io.Writer that writes to the component's logger with the
+// given verbosity.
+func (cl *ComponentLogger) Writer(minVerb int) io.Writer {
+	return &toolLogWriter{cl.logger, cl.name, minVerb}
+}
@@ -0,0 +1,103 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseComponentVerbosity(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	RegisterComponent("parsetest.reader")
+
+	Convey("Component verbosity should be parsed by name or number", t, func() {
+		levels, err := ParseComponentVerbosity("parsetest=trace, parsetest.reader=quiet")
+		So(err, ShouldBeNil)
+		So(levels, ShouldResemble, map[string]int{"parsetest": DebugHigh, "parsetest.reader": -1})
+
+		levels, err = ParseComponentVerbosity("parsetest.reader=2")
+		So(err, ShouldBeNil)
+		So(levels, ShouldResemble, map[string]int{"parsetest.reader": DebugLow})
+
+		levels, err = ParseComponentVerbosity("")
+		So(err, ShouldBeNil)
+		So(levels, ShouldBeEmpty)
+	})
+
+	Convey("Invalid component verbosity should be rejected", t, func() {
+		for spec, message := range map[string]string{
+			"parsetest":           "invalid component verbosity 'parsetest', expected <component>=<level>",
+			"=debug":              "invalid component verbosity '=debug', expected <component>=<level>",
+			"parsetest.other=1":   "unknown log component 'parsetest.other'",
+			"parsetest=loud":      "invalid verbosity level 'loud' for component 'parsetest'",
+			"parsetest.reader=-2": "invalid verbosity level '-2' for component 'parsetest.reader'",
+		} {
+			_, err := ParseComponentVerbosity(spec)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, message)
+		}
+	})
+}
+
+func TestComponentVerbosity(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a logger at the default verbosity", t, func() {
+		tl, buf := newTestLogger(Always)
+		reader := tl.Component("archive.reader")
+		other := tl.Component("other")
+
+		Convey("components should follow the logger's verbosity without overrides", func() {
+			reader.Logv(Info, "info")
+			reader.Logv(Always, "always")
+			So(buf.String(), ShouldEqual, "always\n")
+			So(reader.IsInVerbosity(Info), ShouldBeFalse)
+		})
+
+		Convey("an override should apply to the component and its children only", func() {
+			tl.SetComponentVerbosity(map[string]int{"archive": DebugHigh})
+			reader.Logv(DebugHigh, "reader trace")
+			other.Logv(Info, "other info")
+			tl.Logv(Info, "global info")
+			So(buf.String(), ShouldEqual, "reader trace\n")
+			So(reader.IsInVerbosity(DebugHigh), ShouldBeTrue)
+			So(other.IsInVerbosity(Info), ShouldBeFalse)
+		})
+
+		Convey("the closest override should win", func() {
+			tl.SetComponentVerbosity(map[string]int{"archive": DebugHigh, "archive.reader": Info})
+			reader.Logv(DebugLow, "reader debug")
+			tl.Component("archive.writer").Logv(DebugLow, "writer debug")
+			So(buf.String(), ShouldEqual, "writer debug\n")
+		})
+
+		Convey("a quiet component should drop its errors too", func() {
+			tl.SetComponentVerbosity(map[string]int{"archive": -1})
+			reader.Logv(Always, "always")
+			reader.WithFields("ns", "db.c").Errorf("failed")
+			other.WithFields().Errorf("other failed")
+			So(buf.String(), ShouldEqual, "other failed\n")
+		})
+	})
+
+	Convey("With a quiet logger", t, func() {
+		tl, buf := newTestLogger(-1)
+
+		Convey("components set to a higher verbosity should stay quiet", func() {
+			tl.SetComponentVerbosity(map[string]int{"archive": DebugHigh})
+			reader := tl.Component("archive.reader")
+			reader.Logv(Always, "always")
+			reader.WithFields().Errorf("failed")
+			So(buf.String(), ShouldBeEmpty)
+			So(reader.IsInVerbosity(Always), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+var lastOperationID uint64
+
+// NewOperationID returns a process-unique ID for correlating the messages
+// produced by one unit of work, such as dumping a single collection.
+func NewOperationID() string {
+	return strconv.FormatUint(atomic.AddUint64(&lastOperationID, 1), 10)
+}
+
+// FieldLogger attaches a fixed set of fields to every message it writes.
+type FieldLogger struct {
+	logger    *ToolLogger
+	component string
+	fields    []Field
+}
+
+// WithFields returns a logger that adds the given alternating keys and
+// values to every message.
+func (tl *ToolLogger) WithFields(keyvals ...interface{}) *FieldLogger {
+	return &FieldLogger{logger: tl, fields: fieldsFromKeyvals(keyvals)}
+}
+
+// WithFields returns a logger for this component that adds the given
+// alternating keys and values to every message.
+func (cl *ComponentLogger) WithFields(keyvals ...interface{}) *FieldLogger {
+	return &FieldLogger{logger: cl.logger, component: cl.name, fields: fieldsFromKeyvals(keyvals)}
+}
+
+// WithFields returns a logger with additional fields.
+func (fl *FieldLogger) WithFields(keyvals ...interface{}) *FieldLogger {
+	return &FieldLogger{logger: fl.logger, component: fl.component, fields: fl.withFields(fieldsFromKeyvals(keyvals))}
+}
+
+// withFields returns a new slice holding the logger's fields followed by
+// the given ones, so that callers never share a slice that gets redacted.
+func (fl *FieldLogger) withFields(fields []Field) []Field {
+	all := make([]Field, 0, len(fl.fields)+len(fields))
+	all = append(all, fl.fields...)
+	return append(all, fields...)
+}
+
+func (fl *FieldLogger) Logvf(minVerb int, format string, a ...interface{}) {
+	if fl.logger.enabled(fl.component, minVerb) {
+		fl.logger.logf(fl.component, minVerb, severityForVerbosity(minVerb), fl.withFields(nil), format, a)
+	}
+}
+
+func (fl *FieldLogger) Logv(minVerb int, msg string) {
+	fl.Logkv(minVerb, msg)
+}
+
+func (fl *FieldLogger) Logkv(minVerb int, msg string, keyvals ...interface{}) {
+	if fl.logger.enabled(fl.component, minVerb) {
+		fl.logger.log(fl.component, minVerb, severityForVerbosity(minVerb), msg, fl.withFields(fieldsFromKeyvals(keyvals)))
+	}
+}
+
+func (fl *FieldLogger) Errorf(format string, a ...interface{}) {
+	if fl.logger.enabled(fl.component, Always) {
+		fl.logger.log(fl.component, Always, SeverityError, fmt.Sprintf(format, a...), fl.withFields(nil))
+	}
+}
+
+func (fl *FieldLogger) Warnf(format string, a ...interface{}) {
+	if fl.logger.enabled(fl.component, Always) {
+		fl.logger.log(fl.component, Always, SeverityWarning, fmt.Sprintf(format, a...), fl.withFields(nil))
+	}
+}
+
+// IsInVerbosity returns true if messages at the given level would be written.
+func (fl *FieldLogger) IsInVerbosity(minVerb int) bool {
+	return minVerb >= 0 && fl.logger.enabled(fl.component, minVerb)
+}
+
+type contextKey struct{}
+
+// ContextWithFields returns a context carrying the given alternating keys
+// and values in addition to any fields already in ctx.
+func ContextWithFields(ctx context.Context, keyvals ...interface{}) context.Context {
+	return context.WithValue(ctx, contextKey{}, WithContext(ctx).WithFields(keyvals...).fields)
+}
+
+// WithContext returns a logger that adds the fields carried by ctx to every
+// message.
+func (tl *ToolLogger) WithContext(ctx context.Context) *FieldLogger {
+	fields, _ := ctx.Value(contextKey{}).([]Field)
+	return &FieldLogger{logger: tl, fields: fields}
+}
+
+func WithFields(keyvals ...interface{}) *FieldLogger {
+	return globalToolLogger.WithFields(keyvals...)
+}
+
+func WithContext(ctx context.Context) *FieldLogger {
+	return globalToolLogger.WithContext(ctx)
+}
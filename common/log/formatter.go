@@ -0,0 +1,191 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Names of the supported log output formats
+const (
+	TextFormat = "text"
+	JSONFormat = "json"
+)
+
+// Severity describes how important a log entry is. It is derived from the
+// verbosity level an entry was logged at.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityInfo
+	SeverityDebug
+	SeverityTrace
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	case SeverityDebug:
+		return "debug"
+	default:
+		return "trace"
+	}
+}
+
+// severityForVerbosity maps a minimum verbosity level onto a severity.
+func severityForVerbosity(minVerb int) Severity {
+	switch {
+	case minVerb <= Info:
+		return SeverityInfo
+	case minVerb == DebugLow:
+		return SeverityDebug
+	default:
+		return SeverityTrace
+	}
+}
+
+// Field is a single key/value pair attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Entry is a single log line before it has been formatted.
+type Entry struct {
+	Time      time.Time
+	Timestamp string
+	Verbosity int
+	Severity  Severity
+	Tool      string
+	Component string
+	Message   string
+	Fields    []Field
+}
+
+// Formatter turns a log entry into the bytes written to the log writer.
+// The returned slice must include any trailing newline.
+type Formatter interface {
+	Format(entry *Entry) []byte
+}
+
+// NewFormatter returns the formatter registered under the given name.
+func NewFormatter(name string) (Formatter, error) {
+	switch name {
+	case "", TextFormat:
+		return TextFormatter{}, nil
+	case JSONFormat:
+		return JSONFormatter{}, nil
+	}
+	return nil, fmt.Errorf("unknown log format '%v', expected '%v' or '%v'", name, TextFormat, JSONFormat)
+}
+
+// TextFormatter writes entries as tab-separated, human-readable lines.
+// Fields are appended to the message as key=value pairs.
+type TextFormatter struct{}
+
+func (TextFormatter) Format(entry *Entry) []byte {
+	buf := &bytes.Buffer{}
+	if entry.Timestamp != "" {
+		buf.WriteString(entry.Timestamp)
+		buf.WriteByte('\t')
+	}
+	writeMessage(buf, entry)
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// writeMessage writes the entry's message followed by its fields. Message
+// IDs are left out, since they only matter to machine-readable output.
+func writeMessage(buf *bytes.Buffer, entry *Entry) {
+	buf.WriteString(entry.Message)
+	for _, field := range entry.Fields {
+		if field.Key == MessageIDField {
+			continue
+		}
+		fmt.Fprintf(buf, " %v=%v", field.Key, field.Value)
+	}
+}
+
+// JSONFormatter writes each entry as a single-line JSON object so that log
+// output can be ingested without further parsing.
+type JSONFormatter struct{}
+
+func (JSONFormatter) Format(entry *Entry) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	if entry.Timestamp != "" {
+		writeJSONField(buf, "t", entry.Timestamp)
+		buf.WriteByte(',')
+	}
+	writeJSONField(buf, "level", entry.Severity.String())
+	if entry.Tool != "" {
+		buf.WriteByte(',')
+		writeJSONField(buf, "tool", entry.Tool)
+	}
+	if entry.Component != "" {
+		buf.WriteByte(',')
+		writeJSONField(buf, "component", entry.Component)
+	}
+	buf.WriteByte(',')
+	writeJSONField(buf, "msg", entry.Message)
+	if len(entry.Fields) > 0 {
+		buf.WriteString(`,"attr":{`)
+		for i, field := range entry.Fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONField(buf, field.Key, field.Value)
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+func writeJSONField(buf *bytes.Buffer, key string, value interface{}) {
+	encodedKey, _ := json.Marshal(key)
+	buf.Write(encodedKey)
+	buf.WriteByte(':')
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		// fall back to the value's string representation rather than
+		// dropping the field
+		encodedValue, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(encodedValue)
+}
+
+// fieldsFromKeyvals converts alternating keys and values into fields. A
+// trailing key without a value is kept with a placeholder value.
+func fieldsFromKeyvals(keyvals []interface{}) []Field {
+	if len(keyvals) == 0 {
+		return nil
+	}
+	fields := make([]Field, 0, (len(keyvals)+1)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		var value interface{} = "<missing>"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		fields = append(fields, Field{Key: key, Value: value})
+	}
+	return fields
+}
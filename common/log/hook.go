@@ -0,0 +1,60 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+// Hook is called for every message written by a logger. The level is the
+// message's Severity, so hooks interested only in problems can check for
+// SeverityError or SeverityWarning. The fields map holds the message's
+// fields and may be kept by the hook.
+type Hook func(level int, msg string, fields map[string]interface{})
+
+// AddHook registers a hook that is called for every message that passes the
+// verbosity and sampling checks, after it has been redacted, and returns a
+// function that removes it. Hooks run on the logging goroutine but outside
+// the logger's mutex, so they may block or log without deadlocking; a hook
+// that logs unconditionally will call itself forever, however.
+func (tl *ToolLogger) AddHook(hook Hook) (remove func()) {
+	tl.hookMutex.Lock()
+	defer tl.hookMutex.Unlock()
+	added := &hook
+	tl.hooks = append(tl.hooks, added)
+	return func() {
+		tl.hookMutex.Lock()
+		defer tl.hookMutex.Unlock()
+		// copy the remaining hooks, since fireHooks may be iterating over
+		// the current slice
+		hooks := make([]*Hook, 0, len(tl.hooks))
+		for _, h := range tl.hooks {
+			if h != added {
+				hooks = append(hooks, h)
+			}
+		}
+		tl.hooks = hooks
+	}
+}
+
+func AddHook(hook Hook) (remove func()) {
+	return globalToolLogger.AddHook(hook)
+}
+
+// fireHooks calls all hooks for an entry. It must not be called with the
+// mutex held.
+func (tl *ToolLogger) fireHooks(entry *Entry) {
+	tl.hookMutex.RLock()
+	hooks := tl.hooks
+	tl.hookMutex.RUnlock()
+	if len(hooks) == 0 {
+		return
+	}
+	for _, hook := range hooks {
+		fields := make(map[string]interface{}, len(entry.Fields))
+		for _, field := range entry.Fields {
+			fields[field.Key] = field.Value
+		}
+		(*hook)(int(entry.Severity), entry.Message, fields)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// hookCall is a message passed to a hook.
+type hookCall struct {
+	level  int
+	msg    string
+	fields map[string]interface{}
+}
+
+func TestHooks(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a hook on a logger", t, func() {
+		tl, buf := newTestLogger(Info)
+		var calls []hookCall
+		remove := tl.AddHook(func(level int, msg string, fields map[string]interface{}) {
+			calls = append(calls, hookCall{level, msg, fields})
+		})
+
+		Convey("the hook should get each message written with its severity and fields", func() {
+			tl.Logkv(Info, "restored", "ns", "db.c", "docs", 2)
+			tl.Errorf("failed")
+			tl.Logv(DebugLow, "not written")
+			So(calls, ShouldResemble, []hookCall{
+				{int(SeverityInfo), "restored", map[string]interface{}{"ns": "db.c", "docs": 2}},
+				{int(SeverityError), "failed", map[string]interface{}{}},
+			})
+		})
+
+		Convey("the hook should get the redacted message and fields", func() {
+			tl.AddSecret("hunter2")
+			tl.Logkv(Always, "connecting to mongodb://u:p@host",
+				"err", errors.New("bad password hunter2"), "uri", "mongodb://u:p@host")
+			So(calls, ShouldResemble, []hookCall{{
+				int(SeverityInfo),
+				"connecting to mongodb://u:<redacted>@host",
+				map[string]interface{}{"err": "bad password <redacted>", "uri": "mongodb://u:<redacted>@host"},
+			}})
+		})
+
+		Convey("the remove func should detach only its hook", func() {
+			others := 0
+			tl.AddHook(func(int, string, map[string]interface{}) { others++ })
+			remove()
+			tl.Logv(Always, "after remove")
+			So(calls, ShouldBeEmpty)
+			So(others, ShouldEqual, 1)
+			So(buf.String(), ShouldEqual, "after remove\n")
+
+			// removing twice does nothing
+			remove()
+			tl.Logv(Always, "again")
+			So(others, ShouldEqual, 2)
+		})
+
+		Convey("a hook should be able to log without deadlocking", func() {
+			tl.AddHook(func(level int, msg string, fields map[string]interface{}) {
+				if level == int(SeverityError) {
+					tl.Logkv(Always, "forwarded", "msg", msg)
+				}
+			})
+			done := make(chan struct{})
+			go func() {
+				tl.Errorf("failed")
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				So("the hook deadlocked", ShouldBeEmpty)
+			}
+			So(buf.String(), ShouldEqual, "failed\nforwarded msg=failed\n")
+		})
+	})
+}
@@ -0,0 +1,176 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// limiterNow and limiterAfterFunc are replaced in tests with a fake clock.
+var (
+	limiterNow       = time.Now
+	limiterAfterFunc = func(d time.Duration, f func()) (stop func() bool) {
+		return time.AfterFunc(d, f).Stop
+	}
+)
+
+// Limiter reduces the volume of repetitive log output. Messages are grouped
+// by a caller-supplied key: consecutive identical messages for a key are
+// collapsed into a single "last message repeated N times" line, and at most
+// burst messages are written per key in each interval, with the rest counted
+// and summarized once the interval is over.
+type Limiter struct {
+	logger   *ToolLogger
+	burst    int
+	interval time.Duration
+
+	mutex sync.Mutex
+	keys  map[string]*limiterKey
+}
+
+type limiterKey struct {
+	minVerb     int
+	logged      bool
+	lastMsg     string
+	repeats     int
+	windowStart time.Time
+	window      int
+	written     int
+	suppressed  int
+	// stops the timer that writes the summaries when the window ends
+	stopTimer func() bool
+}
+
+// NewLimiter creates a limiter writing to this logger. A burst of zero
+// disables rate limiting, leaving only the collapsing of repeated messages.
+func (tl *ToolLogger) NewLimiter(burst int, interval time.Duration) *Limiter {
+	return &Limiter{
+		logger:   tl,
+		burst:    burst,
+		interval: interval,
+		keys:     map[string]*limiterKey{},
+	}
+}
+
+// NewLimiter creates a limiter writing to the global logger.
+func NewLimiter(burst int, interval time.Duration) *Limiter {
+	return globalToolLogger.NewLimiter(burst, interval)
+}
+
+// Logvf logs a formatted message under the given key, subject to the
+// limiter's deduplication and rate limits. Summaries of the messages that
+// were held back are written when the key's interval ends.
+func (l *Limiter) Logvf(key string, minVerb int, format string, a ...interface{}) {
+	if !l.logger.enabled("", minVerb) {
+		return
+	}
+	msg := fmt.Sprintf(format, a...)
+	now := limiterNow()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	state, ok := l.keys[key]
+	if !ok {
+		state = &limiterKey{windowStart: now}
+		l.keys[key] = state
+	}
+	state.minVerb = minVerb
+	if now.Sub(state.windowStart) >= l.interval {
+		l.startWindow(key, state, now)
+	}
+
+	if state.logged && msg == state.lastMsg {
+		state.repeats++
+		l.scheduleWindowEnd(key, state, now)
+		return
+	}
+	l.flushRepeats(state)
+
+	if l.burst > 0 && state.written >= l.burst {
+		state.suppressed++
+		l.scheduleWindowEnd(key, state, now)
+		return
+	}
+	state.written++
+	state.logged = true
+	state.lastMsg = msg
+	l.logger.logkv("", minVerb, msg, nil)
+}
+
+// startWindow writes the summaries of the key's current window and starts
+// a new one. The caller must hold the mutex.
+func (l *Limiter) startWindow(key string, state *limiterKey, now time.Time) {
+	l.flushRepeats(state)
+	l.flushSuppressed(key, state)
+	l.stopTimer(state)
+	state.windowStart = now
+	state.window++
+	state.written = 0
+}
+
+// scheduleWindowEnd makes sure that the summaries of the key's window are
+// written when it ends, even if no further message arrives. The caller
+// must hold the mutex.
+func (l *Limiter) scheduleWindowEnd(key string, state *limiterKey, now time.Time) {
+	if state.stopTimer != nil {
+		return
+	}
+	window := state.window
+	state.stopTimer = limiterAfterFunc(state.windowStart.Add(l.interval).Sub(now), func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		// a message may have started a new window in the meantime
+		if state.window == window {
+			state.stopTimer = nil
+			l.startWindow(key, state, limiterNow())
+		}
+	})
+}
+
+func (l *Limiter) stopTimer(state *limiterKey) {
+	if state.stopTimer != nil {
+		state.stopTimer()
+		state.stopTimer = nil
+	}
+}
+
+// Flush writes the summaries of any collapsed or suppressed messages. It
+// should be called before the tool exits.
+func (l *Limiter) Flush() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	keys := make([]string, 0, len(l.keys))
+	for key := range l.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		state := l.keys[key]
+		l.stopTimer(state)
+		l.flushRepeats(state)
+		l.flushSuppressed(key, state)
+	}
+}
+
+func (l *Limiter) flushRepeats(state *limiterKey) {
+	if state.repeats > 0 {
+		l.logger.logkv("", state.minVerb, fmt.Sprintf("last message repeated %v times", state.repeats), nil)
+		state.repeats = 0
+	}
+}
+
+func (l *Limiter) flushSuppressed(key string, state *limiterKey) {
+	if state.suppressed > 0 {
+		l.logger.logkv("", state.minVerb, fmt.Sprintf("suppressed %v similar messages (%v)", state.suppressed, key), nil)
+		state.suppressed = 0
+	}
+}
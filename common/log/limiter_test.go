@@ -0,0 +1,136 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeTimer is a timer started by a limiter, fired by the test.
+type fakeTimer struct {
+	delay   time.Duration
+	fire    func()
+	stopped bool
+}
+
+func TestLimiter(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a fake clock", t, func() {
+		now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		var timers []*fakeTimer
+		savedNow, savedAfterFunc := limiterNow, limiterAfterFunc
+		limiterNow = func() time.Time { return now }
+		limiterAfterFunc = func(d time.Duration, f func()) func() bool {
+			timer := &fakeTimer{delay: d, fire: f}
+			timers = append(timers, timer)
+			return func() bool {
+				timer.stopped = true
+				return true
+			}
+		}
+		defer func() { limiterNow, limiterAfterFunc = savedNow, savedAfterFunc }()
+
+		tl, buf := newTestLogger(Info)
+
+		Convey("consecutive identical messages should be collapsed", func() {
+			limiter := tl.NewLimiter(0, time.Minute)
+			for i := 0; i < 3; i++ {
+				limiter.Logvf("dup", Always, "duplicate key %v", 1)
+			}
+			limiter.Logvf("dup", Always, "duplicate key %v", 2)
+			limiter.Logvf("dup", Always, "duplicate key %v", 1)
+			So(buf.String(), ShouldEqual,
+				"duplicate key 1\nlast message repeated 2 times\nduplicate key 2\nduplicate key 1\n")
+		})
+
+		Convey("repeats should be summarized when the interval ends", func() {
+			limiter := tl.NewLimiter(0, time.Minute)
+			limiter.Logvf("dup", Always, "duplicate key")
+			now = now.Add(10 * time.Second)
+			limiter.Logvf("dup", Always, "duplicate key")
+			limiter.Logvf("dup", Always, "duplicate key")
+			So(timers, ShouldHaveLength, 1)
+			So(timers[0].delay, ShouldEqual, 50*time.Second)
+
+			now = now.Add(50 * time.Second)
+			timers[0].fire()
+			So(buf.String(), ShouldEqual, "duplicate key\nlast message repeated 2 times\n")
+
+			// later repeats are still collapsed, and summarized at the end
+			// of their own interval
+			limiter.Logvf("dup", Always, "duplicate key")
+			So(timers, ShouldHaveLength, 2)
+			So(timers[1].delay, ShouldEqual, time.Minute)
+		})
+
+		Convey("at most burst messages should be written per key and interval", func() {
+			limiter := tl.NewLimiter(2, time.Minute)
+			for i := 1; i <= 5; i++ {
+				limiter.Logvf("code 11000", Always, "error %v", i)
+			}
+			limiter.Logvf("code 121", Always, "validation error")
+			So(buf.String(), ShouldEqual, "error 1\nerror 2\nvalidation error\n")
+			So(timers, ShouldHaveLength, 1)
+
+			Convey("and the rest should be summarized when the interval ends", func() {
+				now = now.Add(time.Minute)
+				timers[0].fire()
+				So(buf.String(), ShouldEqual,
+					"error 1\nerror 2\nvalidation error\nsuppressed 3 similar messages (code 11000)\n")
+
+				limiter.Logvf("code 11000", Always, "error 6")
+				limiter.Logvf("code 11000", Always, "error 7")
+				So(buf.String(), ShouldEndWith, "error 6\nerror 7\n")
+			})
+
+			Convey("or by the first message of the next interval", func() {
+				now = now.Add(time.Minute)
+				limiter.Logvf("code 11000", Always, "error 6")
+				So(buf.String(), ShouldEqual,
+					"error 1\nerror 2\nvalidation error\nsuppressed 3 similar messages (code 11000)\nerror 6\n")
+				So(timers[0].stopped, ShouldBeTrue)
+
+				// a timer that fires late must not end the new window
+				timers[0].fire()
+				limiter.Logvf("code 11000", Always, "error 7")
+				limiter.Logvf("code 11000", Always, "error 8")
+				So(buf.String(), ShouldEndWith, "error 6\nerror 7\n")
+			})
+		})
+
+		Convey("Flush should write the pending summaries of every key", func() {
+			limiter := tl.NewLimiter(1, time.Minute)
+			limiter.Logvf("b", Always, "b1")
+			limiter.Logvf("b", Always, "b2")
+			limiter.Logvf("a", Always, "a1")
+			limiter.Logvf("a", Always, "a1")
+			limiter.Flush()
+			So(buf.String(), ShouldEqual,
+				"b1\na1\nlast message repeated 1 times\nsuppressed 1 similar messages (b)\n")
+			for _, timer := range timers {
+				So(timer.stopped, ShouldBeTrue)
+			}
+
+			buf.Reset()
+			limiter.Flush()
+			So(buf.String(), ShouldBeEmpty)
+		})
+
+		Convey("messages above the verbosity should be neither written nor counted", func() {
+			limiter := tl.NewLimiter(1, time.Minute)
+			limiter.Logvf("k", DebugLow, "debug")
+			limiter.Logvf("k", Always, "written")
+			limiter.Flush()
+			So(buf.String(), ShouldEqual, "written\n")
+		})
+	})
+}
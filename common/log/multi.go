@@ -0,0 +1,55 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"io"
+)
+
+// writerSink is an additional log output with its own verbosity and format.
+type writerSink struct {
+	writer    io.Writer
+	verbosity int
+	formatter Formatter
+}
+
+// AddSink writes log output to an additional writer, alongside the logger's
+// own output. The sink receives every message logged at or below the given
+// verbosity, regardless of the logger's verbosity and component settings,
+// formatted with the named format. AddSink should be called before logging
+// begins.
+func (tl *ToolLogger) AddSink(writer io.Writer, verbosity int, format string) error {
+	formatter, err := NewFormatter(format)
+	if err != nil {
+		return err
+	}
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+	tl.writerSinks = append(tl.writerSinks, &writerSink{
+		writer:    writer,
+		verbosity: verbosity,
+		formatter: formatter,
+	})
+	if verbosity > tl.maxSinkVerbosity {
+		tl.maxSinkVerbosity = verbosity
+	}
+	return nil
+}
+
+func AddSink(writer io.Writer, verbosity int, format string) error {
+	return globalToolLogger.AddSink(writer, verbosity, format)
+}
+
+// writeSinks writes an entry to every additional sink that wants it. The
+// caller must hold the mutex.
+func (tl *ToolLogger) writeSinks(entry *Entry) {
+	for _, sink := range tl.writerSinks {
+		if entry.Verbosity <= sink.verbosity {
+			sink.writer.Write(sink.formatter.Format(entry))
+		}
+	}
+}
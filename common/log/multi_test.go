@@ -0,0 +1,88 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWriterSinks(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a logger at the default verbosity", t, func() {
+		tl, buf := newTestLogger(Always)
+		sinkBuf := &bytes.Buffer{}
+
+		Convey("a more verbose sink should get entries the main writer doesn't", func() {
+			So(tl.AddSink(sinkBuf, DebugLow, JSONFormat), ShouldBeNil)
+			So(tl.enabled("", DebugLow), ShouldBeTrue)
+			So(tl.enabled("", DebugHigh), ShouldBeFalse)
+
+			tl.Logv(DebugLow, "debug")
+			So(buf.String(), ShouldBeEmpty)
+			So(sinkBuf.String(), ShouldEqual, `{"level":"debug","msg":"debug"}`+"\n")
+
+			tl.Logv(Always, "always")
+			tl.Logv(DebugHigh, "trace")
+			So(buf.String(), ShouldEqual, "always\n")
+			So(sinkBuf.String(), ShouldEqual, `{"level":"debug","msg":"debug"}`+"\n"+`{"level":"info","msg":"always"}`+"\n")
+		})
+
+		Convey("a sink should ignore component overrides", func() {
+			So(tl.AddSink(sinkBuf, Info, TextFormat), ShouldBeNil)
+			tl.SetComponentVerbosity(map[string]int{"archive": -1})
+			tl.Component("archive").Logv(Info, "archive info")
+			So(buf.String(), ShouldBeEmpty)
+			So(sinkBuf.String(), ShouldEqual, "archive info\n")
+		})
+
+		Convey("each sink should only get entries up to its own verbosity", func() {
+			traceBuf := &bytes.Buffer{}
+			So(tl.AddSink(sinkBuf, Info, TextFormat), ShouldBeNil)
+			So(tl.AddSink(traceBuf, DebugHigh, TextFormat), ShouldBeNil)
+			tl.Logv(Info, "info")
+			tl.Logv(DebugHigh, "trace")
+			So(buf.String(), ShouldBeEmpty)
+			So(sinkBuf.String(), ShouldEqual, "info\n")
+			So(traceBuf.String(), ShouldEqual, "info\ntrace\n")
+		})
+
+		Convey("an unknown format should be rejected", func() {
+			So(tl.AddSink(sinkBuf, Info, "xml"), ShouldNotBeNil)
+			So(tl.writerSinks, ShouldBeEmpty)
+		})
+	})
+
+	Convey("With a more verbose logger than its sink", t, func() {
+		tl, buf := newTestLogger(DebugHigh)
+		sinkBuf := &bytes.Buffer{}
+		So(tl.AddSink(sinkBuf, Always, TextFormat), ShouldBeNil)
+
+		Convey("the sink should not get the entries above its verbosity", func() {
+			tl.Logv(Info, "info")
+			So(buf.String(), ShouldEqual, "info\n")
+			So(sinkBuf.String(), ShouldBeEmpty)
+		})
+	})
+
+	Convey("With a quiet logger", t, func() {
+		tl, buf := newTestLogger(-1)
+		sinkBuf := &bytes.Buffer{}
+		So(tl.AddSink(sinkBuf, Info, TextFormat), ShouldBeNil)
+
+		Convey("a sink should still get its entries", func() {
+			tl.Errorf("failed")
+			tl.Logv(Info, "info")
+			So(buf.String(), ShouldBeEmpty)
+			So(sinkBuf.String(), ShouldEqual, "failed\ninfo\n")
+		})
+	})
+}
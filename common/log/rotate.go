@@ -0,0 +1,237 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedSuffixFormat is appended to the log path when a file is rotated.
+const rotatedSuffixFormat = "2006-01-02T15-04-05.000"
+
+// rotateNow is replaced in tests to rotate files at fixed times.
+var rotateNow = time.Now
+
+// RotateOptions controls when a RotatingWriter rotates its file and what
+// happens to rotated files.
+type RotateOptions struct {
+	// MaxSize is the size in bytes after which the file is rotated. Zero
+	// disables size-based rotation.
+	MaxSize int64
+
+	// Interval is the age after which the file is rotated. Zero disables
+	// time-based rotation.
+	Interval time.Duration
+
+	// MaxFiles is the number of rotated files to keep. Zero keeps all of them.
+	MaxFiles int
+
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// RotatingWriter is an io.WriteCloser that appends to a file, moving it
+// aside and starting a new one according to its RotateOptions.
+type RotatingWriter struct {
+	sync.Mutex
+	path   string
+	opts   RotateOptions
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// NewRotatingWriter opens (or creates) the file at path for appending.
+func NewRotatingWriter(path string, opts RotateOptions) (*RotatingWriter, error) {
+	rw := &RotatingWriter{path: path, opts: opts}
+	if err := rw.open(); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+func (rw *RotatingWriter) open() error {
+	if dir := filepath.Dir(rw.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating log directory: %v", err)
+		}
+	}
+	file, err := os.OpenFile(rw.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error reading log file info: %v", err)
+	}
+	rw.file = file
+	rw.size = info.Size()
+	rw.opened = rotateNow()
+	return nil
+}
+
+// Write writes to the current file, rotating first if the write would put
+// the file over its size limit or the file is older than the interval.
+func (rw *RotatingWriter) Write(p []byte) (int, error) {
+	rw.Lock()
+	defer rw.Unlock()
+
+	if rw.file == nil {
+		return 0, fmt.Errorf("write to closed log file %v", rw.path)
+	}
+	if rw.shouldRotate(int64(len(p))) {
+		if err := rw.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rw.file.Write(p)
+	rw.size += int64(n)
+	return n, err
+}
+
+func (rw *RotatingWriter) shouldRotate(incoming int64) bool {
+	if rw.size == 0 {
+		return false
+	}
+	if rw.opts.MaxSize > 0 && rw.size+incoming > rw.opts.MaxSize {
+		return true
+	}
+	return rw.opts.Interval > 0 && rotateNow().Sub(rw.opened) >= rw.opts.Interval
+}
+
+// Rotate closes the current file, renames it with a timestamp suffix and
+// opens a new file at the original path.
+func (rw *RotatingWriter) Rotate() error {
+	rw.Lock()
+	defer rw.Unlock()
+	return rw.rotate()
+}
+
+func (rw *RotatingWriter) rotate() error {
+	if err := rw.file.Close(); err != nil {
+		return fmt.Errorf("error closing log file: %v", err)
+	}
+	rw.file = nil
+
+	rotated := rw.rotatedName()
+	if err := os.Rename(rw.path, rotated); err != nil {
+		return fmt.Errorf("error rotating log file: %v", err)
+	}
+	if rw.opts.Compress {
+		// compress synchronously so an exiting tool never leaves a
+		// half-written archive behind
+		if err := compressFile(rotated); err != nil {
+			return err
+		}
+	}
+	if err := rw.removeOldFiles(); err != nil {
+		return err
+	}
+	return rw.open()
+}
+
+// rotatedName returns an unused name for the file being rotated out.
+func (rw *RotatingWriter) rotatedName() string {
+	base := rw.path + "." + rotateNow().Format(rotatedSuffixFormat)
+	name := base
+	for i := 1; fileExists(name) || fileExists(name+".gz"); i++ {
+		name = fmt.Sprintf("%v-%d", base, i)
+	}
+	return name
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// removeOldFiles deletes the oldest rotated files beyond MaxFiles.
+func (rw *RotatingWriter) removeOldFiles() error {
+	if rw.opts.MaxFiles <= 0 {
+		return nil
+	}
+	matches, err := filepath.Glob(rw.path + ".*")
+	if err != nil {
+		return err
+	}
+	var rotated []os.FileInfo
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, rw.path+".")
+		if len(suffix) < len(rotatedSuffixFormat) {
+			continue
+		}
+		if _, err := time.Parse(rotatedSuffixFormat, suffix[:len(rotatedSuffixFormat)]); err != nil {
+			continue
+		}
+		if info, err := os.Stat(match); err == nil {
+			rotated = append(rotated, info)
+		}
+	}
+	// files rotated within the file system's timestamp resolution are
+	// ordered by their names, which hold the time of the rotation
+	sort.Slice(rotated, func(i, j int) bool {
+		if !rotated[i].ModTime().Equal(rotated[j].ModTime()) {
+			return rotated[i].ModTime().Before(rotated[j].ModTime())
+		}
+		return rotated[i].Name() < rotated[j].Name()
+	})
+	dir := filepath.Dir(rw.path)
+	for len(rotated) > rw.opts.MaxFiles {
+		if err := os.Remove(filepath.Join(dir, rotated[0].Name())); err != nil {
+			return fmt.Errorf("error removing old log file: %v", err)
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// Close closes the current file.
+func (rw *RotatingWriter) Close() error {
+	rw.Lock()
+	defer rw.Unlock()
+	if rw.file == nil {
+		return nil
+	}
+	err := rw.file.Close()
+	rw.file = nil
+	return err
+}
+
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening rotated log file: %v", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("error creating compressed log file: %v", err)
+	}
+	gz := gzip.NewWriter(out)
+	if _, err = io.Copy(gz, in); err == nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return fmt.Errorf("error compressing rotated log file: %v", err)
+	}
+	in.Close()
+	return os.Remove(path)
+}
@@ -0,0 +1,171 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRotatingWriter(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a log file in a temporary directory and a fake clock", t, func() {
+		dir, err := ioutil.TempDir("", "log_rotate")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "logs", "tool.log")
+
+		now := time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)
+		savedNow := rotateNow
+		rotateNow = func() time.Time { return now }
+		defer func() { rotateNow = savedNow }()
+
+		read := func(name string) string {
+			content, err := ioutil.ReadFile(name)
+			So(err, ShouldBeNil)
+			return string(content)
+		}
+		// files returns the names of the files next to the log file
+		files := func() []string {
+			infos, err := ioutil.ReadDir(filepath.Dir(path))
+			So(err, ShouldBeNil)
+			var names []string
+			for _, info := range infos {
+				names = append(names, info.Name())
+			}
+			sort.Strings(names)
+			return names
+		}
+		write := func(rw *RotatingWriter, s string) {
+			_, err := rw.Write([]byte(s))
+			So(err, ShouldBeNil)
+		}
+
+		Convey("a write that would exceed the size limit should go to a new file", func() {
+			rw, err := NewRotatingWriter(path, RotateOptions{MaxSize: 10})
+			So(err, ShouldBeNil)
+			defer rw.Close()
+			write(rw, "12345678\n")
+			write(rw, "a\n")
+			So(files(), ShouldResemble, []string{"tool.log", "tool.log.2020-01-02T03-04-05.006"})
+			So(read(path), ShouldEqual, "a\n")
+			So(read(path+".2020-01-02T03-04-05.006"), ShouldEqual, "12345678\n")
+		})
+
+		Convey("a write larger than the limit should not rotate an empty file", func() {
+			rw, err := NewRotatingWriter(path, RotateOptions{MaxSize: 4})
+			So(err, ShouldBeNil)
+			defer rw.Close()
+			write(rw, "longer than four bytes\n")
+			So(files(), ShouldResemble, []string{"tool.log"})
+		})
+
+		Convey("the size of an existing file should count towards the limit", func() {
+			So(os.MkdirAll(filepath.Dir(path), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(path, []byte("12345678\n"), 0644), ShouldBeNil)
+			rw, err := NewRotatingWriter(path, RotateOptions{MaxSize: 10})
+			So(err, ShouldBeNil)
+			defer rw.Close()
+			write(rw, "a\n")
+			So(read(path), ShouldEqual, "a\n")
+			So(len(files()), ShouldEqual, 2)
+		})
+
+		Convey("a file older than the interval should be rotated on the next write", func() {
+			rw, err := NewRotatingWriter(path, RotateOptions{Interval: time.Hour})
+			So(err, ShouldBeNil)
+			defer rw.Close()
+			write(rw, "first\n")
+			now = now.Add(59 * time.Minute)
+			write(rw, "second\n")
+			So(files(), ShouldResemble, []string{"tool.log"})
+
+			now = now.Add(time.Minute)
+			write(rw, "third\n")
+			So(files(), ShouldResemble, []string{"tool.log", "tool.log.2020-01-02T04-04-05.006"})
+			So(read(path), ShouldEqual, "third\n")
+
+			// the interval restarts with the new file
+			now = now.Add(30 * time.Minute)
+			write(rw, "fourth\n")
+			So(read(path), ShouldEqual, "third\nfourth\n")
+		})
+
+		Convey("files rotated at the same time should get distinct names", func() {
+			rw, err := NewRotatingWriter(path, RotateOptions{})
+			So(err, ShouldBeNil)
+			defer rw.Close()
+			for _, s := range []string{"a\n", "b\n", "c\n"} {
+				write(rw, s)
+				So(rw.Rotate(), ShouldBeNil)
+			}
+			So(files(), ShouldResemble, []string{
+				"tool.log",
+				"tool.log.2020-01-02T03-04-05.006",
+				"tool.log.2020-01-02T03-04-05.006-1",
+				"tool.log.2020-01-02T03-04-05.006-2",
+			})
+			So(read(path+".2020-01-02T03-04-05.006-2"), ShouldEqual, "c\n")
+		})
+
+		Convey("only the newest MaxFiles rotated files should be kept", func() {
+			rw, err := NewRotatingWriter(path, RotateOptions{MaxFiles: 2})
+			So(err, ShouldBeNil)
+			defer rw.Close()
+			So(ioutil.WriteFile(path+".notes", []byte("not a rotated file"), 0644), ShouldBeNil)
+			for i := 0; i < 4; i++ {
+				write(rw, "line\n")
+				So(rw.Rotate(), ShouldBeNil)
+				now = now.Add(time.Second)
+			}
+			So(files(), ShouldResemble, []string{
+				"tool.log",
+				"tool.log.2020-01-02T03-04-07.006",
+				"tool.log.2020-01-02T03-04-08.006",
+				"tool.log.notes",
+			})
+		})
+
+		Convey("rotated files should be compressed if requested", func() {
+			rw, err := NewRotatingWriter(path, RotateOptions{Compress: true, MaxFiles: 1})
+			So(err, ShouldBeNil)
+			defer rw.Close()
+			write(rw, "compressed\n")
+			So(rw.Rotate(), ShouldBeNil)
+			now = now.Add(time.Second)
+			write(rw, "also compressed\n")
+			So(rw.Rotate(), ShouldBeNil)
+			So(files(), ShouldResemble, []string{"tool.log", "tool.log.2020-01-02T03-04-06.006.gz"})
+
+			file, err := os.Open(path + ".2020-01-02T03-04-06.006.gz")
+			So(err, ShouldBeNil)
+			defer file.Close()
+			gz, err := gzip.NewReader(file)
+			So(err, ShouldBeNil)
+			content, err := ioutil.ReadAll(gz)
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "also compressed\n")
+		})
+
+		Convey("writes after Close should fail", func() {
+			rw, err := NewRotatingWriter(path, RotateOptions{})
+			So(err, ShouldBeNil)
+			So(rw.Close(), ShouldBeNil)
+			_, err = rw.Write([]byte("late\n"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
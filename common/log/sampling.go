@@ -0,0 +1,86 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// sampler keeps one in every rate debug or trace messages of a component.
+type sampler struct {
+	rate  uint64
+	count uint64
+}
+
+// ParseSampling parses a comma-separated list of component=N pairs, e.g.
+// "restore=1000", meaning that one in every N debug and trace messages for
+// the component is written.
+func ParseSampling(spec string) (map[string]int, error) {
+	rates := map[string]int{}
+	if spec == "" {
+		return rates, nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid log sampling '%v', expected <component>=<N>", pair)
+		}
+		name := parts[0]
+		if !isKnownComponent(name) {
+			return nil, fmt.Errorf("unknown log component '%v' (known components: %v)",
+				name, strings.Join(RegisteredComponents(), ", "))
+		}
+		rate, err := strconv.Atoi(parts[1])
+		if err != nil || rate < 1 {
+			return nil, fmt.Errorf("invalid sampling rate '%v' for component '%v', expected a positive number", parts[1], name)
+		}
+		rates[name] = rate
+	}
+	return rates, nil
+}
+
+// SetSampling sets the sampling rates of the given components. Only debug
+// and trace messages are sampled; errors, warnings and messages at the
+// default or info verbosity are always written. Components inherit the
+// rate of their closest configured parent.
+func (tl *ToolLogger) SetSampling(rates map[string]int) {
+	samplers := make(map[string]*sampler, len(rates))
+	for name, rate := range rates {
+		samplers[name] = &sampler{rate: uint64(rate)}
+	}
+	tl.samplers = samplers
+}
+
+func SetSampling(rates map[string]int) {
+	globalToolLogger.SetSampling(rates)
+}
+
+// sample reports whether an entry should be written and, if it is subject
+// to sampling, the rate to record with it.
+func (tl *ToolLogger) sample(component string, severity Severity) (bool, int) {
+	if len(tl.samplers) == 0 || (severity != SeverityDebug && severity != SeverityTrace) {
+		return true, 0
+	}
+	for component != "" {
+		if s, ok := tl.samplers[component]; ok {
+			if s.rate == 1 {
+				return true, 0
+			}
+			n := atomic.AddUint64(&s.count, 1)
+			return (n-1)%s.rate == 0, int(s.rate)
+		}
+		idx := strings.LastIndex(component, ".")
+		if idx < 0 {
+			break
+		}
+		component = component[:idx]
+	}
+	return true, 0
+}
@@ -0,0 +1,112 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// formatCounter counts how often it is formatted.
+type formatCounter struct {
+	count int
+}
+
+func (c *formatCounter) String() string {
+	c.count++
+	return "counted"
+}
+
+func TestParseSampling(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	RegisterComponent("sampletest.documents")
+
+	Convey("Sampling rates should be parsed per component", t, func() {
+		rates, err := ParseSampling("sampletest=10, sampletest.documents=1000")
+		So(err, ShouldBeNil)
+		So(rates, ShouldResemble, map[string]int{"sampletest": 10, "sampletest.documents": 1000})
+
+		rates, err = ParseSampling("")
+		So(err, ShouldBeNil)
+		So(rates, ShouldBeEmpty)
+	})
+
+	Convey("Invalid sampling rates should be rejected", t, func() {
+		for spec, message := range map[string]string{
+			"sampletest":          "invalid log sampling 'sampletest', expected <component>=<N>",
+			"=10":                 "invalid log sampling '=10', expected <component>=<N>",
+			"sampletest.other=10": "unknown log component 'sampletest.other'",
+			"sampletest=0":        "invalid sampling rate '0' for component 'sampletest', expected a positive number",
+			"sampletest=often":    "invalid sampling rate 'often' for component 'sampletest', expected a positive number",
+		} {
+			_, err := ParseSampling(spec)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, message)
+		}
+	})
+}
+
+func TestSampling(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a logger sampling a component", t, func() {
+		tl, buf := newTestLogger(DebugHigh)
+		tl.SetSampling(map[string]int{"import": 3, "import.quiet": 1})
+		documents := tl.Component("import.documents")
+
+		Convey("one in every N debug and trace messages should be written, with the rate", func() {
+			for i := 0; i < 7; i++ {
+				documents.Logvf(DebugLow, "document %v", i)
+			}
+			So(buf.String(), ShouldEqual,
+				"document 0 sampleRate=3\ndocument 3 sampleRate=3\ndocument 6 sampleRate=3\n")
+		})
+
+		Convey("the rate should be attached to the message's other fields", func() {
+			for i := 0; i < 4; i++ {
+				documents.WithFields("ns", "db.c").Logv(DebugHigh, "document")
+			}
+			tl.SetFormatter(JSONFormatter{})
+			documents.Logkv(DebugHigh, "document", "n", 4)
+			So(buf.String(), ShouldEqual, "document ns=db.c sampleRate=3\ndocument ns=db.c sampleRate=3\n")
+
+			documents.Logkv(DebugHigh, "document", "n", 5)
+			documents.Logkv(DebugHigh, "document", "n", 6)
+			So(buf.String(), ShouldEndWith, `{"level":"trace","component":"import.documents","msg":"document","attr":{"n":6,"sampleRate":3}}`+"\n")
+		})
+
+		Convey("a child should use its own rate over its parent's", func() {
+			for i := 0; i < 3; i++ {
+				tl.Component("import.quiet").Logvf(DebugLow, "quiet %v", i)
+			}
+			So(buf.String(), ShouldEqual, "quiet 0\nquiet 1\nquiet 2\n")
+		})
+
+		Convey("other components and messages that aren't debug or trace should not be sampled", func() {
+			for i := 0; i < 3; i++ {
+				tl.Component("export").Logvf(DebugLow, "export %v", i)
+				documents.Logvf(Info, "info %v", i)
+				documents.WithFields().Warnf("warning %v", i)
+			}
+			So(buf.String(), ShouldEqual, "export 0\ninfo 0\nwarning 0\n"+
+				"export 1\ninfo 1\nwarning 1\n"+
+				"export 2\ninfo 2\nwarning 2\n")
+		})
+
+		Convey("dropped messages should not be formatted", func() {
+			counter := &formatCounter{}
+			for i := 0; i < 6; i++ {
+				documents.Logvf(DebugLow, "%v", counter)
+				documents.WithFields("ns", "db.c").Logvf(DebugLow, "%v", counter)
+			}
+			So(counter.count, ShouldEqual, 4)
+		})
+	})
+}
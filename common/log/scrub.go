@@ -0,0 +1,183 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// scrubber replaces the values of configured document fields with keyed
+// hashes, so that equal values can still be correlated within a run
+// without the values themselves reaching the log.
+type scrubber struct {
+	// lower-cased field names matched at any depth
+	names map[string]bool
+	// lower-cased dotted paths matched from the top of the document
+	paths map[string]bool
+	key   []byte
+}
+
+// SetScrubFields sets the document fields whose values are replaced with
+// hashes in log output. A name such as "ssn" matches the field at any
+// depth, while a dotted path such as "profile.ssn" only matches that field.
+// Matching is case-insensitive. An empty list disables scrubbing.
+func (tl *ToolLogger) SetScrubFields(fields []string) {
+	if len(fields) == 0 {
+		tl.scrubber = nil
+		return
+	}
+	s := &scrubber{names: map[string]bool{}, paths: map[string]bool{}, key: make([]byte, 32)}
+	for _, field := range fields {
+		field = strings.ToLower(strings.TrimSpace(field))
+		switch {
+		case field == "":
+		case strings.Contains(field, "."):
+			s.paths[field] = true
+		default:
+			s.names[field] = true
+		}
+	}
+	// a fresh key per run keeps hashes of guessable values from being
+	// looked up across runs
+	rand.Read(s.key)
+	tl.scrubber = s
+}
+
+// Document wraps a BSON document (bson.D, bson.M, bson.A or a map) for
+// logging, so that scrubbed fields are hashed when it is formatted. The
+// document is only walked if the message is actually written.
+func (tl *ToolLogger) Document(doc interface{}) interface{} {
+	if tl.scrubber == nil {
+		return doc
+	}
+	return scrubbedDocument{tl.scrubber, doc}
+}
+
+// ScrubField returns the value to log for the named field: a hash if the
+// field is scrubbed, and the value itself otherwise. Documents nested in the
+// value are scrubbed too.
+func (tl *ToolLogger) ScrubField(name string, value interface{}) interface{} {
+	if tl.scrubber == nil {
+		return value
+	}
+	return tl.scrubber.field(strings.ToLower(name), strings.ToLower(name), value)
+}
+
+type scrubbedDocument struct {
+	scrubber *scrubber
+	doc      interface{}
+}
+
+// Format formats the scrubbed document with the same verb and flags.
+func (d scrubbedDocument) Format(f fmt.State, verb rune) {
+	format := "%"
+	for _, flag := range "+-# 0" {
+		if f.Flag(int(flag)) {
+			format += string(flag)
+		}
+	}
+	if width, ok := f.Width(); ok {
+		format += fmt.Sprint(width)
+	}
+	if precision, ok := f.Precision(); ok {
+		format += "." + fmt.Sprint(precision)
+	}
+	fmt.Fprintf(f, format+string(verb), d.scrubber.value("", d.doc))
+}
+
+func (s *scrubber) hash(value interface{}) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%v", value)
+	return "<scrubbed:" + hex.EncodeToString(mac.Sum(nil)[:6]) + ">"
+}
+
+// field returns the value to log for a field with the given lower-cased
+// name and path.
+func (s *scrubber) field(name, path string, value interface{}) interface{} {
+	if s.names[name] || s.paths[path] {
+		return s.hash(value)
+	}
+	return s.value(path, value)
+}
+
+// value returns a scrubbed copy of value if it is a document or array, and
+// value itself otherwise. Array elements share the path of the array.
+func (s *scrubber) value(path string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case primitive.D:
+		scrubbed := make(primitive.D, len(v))
+		for i, elem := range v {
+			scrubbed[i] = primitive.E{Key: elem.Key, Value: s.child(path, elem.Key, elem.Value)}
+		}
+		return scrubbed
+	case primitive.M:
+		scrubbed := make(primitive.M, len(v))
+		for key, elem := range v {
+			scrubbed[key] = s.child(path, key, elem)
+		}
+		return scrubbed
+	case map[string]interface{}:
+		scrubbed := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			scrubbed[key] = s.child(path, key, elem)
+		}
+		return scrubbed
+	case primitive.A:
+		scrubbed := make(primitive.A, len(v))
+		for i, elem := range v {
+			scrubbed[i] = s.value(path, elem)
+		}
+		return scrubbed
+	case []interface{}:
+		scrubbed := make([]interface{}, len(v))
+		for i, elem := range v {
+			scrubbed[i] = s.value(path, elem)
+		}
+		return scrubbed
+	}
+	return value
+}
+
+func (s *scrubber) child(path, key string, value interface{}) interface{} {
+	key = strings.ToLower(key)
+	if path != "" {
+		path += "."
+	}
+	return s.field(key, path+key, value)
+}
+
+// scrubFields hashes entry fields whose keys are scrubbed, and scrubs
+// documents passed as field values. Paths in such documents start from the
+// document, not from the entry field.
+func (s *scrubber) scrubFields(fields []Field) {
+	for i, field := range fields {
+		if s.names[strings.ToLower(field.Key)] {
+			fields[i].Value = s.hash(field.Value)
+		} else {
+			fields[i].Value = s.value("", field.Value)
+		}
+	}
+}
+
+func SetScrubFields(fields []string) {
+	globalToolLogger.SetScrubFields(fields)
+}
+
+func Document(doc interface{}) interface{} {
+	return globalToolLogger.Document(doc)
+}
+
+func ScrubField(name string, value interface{}) interface{} {
+	return globalToolLogger.ScrubField(name, value)
+}
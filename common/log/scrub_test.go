@@ -0,0 +1,99 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var scrubbedPattern = regexp.MustCompile(`^<scrubbed:[0-9a-f]{12}>$`)
+
+func TestScrubber(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With scrubbed fields", t, func() {
+		tl, buf := newTestLogger(Info)
+		tl.SetScrubFields([]string{"SSN", " profile.email ", ""})
+
+		Convey("entry fields with a scrubbed name should be hashed", func() {
+			fields := []Field{{Key: "ssn", Value: "123-45-6789"}, {Key: "Ssn", Value: 42}, {Key: "name", Value: "Ann"}}
+			tl.scrubber.scrubFields(fields)
+			So(scrubbedPattern.MatchString(fields[0].Value.(string)), ShouldBeTrue)
+			So(scrubbedPattern.MatchString(fields[1].Value.(string)), ShouldBeTrue)
+			So(fields[2].Value, ShouldEqual, "Ann")
+
+			tl.Logkv(Always, "document", "ssn", "123-45-6789")
+			So(buf.String(), ShouldEqual, fmt.Sprintf("document ssn=%v\n", fields[0].Value))
+		})
+
+		Convey("hashes should be stable within a run and differ between values", func() {
+			first := tl.ScrubField("ssn", "123-45-6789")
+			So(tl.ScrubField("SSN", "123-45-6789"), ShouldEqual, first)
+			So(tl.ScrubField("ssn", "987-65-4321"), ShouldNotEqual, first)
+
+			// each run hashes with its own key
+			tl.SetScrubFields([]string{"ssn"})
+			So(tl.ScrubField("ssn", "123-45-6789"), ShouldNotEqual, first)
+		})
+
+		Convey("names should match at any depth, and paths only from the top", func() {
+			doc := bson.D{
+				{Key: "ssn", Value: "1"},
+				{Key: "email", Value: "top@example.com"},
+				{Key: "profile", Value: bson.D{{Key: "email", Value: "a@example.com"}, {Key: "ssn", Value: "2"}, {Key: "name", Value: "Ann"}}},
+				{Key: "other", Value: bson.M{"profile": bson.M{"email": "nested@example.com"}}},
+				{Key: "list", Value: bson.A{bson.M{"ssn": "3"}, []interface{}{map[string]interface{}{"SSN": "4"}}}},
+			}
+			fields := []Field{{Key: "doc", Value: doc}}
+			tl.scrubber.scrubFields(fields)
+			scrubbed := fields[0].Value.(bson.D)
+
+			So(scrubbedPattern.MatchString(scrubbed[0].Value.(string)), ShouldBeTrue)
+			So(scrubbed[1].Value, ShouldEqual, "top@example.com")
+			profile := scrubbed[2].Value.(bson.D)
+			So(scrubbedPattern.MatchString(profile[0].Value.(string)), ShouldBeTrue)
+			So(scrubbedPattern.MatchString(profile[1].Value.(string)), ShouldBeTrue)
+			So(profile[2].Value, ShouldEqual, "Ann")
+			So(scrubbed[3].Value, ShouldResemble, bson.M{"profile": bson.M{"email": "nested@example.com"}})
+			list := scrubbed[4].Value.(bson.A)
+			So(scrubbedPattern.MatchString(list[0].(bson.M)["ssn"].(string)), ShouldBeTrue)
+			So(scrubbedPattern.MatchString(list[1].([]interface{})[0].(map[string]interface{})["SSN"].(string)), ShouldBeTrue)
+
+			// the logged document is a copy
+			So(doc[0].Value, ShouldEqual, "1")
+			So(doc[2].Value.(bson.D)[0].Value, ShouldEqual, "a@example.com")
+		})
+
+		Convey("a whole scrubbed subdocument should be hashed as one value", func() {
+			tl.SetScrubFields([]string{"profile"})
+			value := tl.ScrubField("user", bson.D{{Key: "profile", Value: bson.D{{Key: "email", Value: "a@example.com"}}}})
+			So(scrubbedPattern.MatchString(value.(bson.D)[0].Value.(string)), ShouldBeTrue)
+		})
+
+		Convey("documents should be scrubbed when they are formatted", func() {
+			tl.Logvf(Always, "got %v", tl.Document(bson.D{{Key: "ssn", Value: "1"}, {Key: "name", Value: "Ann"}}))
+			So(buf.String(), ShouldStartWith, "got [{ssn <scrubbed:")
+			So(buf.String(), ShouldEndWith, ">} {name Ann}]\n")
+			So(buf.String(), ShouldNotContainSubstring, "{ssn 1}")
+		})
+	})
+
+	Convey("Without scrubbed fields, values should be logged as they are", t, func() {
+		tl, _ := newTestLogger(Info)
+		tl.SetScrubFields([]string{"ssn"})
+		tl.SetScrubFields(nil)
+		doc := bson.D{{Key: "ssn", Value: "1"}}
+		So(tl.Document(doc), ShouldResemble, doc)
+		So(tl.ScrubField("ssn", "1"), ShouldEqual, "1")
+	})
+}
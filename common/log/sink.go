@@ -0,0 +1,63 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Names of the supported log destinations
+const (
+	StderrDestination   = "stderr"
+	FileDestination     = "file"
+	SyslogDestination   = "syslog"
+	EventLogDestination = "eventlog"
+)
+
+// Sink receives log entries in place of the logger's writer. Sinks are used
+// for destinations that need more than formatted bytes, such as system
+// loggers that record each entry's severity.
+type Sink interface {
+	WriteEntry(entry *Entry) error
+	Close() error
+}
+
+// SetSink sends all log entries to the given sink. Passing nil restores
+// output to the logger's writer.
+func (tl *ToolLogger) SetSink(sink Sink) {
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+	tl.sink = sink
+}
+
+func SetSink(sink Sink) {
+	globalToolLogger.SetSink(sink)
+}
+
+// NewSystemSink opens a sink for the given system log destination, tagging
+// entries with the tool name.
+func NewSystemSink(destination, toolName string, formatter Formatter) (Sink, error) {
+	switch destination {
+	case SyslogDestination:
+		return newSyslogSink(toolName, formatter)
+	case EventLogDestination:
+		return newEventLogSink(toolName, formatter)
+	}
+	return nil, fmt.Errorf("'%v' is not a system log destination", destination)
+}
+
+// systemMessage renders an entry for a system logger, which records its own
+// timestamp and severity.
+func systemMessage(entry *Entry, formatter Formatter) string {
+	if _, isText := formatter.(TextFormatter); !isText && formatter != nil {
+		return string(bytes.TrimRight(formatter.Format(entry), "\n"))
+	}
+	buf := &bytes.Buffer{}
+	writeMessage(buf, entry)
+	return buf.String()
+}
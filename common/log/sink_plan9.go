@@ -0,0 +1,19 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"fmt"
+)
+
+func newSyslogSink(string, Formatter) (Sink, error) {
+	return nil, fmt.Errorf("syslog is not available on this platform")
+}
+
+func newEventLogSink(string, Formatter) (Sink, error) {
+	return nil, fmt.Errorf("the Windows event log is only available on Windows")
+}
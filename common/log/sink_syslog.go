@@ -0,0 +1,51 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package log
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// syslogSink writes entries to the local syslog daemon.
+type syslogSink struct {
+	writer    *syslog.Writer
+	formatter Formatter
+}
+
+func newSyslogSink(tag string, formatter Formatter) (Sink, error) {
+	writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, tag)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to syslog: %v", err)
+	}
+	return &syslogSink{writer: writer, formatter: formatter}, nil
+}
+
+func (s *syslogSink) WriteEntry(entry *Entry) error {
+	msg := systemMessage(entry, s.formatter)
+	switch entry.Severity {
+	case SeverityError:
+		return s.writer.Err(msg)
+	case SeverityWarning:
+		return s.writer.Warning(msg)
+	case SeverityInfo:
+		return s.writer.Info(msg)
+	default:
+		return s.writer.Debug(msg)
+	}
+}
+
+func (s *syslogSink) Close() error {
+	return s.writer.Close()
+}
+
+func newEventLogSink(string, Formatter) (Sink, error) {
+	return nil, fmt.Errorf("the Windows event log is only available on Windows")
+}
@@ -0,0 +1,59 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// toolEventID is the event identifier reported for every tool log entry.
+const toolEventID = 1
+
+// eventLogSink writes entries to the Windows application event log.
+type eventLogSink struct {
+	handle    windows.Handle
+	formatter Formatter
+}
+
+func newEventLogSink(source string, formatter Formatter) (Sink, error) {
+	sourcePtr, err := windows.UTF16PtrFromString(source)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := windows.RegisterEventSource(nil, sourcePtr)
+	if err != nil {
+		return nil, fmt.Errorf("error registering event source: %v", err)
+	}
+	return &eventLogSink{handle: handle, formatter: formatter}, nil
+}
+
+func (s *eventLogSink) WriteEntry(entry *Entry) error {
+	// the event log has no debug levels, so everything below a warning is
+	// reported as information
+	eventType := uint16(windows.EVENTLOG_INFORMATION_TYPE)
+	switch entry.Severity {
+	case SeverityError:
+		eventType = windows.EVENTLOG_ERROR_TYPE
+	case SeverityWarning:
+		eventType = windows.EVENTLOG_WARNING_TYPE
+	}
+	msg, err := windows.UTF16PtrFromString(systemMessage(entry, s.formatter))
+	if err != nil {
+		return err
+	}
+	return windows.ReportEvent(s.handle, eventType, 0, toolEventID, 0, 1, 0, &msg, nil)
+}
+
+func (s *eventLogSink) Close() error {
+	return windows.DeregisterEventSource(s.handle)
+}
+
+func newSyslogSink(string, Formatter) (Sink, error) {
+	return nil, fmt.Errorf("syslog is not available on Windows")
+}
@@ -0,0 +1,183 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+//go:build go1.21
+// +build go1.21
+
+package log
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// LevelTrace is the slog level used for DebugHigh messages.
+const LevelTrace = slog.LevelDebug - 4
+
+// SlogLevel maps a tool verbosity level onto a slog level.
+func SlogLevel(minVerb int) slog.Level {
+	return slogLevelForSeverity(severityForVerbosity(minVerb))
+}
+
+func slogLevelForSeverity(severity Severity) slog.Level {
+	switch severity {
+	case SeverityError:
+		return slog.LevelError
+	case SeverityWarning:
+		return slog.LevelWarn
+	case SeverityInfo:
+		return slog.LevelInfo
+	case SeverityDebug:
+		return slog.LevelDebug
+	default:
+		return LevelTrace
+	}
+}
+
+// VerbosityForSlogLevel maps a slog level onto the minimum tool verbosity at
+// which records of that level are written. Warnings and errors are always
+// written.
+func VerbosityForSlogLevel(level slog.Level) int {
+	switch {
+	case level >= slog.LevelWarn:
+		return Always
+	case level >= slog.LevelInfo:
+		return Info
+	case level >= slog.LevelDebug:
+		return DebugLow
+	default:
+		return DebugHigh
+	}
+}
+
+func severityForSlogLevel(level slog.Level) Severity {
+	switch {
+	case level >= slog.LevelError:
+		return SeverityError
+	case level >= slog.LevelWarn:
+		return SeverityWarning
+	default:
+		return severityForVerbosity(VerbosityForSlogLevel(level))
+	}
+}
+
+// Slog returns a *slog.Logger that writes through the tool logger, honoring
+// its verbosity, format and redaction settings.
+func (tl *ToolLogger) Slog() *slog.Logger {
+	return slog.New(&toolHandler{logger: tl})
+}
+
+// SetHandler forwards every log entry to the given slog handler instead of
+// the logger's writer. Passing nil restores normal output.
+func (tl *ToolLogger) SetHandler(handler slog.Handler) {
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+	if th, ok := handler.(*toolHandler); handler == nil || (ok && th.logger == tl) {
+		// a handler writing back into this logger would recurse forever
+		tl.sink = nil
+		return
+	}
+	tl.sink = &slogSink{handler}
+}
+
+func Slog() *slog.Logger {
+	return globalToolLogger.Slog()
+}
+
+func SetHandler(handler slog.Handler) {
+	globalToolLogger.SetHandler(handler)
+}
+
+// slogSink converts tool log entries into slog records.
+type slogSink struct {
+	handler slog.Handler
+}
+
+func (h *slogSink) WriteEntry(entry *Entry) error {
+	ctx := context.Background()
+	level := slogLevelForSeverity(entry.Severity)
+	if !h.handler.Enabled(ctx, level) {
+		return nil
+	}
+	record := slog.NewRecord(entry.Time, level, entry.Message, 0)
+	if entry.Tool != "" {
+		record.AddAttrs(slog.String("tool", entry.Tool))
+	}
+	if entry.Component != "" {
+		record.AddAttrs(slog.String("component", entry.Component))
+	}
+	for _, field := range entry.Fields {
+		record.AddAttrs(slog.Any(field.Key, field.Value))
+	}
+	return h.handler.Handle(ctx, record)
+}
+
+func (h *slogSink) Close() error {
+	return nil
+}
+
+// toolHandler is a slog.Handler that writes records to a ToolLogger.
+type toolHandler struct {
+	logger *ToolLogger
+	attrs  []Field
+	group  string
+}
+
+func (h *toolHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.enabled("", VerbosityForSlogLevel(level))
+}
+
+func (h *toolHandler) Handle(_ context.Context, record slog.Record) error {
+	fields := make([]Field, 0, len(h.attrs)+record.NumAttrs())
+	fields = append(fields, h.attrs...)
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = appendSlogAttr(fields, h.group, attr)
+		return true
+	})
+	h.logger.log("", VerbosityForSlogLevel(record.Level), severityForSlogLevel(record.Level), record.Message, fields)
+	return nil
+}
+
+func (h *toolHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := append([]Field{}, h.attrs...)
+	for _, attr := range attrs {
+		fields = appendSlogAttr(fields, h.group, attr)
+	}
+	return &toolHandler{logger: h.logger, attrs: fields, group: h.group}
+}
+
+func (h *toolHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &toolHandler{logger: h.logger, attrs: h.attrs, group: joinGroup(h.group, name)}
+}
+
+// appendSlogAttr flattens an attribute, joining nested group keys with dots.
+func appendSlogAttr(fields []Field, group string, attr slog.Attr) []Field {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		for _, nested := range value.Group() {
+			fields = appendSlogAttr(fields, joinGroup(group, attr.Key), nested)
+		}
+		return fields
+	}
+	if attr.Key == "" {
+		return fields
+	}
+	return append(fields, Field{Key: joinGroup(group, attr.Key), Value: value.Any()})
+}
+
+func joinGroup(group, key string) string {
+	if group == "" {
+		return key
+	}
+	if key == "" {
+		return group
+	}
+	return strings.Join([]string{group, key}, ".")
+}
@@ -0,0 +1,218 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RunSummary accumulates the outcome of a tool run: counters, per-namespace
+// results, failures and skipped namespaces. It is written as a single JSON
+// document when the tool finishes. All methods are safe to call on a nil
+// RunSummary, which is what Summary returns when no report was requested.
+type RunSummary struct {
+	mutex      sync.Mutex
+	path       string
+	start      time.Time
+	counters   map[string]int64
+	namespaces []*namespaceSummary
+	byName     map[string]*namespaceSummary
+	failures   []summaryFailure
+	skipped    []summarySkip
+	written    bool
+}
+
+type namespaceSummary struct {
+	Namespace  string `json:"ns"`
+	Documents  int64  `json:"documents"`
+	Failures   int64  `json:"failures,omitempty"`
+	DurationMS int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+type summaryFailure struct {
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+}
+
+type summarySkip struct {
+	Namespace string `json:"ns"`
+	Reason    string `json:"reason"`
+}
+
+type summaryReport struct {
+	Tool       string              `json:"tool"`
+	Start      string              `json:"start"`
+	End        string              `json:"end"`
+	DurationMS int64               `json:"durationMs"`
+	ExitCode   int                 `json:"exitCode"`
+	Counters   map[string]int64    `json:"counters"`
+	Namespaces []*namespaceSummary `json:"namespaces"`
+	Failures   []summaryFailure    `json:"failures"`
+	Skipped    []summarySkip       `json:"skipped"`
+}
+
+// EnableSummary starts accumulating a run summary. When the tool finishes,
+// WriteSummary writes it to the file at path, or as a single line on the
+// log output if path is empty.
+func (tl *ToolLogger) EnableSummary(path string) {
+	tl.summary = &RunSummary{
+		path:     path,
+		start:    time.Now(),
+		counters: map[string]int64{},
+		byName:   map[string]*namespaceSummary{},
+
+		// empty rather than nil, so the report has [] instead of null
+		namespaces: []*namespaceSummary{},
+		failures:   []summaryFailure{},
+		skipped:    []summarySkip{},
+	}
+}
+
+// Summary returns the run summary, or nil if summaries are not enabled.
+func (tl *ToolLogger) Summary() *RunSummary {
+	return tl.summary
+}
+
+// AddCount adds delta to the named counter.
+func (s *RunSummary) AddCount(counter string, delta int64) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.counters[counter] += delta
+}
+
+// AddNamespace records the result of processing a namespace. Results for a
+// namespace that was already recorded are added to the existing ones.
+func (s *RunSummary) AddNamespace(ns string, documents, failures int64, duration time.Duration, err error) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	summary, ok := s.byName[ns]
+	if !ok {
+		summary = &namespaceSummary{Namespace: ns}
+		s.byName[ns] = summary
+		s.namespaces = append(s.namespaces, summary)
+	}
+	summary.Documents += documents
+	summary.Failures += failures
+	summary.DurationMS += int64(duration / time.Millisecond)
+	if err != nil {
+		summary.Error = Redact(err.Error())
+	}
+}
+
+// ClearNamespaceError removes the error recorded for a namespace, once it
+// has been processed again without one.
+func (s *RunSummary) ClearNamespaceError(ns string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if summary, ok := s.byName[ns]; ok {
+		summary.Error = ""
+	}
+}
+
+// AddFailure records a failure with its error code, if it has one.
+func (s *RunSummary) AddFailure(msg, code string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failures = append(s.failures, summaryFailure{Redact(msg), code})
+}
+
+// AddSkipped records a namespace that was deliberately not processed.
+func (s *RunSummary) AddSkipped(ns, reason string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.skipped = append(s.skipped, summarySkip{ns, reason})
+}
+
+// WriteSummary writes the run summary, if enabled, along with the tool's
+// exit code. The summary is only written once, so later calls do nothing.
+func (tl *ToolLogger) WriteSummary(exitCode int) error {
+	s := tl.summary
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.written {
+		return nil
+	}
+	s.written = true
+
+	end := time.Now()
+	report := summaryReport{
+		Tool:       tl.toolName,
+		Start:      s.start.Format(time.RFC3339Nano),
+		End:        end.Format(time.RFC3339Nano),
+		DurationMS: int64(end.Sub(s.start) / time.Millisecond),
+		ExitCode:   exitCode,
+		Counters:   s.counters,
+		Namespaces: s.namespaces,
+		Failures:   s.failures,
+		Skipped:    s.skipped,
+	}
+	sort.SliceStable(report.Namespaces, func(i, j int) bool {
+		return report.Namespaces[i].Namespace < report.Namespaces[j].Namespace
+	})
+	line, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if s.path != "" {
+		return writeSummaryFile(s.path, line)
+	}
+	// bypass verbosity and formatting, since quiet mode hides everything else
+	tl.Flush()
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+	_, err = tl.writer.Write(line)
+	return err
+}
+
+func writeSummaryFile(path string, line []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func EnableSummary(path string) {
+	globalToolLogger.EnableSummary(path)
+}
+
+func Summary() *RunSummary {
+	return globalToolLogger.Summary()
+}
+
+func WriteSummary(exitCode int) error {
+	return globalToolLogger.WriteSummary(exitCode)
+}
@@ -0,0 +1,130 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRunSummary(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a directory for report files", t, func() {
+		dir, err := ioutil.TempDir("", "log_summary")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "report.json")
+
+		tl, buf := newTestLogger(Info)
+		tl.SetToolName("mongorestore")
+
+		Convey("--reportFile should get the counts, namespaces, failures and exit code as JSON", func() {
+			tl.EnableSummary(path)
+			summary := tl.Summary()
+			summary.AddCount("documents", 3)
+			summary.AddCount("documents", 4)
+			summary.AddCount("indexes", 1)
+			summary.AddNamespace("db.b", 5, 0, 2*time.Second, nil)
+			summary.AddNamespace("db.a", 2, 1, time.Second, errors.New("failed on mongodb://u:p@host"))
+			summary.AddNamespace("db.a", 1, 0, 500*time.Millisecond, nil)
+			summary.AddNamespace("db.c", 0, 0, 0, errors.New("index build failed"))
+			summary.ClearNamespaceError("db.c")
+			summary.AddFailure("duplicate key --password=secret", "E11000")
+			summary.AddSkipped("db.system.profile", "system collection")
+			So(tl.WriteSummary(3), ShouldBeNil)
+			So(buf.String(), ShouldBeEmpty)
+
+			content, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			var report summaryReport
+			So(json.Unmarshal(content, &report), ShouldBeNil)
+			So(report.Tool, ShouldEqual, "mongorestore")
+			So(report.ExitCode, ShouldEqual, 3)
+			So(report.Counters, ShouldResemble, map[string]int64{"documents": 7, "indexes": 1})
+			So(report.Namespaces, ShouldResemble, []*namespaceSummary{
+				{Namespace: "db.a", Documents: 3, Failures: 1, DurationMS: 1500, Error: "failed on mongodb://u:<redacted>@host"},
+				{Namespace: "db.b", Documents: 5, DurationMS: 2000},
+				{Namespace: "db.c"},
+			})
+			So(report.Failures, ShouldResemble, []summaryFailure{{"duplicate key --password=<redacted>", "E11000"}})
+			So(report.Skipped, ShouldResemble, []summarySkip{{"db.system.profile", "system collection"}})
+			start, err := time.Parse(time.RFC3339Nano, report.Start)
+			So(err, ShouldBeNil)
+			end, err := time.Parse(time.RFC3339Nano, report.End)
+			So(err, ShouldBeNil)
+			So(end.Before(start), ShouldBeFalse)
+
+			Convey("and the summary should only be written once", func() {
+				So(os.Remove(path), ShouldBeNil)
+				So(tl.WriteSummary(0), ShouldBeNil)
+				_, err := os.Stat(path)
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+
+		Convey("an empty report should have empty lists rather than nulls", func() {
+			tl.EnableSummary(path)
+			So(tl.WriteSummary(0), ShouldBeNil)
+			var report map[string]interface{}
+			content, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(json.Unmarshal(content, &report), ShouldBeNil)
+			So(report["namespaces"], ShouldResemble, []interface{}{})
+			So(report["failures"], ShouldResemble, []interface{}{})
+			So(report["skipped"], ShouldResemble, []interface{}{})
+			So(report["exitCode"], ShouldEqual, 0)
+		})
+
+		Convey("a report file that can't be created should be an error", func() {
+			tl.EnableSummary(filepath.Join(dir, "missing", "report.json"))
+			So(tl.WriteSummary(1), ShouldNotBeNil)
+		})
+	})
+
+	Convey("With a quiet logger", t, func() {
+		tl, buf := newTestLogger(-1)
+		tl.SetFormatter(JSONFormatter{})
+
+		Convey("the summary should be a single line on the log output", func() {
+			tl.EnableSummary("")
+			tl.Summary().AddCount("documents", 2)
+			tl.Errorf("hidden by --quiet")
+			So(tl.WriteSummary(1), ShouldBeNil)
+
+			var report summaryReport
+			So(buf.String(), ShouldEndWith, "}\n")
+			So(json.Unmarshal(buf.Bytes(), &report), ShouldBeNil)
+			So(report.ExitCode, ShouldEqual, 1)
+			So(report.Counters, ShouldResemble, map[string]int64{"documents": 2})
+		})
+	})
+
+	Convey("Without a summary", t, func() {
+		tl, buf := newTestLogger(-1)
+
+		Convey("recording results and writing the summary should do nothing", func() {
+			summary := tl.Summary()
+			So(summary, ShouldBeNil)
+			summary.AddCount("documents", 1)
+			summary.AddNamespace("db.c", 1, 0, time.Second, nil)
+			summary.ClearNamespaceError("db.c")
+			summary.AddFailure("failed", "")
+			summary.AddSkipped("db.c", "skipped")
+			So(tl.WriteSummary(0), ShouldBeNil)
+			So(buf.String(), ShouldBeEmpty)
+		})
+	})
+}
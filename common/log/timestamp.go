@@ -0,0 +1,78 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Names of the timestamp format presets
+const (
+	TimestampDefault = "default"
+	TimestampRFC3339 = "rfc3339"
+	TimestampEpochMS = "epoch-ms"
+	TimestampUTC     = "utc"
+	TimestampNone    = "none"
+)
+
+// RFC3339TimeFormat is RFC 3339 with millisecond precision.
+const RFC3339TimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// epochMSFormat is a sentinel layout for millisecond Unix timestamps.
+const epochMSFormat = "epoch-ms"
+
+// SetTimestampFormat selects one of the named timestamp presets. The utc
+// preset is the default layout in UTC; the none preset omits timestamps.
+func (tl *ToolLogger) SetTimestampFormat(name string) error {
+	switch name {
+	case "", TimestampDefault:
+		tl.format = ToolTimeFormat
+	case TimestampRFC3339:
+		tl.format = RFC3339TimeFormat
+	case TimestampEpochMS:
+		tl.format = epochMSFormat
+	case TimestampUTC:
+		tl.format = ToolTimeFormat
+		tl.utc = true
+	case TimestampNone:
+		tl.format = ""
+	default:
+		return fmt.Errorf("unknown timestamp format '%v', expected one of %v, %v, %v, %v or %v",
+			name, TimestampDefault, TimestampRFC3339, TimestampEpochMS, TimestampUTC, TimestampNone)
+	}
+	return nil
+}
+
+// SetUTC makes timestamps use UTC rather than the local time zone,
+// whatever their format.
+func (tl *ToolLogger) SetUTC(utc bool) {
+	tl.utc = utc
+}
+
+// formatTime renders a timestamp according to the logger's settings.
+func (tl *ToolLogger) formatTime(t time.Time) string {
+	switch tl.format {
+	case "":
+		return ""
+	case epochMSFormat:
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	}
+	if tl.utc {
+		t = t.UTC()
+	}
+	return t.Format(tl.format)
+}
+
+func SetTimestampFormat(name string) error {
+	return globalToolLogger.SetTimestampFormat(name)
+}
+
+func SetUTC(utc bool) {
+	globalToolLogger.SetUTC(utc)
+}
@@ -0,0 +1,370 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+// Package log provides a utility to log timestamped messages to an io.Writer.
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Tool Logger verbosity constants
+const (
+	Always = iota
+	Info
+	DebugLow
+	DebugHigh
+)
+
+const (
+	ToolTimeFormat = "2006-01-02T15:04:05.000-0700"
+)
+
+//// Tool Logger Definition
+
+type ToolLogger struct {
+	mutex  *sync.Mutex
+	writer io.Writer
+	format string
+
+	// if set, warnings and errors are written here instead of to writer
+	errorWriter io.Writer
+
+	utc       bool
+	verbosity int
+	formatter Formatter
+	toolName  string
+
+	// per-component verbosity overrides, keyed by component name
+	componentVerbosity map[string]int
+
+	redactor redactor
+
+	// if set, the values of configured document fields are hashed
+	scrubber *scrubber
+
+	// if set, entries are passed to the sink instead of being
+	// formatted and written
+	sink Sink
+
+	// if set, entries are queued and written in the background
+	async *asyncWriter
+
+	// additional outputs with their own verbosity, and the highest of
+	// their verbosities
+	writerSinks      []*writerSink
+	maxSinkVerbosity int
+
+	// per-component sampling of debug and trace messages
+	samplers map[string]*sampler
+
+	// accumulated outcome of the run, if a summary was requested
+	summary *RunSummary
+
+	hookMutex sync.RWMutex
+	hooks     []*Hook
+}
+
+type VerbosityLevel interface {
+	Level() int
+	IsQuiet() bool
+}
+
+func (tl *ToolLogger) SetVerbosity(level VerbosityLevel) {
+	if level == nil {
+		tl.verbosity = 0
+		return
+	}
+
+	if level.IsQuiet() {
+		tl.verbosity = -1
+	} else {
+		tl.verbosity = level.Level()
+	}
+}
+
+func (tl *ToolLogger) SetWriter(writer io.Writer) {
+	tl.writer = writer
+}
+
+// SetErrorWriter sends warnings and errors to the given writer, leaving
+// everything else on the main writer. A nil writer sends all output to the
+// main writer again.
+func (tl *ToolLogger) SetErrorWriter(writer io.Writer) {
+	tl.errorWriter = writer
+}
+
+// SetDateFormat sets the layout used for timestamps, as accepted by
+// time.Format. An empty layout omits timestamps.
+func (tl *ToolLogger) SetDateFormat(dateFormat string) {
+	tl.format = dateFormat
+}
+
+// SetFormatter sets the formatter used to render each log entry.
+func (tl *ToolLogger) SetFormatter(formatter Formatter) {
+	tl.formatter = formatter
+}
+
+// SetToolName sets the tool name reported in structured log output.
+func (tl *ToolLogger) SetToolName(name string) {
+	tl.toolName = name
+}
+
+func (tl *ToolLogger) Logvf(minVerb int, format string, a ...interface{}) {
+	tl.logvf("", minVerb, format, a...)
+}
+
+func (tl *ToolLogger) Logv(minVerb int, msg string) {
+	tl.logkv("", minVerb, msg, nil)
+}
+
+// Errorf logs a message with error severity. Errors are written at every
+// verbosity level except quiet.
+func (tl *ToolLogger) Errorf(format string, a ...interface{}) {
+	tl.logSeverityf(SeverityError, format, a...)
+}
+
+// Warnf logs a message with warning severity. Warnings are written at every
+// verbosity level except quiet.
+func (tl *ToolLogger) Warnf(format string, a ...interface{}) {
+	tl.logSeverityf(SeverityWarning, format, a...)
+}
+
+// Errorkv logs a message with error severity along with alternating keys
+// and values.
+func (tl *ToolLogger) Errorkv(msg string, keyvals ...interface{}) {
+	if tl.enabled("", Always) {
+		tl.log("", Always, SeverityError, msg, fieldsFromKeyvals(keyvals))
+	}
+}
+
+func (tl *ToolLogger) logSeverityf(severity Severity, format string, a ...interface{}) {
+	if tl.enabled("", Always) {
+		tl.log("", Always, severity, fmt.Sprintf(format, a...), nil)
+	}
+}
+
+// Logkv logs a message along with alternating keys and values. In text mode
+// the pairs are appended to the message; in JSON mode they are emitted as
+// separate attributes.
+func (tl *ToolLogger) Logkv(minVerb int, msg string, keyvals ...interface{}) {
+	tl.logkv("", minVerb, msg, keyvals)
+}
+
+func (tl *ToolLogger) logvf(component string, minVerb int, format string, a ...interface{}) {
+	if tl.enabled(component, minVerb) {
+		tl.logf(component, minVerb, severityForVerbosity(minVerb), nil, format, a)
+	}
+}
+
+func (tl *ToolLogger) logkv(component string, minVerb int, msg string, keyvals []interface{}) {
+	if tl.enabled(component, minVerb) {
+		tl.log(component, minVerb, severityForVerbosity(minVerb), msg, fieldsFromKeyvals(keyvals))
+	}
+}
+
+// enabled reports whether a message at the given verbosity should be written
+// for the given component, either to the logger's own output or to one of
+// its additional sinks.
+func (tl *ToolLogger) enabled(component string, minVerb int) bool {
+	if minVerb < 0 {
+		panic("cannot set a minimum log verbosity that is less than 0")
+	}
+	return minVerb <= tl.verbosityFor(component) || (len(tl.writerSinks) > 0 && minVerb <= tl.maxSinkVerbosity)
+}
+
+// log builds an entry and writes it, or queues it in async mode.
+func (tl *ToolLogger) log(component string, minVerb int, severity Severity, msg string, fields []Field) {
+	if keep, sampleRate := tl.sample(component, severity); keep {
+		tl.logSampled(component, minVerb, severity, msg, fields, sampleRate)
+	}
+}
+
+// logf is like log, but only formats the message if sampling keeps it, so
+// that the messages a sampled component drops cost next to nothing.
+func (tl *ToolLogger) logf(component string, minVerb int, severity Severity, fields []Field, format string, a []interface{}) {
+	if keep, sampleRate := tl.sample(component, severity); keep {
+		tl.logSampled(component, minVerb, severity, fmt.Sprintf(format, a...), fields, sampleRate)
+	}
+}
+
+// logSampled builds an entry that sampling kept and writes it.
+func (tl *ToolLogger) logSampled(component string, minVerb int, severity Severity, msg string, fields []Field, sampleRate int) {
+	if sampleRate > 0 {
+		fields = append(fields, Field{Key: "sampleRate", Value: sampleRate})
+	}
+	if tl.scrubber != nil {
+		tl.scrubber.scrubFields(fields)
+	}
+	msg = tl.redactor.redact(msg)
+	tl.redactor.redactFields(fields)
+
+	now := time.Now()
+	entry := &Entry{
+		Time:      now,
+		Timestamp: tl.formatTime(now),
+		Verbosity: minVerb,
+		Severity:  severity,
+		Tool:      tl.toolName,
+		Component: component,
+		Message:   msg,
+		Fields:    fields,
+	}
+	if tl.async == nil || !tl.async.enqueue(entry) {
+		tl.writeLocked(entry)
+	}
+	tl.fireHooks(entry)
+}
+
+func (tl *ToolLogger) writeLocked(entry *Entry) {
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+	tl.write(entry)
+}
+
+// write outputs an entry. The caller must hold the mutex.
+func (tl *ToolLogger) write(entry *Entry) {
+	tl.writeSinks(entry)
+	if entry.Verbosity > tl.verbosityFor(entry.Component) {
+		return
+	}
+	if tl.sink != nil {
+		tl.sink.WriteEntry(entry)
+		return
+	}
+	writer := tl.writer
+	if tl.errorWriter != nil && entry.Severity <= SeverityWarning {
+		writer = tl.errorWriter
+	}
+	writer.Write(tl.formatter.Format(entry))
+}
+
+func NewToolLogger(verbosity VerbosityLevel) *ToolLogger {
+	tl := &ToolLogger{
+		mutex:     &sync.Mutex{},
+		writer:    os.Stderr, // default to stderr
+		format:    ToolTimeFormat,
+		formatter: TextFormatter{},
+	}
+	tl.SetVerbosity(verbosity)
+	return tl
+}
+
+//// Log Writer Interface
+
+// toolLogWriter is an io.Writer wrapping a tool logger. It is a private
+// type meant for creation with the ToolLogger.Writer(...) method.
+type toolLogWriter struct {
+	logger       *ToolLogger
+	component    string
+	minVerbosity int
+}
+
+func (tlw *toolLogWriter) Write(message []byte) (int, error) {
+	tlw.logger.logkv(tlw.component, tlw.minVerbosity, string(message), nil)
+	return len(message), nil
+}
+
+// Writer returns an io.Writer that writes to the logger with
+// the given verbosity
+func (tl *ToolLogger) Writer(minVerb int) io.Writer {
+	return &toolLogWriter{tl, "", minVerb}
+}
+
+//// Global Logging
+
+var globalToolLogger *ToolLogger
+
+func init() {
+	if globalToolLogger == nil {
+		// initialize tool logger with verbosity level = 0
+		globalToolLogger = NewToolLogger(nil)
+	}
+}
+
+// IsInVerbosity returns true if the current verbosity level setting is
+// greater than or equal to the given level.
+func IsInVerbosity(minVerb int) bool {
+	return minVerb >= 0 && globalToolLogger.enabled("", minVerb)
+}
+
+// Component returns a logger for the named component of the global logger,
+// registering the component so its verbosity can be set on the command line.
+func Component(name string) *ComponentLogger {
+	return globalToolLogger.Component(name)
+}
+
+func SetComponentVerbosity(levels map[string]int) {
+	globalToolLogger.SetComponentVerbosity(levels)
+}
+
+func AddSecret(secret string) {
+	globalToolLogger.AddSecret(secret)
+}
+
+func SetRedaction(enabled bool) {
+	globalToolLogger.SetRedaction(enabled)
+}
+
+func Redact(msg string) string {
+	return globalToolLogger.Redact(msg)
+}
+
+func Logvf(minVerb int, format string, a ...interface{}) {
+	globalToolLogger.Logvf(minVerb, format, a...)
+}
+
+func Logv(minVerb int, msg string) {
+	globalToolLogger.Logv(minVerb, msg)
+}
+
+func Errorf(format string, a ...interface{}) {
+	globalToolLogger.Errorf(format, a...)
+}
+
+func Warnf(format string, a ...interface{}) {
+	globalToolLogger.Warnf(format, a...)
+}
+
+func Errorkv(msg string, keyvals ...interface{}) {
+	globalToolLogger.Errorkv(msg, keyvals...)
+}
+
+func SetVerbosity(verbosity VerbosityLevel) {
+	globalToolLogger.SetVerbosity(verbosity)
+}
+
+func SetWriter(writer io.Writer) {
+	globalToolLogger.SetWriter(writer)
+}
+
+func SetErrorWriter(writer io.Writer) {
+	globalToolLogger.SetErrorWriter(writer)
+}
+
+func SetDateFormat(dateFormat string) {
+	globalToolLogger.SetDateFormat(dateFormat)
+}
+
+func Logkv(minVerb int, msg string, keyvals ...interface{}) {
+	globalToolLogger.Logkv(minVerb, msg, keyvals...)
+}
+
+func SetFormatter(formatter Formatter) {
+	globalToolLogger.SetFormatter(formatter)
+}
+
+func SetToolName(name string) {
+	globalToolLogger.SetToolName(name)
+}
+
+func Writer(minVerb int) io.Writer {
+	return globalToolLogger.Writer(minVerb)
+}
@@ -0,0 +1,130 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testVerbosity is a VerbosityLevel where a negative level means quiet.
+type testVerbosity int
+
+func (v testVerbosity) Level() int    { return int(v) }
+func (v testVerbosity) IsQuiet() bool { return v < 0 }
+
+// newTestLogger returns a logger at the given verbosity that writes to a
+// buffer, without timestamps.
+func newTestLogger(level int) (*ToolLogger, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	tl := NewToolLogger(testVerbosity(level))
+	tl.SetWriter(buf)
+	tl.SetDateFormat("")
+	return tl, buf
+}
+
+func TestJSONFormat(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a logger writing JSON", t, func() {
+		tl, buf := newTestLogger(Info)
+		tl.SetFormatter(JSONFormatter{})
+		tl.SetToolName("mongodump")
+
+		Convey("each entry should be a single-line object with its level, tool and message", func() {
+			tl.Logkv(Always, "dumping", "ns", "db.c", "docs", 3)
+			So(buf.String(), ShouldEqual,
+				`{"level":"info","tool":"mongodump","msg":"dumping","attr":{"ns":"db.c","docs":3}}`+"\n")
+		})
+
+		Convey("entries without fields should have no attributes", func() {
+			tl.Logvf(Info, "%v documents", 5)
+			So(buf.String(), ShouldEqual, `{"level":"info","tool":"mongodump","msg":"5 documents"}`+"\n")
+		})
+
+		Convey("errors should be logged with their message", func() {
+			tl.Errorkv("failed", "err", errors.New("connection reset"))
+			So(buf.String(), ShouldEqual,
+				`{"level":"error","tool":"mongodump","msg":"failed","attr":{"err":"connection reset"}}`+"\n")
+		})
+
+		Convey("timestamps should be written first", func() {
+			tl.SetDateFormat(ToolTimeFormat)
+			tl.Warnf("slow")
+			var entry map[string]interface{}
+			So(json.Unmarshal(buf.Bytes(), &entry), ShouldBeNil)
+			So(entry["t"], ShouldNotBeEmpty)
+			So(entry["level"], ShouldEqual, "warning")
+			So(buf.String(), ShouldStartWith, `{"t":`)
+		})
+
+		Convey("values JSON can't encode should be written as strings", func() {
+			tl.Logkv(Always, "odd", "ch", make(chan int))
+			var entry struct {
+				Attr map[string]interface{} `json:"attr"`
+			}
+			So(json.Unmarshal(buf.Bytes(), &entry), ShouldBeNil)
+			So(entry.Attr["ch"], ShouldStartWith, "0x")
+		})
+	})
+}
+
+func TestLogkv(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a logger writing text", t, func() {
+		tl, buf := newTestLogger(Info)
+
+		Convey("pairs should be appended to the message", func() {
+			tl.Logkv(Always, "dumping", "ns", "db.c", "docs", 3)
+			So(buf.String(), ShouldEqual, "dumping ns=db.c docs=3\n")
+		})
+
+		Convey("a key without a value should be kept", func() {
+			tl.Logkv(Always, "dumping", "ns")
+			So(buf.String(), ShouldEqual, "dumping ns=<missing>\n")
+		})
+
+		Convey("the same pairs should be attributes in JSON", func() {
+			tl.SetFormatter(JSONFormatter{})
+			tl.Logkv(Always, "dumping", "ns", "db.c", "docs")
+			var entry struct {
+				Msg  string                 `json:"msg"`
+				Attr map[string]interface{} `json:"attr"`
+			}
+			So(json.Unmarshal(buf.Bytes(), &entry), ShouldBeNil)
+			So(entry.Msg, ShouldEqual, "dumping")
+			So(entry.Attr, ShouldResemble, map[string]interface{}{"ns": "db.c", "docs": "<missing>"})
+		})
+
+		Convey("messages above the verbosity should not be written", func() {
+			tl.Logkv(DebugLow, "details", "ns", "db.c")
+			So(buf.String(), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestNewFormatter(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Formatters should be looked up by their --logFormat name", t, func() {
+		for name, expected := range map[string]Formatter{"": TextFormatter{}, "text": TextFormatter{}, "json": JSONFormatter{}} {
+			formatter, err := NewFormatter(name)
+			So(err, ShouldBeNil)
+			So(formatter, ShouldResemble, expected)
+		}
+
+		_, err := NewFormatter("xml")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "unknown log format 'xml', expected 'text' or 'json'")
+	})
+}
@@ -0,0 +1,275 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package options
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/tracing"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// Struct holding options that control how log output is written
+type Logging struct {
+	LogFormat          string `long:"logFormat" value-name:"<text|json>" default:"text" description:"format of log output; 'json' writes each log line as a JSON object"`
+	Color              string `long:"color" value-name:"<auto|always|never>" default:"auto" description:"colorize warnings, errors and trace output on stderr; 'auto' only colorizes when stderr is a terminal"`
+	TimestampFormat    string `long:"logTimestampFormat" value-name:"<default|rfc3339|epoch-ms|utc|none>" default:"default" description:"format of log timestamps; 'utc' is the default format in UTC, 'none' omits timestamps"`
+	LogUTC             bool   `long:"logUTC" description:"write log timestamps in UTC rather than the local time zone, whatever their format"`
+	ComponentVerbosity string `long:"componentVerbosity" value-name:"<component>=<level>[,...]" description:"verbosity for individual components, e.g. 'archive=trace,network=debug'; levels are quiet, always, info, debug, trace or a number"`
+	LogSampling        string `long:"logSampling" value-name:"<component>=<N>[,...]" description:"only write one in every N debug and trace messages for the given components, e.g. 'import.documents=1000'; errors and warnings are never sampled"`
+
+	LogDestination    string        `long:"logDestination" value-name:"<stderr|file|syslog|eventlog>" description:"where to send log output (default: 'stderr', or 'file' if --logPath is given)"`
+	LogPath           string        `long:"logPath" value-name:"<filename>" description:"write log output to the given file instead of stderr"`
+	LogSplitStreams   bool          `long:"logSplitStreams" description:"write informational output to stdout and only warnings and errors to stderr; cannot be used when the tool writes data to stdout"`
+	LogFileVerbosity  string        `long:"logFileVerbosity" value-name:"<level>" description:"also write log output to stderr as usual, and write messages up to the given level (always, info, debug, trace or a number) to --logPath"`
+	LogRotateSizeMB   int64         `long:"logRotateSizeMB" value-name:"<megabytes>" description:"rotate the log file once it reaches the given size (requires --logPath)"`
+	LogRotateInterval time.Duration `long:"logRotateInterval" value-name:"<duration>" description:"rotate the log file after the given duration, e.g. '24h' (requires --logPath)"`
+	LogRotateMaxFiles int           `long:"logRotateMaxFiles" value-name:"<count>" description:"number of rotated log files to keep; 0 keeps all of them (requires --logPath)"`
+	LogRotateCompress bool          `long:"logRotateCompress" description:"gzip rotated log files (requires --logPath)"`
+
+	DisableLogRedaction bool   `long:"disableLogRedaction" description:"do not mask passwords and other credentials in log output (for debugging only)"`
+	LogScrubFields      string `long:"logScrubFields" value-name:"<field>[,<field>...]" description:"replace the values of the given fields with hashes wherever documents are logged, e.g. 'ssn,password,token'; dotted paths such as 'profile.ssn' only match that field"`
+
+	LogProgress   bool   `long:"logProgress" description:"log a structured 'progress' record for each namespace whenever progress is displayed; combine with --logFormat=json to track progress from the log"`
+	ProgressStyle string `long:"progressStyle" value-name:"<bars|table>" default:"bars" description:"display progress as a bar for each namespace, or as a status table of each namespace's percent complete, throughput and estimated time remaining"`
+	ProgressJSON  string `long:"progressJson" value-name:"<filename>" description:"write a JSON object for each namespace whenever progress is displayed to the given file, or '-' for stdout, one object per line"`
+	LogAsync      bool   `long:"logAsync" description:"write log output from a background goroutine, reducing contention between workers at high verbosity"`
+	LogCatalog    string `long:"logCatalog" value-name:"<filename>" description:"JSON file mapping message IDs to translated format strings, used for user-facing messages in place of the English defaults"`
+	ReportFile    string `long:"reportFile" value-name:"<filename>" description:"when the tool finishes, write a JSON summary of counts, durations, failures and skipped namespaces to the given file; with --quiet and no --reportFile, the summary is written as a single line on the log output"`
+
+	OTelEndpoint string `long:"otelEndpoint" value-name:"<host:port|url>" description:"export OpenTelemetry spans for connecting, each collection and the oplog to the OTLP/HTTP collector at the given address; trace and span IDs are added to log output"`
+}
+
+// LogsToStdout reports whether log or progress output is written to stdout,
+// in which case tools must not write their data there too.
+func (l *Logging) LogsToStdout() bool {
+	return l != nil && (l.LogSplitStreams || l.ProgressJSON == "-")
+}
+
+// LogsJSON reports whether log output is written as JSON, where fields are
+// worth attaching to messages that already name what they describe.
+func (l *Logging) LogsJSON() bool {
+	return l != nil && l.LogFormat == log.JSONFormat
+}
+
+// rotateOptions returns the rotation settings, or an error if rotation was
+// requested without a log file.
+func (l *Logging) rotateOptions() (log.RotateOptions, error) {
+	rotate := log.RotateOptions{
+		MaxSize:  l.LogRotateSizeMB * 1024 * 1024,
+		Interval: l.LogRotateInterval,
+		MaxFiles: l.LogRotateMaxFiles,
+		Compress: l.LogRotateCompress,
+	}
+	if rotate.MaxSize < 0 || rotate.Interval < 0 || rotate.MaxFiles < 0 {
+		return rotate, fmt.Errorf("log rotation settings cannot be negative")
+	}
+	if l.LogPath == "" && rotate != (log.RotateOptions{}) {
+		return rotate, fmt.Errorf("log rotation options require --logPath")
+	}
+	return rotate, nil
+}
+
+// configureLogger applies the logging options to the global logger.
+func (opts *ToolOptions) configureLogger() error {
+	formatter, err := log.NewFormatter(opts.LogFormat)
+	if err != nil {
+		return err
+	}
+	log.SetFormatter(formatter)
+
+	if err = log.SetTimestampFormat(opts.TimestampFormat); err != nil {
+		return fmt.Errorf("error parsing --logTimestampFormat: %v", err)
+	}
+	if opts.LogUTC {
+		log.SetUTC(true)
+	}
+
+	colorize, err := log.ShouldColor(opts.Color, os.Stderr)
+	if err != nil {
+		return fmt.Errorf("error parsing --color: %v", err)
+	}
+	if opts.LogSplitStreams && colorize {
+		// trace output is dimmed, so stdout has to support color too
+		colorize, _ = log.ShouldColor(opts.Color, os.Stdout)
+	}
+
+	componentLevels, err := log.ParseComponentVerbosity(opts.ComponentVerbosity)
+	if err != nil {
+		return fmt.Errorf("error parsing --componentVerbosity: %v", err)
+	}
+	log.SetComponentVerbosity(componentLevels)
+
+	samplingRates, err := log.ParseSampling(opts.LogSampling)
+	if err != nil {
+		return fmt.Errorf("error parsing --logSampling: %v", err)
+	}
+	log.SetSampling(samplingRates)
+
+	if opts.LogCatalog != "" {
+		if err = log.LoadCatalog(opts.LogCatalog); err != nil {
+			return fmt.Errorf("error loading --logCatalog: %v", err)
+		}
+	}
+
+	if err = opts.configureLogDestination(formatter, colorize); err != nil {
+		return err
+	}
+	log.SetRedaction(!opts.DisableLogRedaction)
+	if opts.LogScrubFields != "" {
+		log.SetScrubFields(strings.Split(opts.LogScrubFields, ","))
+	}
+	log.SetToolName(opts.AppName)
+	progress.EnableEvents(opts.LogProgress)
+	if err = progress.SetStyle(opts.ProgressStyle); err != nil {
+		return fmt.Errorf("error parsing --progressStyle: %v", err)
+	}
+	if err = opts.configureProgressJSON(); err != nil {
+		return err
+	}
+	if opts.LogAsync {
+		log.SetAsync(log.DefaultAsyncBufferSize)
+	}
+	if opts.ReportFile != "" || (opts.Verbosity != nil && opts.Quiet) {
+		log.EnableSummary(opts.ReportFile)
+	}
+	if opts.OTelEndpoint != "" {
+		if err = tracing.Init(opts.AppName, opts.OTelEndpoint); err != nil {
+			return fmt.Errorf("error parsing --otelEndpoint: %v", err)
+		}
+	}
+	return nil
+}
+
+// configureLogDestination points the global logger at the requested
+// destination. Colorized output is only used for text written to stderr.
+func (opts *ToolOptions) configureLogDestination(formatter log.Formatter, colorize bool) error {
+	rotate, err := opts.Logging.rotateOptions()
+	if err != nil {
+		return err
+	}
+
+	destination := opts.LogDestination
+	if destination == "" {
+		destination = log.StderrDestination
+		if opts.LogPath != "" {
+			destination = log.FileDestination
+		}
+	}
+
+	switch destination {
+	case log.StderrDestination:
+		if opts.LogPath != "" {
+			return fmt.Errorf("--logPath requires --logDestination=%v", log.FileDestination)
+		}
+		if opts.LogSplitStreams {
+			log.SetWriter(os.Stdout)
+			log.SetErrorWriter(os.Stderr)
+		}
+		if colorize {
+			opts.colorizeStderr(formatter)
+		}
+	case log.FileDestination:
+		if opts.LogPath == "" {
+			return fmt.Errorf("--logDestination=%v requires --logPath", log.FileDestination)
+		}
+		fileLevel, err := log.ParseVerbosity(opts.LogFileVerbosity)
+		if opts.LogFileVerbosity != "" && (err != nil || fileLevel < 0) {
+			return fmt.Errorf("invalid --logFileVerbosity '%v'", opts.LogFileVerbosity)
+		}
+		writer, err := log.NewRotatingWriter(opts.LogPath, rotate)
+		if err != nil {
+			return err
+		}
+		if opts.LogFileVerbosity == "" {
+			log.SetWriter(writer)
+			break
+		}
+		// keep writing to stderr, and send the more verbose output to the file
+		if err = log.AddSink(writer, fileLevel, opts.LogFormat); err != nil {
+			return err
+		}
+		if colorize {
+			opts.colorizeStderr(formatter)
+		}
+	case log.SyslogDestination, log.EventLogDestination:
+		if opts.LogPath != "" {
+			return fmt.Errorf("--logPath cannot be used with --logDestination=%v", destination)
+		}
+		sink, err := log.NewSystemSink(destination, opts.AppName, formatter)
+		if err != nil {
+			return err
+		}
+		log.SetSink(sink)
+	default:
+		return fmt.Errorf("unknown log destination '%v'", destination)
+	}
+	if opts.LogFileVerbosity != "" && destination != log.FileDestination {
+		return fmt.Errorf("--logFileVerbosity requires --logPath")
+	}
+	if opts.LogSplitStreams && destination != log.StderrDestination {
+		return fmt.Errorf("--logSplitStreams cannot be used with --logDestination=%v", destination)
+	}
+	return nil
+}
+
+// configureProgressJSON opens the --progressJson output.
+func (opts *ToolOptions) configureProgressJSON() error {
+	switch opts.ProgressJSON {
+	case "":
+		return nil
+	case "-":
+		progress.SetJSONOutput(os.Stdout)
+		return nil
+	}
+	file, err := os.OpenFile(opts.ProgressJSON, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening --progressJson file: %v", err)
+	}
+	progress.SetJSONOutput(file)
+	return nil
+}
+
+// colorizeStderr switches text output on stderr to the color formatter.
+func (opts *ToolOptions) colorizeStderr(formatter log.Formatter) {
+	if _, isText := formatter.(log.TextFormatter); isText {
+		log.SetFormatter(log.ColorFormatter{Formatter: formatter})
+	}
+}
+
+// registerLogSecrets makes sure that credentials passed on the command line
+// or in the connection string are masked in log output.
+func (opts *ToolOptions) registerLogSecrets() {
+	if opts.Auth != nil {
+		log.AddSecret(opts.Auth.Password)
+		log.AddSecret(opts.Auth.AWSSessionToken)
+	}
+	if opts.SSL != nil {
+		log.AddSecret(opts.SSL.SSLPEMKeyPassword)
+	}
+}
+
+// configureCrashReports records the details written if the tool panics.
+// Crash files are written next to the log file, if there is one.
+func (opts *ToolOptions) configureCrashReports() {
+	info := util.CrashInfo{
+		Tool:      opts.AppName,
+		Version:   opts.VersionStr,
+		GitCommit: opts.GitCommit,
+		Args:      os.Args[1:],
+	}
+	if opts.LogPath != "" {
+		info.Dir = filepath.Dir(opts.LogPath)
+	}
+	util.SetCrashInfo(info)
+}
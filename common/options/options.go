@@ -18,9 +18,9 @@ import (
 	"time"
 
 	flags "github.com/jessevdk/go-flags"
-	"github.com/mongodb/mongo-tools-common/failpoint"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/failpoint"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
@@ -60,6 +60,7 @@ type ToolOptions struct {
 	*URI
 	*General
 	*Verbosity
+	*Logging
 	*Connection
 	*SSL
 	*Auth
@@ -217,6 +218,7 @@ func New(appName, versionStr, gitCommit, usageStr string, parsePositionalArgsAsU
 
 		General:    &General{},
 		Verbosity:  &Verbosity{},
+		Logging:    &Logging{},
 		Connection: &Connection{},
 		URI:        &URI{},
 		SSL:        &SSL{},
@@ -253,6 +255,9 @@ func New(appName, versionStr, gitCommit, usageStr string, parsePositionalArgsAsU
 	if _, err := opts.parser.AddGroup("verbosity options", "", opts.Verbosity); err != nil {
 		panic(fmt.Errorf("couldn't register verbosity options: %v", err))
 	}
+	if _, err := opts.parser.AddGroup("logging options", "", opts.Logging); err != nil {
+		panic(fmt.Errorf("couldn't register logging options: %v", err))
+	}
 
 	// this call disables failpoints if compiled without failpoint support
 	EnableFailpoints(opts)
@@ -292,6 +297,20 @@ func New(appName, versionStr, gitCommit, usageStr string, parsePositionalArgsAsU
 	return opts
 }
 
+// ShardOptions returns a copy of the options that connects to the replica
+// set of a shard, given its host string from config.shards.
+func (opts *ToolOptions) ShardOptions(host string) *ToolOptions {
+	hosts, setName := util.SplitHostArg(host)
+	shardOpts := *opts
+	uri := *opts.URI
+	uri.ConnString.Hosts = hosts
+	uri.ConnString.ReplicaSet = setName
+	shardOpts.URI = &uri
+	shardOpts.ReplicaSetName = setName
+	shardOpts.Direct = setName == ""
+	return &shardOpts
+}
+
 // UseReadOnlyHostDescription changes the help description of the --host arg to
 // not mention the shard/host:port format used in the data-mutating tools
 func (opts *ToolOptions) UseReadOnlyHostDescription() {
@@ -392,7 +411,7 @@ func (opts *ToolOptions) EnabledToolOptions() EnabledOptions {
 // The unknown options are determined by the driver.
 func (uri *URI) LogUnsupportedOptions() {
 	for key := range uri.ConnString.UnknownOptions {
-		log.Logvf(log.Always, unknownOptionsWarningFormat, key)
+		log.Warnf(unknownOptionsWarningFormat, key)
 	}
 }
 
@@ -432,7 +451,7 @@ func (opts *ToolOptions) ParseArgs(args []string) ([]string, error) {
 	}
 
 	if opts.SSLAllowInvalidCert || opts.SSLAllowInvalidHost {
-		log.Logvf(log.Always, deprecationWarningSSLAllow)
+		log.Warnf(deprecationWarningSSLAllow)
 	}
 
 	if opts.parsePositionalArgsAsURI {
@@ -444,10 +463,16 @@ func (opts *ToolOptions) ParseArgs(args []string) ([]string, error) {
 
 	failpoint.ParseFailpoints(opts.Failpoints)
 
+	if err = opts.configureLogger(); err != nil {
+		return []string{}, err
+	}
+
 	err = opts.NormalizeOptionsAndURI()
 	if err != nil {
 		return []string{}, err
 	}
+	opts.registerLogSecrets()
+	opts.configureCrashReports()
 
 	return args, err
 }
@@ -0,0 +1,104 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package progress
+
+import (
+	"sync"
+	"time"
+)
+
+// Update is the progress of a progressor attached to Callbacks.
+type Update struct {
+	Name    string
+	Current int64
+	Total   int64
+	// Documents is set if the progressor counts documents rather than bytes
+	Documents bool
+	// Done is set on the last update, when the progressor is detached
+	Done bool
+}
+
+// updateOf returns the progress of a progressor.
+func updateOf(name string, progressor Progressor) Update {
+	current, total := progressor.Progress()
+	counter, ok := progressor.(DocumentCounter)
+	return Update{Name: name, Current: current, Total: total, Documents: ok && counter.CountsDocuments()}
+}
+
+// Callbacks is a Manager that calls a function with the progress of each
+// progressor attached to it, periodically and when it is detached, for
+// programs that run the tools as a library.
+type Callbacks struct {
+	callback func(Update)
+	done     chan struct{}
+	once     sync.Once
+
+	mutex       sync.Mutex
+	progressors map[string]Progressor
+}
+
+// NewCallbacks returns Callbacks that call callback every interval until
+// Stop is called.
+func NewCallbacks(callback func(Update), interval time.Duration) *Callbacks {
+	c := &Callbacks{
+		callback:    callback,
+		done:        make(chan struct{}),
+		progressors: map[string]Progressor{},
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-ticker.C:
+				c.report()
+			}
+		}
+	}()
+	return c
+}
+
+// Attach is part of the Manager interface.
+func (c *Callbacks) Attach(name string, progressor Progressor) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.progressors[name] = progressor
+}
+
+// Detach is part of the Manager interface. It reports the final progress of
+// the progressor.
+func (c *Callbacks) Detach(name string) {
+	c.mutex.Lock()
+	progressor, ok := c.progressors[name]
+	delete(c.progressors, name)
+	c.mutex.Unlock()
+	if ok {
+		update := updateOf(name, progressor)
+		update.Done = true
+		c.callback(update)
+	}
+}
+
+// report calls the callback with the progress of each progressor attached.
+func (c *Callbacks) report() {
+	c.mutex.Lock()
+	var updates []Update
+	for name, progressor := range c.progressors {
+		updates = append(updates, updateOf(name, progressor))
+	}
+	c.mutex.Unlock()
+	for _, update := range updates {
+		c.callback(update)
+	}
+}
+
+// Stop stops the periodic calls. It can be called more than once.
+func (c *Callbacks) Stop() {
+	c.once.Do(func() { close(c.done) })
+}
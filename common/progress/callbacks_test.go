@@ -0,0 +1,52 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package progress
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCallbacks(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Progress is reported periodically and when a progressor is detached", t, func() {
+		var mutex sync.Mutex
+		var updates []Update
+		callbacks := NewCallbacks(func(u Update) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			updates = append(updates, u)
+		}, time.Millisecond)
+		defer callbacks.Stop()
+
+		counter := NewDocumentCounter(10)
+		callbacks.Attach("test.orders", counter)
+		counter.Inc(4)
+		time.Sleep(20 * time.Millisecond)
+		counter.Inc(6)
+		callbacks.Detach("test.orders")
+		callbacks.Detach("test.orders")
+		callbacks.Stop()
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		So(len(updates), ShouldBeGreaterThan, 1)
+		So(updates[0], ShouldResemble, Update{Name: "test.orders", Current: 4, Total: 10, Documents: true})
+		last := updates[len(updates)-1]
+		So(last, ShouldResemble, Update{Name: "test.orders", Current: 10, Total: 10, Documents: true, Done: true})
+		So(updates[len(updates)-2].Done, ShouldBeFalse)
+	})
+
+	Convey("Progress of bytes isn't counted in documents", t, func() {
+		So(updateOf("test.orders", NewCounter(100)), ShouldResemble, Update{Name: "test.orders", Total: 100})
+	})
+}
@@ -0,0 +1,156 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package progress
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// progressLog receives the progress records, so that they can be filtered
+// or have their verbosity set independently from other output.
+var progressLog = log.Component("progress")
+
+// progressNow returns the time of a sample, and is swapped out by tests.
+var progressNow = time.Now
+
+// EnableEvents turns on progress records: every time a BarWriter renders
+// its bars, it also logs one structured record per bar with the bar's name,
+// the amount done, the total, the unit, the rate per second since the
+// previous record and the estimated seconds remaining. It applies to
+// BarWriters created afterwards.
+func EnableEvents(enabled bool) {
+	defaults.Lock()
+	defer defaults.Unlock()
+	defaults.events = enabled
+}
+
+// jsonOutput is where progress objects are written, if anywhere.
+var jsonOutput struct {
+	sync.Mutex
+	encoder *json.Encoder
+}
+
+// SetJSONOutput makes BarWriters write a JSON object per bar to w every time
+// they render their bars, and a final one when a bar is detached. Objects are
+// written one per line and have the same fields as progress records, with
+// the time and percent complete. It should be called before any BarWriter is
+// started; a nil w turns the output off.
+func SetJSONOutput(w io.Writer) {
+	jsonOutput.Lock()
+	defer jsonOutput.Unlock()
+	jsonOutput.encoder = nil
+	if w != nil {
+		jsonOutput.encoder = json.NewEncoder(w)
+	}
+}
+
+// jsonRecord is a progress object written to the JSON output.
+type jsonRecord struct {
+	Time    string   `json:"time"`
+	NS      string   `json:"ns"`
+	State   string   `json:"state"`
+	Done    int64    `json:"done"`
+	Total   int64    `json:"total"`
+	Unit    string   `json:"unit"`
+	Percent *float64 `json:"percent,omitempty"`
+	Rate    *float64 `json:"rate,omitempty"`
+	ETA     *int64   `json:"eta,omitempty"`
+}
+
+// barStats is a bar's progress when it was last sampled, and its rate since
+// the sample before.
+type barStats struct {
+	time        time.Time
+	done, total int64
+	rate        float64
+	hasRate     bool
+}
+
+// sample records the bar's progress and its rate since the previous sample.
+func (pb *Bar) sample() {
+	done, total := pb.Watching.Progress()
+	now := progressNow()
+	pb.stats.hasRate = false
+	if !pb.stats.time.IsZero() {
+		if elapsed := now.Sub(pb.stats.time).Seconds(); elapsed > 0 {
+			pb.stats.rate = float64(done-pb.stats.done) / elapsed
+			pb.stats.hasRate = true
+		}
+	}
+	pb.stats.time, pb.stats.done, pb.stats.total = now, done, total
+}
+
+// eta returns the estimated seconds until the bar completes at its latest
+// rate, or false if that isn't known.
+func (pb *Bar) eta() (int64, bool) {
+	if !pb.stats.hasRate || pb.stats.rate <= 0 || pb.stats.total <= pb.stats.done {
+		return 0, false
+	}
+	return int64(math.Ceil(float64(pb.stats.total-pb.stats.done) / pb.stats.rate)), true
+}
+
+// unit returns what the bar counts.
+func (pb *Bar) unit() string {
+	if pb.IsBytes {
+		return "bytes"
+	}
+	return "documents"
+}
+
+// report writes a JSON object, and a progress record if events are on, for
+// the bar's latest sample. Final ones are written when the bar is detached.
+func (pb *Bar) report(final, events bool) {
+	state := "running"
+	if final {
+		state = "done"
+	}
+	rate := math.Round(pb.stats.rate*10) / 10
+	eta, hasETA := pb.eta()
+
+	if events && progressLog.IsInVerbosity(log.Always) {
+		keyvals := []interface{}{"ns", pb.Name, "state", state, "done", pb.stats.done, "total", pb.stats.total, "unit", pb.unit()}
+		if pb.stats.hasRate {
+			keyvals = append(keyvals, "rate", rate)
+		}
+		if hasETA {
+			keyvals = append(keyvals, "eta", eta)
+		}
+		progressLog.Logkv(log.Always, "progress", keyvals...)
+	}
+
+	jsonOutput.Lock()
+	defer jsonOutput.Unlock()
+	if jsonOutput.encoder == nil {
+		return
+	}
+	record := &jsonRecord{
+		Time:  pb.stats.time.UTC().Format(time.RFC3339Nano),
+		NS:    pb.Name,
+		State: state,
+		Done:  pb.stats.done,
+		Total: pb.stats.total,
+		Unit:  pb.unit(),
+	}
+	if pb.stats.total > 0 {
+		percent := math.Round(float64(pb.stats.done)/float64(pb.stats.total)*1000) / 10
+		record.Percent = &percent
+	}
+	if pb.stats.hasRate {
+		record.Rate = &rate
+	}
+	if hasETA {
+		record.ETA = &eta
+	}
+	// progress output never interrupts the tool
+	_ = jsonOutput.encoder.Encode(record)
+}
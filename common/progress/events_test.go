@@ -0,0 +1,137 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package progress
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testClock is a time for progressNow that tests move forward.
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// useTestClock swaps progressNow for a test clock, returning the clock and
+// a func that restores progressNow.
+func useTestClock() (*testClock, func()) {
+	clock := &testClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	progressNow = func() time.Time { return clock.now }
+	return clock, func() { progressNow = time.Now }
+}
+
+func TestBarStats(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a test clock", t, func() {
+		clock, restore := useTestClock()
+		defer restore()
+
+		Convey("a bar's rate should be measured between samples", func() {
+			counter := NewCounter(100)
+			pb := &Bar{Name: "db.c", Watching: counter}
+			pb.sample()
+			So(pb.stats.hasRate, ShouldBeFalse)
+			_, ok := pb.eta()
+			So(ok, ShouldBeFalse)
+
+			clock.advance(4 * time.Second)
+			counter.Inc(12)
+			pb.sample()
+			So(pb.stats.hasRate, ShouldBeTrue)
+			So(pb.stats.rate, ShouldEqual, 3)
+			seconds, ok := pb.eta()
+			So(ok, ShouldBeTrue)
+			// 88 left at 3/s, rounded up
+			So(seconds, ShouldEqual, 30)
+
+			Convey("and samples at the same time should have no rate", func() {
+				pb.sample()
+				So(pb.stats.hasRate, ShouldBeFalse)
+			})
+
+			Convey("and a bar without progress should have no ETA", func() {
+				clock.advance(time.Second)
+				pb.sample()
+				So(pb.stats.rate, ShouldEqual, 0)
+				_, ok := pb.eta()
+				So(ok, ShouldBeFalse)
+			})
+
+			Convey("and a complete bar should have no ETA", func() {
+				clock.advance(time.Second)
+				counter.Set(100)
+				pb.sample()
+				_, ok := pb.eta()
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("a bar with an unknown total should have a rate but no ETA", func() {
+			counter := NewCounter(0)
+			pb := &Bar{Name: "db.c", Watching: counter}
+			pb.sample()
+			clock.advance(2 * time.Second)
+			counter.Inc(10)
+			pb.sample()
+			So(pb.stats.hasRate, ShouldBeTrue)
+			So(pb.stats.rate, ShouldEqual, 5)
+			_, ok := pb.eta()
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestJSONOutput(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With JSON progress output", t, func() {
+		clock, restore := useTestClock()
+		defer restore()
+		output := &bytes.Buffer{}
+		SetJSONOutput(output)
+		defer SetJSONOutput(nil)
+
+		Convey("a bar should be written with its percent, rate and ETA", func() {
+			counter := NewDocumentCounter(200)
+			pb := &Bar{Name: "db.c", Watching: counter}
+			pb.sample()
+			clock.advance(10 * time.Second)
+			counter.Inc(50)
+			pb.sample()
+			pb.report(false, false)
+			pb.report(true, false)
+			So(output.String(), ShouldEqual,
+				`{"time":"2020-01-01T00:00:10Z","ns":"db.c","state":"running","done":50,"total":200,"unit":"documents","percent":25,"rate":5,"eta":30}`+"\n"+
+					`{"time":"2020-01-01T00:00:10Z","ns":"db.c","state":"done","done":50,"total":200,"unit":"documents","percent":25,"rate":5,"eta":30}`+"\n")
+		})
+
+		Convey("unknown values should be left out", func() {
+			pb := &Bar{Name: "db.c", Watching: NewCounter(0), IsBytes: true}
+			pb.sample()
+			pb.report(false, false)
+			So(output.String(), ShouldEqual,
+				`{"time":"2020-01-01T00:00:00Z","ns":"db.c","state":"running","done":0,"total":0,"unit":"bytes"}`+"\n")
+		})
+
+		Convey("a nil writer should turn the output off", func() {
+			SetJSONOutput(nil)
+			pb := &Bar{Name: "db.c", Watching: NewCounter(10)}
+			pb.sample()
+			pb.report(false, false)
+			So(output.String(), ShouldBeEmpty)
+		})
+	})
+}
@@ -12,7 +12,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/mongodb/mongo-tools-common/text"
+	"github.com/mongodb/mongo-tools/common/text"
 )
 
 // Manager is an interface which tools can use to registers progressors which
@@ -29,7 +29,8 @@ type Manager interface {
 const GridPadding = 2
 
 // BarWriter implements Manager. It periodically prints the status of all of its
-// progressors in the form of pretty progress bars. It handles thread-safe
+// progressors in the form of pretty progress bars, or as a status table with
+// the TableStyle of SetStyle. It handles thread-safe
 // synchronized progress bar writing, so that its progressors are written in a
 // group at a given interval. It maintains insertion order when printing, such
 // that new bars appear at the bottom of the group.
@@ -42,17 +43,26 @@ type BarWriter struct {
 	stopChan  chan struct{}
 	barLength int
 	isBytes   bool
+
+	// table and events are the SetStyle and EnableEvents settings when the
+	// BarWriter was created
+	table  bool
+	events bool
 }
 
 // NewBarWriter returns an initialized BarWriter with the given bar length and
 // byte-formatting toggle, waiting the given duration between writes
 func NewBarWriter(w io.Writer, waitTime time.Duration, barLength int, isBytes bool) *BarWriter {
+	defaults.Lock()
+	defer defaults.Unlock()
 	return &BarWriter{
 		waitTime:  waitTime,
 		writer:    w,
 		stopChan:  make(chan struct{}),
 		barLength: barLength,
 		isBytes:   isBytes,
+		table:     defaults.table,
+		events:    defaults.events,
 	}
 }
 
@@ -64,6 +74,9 @@ func (manager *BarWriter) Attach(name string, progressor Progressor) {
 		BarLength: manager.barLength,
 		IsBytes:   manager.isBytes,
 	}
+	if counter, ok := progressor.(DocumentCounter); ok && counter.CountsDocuments() {
+		pb.IsBytes = false
+	}
 	pb.validate()
 
 	manager.Lock()
@@ -98,11 +111,17 @@ func (manager *BarWriter) Detach(name string) {
 	grid := &text.GridWriter{
 		ColumnPadding: GridPadding,
 	}
+	pb.sample()
 	if pb.hasRendered {
 		// if we've rendered this bar at least once, render it one last time
-		pb.renderToGridRow(grid)
+		if manager.table {
+			pb.renderToTableRow(grid, true)
+		} else {
+			pb.renderToGridRow(grid)
+		}
 	}
 	grid.FlushRows(manager.writer)
+	pb.report(true, manager.events)
 
 	updatedBars := make([]*Bar, 0, len(manager.bars)-1)
 	for _, bar := range manager.bars {
@@ -123,9 +142,22 @@ func (manager *BarWriter) renderAllBars() {
 		ColumnPadding: GridPadding,
 	}
 	for _, bar := range manager.bars {
-		bar.renderToGridRow(grid)
+		bar.sample()
+	}
+	if manager.table && len(manager.bars) > 0 {
+		writeTableHeader(grid)
+		for _, bar := range manager.bars {
+			bar.renderToTableRow(grid, false)
+		}
+	} else {
+		for _, bar := range manager.bars {
+			bar.renderToGridRow(grid)
+		}
 	}
 	grid.FlushRows(manager.writer)
+	for _, bar := range manager.bars {
+		bar.report(false, manager.events)
+	}
 	// add padding of one row if we have more than one active bar
 	if len(manager.bars) > 1 {
 		// we just write an empty array here, since a write call of any
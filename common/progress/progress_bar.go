@@ -13,7 +13,7 @@ import (
 	"io"
 	"time"
 
-	"github.com/mongodb/mongo-tools-common/text"
+	"github.com/mongodb/mongo-tools/common/text"
 )
 
 const (
@@ -51,6 +51,10 @@ type Bar struct {
 	// hasRendered indicates that the bar has been rendered at least once
 	// and implies that when detaching should be rendered one more time
 	hasRendered bool
+
+	// the latest sample of progress, for rates, the status table and
+	// progress records
+	stats barStats
 }
 
 // Start starts the Bar goroutine. Once Start is called, a bar will
@@ -31,9 +31,19 @@ type Updateable interface {
 	Set(amount int64)
 }
 
+// DocumentCounter is implemented by progressors that may count documents
+// rather than bytes, which BarWriters of bytes display as documents.
+type DocumentCounter interface {
+	Progressor
+
+	// CountsDocuments returns whether the progressor counts documents.
+	CountsDocuments() bool
+}
+
 // CountProgressor is an implementation of Progressor that uses
 type CountProgressor struct {
 	max, current int64
+	documents    bool
 }
 
 // Progress returns the current and maximum values of the counter.
@@ -54,5 +64,17 @@ func (c *CountProgressor) Set(amount int64) {
 
 // NewCounter constructs a CountProgressor with a given maximum count.
 func NewCounter(max int64) *CountProgressor {
-	return &CountProgressor{max, 0}
+	return &CountProgressor{max: max}
+}
+
+// NewDocumentCounter constructs a CountProgressor of documents with a given
+// maximum count.
+func NewDocumentCounter(max int64) *CountProgressor {
+	return &CountProgressor{max: max, documents: true}
+}
+
+// CountsDocuments returns whether the counter was constructed with
+// NewDocumentCounter.
+func (c *CountProgressor) CountsDocuments() bool {
+	return c.documents
 }
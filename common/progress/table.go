@@ -0,0 +1,84 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package progress
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/text"
+)
+
+// Styles of progress output for SetStyle.
+const (
+	BarStyle   = "bars"
+	TableStyle = "table"
+)
+
+// defaults are the settings given to new BarWriters.
+var defaults struct {
+	sync.Mutex
+	table, events bool
+}
+
+// SetStyle sets how BarWriters render their progressors: as a bar each, or
+// as a table with a row for each progressor's percent complete, rate and
+// estimated time remaining. It applies to BarWriters created afterwards.
+func SetStyle(style string) error {
+	var table bool
+	switch style {
+	case "", BarStyle:
+	case TableStyle:
+		table = true
+	default:
+		return fmt.Errorf("unknown progress style '%v'; expected %v or %v", style, BarStyle, TableStyle)
+	}
+	defaults.Lock()
+	defer defaults.Unlock()
+	defaults.table = table
+	return nil
+}
+
+// writeTableHeader writes the header row of the status table.
+func writeTableHeader(grid *text.GridWriter) {
+	grid.WriteCells("namespace", "progress", "percent", "rate", "eta")
+	grid.EndRow()
+}
+
+// renderToTableRow writes the bar's latest sample as a row of the status
+// table.
+func (pb *Bar) renderToTableRow(grid *text.GridWriter, final bool) {
+	pb.hasRendered = true
+	currentStr, maxStr := fmt.Sprint(pb.stats.done), fmt.Sprint(pb.stats.total)
+	if pb.IsBytes {
+		currentStr, maxStr = text.FormatByteAmount(pb.stats.done), text.FormatByteAmount(pb.stats.total)
+	}
+
+	progress, percent := currentStr, "-"
+	if pb.stats.total > 0 {
+		progress = fmt.Sprintf("%s/%s", currentStr, maxStr)
+		percent = fmt.Sprintf("%2.1f%%", float64(pb.stats.done)/float64(pb.stats.total)*100)
+	}
+	rate := "-"
+	if pb.stats.hasRate {
+		if pb.IsBytes {
+			rate = text.FormatByteAmount(int64(pb.stats.rate)) + "/s"
+		} else {
+			rate = fmt.Sprintf("%.0f/s", pb.stats.rate)
+		}
+	}
+	eta := "-"
+	if final {
+		eta = "done"
+	} else if seconds, ok := pb.eta(); ok {
+		eta = (time.Duration(seconds) * time.Second).String()
+	}
+
+	grid.WriteCells(pb.Name, progress, percent, rate, eta)
+	grid.EndRow()
+}
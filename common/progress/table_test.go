@@ -0,0 +1,102 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package progress
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/common/text"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// rowWriter records each row written to it, split into its cells.
+type rowWriter struct {
+	rows [][]string
+}
+
+func (w *rowWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.rows = append(w.rows, strings.Fields(string(p)))
+	}
+	return len(p), nil
+}
+
+// tableRow renders the bar as a row of the status table.
+func tableRow(pb *Bar, final bool) []string {
+	grid := &text.GridWriter{ColumnPadding: GridPadding}
+	pb.renderToTableRow(grid, final)
+	w := &rowWriter{}
+	grid.FlushRows(w)
+	return w.rows[0]
+}
+
+func TestTableRows(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a test clock", t, func() {
+		clock, restore := useTestClock()
+		defer restore()
+
+		Convey("a row should have the bar's progress, percent, rate and ETA", func() {
+			counter := NewCounter(200)
+			pb := &Bar{Name: "db.c", Watching: counter}
+			pb.sample()
+			So(tableRow(pb, false), ShouldResemble, []string{"db.c", "0/200", "0.0%", "-", "-"})
+
+			clock.advance(10 * time.Second)
+			counter.Inc(50)
+			pb.sample()
+			So(tableRow(pb, false), ShouldResemble, []string{"db.c", "50/200", "25.0%", "5/s", "30s"})
+			So(tableRow(pb, true), ShouldResemble, []string{"db.c", "50/200", "25.0%", "5/s", "done"})
+			So(pb.hasRendered, ShouldBeTrue)
+		})
+
+		Convey("a row with an unknown total should have only the count and rate", func() {
+			counter := NewCounter(0)
+			pb := &Bar{Name: "db.c", Watching: counter}
+			pb.sample()
+			clock.advance(time.Second)
+			counter.Inc(7)
+			pb.sample()
+			So(tableRow(pb, false), ShouldResemble, []string{"db.c", "7", "-", "7/s", "-"})
+		})
+
+		Convey("a row counting bytes should format its amounts", func() {
+			counter := NewCounter(4096)
+			pb := &Bar{Name: "db.c", Watching: counter, IsBytes: true}
+			pb.sample()
+			clock.advance(time.Second)
+			counter.Inc(2048)
+			pb.sample()
+			progress := text.FormatByteAmount(2048) + "/" + text.FormatByteAmount(4096)
+			rate := text.FormatByteAmount(2048) + "/s"
+			So(strings.Join(tableRow(pb, false), " "), ShouldEqual,
+				strings.Join([]string{"db.c", progress, "50.0%", rate, "1s"}, " "))
+		})
+	})
+
+	Convey("BarWriters should use the style they were created with", t, func() {
+		So(SetStyle("xml"), ShouldNotBeNil)
+		So(SetStyle(TableStyle), ShouldBeNil)
+		w := &rowWriter{}
+		manager := NewBarWriter(w, time.Second, 10, false)
+		So(SetStyle(BarStyle), ShouldBeNil)
+		So(NewBarWriter(w, time.Second, 10, false).table, ShouldBeFalse)
+
+		manager.Attach("db.c", NewCounter(10))
+		manager.renderAllBars()
+		So(w.rows, ShouldHaveLength, 2)
+		So(w.rows[0], ShouldResemble, []string{"namespace", "progress", "percent", "rate", "eta"})
+		So(w.rows[1][0], ShouldEqual, "db.c")
+		manager.Detach("db.c")
+		So(w.rows, ShouldHaveLength, 3)
+		So(w.rows[2][len(w.rows[2])-1], ShouldEqual, "done")
+	})
+}
@@ -7,8 +7,8 @@
 package signals
 
 import (
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
 
 	"os"
 	"os/signal"
@@ -55,7 +55,7 @@ func handleSignals(finalizer func(), finishedChan chan struct{}) {
 	case sig := <-sigChan:
 		// second signal exits immediately
 		log.Logvf(log.Always, "signal '%s' received; forcefully terminating", sig)
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitFailure)
 	case <-finishedChan:
 		return
 	}
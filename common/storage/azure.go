@@ -0,0 +1,350 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Block blobs allow 50,000 blocks, so blocks start at 8MB and double every
+// 5,000 blocks.
+const (
+	azureBaseBlockSize = 8 << 20
+	azureBlockStep     = 5000
+)
+
+// azureVersion is the Blob service REST API version sent with requests.
+const azureVersion = "2021-08-06"
+
+// azureBackend writes block blobs to an Azure Storage container. The account
+// and its credentials come from AZURE_STORAGE_CONNECTION_STRING, or from
+// AZURE_STORAGE_ACCOUNT with AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN.
+// Without a key or SAS token, a managed identity token is used.
+type azureBackend struct {
+	container string
+	prefix    string
+	account   string
+	endpoint  string
+	key       []byte
+	sas       string
+
+	mutex sync.Mutex
+	token *oauthToken
+}
+
+func newAzureBackend(container, prefix string) (Backend, error) {
+	b := &azureBackend{container: container, prefix: prefix, account: os.Getenv("AZURE_STORAGE_ACCOUNT")}
+	key := os.Getenv("AZURE_STORAGE_KEY")
+	b.sas = os.Getenv("AZURE_STORAGE_SAS_TOKEN")
+
+	if conn := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); conn != "" {
+		for _, part := range strings.Split(conn, ";") {
+			i := strings.Index(part, "=")
+			if i < 0 {
+				continue
+			}
+			value := part[i+1:]
+			switch strings.ToLower(part[:i]) {
+			case "accountname":
+				b.account = value
+			case "accountkey":
+				key = value
+			case "blobendpoint":
+				b.endpoint = strings.TrimSuffix(value, "/")
+			case "sharedaccesssignature":
+				b.sas = value
+			}
+		}
+	}
+	if b.account == "" && b.endpoint == "" {
+		return nil, fmt.Errorf("no Azure storage account found; set AZURE_STORAGE_ACCOUNT or AZURE_STORAGE_CONNECTION_STRING")
+	}
+	if b.endpoint == "" {
+		b.endpoint = fmt.Sprintf("https://%v.blob.core.windows.net", b.account)
+	}
+	if key != "" {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Azure storage account key: %v", err)
+		}
+		b.key = decoded
+	}
+	b.sas = strings.TrimPrefix(b.sas, "?")
+	return b, nil
+}
+
+func (b *azureBackend) Location(name string) string {
+	return "azblob://" + b.container + "/" + objectName(b.prefix, name)
+}
+
+func (b *azureBackend) Create(name string) (io.WriteCloser, error) {
+	if b.key == nil && b.sas == "" {
+		if _, err := b.bearerToken(); err != nil {
+			return nil, err
+		}
+	}
+	return newPartWriter(&azureUpload{backend: b, name: objectName(b.prefix, name), location: b.Location(name)}), nil
+}
+
+func (b *azureBackend) List(prefix string) ([]string, error) {
+	return objectNames(b.Objects(prefix))
+}
+
+// Objects lists the blobs under prefix, a page at a time.
+func (b *azureBackend) Objects(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {objectName(b.prefix, prefix)}}
+	for {
+		// the container's URL is the URL of a blob with an empty name,
+		// without the trailing slash
+		resp, err := do(request{method: "GET", url: strings.Replace(b.blobURL("", query), "/?", "?", 1), sign: b.sign})
+		if err != nil {
+			return nil, fmt.Errorf("error listing %v: %v", b.Location(prefix), err)
+		}
+		var result struct {
+			Blobs []struct {
+				Name string
+				Size int64 `xml:"Properties>Content-Length"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string
+		}
+		if err = xml.Unmarshal(resp.body, &result); err != nil {
+			return nil, fmt.Errorf("error listing %v: %v", b.Location(prefix), err)
+		}
+		for _, blob := range result.Blobs {
+			objects = append(objects, ObjectInfo{Name: relativeName(b.prefix, blob.Name), Size: blob.Size})
+		}
+		if result.NextMarker == "" {
+			return objects, nil
+		}
+		query.Set("marker", result.NextMarker)
+	}
+}
+
+func (b *azureBackend) Open(name string) (io.ReadCloser, error) {
+	r := request{method: "GET", url: b.blobURL(objectName(b.prefix, name), nil), sign: b.sign}
+	return newRangeReader(b.Location(name), r, pinETag)
+}
+
+func (b *azureBackend) Delete(name string) error {
+	_, err := do(request{method: "DELETE", url: b.blobURL(objectName(b.prefix, name), nil), sign: b.sign})
+	if err != nil {
+		return fmt.Errorf("error deleting %v: %v", b.Location(name), err)
+	}
+	return nil
+}
+
+func (b *azureBackend) blobURL(name string, query url.Values) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	u := b.endpoint + "/" + url.PathEscape(b.container) + "/" + strings.Join(segments, "/")
+	rawQuery := query.Encode()
+	if b.sas != "" {
+		if rawQuery != "" {
+			rawQuery += "&"
+		}
+		rawQuery += b.sas
+	}
+	if rawQuery != "" {
+		u += "?" + rawQuery
+	}
+	return u
+}
+
+// bearerToken returns a managed identity token for the storage service.
+func (b *azureBackend) bearerToken() (string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.token != nil && time.Until(b.token.expiry) > 5*time.Minute {
+		return b.token.token, nil
+	}
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {"https://storage.azure.com/"}}
+	if clientID := os.Getenv("AZURE_CLIENT_ID"); clientID != "" {
+		query.Set("client_id", clientID)
+	}
+	resp, err := doOnce(request{
+		method: "GET",
+		url:    "http://169.254.169.254/metadata/identity/oauth2/token?" + query.Encode(),
+		header: http.Header{"Metadata": {"true"}},
+		client: metadataClient,
+	})
+	if err != nil {
+		return "", fmt.Errorf("no Azure credentials found; set AZURE_STORAGE_KEY, " +
+			"AZURE_STORAGE_SAS_TOKEN or AZURE_STORAGE_CONNECTION_STRING")
+	}
+	// unlike other token endpoints, expires_in is a string
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in,string"`
+	}
+	if err = json.Unmarshal(resp.body, &result); err != nil || result.AccessToken == "" {
+		return "", fmt.Errorf("unexpected response from Azure managed identity endpoint")
+	}
+	token := &oauthToken{
+		token:  result.AccessToken,
+		expiry: time.Now().Add(time.Duration(result.ExpiresIn) * time.Second),
+	}
+	b.token = token
+	return token.token, nil
+}
+
+// sign authenticates the request with the account key, or with a managed
+// identity token. Requests with a SAS token are already authorized.
+func (b *azureBackend) sign(req *http.Request, body []byte) error {
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureVersion)
+	switch {
+	case b.key != nil:
+		req.Header.Set("Authorization", "SharedKey "+b.account+":"+b.sharedKeySignature(req, len(body)))
+	case b.sas != "":
+	default:
+		token, err := b.bearerToken()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// sharedKeySignature signs the request as the Shared Key scheme requires.
+func (b *azureBackend) sharedKeySignature(req *http.Request, length int) string {
+	contentLength := ""
+	if length > 0 {
+		contentLength = fmt.Sprint(length)
+	}
+	var headers []string
+	for key := range req.Header {
+		if lower := strings.ToLower(key); strings.HasPrefix(lower, "x-ms-") {
+			headers = append(headers, lower)
+		}
+	}
+	sort.Strings(headers)
+	var canonical strings.Builder
+	for _, name := range headers {
+		canonical.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	resource := "/" + b.account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	var params []string
+	for key := range query {
+		params = append(params, key)
+	}
+	sort.Strings(params)
+	for _, key := range params {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(key) + ":" + strings.Join(values, ",")
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, which x-ms-date replaces
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		canonical.String() + resource,
+	}, "\n")
+	mac := hmac.New(sha256.New, b.key)
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// azureUpload uploads a block blob, staging blocks once the blob is larger
+// than a block.
+type azureUpload struct {
+	backend  *azureBackend
+	name     string
+	location string
+	blocks   []string
+}
+
+func (u *azureUpload) partSize(part int) int {
+	return growingPartSize(azureBaseBlockSize, azureBlockStep, part)
+}
+
+func (u *azureUpload) uploadPart(part int, data []byte) error {
+	// block IDs must all have the same length
+	id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%08d", part)))
+	_, err := do(request{
+		method: "PUT",
+		url:    u.backend.blobURL(u.name, url.Values{"comp": {"block"}, "blockid": {id}}),
+		body:   data,
+		sign:   u.backend.sign,
+	})
+	if err != nil {
+		return fmt.Errorf("error uploading block %v of %v: %v", part, u.location, err)
+	}
+	u.blocks = append(u.blocks, id)
+	return nil
+}
+
+func (u *azureUpload) finish(part int, data []byte) error {
+	if len(u.blocks) == 0 {
+		_, err := do(request{
+			method: "PUT",
+			url:    u.backend.blobURL(u.name, nil),
+			header: http.Header{"X-Ms-Blob-Type": {"BlockBlob"}},
+			body:   data,
+			sign:   u.backend.sign,
+		})
+		if err != nil {
+			return fmt.Errorf("error uploading %v: %v", u.location, err)
+		}
+		return nil
+	}
+	if len(data) > 0 {
+		if err := u.uploadPart(part, data); err != nil {
+			return err
+		}
+	}
+	list := struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{Latest: u.blocks}
+	body, err := xml.Marshal(list)
+	if err != nil {
+		return err
+	}
+	_, err = do(request{
+		method: "PUT",
+		url:    u.backend.blobURL(u.name, url.Values{"comp": {"blocklist"}}),
+		header: http.Header{"Content-Type": {"application/xml"}},
+		body:   append([]byte(xml.Header), body...),
+		sign:   u.backend.sign,
+	})
+	if err != nil {
+		return fmt.Errorf("error committing %v: %v", u.location, err)
+	}
+	return nil
+}
+
+// abort does nothing, since uncommitted blocks are discarded by the service.
+func (u *azureUpload) abort() {}
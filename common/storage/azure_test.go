@@ -0,0 +1,132 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeAzure is a Blob service that stores block blobs in memory, and only
+// accepts requests with its SAS token.
+type fakeAzure struct {
+	mutex    sync.Mutex
+	blobs    map[string][]byte
+	blocks   map[string][]byte
+	requests []string
+	// failBlock fails the first attempt to stage the block with a server
+	// error
+	failBlock string
+	failed    bool
+}
+
+func (s *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	query := r.URL.Query()
+	s.requests = append(s.requests, r.Method+" "+query.Get("comp"))
+	if query.Get("sig") != "secret" || r.Header.Get("x-ms-version") != azureVersion {
+		http.Error(w, "AuthenticationFailed", http.StatusForbidden)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/container/")
+	switch {
+	case r.Method == "PUT" && query.Get("comp") == "block":
+		id := query.Get("blockid")
+		if id == s.failBlock && !s.failed {
+			s.failed = true
+			http.Error(w, "InternalError", http.StatusInternalServerError)
+			return
+		}
+		s.blocks[id] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "PUT" && query.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		xml.Unmarshal(body, &list)
+		var blob []byte
+		for _, id := range list.Latest {
+			block, ok := s.blocks[id]
+			if !ok {
+				http.Error(w, "InvalidBlockList", http.StatusBadRequest)
+				return
+			}
+			blob = append(blob, block...)
+		}
+		s.blobs[name] = blob
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "PUT" && r.Header.Get("X-Ms-Blob-Type") == "BlockBlob":
+		s.blobs[name] = body
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "UnsupportedHttpVerb", http.StatusMethodNotAllowed)
+	}
+}
+
+func TestAzureUpload(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a Blob service and a SAS token", t, func() {
+		defer noRetryDelay()()
+		azure := &fakeAzure{blobs: map[string][]byte{}, blocks: map[string][]byte{}}
+		server := httptest.NewServer(azure)
+		defer server.Close()
+		defer setEnv(map[string]string{
+			"AZURE_STORAGE_CONNECTION_STRING": "BlobEndpoint=" + server.URL + ";SharedAccessSignature=sv=2021-08-06&sig=secret",
+			"AZURE_STORAGE_ACCOUNT":           "",
+			"AZURE_STORAGE_KEY":               "",
+			"AZURE_STORAGE_SAS_TOKEN":         "",
+		})()
+		backend, err := Open("azblob://container/prefix")
+		So(err, ShouldBeNil)
+
+		write := func(name string, data []byte) error {
+			w, err := backend.Create(name)
+			So(err, ShouldBeNil)
+			if _, err = w.Write(data); err != nil {
+				return err
+			}
+			return w.Close()
+		}
+
+		Convey("a small blob should be uploaded with a single request", func() {
+			So(write("small.bson", []byte("small blob")), ShouldBeNil)
+			So(string(azure.blobs["prefix/small.bson"]), ShouldEqual, "small blob")
+			So(azure.requests, ShouldResemble, []string{"PUT "})
+		})
+
+		Convey("a large blob should be staged in blocks and committed", func() {
+			data := make([]byte, azureBaseBlockSize+1000)
+			rand.Read(data)
+			So(write("large.bson", data), ShouldBeNil)
+			So(bytes.Equal(azure.blobs["prefix/large.bson"], data), ShouldBeTrue)
+			So(azure.requests, ShouldResemble, []string{"PUT block", "PUT block", "PUT blocklist"})
+		})
+
+		Convey("a block that fails with a server error should be retried", func() {
+			azure.failBlock = "YmxvY2stMDAwMDAwMDE="
+			data := make([]byte, azureBaseBlockSize+1000)
+			rand.Read(data)
+			So(write("large.bson", data), ShouldBeNil)
+			So(bytes.Equal(azure.blobs["prefix/large.bson"], data), ShouldBeTrue)
+			So(azure.requests, ShouldResemble, []string{"PUT block", "PUT block", "PUT block", "PUT blocklist"})
+		})
+	})
+}
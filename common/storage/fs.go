@@ -0,0 +1,129 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fileSystems holds the file systems registered with RegisterFS, by name.
+var fileSystems = struct {
+	sync.Mutex
+	systems map[string]http.FileSystem
+}{systems: map[string]http.FileSystem{}}
+
+// RegisterFS makes the files of fsys readable as the objects under the
+// location fs://<name>, which it returns, so that a dump in any file system
+// is read like one in object storage. The name must be a valid URL host.
+func RegisterFS(name string, fsys http.FileSystem) string {
+	fileSystems.Lock()
+	fileSystems.systems[name] = fsys
+	fileSystems.Unlock()
+	return "fs://" + name
+}
+
+// UnregisterFS removes the file system registered as name, and the readers
+// of its locations opened since.
+func UnregisterFS(name string) {
+	fileSystems.Lock()
+	delete(fileSystems.systems, name)
+	fileSystems.Unlock()
+
+	location := "fs://" + name
+	readers.Lock()
+	defer readers.Unlock()
+	for key := range readers.backends {
+		if key == location || strings.HasPrefix(key, location+"/") {
+			delete(readers.backends, key)
+		}
+	}
+}
+
+// fsBackend reads the files under a directory of a registered file system.
+// It cannot create them.
+type fsBackend struct {
+	name   string
+	fsys   http.FileSystem
+	prefix string
+}
+
+func newFSBackend(name, prefix string) (Backend, error) {
+	fileSystems.Lock()
+	defer fileSystems.Unlock()
+	fsys, ok := fileSystems.systems[name]
+	if !ok {
+		return nil, fmt.Errorf("no file system is registered as '%v'", name)
+	}
+	return &fsBackend{name: name, fsys: fsys, prefix: prefix}, nil
+}
+
+func (b *fsBackend) Location(name string) string {
+	return "fs://" + b.name + "/" + objectName(b.prefix, name)
+}
+
+func (b *fsBackend) Create(name string) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("cannot create %v: file systems are read-only", b.Location(name))
+}
+
+func (b *fsBackend) Objects(prefix string) ([]ObjectInfo, error) {
+	root := b.prefix
+	if prefix != "" {
+		root = objectName(b.prefix, prefix)
+	}
+	var objects []ObjectInfo
+	err := b.walk(root, &objects)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing %v: %v", b.Location(prefix), err)
+	}
+	return objects, nil
+}
+
+// walk adds the files under the directory name to objects, in lexical
+// order. Like a listing of a prefix, a directory lists the files under it
+// but a file lists nothing.
+func (b *fsBackend) walk(name string, objects *[]ObjectInfo) error {
+	dir, err := b.fsys.Open(path.Join("/", name))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	info, err := dir.Stat()
+	if err != nil || !info.IsDir() {
+		return err
+	}
+	entries, err := dir.Readdir(-1)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, entry := range entries {
+		child := entry.Name()
+		if name != "" {
+			child = name + "/" + child
+		}
+		if !entry.IsDir() {
+			*objects = append(*objects, ObjectInfo{Name: relativeName(b.prefix, child), Size: entry.Size()})
+		} else if err = b.walk(child, objects); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *fsBackend) Open(name string) (io.ReadCloser, error) {
+	return b.fsys.Open(path.Join("/", objectName(b.prefix, name)))
+}
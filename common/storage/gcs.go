@@ -0,0 +1,452 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package storage
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GCS resumable uploads have no limit on the number of chunks, but every
+// chunk except the last must be a multiple of 256KB.
+const gcsChunkSize = 16 << 20
+
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// oauthToken is a bearer token and its expiry.
+type oauthToken struct {
+	token  string
+	expiry time.Time
+}
+
+// gcsBackend writes objects to a Google Cloud Storage bucket, or to the
+// emulator at STORAGE_EMULATOR_HOST.
+type gcsBackend struct {
+	bucket   string
+	prefix   string
+	endpoint string
+	// emulators do not authenticate requests
+	emulator bool
+
+	mutex sync.Mutex
+	token *oauthToken
+}
+
+func newGCSBackend(bucket, prefix string) (Backend, error) {
+	b := &gcsBackend{bucket: bucket, prefix: prefix, endpoint: "https://storage.googleapis.com"}
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		b.endpoint = strings.TrimSuffix(host, "/")
+		b.emulator = true
+	}
+	return b, nil
+}
+
+func (b *gcsBackend) Location(name string) string {
+	return "gs://" + b.bucket + "/" + objectName(b.prefix, name)
+}
+
+func (b *gcsBackend) Create(name string) (io.WriteCloser, error) {
+	if _, err := b.bearerToken(); err != nil {
+		return nil, err
+	}
+	return newPartWriter(&gcsUpload{backend: b, name: objectName(b.prefix, name), location: b.Location(name)}), nil
+}
+
+func (b *gcsBackend) List(prefix string) ([]string, error) {
+	return objectNames(b.Objects(prefix))
+}
+
+// Objects lists the objects under prefix, a page at a time.
+func (b *gcsBackend) Objects(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	query := url.Values{"prefix": {objectName(b.prefix, prefix)}, "fields": {"items(name,size),nextPageToken"}}
+	for {
+		resp, err := do(request{
+			method: "GET",
+			url:    fmt.Sprintf("%v/storage/v1/b/%v/o?%v", b.endpoint, url.PathEscape(b.bucket), query.Encode()),
+			sign:   b.sign,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing %v: %v", b.Location(prefix), err)
+		}
+		var result struct {
+			Items []struct {
+				Name string `json:"name"`
+				// sizes are given as strings
+				Size int64 `json:"size,string"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err = json.Unmarshal(resp.body, &result); err != nil {
+			return nil, fmt.Errorf("error listing %v: %v", b.Location(prefix), err)
+		}
+		for _, object := range result.Items {
+			objects = append(objects, ObjectInfo{Name: relativeName(b.prefix, object.Name), Size: object.Size})
+		}
+		if result.NextPageToken == "" {
+			return objects, nil
+		}
+		query.Set("pageToken", result.NextPageToken)
+	}
+}
+
+func (b *gcsBackend) Open(name string) (io.ReadCloser, error) {
+	objectURL := fmt.Sprintf("%v/storage/v1/b/%v/o/%v", b.endpoint, url.PathEscape(b.bucket),
+		url.PathEscape(objectName(b.prefix, name)))
+	r := request{method: "GET", url: objectURL + "?alt=media", sign: b.sign}
+	// the JSON API pins a generation of the object rather than an ETag
+	pin := func(r *request, header http.Header) {
+		if generation := header.Get("X-Goog-Generation"); generation != "" {
+			r.url = objectURL + "?" + url.Values{"alt": {"media"}, "ifGenerationMatch": {generation}}.Encode()
+		}
+	}
+	return newRangeReader(b.Location(name), r, pin)
+}
+
+func (b *gcsBackend) Delete(name string) error {
+	_, err := do(request{
+		method: "DELETE",
+		url: fmt.Sprintf("%v/storage/v1/b/%v/o/%v", b.endpoint, url.PathEscape(b.bucket),
+			url.PathEscape(objectName(b.prefix, name))),
+		sign: b.sign,
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting %v: %v", b.Location(name), err)
+	}
+	return nil
+}
+
+// bearerToken returns the cached access token, refreshing it if it is about
+// to expire. It returns an empty token for emulators.
+func (b *gcsBackend) bearerToken() (string, error) {
+	if b.emulator {
+		return "", nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.token == nil || (!b.token.expiry.IsZero() && time.Until(b.token.expiry) < 5*time.Minute) {
+		token, err := loadGoogleToken()
+		if err != nil {
+			return "", err
+		}
+		b.token = token
+	}
+	return b.token.token, nil
+}
+
+// sign adds the bearer token to the request.
+func (b *gcsBackend) sign(req *http.Request, _ []byte) error {
+	token, err := b.bearerToken()
+	if err != nil || token == "" {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// gcsUpload uploads an object, starting a resumable upload session once
+// the object is larger than a chunk.
+type gcsUpload struct {
+	backend  *gcsBackend
+	name     string
+	location string
+	session  string
+	offset   int64
+}
+
+func (u *gcsUpload) partSize(int) int {
+	return gcsChunkSize
+}
+
+func (u *gcsUpload) uploadURL(uploadType string) string {
+	return fmt.Sprintf("%v/upload/storage/v1/b/%v/o?uploadType=%v&name=%v",
+		u.backend.endpoint, url.PathEscape(u.backend.bucket), uploadType, url.QueryEscape(u.name))
+}
+
+func (u *gcsUpload) uploadPart(_ int, data []byte) error {
+	if u.session == "" {
+		resp, err := do(request{
+			method: "POST",
+			url:    u.uploadURL("resumable"),
+			header: http.Header{"X-Upload-Content-Type": {"application/octet-stream"}},
+			sign:   u.backend.sign,
+		})
+		if err != nil {
+			return fmt.Errorf("error starting upload to %v: %v", u.location, err)
+		}
+		u.session = resp.header.Get("Location")
+		if u.session == "" {
+			return fmt.Errorf("error starting upload to %v: no upload session", u.location)
+		}
+	}
+	return u.put(data, "*")
+}
+
+// put uploads a chunk of the session; total is "*" until the last chunk.
+// A failed request may still have stored some of the chunk, so before each
+// retry the session is asked how much it has, and only the rest is resent.
+func (u *gcsUpload) put(data []byte, total string) error {
+	start := u.offset
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(retryDelay(attempt - 1))
+			var complete bool
+			if complete, err = u.status(start, len(data)); err != nil {
+				if isRetryable(err) {
+					continue
+				}
+				break
+			}
+			if complete || (u.offset == start+int64(len(data)) && total == "*") {
+				u.offset = start + int64(len(data))
+				return nil
+			}
+		}
+		chunk := data[u.offset-start:]
+		contentRange := fmt.Sprintf("bytes %v-%v/%v", u.offset, u.offset+int64(len(chunk))-1, total)
+		if len(chunk) == 0 {
+			contentRange = "bytes */" + total
+		}
+		_, err = doOnce(request{
+			method: "PUT",
+			url:    u.session,
+			header: http.Header{"Content-Range": {contentRange}},
+			body:   chunk,
+			sign:   u.backend.sign,
+			// 308 means the chunk was stored and more are expected
+			ok: []int{http.StatusOK, http.StatusCreated, 308},
+		})
+		if err == nil {
+			u.offset = start + int64(len(data))
+			return nil
+		}
+		if !isRetryable(err) {
+			break
+		}
+	}
+	return fmt.Errorf("error uploading to %v: %v", u.location, err)
+}
+
+// status asks the session how much of the object it has stored, and sets
+// the offset to it. It returns true if the object is already complete. The
+// offset must be within the chunk of size bytes at start being uploaded.
+func (u *gcsUpload) status(start int64, size int) (bool, error) {
+	resp, err := doOnce(request{
+		method: "PUT",
+		url:    u.session,
+		header: http.Header{"Content-Range": {"bytes */*"}},
+		sign:   u.backend.sign,
+		ok:     []int{http.StatusOK, http.StatusCreated, 308},
+	})
+	if err != nil {
+		return false, err
+	}
+	if resp.status != 308 {
+		return true, nil
+	}
+	// the Range header is "bytes=0-<last byte stored>", and is missing if
+	// nothing has been stored
+	committed := int64(0)
+	if stored := resp.header.Get("Range"); stored != "" {
+		var last int64
+		if _, err = fmt.Sscanf(stored, "bytes=0-%d", &last); err != nil {
+			return false, fmt.Errorf("unexpected upload status Range '%v'", stored)
+		}
+		committed = last + 1
+	}
+	if committed < start || committed > start+int64(size) {
+		return false, fmt.Errorf("upload session has %v bytes, but %v to %v were being uploaded",
+			committed, start, start+int64(size))
+	}
+	u.offset = committed
+	return false, nil
+}
+
+func (u *gcsUpload) finish(_ int, data []byte) error {
+	if u.session == "" {
+		_, err := do(request{
+			method: "POST",
+			url:    u.uploadURL("media"),
+			header: http.Header{"Content-Type": {"application/octet-stream"}},
+			body:   data,
+			sign:   u.backend.sign,
+		})
+		if err != nil {
+			return fmt.Errorf("error uploading %v: %v", u.location, err)
+		}
+		return nil
+	}
+	if err := u.put(data, fmt.Sprint(u.offset+int64(len(data)))); err != nil {
+		return err
+	}
+	u.session = ""
+	return nil
+}
+
+func (u *gcsUpload) abort() {
+	if u.session != "" {
+		do(request{method: "DELETE", url: u.session, sign: u.backend.sign, ok: []int{499, http.StatusNoContent}})
+		u.session = ""
+	}
+}
+
+// loadGoogleToken gets an access token in the same way as Application
+// Default Credentials: from GOOGLE_OAUTH_ACCESS_TOKEN, the credentials file
+// at GOOGLE_APPLICATION_CREDENTIALS or the gcloud default, or the GCE
+// metadata server.
+func loadGoogleToken() (*oauthToken, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return &oauthToken{token: token}, nil
+	}
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+			if _, err = os.Stat(path); err != nil {
+				path = ""
+			}
+		}
+	}
+	if path != "" {
+		return googleFileToken(path)
+	}
+	resp, err := doOnce(request{
+		method: "GET",
+		url:    "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token",
+		header: http.Header{"Metadata-Flavor": {"Google"}},
+		client: metadataClient,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("no Google Cloud credentials found; set GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	return parseOAuthToken(resp.body)
+}
+
+// googleFileToken exchanges the service account key or user refresh token in
+// a credentials file for an access token.
+func googleFileToken(path string) (*oauthToken, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading Google Cloud credentials: %v", err)
+	}
+	var file struct {
+		Type         string `json:"type"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		TokenURI     string `json:"token_uri"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing Google Cloud credentials %v: %v", path, err)
+	}
+	tokenURI := file.TokenURI
+	if tokenURI == "" {
+		tokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	form := url.Values{}
+	switch file.Type {
+	case "service_account":
+		assertion, err := googleJWT(file.ClientEmail, file.PrivateKey, tokenURI)
+		if err != nil {
+			return nil, fmt.Errorf("error signing Google Cloud credentials %v: %v", path, err)
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	case "authorized_user":
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", file.ClientID)
+		form.Set("client_secret", file.ClientSecret)
+		form.Set("refresh_token", file.RefreshToken)
+	default:
+		return nil, fmt.Errorf("unsupported Google Cloud credentials type '%v' in %v", file.Type, path)
+	}
+	resp, err := do(request{
+		method: "POST",
+		url:    tokenURI,
+		header: http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+		body:   []byte(form.Encode()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting Google Cloud access token: %v", err)
+	}
+	return parseOAuthToken(resp.body)
+}
+
+// googleJWT returns a signed assertion for a service account.
+func googleJWT(email, privateKey, audience string) (string, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return "", fmt.Errorf("invalid private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return "", fmt.Errorf("invalid private key: %v", err)
+		}
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("private key is not an RSA key")
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   email,
+		"scope": gcsScope,
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	encoding := base64.RawURLEncoding
+	unsigned := encoding.EncodeToString(header) + "." + encoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + encoding.EncodeToString(signature), nil
+}
+
+func parseOAuthToken(body []byte) (*oauthToken, error) {
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil || result.AccessToken == "" {
+		return nil, fmt.Errorf("unexpected response from token endpoint")
+	}
+	token := &oauthToken{token: result.AccessToken}
+	if result.ExpiresIn > 0 {
+		token.expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
@@ -0,0 +1,177 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeGCS is a Cloud Storage emulator that implements uploads, and a single
+// resumable upload session at a time.
+type fakeGCS struct {
+	mutex    sync.Mutex
+	url      string
+	objects  map[string][]byte
+	session  string
+	stored   []byte
+	requests []string
+	// fail makes the first request to upload the chunk at failOffset fail
+	// with a server error, after storing failStore bytes of it
+	failOffset int64
+	failStore  int
+	failed     bool
+}
+
+func (s *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	contentRange := r.Header.Get("Content-Range")
+	s.requests = append(s.requests, strings.TrimSpace(r.Method+" "+contentRange))
+
+	switch {
+	case r.Method == "POST" && r.URL.Query().Get("uploadType") == "media":
+		s.objects[r.URL.Query().Get("name")] = body
+	case r.Method == "POST" && r.URL.Query().Get("uploadType") == "resumable":
+		s.session = r.URL.Query().Get("name")
+		s.stored = nil
+		w.Header().Set("Location", s.url+"/session")
+	case r.Method == "PUT" && r.URL.Path == "/session" && s.session != "":
+		s.put(w, contentRange, body)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// put stores a chunk of the upload session, which has to start at the end
+// of what is stored, as the service requires.
+func (s *fakeGCS) put(w http.ResponseWriter, contentRange string, body []byte) {
+	var first, last int64
+	var total string
+	if n, _ := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &first, &last, &total); n != 3 {
+		total = strings.TrimPrefix(contentRange, "bytes */")
+		first, last = int64(len(s.stored)), int64(len(s.stored))-1
+		if len(body) != 0 {
+			http.Error(w, "body without a range", http.StatusBadRequest)
+			return
+		}
+	}
+	if first != int64(len(s.stored)) || last-first+1 != int64(len(body)) {
+		http.Error(w, fmt.Sprintf("range %v does not follow %v bytes", contentRange, len(s.stored)),
+			http.StatusBadRequest)
+		return
+	}
+	if first == s.failOffset && len(body) > 0 && !s.failed {
+		s.failed = true
+		s.stored = append(s.stored, body[:s.failStore]...)
+		http.Error(w, "backend error", http.StatusServiceUnavailable)
+		return
+	}
+	s.stored = append(s.stored, body...)
+	if size, err := strconv.Atoi(total); err == nil && size == len(s.stored) {
+		s.objects[s.session] = s.stored
+		s.session = ""
+		return
+	}
+	if len(s.stored) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%v", len(s.stored)-1))
+	}
+	w.WriteHeader(308)
+}
+
+func TestGCSUpload(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a Cloud Storage emulator", t, func() {
+		defer noRetryDelay()()
+		gcs := &fakeGCS{objects: map[string][]byte{}, failOffset: -1}
+		server := httptest.NewServer(gcs)
+		defer server.Close()
+		gcs.url = server.URL
+		defer setEnv(map[string]string{"STORAGE_EMULATOR_HOST": server.URL})()
+		backend, err := Open("gs://bucket/prefix")
+		So(err, ShouldBeNil)
+
+		write := func(name string, data []byte) error {
+			w, err := backend.Create(name)
+			So(err, ShouldBeNil)
+			if _, err = w.Write(data); err != nil {
+				return err
+			}
+			return w.Close()
+		}
+		data := make([]byte, gcsChunkSize+1000)
+		rand.Read(data)
+		size := len(data)
+
+		Convey("a small object should be uploaded with a single request", func() {
+			So(write("small.bson", []byte("small object")), ShouldBeNil)
+			So(string(gcs.objects["prefix/small.bson"]), ShouldEqual, "small object")
+			So(gcs.requests, ShouldResemble, []string{"POST"})
+		})
+
+		Convey("a large object should be uploaded in chunks of a session", func() {
+			So(write("large.bson", data), ShouldBeNil)
+			So(bytes.Equal(gcs.objects["prefix/large.bson"], data), ShouldBeTrue)
+			So(gcs.requests, ShouldResemble, []string{
+				"POST",
+				fmt.Sprintf("PUT bytes 0-%v/*", gcsChunkSize-1),
+				fmt.Sprintf("PUT bytes %v-%v/%v", gcsChunkSize, size-1, size),
+			})
+		})
+
+		Convey("a chunk that is partly stored should be resent from where the session stopped", func() {
+			gcs.failOffset, gcs.failStore = 0, 1<<20
+			So(write("large.bson", data), ShouldBeNil)
+			So(bytes.Equal(gcs.objects["prefix/large.bson"], data), ShouldBeTrue)
+			So(gcs.requests, ShouldResemble, []string{
+				"POST",
+				fmt.Sprintf("PUT bytes 0-%v/*", gcsChunkSize-1),
+				"PUT bytes */*",
+				fmt.Sprintf("PUT bytes %v-%v/*", 1<<20, gcsChunkSize-1),
+				fmt.Sprintf("PUT bytes %v-%v/%v", gcsChunkSize, size-1, size),
+			})
+		})
+
+		Convey("a chunk that is stored despite an error should not be resent", func() {
+			gcs.failOffset, gcs.failStore = 0, gcsChunkSize
+			So(write("large.bson", data), ShouldBeNil)
+			So(bytes.Equal(gcs.objects["prefix/large.bson"], data), ShouldBeTrue)
+			So(gcs.requests, ShouldResemble, []string{
+				"POST",
+				fmt.Sprintf("PUT bytes 0-%v/*", gcsChunkSize-1),
+				"PUT bytes */*",
+				fmt.Sprintf("PUT bytes %v-%v/%v", gcsChunkSize, size-1, size),
+			})
+		})
+
+		Convey("a last chunk that is stored despite an error should complete the object", func() {
+			gcs.failOffset, gcs.failStore = gcsChunkSize, 1000
+			So(write("large.bson", data), ShouldBeNil)
+			So(bytes.Equal(gcs.objects["prefix/large.bson"], data), ShouldBeTrue)
+			So(gcs.requests, ShouldResemble, []string{
+				"POST",
+				fmt.Sprintf("PUT bytes 0-%v/*", gcsChunkSize-1),
+				fmt.Sprintf("PUT bytes %v-%v/%v", gcsChunkSize, size-1, size),
+				"PUT bytes */*",
+				fmt.Sprintf("PUT bytes */%v", size),
+			})
+		})
+	})
+}
@@ -0,0 +1,188 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxAttempts is how many times a request is sent before giving up on
+// network errors, throttling and server errors.
+const maxAttempts = 4
+
+// httpClient has no overall timeout, since large parts can take a long time
+// to upload, but bounds connection setup and waiting for responses.
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Minute,
+		MaxIdleConnsPerHost:   16,
+	},
+}
+
+// metadataClient is used for credential endpoints on the local network,
+// which respond quickly when they exist at all.
+var metadataClient = &http.Client{Timeout: 3 * time.Second}
+
+// timeNow is replaced in tests to sign requests at a fixed time.
+var timeNow = time.Now
+
+// retryDelay is the delay before the given retry, numbered from 1.
+var retryDelay = func(retry int) time.Duration {
+	return time.Duration(1<<uint(retry-1)) * time.Second
+}
+
+// request describes an HTTP request that can be sent more than once.
+type request struct {
+	method string
+	url    string
+	header http.Header
+	body   []byte
+	// sign adds authentication to the request before each attempt
+	sign func(*http.Request, []byte) error
+	// ok lists the expected status codes, which default to 200-299
+	ok []int
+	// client defaults to httpClient
+	client *http.Client
+	// maxBody is the most of the response body that is read, which
+	// defaults to 1MB
+	maxBody int64
+}
+
+// response is a fully read HTTP response.
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// do sends the request, retrying failures that may be transient.
+func do(r request) (*response, error) {
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(retryDelay(attempt - 1))
+		}
+		var resp *response
+		resp, err = doOnce(r)
+		if err == nil {
+			return resp, nil
+		}
+		if !isRetryable(err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func doOnce(r request) (*response, error) {
+	req, err := http.NewRequest(r.method, r.url, bytes.NewReader(r.body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range r.header {
+		req.Header[key] = values
+	}
+	req.ContentLength = int64(len(r.body))
+	if r.sign != nil {
+		if err = r.sign(req, r.body); err != nil {
+			return nil, err
+		}
+	}
+	client := r.client
+	if client == nil {
+		client = httpClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, transientError{err}
+	}
+	defer resp.Body.Close()
+	maxBody := r.maxBody
+	if maxBody == 0 {
+		maxBody = 1 << 20
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return nil, transientError{err}
+	}
+	if !expectedStatus(resp.StatusCode, r.ok) {
+		return nil, &statusError{method: r.method, url: redactedURL(req.URL), status: resp.StatusCode, body: body}
+	}
+	return &response{status: resp.StatusCode, header: resp.Header, body: body}, nil
+}
+
+// redactedURL returns u without its user info, for messages.
+func redactedURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	return redacted.String()
+}
+
+func expectedStatus(status int, ok []int) bool {
+	if len(ok) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, code := range ok {
+		if status == code {
+			return true
+		}
+	}
+	return false
+}
+
+// transientError is a network error, which is always retried.
+type transientError struct {
+	error
+}
+
+// statusError is an unexpected HTTP response.
+type statusError struct {
+	method string
+	url    string
+	status int
+	body   []byte
+}
+
+func (e *statusError) Error() string {
+	msg := strings.TrimSpace(string(e.body))
+	if len(msg) > 512 {
+		msg = msg[:512] + "..."
+	}
+	// drop the query, which may hold a signature or upload session
+	u := e.url
+	if i := strings.Index(u, "?"); i >= 0 {
+		u = u[:i]
+	}
+	if msg == "" {
+		return fmt.Sprintf("%v %v: %v", e.method, u, http.StatusText(e.status))
+	}
+	return fmt.Sprintf("%v %v: %v: %v", e.method, u, http.StatusText(e.status), msg)
+}
+
+func isRetryable(err error) bool {
+	switch e := err.(type) {
+	case transientError:
+		return true
+	case *statusError:
+		return e.status == http.StatusTooManyRequests || e.status >= 500
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package storage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// setEnv sets environment variables, and unsets the others given as empty,
+// until the returned function restores them.
+func setEnv(env map[string]string) func() {
+	saved := map[string]*string{}
+	for name, value := range env {
+		if old, ok := os.LookupEnv(name); ok {
+			saved[name] = &old
+		} else {
+			saved[name] = nil
+		}
+		if value == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+	}
+	return func() {
+		for name, old := range saved {
+			if old == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *old)
+			}
+		}
+	}
+}
+
+// noRetryDelay retries requests immediately until the returned function
+// restores the delay.
+func noRetryDelay() func() {
+	saved := retryDelay
+	retryDelay = func(int) time.Duration { return 0 }
+	return func() { retryDelay = saved }
+}
+
+func TestDo(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a server that fails some requests", t, func() {
+		defer noRetryDelay()()
+		var requests, failures int32
+		status := http.StatusServiceUnavailable
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) <= atomic.LoadInt32(&failures) {
+				http.Error(w, "try again", status)
+				return
+			}
+			w.Write([]byte("ok"))
+		}))
+		defer server.Close()
+
+		Convey("server errors and throttling should be retried", func() {
+			for _, status = range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+				atomic.StoreInt32(&requests, 0)
+				failures = 2
+				resp, err := do(request{method: "GET", url: server.URL})
+				So(err, ShouldBeNil)
+				So(string(resp.body), ShouldEqual, "ok")
+				So(atomic.LoadInt32(&requests), ShouldEqual, 3)
+			}
+		})
+
+		Convey("requests should be sent at most maxAttempts times", func() {
+			failures = 100
+			_, err := do(request{method: "GET", url: server.URL})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "try again")
+			So(atomic.LoadInt32(&requests), ShouldEqual, maxAttempts)
+		})
+
+		Convey("client errors should not be retried", func() {
+			failures, status = 100, http.StatusForbidden
+			_, err := do(request{method: "GET", url: server.URL})
+			So(err, ShouldHaveSameTypeAs, &statusError{})
+			So(err.(*statusError).status, ShouldEqual, http.StatusForbidden)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 1)
+		})
+
+		Convey("unexpected statuses should fail even if they are successful", func() {
+			_, err := do(request{method: "GET", url: server.URL, ok: []int{http.StatusNoContent}})
+			So(err, ShouldNotBeNil)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 1)
+		})
+
+		Convey("errors should not include credentials or the query", func() {
+			failures, status = 100, http.StatusForbidden
+			u := "http://user:secret@" + server.Listener.Addr().String() + "/object?signature=abc"
+			_, err := do(request{method: "PUT", url: u})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "PUT http://"+server.Listener.Addr().String()+"/object: Forbidden")
+			So(err.Error(), ShouldNotContainSubstring, "secret")
+			So(err.Error(), ShouldNotContainSubstring, "signature")
+		})
+	})
+}
@@ -0,0 +1,84 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AWSKMS generates and decrypts data keys with the AWS Key Management
+// Service. It finds credentials and the region the same way as the S3
+// backend, and uses AWS_ENDPOINT_URL_KMS for KMS-compatible services.
+type AWSKMS struct {
+	awsSigner
+	endpoint string
+}
+
+// NewAWSKMS returns a client for KMS in the configured region.
+func NewAWSKMS() (*AWSKMS, error) {
+	signer, err := newAWSSigner("kms")
+	if err != nil {
+		return nil, err
+	}
+	k := &AWSKMS{
+		awsSigner: signer,
+		endpoint:  strings.TrimSuffix(firstEnv("AWS_ENDPOINT_URL_KMS", "AWS_ENDPOINT_URL"), "/"),
+	}
+	if k.endpoint == "" {
+		k.endpoint = fmt.Sprintf("https://kms.%v.amazonaws.com", k.region)
+	}
+	return k, nil
+}
+
+// GenerateDataKey returns a new 256-bit data key and the same key encrypted
+// under the KMS key keyID.
+func (k *AWSKMS) GenerateDataKey(keyID string) (plaintext, ciphertext []byte, err error) {
+	var result struct {
+		CiphertextBlob []byte
+		Plaintext      []byte
+	}
+	err = k.call("GenerateDataKey", map[string]string{"KeyId": keyID, "KeySpec": "AES_256"}, &result)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating a data key with KMS key %v: %v", keyID, err)
+	}
+	return result.Plaintext, result.CiphertextBlob, nil
+}
+
+// Decrypt decrypts a data key returned by GenerateDataKey.
+func (k *AWSKMS) Decrypt(ciphertext []byte) ([]byte, error) {
+	var result struct {
+		Plaintext []byte
+	}
+	if err := k.call("Decrypt", map[string][]byte{"CiphertextBlob": ciphertext}, &result); err != nil {
+		return nil, fmt.Errorf("error decrypting the data key with KMS: %v", err)
+	}
+	return result.Plaintext, nil
+}
+
+func (k *AWSKMS) call(action string, input, output interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	resp, err := do(request{
+		method: "POST",
+		url:    k.endpoint + "/",
+		header: http.Header{
+			"Content-Type": {"application/x-amz-json-1.1"},
+			"X-Amz-Target": {"TrentService." + action},
+		},
+		body: body,
+		sign: k.sign,
+	})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(resp.body, output)
+}
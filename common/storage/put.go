@@ -0,0 +1,111 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// endpointSchemes are the schemes of HTTP endpoints that objects are PUT
+// to, rather than uploaded to object storage.
+var endpointSchemes = map[string]bool{"http": true, "https": true}
+
+// putBackend creates objects by streaming each one as the body of a single
+// PUT request to the URL of its name under the backend's URL. Credentials
+// are given as the user info of the URL, for basic authentication, and the
+// query of the URL is sent with each request.
+type putBackend struct {
+	base *url.URL
+}
+
+func newPutBackend(u *url.URL) (Backend, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("storage location '%v' has no host", redactedURL(u))
+	}
+	base := *u
+	base.Path = strings.Trim(u.Path, "/")
+	base.RawPath = ""
+	return &putBackend{base: &base}, nil
+}
+
+func (b *putBackend) objectURL(name string) *url.URL {
+	u := *b.base
+	u.Path = "/" + objectName(b.base.Path, name)
+	return &u
+}
+
+func (b *putBackend) Location(name string) string {
+	return redactedURL(b.objectURL(name))
+}
+
+// Create starts the PUT request of the object, whose body is what is
+// written until Close. Unlike parts uploaded to object storage, the request
+// can't be retried, as its body isn't kept.
+func (b *putBackend) Create(name string) (io.WriteCloser, error) {
+	req, err := http.NewRequest(http.MethodPut, b.objectURL(name).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	reader, writer := io.Pipe()
+	req.Body = reader
+	// the size isn't known, so the body is sent in chunks
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if user := b.base.User; user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+	}
+
+	w := &putWriter{pipe: writer, done: make(chan error, 1)}
+	go func() {
+		resp, err := httpClient.Do(req)
+		if err == nil {
+			defer resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+				err = &statusError{method: req.Method, url: b.Location(name), status: resp.StatusCode, body: body}
+			}
+		}
+		// a request that ends early fails the writes still to come
+		if err != nil {
+			reader.CloseWithError(fmt.Errorf("error uploading %v: %v", b.Location(name), err))
+		} else {
+			reader.Close()
+		}
+		w.done <- err
+	}()
+	return w, nil
+}
+
+// putWriter writes the body of a PUT request.
+type putWriter struct {
+	pipe   *io.PipeWriter
+	done   chan error
+	err    error
+	closed bool
+}
+
+func (w *putWriter) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+// Close ends the body of the request and waits for its response. The object
+// is only complete if it returns no error.
+func (w *putWriter) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	w.pipe.Close()
+	w.err = <-w.done
+	return w.err
+}
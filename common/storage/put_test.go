@@ -0,0 +1,61 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package storage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPutBackend(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With an HTTP endpoint that requires basic authentication", t, func() {
+		objects := map[string]string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "secret" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			objects[r.URL.Path+"?"+r.URL.RawQuery] = string(body)
+		}))
+		defer server.Close()
+		host := strings.TrimPrefix(server.URL, "http://")
+
+		Convey("objects should be PUT under the URL with its query", func() {
+			backend, err := Open("http://user:secret@" + host + "/dumps/?token=abc")
+			So(err, ShouldBeNil)
+			So(backend.Location("db/c.bson"), ShouldEqual, "http://"+host+"/dumps/db/c.bson?token=abc")
+
+			w, err := backend.Create("db/c.bson")
+			So(err, ShouldBeNil)
+			_, err = w.Write([]byte("object"))
+			So(err, ShouldBeNil)
+			So(w.Close(), ShouldBeNil)
+			So(objects, ShouldResemble, map[string]string{"/dumps/db/c.bson?token=abc": "object"})
+		})
+
+		Convey("errors should not include the credentials", func() {
+			backend, err := Open("http://user:wrong@" + host + "/dumps")
+			So(err, ShouldBeNil)
+			w, err := backend.Create("c.bson")
+			So(err, ShouldBeNil)
+			w.Write([]byte("object"))
+			err = w.Close()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Unauthorized")
+			So(err.Error(), ShouldNotContainSubstring, "wrong")
+		})
+	})
+}
@@ -0,0 +1,222 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// readRangeSize is the size of each range of an object read at once. Each
+// range is retried on its own, so a failure late in a large object doesn't
+// restart it.
+const readRangeSize = 8 << 20
+
+// ObjectInfo describes an object listed under a location.
+type ObjectInfo struct {
+	// Name is slash-separated, relative to the backend's location
+	Name string
+	Size int64
+}
+
+// Reader is implemented by backends that can read the objects under their
+// location, to restore from object storage without staging it on local
+// disk.
+type Reader interface {
+	// Objects lists the objects under the slash-separated prefix, relative
+	// to the backend's location.
+	Objects(prefix string) ([]ObjectInfo, error)
+	// Open returns a reader of the object at name, which streams it a range
+	// at a time. It returns an error satisfying os.IsNotExist if there is
+	// no such object.
+	Open(name string) (io.ReadCloser, error)
+}
+
+// objectNames returns the names of listed objects.
+func objectNames(objects []ObjectInfo, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(objects))
+	for i, object := range objects {
+		names[i] = object.Name
+	}
+	return names, nil
+}
+
+// readers caches the backends opened by OpenObject by their location, so
+// that their credentials are reused for each object read.
+var readers = struct {
+	sync.Mutex
+	backends map[string]Reader
+}{backends: map[string]Reader{}}
+
+// OpenReader returns a backend that reads the objects under location.
+func OpenReader(location string) (Reader, error) {
+	location = strings.TrimSuffix(location, "/")
+	readers.Lock()
+	defer readers.Unlock()
+	if reader, ok := readers.backends[location]; ok {
+		return reader, nil
+	}
+	backend, err := Open(location)
+	if err != nil {
+		return nil, err
+	}
+	reader, ok := backend.(Reader)
+	if !ok {
+		return nil, fmt.Errorf("storage location '%v' cannot be read", location)
+	}
+	readers.backends[location] = reader
+	return reader, nil
+}
+
+// OpenObject returns a reader of the single object at location.
+func OpenObject(location string) (io.ReadCloser, error) {
+	dir, name := path.Split(location)
+	if name == "" || strings.HasSuffix(dir, "://") {
+		return nil, fmt.Errorf("storage location '%v' does not name an object", location)
+	}
+	reader, err := OpenReader(dir)
+	if err != nil {
+		return nil, err
+	}
+	return reader.Open(name)
+}
+
+// rangeReader reads an object a range at a time, retrying each range.
+type rangeReader struct {
+	location string
+	request  request
+	// pin changes the request to only read the version of the object given
+	// by the headers of its first range, so that an object replaced while
+	// it is being read fails rather than mixing both versions
+	pin func(r *request, header http.Header)
+
+	offset int64
+	// size is -1 until the first range has been read
+	size   int64
+	buffer []byte
+	err    error
+}
+
+// newRangeReader returns a reader of the object at location, reading its
+// first range so that a missing object is reported.
+func newRangeReader(location string, r request, pin func(*request, http.Header)) (*rangeReader, error) {
+	if r.header == nil {
+		r.header = http.Header{}
+	}
+	rr := &rangeReader{location: location, request: r, pin: pin, size: -1}
+	if err := rr.fetch(); err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
+
+// pinETag is the pin of services that support If-Match.
+func pinETag(r *request, header http.Header) {
+	if etag := header.Get("ETag"); etag != "" {
+		r.header.Set("If-Match", etag)
+	}
+}
+
+func (rr *rangeReader) Read(p []byte) (int, error) {
+	for len(rr.buffer) == 0 {
+		if rr.err != nil {
+			return 0, rr.err
+		}
+		if rr.offset >= rr.size {
+			return 0, io.EOF
+		}
+		rr.err = rr.fetch()
+	}
+	n := copy(p, rr.buffer)
+	rr.buffer = rr.buffer[n:]
+	return n, nil
+}
+
+func (rr *rangeReader) Close() error {
+	rr.buffer = nil
+	rr.err = fmt.Errorf("read from closed object %v", rr.location)
+	return nil
+}
+
+// fetch reads the range at the offset into the buffer.
+func (rr *rangeReader) fetch() error {
+	r := rr.request
+	r.header = http.Header{}
+	for key, values := range rr.request.header {
+		r.header[key] = values
+	}
+	r.header.Set("Range", fmt.Sprintf("bytes=%v-%v", rr.offset, rr.offset+readRangeSize-1))
+	r.ok = []int{http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable}
+	r.maxBody = readRangeSize + 1
+
+	resp, err := do(r)
+	if err != nil {
+		if status, ok := err.(*statusError); ok {
+			switch status.status {
+			case http.StatusNotFound:
+				return &os.PathError{Op: "open", Path: rr.location, Err: os.ErrNotExist}
+			case http.StatusPreconditionFailed:
+				return fmt.Errorf("%v changed while it was being read", rr.location)
+			}
+		}
+		return fmt.Errorf("error reading %v: %v", rr.location, err)
+	}
+
+	switch resp.status {
+	case http.StatusRequestedRangeNotSatisfiable:
+		// an empty object, or a range past the end of one whose size is a
+		// multiple of the range size
+		if rr.size < 0 {
+			rr.size = rr.offset
+		}
+		if rr.offset < rr.size {
+			return fmt.Errorf("error reading %v: range at %v is past the end", rr.location, rr.offset)
+		}
+		return nil
+	case http.StatusOK:
+		// the whole object, from a service that ignored the range
+		if rr.offset != 0 || int64(len(resp.body)) > readRangeSize {
+			return fmt.Errorf("error reading %v: ranged reads are not supported", rr.location)
+		}
+		rr.size = int64(len(resp.body))
+	default:
+		size, err := contentRangeSize(resp.header.Get("Content-Range"))
+		if err != nil {
+			return fmt.Errorf("error reading %v: %v", rr.location, err)
+		}
+		rr.size = size
+	}
+	if rr.offset == 0 && rr.pin != nil {
+		rr.pin(&rr.request, resp.header)
+	}
+	rr.buffer = resp.body
+	rr.offset += int64(len(resp.body))
+	return nil
+}
+
+// contentRangeSize returns the size of the object given by a Content-Range
+// header of the form "bytes <start>-<end>/<size>".
+func contentRangeSize(header string) (int64, error) {
+	i := strings.LastIndex(header, "/")
+	if i < 0 || !strings.HasPrefix(header, "bytes ") {
+		return 0, fmt.Errorf("unexpected Content-Range %q", header)
+	}
+	size, err := strconv.ParseInt(header[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected Content-Range %q", header)
+	}
+	return size, nil
+}
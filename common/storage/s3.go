@@ -0,0 +1,308 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package storage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// S3 allows 10,000 parts per upload, so parts start at 8MB and double every
+// 1,000 parts, which allows objects up to the 5TB limit.
+const (
+	s3BasePartSize = 8 << 20
+	s3PartStep     = 1000
+)
+
+// awsSigner signs requests to an AWS service with the credentials and region
+// that the AWS SDK finds: the environment, the shared credentials and config
+// files, the container credentials endpoint, or the EC2 instance role.
+type awsSigner struct {
+	service     string
+	region      string
+	credentials *credentials.Credentials
+	signer      *v4.Signer
+}
+
+func newAWSSigner(service string) (awsSigner, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return awsSigner{}, fmt.Errorf("error loading AWS configuration: %v", err)
+	}
+	region := aws.StringValue(sess.Config.Region)
+	if region == "" {
+		region = "us-east-1"
+	}
+	signer := v4.NewSigner(sess.Config.Credentials, func(s *v4.Signer) {
+		// object keys are escaped once in the URL, and S3 signs them as sent
+		s.DisableURIPathEscaping = service == "s3"
+	})
+	return awsSigner{service: service, region: region, credentials: sess.Config.Credentials, signer: signer}, nil
+}
+
+// s3Backend writes objects to an S3 bucket, or to an S3-compatible service
+// at AWS_ENDPOINT_URL.
+type s3Backend struct {
+	awsSigner
+	bucket string
+	prefix string
+	// endpoint is set for S3-compatible services, which are addressed
+	// path-style
+	endpoint string
+}
+
+func newS3Backend(bucket, prefix string) (Backend, error) {
+	signer, err := newAWSSigner("s3")
+	if err != nil {
+		return nil, err
+	}
+	b := &s3Backend{
+		awsSigner: signer,
+		bucket:    bucket,
+		prefix:    prefix,
+		endpoint:  strings.TrimSuffix(firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"), "/"),
+	}
+	return b, nil
+}
+
+func (b *s3Backend) Location(name string) string {
+	return "s3://" + b.bucket + "/" + objectName(b.prefix, name)
+}
+
+func (b *s3Backend) Create(name string) (io.WriteCloser, error) {
+	if _, err := b.credentials.Get(); err != nil {
+		return nil, fmt.Errorf("no AWS credentials found: %v", err)
+	}
+	return newPartWriter(&s3Upload{backend: b, key: objectName(b.prefix, name), location: b.Location(name)}), nil
+}
+
+func (b *s3Backend) List(prefix string) ([]string, error) {
+	return objectNames(b.Objects(prefix))
+}
+
+// Objects lists the objects under prefix with ListObjectsV2, a page at a
+// time.
+func (b *s3Backend) Objects(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	query := url.Values{"list-type": {"2"}, "prefix": {objectName(b.prefix, prefix)}}
+	for {
+		resp, err := do(request{method: "GET", url: b.objectURL("", query), sign: b.sign})
+		if err != nil {
+			return nil, fmt.Errorf("error listing %v: %v", b.Location(prefix), err)
+		}
+		var result struct {
+			Contents []struct {
+				Key  string
+				Size int64
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err = xml.Unmarshal(resp.body, &result); err != nil {
+			return nil, fmt.Errorf("error listing %v: %v", b.Location(prefix), err)
+		}
+		for _, object := range result.Contents {
+			objects = append(objects, ObjectInfo{Name: relativeName(b.prefix, object.Key), Size: object.Size})
+		}
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (b *s3Backend) Open(name string) (io.ReadCloser, error) {
+	r := request{method: "GET", url: b.objectURL(objectName(b.prefix, name), nil), sign: b.sign}
+	return newRangeReader(b.Location(name), r, pinETag)
+}
+
+func (b *s3Backend) Delete(name string) error {
+	_, err := do(request{method: "DELETE", url: b.objectURL(objectName(b.prefix, name), nil), sign: b.sign})
+	if err != nil {
+		return fmt.Errorf("error deleting %v: %v", b.Location(name), err)
+	}
+	return nil
+}
+
+// objectURL returns the URL of key. Buckets with dots in their names are
+// addressed path-style, since they do not match the wildcard certificate.
+func (b *s3Backend) objectURL(key string, query url.Values) string {
+	var u string
+	switch {
+	case b.endpoint != "":
+		u = b.endpoint + "/" + b.bucket + "/" + awsEscapePath(key)
+	case strings.Contains(b.bucket, "."):
+		u = fmt.Sprintf("https://s3.%v.amazonaws.com/%v/%v", b.region, b.bucket, awsEscapePath(key))
+	default:
+		u = fmt.Sprintf("https://%v.s3.%v.amazonaws.com/%v", b.bucket, b.region, awsEscapePath(key))
+	}
+	if len(query) > 0 {
+		u += "?" + awsCanonicalQuery(query)
+	}
+	return u
+}
+
+// sign signs the request with AWS Signature Version 4.
+func (s *awsSigner) sign(req *http.Request, body []byte) error {
+	_, err := s.signer.Sign(req, bytes.NewReader(body), s.service, s.region, timeNow())
+	return err
+}
+
+// awsEscape percent-encodes everything except unreserved characters, as
+// signature version 4 requires.
+func awsEscape(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			out.WriteByte(c)
+		} else {
+			fmt.Fprintf(&out, "%%%02X", c)
+		}
+	}
+	return out.String()
+}
+
+func awsEscapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func awsCanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, awsEscape(key)+"="+awsEscape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Upload uploads an object, starting a multipart upload once the object
+// is larger than a part.
+type s3Upload struct {
+	backend  *s3Backend
+	key      string
+	location string
+	uploadID string
+	etags    []string
+}
+
+func (u *s3Upload) partSize(part int) int {
+	return growingPartSize(s3BasePartSize, s3PartStep, part)
+}
+
+func (u *s3Upload) request(method string, query url.Values, body []byte) (*response, error) {
+	return do(request{
+		method: method,
+		url:    u.backend.objectURL(u.key, query),
+		body:   body,
+		sign:   u.backend.sign,
+	})
+}
+
+func (u *s3Upload) uploadPart(part int, data []byte) error {
+	if u.uploadID == "" {
+		resp, err := u.request("POST", url.Values{"uploads": {""}}, nil)
+		if err != nil {
+			return fmt.Errorf("error starting upload to %v: %v", u.location, err)
+		}
+		var result struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err = xml.Unmarshal(resp.body, &result); err != nil || result.UploadID == "" {
+			return fmt.Errorf("error starting upload to %v: unexpected response", u.location)
+		}
+		u.uploadID = result.UploadID
+	}
+	resp, err := u.request("PUT", url.Values{
+		"partNumber": {fmt.Sprint(part)},
+		"uploadId":   {u.uploadID},
+	}, data)
+	if err != nil {
+		return fmt.Errorf("error uploading part %v of %v: %v", part, u.location, err)
+	}
+	u.etags = append(u.etags, resp.header.Get("ETag"))
+	return nil
+}
+
+type s3CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+func (u *s3Upload) finish(part int, data []byte) error {
+	if u.uploadID == "" {
+		if _, err := u.request("PUT", nil, data); err != nil {
+			return fmt.Errorf("error uploading %v: %v", u.location, err)
+		}
+		return nil
+	}
+	if err := u.uploadPart(part, data); err != nil {
+		return err
+	}
+	complete := struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{}
+	for i, etag := range u.etags {
+		complete.Parts = append(complete.Parts, s3CompletedPart{i + 1, etag})
+	}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	resp, err := u.request("POST", url.Values{"uploadId": {u.uploadID}}, body)
+	if err == nil && strings.Contains(string(resp.body), "<Error>") {
+		// completion can fail after the response has started
+		err = fmt.Errorf("%s", resp.body)
+	}
+	if err != nil {
+		return fmt.Errorf("error completing upload to %v: %v", u.location, err)
+	}
+	u.uploadID = ""
+	return nil
+}
+
+func (u *s3Upload) abort() {
+	if u.uploadID != "" {
+		u.request("DELETE", url.Values{"uploadId": {u.uploadID}}, nil)
+		u.uploadID = ""
+	}
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,202 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+// Package storage writes tool output to object storage, and reads it back.
+// Locations are URLs of the form s3://bucket/prefix, gs://bucket/prefix or
+// azblob://container/prefix, or fs://name/prefix for a file system given to
+// RegisterFS. Objects are streamed in parts as they are written, and in
+// ranges as they are read, so they never have to be staged on local disk.
+// Objects can also be written to an HTTP endpoint, http(s)://host/prefix,
+// which each object is streamed to with a PUT request.
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Backend creates objects under a location in object storage.
+type Backend interface {
+	// Create returns a writer for the object at the slash-separated name,
+	// relative to the backend's location. The object is only complete once
+	// the writer has been closed without error.
+	Create(name string) (io.WriteCloser, error)
+	// Location returns the URL of the object at name, for messages.
+	Location(name string) string
+}
+
+// Pruner is implemented by backends that can list and delete the objects
+// under their location, to remove old output.
+type Pruner interface {
+	// List returns the names of the objects under the slash-separated
+	// prefix, relative to the backend's location.
+	List(prefix string) ([]string, error)
+	// Delete deletes the object at name.
+	Delete(name string) error
+}
+
+// relativeName returns the name of an object relative to the backend
+// prefix.
+func relativeName(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return strings.TrimPrefix(key, prefix+"/")
+}
+
+// uploader is implemented by each kind of object storage to upload a single
+// object in parts.
+type uploader interface {
+	// partSize returns the size of the given part, numbered from 1.
+	partSize(part int) int
+	// uploadPart uploads a part, which is not the last part of the object.
+	uploadPart(part int, data []byte) error
+	// finish uploads the rest of the object after parts-1 parts have been
+	// uploaded, and completes it. If no parts were uploaded, data is the
+	// whole object.
+	finish(part int, data []byte) error
+	// abort discards an incomplete object.
+	abort()
+}
+
+var schemes = map[string]func(bucket, prefix string) (Backend, error){
+	"s3":     newS3Backend,
+	"gs":     newGCSBackend,
+	"azblob": newAzureBackend,
+	"fs":     newFSBackend,
+}
+
+// IsRemote returns true if location is an object storage URL rather than a
+// local path.
+func IsRemote(location string) bool {
+	i := strings.Index(location, "://")
+	if i <= 0 {
+		return false
+	}
+	_, ok := schemes[location[:i]]
+	return ok || endpointSchemes[location[:i]]
+}
+
+// Open returns a backend that creates objects under location.
+func Open(location string) (Backend, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage location '%v': %v", location, err)
+	}
+	if endpointSchemes[u.Scheme] {
+		return newPutBackend(u)
+	}
+	newBackend, ok := schemes[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported storage location '%v'; expected s3://, gs://, azblob:// or https://", location)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("storage location '%v' has no bucket", location)
+	}
+	return newBackend(u.Host, strings.Trim(u.Path, "/"))
+}
+
+// Create returns a writer for the single object at location.
+func Create(location string) (io.WriteCloser, error) {
+	dir, name := path.Split(location)
+	if name == "" || strings.HasSuffix(dir, "://") {
+		return nil, fmt.Errorf("storage location '%v' does not name an object", location)
+	}
+	backend, err := Open(strings.TrimSuffix(dir, "/"))
+	if err != nil {
+		return nil, err
+	}
+	return backend.Create(name)
+}
+
+// objectName joins the backend prefix and an object name.
+func objectName(prefix, name string) string {
+	name = strings.TrimLeft(path.Clean("/"+name), "/")
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+// growingPartSize returns part sizes that start at base and double every
+// step parts, so that an object of unknown size fits in the part limit of
+// the service while small objects use little memory.
+func growingPartSize(base, step, part int) int {
+	shift := (part - 1) / step
+	if shift > 10 {
+		shift = 10
+	}
+	return base << uint(shift)
+}
+
+// partWriter buffers writes into parts and uploads each part once it is
+// full. The last part is uploaded by Close.
+type partWriter struct {
+	uploader uploader
+	buffer   []byte
+	part     int
+	err      error
+	closed   bool
+}
+
+func newPartWriter(u uploader) *partWriter {
+	return &partWriter{uploader: u, part: 1}
+}
+
+func (w *partWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, fmt.Errorf("write to closed object")
+	}
+	written := len(p)
+	for len(p) > 0 {
+		size := w.uploader.partSize(w.part)
+		if w.buffer == nil {
+			w.buffer = make([]byte, 0, size)
+		}
+		n := size - len(w.buffer)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buffer = append(w.buffer, p[:n]...)
+		p = p[n:]
+		if len(w.buffer) == size && len(p) > 0 {
+			// only upload a full part once more data follows it, since
+			// the last part has to be uploaded by finish
+			if w.err = w.uploader.uploadPart(w.part, w.buffer); w.err != nil {
+				w.uploader.abort()
+				return written - len(p), w.err
+			}
+			w.part++
+			w.buffer = w.buffer[:0]
+			if cap(w.buffer) < w.uploader.partSize(w.part) {
+				w.buffer = nil
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close uploads the remaining data and completes the object.
+func (w *partWriter) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	if w.err = w.uploader.finish(w.part, w.buffer); w.err != nil {
+		w.uploader.abort()
+	}
+	w.buffer = nil
+	return w.err
+}
@@ -9,6 +9,8 @@ package text
 import (
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -20,6 +22,16 @@ var (
 	longByteUnits  = []string{"B", "KB", "MB", "GB"}
 	shortByteUnits = []string{"B", "K", "M", "G"}
 	shortBitUnits  = []string{"b", "k", "m", "g"}
+
+	// parsedByteUnits are the units accepted by ParseByteAmount, with the
+	// longer units first so that they match before their short forms
+	parsedByteUnits = []struct {
+		suffix string
+		shifts int
+	}{
+		{"TB", 4}, {"GB", 3}, {"MB", 2}, {"KB", 1},
+		{"T", 4}, {"G", 3}, {"M", 2}, {"K", 1}, {"B", 0},
+	}
 )
 
 // FormatByteAmount takes an int64 representing a size in bytes and
@@ -29,6 +41,26 @@ func FormatByteAmount(size int64) string {
 	return formatUnitAmount(binary, size, 3, longByteUnits)
 }
 
+// ParseByteAmount parses a size in bytes with an optional unit, which is
+// one of the units of FormatByteAmount, their short forms, or TB. Units are
+// binary and case insensitive, as in 512, 64KB, 1.5G or 10GB.
+func ParseByteAmount(amount string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(amount))
+	multiplier := 1.0
+	for _, unit := range parsedByteUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix))
+			multiplier = math.Pow(binary, float64(unit.shifts))
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("invalid size '%v'", amount)
+	}
+	return int64(value * multiplier), nil
+}
+
 // FormatMegabyteAmount is equivalent to FormatByteAmount but expects
 // an amount of MB instead of bytes.
 func FormatMegabyteAmount(size int64) string {
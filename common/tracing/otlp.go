@@ -0,0 +1,199 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// OTLP span kinds and status codes
+const (
+	spanKindInternal = 1
+	statusOK         = 1
+	statusError      = 2
+)
+
+// exportTimeout bounds how long an export may delay the tool.
+const exportTimeout = 10 * time.Second
+
+// exporter sends spans to an OTLP/HTTP collector using the JSON encoding.
+type exporter struct {
+	service string
+	url     string
+	client  *http.Client
+
+	// a missing collector should not flood the log, so only warn once
+	warnOnce sync.Once
+}
+
+// newExporter validates the endpoint. Endpoints without a path are sent to
+// the standard /v1/traces path; endpoints without a scheme use http.
+func newExporter(service, endpoint string) (*exporter, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid OpenTelemetry endpoint '%v'", endpoint)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported OpenTelemetry endpoint scheme '%v'", u.Scheme)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return &exporter{
+		service: service,
+		url:     u.String(),
+		client:  &http.Client{Timeout: exportTimeout},
+	}, nil
+}
+
+func (e *exporter) export(spans []*Span) {
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(e.request(spans))
+	if err == nil {
+		err = e.post(body)
+	}
+	if err != nil {
+		e.warnOnce.Do(func() {
+			log.Warnf("error exporting OpenTelemetry spans: %v", err)
+		})
+	}
+}
+
+func (e *exporter) post(body []byte) error {
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %v", resp.Status)
+	}
+	return nil
+}
+
+// The OTLP JSON request, see opentelemetry-proto's trace_service.proto
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func (e *exporter) request(spans []*Span) otlpRequest {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		otlpSpans = append(otlpSpans, span.toOTLP())
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			newAttribute("service.name", e.service),
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/mongodb/mongo-tools"},
+			Spans: otlpSpans,
+		}},
+	}}}
+}
+
+func (s *Span) toOTLP() otlpSpan {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	span := otlpSpan{
+		TraceID:           s.traceID,
+		SpanID:            s.spanID,
+		ParentSpanID:      s.parentID,
+		Name:              s.name,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Status:            otlpStatus{Code: statusOK},
+	}
+	for _, attr := range s.attributes {
+		span.Attributes = append(span.Attributes, newAttribute(attr.key, attr.value))
+	}
+	if s.err != nil {
+		span.Status = otlpStatus{Code: statusError, Message: log.Redact(s.err.Error())}
+	}
+	return span
+}
+
+// newAttribute encodes a value as an OTLP AnyValue.
+func newAttribute(key string, value interface{}) otlpAttribute {
+	var encoded map[string]interface{}
+	switch v := value.(type) {
+	case string:
+		encoded = map[string]interface{}{"stringValue": log.Redact(v)}
+	case bool:
+		encoded = map[string]interface{}{"boolValue": v}
+	case int:
+		encoded = map[string]interface{}{"intValue": strconv.FormatInt(int64(v), 10)}
+	case int32:
+		encoded = map[string]interface{}{"intValue": strconv.FormatInt(int64(v), 10)}
+	case int64:
+		encoded = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		encoded = map[string]interface{}{"doubleValue": v}
+	default:
+		encoded = map[string]interface{}{"stringValue": log.Redact(fmt.Sprint(v))}
+	}
+	return otlpAttribute{Key: key, Value: encoded}
+}
@@ -0,0 +1,204 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+// Package tracing records the phases of a tool run as OpenTelemetry spans
+// and exports them to an OTLP/HTTP collector.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// Span is a single timed operation. All methods are safe to call on a nil
+// Span, which is what Start returns when tracing is disabled.
+type Span struct {
+	tracer   *tracer
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	start    time.Time
+
+	mutex      sync.Mutex
+	end        time.Time
+	attributes []attribute
+	err        error
+}
+
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+type spanKey struct{}
+
+// tracer holds the state of an enabled tracing session.
+type tracer struct {
+	exporter *exporter
+	root     *Span
+
+	mutex    sync.Mutex
+	finished []*Span
+}
+
+// batchSize is the number of finished spans that triggers an export.
+const batchSize = 512
+
+var (
+	globalMutex  sync.Mutex
+	globalTracer *tracer
+)
+
+// Init enables tracing for the named service, exporting spans to the OTLP
+// collector at endpoint. It starts a root span named after the service,
+// which Shutdown ends.
+func Init(service, endpoint string) error {
+	exporter, err := newExporter(service, endpoint)
+	if err != nil {
+		return err
+	}
+	t := &tracer{exporter: exporter}
+	t.root = t.newSpan(service, "", "")
+
+	globalMutex.Lock()
+	defer globalMutex.Unlock()
+	globalTracer = t
+	return nil
+}
+
+// Enabled reports whether tracing has been initialized.
+func Enabled() bool {
+	globalMutex.Lock()
+	defer globalMutex.Unlock()
+	return globalTracer != nil
+}
+
+// Start begins a span with the given name and alternating attribute keys
+// and values. Its parent is the span in ctx, or the root span. The returned
+// context carries the span, and its trace and span IDs are attached to
+// messages logged with log.WithContext.
+func Start(ctx context.Context, name string, keyvals ...interface{}) (context.Context, *Span) {
+	globalMutex.Lock()
+	t := globalTracer
+	globalMutex.Unlock()
+	if t == nil {
+		return ctx, nil
+	}
+	parent := FromContext(ctx)
+	if parent == nil {
+		parent = t.root
+	}
+	span := t.newSpan(name, parent.traceID, parent.spanID)
+	span.SetAttributes(keyvals...)
+	ctx = context.WithValue(ctx, spanKey{}, span)
+	ctx = log.ContextWithFields(ctx, span.LogFields()...)
+	return ctx, span
+}
+
+// FromContext returns the span carried by ctx, if any.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+func (t *tracer) newSpan(name, traceID, parentID string) *Span {
+	if traceID == "" {
+		traceID = randomID(16)
+	}
+	return &Span{
+		tracer:   t,
+		traceID:  traceID,
+		spanID:   randomID(8),
+		parentID: parentID,
+		name:     name,
+		start:    time.Now(),
+	}
+}
+
+func randomID(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		// fall back to a time-based ID rather than failing the tool
+		return fmt.Sprintf("%0*x", size*2, time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// SetAttributes adds alternating keys and values to the span.
+func (s *Span) SetAttributes(keyvals ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		s.attributes = append(s.attributes, attribute{fmt.Sprint(keyvals[i]), keyvals[i+1]})
+	}
+}
+
+// End finishes the span, marking it as failed if err is not nil. Ending a
+// span more than once has no effect.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if !s.end.IsZero() {
+		s.mutex.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+	s.mutex.Unlock()
+	s.tracer.finish(s)
+}
+
+// LogFields returns the keys and values identifying the span in log
+// messages.
+func (s *Span) LogFields() []interface{} {
+	if s == nil {
+		return nil
+	}
+	return []interface{}{"traceId", s.traceID, "spanId", s.spanID}
+}
+
+func (t *tracer) finish(span *Span) {
+	t.mutex.Lock()
+	t.finished = append(t.finished, span)
+	var batch []*Span
+	if len(t.finished) >= batchSize {
+		batch, t.finished = t.finished, nil
+	}
+	t.mutex.Unlock()
+	if batch != nil {
+		t.exporter.export(batch)
+	}
+}
+
+// Shutdown ends the root span, marking it as failed if err is not nil, and
+// exports all remaining spans. Tracing is disabled afterwards.
+func Shutdown(err error) {
+	globalMutex.Lock()
+	t := globalTracer
+	globalTracer = nil
+	globalMutex.Unlock()
+	if t == nil {
+		return
+	}
+	t.root.End(err)
+	t.mutex.Lock()
+	batch := t.finished
+	t.finished = nil
+	t.mutex.Unlock()
+	t.exporter.export(batch)
+}
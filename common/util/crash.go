@@ -0,0 +1,123 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package util
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// CrashInfo describes the running tool in crash reports.
+type CrashInfo struct {
+	Tool      string
+	Version   string
+	GitCommit string
+
+	// Args are the command line arguments. They are redacted before being
+	// written.
+	Args []string
+
+	// Dir is where crash files are written. It defaults to the system's
+	// temporary directory.
+	Dir string
+}
+
+var (
+	crashMutex sync.Mutex
+	crashInfo  = CrashInfo{Tool: filepath.Base(os.Args[0])}
+)
+
+// SetCrashInfo sets the details included in crash reports.
+func SetCrashInfo(info CrashInfo) {
+	crashMutex.Lock()
+	defer crashMutex.Unlock()
+	crashInfo = info
+}
+
+// crashOutput gets a line about every crash whatever the log settings, so
+// that a crash is never silent.
+var crashOutput io.Writer = os.Stderr
+
+// RecoverCrash recovers from a panic, writes a crash report to stderr, the
+// log and a crash file, and exits with ExitCrash. It must be deferred at the
+// top of main and of any long-running goroutine whose panics should be
+// captured; it does nothing if there is no panic.
+func RecoverCrash() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	// only the first panicking goroutine reports; the others wait for the
+	// process to exit
+	crashMutex.Lock()
+	reportCrash(crashInfo, r, debug.Stack())
+	Exit(ExitCrash)
+}
+
+// reportCrash writes the crash file and a line naming it to crashOutput,
+// then the details to the log, which may be quiet or written elsewhere.
+func reportCrash(info CrashInfo, r interface{}, stack []byte) {
+	report := crashReport(info, r, stack)
+	crashFile, err := writeCrashFile(info, report)
+	if err != nil {
+		crashFile = fmt.Sprintf("<not written: %v>", err)
+	}
+	fmt.Fprintf(crashOutput, "%v crashed: %v (crash report: %v)\n", info.Tool, log.Redact(fmt.Sprint(r)), crashFile)
+	log.Errorkv(fmt.Sprintf("%v crashed: %v", info.Tool, r),
+		"version", info.Version,
+		"gitCommit", info.GitCommit,
+		"args", crashArgs(info),
+		"crashFile", crashFile,
+	)
+	log.Errorf("%s", stack)
+}
+
+// crashArgs returns the command line with credentials redacted.
+func crashArgs(info CrashInfo) string {
+	return log.Redact(strings.Join(info.Args, " "))
+}
+
+func crashReport(info CrashInfo, r interface{}, stack []byte) string {
+	return fmt.Sprintf("%v crashed at %v\n\n"+
+		"panic: %v\n\n"+
+		"version: %v\n"+
+		"git commit: %v\n"+
+		"go version: %v %v/%v\n"+
+		"args: %v\n\n"+
+		"%s",
+		info.Tool, time.Now().Format(time.RFC3339),
+		log.Redact(fmt.Sprint(r)),
+		info.Version,
+		info.GitCommit,
+		runtime.Version(), runtime.GOOS, runtime.GOARCH,
+		crashArgs(info),
+		stack)
+}
+
+// writeCrashFile writes the report to a new file, returning its path.
+func writeCrashFile(info CrashInfo, report string) (string, error) {
+	dir := info.Dir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	name := fmt.Sprintf("%v-crash-%v-%d.log", info.Tool, time.Now().Format("20060102T150405"), os.Getpid())
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(report), 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,181 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package util
+
+import (
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// ErrorCode identifies a class of failure in a stable, machine-readable way.
+// IDs have the form MTOOLS-<AREA>-<NUMBER> and are never reused.
+type ErrorCode struct {
+	ID          string
+	ExitCode    int
+	Retryable   bool
+	Description string
+}
+
+func (c ErrorCode) String() string {
+	return c.ID
+}
+
+// General error codes, shared by all tools
+var (
+	ErrCodeUnknown        = ErrorCode{"MTOOLS-GENERAL-0001", ExitFailure, false, "unclassified failure"}
+	ErrCodeInterrupted    = ErrorCode{"MTOOLS-GENERAL-0002", ExitInterrupted, true, "interrupted by a signal"}
+	ErrCodeBadOptions     = ErrorCode{"MTOOLS-OPTIONS-0001", ExitBadOptions, false, "invalid command line options"}
+	ErrCodeConnection     = ErrorCode{"MTOOLS-CONNECT-0001", ExitConnectionFailure, true, "unable to reach the server"}
+	ErrCodeAuthentication = ErrorCode{"MTOOLS-CONNECT-0002", ExitAuthFailure, false, "authentication failed"}
+)
+
+// mongodump error codes
+var (
+	ErrCodeDumpOplogOverflow = ErrorCode{"MTOOLS-DUMP-0001", ExitTransientFailure, true,
+		"the oplog rolled over before the dump completed"}
+	ErrCodeDumpOplog = ErrorCode{"MTOOLS-DUMP-0002", ExitFailure, false, "error dumping the oplog"}
+	ErrCodeDumpData  = ErrorCode{"MTOOLS-DUMP-0003", ExitFailure, false, "error dumping collection data"}
+)
+
+// mongorestore error codes
+var (
+	ErrCodeRestoreSource      = ErrorCode{"MTOOLS-RESTORE-0001", ExitFailure, false, "invalid restore source"}
+	ErrCodeRestoreAuthVersion = ErrorCode{"MTOOLS-RESTORE-0002", ExitFailure, false,
+		"incompatible users and roles auth version"}
+	ErrCodeRestoreIndexes = ErrorCode{"MTOOLS-RESTORE-0003", ExitFailure, false, "error reading index definitions"}
+	ErrCodeRestoreData    = ErrorCode{"MTOOLS-RESTORE-0004", ExitFailure, false, "error restoring collection data"}
+	ErrCodeRestoreUsers   = ErrorCode{"MTOOLS-RESTORE-0005", ExitFailure, false, "error restoring users and roles"}
+	ErrCodeRestoreOplog   = ErrorCode{"MTOOLS-RESTORE-0006", ExitFailure, false, "error replaying the oplog"}
+	ErrCodeRestoreArchive = ErrorCode{"MTOOLS-RESTORE-0007", ExitFailure, false, "error reading the archive"}
+	ErrCodeRestoreVerify  = ErrorCode{"MTOOLS-RESTORE-0008", ExitFailure, false, "restored data does not match the dump or source"}
+)
+
+// ErrorCodes lists every defined error code.
+var ErrorCodes = []ErrorCode{
+	ErrCodeUnknown,
+	ErrCodeInterrupted,
+	ErrCodeBadOptions,
+	ErrCodeConnection,
+	ErrCodeAuthentication,
+	ErrCodeDumpOplogOverflow,
+	ErrCodeDumpOplog,
+	ErrCodeDumpData,
+	ErrCodeRestoreSource,
+	ErrCodeRestoreAuthVersion,
+	ErrCodeRestoreIndexes,
+	ErrCodeRestoreData,
+	ErrCodeRestoreUsers,
+	ErrCodeRestoreOplog,
+	ErrCodeRestoreArchive,
+	ErrCodeRestoreVerify,
+}
+
+// CodedError is an error annotated with an ErrorCode.
+type CodedError struct {
+	Code ErrorCode
+	Err  error
+}
+
+// Error implements the error interface.
+func (ce *CodedError) Error() string {
+	return ce.Err.Error()
+}
+
+// Unwrap returns the annotated error.
+func (ce *CodedError) Unwrap() error {
+	return ce.Err
+}
+
+// knownErrorCodes maps sentinel errors to their codes.
+var knownErrorCodes = map[error]ErrorCode{
+	ErrTerminated: ErrCodeInterrupted,
+}
+
+// WithErrorCode annotates err with the given code. Errors that already carry
+// a code are returned unchanged, so the most specific code wins.
+func WithErrorCode(code ErrorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := explicitErrorCode(err); ok {
+		return err
+	}
+	return &CodedError{Code: code, Err: err}
+}
+
+func explicitErrorCode(err error) (ErrorCode, bool) {
+	for err != nil {
+		if ce, ok := err.(*CodedError); ok {
+			return ce.Code, true
+		}
+		if code, ok := knownErrorCodes[err]; ok {
+			return code, true
+		}
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			break
+		}
+		err = wrapper.Unwrap()
+	}
+	return ErrorCode{}, false
+}
+
+// Fragments of driver error messages used to classify errors that were
+// created without a code
+var (
+	authErrorMessages = []string{
+		"authentication failed",
+		"auth error",
+		"unable to authenticate",
+	}
+	connectionErrorMessages = []string{
+		"server selection error",
+		"server selection timeout",
+		"no reachable servers",
+		"connection refused",
+		"connection reset",
+		"i/o timeout",
+	}
+)
+
+// ErrorCodeOf returns the code for err. Errors without an explicit code are
+// classified by their message, falling back to ErrCodeUnknown.
+func ErrorCodeOf(err error) ErrorCode {
+	if code, ok := explicitErrorCode(err); ok {
+		return code
+	}
+	msg := strings.ToLower(err.Error())
+	for _, fragment := range authErrorMessages {
+		if strings.Contains(msg, fragment) {
+			return ErrCodeAuthentication
+		}
+	}
+	for _, fragment := range connectionErrorMessages {
+		if strings.Contains(msg, fragment) {
+			return ErrCodeConnection
+		}
+	}
+	return ErrCodeUnknown
+}
+
+// LogFailure logs the final failure message for err, along with its code,
+// and returns the exit code the tool should exit with.
+func LogFailure(err error) int {
+	code := ErrorCodeOf(err)
+	log.Errorkv("Failed: "+err.Error(), "code", code.ID, "retryable", code.Retryable)
+	return FailureExitCode(err)
+}
+
+// FailureExitCode records err in the run summary and returns the exit code
+// the tool should exit with. It is used for failures the tool has already
+// logged.
+func FailureExitCode(err error) int {
+	code := ErrorCodeOf(err)
+	log.Summary().AddFailure(err.Error(), code.ID)
+	return code.ExitCode
+}
@@ -0,0 +1,76 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/tracing"
+)
+
+// Process exit codes. Each ErrorCode maps onto one of these.
+const (
+	ExitSuccess int = iota
+	ExitFailure
+	ExitBadOptions
+	ExitConnectionFailure
+	ExitAuthFailure
+	ExitInterrupted
+	ExitTransientFailure
+	ExitCrash
+)
+
+var (
+	ErrTerminated = errors.New("received termination signal")
+)
+
+// Exit writes any buffered log output, trace spans and summary report and
+// exits the process with the given code. Tools should call Exit rather than os.Exit.
+func Exit(code int) {
+	var err error
+	if code != ExitSuccess {
+		err = fmt.Errorf("exit code %v", code)
+	}
+	tracing.Shutdown(err)
+	writeSummary(code)
+	log.Close()
+	os.Exit(code)
+}
+
+// Shutdown writes any buffered log output, trace spans and summary report. Tools should defer
+// it in main, to cover the case where main returns without calling Exit.
+func Shutdown() {
+	tracing.Shutdown(nil)
+	writeSummary(ExitSuccess)
+	log.Close()
+}
+
+func writeSummary(code int) {
+	if err := log.WriteSummary(code); err != nil {
+		log.Errorf("error writing summary report: %v", err)
+	}
+}
+
+func ShortUsage(tool string) string {
+	return "try '" + tool + " --help' for more information"
+}
+
+// SetupError is the error thrown by "New" functions used to convey what error occurred and the appropriate exit code.
+type SetupError struct {
+	Err error
+
+	// An optional message to be logged before exiting
+	Message string
+}
+
+// Error implements the error interface.
+func (se SetupError) Error() string {
+	return se.Err.Error()
+}
@@ -0,0 +1,57 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWriteSummary(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a directory for report files", t, func() {
+		dir, err := ioutil.TempDir("", "util_summary")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		logOutput := &bytes.Buffer{}
+		log.SetWriter(logOutput)
+		defer log.SetWriter(os.Stderr)
+
+		Convey("the report should record the exit code", func() {
+			path := filepath.Join(dir, "report.json")
+			log.EnableSummary(path)
+			log.Summary().AddCount("documents", 5)
+			writeSummary(ExitFailure)
+
+			content, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			var report struct {
+				ExitCode int              `json:"exitCode"`
+				Counters map[string]int64 `json:"counters"`
+			}
+			So(json.Unmarshal(content, &report), ShouldBeNil)
+			So(report.ExitCode, ShouldEqual, ExitFailure)
+			So(report.Counters, ShouldResemble, map[string]int64{"documents": 5})
+			So(logOutput.String(), ShouldBeEmpty)
+		})
+
+		Convey("an error writing the report should be logged", func() {
+			log.EnableSummary(filepath.Join(dir, "missing", "report.json"))
+			writeSummary(ExitSuccess)
+			So(logOutput.String(), ShouldContainSubstring, "error writing summary report: ")
+		})
+	})
+}
@@ -0,0 +1,50 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// RunHook runs a hook command with the shell for event, giving it input as
+// JSON on stdin and env in addition to its own environment. Subject names
+// what the hook is run for in messages. The command's output is logged
+// rather than mixed with the tool's, and a command that fails returns an
+// error.
+func RunHook(command, event, subject string, input interface{}, env []string, hookLog *log.FieldLogger) error {
+	encoded, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(encoded)
+
+	hookLog.Logvf(log.DebugLow, "running %v hook for %v", event, subject)
+	output, err := cmd.CombinedOutput()
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		hookLog.Logvf(log.Info, "%v hook: %v", event, scanner.Text())
+	}
+	if err != nil {
+		return fmt.Errorf("%v hook for %v failed: %v", event, subject, err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package util
+
+import (
+	"sync"
+)
+
+// MemoryBudget bounds the bytes held in memory by any number of buffers
+// across goroutines, which take bytes from it before holding data and give
+// them back once they've let it go. A nil MemoryBudget doesn't bound
+// anything.
+type MemoryBudget struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	limit int64
+	used  int64
+}
+
+// NewMemoryBudget returns a MemoryBudget of limit bytes.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	budget := &MemoryBudget{limit: limit}
+	budget.cond = sync.NewCond(&budget.mutex)
+	return budget
+}
+
+// Acquire blocks until n bytes fit in the budget and takes them. A request
+// for more than the budget is allowed once nothing else is held, so that it
+// doesn't wait forever.
+func (budget *MemoryBudget) Acquire(n int64) {
+	if budget == nil {
+		return
+	}
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	for budget.used > 0 && budget.used+n > budget.limit {
+		budget.cond.Wait()
+	}
+	budget.used += n
+}
+
+// TryAcquire takes n bytes if they fit in the budget, and returns whether
+// it did.
+func (budget *MemoryBudget) TryAcquire(n int64) bool {
+	if budget == nil {
+		return true
+	}
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	if budget.used > 0 && budget.used+n > budget.limit {
+		return false
+	}
+	budget.used += n
+	return true
+}
+
+// Grow takes n bytes without waiting, going over the budget if need be, for
+// data that a buffer must hold for its consumer to make progress.
+func (budget *MemoryBudget) Grow(n int64) {
+	if budget == nil {
+		return
+	}
+	budget.mutex.Lock()
+	budget.used += n
+	budget.mutex.Unlock()
+}
+
+// Release gives back n bytes taken from the budget.
+func (budget *MemoryBudget) Release(n int64) {
+	if budget == nil || n == 0 {
+		return
+	}
+	budget.mutex.Lock()
+	budget.used -= n
+	budget.cond.Broadcast()
+	budget.mutex.Unlock()
+}
+
+// Used returns the number of bytes taken from the budget.
+func (budget *MemoryBudget) Used() int64 {
+	if budget == nil {
+		return 0
+	}
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	return budget.used
+}
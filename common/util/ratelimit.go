@@ -0,0 +1,58 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// limiterNow and limiterSleep are replaced in tests with a fake clock.
+var (
+	limiterNow   = time.Now
+	limiterSleep = time.Sleep
+)
+
+// RateLimiter is a token bucket that limits the rate of some quantity, such
+// as bytes or documents, across any number of goroutines. A nil RateLimiter
+// doesn't limit anything.
+type RateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter that allows rate tokens per second on
+// average, and up to a second's worth at once.
+func NewRateLimiter(rate float64) *RateLimiter {
+	return &RateLimiter{rate: rate, burst: rate, tokens: rate, last: limiterNow()}
+}
+
+// Wait blocks until n tokens are available and takes them. A request for
+// more than the bucket holds is allowed, and later requests wait for the
+// bucket to refill.
+func (limiter *RateLimiter) Wait(n int) {
+	if limiter == nil {
+		return
+	}
+	limiter.mutex.Lock()
+	now := limiterNow()
+	limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+	if limiter.tokens > limiter.burst {
+		limiter.tokens = limiter.burst
+	}
+	limiter.last = now
+	limiter.tokens -= float64(n)
+	debt := limiter.tokens
+	limiter.mutex.Unlock()
+
+	if debt < 0 {
+		limiterSleep(time.Duration(-debt / limiter.rate * float64(time.Second)))
+	}
+}
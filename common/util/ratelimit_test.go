@@ -0,0 +1,64 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateLimiter(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a fake clock", t, func() {
+		now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		var slept []time.Duration
+		savedNow, savedSleep := limiterNow, limiterSleep
+		limiterNow = func() time.Time { return now }
+		limiterSleep = func(d time.Duration) {
+			slept = append(slept, d)
+			now = now.Add(d)
+		}
+		defer func() { limiterNow, limiterSleep = savedNow, savedSleep }()
+
+		limiter := NewRateLimiter(100)
+
+		Convey("a second's worth of tokens should be available at once", func() {
+			limiter.Wait(60)
+			limiter.Wait(40)
+			So(slept, ShouldBeEmpty)
+		})
+
+		Convey("taking more than is available should wait off the debt", func() {
+			limiter.Wait(150)
+			So(slept, ShouldResemble, []time.Duration{500 * time.Millisecond})
+
+			limiter.Wait(10)
+			So(slept, ShouldResemble, []time.Duration{500 * time.Millisecond, 100 * time.Millisecond})
+		})
+
+		Convey("the bucket should refill at the rate, up to the burst", func() {
+			limiter.Wait(100)
+			now = now.Add(250 * time.Millisecond)
+			limiter.Wait(25)
+			So(slept, ShouldBeEmpty)
+
+			now = now.Add(time.Minute)
+			limiter.Wait(200)
+			So(slept, ShouldResemble, []time.Duration{time.Second})
+		})
+
+		Convey("a nil limiter should never wait", func() {
+			var nilLimiter *RateLimiter
+			nilLimiter.Wait(1000000)
+			So(slept, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,212 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package util
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// segmentMarker separates the name of a file that was split into segments
+// from the number of each segment.
+const segmentMarker = ".part"
+
+// SegmentPath returns the path of the segment with the given index of the
+// file at path.
+func SegmentPath(path string, index int) string {
+	return fmt.Sprintf("%v%v%04d", path, segmentMarker, index)
+}
+
+// SplitSegmentPath returns the path of the file that the segment at path
+// belongs to, and the segment's index. It returns false if path isn't the
+// path of a segment.
+func SplitSegmentPath(path string) (string, int, bool) {
+	i := strings.LastIndex(path, segmentMarker)
+	if i < 0 {
+		return "", 0, false
+	}
+	digits := path[i+len(segmentMarker):]
+	if len(digits) < 4 {
+		return "", 0, false
+	}
+	index, err := strconv.Atoi(digits)
+	if err != nil || index < 0 || SegmentPath(path[:i], index) != path {
+		return "", 0, false
+	}
+	return path[:i], index, true
+}
+
+// SegmentWriter splits what is written to it into numbered segments of at
+// most a fixed size, each created as it is needed.
+type SegmentWriter struct {
+	create  func(index int) (io.WriteCloser, error)
+	size    int64
+	index   int
+	written int64
+	current io.WriteCloser
+}
+
+// NewSegmentWriter returns a SegmentWriter that writes segments of at most
+// size bytes to the writers returned by create. The first segment is created
+// immediately, so that there is one even if nothing is written.
+func NewSegmentWriter(size int64, create func(index int) (io.WriteCloser, error)) (*SegmentWriter, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("segment size must be positive")
+	}
+	current, err := create(0)
+	if err != nil {
+		return nil, err
+	}
+	return &SegmentWriter{create: create, size: size, current: current}, nil
+}
+
+// Write writes p, starting new segments as each one fills.
+func (w *SegmentWriter) Write(p []byte) (int, error) {
+	var total int
+	for len(p) > 0 {
+		if w.written == w.size {
+			if err := w.current.Close(); err != nil {
+				return total, err
+			}
+			w.index++
+			current, err := w.create(w.index)
+			if err != nil {
+				return total, err
+			}
+			w.current, w.written = current, 0
+		}
+		chunk := p
+		if remaining := w.size - w.written; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		n, err := w.current.Write(chunk)
+		total += n
+		w.written += int64(n)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// Close closes the last segment.
+func (w *SegmentWriter) Close() error {
+	return w.current.Close()
+}
+
+// listSegments returns the paths of the segments of the file at path, in
+// order. It is an error for there to be no segments, or a gap between them.
+func listSegments(path string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(path)
+	found := map[int]bool{}
+	for _, entry := range entries {
+		if base, index, ok := SplitSegmentPath(entry.Name()); ok && base == name && !entry.IsDir() {
+			found[index] = true
+		}
+	}
+	if len(found) == 0 {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	segments := make([]string, len(found))
+	for index := range segments {
+		if !found[index] {
+			return nil, fmt.Errorf("segment %v of %v is missing", index, path)
+		}
+		segments[index] = SegmentPath(path, index)
+	}
+	return segments, nil
+}
+
+// segmentsInfo is the os.FileInfo of a file that was split into segments,
+// which is that of its first segment with the file's name and total size.
+type segmentsInfo struct {
+	os.FileInfo
+	name string
+	size int64
+}
+
+func (info *segmentsInfo) Name() string {
+	return info.name
+}
+
+func (info *segmentsInfo) Size() int64 {
+	return info.size
+}
+
+// StatSegments returns the os.FileInfo of the file at path that was split
+// into segments by a SegmentWriter, as if it was a single file.
+func StatSegments(path string) (os.FileInfo, error) {
+	segments, err := listSegments(path)
+	if err != nil {
+		return nil, err
+	}
+	info := &segmentsInfo{name: filepath.Base(path)}
+	for i, segment := range segments {
+		stat, err := os.Stat(segment)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			info.FileInfo = stat
+		}
+		info.size += stat.Size()
+	}
+	return info, nil
+}
+
+// segmentsReader reads the segments of a file one after another.
+type segmentsReader struct {
+	segments []string
+	current  *os.File
+}
+
+// OpenSegments opens the file at path that was split into segments by a
+// SegmentWriter, and reads it as if it was a single file.
+func OpenSegments(path string) (io.ReadCloser, error) {
+	segments, err := listSegments(path)
+	if err != nil {
+		return nil, err
+	}
+	first, err := os.Open(segments[0])
+	if err != nil {
+		return nil, err
+	}
+	return &segmentsReader{segments: segments[1:], current: first}, nil
+}
+
+func (r *segmentsReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.current.Read(p)
+		if err != io.EOF || len(r.segments) == 0 {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+		if err = r.current.Close(); err != nil {
+			return 0, err
+		}
+		if r.current, err = os.Open(r.segments[0]); err != nil {
+			return 0, err
+		}
+		r.segments = r.segments[1:]
+	}
+}
+
+func (r *segmentsReader) Close() error {
+	return r.current.Close()
+}
@@ -0,0 +1,62 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TimestampGreaterThan returns true if lhs comes after rhs, false otherwise.
+func TimestampGreaterThan(lhs, rhs primitive.Timestamp) bool {
+	return lhs.T > rhs.T || lhs.T == rhs.T && lhs.I > rhs.I
+}
+
+// TimestampLessThan returns true if lhs comes before rhs, false otherwise.
+func TimestampLessThan(lhs, rhs primitive.Timestamp) bool {
+	return lhs.T < rhs.T || lhs.T == rhs.T && lhs.I < rhs.I
+}
+
+// ParseTimestamp parses a timestamp of the form <time_t>[:<ordinal>], where
+// <time_t> is the seconds since the UNIX epoch, and <ordinal> is a counter of
+// operations in the oplog that occurred in the specified second.
+func ParseTimestamp(ts string) (primitive.Timestamp, error) {
+	var seconds, increment int
+	timestampFields := strings.Split(ts, ":")
+	if len(timestampFields) > 2 {
+		return primitive.Timestamp{}, fmt.Errorf("too many : characters")
+	}
+
+	seconds, err := strconv.Atoi(timestampFields[0])
+	if err != nil {
+		return primitive.Timestamp{}, fmt.Errorf("error parsing timestamp seconds: %v", err)
+	}
+
+	// parse the increment field if it exists
+	if len(timestampFields) == 2 {
+		if len(timestampFields[1]) > 0 {
+			increment, err = strconv.Atoi(timestampFields[1])
+			if err != nil {
+				return primitive.Timestamp{}, fmt.Errorf("error parsing timestamp increment: %v", err)
+			}
+		} else {
+			// handle the case where the user writes "<time_t>:" with no ordinal
+			increment = 0
+		}
+	}
+
+	return primitive.Timestamp{T: uint32(seconds), I: uint32(increment)}, nil
+}
+
+// FormatTimestamp formats a timestamp in the <time_t>:<ordinal> form accepted
+// by ParseTimestamp.
+func FormatTimestamp(ts primitive.Timestamp) string {
+	return fmt.Sprintf("%v:%v", ts.T, ts.I)
+}
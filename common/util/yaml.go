@@ -0,0 +1,445 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ReadConfigFile reads a JSON or YAML configuration file, returning its
+// contents as JSON. Files ending in .yaml or .yml are read as YAML.
+func ReadConfigFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		converted, err := YAMLToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %v: %v", path, err)
+		}
+		return converted, nil
+	}
+	return data, nil
+}
+
+// YAMLToJSON converts a YAML document to JSON, keeping the order of mapping
+// keys so the result can be read as Extended JSON. It supports the subset of
+// YAML used for configuration: block and flow mappings and sequences,
+// comments, and plain and quoted scalars. Anchors, tags and multi-line
+// scalars are not supported.
+func YAMLToJSON(data []byte) ([]byte, error) {
+	p := &yamlParser{}
+	if err := p.split(string(data)); err != nil {
+		return nil, err
+	}
+	if len(p.lines) == 0 {
+		return []byte("null"), nil
+	}
+	if err := p.node(p.lines[0].indent); err != nil {
+		return nil, err
+	}
+	if p.i < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return p.out.Bytes(), nil
+}
+
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+	out   bytes.Buffer
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	line := p.lines[len(p.lines)-1].number
+	if p.i < len(p.lines) {
+		line = p.lines[p.i].number
+	}
+	return fmt.Errorf("line %v: %v", line, fmt.Sprintf(format, args...))
+}
+
+// split breaks the document into lines without comments or blank lines.
+func (p *yamlParser) split(data string) error {
+	for n, text := range strings.Split(data, "\n") {
+		text = strings.TrimRight(stripYAMLComment(text), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" || trimmed == "..." {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return fmt.Errorf("line %v: tabs cannot be used for indentation", n+1)
+		}
+		if strings.HasPrefix(trimmed, "%") {
+			return fmt.Errorf("line %v: directives are not supported", n+1)
+		}
+		p.lines = append(p.lines, yamlLine{number: n + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	return nil
+}
+
+// stripYAMLComment removes a comment that is not inside quotes.
+func stripYAMLComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// node writes the block node starting at the current line.
+func (p *yamlParser) node(indent int) error {
+	if isYAMLSequenceItem(p.lines[p.i].text) {
+		return p.sequence(indent)
+	}
+	if _, _, ok := splitYAMLKey(p.lines[p.i].text); !ok {
+		// a scalar or flow collection on its own
+		return p.inline(p.lines[p.i].text)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) sequence(indent int) error {
+	p.out.WriteByte('[')
+	for first := true; p.i < len(p.lines) && p.lines[p.i].indent == indent && isYAMLSequenceItem(p.lines[p.i].text); first = false {
+		if !first {
+			p.out.WriteByte(',')
+		}
+		line := p.lines[p.i]
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if rest == "" {
+			p.i++
+			if p.i < len(p.lines) && p.lines[p.i].indent > indent {
+				if err := p.node(p.lines[p.i].indent); err != nil {
+					return err
+				}
+			} else {
+				p.out.WriteString("null")
+			}
+			continue
+		}
+		// parse the rest of the line as if it started its own line, so that
+		// a mapping can continue on the lines after it
+		p.lines[p.i] = yamlLine{number: line.number, indent: indent + len(line.text) - len(rest), text: rest}
+		if err := p.node(p.lines[p.i].indent); err != nil {
+			return err
+		}
+	}
+	p.out.WriteByte(']')
+	return nil
+}
+
+func (p *yamlParser) mapping(indent int) error {
+	p.out.WriteByte('{')
+	for first := true; p.i < len(p.lines) && p.lines[p.i].indent == indent; first = false {
+		key, rest, ok := splitYAMLKey(p.lines[p.i].text)
+		if !ok {
+			return p.errorf("expected a key")
+		}
+		if !first {
+			p.out.WriteByte(',')
+		}
+		keyJSON, err := yamlScalarString(key)
+		if err != nil {
+			return p.errorf("%v", err)
+		}
+		p.out.WriteString(keyJSON)
+		p.out.WriteByte(':')
+		if rest != "" {
+			if err := p.inline(rest); err != nil {
+				return err
+			}
+			continue
+		}
+		p.i++
+		switch {
+		case p.i < len(p.lines) && p.lines[p.i].indent > indent:
+			err = p.node(p.lines[p.i].indent)
+		case p.i < len(p.lines) && p.lines[p.i].indent == indent && isYAMLSequenceItem(p.lines[p.i].text):
+			// sequences may be indented as much as their key
+			err = p.sequence(indent)
+		default:
+			p.out.WriteString("null")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if p.i < len(p.lines) && p.lines[p.i].indent > indent {
+		return p.errorf("unexpected indentation")
+	}
+	p.out.WriteByte('}')
+	return nil
+}
+
+// inline writes a value given on the same line as its key or sequence item,
+// consuming more lines if it is a flow collection that continues onto them.
+func (p *yamlParser) inline(text string) error {
+	if text[0] == '|' || text[0] == '>' {
+		return p.errorf("multi-line scalars are not supported")
+	}
+	if text[0] == '&' || text[0] == '*' || text[0] == '!' {
+		return p.errorf("anchors, aliases and tags are not supported")
+	}
+	if text[0] == '{' || text[0] == '[' {
+		for yamlFlowDepth(text) > 0 && p.i+1 < len(p.lines) {
+			p.i++
+			text += " " + p.lines[p.i].text
+		}
+		f := &yamlFlow{text: text}
+		if err := f.value(&p.out); err != nil {
+			return p.errorf("%v", err)
+		}
+		f.skipSpace()
+		if f.pos < len(f.text) {
+			return p.errorf("unexpected '%v' after flow collection", f.text[f.pos:])
+		}
+		p.i++
+		return nil
+	}
+	value, err := yamlScalar(text)
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+	p.out.WriteString(value)
+	p.i++
+	return nil
+}
+
+// yamlFlowDepth returns how many flow collections are still open at the
+// end of text.
+func yamlFlowDepth(text string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return depth
+}
+
+// splitYAMLKey splits a "key: value" line. It returns false if the line is
+// not a mapping entry.
+func splitYAMLKey(text string) (string, string, bool) {
+	if text[0] == '{' || text[0] == '[' {
+		return "", "", false
+	}
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+var yamlNumber = regexp.MustCompile(`^[-+]?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// yamlScalar converts a plain or quoted scalar to JSON.
+func yamlScalar(text string) (string, error) {
+	switch text {
+	case "~", "null", "Null", "NULL":
+		return "null", nil
+	case "true", "True", "TRUE":
+		return "true", nil
+	case "false", "False", "FALSE":
+		return "false", nil
+	}
+	if yamlNumber.MatchString(text) {
+		return strings.TrimPrefix(text, "+"), nil
+	}
+	return yamlScalarString(text)
+}
+
+// yamlScalarString converts a scalar to a JSON string, however it is
+// written.
+func yamlScalarString(text string) (string, error) {
+	var value string
+	switch {
+	case len(text) >= 2 && text[0] == '"' && text[len(text)-1] == '"':
+		if err := json.Unmarshal([]byte(text), &value); err != nil {
+			return "", fmt.Errorf("invalid quoted string %v", text)
+		}
+	case len(text) >= 2 && text[0] == '\'' && text[len(text)-1] == '\'':
+		value = strings.Replace(text[1:len(text)-1], "''", "'", -1)
+	case text[0] == '"' || text[0] == '\'':
+		return "", fmt.Errorf("unterminated string %v", text)
+	default:
+		value = text
+	}
+	out, err := json.Marshal(value)
+	return string(out), err
+}
+
+// yamlFlow parses a flow collection, such as {a: 1, b: [x, y]}.
+type yamlFlow struct {
+	text string
+	pos  int
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.pos < len(f.text) && f.text[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *yamlFlow) value(out *bytes.Buffer) error {
+	f.skipSpace()
+	if f.pos >= len(f.text) {
+		return fmt.Errorf("unexpected end of flow collection")
+	}
+	switch f.text[f.pos] {
+	case '{':
+		return f.collection(out, '{', '}')
+	case '[':
+		return f.collection(out, '[', ']')
+	}
+	scalar, err := f.scalar(false)
+	if err != nil {
+		return err
+	}
+	value, err := yamlScalar(scalar)
+	if err != nil {
+		return err
+	}
+	out.WriteString(value)
+	return nil
+}
+
+func (f *yamlFlow) collection(out *bytes.Buffer, open, close byte) error {
+	f.pos++
+	out.WriteByte(open)
+	for first := true; ; first = false {
+		f.skipSpace()
+		if f.pos < len(f.text) && f.text[f.pos] == close {
+			f.pos++
+			out.WriteByte(close)
+			return nil
+		}
+		if !first {
+			if f.pos >= len(f.text) || f.text[f.pos] != ',' {
+				return fmt.Errorf("expected ',' or '%c' in flow collection", close)
+			}
+			f.pos++
+			f.skipSpace()
+			if f.pos < len(f.text) && f.text[f.pos] == close {
+				// a trailing comma
+				continue
+			}
+			out.WriteByte(',')
+		}
+		if open == '{' {
+			key, err := f.scalar(true)
+			if err != nil {
+				return err
+			}
+			keyJSON, err := yamlScalarString(key)
+			if err != nil {
+				return err
+			}
+			out.WriteString(keyJSON)
+			f.skipSpace()
+			if f.pos >= len(f.text) || f.text[f.pos] != ':' {
+				return fmt.Errorf("expected ':' after key %v", key)
+			}
+			f.pos++
+			out.WriteByte(':')
+		}
+		if err := f.value(out); err != nil {
+			return err
+		}
+	}
+}
+
+// scalar returns the text of a scalar in a flow collection, with its
+// quotes. Keys also end at a colon.
+func (f *yamlFlow) scalar(key bool) (string, error) {
+	f.skipSpace()
+	start := f.pos
+	if f.pos < len(f.text) && (f.text[f.pos] == '"' || f.text[f.pos] == '\'') {
+		quote := f.text[f.pos]
+		for f.pos++; f.pos < len(f.text); f.pos++ {
+			if f.text[f.pos] == '\\' && quote == '"' {
+				f.pos++
+			} else if f.text[f.pos] == quote {
+				if quote == '\'' && f.pos+1 < len(f.text) && f.text[f.pos+1] == '\'' {
+					f.pos++
+					continue
+				}
+				f.pos++
+				return f.text[start:f.pos], nil
+			}
+		}
+		return "", fmt.Errorf("unterminated string %v", f.text[start:])
+	}
+	for ; f.pos < len(f.text); f.pos++ {
+		c := f.text[f.pos]
+		if c == ',' || c == '}' || c == ']' || (key && c == ':') {
+			break
+		}
+		if c == ':' && (f.pos+1 == len(f.text) || f.text[f.pos+1] == ' ') {
+			break
+		}
+	}
+	scalar := strings.TrimSpace(f.text[start:f.pos])
+	if scalar == "" {
+		return "", fmt.Errorf("expected a value in flow collection")
+	}
+	return scalar, nil
+}
@@ -0,0 +1,150 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestYAMLToJSON(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	converts := func(yaml, expected string) {
+		json, err := YAMLToJSON([]byte(yaml))
+		So(err, ShouldBeNil)
+		So(string(json), ShouldEqual, expected)
+	}
+	fails := func(yaml, message string) {
+		_, err := YAMLToJSON([]byte(yaml))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, message)
+	}
+
+	Convey("Scalars should convert to JSON values", t, func() {
+		converts("plain text", `"plain text"`)
+		converts("42", `42`)
+		converts("-1.5e3", `-1.5e3`)
+		converts("+7", `7`)
+		converts("007", `"007"`)
+		converts("1.", `"1."`)
+		converts("true", `true`)
+		converts("FALSE", `false`)
+		converts("~", `null`)
+		converts("Null", `null`)
+		converts("yes", `"yes"`)
+		converts(`"tab\tand \"quote\""`, `"tab\tand \"quote\""`)
+		converts(`'it''s'`, `"it's"`)
+		converts(`"42"`, `"42"`)
+		converts(`'true'`, `"true"`)
+		converts("", `null`)
+		converts("# only a comment\n", `null`)
+	})
+
+	Convey("Block mappings should keep the order of their keys", t, func() {
+		converts("b: 1\na: 2\nc: x", `{"b":1,"a":2,"c":"x"}`)
+		converts("a:\n  b:\n    c: 1\n  d: 2\ne: 3", `{"a":{"b":{"c":1},"d":2},"e":3}`)
+		converts("empty:\nnext: 1", `{"empty":null,"next":1}`)
+		converts(`"quoted key": 1`+"\n'single: quoted': 2", `{"quoted key":1,"single: quoted":2}`)
+		converts("$gte: 5\n$in.x: 1", `{"$gte":5,"$in.x":1}`)
+		converts("url: http://host:27017/db", `{"url":"http://host:27017/db"}`)
+		converts("time: 12:30", `{"time":"12:30"}`)
+		converts("a: 1 # comment\n# comment\nb: 'x # not a comment'", `{"a":1,"b":"x # not a comment"}`)
+		converts("a: x#y", `{"a":"x#y"}`)
+		converts("---\na: 1\n...\n", `{"a":1}`)
+		converts("a: 1\r\nb: 2\r\n", `{"a":1,"b":2}`)
+	})
+
+	Convey("Block sequences should convert to arrays", t, func() {
+		converts("- 1\n- two\n- true", `[1,"two",true]`)
+		converts("a:\n  - 1\n  - 2", `{"a":[1,2]}`)
+		converts("a:\n- 1\n- 2\nb: 3", `{"a":[1,2],"b":3}`)
+		converts("- a: 1\n  b: 2\n- c: 3", `[{"a":1,"b":2},{"c":3}]`)
+		converts("- - 1\n  - 2\n- - 3", `[[1,2],[3]]`)
+		converts("-\n  a: 1\n-\n- x", `[{"a":1},null,"x"]`)
+		converts("$or:\n  - active: true\n  - plan:\n      $in: [pro, enterprise]",
+			`{"$or":[{"active":true},{"plan":{"$in":["pro","enterprise"]}}]}`)
+	})
+
+	Convey("Flow collections should convert to JSON", t, func() {
+		converts("{a: 1, b: [x, 'y', \"z\"]}", `{"a":1,"b":["x","y","z"]}`)
+		converts("a: {}\nb: []", `{"a":{},"b":[]}`)
+		converts("a: [1, 2, ]", `{"a":[1,2]}`)
+		converts("a: {$date: '2020-01-01T00:00:00Z'}", `{"a":{"$date":"2020-01-01T00:00:00Z"}}`)
+		converts("a: {url: http://host/x, 'k:': v}", `{"a":{"url":"http://host/x","k:":"v"}}`)
+		converts("a: {b: [1,\n  2], c: 3}\nd: 4", `{"a":{"b":[1,2],"c":3},"d":4}`)
+		converts("- [a, '[b]', \"c, d\"]", `[["a","[b]","c, d"]]`)
+		converts("a: {b: {c: {d: null}}}", `{"a":{"b":{"c":{"d":null}}}}`)
+	})
+
+	Convey("Unsupported or invalid YAML should fail with its line", t, func() {
+		fails("a: 1\n\tb: 2", "line 2: tabs cannot be used for indentation")
+		fails("%YAML 1.2\na: 1", "line 1: directives are not supported")
+		fails("a: |\n  text", "line 1: multi-line scalars are not supported")
+		fails("a: &anchor 1", "anchors, aliases and tags are not supported")
+		fails("a: *anchor", "anchors, aliases and tags are not supported")
+		fails("a: !!str 1", "anchors, aliases and tags are not supported")
+		fails("a: 1\n    b: 2", "line 2: unexpected indentation")
+		fails("a:\n  b: 1\n c: 2", "unexpected indentation")
+		fails("a: 1\njust text", "line 2: expected a key")
+		fails("a: 'open", "line 1: unterminated string")
+		fails(`a: "bad \q escape"`, "invalid quoted string")
+		fails("a: {b: 1", "expected ',' or '}' in flow collection")
+		fails("a: [", "unexpected end of flow collection")
+		fails("a: {b 1}", "expected ':' after key b")
+		fails("a: [1 2] x", "unexpected")
+		fails("a: {b: 1} c", "unexpected 'c' after flow collection")
+		fails("a: [1, {b: 'x]", "unterminated string")
+		fails("a: [,]", "expected a value in flow collection")
+	})
+}
+
+func TestReadConfigFile(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With configuration files", t, func() {
+		dir, err := ioutil.TempDir("", "util_yaml")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		write := func(name, content string) string {
+			path := filepath.Join(dir, name)
+			So(ioutil.WriteFile(path, []byte(content), 0644), ShouldBeNil)
+			return path
+		}
+
+		Convey("YAML files should be converted to JSON", func() {
+			for _, name := range []string{"config.yaml", "config.yml", "CONFIG.YML"} {
+				content, err := ReadConfigFile(write(name, "a: [1, 2]\n"))
+				So(err, ShouldBeNil)
+				So(string(content), ShouldEqual, `{"a":[1,2]}`)
+			}
+		})
+
+		Convey("other files should be read as they are", func() {
+			content, err := ReadConfigFile(write("config.json", "a: 1"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "a: 1")
+		})
+
+		Convey("YAML errors should name the file", func() {
+			path := write("bad.yaml", "a: 'open")
+			_, err := ReadConfigFile(path)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "error parsing "+path+": line 1:")
+		})
+
+		Convey("missing files should fail", func() {
+			_, err := ReadConfigFile(filepath.Join(dir, "missing.yaml"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
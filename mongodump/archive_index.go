@@ -0,0 +1,60 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// indexedArchive is an archive file whose blocks are recorded in an index
+// with --archiveIndex.
+type indexedArchive struct {
+	io.WriteCloser
+	written int64 // updated atomically
+	path    string
+	index   *archive.Index
+}
+
+func (w *indexedArchive) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	atomic.AddInt64(&w.written, int64(n))
+	return n, err
+}
+
+// indexArchiveOut returns a writer of the archive file at path that counts
+// the bytes written for its index, or out if --archiveIndex isn't given.
+func (dump *MongoDump) indexArchiveOut(path string, out io.WriteCloser) io.WriteCloser {
+	if !dump.OutputOptions.ArchiveIndex {
+		return out
+	}
+	w := &indexedArchive{WriteCloser: out, path: path}
+	w.index = archive.NewIndex(func() int64 { return atomic.LoadInt64(&w.written) })
+	dump.archiveIndex = w
+	return w
+}
+
+// writeArchiveIndex writes the index of the blocks of the archive next to
+// it, once the archive is complete.
+func (dump *MongoDump) writeArchiveIndex() error {
+	path := dump.archiveIndex.path + archive.IndexSuffix
+	content, err := json.Marshal(dump.archiveIndex.index)
+	if err != nil {
+		return fmt.Errorf("error encoding the archive index: %v", err)
+	}
+	if err = ioutil.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("error writing the archive index: %v", err)
+	}
+	log.Logvf(log.Info, "wrote the index of the archive to %v", path)
+	return nil
+}
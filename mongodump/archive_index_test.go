@@ -0,0 +1,109 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type nopNotifier struct{}
+
+func (nopNotifier) Notify() {}
+
+// blockHeader returns the namespace header a block starts with.
+func blockHeader(block []byte) archive.NamespaceHeader {
+	var header archive.NamespaceHeader
+	So(bson.Unmarshal(block[:binary.LittleEndian.Uint32(block)], &header), ShouldBeNil)
+	return header
+}
+
+func TestArchiveIndex(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With an archive of two collections written with --archiveIndex", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump_index")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "dump.archive")
+
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.ArchiveIndex = true
+		var out bytes.Buffer
+		writer := md.indexArchiveOut(path, &nopCloseWriter{&out})
+		So(md.archiveIndex, ShouldNotBeNil)
+		_, err = writer.Write([]byte("prelude"))
+		So(err, ShouldBeNil)
+
+		mux := archive.NewMultiplexer(writer, nopNotifier{})
+		mux.Index = md.archiveIndex.index
+		go mux.Run()
+		ins := []*archive.MuxIn{
+			{Intent: &intents.Intent{DB: "test", C: "a"}, Mux: mux},
+			{Intent: &intents.Intent{DB: "test", C: "b"}, Mux: mux},
+		}
+		for _, in := range ins {
+			So(in.Open(), ShouldBeNil)
+		}
+		for i := 0; i < 3; i++ {
+			for _, in := range ins {
+				doc, err := bson.Marshal(bson.D{{Key: "_id", Value: i}})
+				So(err, ShouldBeNil)
+				_, err = in.Write(doc)
+				So(err, ShouldBeNil)
+			}
+		}
+		for _, in := range ins {
+			So(in.Close(), ShouldBeNil)
+		}
+		close(mux.Control)
+		So(<-mux.Completed, ShouldBeNil)
+		So(md.writeArchiveIndex(), ShouldBeNil)
+
+		Convey("the index records the blocks of each namespace", func() {
+			content, err := ioutil.ReadFile(path + archive.IndexSuffix)
+			So(err, ShouldBeNil)
+			var index archive.Index
+			So(json.Unmarshal(content, &index), ShouldBeNil)
+			So(index.FormatVersion, ShouldEqual, archive.IndexFormatVersion)
+			So(index.Size, ShouldEqual, out.Len())
+			So(index.Namespaces, ShouldContainKey, "test.a")
+			So(index.Namespaces, ShouldContainKey, "test.b")
+
+			for ns, blocks := range index.Namespaces {
+				So(blocks[0].Offset, ShouldBeGreaterThanOrEqualTo, len("prelude"))
+				for _, block := range blocks {
+					header := blockHeader(out.Bytes()[block.Offset : block.Offset+block.Length])
+					So(header.Database+"."+header.Collection, ShouldEqual, ns)
+					So(out.Bytes()[block.Offset+block.Length-4:block.Offset+block.Length], ShouldResemble, []byte{0xff, 0xff, 0xff, 0xff})
+				}
+			}
+
+			Convey("and reads only the blocks of the namespaces not skipped", func() {
+				blocks, err := ioutil.ReadAll(index.Reader(bytes.NewReader(out.Bytes()), map[string]bool{"test.b": true}))
+				So(err, ShouldBeNil)
+				var length int64
+				for _, block := range index.Namespaces["test.a"] {
+					length += block.Length
+				}
+				So(len(blocks), ShouldEqual, length)
+				So(blockHeader(blocks).Collection, ShouldEqual, "a")
+			})
+		})
+	})
+}
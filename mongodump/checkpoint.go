@@ -0,0 +1,321 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// checkpointVersion is the version of the checkpoint file format.
+const checkpointVersion = 1
+
+// checkpointInterval is how often the position of each collection being
+// dumped is saved to the checkpoint file.
+var checkpointInterval = 10 * time.Second
+
+// Ways of resuming a collection
+const (
+	// resume after the last dumped _id, scanning in _id order
+	resumeByID = "_id"
+	// resume by skipping the documents already dumped, scanning in natural
+	// order; only reliable for collections that are not being modified
+	resumeByPosition = "natural"
+)
+
+// dumpCheckpoint records how far a directory dump got, so that an
+// interrupted dump can be resumed with --resume.
+type dumpCheckpoint struct {
+	Version     int                              `json:"version"`
+	Out         string                           `json:"out"`
+	Query       string                           `json:"query,omitempty"`
+	Collections map[string]*collectionCheckpoint `json:"collections"`
+
+	path  string
+	mutex sync.Mutex
+}
+
+// collectionCheckpoint is the position reached in a single collection.
+// Bytes is the length of the valid part of File, which is truncated to it on
+// resume to discard any partially written document.
+type collectionCheckpoint struct {
+	File      string          `json:"file"`
+	Mode      string          `json:"mode"`
+	LastID    json.RawMessage `json:"lastId,omitempty"`
+	Documents int64           `json:"documents"`
+	Bytes     int64           `json:"bytes"`
+	Complete  bool            `json:"complete"`
+}
+
+// checkpointPath returns the checkpoint file location, which defaults to a
+// file next to the output directory.
+func (dump *MongoDump) checkpointPath() string {
+	if dump.OutputOptions.CheckpointFile != "" {
+		return dump.OutputOptions.CheckpointFile
+	}
+	return filepath.Clean(dump.outputRoot()) + ".checkpoint.json"
+}
+
+// outputRoot returns the output directory.
+func (dump *MongoDump) outputRoot() string {
+	if dump.OutputOptions.Out == "" {
+		return "dump"
+	}
+	return dump.OutputOptions.Out
+}
+
+// initCheckpoint loads the checkpoint when resuming, or starts a new one.
+func (dump *MongoDump) initCheckpoint(query string) error {
+	path := dump.checkpointPath()
+	cp := &dumpCheckpoint{
+		Version:     checkpointVersion,
+		Out:         filepath.Clean(dump.outputRoot()),
+		Query:       query,
+		Collections: map[string]*collectionCheckpoint{},
+		path:        path,
+	}
+	dump.checkpoint = cp
+	if !dump.OutputOptions.Resume {
+		return cp.save()
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Logvf(log.Always, "no checkpoint found at %v, starting a new dump", path)
+		return cp.save()
+	}
+	if err != nil {
+		return fmt.Errorf("error reading checkpoint file: %v", err)
+	}
+	saved := &dumpCheckpoint{}
+	if err = json.Unmarshal(data, saved); err != nil {
+		return fmt.Errorf("error parsing checkpoint file %v: %v", path, err)
+	}
+	switch {
+	case saved.Version != checkpointVersion:
+		return fmt.Errorf("checkpoint file %v has unsupported version %v", path, saved.Version)
+	case saved.Out != cp.Out:
+		return fmt.Errorf("checkpoint file %v is for a dump to '%v', not '%v'", path, saved.Out, cp.Out)
+	case saved.Query != cp.Query:
+		return fmt.Errorf("checkpoint file %v was written with a different query", path)
+	}
+	if saved.Collections != nil {
+		cp.Collections = saved.Collections
+	}
+	log.Logvf(log.Always, "resuming dump from checkpoint %v", path)
+	return nil
+}
+
+// save atomically replaces the checkpoint file.
+func (cp *dumpCheckpoint) save() error {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := cp.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing checkpoint file: %v", err)
+	}
+	if err = os.Rename(tmp, cp.path); err != nil {
+		return fmt.Errorf("error writing checkpoint file: %v", err)
+	}
+	return nil
+}
+
+// remove deletes the checkpoint file once the dump has completed.
+func (cp *dumpCheckpoint) remove() error {
+	err := os.Remove(cp.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// update applies fn while holding the checkpoint's lock, then saves it.
+func (cp *dumpCheckpoint) update(fn func()) error {
+	cp.mutex.Lock()
+	fn()
+	cp.mutex.Unlock()
+	return cp.save()
+}
+
+// prepareCheckpoint sets up checkpointing for a collection, adjusting the
+// query to resume after the recorded position. It returns nil for intents
+// that are not checkpointed.
+func (dump *MongoDump) prepareCheckpoint(intent *intents.Intent, query *db.DeferredQuery) (*collectionCheckpoint, error) {
+	file, ok := intent.BSONFile.(*realBSONFile)
+	if dump.checkpoint == nil || !ok || intent.IsSpecialCollection() || intent.IsOplog() {
+		return nil, nil
+	}
+	cp := dump.checkpoint
+
+	cp.mutex.Lock()
+	coll, found := cp.Collections[intent.Namespace()]
+	if !found {
+		coll = &collectionCheckpoint{File: file.path, Mode: checkpointMode(intent)}
+		cp.Collections[intent.Namespace()] = coll
+	}
+	cp.mutex.Unlock()
+
+	if !found {
+		file.checkpoint = coll
+		return coll, cp.save()
+	}
+	if coll.File != file.path {
+		return nil, fmt.Errorf("checkpoint for %v is for file %v, not %v", intent.Namespace(), coll.File, file.path)
+	}
+	if coll.Complete {
+		return coll, nil
+	}
+
+	info, err := os.Stat(file.path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resume %v: %v", intent.Namespace(), err)
+	}
+	if info.Size() < coll.Bytes {
+		return nil, fmt.Errorf("cannot resume %v: %v is shorter than recorded in the checkpoint (%v < %v bytes)",
+			intent.Namespace(), file.path, info.Size(), coll.Bytes)
+	}
+	file.resumeAt = coll.Bytes
+	file.checkpoint = coll
+
+	switch coll.Mode {
+	case resumeByID:
+		if coll.LastID != nil {
+			var lastID bson.D
+			if err = bson.UnmarshalExtJSON(coll.LastID, true, &lastID); err != nil || len(lastID) != 1 {
+				return nil, fmt.Errorf("invalid last _id for %v in checkpoint: %v", intent.Namespace(), err)
+			}
+			// an index bound resumes across every BSON type in _id order,
+			// where a $gt filter would only match _ids of the same type
+			query.Min = bson.D{{"_id", lastID[0].Value}}
+			notLast := bson.D{{"_id", bson.D{{"$ne", lastID[0].Value}}}}
+			if query.Filter != nil {
+				query.Filter = bson.D{{"$and", bson.A{query.Filter, notLast}}}
+			} else {
+				query.Filter = notLast
+			}
+		}
+	case resumeByPosition:
+		query.Skip = coll.Documents
+	default:
+		return nil, fmt.Errorf("unknown resume mode '%v' for %v in checkpoint", coll.Mode, intent.Namespace())
+	}
+	log.Logvf(log.Always, "resuming %v after %v %v", intent.Namespace(), coll.Documents, docPlural(coll.Documents))
+	return coll, nil
+}
+
+// checkpointMode returns how a collection can be resumed. Collections
+// without an _id index, including time-series collections, can only be
+// resumed by position, and so can capped collections, which are read in
+// insertion order.
+func checkpointMode(intent *intents.Intent) string {
+	if intent.IsView() || intent.IsTimeseries() || intent.IsCapped() {
+		return resumeByPosition
+	}
+	if autoIndexID, ok := intent.Options["autoIndexId"]; ok && autoIndexID == false {
+		return resumeByPosition
+	}
+	return resumeByID
+}
+
+// applyCheckpointOrder makes the query scan the _id index, which the
+// checkpoint's order and resume bound rely on.
+func applyCheckpointOrder(coll *collectionCheckpoint, query *db.DeferredQuery) {
+	if coll != nil && coll.Mode == resumeByID {
+		query.Sort = bson.D{{"_id", 1}}
+		query.Hint = bson.D{{"_id", 1}}
+	}
+}
+
+// checkpointWriter records the position of each document written through
+// it, and periodically flushes the output and saves the checkpoint.
+type checkpointWriter struct {
+	io.Writer
+	dump  *MongoDump
+	coll  *collectionCheckpoint
+	file  *realBSONFile
+	flush func() error
+
+	position  int64
+	documents int64
+	lastID    json.RawMessage
+	lastSave  time.Time
+}
+
+func (dump *MongoDump) newCheckpointWriter(w io.Writer, file *realBSONFile, flush func() error) *checkpointWriter {
+	return &checkpointWriter{
+		Writer:    w,
+		dump:      dump,
+		coll:      file.checkpoint,
+		file:      file,
+		flush:     flush,
+		position:  file.checkpoint.Bytes,
+		documents: file.checkpoint.Documents,
+		lastID:    file.checkpoint.LastID,
+		lastSave:  time.Now(),
+	}
+}
+
+// Write writes a single document.
+func (w *checkpointWriter) Write(doc []byte) (int, error) {
+	n, err := w.Writer.Write(doc)
+	if err != nil {
+		return n, err
+	}
+	w.position += int64(n)
+	w.documents++
+	if w.coll.Mode == resumeByID {
+		id, err := bson.Raw(doc).LookupErr("_id")
+		if err != nil {
+			return n, fmt.Errorf("cannot checkpoint a document without an _id")
+		}
+		w.lastID, err = bson.MarshalExtJSON(bson.D{{"_id", id}}, true, false)
+		if err != nil {
+			return n, fmt.Errorf("error recording _id in checkpoint: %v", err)
+		}
+	}
+	if time.Since(w.lastSave) >= checkpointInterval {
+		if err = w.save(false); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// save flushes everything written so far to disk and records the position
+// in the checkpoint.
+func (w *checkpointWriter) save(complete bool) error {
+	if w.flush != nil {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	if err := w.file.sync(); err != nil {
+		return err
+	}
+	w.lastSave = time.Now()
+	return w.dump.checkpoint.update(func() {
+		w.coll.Bytes = w.position
+		w.coll.Documents = w.documents
+		w.coll.LastID = w.lastID
+		w.coll.Complete = complete
+	})
+}
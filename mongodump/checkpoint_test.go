@@ -0,0 +1,207 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func marshalDocs(docs ...bson.D) [][]byte {
+	out := [][]byte{}
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		So(err, ShouldBeNil)
+		out = append(out, raw)
+	}
+	return out
+}
+
+func TestDumpCheckpoint(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+	log.SetWriter(ioutil.Discard)
+
+	Convey("With a checkpointed dump to a temporary directory", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump_checkpoint")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		newDump := func(resume bool) *MongoDump {
+			md := simpleMongoDumpInstance()
+			md.OutputOptions.Out = filepath.Join(dir, "dump")
+			md.OutputOptions.Resume = resume
+			return md
+		}
+		newIntent := func() (*intents.Intent, *realBSONFile) {
+			intent := &intents.Intent{DB: "db", C: "coll"}
+			file := &realBSONFile{path: filepath.Join(dir, "dump", "db", "coll.bson"), intent: intent}
+			intent.BSONFile = file
+			return intent, file
+		}
+		docs := marshalDocs(bson.D{{"_id", 1}}, bson.D{{"_id", 2}}, bson.D{{"_id", 3}})
+
+		md := newDump(false)
+		So(md.checkpointsEnabled(), ShouldBeFalse)
+		md.OutputOptions.CheckpointFile = filepath.Join(dir, "progress.json")
+		So(md.checkpointsEnabled(), ShouldBeTrue)
+		So(md.initCheckpoint(""), ShouldBeNil)
+		_, err = os.Stat(md.checkpointPath())
+		So(err, ShouldBeNil)
+
+		intent, file := newIntent()
+		query := &db.DeferredQuery{}
+		coll, err := md.prepareCheckpoint(intent, query)
+		So(err, ShouldBeNil)
+		So(coll.Mode, ShouldEqual, resumeByID)
+		So(query.Filter, ShouldBeNil)
+
+		// write two documents, then a partial third as if we were interrupted
+		So(file.Open(), ShouldBeNil)
+		w := md.newCheckpointWriter(file, file, nil)
+		for _, doc := range docs[:2] {
+			_, err = w.Write(doc)
+			So(err, ShouldBeNil)
+		}
+		So(w.save(false), ShouldBeNil)
+		_, err = file.Write(docs[2][:5])
+		So(err, ShouldBeNil)
+		So(file.Close(), ShouldBeNil)
+
+		Convey("resuming continues after the last _id and discards the partial document", func() {
+			md := newDump(true)
+			md.OutputOptions.CheckpointFile = filepath.Join(dir, "progress.json")
+			So(md.initCheckpoint(""), ShouldBeNil)
+
+			intent, file := newIntent()
+			query := &db.DeferredQuery{}
+			coll, err := md.prepareCheckpoint(intent, query)
+			So(err, ShouldBeNil)
+			So(coll.Documents, ShouldEqual, 2)
+			So(query.Min, ShouldResemble, bson.D{{"_id", int32(2)}})
+			So(query.Filter, ShouldResemble, bson.D{{"_id", bson.D{{"$ne", int32(2)}}}})
+
+			So(file.Open(), ShouldBeNil)
+			w := md.newCheckpointWriter(file, file, nil)
+			_, err = w.Write(docs[2])
+			So(err, ShouldBeNil)
+			So(w.save(true), ShouldBeNil)
+			So(file.Close(), ShouldBeNil)
+
+			contents, err := ioutil.ReadFile(file.path)
+			So(err, ShouldBeNil)
+			So(len(contents), ShouldEqual, len(docs[0])+len(docs[1])+len(docs[2]))
+			So(md.checkpoint.Collections["db.coll"].Complete, ShouldBeTrue)
+
+			Convey("and the checkpoint is removed when the dump finishes", func() {
+				So(md.checkpoint.remove(), ShouldBeNil)
+				_, err = os.Stat(md.checkpointPath())
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+
+		Convey("resuming combines the saved position with the query", func() {
+			md := newDump(true)
+			md.OutputOptions.CheckpointFile = filepath.Join(dir, "progress.json")
+			So(md.initCheckpoint(""), ShouldBeNil)
+
+			intent, _ := newIntent()
+			query := &db.DeferredQuery{Filter: bson.D{{"a", 1}}}
+			_, err := md.prepareCheckpoint(intent, query)
+			So(err, ShouldBeNil)
+			So(query.Filter, ShouldResemble, bson.D{{"$and", bson.A{
+				bson.D{{"a", 1}},
+				bson.D{{"_id", bson.D{{"$ne", int32(2)}}}},
+			}}})
+			So(query.Min, ShouldResemble, bson.D{{"_id", int32(2)}})
+		})
+
+		Convey("resuming fails if the checkpoint is for a different dump", func() {
+			md := newDump(true)
+			md.OutputOptions.CheckpointFile = filepath.Join(dir, "progress.json")
+			err := md.initCheckpoint(`{"a":1}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "different query")
+
+			md = newDump(true)
+			md.OutputOptions.Out = filepath.Join(dir, "other")
+			md.OutputOptions.CheckpointFile = filepath.Join(dir, "progress.json")
+			So(md.initCheckpoint(""), ShouldNotBeNil)
+		})
+
+		Convey("resuming fails if the output file was truncated", func() {
+			md := newDump(true)
+			md.OutputOptions.CheckpointFile = filepath.Join(dir, "progress.json")
+			So(md.initCheckpoint(""), ShouldBeNil)
+
+			intent, file := newIntent()
+			So(os.Truncate(file.path, int64(len(docs[0]))), ShouldBeNil)
+			_, err := md.prepareCheckpoint(intent, &db.DeferredQuery{})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "shorter than recorded")
+		})
+
+		Convey("collections without an _id index resume by position", func() {
+			md := newDump(false)
+			md.OutputOptions.CheckpointFile = filepath.Join(dir, "progress.json")
+			So(md.initCheckpoint(""), ShouldBeNil)
+
+			intent, _ := newIntent()
+			intent.Options = bson.M{"autoIndexId": false}
+			coll, err := md.prepareCheckpoint(intent, &db.DeferredQuery{})
+			So(err, ShouldBeNil)
+			So(coll.Mode, ShouldEqual, resumeByPosition)
+		})
+	})
+}
+
+func TestCheckpointValidation(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a MongoDump instance using --resume", t, func() {
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.Resume = true
+
+		Convey("the default checkpoint file is next to the output directory", func() {
+			So(md.checkpointPath(), ShouldEqual, "dump.checkpoint.json")
+			md.OutputOptions.Out = "backups/today/"
+			So(md.checkpointPath(), ShouldEqual, filepath.Join("backups", "today.checkpoint.json"))
+		})
+
+		Convey("archives are not allowed", func() {
+			md.OutputOptions.Archive = "dump.archive"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("object storage output is not allowed", func() {
+			md.OutputOptions.Out = "s3://bucket/dump"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--gzip is not allowed", func() {
+			md.OutputOptions.Gzip = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--forceTableScan is not allowed", func() {
+			md.InputOptions.TableScan = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("a directory dump is allowed", func() {
+			So(md.ValidateOptions(), ShouldBeNil)
+		})
+	})
+}
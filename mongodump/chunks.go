@@ -0,0 +1,121 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// samplesPerChunk is how many _ids are sampled for each chunk, so that
+	// chunks are close to the same size.
+	samplesPerChunk = 10
+	// minDocumentsPerChunk keeps small collections from being split, since
+	// sampling costs more than it saves for them.
+	minDocumentsPerChunk = 1000
+)
+
+// canSplitCollection returns true if the collection's documents can be read
+// in ranges of its _id index. Capped collections never are, so that they are
+// dumped in insertion order.
+func canSplitCollection(intent *intents.Intent, isView bool) bool {
+	if isView || intent.IsSpecialCollection() || intent.IsOplog() || intent.IsTimeseries() || intent.IsCapped() {
+		return false
+	}
+	autoIndexId, found := intent.Options["autoIndexId"]
+	return !found || autoIndexId == true
+}
+
+// splitQuery divides a query of a collection into the number of _id ranges
+// given by --numParallelChunksPerCollection, which can be read concurrently.
+// The ranges are index bounds rather than filters, since a range filter on
+// _id would only match values of the same BSON type as its bounds. It
+// returns the query unchanged if the collection is too small to split.
+func (dump *MongoDump) splitQuery(query *db.DeferredQuery, intentLog *log.FieldLogger) ([]*db.DeferredQuery, error) {
+	chunks := dump.OutputOptions.NumParallelChunks
+	count, err := query.EstimatedDocumentCount()
+	if err != nil {
+		return nil, fmt.Errorf("error counting documents to split: %v", err)
+	}
+	if count < chunks*minDocumentsPerChunk {
+		return []*db.DeferredQuery{query}, nil
+	}
+
+	boundaries, err := sampleChunkBoundaries(query.Coll, chunks)
+	if err != nil {
+		return nil, fmt.Errorf("error sampling _id values to split %v: %v", query.Coll.Name(), err)
+	}
+	intentLog.Logvf(log.Info, "reading %v in %v ranges of _id", query.Coll.Name(), len(boundaries)+1)
+
+	queries := make([]*db.DeferredQuery, 0, len(boundaries)+1)
+	for i := 0; i <= len(boundaries); i++ {
+		chunk := *query
+		chunk.Hint = bson.D{{"_id", 1}}
+		if i > 0 {
+			chunk.Min = bson.D{{"_id", boundaries[i-1]}}
+		}
+		if i < len(boundaries) {
+			chunk.Max = bson.D{{"_id", boundaries[i]}}
+		}
+		queries = append(queries, &chunk)
+	}
+	return queries, nil
+}
+
+// sampleChunkBoundaries returns up to chunks-1 distinct _id values, in
+// index order, that split the collection into ranges of about the same
+// number of documents.
+func sampleChunkBoundaries(coll *mongo.Collection, chunks int) ([]bson.RawValue, error) {
+	pipeline := bson.A{
+		bson.M{"$sample": bson.M{"size": chunks * samplesPerChunk}},
+		bson.M{"$project": bson.M{"_id": 1}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	}
+	cursor, err := coll.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var samples []bson.RawValue
+	for cursor.Next(context.Background()) {
+		id := cursor.Current.Lookup("_id")
+		// the cursor reuses its buffer
+		samples = append(samples, bson.RawValue{Type: id.Type, Value: append([]byte(nil), id.Value...)})
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, err
+	}
+	return chooseBoundaries(samples, chunks), nil
+}
+
+// chooseBoundaries picks evenly spaced values from sorted samples to split
+// them into chunks, skipping repeated values.
+func chooseBoundaries(samples []bson.RawValue, chunks int) []bson.RawValue {
+	var boundaries []bson.RawValue
+	for i := 1; i < chunks; i++ {
+		index := i * len(samples) / chunks
+		if index == 0 || index >= len(samples) {
+			continue
+		}
+		value := samples[index]
+		if n := len(boundaries); n > 0 && boundaries[n-1].Type == value.Type &&
+			bytes.Equal(boundaries[n-1].Value, value.Value) {
+			continue
+		}
+		boundaries = append(boundaries, value)
+	}
+	return boundaries
+}
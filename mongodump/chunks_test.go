@@ -0,0 +1,150 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	"github.com/mongodb/mongo-tools/common/util"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+func int32Value(i int32) bson.RawValue {
+	value := make([]byte, 4)
+	value[0], value[1], value[2], value[3] = byte(i), byte(i>>8), byte(i>>16), byte(i>>24)
+	return bson.RawValue{Type: bsontype.Int32, Value: value}
+}
+
+func TestChooseBoundaries(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With sorted samples", t, func() {
+		var samples []bson.RawValue
+		for i := int32(0); i < 40; i++ {
+			samples = append(samples, int32Value(i))
+		}
+
+		Convey("boundaries are evenly spaced", func() {
+			boundaries := chooseBoundaries(samples, 4)
+			So(boundaries, ShouldResemble, []bson.RawValue{int32Value(10), int32Value(20), int32Value(30)})
+		})
+
+		Convey("repeated values give fewer boundaries", func() {
+			for i := range samples[:25] {
+				samples[i] = int32Value(0)
+			}
+			boundaries := chooseBoundaries(samples, 4)
+			So(boundaries, ShouldResemble, []bson.RawValue{int32Value(0), int32Value(30)})
+		})
+
+		Convey("too few samples give fewer boundaries", func() {
+			So(chooseBoundaries(samples[:1], 4), ShouldBeEmpty)
+			So(chooseBoundaries(nil, 4), ShouldBeEmpty)
+		})
+	})
+
+	Convey("--numParallelChunksPerCollection can't be negative or used with checkpoints", t, func() {
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.NumParallelChunks = -1
+		So(md.ValidateOptions(), ShouldNotBeNil)
+
+		md.OutputOptions.NumParallelChunks = 4
+		So(md.ValidateOptions(), ShouldBeNil)
+		md.OutputOptions.Resume = true
+		So(md.ValidateOptions(), ShouldNotBeNil)
+	})
+
+	Convey("Capped collections are read whole in insertion order", t, func() {
+		intent := &intents.Intent{DB: "db", C: "log", Options: bson.M{"capped": true, "size": int32(4096), "max": int32(10)}}
+		So(intent.IsCapped(), ShouldBeTrue)
+		So(canSplitCollection(intent, false), ShouldBeFalse)
+		So(checkpointMode(intent), ShouldEqual, resumeByPosition)
+		So((&intents.Intent{DB: "db", C: "c", Options: bson.M{"capped": false}}).IsCapped(), ShouldBeFalse)
+	})
+
+	Convey("Time-series collections have no _id index to split or resume by", t, func() {
+		intent := &intents.Intent{DB: "db", C: "readings", Options: bson.M{"timeseries": bson.M{"timeField": "ts"}}}
+		So(canSplitCollection(intent, false), ShouldBeFalse)
+		So(checkpointMode(intent), ShouldEqual, resumeByPosition)
+
+		md := simpleMongoDumpInstance()
+		So(md.dumpsBuckets(intent), ShouldBeFalse)
+		md.OutputOptions.TimeseriesBuckets = true
+		So(md.dumpsBuckets(intent), ShouldBeTrue)
+		So(md.dumpsBuckets(&intents.Intent{DB: "db", C: "c"}), ShouldBeFalse)
+	})
+}
+
+func TestMongoDumpParallelChunks(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.IntegrationTestType)
+	log.SetWriter(ioutil.Discard)
+
+	Convey("With a collection of mixed _id types", t, func() {
+		session, err := testutil.GetBareSession()
+		So(err, ShouldBeNil)
+		coll := session.Database(testDB).Collection("chunks")
+		var docs []interface{}
+		for i := 0; i < 6000; i++ {
+			var id interface{} = i
+			switch i % 3 {
+			case 1:
+				id = fmt.Sprintf("id%v", i)
+			case 2:
+				id = float64(i) + 0.5
+			}
+			docs = append(docs, bson.M{"_id": id, "n": i})
+		}
+		_, err = coll.InsertMany(nil, docs)
+		So(err, ShouldBeNil)
+
+		dumpDir, err := ioutil.TempDir("", "mongodump_chunks")
+		So(err, ShouldBeNil)
+
+		Convey("a dump in parallel ranges contains every document once", func() {
+			md := simpleMongoDumpInstance()
+			md.ToolOptions.Namespace.DB = testDB
+			md.ToolOptions.Namespace.Collection = "chunks"
+			md.OutputOptions.Out = dumpDir
+			md.OutputOptions.NumParallelChunks = 4
+			So(md.Init(), ShouldBeNil)
+			So(md.Dump(), ShouldBeNil)
+
+			file, err := os.Open(util.ToUniversalPath(filepath.Join(dumpDir, testDB, "chunks.bson")))
+			So(err, ShouldBeNil)
+			defer file.Close()
+			source := db.NewDecodedBSONSource(db.NewBSONSource(file))
+			defer source.Close()
+
+			seen := map[int32]bool{}
+			var result struct {
+				N int32 `bson:"n"`
+			}
+			for source.Next(&result) {
+				So(seen[result.N], ShouldBeFalse)
+				seen[result.N] = true
+			}
+			So(source.Err(), ShouldBeNil)
+			So(len(seen), ShouldEqual, len(docs))
+		})
+
+		Reset(func() {
+			So(os.RemoveAll(dumpDir), ShouldBeNil)
+			So(coll.Drop(nil), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,293 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+const (
+	// maxDocumentSize is the largest document a server returns: the
+	// largest stored document, with room for what the server adds to it
+	maxDocumentSize = 16*1024*1024 + 16*1024
+	// maxNestingDepth is the most levels of nesting a document may have
+	maxNestingDepth = 100
+)
+
+// errSkipDocument is returned by a documentValidator to leave a document
+// out of the dump without failing it.
+var errSkipDocument = errors.New("skip document")
+
+// checkDocument returns why doc isn't a valid document, or nil if it is.
+func checkDocument(doc []byte) error {
+	if len(doc) > maxDocumentSize {
+		return fmt.Errorf("document is %v bytes, larger than the maximum of %v", len(doc), maxDocumentSize)
+	}
+	return checkDocumentAt(doc, 1)
+}
+
+// checkDocumentAt checks a document nested at the given depth, the top
+// level being 1.
+func checkDocumentAt(doc []byte, depth int) error {
+	if depth > maxNestingDepth {
+		return fmt.Errorf("document is nested more than %v levels deep", maxNestingDepth)
+	}
+	if len(doc) < 5 {
+		return fmt.Errorf("document is %v bytes, too short to be a document", len(doc))
+	}
+	if length := int32(binary.LittleEndian.Uint32(doc)); int(length) != len(doc) {
+		return fmt.Errorf("document length %v does not match its %v bytes", length, len(doc))
+	}
+	if doc[len(doc)-1] != 0 {
+		return fmt.Errorf("document is not terminated")
+	}
+	rest := doc[4 : len(doc)-1]
+	for len(rest) > 0 {
+		t := bsontype.Type(rest[0])
+		end := bytes.IndexByte(rest[1:], 0)
+		if end < 0 {
+			return fmt.Errorf("field name is not terminated")
+		}
+		key := rest[1 : end+1]
+		if !utf8.Valid(key) {
+			return fmt.Errorf("field name %q is not valid UTF-8", key)
+		}
+		rest = rest[end+2:]
+		if !validLength(rest, t) {
+			return fmt.Errorf("field '%s' has an invalid length", key)
+		}
+		value, remaining, ok := bsoncore.ReadValue(rest, t)
+		if !ok {
+			return fmt.Errorf("field '%s' has an invalid type %v or is truncated", key, byte(t))
+		}
+		if err := checkValue(value, depth); err != nil {
+			return fmt.Errorf("field '%s': %v", key, err)
+		}
+		rest = remaining
+	}
+	return nil
+}
+
+// validLength returns whether a value of a type that starts with its
+// length has one that is large enough to hold the value, since the driver
+// doesn't check that it isn't negative.
+func validLength(data []byte, t bsontype.Type) bool {
+	var min int32
+	switch t {
+	case bsontype.String, bsontype.JavaScript, bsontype.Symbol, bsontype.DBPointer:
+		min = 1
+	case bsontype.EmbeddedDocument, bsontype.Array:
+		min = 5
+	case bsontype.CodeWithScope:
+		min = 14
+	case bsontype.Binary:
+		min = 0
+	default:
+		return true
+	}
+	length, _, ok := bsoncore.ReadLength(data)
+	return !ok || length >= min
+}
+
+// checkValue checks the strings of a value and the documents nested in it.
+func checkValue(value bsoncore.Value, depth int) error {
+	var strs []string
+	ok := true
+	switch value.Type {
+	case bsontype.String:
+		var s string
+		s, ok = value.StringValueOK()
+		strs = append(strs, s)
+	case bsontype.JavaScript:
+		var s string
+		s, ok = value.JavaScriptOK()
+		strs = append(strs, s)
+	case bsontype.Symbol:
+		var s string
+		s, ok = value.SymbolOK()
+		strs = append(strs, s)
+	case bsontype.Regex:
+		var pattern, options string
+		pattern, options, ok = value.RegexOK()
+		strs = append(strs, pattern, options)
+	case bsontype.DBPointer:
+		var ns string
+		ns, _, ok = value.DBPointerOK()
+		strs = append(strs, ns)
+	case bsontype.Binary:
+		_, _, ok = value.BinaryOK()
+	case bsontype.Boolean:
+		ok = value.Data[0] <= 1
+	case bsontype.EmbeddedDocument, bsontype.Array:
+		return checkDocumentAt(value.Data, depth+1)
+	case bsontype.CodeWithScope:
+		var code string
+		var scope bsoncore.Document
+		if code, scope, ok = value.CodeWithScopeOK(); ok {
+			if !utf8.ValidString(code) {
+				return fmt.Errorf("string is not valid UTF-8")
+			}
+			return checkDocumentAt(scope, depth+1)
+		}
+	}
+	if !ok {
+		return fmt.Errorf("malformed %v", value.Type)
+	}
+	for _, s := range strs {
+		if !utf8.ValidString(s) {
+			return fmt.Errorf("string is not valid UTF-8")
+		}
+	}
+	return nil
+}
+
+// corruptDocument is a line of the --corruptDocumentsFile.
+type corruptDocument struct {
+	Namespace string `json:"ns"`
+	// Position is the document's place in the order it was read, from 1
+	Position int64           `json:"position"`
+	ID       json.RawMessage `json:"_id,omitempty"`
+	Problem  string          `json:"problem"`
+	Size     int             `json:"size"`
+	Data     []byte          `json:"data"`
+}
+
+// corruptionReport checks each document dumped with --validateDocuments,
+// and records the corrupt ones in the --corruptDocumentsFile, which is only
+// created once one is found. Its methods do nothing on a nil
+// *corruptionReport.
+type corruptionReport struct {
+	path string
+	skip bool
+
+	checked int64
+	mutex   sync.Mutex
+	file    *os.File
+	corrupt map[string]int64
+}
+
+// corruptDocumentsPath returns where corrupt documents are recorded, which
+// defaults to a file next to the output directory or archive.
+func (dump *MongoDump) corruptDocumentsPath() string {
+	switch archive := dump.OutputOptions.Archive; {
+	case dump.OutputOptions.CorruptDocumentsFile != "":
+		return dump.OutputOptions.CorruptDocumentsFile
+	case archive != "" && archive != "-" && !storage.IsRemote(archive):
+		return archive + ".corrupt.jsonl"
+	case archive == "" && !dump.collectionToStdout() && !storage.IsRemote(dump.OutputOptions.Out):
+		return filepath.Clean(dump.outputRoot()) + ".corrupt.jsonl"
+	}
+	return "mongodump.corrupt.jsonl"
+}
+
+// validator returns a validator that checks each document of an intent
+// before next, which may be nil.
+func (r *corruptionReport) validator(intent *intents.Intent, next documentValidator) documentValidator {
+	if r == nil {
+		return next
+	}
+	var position int64
+	return func(doc []byte) error {
+		atomic.AddInt64(&r.checked, 1)
+		n := atomic.AddInt64(&position, 1)
+		if problem := checkDocument(doc); problem != nil {
+			if err := r.record(intent.Namespace(), n, doc, problem); err != nil {
+				return err
+			}
+			if r.skip {
+				log.Logvf(log.Always, "skipping corrupt document %v of %v: %v", n, intent.Namespace(), problem)
+				return errSkipDocument
+			}
+			return util.WithErrorCode(util.ErrCodeDumpData, fmt.Errorf(
+				"corrupt document %v of %v: %v; it was recorded in %v", n, intent.Namespace(), problem, r.path))
+		}
+		if next != nil {
+			return next(doc)
+		}
+		return nil
+	}
+}
+
+// record writes a corrupt document to the report file.
+func (r *corruptionReport) record(namespace string, position int64, doc []byte, problem error) error {
+	line := corruptDocument{Namespace: namespace, Position: position, Problem: problem.Error(), Size: len(doc), Data: doc}
+	if id, err := bsoncore.Document(doc).LookupErr("_id"); err == nil {
+		if idJSON, err := bson.MarshalExtJSON(bson.D{{"_id", bson.RawValue{Type: id.Type, Value: id.Data}}}, false, false); err == nil {
+			// the _id is recorded without the document around it
+			var wrapped map[string]json.RawMessage
+			if json.Unmarshal(idJSON, &wrapped) == nil {
+				line.ID = wrapped["_id"]
+			}
+		}
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file == nil {
+		if r.file, err = os.Create(r.path); err != nil {
+			return fmt.Errorf("error creating --corruptDocumentsFile: %v", err)
+		}
+	}
+	if _, err = r.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing to %v: %v", r.path, err)
+	}
+	if r.corrupt == nil {
+		r.corrupt = map[string]int64{}
+	}
+	r.corrupt[namespace]++
+	return nil
+}
+
+// finish closes the report file and logs how many documents were checked
+// and which collections hold corrupt ones.
+func (r *corruptionReport) finish() error {
+	if r == nil {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	checked := atomic.LoadInt64(&r.checked)
+	if r.file == nil {
+		log.Logvf(log.Always, "validated %v %v: no corrupt documents found", checked, docPlural(checked))
+		return nil
+	}
+	var namespaces []string
+	var total int64
+	for namespace, count := range r.corrupt {
+		namespaces = append(namespaces, fmt.Sprintf("%v (%v)", namespace, count))
+		total += count
+	}
+	sort.Strings(namespaces)
+	log.Logvf(log.Always, "validated %v %v: found %v corrupt %v, recorded in %v, in %v",
+		checked, docPlural(checked), total, docPlural(total), r.path, strings.Join(namespaces, ", "))
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,184 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// nestedDocument returns a document nested to the given depth.
+func nestedDocument(depth int) bson.D {
+	doc := bson.D{{"x", int32(1)}}
+	for i := 1; i < depth; i++ {
+		doc = bson.D{{"x", doc}}
+	}
+	return doc
+}
+
+func TestCheckDocument(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	valid, err := bson.Marshal(bson.D{
+		{"_id", int32(1)},
+		{"name", "café"},
+		{"tags", bson.A{"a", bson.D{{"b", true}}}},
+		{"re", bson.D{{"$regularExpression", bson.D{{"pattern", "^a"}, {"options", "i"}}}}},
+		{"bin", []byte{1, 2, 3}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Valid documents pass", t, func() {
+		So(checkDocument(valid), ShouldBeNil)
+		deep, err := bson.Marshal(nestedDocument(maxNestingDepth))
+		So(err, ShouldBeNil)
+		So(checkDocument(deep), ShouldBeNil)
+	})
+
+	Convey("Documents with a wrong length or terminator are corrupt", t, func() {
+		So(checkDocument(valid[:len(valid)-1]), ShouldNotBeNil)
+		So(checkDocument(valid[:3]), ShouldNotBeNil)
+		unterminated := append([]byte{}, valid...)
+		unterminated[len(unterminated)-1] = 1
+		So(checkDocument(unterminated), ShouldNotBeNil)
+	})
+
+	Convey("Invalid UTF-8 in field names and strings is corrupt", t, func() {
+		i := bytes.Index(valid, []byte("caf"))
+		badString := append([]byte{}, valid...)
+		badString[i+3] = 0xff
+		So(checkDocument(badString), ShouldNotBeNil)
+
+		i = bytes.Index(valid, []byte("name"))
+		badKey := append([]byte{}, valid...)
+		badKey[i] = 0xc3
+		So(checkDocument(badKey), ShouldNotBeNil)
+	})
+
+	Convey("Invalid types and values are corrupt", t, func() {
+		doc, err := bson.Marshal(bson.D{{"flag", true}})
+		So(err, ShouldBeNil)
+		badBool := append([]byte{}, doc...)
+		badBool[len(badBool)-2] = 2
+		So(checkDocument(badBool), ShouldNotBeNil)
+
+		badType := append([]byte{}, doc...)
+		badType[4] = 0x77
+		So(checkDocument(badType), ShouldNotBeNil)
+
+		str, err := bson.Marshal(bson.D{{"s", "abc"}})
+		So(err, ShouldBeNil)
+		negative := append([]byte{}, str...)
+		copy(negative[7:], []byte{0xff, 0xff, 0xff, 0xff})
+		So(checkDocument(negative), ShouldNotBeNil)
+	})
+
+	Convey("Documents nested too deeply are corrupt", t, func() {
+		deep, err := bson.Marshal(nestedDocument(maxNestingDepth + 1))
+		So(err, ShouldBeNil)
+		So(checkDocument(deep), ShouldNotBeNil)
+	})
+}
+
+func TestCorruptionReport(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a collection holding a corrupt document", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump_corrupt")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		var docs []bson.Raw
+		for i := 0; i < 3; i++ {
+			doc, err := bson.Marshal(bson.D{{"_id", int32(i)}, {"s", "abc"}})
+			So(err, ShouldBeNil)
+			docs = append(docs, doc)
+		}
+		docs[1][len(docs[1])-3] = 0xff
+		intent := &intents.Intent{DB: "test", C: "c"}
+		md := simpleMongoDumpInstance()
+		md.shutdownIntentsNotifier = newNotifier()
+		report := &corruptionReport{path: filepath.Join(dir, "dump.corrupt.jsonl")}
+
+		Convey("the dump fails at it, and records it", func() {
+			var out bytes.Buffer
+			err := md.dumpValidatedItersToWriter([]documentCursor{&batchCursor{docs: docs, batch: 10}},
+				&out, progress.NewCounter(0), report.validator(intent, nil))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "corrupt document 2 of test.c")
+			So(report.finish(), ShouldBeNil)
+
+			data, err := ioutil.ReadFile(report.path)
+			So(err, ShouldBeNil)
+			var line corruptDocument
+			So(json.Unmarshal(bytes.TrimSpace(data), &line), ShouldBeNil)
+			So(line.Namespace, ShouldEqual, "test.c")
+			So(line.Position, ShouldEqual, 2)
+			So(string(line.ID), ShouldEqual, "1")
+			So(line.Data, ShouldResemble, []byte(docs[1]))
+		})
+
+		Convey("with --skipCorruptDocuments it is left out", func() {
+			report.skip = true
+			var out bytes.Buffer
+			counter := progress.NewCounter(0)
+			err := md.dumpValidatedItersToWriter([]documentCursor{&batchCursor{docs: docs, batch: 10}},
+				&out, counter, report.validator(intent, nil))
+			So(err, ShouldBeNil)
+			written, _ := counter.Progress()
+			So(written, ShouldEqual, 2)
+			So(out.Bytes(), ShouldResemble, append(append([]byte{}, docs[0]...), docs[2]...))
+			So(report.checked, ShouldEqual, 3)
+			So(report.finish(), ShouldBeNil)
+
+			file, err := os.Open(report.path)
+			So(err, ShouldBeNil)
+			defer file.Close()
+			lines := 0
+			for scanner := bufio.NewScanner(file); scanner.Scan(); {
+				lines++
+			}
+			So(lines, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Without corrupt documents no report file is written", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump_corrupt")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		report := &corruptionReport{path: filepath.Join(dir, "dump.corrupt.jsonl")}
+		doc, err := bson.Marshal(bson.D{{"_id", int32(1)}})
+		So(err, ShouldBeNil)
+		So(report.validator(&intents.Intent{DB: "test", C: "c"}, nil)(doc), ShouldBeNil)
+		So(report.finish(), ShouldBeNil)
+		_, err = os.Stat(report.path)
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("The report defaults to a file next to the output", t, func() {
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.Out = "backup/"
+		So(md.corruptDocumentsPath(), ShouldEqual, "backup.corrupt.jsonl")
+		md.OutputOptions.Archive = "dump.archive"
+		So(md.corruptDocumentsPath(), ShouldEqual, "dump.archive.corrupt.jsonl")
+		md.OutputOptions.CorruptDocumentsFile = "corrupt.jsonl"
+		So(md.corruptDocumentsPath(), ShouldEqual, "corrupt.jsonl")
+	})
+}
@@ -0,0 +1,364 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// daemonRunLayout names the directory of each dump with --daemon by the
+// time it was scheduled at, so that the names sort by time.
+const daemonRunLayout = "20060102T150405Z"
+
+// daemonRun is the status of one scheduled dump.
+type daemonRun struct {
+	Location string     `json:"location"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Seconds  float64    `json:"seconds,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// daemonStatus is the state of the daemon served by --statusAddr.
+type daemonStatus struct {
+	State       string     `json:"state"`
+	Schedule    string     `json:"schedule"`
+	NextDump    *time.Time `json:"nextDump,omitempty"`
+	Current     *daemonRun `json:"current,omitempty"`
+	Last        *daemonRun `json:"last,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	Dumps       int        `json:"dumps"`
+	Failures    int        `json:"failures"`
+	// Kept are the dumps under --out after retention was last enforced
+	Kept []string `json:"kept,omitempty"`
+}
+
+// dumpDaemon runs the dumps of --daemon on its schedule.
+type dumpDaemon struct {
+	dump     *MongoDump
+	schedule *cronSchedule
+	// storage is the output when --out is an object storage URL
+	storage storage.Backend
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mutex   sync.Mutex
+	status  daemonStatus
+	running *MongoDump
+}
+
+// daemonEnabled returns whether mongodump runs as a daemon.
+func (dump *MongoDump) daemonEnabled() bool {
+	return dump.DaemonOptions != nil && dump.DaemonOptions.Daemon
+}
+
+// initDaemon parses the schedule and opens the output for retention.
+// Nothing connects to the server until the first dump.
+func (dump *MongoDump) initDaemon() error {
+	schedule, err := parseCronSchedule(dump.DaemonOptions.Schedule)
+	if err != nil {
+		return util.WithErrorCode(util.ErrCodeBadOptions, fmt.Errorf("error parsing --schedule: %v", err))
+	}
+	if schedule.next(time.Now()).IsZero() {
+		return util.WithErrorCode(util.ErrCodeBadOptions,
+			fmt.Errorf("--schedule '%v' never matches", dump.DaemonOptions.Schedule))
+	}
+	d := &dumpDaemon{
+		dump:     dump,
+		schedule: schedule,
+		stop:     make(chan struct{}),
+		status:   daemonStatus{State: "waiting", Schedule: dump.DaemonOptions.Schedule},
+	}
+	if storage.IsRemote(dump.OutputOptions.Out) {
+		if d.storage, err = storage.Open(dump.OutputOptions.Out); err != nil {
+			return util.WithErrorCode(util.ErrCodeBadOptions, err)
+		}
+		if _, ok := d.storage.(storage.Pruner); !ok && dump.DaemonOptions.Retention > 0 {
+			return util.WithErrorCode(util.ErrCodeBadOptions,
+				fmt.Errorf("--retention is not supported for %v", dump.OutputOptions.Out))
+		}
+	}
+	dump.daemon = d
+	return nil
+}
+
+// RunDaemon dumps on the --schedule until interrupted, each time to a new
+// directory under --out, and enforces --retention after each dump. A dump
+// that fails is logged, and doesn't stop the daemon.
+func (dump *MongoDump) RunDaemon() error {
+	d := dump.daemon
+	if dump.DaemonOptions.StatusAddr != "" {
+		listener, err := net.Listen("tcp", dump.DaemonOptions.StatusAddr)
+		if err != nil {
+			return fmt.Errorf("error listening on --statusAddr: %v", err)
+		}
+		defer listener.Close()
+		go http.Serve(listener, d.statusHandler())
+		log.Logvf(log.Always, "serving the daemon status at http://%v/status", listener.Addr())
+	}
+
+	for {
+		next := d.schedule.next(time.Now())
+		d.update(func(status *daemonStatus) { status.NextDump = &next })
+		log.Logvf(log.Always, "next dump at %v", next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-d.stop:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		d.runDump(next)
+		if err := d.enforceRetention(); err != nil {
+			log.Logvf(log.Always, "error enforcing --retention: %v", err)
+		}
+		select {
+		case <-d.stop:
+			return nil
+		default:
+		}
+	}
+}
+
+// interrupt stops the daemon, interrupting the dump that is running.
+func (d *dumpDaemon) interrupt() {
+	d.stopOnce.Do(func() { close(d.stop) })
+	d.mutex.Lock()
+	running := d.running
+	d.mutex.Unlock()
+	if running != nil {
+		running.HandleInterrupt()
+	}
+}
+
+func (d *dumpDaemon) update(change func(status *daemonStatus)) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	change(&d.status)
+}
+
+// runLocation returns where the dump scheduled at the given time is written.
+func (d *dumpDaemon) runLocation(name string) string {
+	if d.storage != nil {
+		return strings.TrimSuffix(d.dump.OutputOptions.Out, "/") + "/" + name
+	}
+	return filepath.Join(d.dump.outputRoot(), name)
+}
+
+// runDump runs the dump scheduled at the given time, with the daemon's
+// options and its own output directory.
+func (d *dumpDaemon) runDump(scheduled time.Time) {
+	location := d.runLocation(scheduled.UTC().Format(daemonRunLayout))
+	inputOptions := *d.dump.InputOptions
+	outputOptions := *d.dump.OutputOptions
+	outputOptions.Out = location
+	run := &MongoDump{
+		ToolOptions:       d.dump.ToolOptions,
+		InputOptions:      &inputOptions,
+		OutputOptions:     &outputOptions,
+		ProgressManager:   d.dump.ProgressManager,
+		OutputWriter:      d.dump.OutputWriter,
+		SkipUsersAndRoles: d.dump.SkipUsersAndRoles,
+	}
+	status := &daemonRun{Location: location, Started: time.Now().UTC()}
+	d.mutex.Lock()
+	d.running = run
+	d.status.State, d.status.Current, d.status.NextDump = "dumping", status, nil
+	d.mutex.Unlock()
+
+	log.Logvf(log.Always, "starting the scheduled dump to %v", location)
+	err := run.Init()
+	if err == nil {
+		err = run.Dump()
+	} else if run.SessionProvider != nil {
+		run.SessionProvider.Close()
+	}
+
+	finished := time.Now().UTC()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.running = nil
+	status.Finished, status.Seconds = &finished, finished.Sub(status.Started).Seconds()
+	d.status.State, d.status.Current, d.status.Last = "waiting", nil, status
+	d.status.Dumps++
+	if err != nil {
+		status.Error = err.Error()
+		d.status.Failures++
+		log.Logvf(log.Always, "the scheduled dump to %v failed: %v", location, err)
+		return
+	}
+	d.status.LastSuccess = &finished
+	log.Logvf(log.Always, "finished the scheduled dump to %v in %v", location, finished.Sub(status.Started).Round(time.Second))
+}
+
+// statusHandler serves the status as JSON at /status, and at /healthz
+// responds with 503 if the last dump failed.
+func (d *dumpDaemon) statusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, _ *http.Request) {
+		d.mutex.Lock()
+		data, err := json.MarshalIndent(d.status, "", "  ")
+		d.mutex.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append(data, '\n'))
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		d.mutex.Lock()
+		last := d.status.Last
+		d.mutex.Unlock()
+		if last != nil && last.Error != "" {
+			http.Error(w, "the last dump failed: "+last.Error, http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// dumpRun is a dump found under --out, and whether it completed, which is
+// when its manifest was written.
+type dumpRun struct {
+	name     string
+	complete bool
+}
+
+// expiredRuns returns the dumps to remove to keep the given number of the
+// most recent complete dumps. Incomplete dumps newer than the oldest dump
+// kept are left for inspection.
+func expiredRuns(runs []dumpRun, keep int) []string {
+	sort.Slice(runs, func(i, j int) bool { return runs[i].name > runs[j].name })
+	var expired []string
+	complete := 0
+	for _, run := range runs {
+		if complete >= keep {
+			expired = append(expired, run.name)
+		} else if run.complete {
+			complete++
+		}
+	}
+	return expired
+}
+
+// isRunName returns whether name is the name of a dump of the daemon.
+func isRunName(name string) bool {
+	_, err := time.Parse(daemonRunLayout, name)
+	return err == nil
+}
+
+// listRuns returns the dumps of the daemon under --out.
+func (d *dumpDaemon) listRuns() ([]dumpRun, error) {
+	var runs []dumpRun
+	if d.storage != nil {
+		names, err := d.storage.(storage.Pruner).List("")
+		if err != nil {
+			return nil, err
+		}
+		found := map[string]bool{}
+		for _, name := range names {
+			parts := strings.SplitN(name, "/", 2)
+			if len(parts) != 2 || !isRunName(parts[0]) {
+				continue
+			}
+			found[parts[0]] = found[parts[0]] || parts[1] == archive.ManifestFile
+		}
+		for name, complete := range found {
+			runs = append(runs, dumpRun{name, complete})
+		}
+		return runs, nil
+	}
+
+	entries, err := ioutil.ReadDir(d.dump.outputRoot())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !isRunName(entry.Name()) {
+			continue
+		}
+		manifestPath := filepath.Join(d.dump.outputRoot(), entry.Name(), archive.ManifestFile)
+		_, err := os.Stat(manifestPath)
+		complete := err == nil
+		// an interrupted dump also has a manifest
+		if m, err := readManifest(manifestPath); complete && err == nil && m.Interrupted {
+			complete = false
+		}
+		runs = append(runs, dumpRun{entry.Name(), complete})
+	}
+	return runs, nil
+}
+
+// removeRun removes a dump of the daemon.
+func (d *dumpDaemon) removeRun(name string) error {
+	if d.storage == nil {
+		return os.RemoveAll(filepath.Join(d.dump.outputRoot(), name))
+	}
+	pruner := d.storage.(storage.Pruner)
+	names, err := pruner.List(name)
+	if err != nil {
+		return err
+	}
+	for _, object := range names {
+		if strings.HasPrefix(object, name+"/") {
+			if err = pruner.Delete(object); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// enforceRetention removes the dumps older than the --retention most recent
+// complete ones.
+func (d *dumpDaemon) enforceRetention() error {
+	if d.dump.DaemonOptions.Retention == 0 {
+		return nil
+	}
+	runs, err := d.listRuns()
+	if err != nil {
+		return err
+	}
+	expired := expiredRuns(runs, d.dump.DaemonOptions.Retention)
+	for _, name := range expired {
+		log.Logvf(log.Always, "removing %v, which is older than the %v most recent dumps",
+			d.runLocation(name), d.dump.DaemonOptions.Retention)
+		if err = d.removeRun(name); err != nil {
+			return fmt.Errorf("error removing %v: %v", d.runLocation(name), err)
+		}
+	}
+	var kept []string
+	for _, run := range runs {
+		if !util.StringSliceContains(expired, run.name) {
+			kept = append(kept, run.name)
+		}
+	}
+	d.update(func(status *daemonStatus) { status.Kept = kept })
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDaemonRetention(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("The most recent complete dumps are kept", t, func() {
+		runs := []dumpRun{
+			{"20260301T020000Z", true},
+			{"20260304T020000Z", false},
+			{"20260303T020000Z", true},
+			{"20260302T020000Z", false},
+			{"20260228T020000Z", true},
+			{"20260305T020000Z", true},
+		}
+		So(expiredRuns(runs, 2), ShouldResemble, []string{"20260302T020000Z", "20260301T020000Z", "20260228T020000Z"})
+		So(expiredRuns(runs, 10), ShouldBeNil)
+	})
+
+	Convey("With dumps of the daemon in a local directory", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump_daemon")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		for _, name := range []string{"20260301T020000Z", "20260302T020000Z", "20260303T020000Z", "20260304T020000Z"} {
+			So(os.MkdirAll(filepath.Join(dir, name, "test"), 0755), ShouldBeNil)
+			if name != "20260304T020000Z" {
+				So(ioutil.WriteFile(filepath.Join(dir, name, archive.ManifestFile), []byte("{}"), 0644), ShouldBeNil)
+			}
+		}
+		// other files under --out are left alone
+		So(os.MkdirAll(filepath.Join(dir, "manual"), 0755), ShouldBeNil)
+
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.Out = dir
+		md.DaemonOptions = &DaemonOptions{Daemon: true, Schedule: "0 2 * * *", Retention: 2}
+		So(md.initDaemon(), ShouldBeNil)
+
+		So(md.daemon.enforceRetention(), ShouldBeNil)
+		entries, err := ioutil.ReadDir(dir)
+		So(err, ShouldBeNil)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		So(names, ShouldResemble, []string{"20260302T020000Z", "20260303T020000Z", "20260304T020000Z", "manual"})
+		So(md.daemon.status.Kept, ShouldResemble, []string{"20260304T020000Z", "20260303T020000Z", "20260302T020000Z"})
+		So(md.daemon.runLocation("20260305T020000Z"), ShouldEqual, filepath.Join(dir, "20260305T020000Z"))
+	})
+}
+
+func TestDaemonStatus(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("The status endpoint reports the dumps of the daemon", t, func() {
+		md := simpleMongoDumpInstance()
+		md.DaemonOptions = &DaemonOptions{Daemon: true, Schedule: "@hourly"}
+		So(md.initDaemon(), ShouldBeNil)
+		server := httptest.NewServer(md.daemon.statusHandler())
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/healthz")
+		So(err, ShouldBeNil)
+		resp.Body.Close()
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+		md.daemon.update(func(status *daemonStatus) {
+			status.Dumps, status.Failures = 1, 1
+			status.Last = &daemonRun{Location: "dump/20260304T020000Z", Started: time.Now(), Error: "connection refused"}
+		})
+		resp, err = http.Get(server.URL + "/healthz")
+		So(err, ShouldBeNil)
+		resp.Body.Close()
+		So(resp.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+
+		resp, err = http.Get(server.URL + "/status")
+		So(err, ShouldBeNil)
+		defer resp.Body.Close()
+		var status daemonStatus
+		So(json.NewDecoder(resp.Body).Decode(&status), ShouldBeNil)
+		So(status.State, ShouldEqual, "waiting")
+		So(status.Schedule, ShouldEqual, "@hourly")
+		So(status.Failures, ShouldEqual, 1)
+		So(status.Last.Error, ShouldEqual, "connection refused")
+	})
+}
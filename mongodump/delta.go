@@ -0,0 +1,69 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mongodb/mongo-tools/common/delta"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/text"
+)
+
+// deltaWriter is the archive written as a delta from --deltaBase.
+type deltaWriter struct {
+	*delta.Writer
+	out  io.WriteCloser
+	base string
+}
+
+// Close ends the delta and closes the output, logging how much of the
+// archive was found in the base.
+func (w *deltaWriter) Close() error {
+	err := w.Writer.Close()
+	if closeErr := w.out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		log.Logvf(log.Always, "wrote the archive as a delta from %v: %v found in it, %v written",
+			w.base, text.FormatByteAmount(w.Copied), text.FormatByteAmount(w.Literal))
+	}
+	return err
+}
+
+// deltaArchiveOut returns a writer of the archive to out as a delta from
+// --deltaBase, or out if it isn't given. The base is read first to find its
+// blocks in the archive.
+func (dump *MongoDump) deltaArchiveOut(out io.WriteCloser) (io.WriteCloser, error) {
+	if dump.OutputOptions.DeltaBase == "" {
+		return out, nil
+	}
+	base, err := os.Open(dump.OutputOptions.DeltaBase)
+	if err != nil {
+		out.Close()
+		return nil, fmt.Errorf("error opening --deltaBase: %v", err)
+	}
+	defer base.Close()
+	sig, err := delta.NewSignature(base, delta.DefaultBlockSize)
+	if err != nil {
+		out.Close()
+		return nil, fmt.Errorf("error reading --deltaBase: %v", err)
+	}
+	log.Logvf(log.DebugLow, "read %v blocks of %v", sig.Blocks(), dump.OutputOptions.DeltaBase)
+	if dump.OutputOptions.NumParallelCollections > 1 {
+		log.Logvf(log.Info, "collections dumped in parallel are interleaved differently in each archive; "+
+			"dumping with -j 1 makes a delta from the base smaller")
+	}
+	writer, err := delta.NewWriter(out, sig)
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	return &deltaWriter{Writer: writer, out: out, base: dump.OutputOptions.DeltaBase}, nil
+}
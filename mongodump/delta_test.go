@@ -0,0 +1,89 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/delta"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeltaArchive(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a base archive and a new archive that changes a little of it", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump_delta")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		random := rand.New(rand.NewSource(1))
+		base := make([]byte, 40*delta.DefaultBlockSize+123)
+		random.Read(base)
+		basePath := filepath.Join(dir, "base.archive")
+		So(ioutil.WriteFile(basePath, base, 0644), ShouldBeNil)
+
+		// insert data near the start, change a byte in the middle and
+		// append to the end, so that blocks move
+		var archive []byte
+		archive = append(archive, base[:1000]...)
+		archive = append(archive, []byte("inserted documents")...)
+		archive = append(archive, base[1000:]...)
+		archive[len(archive)/2] ^= 0xff
+		archive = append(archive, []byte("appended collection")...)
+
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.DeltaBase = basePath
+		var out bytes.Buffer
+		writer, err := md.deltaArchiveOut(&nopCloseWriter{&out})
+		So(err, ShouldBeNil)
+		for i := 0; i < len(archive); i += 4000 {
+			end := i + 4000
+			if end > len(archive) {
+				end = len(archive)
+			}
+			_, err = writer.Write(archive[i:end])
+			So(err, ShouldBeNil)
+		}
+		So(writer.Close(), ShouldBeNil)
+
+		Convey("the delta is much smaller and reconstructs the archive", func() {
+			So(out.Len(), ShouldBeLessThan, 4*delta.DefaultBlockSize)
+			So(writer.(*deltaWriter).Copied, ShouldEqual, 38*delta.DefaultBlockSize)
+
+			reader, err := delta.NewReader(&out, bytes.NewReader(base), int64(len(base)))
+			So(err, ShouldBeNil)
+			reconstructed, err := ioutil.ReadAll(reader)
+			So(err, ShouldBeNil)
+			So(reconstructed, ShouldResemble, archive)
+		})
+
+		Convey("a different base is rejected", func() {
+			other := append([]byte{}, base...)
+			other[0] ^= 0xff
+			_, err := delta.NewReader(&out, bytes.NewReader(other), int64(len(other)))
+			So(err, ShouldNotBeNil)
+			_, err = delta.NewReader(bytes.NewReader(out.Bytes()), bytes.NewReader(base[1:]), int64(len(base)-1))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a corrupted delta fails to reconstruct", func() {
+			corrupted := out.Bytes()
+			corrupted[len(corrupted)-1] ^= 0xff
+			reader, err := delta.NewReader(bytes.NewReader(corrupted), bytes.NewReader(base), int64(len(base)))
+			So(err, ShouldBeNil)
+			_, err = ioutil.ReadAll(reader)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,251 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/text"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// A dry run reads the largest collection for at most dryRunSampleTime or
+// dryRunSampleBytes to measure the rate the runtime is projected from.
+const (
+	dryRunSampleTime  = 2 * time.Second
+	dryRunSampleBytes = 64 * 1024 * 1024
+)
+
+// codeCommandNotSupportedOnView is returned by collStats for a view.
+const codeCommandNotSupportedOnView = 166
+
+// namespaceEstimate is what a dry run expects to dump from a namespace.
+type namespaceEstimate struct {
+	namespace string
+	// data is false for namespaces that only have metadata dumped
+	data      bool
+	documents int64
+	// size is the uncompressed size of the documents, and storageSize how
+	// much they take on disk with the server's compression
+	size        int64
+	storageSize int64
+	// unknown is set for views dumped as collections, whose size can only
+	// be known by running them
+	unknown bool
+}
+
+// outputSize returns the estimated size of a namespace's output. Compressed
+// output is expected to compress as well as the server's storage does.
+func (e *namespaceEstimate) outputSize(codec compression.Codec) int64 {
+	if !codec.IsNone() && e.storageSize > 0 && e.storageSize < e.size {
+		return e.storageSize
+	}
+	return e.size
+}
+
+// DryRun reports the namespaces that would be dumped with their estimated
+// output sizes and a projected runtime, without writing any output.
+func (dump *MongoDump) DryRun() error {
+	if dump.OutputOptions.Archive != "" {
+		// the intents' files are never opened, so the archive needs no
+		// multiplexer
+		dump.archive = &archive.Writer{}
+	}
+	if err := dump.createIntents(); err != nil {
+		return err
+	}
+
+	session, err := dump.SessionProvider.GetSession()
+	if err != nil {
+		return fmt.Errorf("error getting a client session: %v", err)
+	}
+	var estimates []*namespaceEstimate
+	for _, intent := range dump.manager.Intents() {
+		estimate, err := dump.estimateIntent(session, intent)
+		if err != nil {
+			return err
+		}
+		estimates = append(estimates, estimate)
+	}
+	sort.Slice(estimates, func(i, j int) bool { return estimates[i].namespace < estimates[j].namespace })
+
+	var rate float64
+	if largest := largestEstimate(estimates); largest != nil {
+		if rate, err = dump.sampleReadRate(session, dump.manager.IntentForNamespace(largest.namespace)); err != nil {
+			return fmt.Errorf("error reading %v to measure the read rate: %v", largest.namespace, err)
+		}
+	}
+	dump.reportDryRun(estimates, rate)
+	return nil
+}
+
+// estimateIntent returns the estimated output of an intent from the
+// collection's stats.
+func (dump *MongoDump) estimateIntent(session *mongo.Client, intent *intents.Intent) (*namespaceEstimate, error) {
+	estimate := &namespaceEstimate{namespace: intent.Namespace()}
+	if intent.BSONFile == nil || dump.skipsData(intent) || (intent.IsView() && !dump.OutputOptions.ViewsAsCollections) {
+		return estimate, nil
+	}
+	estimate.data = true
+
+	var stats struct {
+		Count       float64 `bson:"count"`
+		Size        float64 `bson:"size"`
+		StorageSize float64 `bson:"storageSize"`
+	}
+	err := session.Database(intent.DB).RunCommand(context.Background(), bson.D{{"collStats", intent.C}}).Decode(&stats)
+	if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Code == codeCommandNotSupportedOnView {
+		estimate.unknown = true
+		return estimate, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting stats for %v: %v", intent.Namespace(), err)
+	}
+	estimate.documents = int64(stats.Count)
+	estimate.size = int64(stats.Size)
+	estimate.storageSize = int64(stats.StorageSize)
+	return estimate, nil
+}
+
+// largestEstimate returns the namespace with the most data, or nil if none
+// has any.
+func largestEstimate(estimates []*namespaceEstimate) *namespaceEstimate {
+	var largest *namespaceEstimate
+	for _, estimate := range estimates {
+		if estimate.size > 0 && (largest == nil || estimate.size > largest.size) {
+			largest = estimate
+		}
+	}
+	return largest
+}
+
+// sampleReadRate reads the documents of a collection for a short time and
+// returns the rate they were read at, in bytes per second.
+func (dump *MongoDump) sampleReadRate(session *mongo.Client, intent *intents.Intent) (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dryRunSampleTime)
+	defer cancel()
+
+	start := time.Now()
+	cursor, err := session.Database(intent.DB).Collection(intent.C).Find(ctx, bson.D{})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(context.Background())
+
+	var read int64
+	for read < dryRunSampleBytes && cursor.Next(ctx) {
+		read += int64(len(cursor.Current))
+	}
+	if err = cursor.Err(); err != nil && ctx.Err() == nil {
+		return 0, err
+	}
+	elapsed := time.Since(start).Seconds()
+	log.Logvf(log.DebugLow, "read %v of %v in %.1fs", text.FormatByteAmount(read), intent.Namespace(), elapsed)
+	if read == 0 || elapsed <= 0 {
+		return 0, nil
+	}
+	return float64(read) / elapsed, nil
+}
+
+// projectRuntime returns how long the estimated namespaces would take to
+// dump when read at rate bytes per second, with the given number of
+// collections at a time: as long as it takes to share all of the data
+// between them, but no less than the largest collection takes by itself,
+// and no faster than --rateLimit and --maxDocsPerSecond allow.
+func projectRuntime(estimates []*namespaceEstimate, rate float64, parallel int, options *OutputOptions) time.Duration {
+	var total, largest, documents int64
+	for _, estimate := range estimates {
+		total += estimate.size
+		documents += estimate.documents
+		if estimate.size > largest {
+			largest = estimate.size
+		}
+	}
+	if rate <= 0 || total == 0 {
+		return 0
+	}
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	seconds := float64(total) / (rate * float64(parallel))
+	if byItself := float64(largest) / rate; byItself > seconds {
+		seconds = byItself
+	}
+	if options.RateLimit > 0 {
+		if limited := float64(total) / (options.RateLimit * 1024 * 1024); limited > seconds {
+			seconds = limited
+		}
+	}
+	if options.MaxDocsPerSecond > 0 {
+		if limited := float64(documents) / float64(options.MaxDocsPerSecond); limited > seconds {
+			seconds = limited
+		}
+	}
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second)
+}
+
+// reportDryRun logs a table of the estimated namespaces and their totals.
+func (dump *MongoDump) reportDryRun(estimates []*namespaceEstimate, rate float64) {
+	grid := &text.GridWriter{ColumnPadding: 2}
+	grid.WriteCells("namespace", "documents", "data size", "estimated output")
+	grid.EndRow()
+
+	var namespaces, documents, size, output int64
+	var unknown int
+	for _, estimate := range estimates {
+		_, codec, _ := dump.collectionOutput(util.SplitNamespace(estimate.namespace))
+		switch {
+		case !estimate.data:
+			grid.WriteCells(estimate.namespace, "-", "-", "metadata only")
+		case estimate.unknown:
+			unknown++
+			grid.WriteCells(estimate.namespace, "?", "?", "unknown (view)")
+		default:
+			grid.WriteCells(estimate.namespace, fmt.Sprint(estimate.documents),
+				text.FormatByteAmount(estimate.size), text.FormatByteAmount(estimate.outputSize(codec)))
+		}
+		grid.EndRow()
+		namespaces++
+		documents += estimate.documents
+		size += estimate.size
+		output += estimate.outputSize(codec)
+	}
+	grid.FlushRows(log.Writer(0))
+
+	if dump.InputOptions.HasQuery() {
+		log.Logvf(log.Always, "dry run: sizes are of whole collections, before --query or --queryFile is applied")
+	}
+	if dump.InputOptions.HasPipeline() {
+		log.Logvf(log.Always, "dry run: sizes are of whole collections, before --pipeline or --pipelineFile is applied")
+	}
+	if dump.InputOptions.HasSample() {
+		log.Logvf(log.Always, "dry run: sizes are of whole collections, before --sample or --sampleDocs is applied")
+	}
+	if unknown > 0 {
+		log.Logvf(log.Always, "dry run: %v views dumped as collections are not included in the estimates", unknown)
+	}
+	if dump.OutputOptions.Oplog {
+		log.Logvf(log.Always, "dry run: the oplog written during the dump is not included in the estimates")
+	}
+	log.Logvf(log.Always, "dry run: %v namespaces, %v %v, %v of data, estimated output %v",
+		namespaces, documents, docPlural(documents), text.FormatByteAmount(size), text.FormatByteAmount(output))
+	if rate > 0 {
+		runtime := projectRuntime(estimates, rate, dump.OutputOptions.NumParallelCollections, dump.OutputOptions)
+		log.Logvf(log.Always, "dry run: read %v/s from the largest collection; projected runtime %v",
+			text.FormatByteAmount(int64(rate)), runtime)
+	}
+}
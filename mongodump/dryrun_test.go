@@ -0,0 +1,51 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDryRunEstimates(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With estimates of a few collections", t, func() {
+		estimates := []*namespaceEstimate{
+			{namespace: "db.a", data: true, documents: 100, size: 600, storageSize: 200},
+			{namespace: "db.b", data: true, documents: 300, size: 300, storageSize: 400},
+			{namespace: "db.v", data: true, unknown: true},
+			{namespace: "db.m"},
+		}
+
+		Convey("compressed output is expected to be the size on disk, when smaller", func() {
+			gzip := compression.Codec{Name: compression.GzipName}
+			So(estimates[0].outputSize(compression.None), ShouldEqual, 600)
+			So(estimates[0].outputSize(gzip), ShouldEqual, 200)
+			So(estimates[1].outputSize(gzip), ShouldEqual, 300)
+		})
+
+		Convey("the largest collection is the one with the most data", func() {
+			So(largestEstimate(estimates).namespace, ShouldEqual, "db.a")
+			So(largestEstimate(estimates[2:]), ShouldBeNil)
+		})
+
+		Convey("the runtime shares the data between parallel collections", func() {
+			options := &OutputOptions{}
+			So(projectRuntime(estimates, 100, 1, options), ShouldEqual, 9*time.Second)
+			// but takes no less than the largest collection by itself
+			So(projectRuntime(estimates, 100, 4, options), ShouldEqual, 6*time.Second)
+			options.MaxDocsPerSecond = 10
+			So(projectRuntime(estimates, 100, 4, options), ShouldEqual, 40*time.Second)
+			So(projectRuntime(estimates, 0, 4, options), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,83 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/encryption"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// initEncryption creates the data key the dump is encrypted with for
+// --encrypt.
+func (dump *MongoDump) initEncryption() error {
+	provider, err := encryption.NewKeyProvider(dump.OutputOptions.KeyFile, dump.OutputOptions.KMSProvider)
+	if err != nil {
+		return err
+	}
+	dump.dataKey, dump.keyInfo, err = provider.NewDataKey()
+	if err != nil {
+		return fmt.Errorf("error creating a data key: %v", err)
+	}
+	if codec := dump.outputCodec(); !codec.IsNone() {
+		dump.keyInfo.Compression = codec.Name
+	}
+	log.Logvf(log.Always, "encrypting the dump with a data key protected by %v key %v",
+		dump.keyInfo.Provider, dump.keyInfo.MasterKeyID)
+	return nil
+}
+
+// encryptArchive returns the writer for everything after the header of an
+// encrypted archive, which compresses and then encrypts to out. Closing it
+// doesn't close out.
+func (dump *MongoDump) encryptArchive(out io.Writer) (io.WriteCloser, error) {
+	encrypted, err := encryption.NewWriter(out, dump.dataKey)
+	if err != nil {
+		return nil, err
+	}
+	codec := dump.outputCodec()
+	if codec.IsNone() {
+		return encrypted, nil
+	}
+	compressed, err := codec.NewWriter(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	return &util.WrappedWriteCloser{WriteCloser: compressed, Inner: encrypted}, nil
+}
+
+// encryptTo returns a writer that encrypts to w with key, and closes w when
+// it is closed. It returns w itself when key is nil.
+func encryptTo(w io.WriteCloser, key []byte) (io.WriteCloser, error) {
+	if key == nil {
+		return w, nil
+	}
+	encrypted, err := encryption.NewWriter(w, key)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	return &util.WrappedWriteCloser{WriteCloser: encrypted, Inner: w}, nil
+}
+
+// writeEncryptionMetadata records the encrypted data key in the root of the
+// output directory, where mongorestore looks for it.
+func (dump *MongoDump) writeEncryptionMetadata() error {
+	data, err := json.Marshal(dump.keyInfo)
+	if err != nil {
+		return err
+	}
+	if err = dump.writeRootFile(archive.EncryptionMetadataFile, data); err != nil {
+		return fmt.Errorf("error writing encryption metadata: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/encryption"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEncryptedOutput(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	key := bytes.Repeat([]byte{7}, encryption.KeySize)
+	data := bytes.Repeat([]byte("collection data "), 10000)
+
+	Convey("BSON files written with a key can only be read with it", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump_encrypted")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "db", "c.bson")
+		file := &realBSONFile{path: path, intent: &intents.Intent{DB: "db", C: "c"}, key: key}
+		So(file.Open(), ShouldBeNil)
+		_, err = file.Write(data)
+		So(err, ShouldBeNil)
+		So(file.Close(), ShouldBeNil)
+
+		raw, err := ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		So(bytes.Contains(raw, []byte("collection data")), ShouldBeFalse)
+
+		decrypted, err := encryption.NewReader(bytes.NewReader(raw), key)
+		So(err, ShouldBeNil)
+		contents, err := ioutil.ReadAll(decrypted)
+		So(err, ShouldBeNil)
+		So(contents, ShouldResemble, data)
+
+		decrypted, err = encryption.NewReader(bytes.NewReader(raw), bytes.Repeat([]byte{8}, encryption.KeySize))
+		So(err, ShouldBeNil)
+		_, err = ioutil.ReadAll(decrypted)
+		So(err, ShouldNotBeNil)
+
+		decrypted, err = encryption.NewReader(bytes.NewReader(raw[:len(raw)-20]), key)
+		So(err, ShouldBeNil)
+		_, err = ioutil.ReadAll(decrypted)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "truncated")
+	})
+
+	Convey("An encrypted archive body is compressed before it is encrypted", t, func() {
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.Compress = "zstd"
+		md.dataKey = key
+
+		out := &bytes.Buffer{}
+		body, err := md.encryptArchive(out)
+		So(err, ShouldBeNil)
+		So(out.Len(), ShouldEqual, 0)
+		_, err = body.Write(data)
+		So(err, ShouldBeNil)
+		So(body.Close(), ShouldBeNil)
+		So(out.Len(), ShouldBeLessThan, len(data)/10)
+
+		decrypted, err := encryption.NewReader(out, key)
+		So(err, ShouldBeNil)
+		decompressed, err := compression.Codec{Name: compression.ZstdName}.NewReader(decrypted)
+		So(err, ShouldBeNil)
+		contents, err := ioutil.ReadAll(decompressed)
+		So(err, ShouldBeNil)
+		So(contents, ShouldResemble, data)
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// Events that dump hooks are run for.
+const (
+	preDumpEvent  = "preDump"
+	postDumpEvent = "postDump"
+)
+
+// dumpHookEvent describes the namespace a hook is run for. It is written to
+// the hook's stdin as JSON, and is also in its environment.
+type dumpHookEvent struct {
+	Event      string `json:"event"`
+	Namespace  string `json:"ns"`
+	DB         string `json:"db"`
+	Collection string `json:"collection"`
+	// File is the path of the namespace's BSON file, and is empty when
+	// dumping to an archive or stdout
+	File string `json:"file,omitempty"`
+	// the rest is only set after the namespace is dumped
+	Documents *int64   `json:"documents,omitempty"`
+	Bytes     *int64   `json:"bytes,omitempty"`
+	Seconds   *float64 `json:"seconds,omitempty"`
+}
+
+// newDumpHookEvent returns the event of a hook for intent.
+func newDumpHookEvent(event string, intent *intents.Intent) *dumpHookEvent {
+	e := &dumpHookEvent{
+		Event:      event,
+		Namespace:  intent.Namespace(),
+		DB:         intent.DB,
+		Collection: intent.C,
+	}
+	if file, ok := intent.BSONFile.(*realBSONFile); ok {
+		e.File = file.path
+	}
+	return e
+}
+
+// environment returns the event as environment variables.
+func (e *dumpHookEvent) environment() []string {
+	env := []string{
+		"MONGODUMP_EVENT=" + e.Event,
+		"MONGODUMP_NS=" + e.Namespace,
+		"MONGODUMP_DB=" + e.DB,
+		"MONGODUMP_COLLECTION=" + e.Collection,
+		"MONGODUMP_FILE=" + e.File,
+	}
+	if e.Documents != nil {
+		env = append(env, "MONGODUMP_DOCUMENTS="+strconv.FormatInt(*e.Documents, 10))
+	}
+	if e.Bytes != nil {
+		env = append(env, "MONGODUMP_BYTES="+strconv.FormatInt(*e.Bytes, 10))
+	}
+	if e.Seconds != nil {
+		env = append(env, "MONGODUMP_SECONDS="+strconv.FormatFloat(*e.Seconds, 'f', 3, 64))
+	}
+	return env
+}
+
+// runPreDumpHook runs --preDumpHook, if it is set, before intent is dumped.
+func (dump *MongoDump) runPreDumpHook(intent *intents.Intent, intentLog *log.FieldLogger) error {
+	if dump.OutputOptions.PreDumpHook == "" {
+		return nil
+	}
+	return runDumpHook(dump.OutputOptions.PreDumpHook, newDumpHookEvent(preDumpEvent, intent), intentLog)
+}
+
+// runPostDumpHook runs --postDumpHook, if it is set, after intent is dumped,
+// with the number of documents dumped, the size of its file and how long it
+// took.
+func (dump *MongoDump) runPostDumpHook(intent *intents.Intent, documents int64, elapsed time.Duration,
+	intentLog *log.FieldLogger) error {
+	if dump.OutputOptions.PostDumpHook == "" {
+		return nil
+	}
+	event := newDumpHookEvent(postDumpEvent, intent)
+	seconds := elapsed.Seconds()
+	event.Documents, event.Seconds = &documents, &seconds
+	if size, ok := dump.manifest.fileSize(event.File); ok {
+		event.Bytes = &size
+	}
+	return runDumpHook(dump.OutputOptions.PostDumpHook, event, intentLog)
+}
+
+// runDumpHook runs command with the shell, giving it event on stdin and in
+// its environment. A command that fails fails the dump.
+func runDumpHook(command string, event *dumpHookEvent, intentLog *log.FieldLogger) error {
+	return util.RunHook(command, event.Event, event.Namespace, event, event.environment(), intentLog)
+}
@@ -0,0 +1,86 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDumpHooks(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands are run with sh")
+	}
+
+	dir, err := ioutil.TempDir("", "mongodump-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	intent := &intents.Intent{DB: "db", C: "c"}
+	intent.BSONFile = &realBSONFile{path: "dump/db/c.bson", intent: intent}
+
+	Convey("A post-dump hook gets the namespace and its stats on stdin and in its environment", t, func() {
+		md := simpleMongoDumpInstance()
+		stdin, env := filepath.Join(dir, "stdin.json"), filepath.Join(dir, "env")
+		md.OutputOptions.PostDumpHook = "cat > " + stdin + "; env | grep ^MONGODUMP_ | sort > " + env
+		md.manifest = &dumpManifest{files: map[string]*manifestFile{
+			"dump/db/c.bson.part0000": {Size: 10},
+			"dump/db/c.bson.part0001": {Size: 5},
+			"dump/db/d.bson":          {Size: 100},
+		}}
+
+		err := md.runPostDumpHook(intent, 3, 1500*time.Millisecond, log.WithFields())
+		So(err, ShouldBeNil)
+
+		input, err := ioutil.ReadFile(stdin)
+		So(err, ShouldBeNil)
+		var event dumpHookEvent
+		So(json.Unmarshal(input, &event), ShouldBeNil)
+		So(event.Event, ShouldEqual, postDumpEvent)
+		So(event.Namespace, ShouldEqual, "db.c")
+		So(event.File, ShouldEqual, "dump/db/c.bson")
+		So(*event.Documents, ShouldEqual, 3)
+		So(*event.Bytes, ShouldEqual, 15)
+		So(*event.Seconds, ShouldEqual, 1.5)
+
+		variables, err := ioutil.ReadFile(env)
+		So(err, ShouldBeNil)
+		So(strings.Split(strings.TrimSpace(string(variables)), "\n"), ShouldResemble, []string{
+			"MONGODUMP_BYTES=15",
+			"MONGODUMP_COLLECTION=c",
+			"MONGODUMP_DB=db",
+			"MONGODUMP_DOCUMENTS=3",
+			"MONGODUMP_EVENT=postDump",
+			"MONGODUMP_FILE=dump/db/c.bson",
+			"MONGODUMP_NS=db.c",
+			"MONGODUMP_SECONDS=1.500",
+		})
+	})
+
+	Convey("A failing pre-dump hook is an error, and unset hooks aren't run", t, func() {
+		md := simpleMongoDumpInstance()
+		So(md.runPreDumpHook(intent, log.WithFields()), ShouldBeNil)
+		md.OutputOptions.PreDumpHook = "echo not ready; exit 3"
+		err := md.runPreDumpHook(intent, log.WithFields())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "preDump hook for db.c failed")
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/tracing"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DumpIncremental dumps the oplog entries written after --since, along with
+// the range they cover, instead of the contents of any collection. The result
+// is restored with --oplogReplay on top of a restore of the dump taken at
+// --since, which is either a full dump with --oplog or an earlier incremental
+// dump.
+func (dump *MongoDump) DumpIncremental() (err error) {
+	_, span := tracing.Start(context.Background(), "dump incremental")
+	defer func() { span.End(err) }()
+
+	if err = dump.CreateOplogIntents(); err != nil {
+		return err
+	}
+	since := dump.incrementalSince
+
+	// the oplog must still hold every entry after since, or the increment
+	// would silently miss writes
+	exists, err := dump.checkOplogTimestampExists(since)
+	if err != nil {
+		return fmt.Errorf("unable to check oplog for --since: %v", err)
+	}
+	if !exists {
+		return util.WithErrorCode(util.ErrCodeDumpOplogOverflow, fmt.Errorf(
+			"the oplog no longer contains entries from %v; take a new full dump with --oplog",
+			util.FormatTimestamp(since)))
+	}
+
+	until, err := dump.getCurrentOplogTime()
+	if err != nil {
+		return fmt.Errorf("error getting oplog end: %v", err)
+	}
+	if util.TimestampLessThan(until, since) {
+		return fmt.Errorf("--since %v is later than the most recent oplog entry %v",
+			util.FormatTimestamp(since), util.FormatTimestamp(until))
+	}
+	incremental := &archive.IncrementalRange{Since: since, Until: until}
+	span.SetAttributes("since", util.FormatTimestamp(since), "until", util.FormatTimestamp(until))
+
+	if dump.OutputOptions.Archive != "" {
+		if err = dump.writePrelude(incremental); err != nil {
+			return err
+		}
+	}
+
+	log.Logvf(log.Always, "writing oplog entries after %v up to %v to %v",
+		util.FormatTimestamp(since), util.FormatTimestamp(until), dump.manager.Oplog().Location)
+	// entries at since itself were captured by the previous dump
+	filter := bson.M{"ts": bson.M{"$gt": since, "$lte": until}}
+	if err = dump.dumpOplogQuery(filter, nil); err != nil {
+		return util.WithErrorCode(util.ErrCodeDumpOplog, fmt.Errorf("error dumping oplog: %v", err))
+	}
+
+	// check again, in case the oplog rolled over while we were reading it
+	exists, err = dump.checkOplogTimestampExists(since)
+	if err != nil {
+		return fmt.Errorf("unable to check oplog for overflow: %v", err)
+	}
+	if !exists {
+		return util.WithErrorCode(util.ErrCodeDumpOplogOverflow, fmt.Errorf(
+			"oplog overflow: mongodump was unable to capture all new oplog entries during execution"))
+	}
+
+	// written last, so a directory is only an incremental dump once it is complete
+	if dump.OutputOptions.Archive == "" {
+		if err = dump.writeIncrementalMetadata(incremental); err != nil {
+			return err
+		}
+	}
+
+	log.Logvf(log.Always, "to continue from this dump, use --incremental --since=%v", util.FormatTimestamp(until))
+	return nil
+}
+
+// writeIncrementalMetadata writes the range of the incremental dump to the
+// root of the output directory.
+func (dump *MongoDump) writeIncrementalMetadata(incremental *archive.IncrementalRange) error {
+	data, err := json.Marshal(incremental)
+	if err != nil {
+		return err
+	}
+	if err = dump.writeRootFile(archive.IncrementalMetadataFile, data); err != nil {
+		return fmt.Errorf("error writing incremental dump metadata: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// progressInterval is how often OnProgress is called for each namespace
+// being dumped.
+const progressInterval = time.Second
+
+// Progress is the state of a namespace being dumped, given to OnProgress.
+type Progress struct {
+	Namespace string
+	Documents int64
+	// Total is the estimated number of documents to dump
+	Total int64
+	// Done is set on the last call for the namespace
+	Done bool
+}
+
+// New returns a MongoDump with the given options, as returned by
+// ParseOptions, to embed dumps in another program:
+//
+//	opts, err := mongodump.ParseOptions([]string{"--uri=mongodb://localhost"}, "", "")
+//	...
+//	err = mongodump.New(opts).DumpNamespace(ctx, "test.orders", w)
+//
+// Set OnProgress on it to follow the dump.
+func New(opts Options) *MongoDump {
+	return &MongoDump{
+		ToolOptions:   opts.ToolOptions,
+		InputOptions:  opts.InputOptions,
+		OutputOptions: opts.OutputOptions,
+		DaemonOptions: opts.DaemonOptions,
+	}
+}
+
+// DumpNamespace dumps the documents of a collection, given as
+// <database>.<collection>, to w as BSON, as mongodump does to stdout. The
+// input options of the MongoDump apply, and those of its output options
+// that choose which documents are read and how fast. It stops when ctx is
+// done, returning ctx.Err().
+func (dump *MongoDump) DumpNamespace(ctx context.Context, namespace string, w io.Writer) error {
+	dbName, collName, err := util.SplitAndValidateNamespace(namespace)
+	if err != nil {
+		return util.WithErrorCode(util.ErrCodeBadOptions, err)
+	}
+	if collName == "" {
+		return util.WithErrorCode(util.ErrCodeBadOptions,
+			fmt.Errorf("namespace '%v' must be of the form <database>.<collection>", namespace))
+	}
+
+	toolOptions := *dump.ToolOptions
+	ns := *toolOptions.Namespace
+	ns.DB, ns.Collection = dbName, collName
+	toolOptions.Namespace = &ns
+	inputOptions := *dump.InputOptions
+	outputOptions := OutputOptions{
+		Out:                    "-",
+		NumParallelCollections: 1,
+		NumParallelChunks:      dump.OutputOptions.NumParallelChunks,
+		ViewsAsCollections:     dump.OutputOptions.ViewsAsCollections,
+		TimeseriesBuckets:      dump.OutputOptions.TimeseriesBuckets,
+		MaxDocsPerSecond:       dump.OutputOptions.MaxDocsPerSecond,
+		RateLimit:              dump.OutputOptions.RateLimit,
+		ValidateDocuments:      dump.OutputOptions.ValidateDocuments,
+		SkipCorruptDocuments:   dump.OutputOptions.SkipCorruptDocuments,
+		CorruptDocumentsFile:   dump.OutputOptions.CorruptDocumentsFile,
+	}
+	run := &MongoDump{
+		ToolOptions:       &toolOptions,
+		InputOptions:      &inputOptions,
+		OutputOptions:     &outputOptions,
+		ProgressManager:   dump.ProgressManager,
+		OnProgress:        dump.OnProgress,
+		OutputWriter:      w,
+		SkipUsersAndRoles: true,
+	}
+	return run.DumpContext(ctx)
+}
+
+// DumpContext initializes the MongoDump and dumps with its options, like
+// Init and Dump, stopping when ctx is done, in which case it returns
+// ctx.Err().
+func (dump *MongoDump) DumpContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if dump.OnProgress != nil {
+		callbacks := progress.NewCallbacks(func(update progress.Update) {
+			dump.OnProgress(libraryProgress(update))
+		}, progressInterval)
+		defer callbacks.Stop()
+		dump.ProgressManager = callbacks
+	}
+	if err := dump.Init(); err != nil {
+		if dump.SessionProvider != nil {
+			dump.SessionProvider.Close()
+		}
+		return err
+	}
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			dump.HandleInterrupt()
+		case <-finished:
+		}
+	}()
+	var err error
+	if dump.daemonEnabled() {
+		err = dump.RunDaemon()
+	} else {
+		err = dump.Dump()
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// libraryProgress converts the progress of a namespace to what OnProgress is
+// given.
+func libraryProgress(update progress.Update) Progress {
+	return Progress{Namespace: update.Name, Documents: update.Current, Total: update.Total, Done: update.Done}
+}
@@ -0,0 +1,88 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDumpNamespace(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("DumpNamespace requires a collection namespace", t, func() {
+		md := simpleMongoDumpInstance()
+		So(md.DumpNamespace(context.Background(), "test", &bytes.Buffer{}), ShouldNotBeNil)
+		So(md.DumpNamespace(context.Background(), "", &bytes.Buffer{}), ShouldNotBeNil)
+	})
+
+	Convey("A cancelled context stops the dump before it connects", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		md := simpleMongoDumpInstance()
+		So(md.DumpNamespace(ctx, "test.orders", &bytes.Buffer{}), ShouldEqual, context.Canceled)
+		So(md.SessionProvider, ShouldBeNil)
+	})
+}
+
+func TestLibraryProgress(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Progress of a namespace is given to OnProgress in documents", t, func() {
+		So(libraryProgress(progress.Update{Name: "test.orders", Current: 4, Total: 10, Documents: true, Done: true}),
+			ShouldResemble, Progress{Namespace: "test.orders", Documents: 4, Total: 10, Done: true})
+	})
+}
+
+func TestDumpContextCancel(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.IntegrationTestType)
+	log.SetWriter(ioutil.Discard)
+
+	Convey("With test data to dump", t, func() {
+		So(setUpMongoDumpTestData(), ShouldBeNil)
+		dumpDir, err := ioutil.TempDir("", "mongodump_cancel")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			So(os.RemoveAll(dumpDir), ShouldBeNil)
+			So(tearDownMongoDumpTestData(), ShouldBeNil)
+		})
+
+		Convey("cancelling the context mid-dump stops the remaining collections", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			md := simpleMongoDumpInstance()
+			md.OutputOptions.Out = dumpDir
+			md.OnProgress = func(p Progress) {
+				if !p.Done {
+					return
+				}
+				// cancel once the first collection is dumped, and wait for
+				// the interrupt so that the next one isn't started
+				cancel()
+				for !md.shutdown.isInterrupted() {
+					time.Sleep(time.Millisecond)
+				}
+			}
+			So(md.DumpContext(ctx), ShouldEqual, context.Canceled)
+			So(md.shutdownIntentsNotifier.isNotified(), ShouldBeTrue)
+
+			dumped, err := countNonIndexBSONFiles(filepath.Join(dumpDir, testDB))
+			So(err, ShouldBeNil)
+			So(dumped, ShouldBeLessThan, len(testCollectionNames))
+		})
+	})
+}
@@ -11,10 +11,10 @@ import (
 	"os"
 	"time"
 
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/progress"
-	"github.com/mongodb/mongo-tools-common/signals"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/signals"
+	"github.com/mongodb/mongo-tools/common/util"
 	"github.com/mongodb/mongo-tools/mongodump"
 )
 
@@ -34,8 +34,10 @@ func main() {
 	if err != nil {
 		log.Logvf(log.Always, "error parsing command line options: %s", err.Error())
 		log.Logvf(log.Always, util.ShortUsage("mongodump"))
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitBadOptions)
 	}
+	defer util.Shutdown()
+	defer util.RecoverCrash()
 
 	// print help, if specified
 	if opts.PrintHelp(false) {
@@ -50,6 +52,14 @@ func main() {
 	// init logger
 	log.SetVerbosity(opts.Verbosity)
 
+	// check an existing dump, which doesn't need a connection
+	if opts.OutputOptions.VerifyManifest != "" {
+		if err = mongodump.VerifyManifest(opts.OutputOptions.VerifyManifest); err != nil {
+			util.Exit(util.LogFailure(err))
+		}
+		return
+	}
+
 	// verify uri options and log them
 	opts.URI.LogUnsupportedOptions()
 
@@ -58,23 +68,22 @@ func main() {
 	progressManager.Start()
 	defer progressManager.Stop()
 
-	dump := mongodump.MongoDump{
-		ToolOptions:     opts.ToolOptions,
-		OutputOptions:   opts.OutputOptions,
-		InputOptions:    opts.InputOptions,
-		ProgressManager: progressManager,
-	}
+	dump := mongodump.New(opts)
+	dump.ProgressManager = progressManager
 
 	finishedChan := signals.HandleWithInterrupt(dump.HandleInterrupt)
 	defer close(finishedChan)
 
 	if err = dump.Init(); err != nil {
-		log.Logvf(log.Always, "Failed: %v", err)
-		os.Exit(util.ExitFailure)
+		util.Exit(util.LogFailure(err))
 	}
 
-	if err = dump.Dump(); err != nil {
-		log.Logvf(log.Always, "Failed: %v", err)
-		os.Exit(util.ExitFailure)
+	if opts.DaemonOptions.Daemon {
+		err = dump.RunDaemon()
+	} else {
+		err = dump.Dump()
+	}
+	if err != nil {
+		util.Exit(util.LogFailure(err))
 	}
 }
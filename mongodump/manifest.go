@@ -0,0 +1,572 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// manifestVersion is the version of the manifest format.
+const manifestVersion = 1
+
+// manifestSuffix is appended to the path of an archive to name its manifest.
+const manifestSuffix = ".manifest.json"
+
+// manifest describes a dump, for auditing it and checking that it was
+// transferred intact.
+type manifest struct {
+	Version       int                    `json:"version"`
+	ToolVersion   string                 `json:"toolVersion"`
+	GitCommit     string                 `json:"gitCommit"`
+	Created       time.Time              `json:"created"`
+	ServerVersion string                 `json:"serverVersion"`
+	Topology      manifestTopology       `json:"topology"`
+	Options       map[string]interface{} `json:"options"`
+	// ChangeMarker is the latest oplog time before any collection was
+	// dumped, which --reuseUnchangedFrom looks for changes after. It is
+	// only recorded for dumps of replica sets.
+	ChangeMarker string `json:"changeMarker,omitempty"`
+	// Interrupted is set for a dump stopped by a signal, which lists the
+	// collections that weren't completely dumped in Incomplete
+	Interrupted bool            `json:"interrupted,omitempty"`
+	Incomplete  []string        `json:"incomplete,omitempty"`
+	Files       []*manifestFile `json:"files"`
+}
+
+// manifestTopology is the deployment a dump was taken from.
+type manifestTopology struct {
+	Type    string          `json:"type"`
+	SetName string          `json:"setName,omitempty"`
+	Hosts   []string        `json:"hosts,omitempty"`
+	Shards  []manifestShard `json:"shards,omitempty"`
+}
+
+type manifestShard struct {
+	ID   string `json:"id"`
+	Host string `json:"host"`
+}
+
+// manifestFile is a file of a dump. Paths are relative to the dump
+// directory, or to the directory holding an archive, and always use slashes.
+type manifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Documents is set for BSON files, and Namespace and UUID for the BSON
+	// files of collections
+	Documents *int64 `json:"documents,omitempty"`
+	Namespace string `json:"ns,omitempty"`
+	UUID      string `json:"uuid,omitempty"`
+	// MD5 is the dbHash of the collection, recorded with --dbHash
+	MD5 string `json:"md5,omitempty"`
+}
+
+// dumpManifest records the files of a dump as they are written. A nil
+// dumpManifest records nothing, for dumps to stdout.
+type dumpManifest struct {
+	// root is the local directory paths are relative to, or empty when
+	// they are relative to object storage
+	root string
+	// location is where the manifest is written for an archive
+	location string
+
+	mutex sync.Mutex
+	files map[string]*manifestFile
+}
+
+// newManifest returns the manifest for the dump's output.
+func (dump *MongoDump) newManifest() *dumpManifest {
+	switch {
+	case dump.collectionToStdout(), dump.OutputOptions.Archive == "-":
+		return nil
+	case dump.outputStorage != nil, dump.tarOutputEnabled(), dump.OutputOptions.Archive != "":
+		// storage and tar paths are already relative, and archives set
+		// their root when the output is opened
+		return &dumpManifest{files: map[string]*manifestFile{}}
+	}
+	return &dumpManifest{root: dump.outputRoot(), files: map[string]*manifestFile{}}
+}
+
+// list returns the files recorded so far, sorted by path.
+func (m *dumpManifest) list() []*manifestFile {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	files := make([]*manifestFile, 0, len(m.files))
+	for _, file := range m.files {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+func (m *dumpManifest) relative(path string) string {
+	if m.root != "" {
+		if rel, err := filepath.Rel(m.root, path); err == nil {
+			path = rel
+		}
+	}
+	return filepath.ToSlash(path)
+}
+
+func (m *dumpManifest) entry(path string) *manifestFile {
+	rel := m.relative(path)
+	file, ok := m.files[rel]
+	if !ok {
+		file = &manifestFile{Path: rel}
+		m.files[rel] = file
+	}
+	return file
+}
+
+func (m *dumpManifest) record(path string, size int64, sum hash.Hash) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	file := m.entry(path)
+	file.Size = size
+	file.SHA256 = hex.EncodeToString(sum.Sum(nil))
+}
+
+// track returns a writer that records the size and checksum of what is
+// written through it to path when it is closed.
+func (m *dumpManifest) track(path string, w io.WriteCloser) io.WriteCloser {
+	if m == nil {
+		return w
+	}
+	return &manifestWriter{WriteCloser: w, manifest: m, path: path, hash: sha256.New()}
+}
+
+// trackArchive is track for an archive written to location, whose manifest
+// is written next to it.
+func (m *dumpManifest) trackArchive(location string, w io.WriteCloser) io.WriteCloser {
+	if m == nil {
+		return w
+	}
+	m.location = location + manifestSuffix
+	return m.track(path.Base(filepath.ToSlash(location)), w)
+}
+
+// trackResumed is track for a file that is appended to after its first
+// size bytes, which are read back to include them in the checksum.
+func (m *dumpManifest) trackResumed(path string, size int64, w io.WriteCloser) (io.WriteCloser, error) {
+	if m == nil {
+		return w, nil
+	}
+	tracker := &manifestWriter{WriteCloser: w, manifest: m, path: path, hash: sha256.New()}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if tracker.size, err = io.CopyN(tracker.hash, file, size); err != nil {
+		return nil, err
+	}
+	return tracker, nil
+}
+
+// addData records a file written all at once.
+func (m *dumpManifest) addData(path string, data []byte) {
+	if m == nil {
+		return
+	}
+	sum := sha256.New()
+	sum.Write(data)
+	m.record(path, int64(len(data)), sum)
+}
+
+// addExisting records a local file written by an earlier, resumed, run.
+func (m *dumpManifest) addExisting(path string) error {
+	if m == nil {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	sum := sha256.New()
+	size, err := io.Copy(sum, file)
+	if err != nil {
+		return err
+	}
+	m.record(path, size, sum)
+	return nil
+}
+
+// setDocuments records the number of documents in a BSON file.
+func (m *dumpManifest) setDocuments(path string, count int64) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.entry(path).Documents = &count
+}
+
+// setCollection records the collection a BSON file holds, if it has a UUID.
+func (m *dumpManifest) setCollection(path, namespace, uuid string) {
+	if m == nil || uuid == "" {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	file := m.entry(path)
+	file.Namespace, file.UUID = namespace, uuid
+}
+
+// addReused records a file linked from a previous dump with the entry of
+// that dump's manifest.
+func (m *dumpManifest) addReused(path string, previous *manifestFile) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	file := m.entry(path)
+	rel := file.Path
+	*file = *previous
+	file.Path = rel
+}
+
+// fileSize returns the size of the file written to path, adding up its
+// segments if it was split. It returns false if nothing is recorded for path.
+func (m *dumpManifest) fileSize(path string) (int64, bool) {
+	if m == nil || path == "" {
+		return 0, false
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	rel := m.relative(path)
+	var size int64
+	var found bool
+	for name, file := range m.files {
+		if base, _, ok := util.SplitSegmentPath(name); name == rel || ok && base == rel {
+			size += file.Size
+			found = true
+		}
+	}
+	return size, found
+}
+
+// manifestWriter computes the size and checksum of a file as it is written.
+type manifestWriter struct {
+	io.WriteCloser
+	manifest *dumpManifest
+	path     string
+	hash     hash.Hash
+	size     int64
+}
+
+func (w *manifestWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.hash.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *manifestWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if err == nil {
+		w.manifest.record(w.path, w.size, w.hash)
+	}
+	return err
+}
+
+// writeRootFile writes a file to the root of the output directory and
+// records it in the manifest.
+func (dump *MongoDump) writeRootFile(name string, data []byte) error {
+	if dump.outputStorage != nil {
+		out, err := dump.outputStorage.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err = out.Write(data); err != nil {
+			out.Close()
+			return err
+		}
+		if err = out.Close(); err != nil {
+			return err
+		}
+		dump.manifest.addData(name, data)
+		return nil
+	}
+	if err := os.MkdirAll(dump.outputRoot(), defaultPermissions); err != nil {
+		return fmt.Errorf("error creating directory %v: %v", dump.outputRoot(), err)
+	}
+	path := filepath.Join(dump.outputRoot(), name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	dump.manifest.addData(path, data)
+	return nil
+}
+
+// writeManifest writes the manifest of a completed or interrupted dump, to
+// the root of the output directory or next to the archive.
+func (dump *MongoDump) writeManifest() error {
+	serverVersion, err := dump.SessionProvider.ServerVersion()
+	if err != nil {
+		serverVersion = "unknown"
+	}
+	m := &manifest{
+		Version:       manifestVersion,
+		ToolVersion:   dump.ToolOptions.VersionStr,
+		GitCommit:     dump.ToolOptions.GitCommit,
+		Created:       time.Now().UTC(),
+		ServerVersion: serverVersion,
+		Topology:      dump.manifestTopology(),
+		Options:       optionValues(dump.ToolOptions.Namespace, dump.InputOptions, dump.OutputOptions),
+	}
+	if dump.changeMarker != nil {
+		m.ChangeMarker = util.FormatTimestamp(*dump.changeMarker)
+	}
+	if dump.shutdown.partial {
+		m.Interrupted, m.Incomplete = true, dump.shutdown.incomplete
+	} else if dump.OutputOptions.DBHash {
+		if err = dump.recordDBHashes(); err != nil {
+			return err
+		}
+	}
+	if err = dump.saveManifest(m); err != nil {
+		return err
+	}
+	return dump.writeTargetManifests(m)
+}
+
+// saveManifest adds the files recorded so far to m and writes it.
+func (dump *MongoDump) saveManifest(m *manifest) error {
+	m.Files = append(m.Files, dump.manifest.list()...)
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	switch location := dump.manifest.location; {
+	case location == "":
+		err = dump.writeRootFile(archive.ManifestFile, data)
+	case storage.IsRemote(location):
+		var out io.WriteCloser
+		if out, err = storage.Create(location); err == nil {
+			if _, err = out.Write(data); err == nil {
+				err = out.Close()
+			}
+		}
+	default:
+		err = ioutil.WriteFile(location, data, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing dump manifest: %v", err)
+	}
+	log.Logvf(log.Info, "wrote manifest of %v %v", len(m.Files), util.Pluralize(len(m.Files), "file", "files"))
+	return nil
+}
+
+// recordDBHashes records the dbHash of the collection of each BSON file in
+// the manifest, running it once per database.
+func (dump *MongoDump) recordDBHashes() error {
+	byDB := map[string]map[string][]*manifestFile{}
+	for _, file := range dump.manifest.list() {
+		if file.Namespace == "" {
+			continue
+		}
+		dbName, collection := util.SplitNamespace(file.Namespace)
+		if byDB[dbName] == nil {
+			byDB[dbName] = map[string][]*manifestFile{}
+		}
+		byDB[dbName][collection] = append(byDB[dbName][collection], file)
+	}
+	for dbName, collections := range byDB {
+		names := make([]string, 0, len(collections))
+		for name := range collections {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var result struct {
+			Collections map[string]string `bson:"collections"`
+		}
+		err := dump.SessionProvider.Run(bson.D{{"dbHash", 1}, {"collections", names}}, &result, dbName)
+		if err != nil {
+			return fmt.Errorf("error running dbHash on database %v: %v", dbName, err)
+		}
+		dump.manifest.mutex.Lock()
+		for name, files := range collections {
+			for _, file := range files {
+				file.MD5 = result.Collections[name]
+			}
+		}
+		dump.manifest.mutex.Unlock()
+	}
+	return nil
+}
+
+// manifestTopology describes the deployment being dumped. It is best
+// effort, since it is only informational.
+func (dump *MongoDump) manifestTopology() manifestTopology {
+	topology := manifestTopology{Type: "unknown"}
+	if nodeType, err := dump.SessionProvider.GetNodeType(); err == nil {
+		topology.Type = string(nodeType)
+	}
+	var hello struct {
+		SetName string   `bson:"setName"`
+		Hosts   []string `bson:"hosts"`
+	}
+	if err := dump.SessionProvider.Run(bson.D{{"isMaster", 1}}, &hello, "admin"); err == nil {
+		topology.SetName = hello.SetName
+		topology.Hosts = hello.Hosts
+	}
+	if dump.shards != nil {
+		for _, shard := range dump.shards.snapshot.Shards {
+			topology.Shards = append(topology.Shards, manifestShard{ID: shard.ID, Host: shard.Host})
+		}
+	}
+	return topology
+}
+
+// optionValues returns the options in each group that were set, by their
+// long names.
+func optionValues(groups ...interface{}) map[string]interface{} {
+	values := map[string]interface{}{}
+	for _, group := range groups {
+		v := reflect.Indirect(reflect.ValueOf(group))
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+		for i := 0; i < v.NumField(); i++ {
+			name := v.Type().Field(i).Tag.Get("long")
+			field := v.Field(i)
+			if name == "" || reflect.DeepEqual(field.Interface(), reflect.Zero(field.Type()).Interface()) {
+				continue
+			}
+			values[name] = field.Interface()
+		}
+	}
+	return values
+}
+
+// VerifyManifest checks the files of the dump at path against its manifest.
+// The path is a dump directory, an archive, or a manifest file. Files of a
+// dump directory that the manifest does not list are also reported.
+func VerifyManifest(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	manifestPath, root := path, filepath.Dir(path)
+	switch {
+	case info.IsDir():
+		manifestPath, root = filepath.Join(path, archive.ManifestFile), path
+	case !strings.HasSuffix(path, manifestSuffix) && filepath.Base(path) != archive.ManifestFile:
+		manifestPath = path + manifestSuffix
+	}
+	m, err := readManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	log.Logvf(log.Always, "verifying %v %v of a dump taken by mongodump %v at %v",
+		len(m.Files), util.Pluralize(len(m.Files), "file", "files"), m.ToolVersion, m.Created.Format(time.RFC3339))
+
+	var problems []string
+	if m.Interrupted {
+		problems = append(problems, fmt.Sprintf("the dump was interrupted, leaving %v %v incomplete: %v",
+			len(m.Incomplete), util.Pluralize(len(m.Incomplete), "collection", "collections"), strings.Join(m.Incomplete, ", ")))
+	}
+	listed := map[string]bool{}
+	for _, file := range m.Files {
+		listed[file.Path] = true
+		if problem := verifyManifestFile(root, file); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	if info.IsDir() {
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || path == manifestPath {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			if !listed[filepath.ToSlash(rel)] {
+				problems = append(problems, fmt.Sprintf("%v is not in the manifest", filepath.ToSlash(rel)))
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error listing %v: %v", root, err)
+		}
+	}
+
+	for _, problem := range problems {
+		log.Logvf(log.Always, "%v", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("dump does not match its manifest: found %v %v",
+			len(problems), util.Pluralize(len(problems), "problem", "problems"))
+	}
+	log.Logvf(log.Always, "all %v %v match the manifest", len(m.Files), util.Pluralize(len(m.Files), "file", "files"))
+	return nil
+}
+
+// readManifest reads and parses the manifest at manifestPath.
+func readManifest(manifestPath string) (*manifest, error) {
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading dump manifest: %v", err)
+	}
+	m := &manifest{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("error parsing dump manifest %v: %v", manifestPath, err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("dump manifest %v has unsupported version %v", manifestPath, m.Version)
+	}
+	return m, nil
+}
+
+// verifyManifestFile returns a description of how the file differs from
+// its manifest entry, or an empty string if it matches.
+func verifyManifestFile(root string, entry *manifestFile) string {
+	file, err := os.Open(filepath.Join(root, filepath.FromSlash(entry.Path)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Sprintf("%v is missing", entry.Path)
+		}
+		return fmt.Sprintf("%v can't be read: %v", entry.Path, err)
+	}
+	defer file.Close()
+	sum := sha256.New()
+	size, err := io.Copy(sum, file)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("%v can't be read: %v", entry.Path, err)
+	case size != entry.Size:
+		return fmt.Sprintf("%v is %v bytes, but the manifest has %v", entry.Path, size, entry.Size)
+	case hex.EncodeToString(sum.Sum(nil)) != entry.SHA256:
+		return fmt.Sprintf("%v has a different SHA-256 checksum than the manifest", entry.Path)
+	}
+	return ""
+}
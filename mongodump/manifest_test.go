@@ -0,0 +1,129 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestManifest(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	data := bytes.Repeat([]byte("collection data "), 1000)
+
+	Convey("With a dump directory written with a manifest", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump_manifest")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.Out = filepath.Join(dir, "dump")
+		md.manifest = md.newManifest()
+
+		path := filepath.Join(md.OutputOptions.Out, "db", "c.bson")
+		file := &realBSONFile{path: path, intent: &intents.Intent{DB: "db", C: "c"}, manifest: md.manifest}
+		So(file.Open(), ShouldBeNil)
+		_, err = file.Write(data)
+		So(err, ShouldBeNil)
+		So(file.Close(), ShouldBeNil)
+		md.manifest.setDocuments(path, 3)
+		So(md.writeRootFile(archive.IncrementalMetadataFile, []byte(`{}`)), ShouldBeNil)
+		So(md.saveManifest(&manifest{Version: manifestVersion, ToolVersion: "test"}), ShouldBeNil)
+
+		Convey("it lists every file with its size, checksum and document count", func() {
+			contents, err := ioutil.ReadFile(filepath.Join(md.OutputOptions.Out, archive.ManifestFile))
+			So(err, ShouldBeNil)
+			m := &manifest{}
+			So(json.Unmarshal(contents, m), ShouldBeNil)
+			So(m.Files, ShouldHaveLength, 2)
+			So(m.Files[0].Path, ShouldEqual, "db/c.bson")
+			So(m.Files[0].Size, ShouldEqual, len(data))
+			So(m.Files[0].SHA256, ShouldHaveLength, 64)
+			So(*m.Files[0].Documents, ShouldEqual, 3)
+			So(m.Files[1].Path, ShouldEqual, archive.IncrementalMetadataFile)
+			So(m.Files[1].Documents, ShouldBeNil)
+		})
+
+		Convey("it verifies while unchanged", func() {
+			So(VerifyManifest(md.OutputOptions.Out), ShouldBeNil)
+		})
+
+		Convey("a modified file fails verification", func() {
+			modified := append([]byte{}, data...)
+			modified[0] = 'C'
+			So(ioutil.WriteFile(path, modified, 0644), ShouldBeNil)
+			So(VerifyManifest(md.OutputOptions.Out), ShouldNotBeNil)
+			So(verifyManifestFile(md.OutputOptions.Out, &manifestFile{Path: "db/c.bson", Size: int64(len(data))}),
+				ShouldContainSubstring, "checksum")
+		})
+
+		Convey("an interrupted dump fails verification", func() {
+			So(md.saveManifest(&manifest{Version: manifestVersion, Interrupted: true, Incomplete: []string{"db.c"}}), ShouldBeNil)
+			So(VerifyManifest(md.OutputOptions.Out), ShouldNotBeNil)
+		})
+
+		Convey("missing and unlisted files fail verification", func() {
+			So(os.Rename(path, path+".moved"), ShouldBeNil)
+			So(VerifyManifest(md.OutputOptions.Out), ShouldNotBeNil)
+			So(verifyManifestFile(md.OutputOptions.Out, &manifestFile{Path: "db/c.bson"}), ShouldContainSubstring, "missing")
+		})
+	})
+
+	Convey("A resumed file's checksum covers what was written before", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump_manifest")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		m := &dumpManifest{root: dir, files: map[string]*manifestFile{}}
+		path := filepath.Join(dir, "c.bson")
+		So(ioutil.WriteFile(path, append(data[:100:100], "partial"...), 0644), ShouldBeNil)
+		file := &realBSONFile{path: path, intent: &intents.Intent{DB: "db", C: "c"}, manifest: m, resumeAt: 100}
+		So(file.Open(), ShouldBeNil)
+		_, err = file.Write(data[100:])
+		So(err, ShouldBeNil)
+		So(file.Close(), ShouldBeNil)
+
+		So(m.files["c.bson"].Size, ShouldEqual, len(data))
+		So(verifyManifestFile(dir, m.files["c.bson"]), ShouldEqual, "")
+	})
+
+	Convey("An archive's manifest is written next to it", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump_manifest")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.Archive = filepath.Join(dir, "dump.archive")
+		md.manifest = md.newManifest()
+		out, err := md.getArchiveOut()
+		So(err, ShouldBeNil)
+		_, err = out.Write(data)
+		So(err, ShouldBeNil)
+		So(out.Close(), ShouldBeNil)
+		So(md.saveManifest(&manifest{Version: manifestVersion}), ShouldBeNil)
+
+		_, err = os.Stat(md.OutputOptions.Archive + manifestSuffix)
+		So(err, ShouldBeNil)
+		So(VerifyManifest(md.OutputOptions.Archive), ShouldBeNil)
+		So(VerifyManifest(md.OutputOptions.Archive+manifestSuffix), ShouldBeNil)
+	})
+
+	Convey("Only the options that were set are recorded", t, func() {
+		values := optionValues(&OutputOptions{Out: "backup", Gzip: true}, InputOptions{})
+		So(values, ShouldResemble, map[string]interface{}{"out": "backup", "gzip": true})
+	})
+}
@@ -0,0 +1,36 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// IDs of the user-facing messages logged by mongodump
+const (
+	msgNamespaceMissing      log.MessageID = "dump.namespaceMissing"
+	msgDumpingUsersForDB     log.MessageID = "dump.dumpingUsersForDB"
+	msgSkippingAdminUsers    log.MessageID = "dump.skippingAdminUsers"
+	msgWritingOplog          log.MessageID = "dump.writingOplog"
+	msgWritingToStdout       log.MessageID = "dump.writingToStdout"
+	msgDumpedToStdout        log.MessageID = "dump.dumpedToStdout"
+	msgWritingCollection     log.MessageID = "dump.writingCollection"
+	msgDoneDumpingCollection log.MessageID = "dump.doneDumpingCollection"
+)
+
+func init() {
+	log.RegisterMessages(map[log.MessageID]string{
+		msgNamespaceMissing:      "namespace with DB %s and collection %s does not exist",
+		msgDumpingUsersForDB:     "dumping users and roles for %v",
+		msgSkippingAdminUsers:    "skipping users/roles dump, already dumped admin database",
+		msgWritingOplog:          "writing captured oplog to %v",
+		msgWritingToStdout:       "writing %v to stdout",
+		msgDumpedToStdout:        "dumped %v %v",
+		msgWritingCollection:     "writing %v to %v",
+		msgDoneDumpingCollection: "done dumping %v (%v %v)",
+	})
+}
@@ -11,18 +11,29 @@ import (
 	"fmt"
 	"io"
 
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/intents"
-	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
 // Metadata holds information about a collection's options and indexes.
+// The options include a view's definition and a collection's default
+// collation and validator. ViewDependencies lists the namespaces a view reads
+// from, so that mongorestore can create them before it. TimeseriesBuckets
+// is set when the data of a time-series collection is its raw buckets, with
+// the ServerVersion they were dumped from, whose bucket format they are in.
+// Sharding is set for sharded collections dumped through mongos.
 type Metadata struct {
-	Options        bson.M   `bson:"options,omitempty"`
-	Indexes        []bson.D `bson:"indexes"`
-	UUID           string   `bson:"uuid,omitempty"`
-	CollectionName string   `bson:"collectionName"`
+	Options           bson.M                    `bson:"options,omitempty"`
+	Indexes           []bson.D                  `bson:"indexes"`
+	UUID              string                    `bson:"uuid,omitempty"`
+	CollectionName    string                    `bson:"collectionName"`
+	ViewDependencies  []string                  `bson:"viewDependencies,omitempty"`
+	TimeseriesBuckets bool                      `bson:"timeseriesBuckets,omitempty"`
+	ServerVersion     string                    `bson:"serverVersion,omitempty"`
+	Sharding          *archive.ShardingMetadata `bson:"sharding,omitempty"`
 }
 
 // IndexDocumentFromDB is used internally to preserve key ordering.
@@ -43,7 +54,13 @@ func (dump *MongoDump) dumpMetadata(intent *intents.Intent, buffer resettableOut
 	}
 
 	// The collection options were already gathered while building the list of intents.
-	meta.Options = intent.Options
+	// --indexesOnly leaves them out, so that only the indexes are restored.
+	if !dump.OutputOptions.IndexesOnly {
+		meta.Options = intent.Options
+		if intent.IsView() && !dump.OutputOptions.ViewsAsCollections {
+			meta.ViewDependencies = viewDependencies(intent.DB, intent.Options)
+		}
+	}
 
 	// If a collection has a UUID, it was gathered while building the list of
 	// intents.  Otherwise, it will be the empty string.
@@ -52,6 +69,17 @@ func (dump *MongoDump) dumpMetadata(intent *intents.Intent, buffer resettableOut
 	// Adding the collection name is useful if a long collection name results in a truncated
 	// bson or metadata file name, in which case the collection name can be found here.
 	meta.CollectionName = intent.C
+	meta.TimeseriesBuckets = dump.dumpsBuckets(intent)
+	if meta.TimeseriesBuckets {
+		if meta.ServerVersion, err = dump.SessionProvider.ServerVersion(); err != nil {
+			return fmt.Errorf("error getting server version: %v", err)
+		}
+	}
+	if dump.isMongos && !intent.IsView() && !dump.OutputOptions.IndexesOnly {
+		if meta.Sharding, err = dump.shardingMetadata(intent); err != nil {
+			return fmt.Errorf("error reading the sharding of %v: %v", intent.Namespace(), err)
+		}
+	}
 
 	// Second, we read the collection's index information by either calling
 	// listIndexes (pre-2.7 systems) or querying system.indexes.
@@ -67,6 +95,8 @@ func (dump *MongoDump) dumpMetadata(intent *intents.Intent, buffer resettableOut
 
 	if dump.OutputOptions.ViewsAsCollections || intent.IsView() {
 		log.Logvf(log.DebugLow, "not dumping indexes metadata for '%v' because it is a view", intent.Namespace())
+	} else if dump.source.noIndexes {
+		log.Logvf(log.DebugLow, "not dumping indexes metadata for '%v' because %v has no indexes", intent.Namespace(), sourceName(dump.source.kind))
 	} else {
 		// get the indexes
 		indexesIter, err := db.GetIndexes(session.Database(intent.DB).Collection(intent.C))
@@ -130,3 +160,79 @@ func (dump *MongoDump) dumpMetadata(intent *intents.Intent, buffer resettableOut
 	}
 	return
 }
+
+// viewDependencies returns the namespaces that the view with the given
+// options reads from: the collection or view it is defined on, and those of
+// the $lookup, $graphLookup and $unionWith stages anywhere in its pipeline.
+func viewDependencies(dbName string, options bson.M) []string {
+	var dependencies []string
+	seen := map[string]bool{}
+	add := func(source interface{}) {
+		ns := ""
+		switch source := source.(type) {
+		case string:
+			ns = dbName + "." + source
+		case bson.D:
+			ns = lookupNamespace(dbName, source.Map())
+		case bson.M:
+			ns = lookupNamespace(dbName, source)
+		}
+		if ns != "" && !seen[ns] {
+			seen[ns] = true
+			dependencies = append(dependencies, ns)
+		}
+	}
+
+	var walk func(value interface{})
+	walkField := func(key string, value interface{}) {
+		switch key {
+		case "$lookup", "$graphLookup":
+			switch stage := value.(type) {
+			case bson.D:
+				add(stage.Map()["from"])
+			case bson.M:
+				add(stage["from"])
+			}
+		case "$unionWith":
+			add(value)
+		}
+		walk(value)
+	}
+	walk = func(value interface{}) {
+		switch value := value.(type) {
+		case bson.D:
+			for _, elem := range value {
+				walkField(elem.Key, elem.Value)
+			}
+		case bson.M:
+			for key, elem := range value {
+				walkField(key, elem)
+			}
+		case bson.A:
+			for _, elem := range value {
+				walk(elem)
+			}
+		case []interface{}:
+			for _, elem := range value {
+				walk(elem)
+			}
+		}
+	}
+
+	add(options["viewOn"])
+	walk(options["pipeline"])
+	return dependencies
+}
+
+// lookupNamespace returns the namespace named by a {db, coll} document in a
+// pipeline stage, or by its "coll" field alone.
+func lookupNamespace(dbName string, source bson.M) string {
+	coll, ok := source["coll"].(string)
+	if !ok {
+		return ""
+	}
+	if db, ok := source["db"].(string); ok {
+		return db + "." + coll
+	}
+	return dbName + "." + coll
+}
@@ -0,0 +1,38 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestViewDependencies(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("A view depends on its source and every collection its pipeline reads", t, func() {
+		options := bson.M{
+			"viewOn": "orders",
+			"pipeline": bson.A{
+				bson.M{"$lookup": bson.M{"from": "customers", "as": "customer"}},
+				bson.M{"$unionWith": bson.M{"coll": "archived", "pipeline": bson.A{
+					bson.M{"$graphLookup": bson.M{"from": "regions"}},
+				}}},
+				bson.M{"$facet": bson.M{"totals": bson.A{
+					bson.M{"$unionWith": "orders"},
+				}}},
+				bson.M{"$lookup": bson.M{"from": bson.M{"db": "reports", "coll": "monthly"}}},
+			},
+		}
+		So(viewDependencies("app", options), ShouldResemble, []string{
+			"app.orders", "app.customers", "app.archived", "app.regions", "reports.monthly",
+		})
+	})
+}
@@ -10,27 +10,33 @@ package mongodump
 import (
 	"context"
 
-	"github.com/mongodb/mongo-tools-common/archive"
-	"github.com/mongodb/mongo-tools-common/auth"
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/failpoint"
-	"github.com/mongodb/mongo-tools-common/intents"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/options"
-	"github.com/mongodb/mongo-tools-common/progress"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/auth"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/encryption"
+	"github.com/mongodb/mongo-tools/common/failpoint"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"github.com/mongodb/mongo-tools/common/text"
+	"github.com/mongodb/mongo-tools/common/tracing"
+	"github.com/mongodb/mongo-tools/common/util"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 
 	"bufio"
-	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -51,11 +57,16 @@ type MongoDump struct {
 	ToolOptions   *options.ToolOptions
 	InputOptions  *InputOptions
 	OutputOptions *OutputOptions
+	DaemonOptions *DaemonOptions
 
 	// Skip dumping users and roles, regardless of namespace, when true.
 	SkipUsersAndRoles bool
 
 	ProgressManager progress.Manager
+	// OnProgress, if set, is called with the progress of each namespace
+	// dumped by DumpContext or DumpNamespace, in place of the
+	// ProgressManager
+	OnProgress func(Progress)
 
 	// useful internals that we don't directly expose as options
 	SessionProvider *db.SessionProvider
@@ -68,10 +79,85 @@ type MongoDump struct {
 	storageEngine   storageEngineType
 	authVersion     int
 	archive         *archive.Writer
+	checkpoint      *dumpCheckpoint
+	shards          *shardCoordinator
+	// archiveIndex is the archive file written with --archiveIndex
+	archiveIndex *indexedArchive
+	// namespaceQueries holds the filter for each namespace of a queryFile
+	// given without --collection
+	namespaceQueries map[string]bson.D
+	// pipelines holds the aggregation pipeline of each namespace given by
+	// --pipeline or --pipelineFile
+	pipelines map[string]bson.A
+	// samples holds the sample size of each namespace given by --sample or
+	// --sampleDocs, and of every other collection under ""
+	samples map[string]sampleSize
+	// sampleRefs tracks the documents referred to with --sampleReference
+	sampleRefs *sampleReferences
+	// outputStorage is set when --out is an object storage URL
+	outputStorage storage.Backend
+	// tarOut is the output with --outFormat tar or tar.gz, which is also
+	// the outputStorage
+	tarOut *tarOutput
+	// source holds what the deployment being dumped doesn't support
+	source sourceLimits
+	// outputMappings holds the rules of --outputMapFile, in order, and
+	// outputTargets the locations they write to
+	outputMappings []*outputMapping
+	outputTargets  []*outputTarget
+
+	// incrementalSince is the parsed value of --since
+	incrementalSince primitive.Timestamp
+	// snapshotTime is the cluster time every collection is read at with
+	// --snapshot
+	snapshotTime *primitive.Timestamp
+	// snapshotDeadline is when the servers may discard the history needed to
+	// read at the cluster time of --snapshot or --coordinateShards
+	snapshotDeadline time.Time
+	// byteLimiter and docLimiter throttle the dump for --rateLimit and
+	// --maxDocsPerSecond
+	byteLimiter *util.RateLimiter
+	docLimiter  *util.RateLimiter
+	// includer and excluder select namespaces for --nsInclude and
+	// --nsExclude, and are nil when those aren't given
+	includer *ns.Matcher
+	excluder *ns.Matcher
+	// splitSize is the largest BSON file segment for --splitSize
+	splitSize int64
+	// retryBackoff is the delay before the first retry of a failed cursor
+	retryBackoff time.Duration
+	// shardReadPrefs are the read preferences of --shardReadPreference, by
+	// shard ID
+	shardReadPrefs map[string]*readpref.ReadPref
+	// maxReplicationLag is the parsed value of --maxReplicationLag
+	maxReplicationLag time.Duration
+	// requireOplogWindow is the parsed value of --requireOplogWindow
+	requireOplogWindow time.Duration
+	// changeMarker is the oplog time recorded in the manifest for a later
+	// --reuseUnchangedFrom, and previous is the dump given by that option
+	changeMarker *primitive.Timestamp
+	previous     *previousDump
+	// daemon runs the scheduled dumps with --daemon
+	daemon *dumpDaemon
+	// dataKey encrypts the output with --encrypt, and keyInfo is how it is
+	// recorded in the dump
+	dataKey []byte
+	keyInfo *encryption.KeyInfo
+	// corruption checks the documents dumped with --validateDocuments
+	corruption *corruptionReport
+	// manifest records the files of the dump
+	manifest *dumpManifest
 	// shutdownIntentsNotifier is provided to the multiplexer
 	// as well as the signal handler, and allows them to notify
-	// the intent dumpers that they should shutdown
+	// the intent dumpers that they should shutdown. It is set by
+	// startShutdownNotifier under notifierMutex, since the signal
+	// handler can run at any time.
 	shutdownIntentsNotifier *notifier
+	notifierMutex           sync.Mutex
+	// shutdown records the collections completed, so that a dump
+	// interrupted by a signal can be finished with the rest marked
+	// incomplete
+	shutdown shutdownState
 	// Writer to take care of BSON output when not writing to the local filesystem.
 	// This is initialized to os.Stdout if unset.
 	OutputWriter io.Writer
@@ -88,21 +174,55 @@ type notifier struct {
 
 func (n *notifier) Notify() { n.once.Do(func() { close(n.notified) }) }
 
+// isNotified returns whether Notify has been called.
+func (n *notifier) isNotified() bool {
+	select {
+	case <-n.notified:
+		return true
+	default:
+		return false
+	}
+}
+
 func newNotifier() *notifier { return &notifier{notified: make(chan struct{})} }
 
+// startShutdownNotifier creates the notifier for the intent dumpers, which
+// is notified at once if the dump was interrupted before it was created.
+func (dump *MongoDump) startShutdownNotifier() {
+	dump.notifierMutex.Lock()
+	dump.shutdownIntentsNotifier = newNotifier()
+	dump.notifierMutex.Unlock()
+	// HandleInterrupt records the interrupt before it looks for the
+	// notifier, so either it notifies this one or this sees the interrupt
+	if dump.shutdown.isInterrupted() {
+		dump.shutdownIntentsNotifier.Notify()
+	}
+}
+
 // ValidateOptions checks for any incompatible sets of options.
 func (dump *MongoDump) ValidateOptions() error {
+	codec, err := dump.OutputOptions.Codec()
+	if err != nil {
+		return err
+	}
 	switch {
-	case dump.OutputOptions.Out == "-" && dump.ToolOptions.Namespace.Collection == "":
+	case dump.collectionToStdout() && dump.ToolOptions.Namespace.Collection == "":
 		return fmt.Errorf("can only dump a single collection to stdout")
 	case dump.ToolOptions.Namespace.DB == "" && dump.ToolOptions.Namespace.Collection != "":
 		return fmt.Errorf("cannot dump a collection without a specified database")
+	case dump.OutputOptions.Incremental && dump.InputOptions.HasQuery():
+		return fmt.Errorf("--incremental cannot be used with --query, --queryFile or --namespaceQueryFile, " +
+			"since the oplog entries it dumps are not filtered")
 	case dump.InputOptions.Query != "" && dump.ToolOptions.Namespace.Collection == "":
 		return fmt.Errorf("cannot dump using a query without a specified collection")
 	case dump.InputOptions.QueryFile != "" && dump.ToolOptions.Namespace.Collection == "":
 		return fmt.Errorf("cannot dump using a queryFile without a specified collection")
 	case dump.InputOptions.Query != "" && dump.InputOptions.QueryFile != "":
 		return fmt.Errorf("either query or queryFile can be specified as a query option, not both")
+	case dump.InputOptions.NamespaceQueryFile != "" && (dump.InputOptions.Query != "" || dump.InputOptions.QueryFile != ""):
+		return fmt.Errorf("--namespaceQueryFile cannot be used with --query or --queryFile")
+	case dump.InputOptions.NamespaceQueryFile != "" && dump.ToolOptions.Namespace.Collection != "":
+		return fmt.Errorf("--namespaceQueryFile cannot be used with --collection; use --query or --queryFile")
 	case dump.InputOptions.Query != "" && dump.InputOptions.TableScan:
 		return fmt.Errorf("cannot use --forceTableScan when specifying --query")
 	case dump.OutputOptions.DumpDBUsersAndRoles && dump.ToolOptions.Namespace.DB == "":
@@ -111,20 +231,193 @@ func (dump *MongoDump) ValidateOptions() error {
 		return fmt.Errorf("cannot specify a collection when running with dumpDbUsersAndRoles")
 	case dump.OutputOptions.Oplog && dump.ToolOptions.Namespace.DB != "":
 		return fmt.Errorf("--oplog mode only supported on full dumps")
+	case dump.InputOptions.Pipeline != "" && dump.InputOptions.PipelineFile != "":
+		return fmt.Errorf("either pipeline or pipelineFile can be specified, not both")
+	case dump.InputOptions.HasPipeline() && (dump.checkpointsEnabled() || dump.OutputOptions.Incremental ||
+		dump.dataExcluded() || dump.OutputOptions.TimeseriesBuckets || dump.OutputOptions.CoordinateShards):
+		return fmt.Errorf("--pipeline and --pipelineFile cannot be used with --resume, --checkpointFile, --incremental, " +
+			"--metadataOnly, --indexesOnly, --timeseriesBuckets or --coordinateShards")
+	case dump.InputOptions.HasSample() && (dump.checkpointsEnabled() || dump.OutputOptions.Incremental ||
+		dump.dataExcluded() || dump.OutputOptions.TimeseriesBuckets || dump.OutputOptions.CoordinateShards):
+		return fmt.Errorf("--sample and --sampleDocs cannot be used with --resume, --checkpointFile, --incremental, " +
+			"--metadataOnly, --indexesOnly, --timeseriesBuckets or --coordinateShards")
+	case len(dump.InputOptions.SampleReference) > 0 && !dump.InputOptions.HasSample():
+		return fmt.Errorf("--sampleReference requires --sample or --sampleDocs")
+	case (dump.OutputOptions.CorruptDocumentsFile != "" || dump.OutputOptions.SkipCorruptDocuments) &&
+		!dump.OutputOptions.ValidateDocuments:
+		return fmt.Errorf("--corruptDocumentsFile and --skipCorruptDocuments require --validateDocuments")
+	case dump.OutputOptions.RequireOplogWindow != "" && !dump.OutputOptions.Oplog:
+		return fmt.Errorf("--requireOplogWindow can only be used with --oplog")
+	case dump.InputOptions.MaxRetries < 0:
+		return fmt.Errorf("maxRetries must not be negative")
+	case dump.OutputOptions.RateLimit < 0:
+		return fmt.Errorf("rateLimit must be positive")
+	case dump.OutputOptions.MaxDocsPerSecond < 0:
+		return fmt.Errorf("maxDocsPerSecond must be positive")
+	case dump.OutputOptions.Encrypt && (dump.OutputOptions.KeyFile == "") == (dump.OutputOptions.KMSProvider == ""):
+		return fmt.Errorf("--encrypt requires one of --keyFile or --kmsProvider")
+	case !dump.OutputOptions.Encrypt && (dump.OutputOptions.KeyFile != "" || dump.OutputOptions.KMSProvider != ""):
+		return fmt.Errorf("--keyFile and --kmsProvider can only be used with --encrypt")
+	case dump.OutputOptions.Encrypt && dump.collectionToStdout():
+		return fmt.Errorf("--encrypt cannot be used when dumping a collection to stdout; use --archive instead")
+	case dump.OutputOptions.Encrypt && dump.checkpointsEnabled():
+		return fmt.Errorf("--encrypt cannot be used with checkpoints")
+	case dump.OutputOptions.SplitSize != "" && (dump.OutputOptions.Archive != "" || dump.collectionToStdout() ||
+		dump.tarOutputEnabled()):
+		return fmt.Errorf("--splitSize can only be used when dumping to a directory")
+	case dump.OutputOptions.SplitSize != "" && dump.checkpointsEnabled():
+		return fmt.Errorf("--splitSize cannot be used with --resume or --checkpointFile")
+	case dump.OutputOptions.TimeseriesBuckets && dump.InputOptions.HasQuery():
+		return fmt.Errorf("--query and --queryFile cannot be used with --timeseriesBuckets, since they filter measurements")
+	case dump.OutputOptions.MetadataOnly && dump.OutputOptions.IndexesOnly:
+		return fmt.Errorf("--metadataOnly and --indexesOnly cannot be used together")
+	case dump.dataExcluded() && dump.collectionToStdout():
+		return fmt.Errorf("--metadataOnly and --indexesOnly cannot be used when dumping a collection to stdout")
+	case dump.dataExcluded() && dump.InputOptions.HasQuery():
+		return fmt.Errorf("--query and --queryFile cannot be used with --metadataOnly or --indexesOnly")
+	case dump.dataExcluded() && (dump.OutputOptions.Oplog || dump.OutputOptions.Incremental):
+		return fmt.Errorf("--oplog and --incremental cannot be used with --metadataOnly or --indexesOnly")
+	case dump.dataExcluded() && dump.checkpointsEnabled():
+		return fmt.Errorf("--metadataOnly and --indexesOnly cannot be used with --resume or --checkpointFile")
+	case dump.OutputOptions.IndexesOnly && (dump.OutputOptions.DumpDBUsersAndRoles || dump.OutputOptions.ViewsAsCollections):
+		return fmt.Errorf("--indexesOnly cannot be used with --dumpDbUsersAndRoles or --viewsAsCollections")
+	case dump.snapshotEnabled() && dump.OutputOptions.Oplog:
+		return fmt.Errorf("--oplog cannot be used with --snapshot, which reads all collections at the same time")
+	case dump.snapshotEnabled() && dump.OutputOptions.Incremental:
+		return fmt.Errorf("--snapshot cannot be used with --incremental")
+	case dump.snapshotEnabled() && dump.OutputOptions.CoordinateShards:
+		return fmt.Errorf("--snapshot cannot be used with --coordinateShards, which chooses its own cluster time")
+	case dump.snapshotEnabled() && dump.checkpointsEnabled():
+		return fmt.Errorf("--snapshot cannot be used with checkpoints")
+	case len(dump.InputOptions.ShardReadPreference) > 0 && !dump.OutputOptions.CoordinateShards:
+		return fmt.Errorf("--shardReadPreference requires --coordinateShards")
+	case dump.OutputOptions.CoordinateShards && dump.OutputOptions.Archive == "":
+		return fmt.Errorf("--coordinateShards requires --archive")
+	case dump.OutputOptions.CoordinateShards && dump.OutputOptions.Oplog:
+		return fmt.Errorf("--oplog cannot be used with --coordinateShards, which reads all shards at the same time")
+	case dump.OutputOptions.CoordinateShards && dump.OutputOptions.ViewsAsCollections:
+		return fmt.Errorf("--viewsAsCollections cannot be used with --coordinateShards")
+	case dump.OutputOptions.CoordinateShards && dump.OutputOptions.NumParallelChunks > 1:
+		return fmt.Errorf("--numParallelChunksPerCollection cannot be used with --coordinateShards")
 	case len(dump.OutputOptions.ExcludedCollections) > 0 && dump.ToolOptions.Namespace.Collection != "":
 		return fmt.Errorf("--collection is not allowed when --excludeCollection is specified")
 	case len(dump.OutputOptions.ExcludedCollectionPrefixes) > 0 && dump.ToolOptions.Namespace.Collection != "":
 		return fmt.Errorf("--collection is not allowed when --excludeCollectionsWithPrefix is specified")
+	case (len(dump.OutputOptions.NSInclude) > 0 || len(dump.OutputOptions.NSExclude) > 0) &&
+		dump.ToolOptions.Namespace.Collection != "":
+		return fmt.Errorf("--collection is not allowed when --nsInclude or --nsExclude is specified")
+	case (len(dump.OutputOptions.NSInclude) > 0 || len(dump.OutputOptions.NSExclude) > 0) && dump.OutputOptions.Incremental:
+		return fmt.Errorf("--nsInclude and --nsExclude cannot be used with --incremental")
 	case len(dump.OutputOptions.ExcludedCollections) > 0 && dump.ToolOptions.Namespace.DB == "":
 		return fmt.Errorf("--db is required when --excludeCollection is specified")
 	case len(dump.OutputOptions.ExcludedCollectionPrefixes) > 0 && dump.ToolOptions.Namespace.DB == "":
 		return fmt.Errorf("--db is required when --excludeCollectionsWithPrefix is specified")
 	case dump.OutputOptions.Out != "" && dump.OutputOptions.Archive != "":
 		return fmt.Errorf("--out not allowed when --archive is specified")
-	case dump.OutputOptions.Out == "-" && dump.OutputOptions.Gzip:
+	case dump.OutputOptions.OutFormat != "" && dump.OutputOptions.OutFormat != DirectoryFormat && !dump.tarOutputEnabled():
+		return fmt.Errorf("unknown --outFormat '%v'; expected %v, %v or %v",
+			dump.OutputOptions.OutFormat, DirectoryFormat, TarFormat, TarGzipFormat)
+	case dump.tarOutputEnabled() && dump.OutputOptions.Out == "":
+		return fmt.Errorf("--outFormat %v requires --out", dump.OutputOptions.OutFormat)
+	case dump.collectionToStdout() && !codec.IsNone():
 		return fmt.Errorf("compression can't be used when dumping a single collection to standard output")
+	case (dump.OutputOptions.Out == "-" || dump.OutputOptions.Archive == "-") && dump.ToolOptions.LogsToStdout():
+		return fmt.Errorf("--logSplitStreams and --progressJson=- cannot be used when dumping to standard output")
+	case len(dump.OutputOptions.TeeArchive) > 0 && dump.OutputOptions.Archive == "":
+		return fmt.Errorf("--teeArchive requires --archive")
+	case dump.teeArchiveConflict() != "":
+		return fmt.Errorf("--teeArchive %v must be a different location than --archive and the other copies",
+			dump.teeArchiveConflict())
+	case dump.OutputOptions.DeltaBase != "" && (dump.OutputOptions.Archive == "" || dump.OutputOptions.DeltaBase == "-" ||
+		storage.IsRemote(dump.OutputOptions.DeltaBase)):
+		return fmt.Errorf("--deltaBase requires --archive, and must be a local file")
+	case dump.OutputOptions.DeltaBase != "" && (!codec.IsNone() || dump.OutputOptions.Encrypt):
+		return fmt.Errorf("--deltaBase cannot be used with compression or --encrypt, which change every block of the archive")
+	case dump.OutputOptions.DeltaBase != "" && filepath.Clean(dump.OutputOptions.DeltaBase) == filepath.Clean(dump.OutputOptions.Archive):
+		return fmt.Errorf("--deltaBase must be a different file than --archive")
+	case dump.OutputOptions.ArchiveIndex && (dump.OutputOptions.Archive == "" || dump.OutputOptions.Archive == "-" ||
+		storage.IsRemote(dump.OutputOptions.Archive)):
+		return fmt.Errorf("--archiveIndex requires --archive to be a local file")
+	case dump.OutputOptions.ArchiveIndex && (!codec.IsNone() || dump.OutputOptions.Encrypt || dump.OutputOptions.DeltaBase != ""):
+		return fmt.Errorf("--archiveIndex cannot be used with compression, --encrypt or --deltaBase, which change where the blocks of the archive are")
+	case dump.OutputOptions.ReuseUnchangedFrom != "" && (dump.OutputOptions.Archive != "" || dump.collectionToStdout() ||
+		dump.tarOutputEnabled() || storage.IsRemote(dump.OutputOptions.Out)):
+		return fmt.Errorf("--reuseUnchangedFrom requires a local output directory")
+	case dump.OutputOptions.ReuseUnchangedFrom != "" && (dump.InputOptions.HasQuery() || dump.InputOptions.HasPipeline() ||
+		dump.InputOptions.HasSample() || dump.OutputOptions.Encrypt || dump.OutputOptions.Incremental || dump.dataExcluded() || dump.checkpointsEnabled() ||
+		dump.OutputOptions.CoordinateShards):
+		return fmt.Errorf("--reuseUnchangedFrom cannot be used with --query, --queryFile, --pipeline, --pipelineFile, " +
+			"--sample, --sampleDocs, --encrypt, --incremental, --metadataOnly, --indexesOnly, --resume, --checkpointFile or --coordinateShards")
+	case dump.OutputOptions.ReuseUnchangedFrom != "" &&
+		filepath.Clean(dump.OutputOptions.ReuseUnchangedFrom) == filepath.Clean(dump.outputRoot()):
+		return fmt.Errorf("--reuseUnchangedFrom must be a different directory than --out")
+	case dump.OutputOptions.OutputMapFile != "" && (dump.OutputOptions.Archive != "" || dump.collectionToStdout() ||
+		dump.tarOutputEnabled()):
+		return fmt.Errorf("--outputMapFile can only be used when dumping to a directory or object storage")
+	case dump.OutputOptions.OutputMapFile != "" && (dump.OutputOptions.Encrypt || dump.checkpointsEnabled() ||
+		dump.OutputOptions.ReuseUnchangedFrom != ""):
+		return fmt.Errorf("--outputMapFile cannot be used with --encrypt, --resume, --checkpointFile or --reuseUnchangedFrom")
+	case dump.InputOptions.SourceType != "" && dump.InputOptions.SourceType != SourceAuto &&
+		dump.InputOptions.SourceType != SourceStandard && dump.InputOptions.SourceType != SourceServerless &&
+		dump.InputOptions.SourceType != SourceDataFederation:
+		return fmt.Errorf("unknown --sourceType '%v'; expected %v, %v, %v or %v", dump.InputOptions.SourceType,
+			SourceAuto, SourceStandard, SourceServerless, SourceDataFederation)
 	case dump.OutputOptions.NumParallelCollections <= 0:
 		return fmt.Errorf("numParallelCollections must be positive")
+	case dump.OutputOptions.NumParallelChunks < 0:
+		return fmt.Errorf("numParallelChunksPerCollection must be positive")
+	case dump.OutputOptions.CompressionWorkers < 0:
+		return fmt.Errorf("--compressionWorkers must not be negative")
+	case dump.OutputOptions.CompressionWorkers > 1 && codec.Name != compression.GzipName && codec.Name != compression.ZstdName &&
+		dump.OutputOptions.OutFormat != TarGzipFormat:
+		return fmt.Errorf("--compressionWorkers requires gzip or zstd compression, or --outFormat=%v", TarGzipFormat)
+	case dump.OutputOptions.NumParallelChunks > 1 && dump.checkpointsEnabled():
+		return fmt.Errorf("--numParallelChunksPerCollection cannot be used with --resume or --checkpointFile")
+	case dump.InputOptions.TableScan && dump.checkpointsEnabled():
+		return fmt.Errorf("--forceTableScan cannot be used with --resume or --checkpointFile, which read collections in _id order")
+	case dump.checkpointsEnabled() && (dump.OutputOptions.Archive != "" || dump.collectionToStdout() ||
+		storage.IsRemote(dump.OutputOptions.Out) || dump.tarOutputEnabled()):
+		return fmt.Errorf("--resume and --checkpointFile can only be used when dumping to a local directory")
+	case dump.checkpointsEnabled() && !codec.IsNone():
+		return fmt.Errorf("--resume and --checkpointFile cannot be used with compression")
+	case dump.checkpointsEnabled() && dump.OutputOptions.Oplog:
+		return fmt.Errorf("--resume and --checkpointFile cannot be used with --oplog")
+	case dump.OutputOptions.Incremental && dump.OutputOptions.Since == "":
+		return fmt.Errorf("--incremental requires --since")
+	case dump.OutputOptions.Since != "" && !dump.OutputOptions.Incremental:
+		return fmt.Errorf("--since can only be used with --incremental")
+	case dump.OutputOptions.Incremental && dump.ToolOptions.Namespace.DB != "":
+		return fmt.Errorf("--incremental mode only supported on full dumps")
+	case dump.OutputOptions.Incremental && dump.OutputOptions.Oplog:
+		return fmt.Errorf("--incremental cannot be used with --oplog")
+	case dump.OutputOptions.Incremental && dump.checkpointsEnabled():
+		return fmt.Errorf("--incremental cannot be used with --resume or --checkpointFile")
+	case dump.OutputOptions.DryRun && (dump.OutputOptions.Incremental || dump.checkpointsEnabled()):
+		return fmt.Errorf("--dryRun cannot be used with --incremental, --resume or --checkpointFile")
+	case dump.OutputOptions.DBHash && (dump.OutputOptions.Archive == "-" || dump.collectionToStdout()):
+		return fmt.Errorf("--dbHash cannot be used when writing to stdout, which has no manifest")
+	}
+	return dump.validateDaemonOptions()
+}
+
+// validateDaemonOptions checks the options of --daemon.
+func (dump *MongoDump) validateDaemonOptions() error {
+	daemon := dump.DaemonOptions
+	if daemon == nil {
+		return nil
+	}
+	switch {
+	case !daemon.Daemon && (daemon.Schedule != "" || daemon.Retention != 0 || daemon.StatusAddr != ""):
+		return fmt.Errorf("--schedule, --retention and --statusAddr can only be used with --daemon")
+	case daemon.Daemon && daemon.Schedule == "":
+		return fmt.Errorf("--daemon requires --schedule")
+	case daemon.Retention < 0:
+		return fmt.Errorf("--retention must not be negative")
+	case daemon.Daemon && (dump.OutputOptions.Archive != "" || dump.OutputOptions.Out == "-" || dump.tarOutputEnabled()):
+		return fmt.Errorf("--daemon requires --out to be a directory or object storage location")
+	case daemon.Daemon && (dump.OutputOptions.DryRun || dump.OutputOptions.Incremental || dump.checkpointsEnabled() ||
+		dump.OutputOptions.ReuseUnchangedFrom != "" || dump.OutputOptions.OutputMapFile != ""):
+		return fmt.Errorf("--daemon cannot be used with --dryRun, --incremental, --resume, --checkpointFile, --reuseUnchangedFrom or --outputMapFile")
 	}
 	return nil
 }
@@ -139,11 +432,98 @@ func (dump *MongoDump) Init() error {
 
 	err := dump.ValidateOptions()
 	if err != nil {
-		return fmt.Errorf("bad option: %v", err)
+		return util.WithErrorCode(util.ErrCodeBadOptions, fmt.Errorf("bad option: %v", err))
+	}
+	if dump.OutputOptions.Incremental {
+		dump.incrementalSince, err = util.ParseTimestamp(dump.OutputOptions.Since)
+		if err != nil {
+			return util.WithErrorCode(util.ErrCodeBadOptions,
+				fmt.Errorf("error parsing timestamp argument to --since: %v", err))
+		}
+	}
+	if dump.InputOptions.AtClusterTime != "" {
+		ts, err := util.ParseTimestamp(dump.InputOptions.AtClusterTime)
+		if err != nil {
+			return util.WithErrorCode(util.ErrCodeBadOptions,
+				fmt.Errorf("error parsing timestamp argument to --atClusterTime: %v", err))
+		}
+		dump.snapshotTime = &ts
+	}
+	if dump.OutputOptions.Encrypt {
+		if err = dump.initEncryption(); err != nil {
+			return util.WithErrorCode(util.ErrCodeBadOptions, err)
+		}
+	}
+	if err = dump.initNamespaceMatchers(); err != nil {
+		return util.WithErrorCode(util.ErrCodeBadOptions, err)
+	}
+	if dump.OutputOptions.SplitSize != "" {
+		dump.splitSize, err = text.ParseByteAmount(dump.OutputOptions.SplitSize)
+		if err == nil && dump.splitSize <= 0 {
+			err = fmt.Errorf("size must be positive")
+		}
+		if err != nil {
+			return util.WithErrorCode(util.ErrCodeBadOptions, fmt.Errorf("error parsing --splitSize: %v", err))
+		}
+	}
+	if dump.InputOptions.RetryBackoff != "" {
+		dump.retryBackoff, err = time.ParseDuration(dump.InputOptions.RetryBackoff)
+		if err == nil && dump.retryBackoff < 0 {
+			err = fmt.Errorf("duration must not be negative")
+		}
+		if err != nil {
+			return util.WithErrorCode(util.ErrCodeBadOptions, fmt.Errorf("error parsing --retryBackoff: %v", err))
+		}
+	}
+	if dump.InputOptions.MaxReplicationLag != "" {
+		dump.maxReplicationLag, err = time.ParseDuration(dump.InputOptions.MaxReplicationLag)
+		if err == nil && dump.maxReplicationLag <= 0 {
+			err = fmt.Errorf("duration must be positive")
+		}
+		if err != nil {
+			return util.WithErrorCode(util.ErrCodeBadOptions, fmt.Errorf("error parsing --maxReplicationLag: %v", err))
+		}
+	}
+	if dump.OutputOptions.RequireOplogWindow != "" {
+		dump.requireOplogWindow, err = time.ParseDuration(dump.OutputOptions.RequireOplogWindow)
+		if err == nil && dump.requireOplogWindow <= 0 {
+			err = fmt.Errorf("duration must be positive")
+		}
+		if err != nil {
+			return util.WithErrorCode(util.ErrCodeBadOptions, fmt.Errorf("error parsing --requireOplogWindow: %v", err))
+		}
+	}
+	if len(dump.InputOptions.ShardReadPreference) > 0 {
+		dump.shardReadPrefs, err = parseShardReadPreferences(dump.InputOptions.ShardReadPreference)
+		if err != nil {
+			return util.WithErrorCode(util.ErrCodeBadOptions, fmt.Errorf("error parsing --shardReadPreference: %v", err))
+		}
+	}
+	if dump.OutputOptions.RateLimit > 0 {
+		dump.byteLimiter = util.NewRateLimiter(dump.OutputOptions.RateLimit * 1024 * 1024)
+	}
+	if dump.OutputOptions.MaxDocsPerSecond > 0 {
+		dump.docLimiter = util.NewRateLimiter(float64(dump.OutputOptions.MaxDocsPerSecond))
 	}
 	if dump.OutputWriter == nil {
 		dump.OutputWriter = os.Stdout
 	}
+	if dump.daemonEnabled() {
+		// each scheduled dump is initialized when it runs
+		return dump.initDaemon()
+	}
+	if storage.IsRemote(dump.OutputOptions.Out) && !dump.tarOutputEnabled() {
+		dump.outputStorage, err = storage.Open(dump.OutputOptions.Out)
+		if err != nil {
+			return util.WithErrorCode(util.ErrCodeBadOptions, err)
+		}
+	}
+	dump.manifest = dump.newManifest()
+	if dump.OutputOptions.OutputMapFile != "" {
+		if err = dump.initOutputMap(); err != nil {
+			return util.WithErrorCode(util.ErrCodeBadOptions, err)
+		}
+	}
 
 	pref, err := db.NewReadPreference(dump.InputOptions.ReadPreference, dump.ToolOptions.URI.ParsedConnString())
 	if err != nil {
@@ -161,9 +541,22 @@ func (dump *MongoDump) Init() error {
 		return fmt.Errorf("error checking for Mongos: %v", err)
 	}
 
+	if err = dump.initSource(); err != nil {
+		return err
+	}
+
 	if dump.isMongos && dump.OutputOptions.Oplog {
 		return fmt.Errorf("can't use --oplog option when dumping from a mongos")
 	}
+	if dump.isMongos && dump.OutputOptions.Incremental {
+		return fmt.Errorf("can't use --incremental option when dumping from a mongos")
+	}
+	if !dump.isMongos && dump.OutputOptions.CoordinateShards {
+		return fmt.Errorf("can only use --coordinateShards when dumping from a mongos")
+	}
+	if dump.isMongos && dump.maxReplicationLag > 0 && !dump.OutputOptions.CoordinateShards {
+		return fmt.Errorf("can only use --maxReplicationLag with a mongos when the shards are read directly with --coordinateShards")
+	}
 
 	// warn if we are trying to dump from a secondary in a sharded cluster
 	if dump.isMongos && pref != readpref.Primary() {
@@ -191,35 +584,106 @@ func (dump *MongoDump) verifyCollectionExists() (bool, error) {
 	return collInfo != nil, nil
 }
 
+// createIntents creates the intents for the namespaces given by the options.
+func (dump *MongoDump) createIntents() (err error) {
+	// switch on what kind of execution to do
+	switch {
+	case dump.ToolOptions.DB == "" && dump.ToolOptions.Collection == "":
+		err = dump.CreateAllIntents()
+	case dump.ToolOptions.DB != "" && dump.ToolOptions.Collection == "":
+		err = dump.CreateIntentsForDatabase(dump.ToolOptions.DB)
+	case dump.ToolOptions.DB != "" && dump.ToolOptions.Collection != "":
+		err = dump.CreateCollectionIntent(dump.ToolOptions.DB, dump.ToolOptions.Collection)
+	}
+	if err != nil {
+		return fmt.Errorf("error creating intents to dump: %v", err)
+	}
+	return dump.checkNamespaceQueries()
+}
+
 // Dump handles some final options checking and executes MongoDump.
 func (dump *MongoDump) Dump() (err error) {
 	defer dump.SessionProvider.Close()
+	defer func() {
+		if reportErr := dump.corruption.finish(); err == nil {
+			err = reportErr
+		}
+	}()
 
 	exists, err := dump.verifyCollectionExists()
 	if err != nil {
 		return fmt.Errorf("error verifying collection info: %v", err)
 	}
 	if !exists {
-		log.Logvf(log.Always, "namespace with DB %s and collection %s does not exist",
+		log.Logmf(log.Always, msgNamespaceMissing,
 			dump.ToolOptions.Namespace.DB, dump.ToolOptions.Namespace.Collection)
 		return nil
 	}
 
 	log.Logvf(log.DebugHigh, "starting Dump()")
 
-	dump.shutdownIntentsNotifier = newNotifier()
+	dump.startShutdownNotifier()
 
+	var queryContent []byte
 	if dump.InputOptions.HasQuery() {
-		content, err := dump.InputOptions.GetQuery()
+		queryContent, err = dump.InputOptions.GetQuery()
 		if err != nil {
 			return err
 		}
-		var query bson.D
-		err = bson.UnmarshalExtJSON(content, false, &query)
+		if dump.InputOptions.NamespaceQueryFile != "" {
+			dump.namespaceQueries, err = parseNamespaceQueries(queryContent, dump.ToolOptions.Namespace.DB)
+			if err != nil {
+				return err
+			}
+		} else {
+			var query bson.D
+			err = bson.UnmarshalExtJSON(queryContent, false, &query)
+			if err != nil {
+				return fmt.Errorf("error parsing query as Extended JSON: %v", err)
+			}
+			dump.query = query
+		}
+	}
+	if dump.InputOptions.HasPipeline() {
+		pipelineContent, err := dump.InputOptions.GetPipeline()
 		if err != nil {
-			return fmt.Errorf("error parsing query as Extended JSON: %v", err)
+			return err
+		}
+		dump.pipelines, err = parsePipelines(pipelineContent,
+			dump.ToolOptions.Namespace.DB, dump.ToolOptions.Namespace.Collection)
+		if err != nil {
+			return err
+		}
+	}
+	if dump.InputOptions.HasSample() {
+		dump.samples, err = parseSampleSizes(dump.InputOptions.Sample, dump.InputOptions.SampleDocs, dump.ToolOptions.Namespace.DB)
+		if err != nil {
+			return util.WithErrorCode(util.ErrCodeBadOptions, err)
+		}
+	}
+	if len(dump.InputOptions.SampleReference) > 0 {
+		references, err := parseSampleReferences(dump.InputOptions.SampleReference, dump.ToolOptions.Namespace.DB)
+		if err != nil {
+			return util.WithErrorCode(util.ErrCodeBadOptions, err)
+		}
+		dump.sampleRefs = newSampleReferences(references)
+	}
+
+	if dump.OutputOptions.ValidateDocuments {
+		dump.corruption = &corruptionReport{
+			path: dump.corruptDocumentsPath(),
+			skip: dump.OutputOptions.SkipCorruptDocuments,
+		}
+	}
+
+	if dump.OutputOptions.DryRun {
+		return dump.DryRun()
+	}
+
+	if dump.checkpointsEnabled() {
+		if err = dump.initCheckpoint(string(queryContent)); err != nil {
+			return err
 		}
-		dump.query = query
 	}
 
 	if !dump.SkipUsersAndRoles && dump.OutputOptions.DumpDBUsersAndRoles {
@@ -238,6 +702,30 @@ func (dump *MongoDump) Dump() (err error) {
 		}
 	}
 
+	// the tar stream is closed after the manifest has been added to it
+	if dump.tarOutputEnabled() {
+		if err = dump.openTarOutput(); err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := dump.tarOut.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+	}
+
+	// the manifest is written once the output has been closed, and so
+	// this runs after the deferred close of an archive
+	defer func() {
+		// an interrupted dump still gets a manifest, listing the
+		// collections that are incomplete
+		if dump.manifest != nil && (err == nil || dump.shutdown.partial) {
+			if manifestErr := dump.writeManifest(); err == nil {
+				err = manifestErr
+			}
+		}
+	}()
+
 	if dump.OutputOptions.Archive != "" {
 		//getArchiveOut gives us a WriteCloser to which we should write the archive
 		var archiveOut io.WriteCloser
@@ -245,18 +733,38 @@ func (dump *MongoDump) Dump() (err error) {
 		if err != nil {
 			return err
 		}
+		// everything after the header of an encrypted archive is written
+		// through the encryption
+		var archiveBody io.WriteCloser = &nopCloseWriter{archiveOut}
+		if dump.dataKey != nil {
+			archiveBody, err = dump.encryptArchive(archiveOut)
+			if err != nil {
+				archiveOut.Close()
+				return err
+			}
+		}
 		dump.archive = &archive.Writer{
 			// The archive.Writer needs its own copy of archiveOut because things
 			// like the prelude are not written by the multiplexer.
-			Out: archiveOut,
-			Mux: archive.NewMultiplexer(archiveOut, dump.shutdownIntentsNotifier),
+			Out:  archiveOut,
+			Body: archiveBody,
+			Mux:  archive.NewMultiplexer(archiveBody, dump.shutdownIntentsNotifier),
+		}
+		if dump.archiveIndex != nil {
+			dump.archive.Mux.Index = dump.archiveIndex.index
 		}
 		go dump.archive.Mux.Run()
 		defer func() {
 			// The Mux runs until its Control is closed
 			close(dump.archive.Mux.Control)
 			muxErr := <-dump.archive.Mux.Completed
+			if closeErr := archiveBody.Close(); muxErr == nil {
+				muxErr = closeErr
+			}
 			archiveOut.Close()
+			if muxErr == nil && err == nil && dump.archiveIndex != nil {
+				err = dump.writeArchiveIndex()
+			}
 			if muxErr != nil {
 				if err != nil {
 					err = fmt.Errorf("archive writer: %v / %v", err, muxErr)
@@ -279,18 +787,24 @@ func (dump *MongoDump) Dump() (err error) {
 	if err != nil {
 		return fmt.Errorf("error connecting to host: %v", err)
 	}
+	if dump.maxReplicationLag > 0 && !dump.isMongos {
+		if err = dump.checkReplicationLag(dump.SessionProvider, dump.ToolOptions.ReadPreference, "the replica set"); err != nil {
+			return err
+		}
+	}
 
-	// switch on what kind of execution to do
-	switch {
-	case dump.ToolOptions.DB == "" && dump.ToolOptions.Collection == "":
-		err = dump.CreateAllIntents()
-	case dump.ToolOptions.DB != "" && dump.ToolOptions.Collection == "":
-		err = dump.CreateIntentsForDatabase(dump.ToolOptions.DB)
-	case dump.ToolOptions.DB != "" && dump.ToolOptions.Collection != "":
-		err = dump.CreateCollectionIntent(dump.ToolOptions.DB, dump.ToolOptions.Collection)
+	if dump.dataKey != nil && dump.OutputOptions.Archive == "" {
+		if err = dump.writeEncryptionMetadata(); err != nil {
+			return err
+		}
 	}
-	if err != nil {
-		return fmt.Errorf("error creating intents to dump: %v", err)
+
+	if dump.OutputOptions.Incremental {
+		return dump.DumpIncremental()
+	}
+
+	if err = dump.createIntents(); err != nil {
+		return err
 	}
 
 	if dump.OutputOptions.Oplog {
@@ -298,6 +812,23 @@ func (dump *MongoDump) Dump() (err error) {
 		if err != nil {
 			return err
 		}
+		if err = dump.checkOplogWindow(); err != nil {
+			return err
+		}
+	}
+
+	if dump.snapshotEnabled() {
+		if err = dump.initSnapshot(); err != nil {
+			return err
+		}
+	}
+
+	if dump.OutputOptions.CoordinateShards {
+		if err = dump.startShardCoordination(); err != nil {
+			return fmt.Errorf("error coordinating shards: %v", err)
+		}
+		defer dump.finishShardCoordination()
+		dump.shards.recordCollections(dump.manager)
 	}
 
 	if !dump.SkipUsersAndRoles && dump.OutputOptions.DumpDBUsersAndRoles && dump.ToolOptions.DB != "admin" {
@@ -307,30 +838,32 @@ func (dump *MongoDump) Dump() (err error) {
 		}
 	}
 
+	if err = dump.recordChangeMarker(); err != nil {
+		return err
+	}
+	if dump.OutputOptions.ReuseUnchangedFrom != "" {
+		if err = dump.loadPreviousDump(); err != nil {
+			return err
+		}
+	}
+
 	// IO Phase I
 	// metadata, users, roles, and versions
 
 	// TODO, either remove this debug or improve the language
 	log.Logvf(log.DebugHigh, "dump phase I: metadata, indexes, users, roles, version")
 
+	_, metadataSpan := tracing.Start(context.Background(), "dump metadata")
+	defer func() { metadataSpan.End(err) }()
+
 	err = dump.DumpMetadata()
 	if err != nil {
 		return fmt.Errorf("error dumping metadata: %v", err)
 	}
 
 	if dump.OutputOptions.Archive != "" {
-		serverVersion, err := dump.SessionProvider.ServerVersion()
-		if err != nil {
-			log.Logvf(log.Always, "warning, couldn't get version information from server: %v", err)
-			serverVersion = "unknown"
-		}
-		dump.archive.Prelude, err = archive.NewPrelude(dump.manager, dump.OutputOptions.NumParallelCollections, serverVersion, dump.ToolOptions.VersionStr)
-		if err != nil {
-			return fmt.Errorf("creating archive prelude: %v", err)
-		}
-		err = dump.archive.Prelude.Write(dump.archive.Out)
-		if err != nil {
-			return fmt.Errorf("error writing metadata into archive: %v", err)
+		if err = dump.writePrelude(nil); err != nil {
+			return err
 		}
 	}
 
@@ -347,9 +880,9 @@ func (dump *MongoDump) Dump() (err error) {
 			}
 		}
 		if dump.OutputOptions.DumpDBUsersAndRoles {
-			log.Logvf(log.Always, "dumping users and roles for %v", dump.ToolOptions.DB)
+			log.Logmf(log.Always, msgDumpingUsersForDB, dump.ToolOptions.DB)
 			if dump.ToolOptions.DB == "admin" {
-				log.Logvf(log.Always, "skipping users/roles dump, already dumped admin database")
+				log.Logmf(log.Always, msgSkippingAdminUsers)
 			} else {
 				err = dump.DumpUsersAndRolesForDB(dump.ToolOptions.DB)
 				if err != nil {
@@ -375,6 +908,8 @@ func (dump *MongoDump) Dump() (err error) {
 		}
 	}
 
+	metadataSpan.End(nil)
+
 	if failpoint.Enabled(failpoint.PauseBeforeDumping) {
 		log.Logvf(log.Info, "failpoint.PauseBeforeDumping: sleeping 15 sec")
 		time.Sleep(15 * time.Second)
@@ -386,10 +921,17 @@ func (dump *MongoDump) Dump() (err error) {
 	// TODO, either remove this debug or improve the language
 	log.Logvf(log.DebugHigh, "dump phase II: regular collections")
 
-	// begin dumping intents
+	// begin dumping intents, logging how much of the oplog is left before
+	// the oplog entries of the dump are overwritten
+	stopOplogSlack := dump.monitorOplogSlack()
 	if err := dump.DumpIntents(); err != nil {
-		return err
+		stopOplogSlack()
+		if dump.shutdown.isInterrupted() {
+			return dump.finishInterrupted(true)
+		}
+		return util.WithErrorCode(util.ErrCodeDumpData, err)
 	}
+	stopOplogSlack()
 
 	// IO Phase III
 	// oplog
@@ -402,6 +944,9 @@ func (dump *MongoDump) Dump() (err error) {
 	// we check to see if the oplog has rolled over (i.e. the most recent entry when
 	// we started still exist, so we know we haven't lost data)
 	if dump.OutputOptions.Oplog {
+		_, oplogSpan := tracing.Start(context.Background(), "dump oplog")
+		defer func() { oplogSpan.End(err) }()
+
 		dump.oplogEnd, err = dump.getCurrentOplogTime()
 		if err != nil {
 			return fmt.Errorf("error getting oplog end: %v", err)
@@ -410,20 +955,24 @@ func (dump *MongoDump) Dump() (err error) {
 		log.Logvf(log.DebugLow, "checking if oplog entry %v still exists", dump.oplogStart)
 		exists, err := dump.checkOplogTimestampExists(dump.oplogStart)
 		if !exists {
-			return fmt.Errorf(
-				"oplog overflow: mongodump was unable to capture all new oplog entries during execution")
+			return util.WithErrorCode(util.ErrCodeDumpOplogOverflow, fmt.Errorf(
+				"oplog overflow: mongodump was unable to capture all new oplog entries during execution"))
 		}
 		if err != nil {
 			return fmt.Errorf("unable to check oplog for overflow: %v", err)
 		}
 		log.Logvf(log.DebugHigh, "oplog entry %v still exists", dump.oplogStart)
 
-		log.Logvf(log.Always, "writing captured oplog to %v", dump.manager.Oplog().Location)
+		log.Logmf(log.Always, msgWritingOplog, dump.manager.Oplog().Location)
 
 		err = dump.DumpOplogBetweenTimestamps(dump.oplogStart, dump.oplogEnd)
+		if err != nil && dump.shutdown.isInterrupted() {
+			return dump.finishInterrupted(false)
+		}
 		if err != nil {
-			return fmt.Errorf("error dumping oplog: %v", err)
+			return util.WithErrorCode(util.ErrCodeDumpOplog, fmt.Errorf("error dumping oplog: %v", err))
 		}
+		dump.shutdown.complete(dump.manager.Oplog())
 
 		// check the oplog for a rollover one last time, to avoid a race condition
 		// wherein the oplog rolls over in the time after our first check, but before
@@ -431,13 +980,26 @@ func (dump *MongoDump) Dump() (err error) {
 		log.Logvf(log.DebugLow, "checking again if oplog entry %v still exists", dump.oplogStart)
 		exists, err = dump.checkOplogTimestampExists(dump.oplogStart)
 		if !exists {
-			return fmt.Errorf(
-				"oplog overflow: mongodump was unable to capture all new oplog entries during execution")
+			return util.WithErrorCode(util.ErrCodeDumpOplogOverflow, fmt.Errorf(
+				"oplog overflow: mongodump was unable to capture all new oplog entries during execution"))
 		}
 		if err != nil {
 			return fmt.Errorf("unable to check oplog for overflow: %v", err)
 		}
 		log.Logvf(log.DebugHigh, "oplog entry %v still exists", dump.oplogStart)
+		log.Logvf(log.Always, "to take an incremental dump from this point, use --incremental --since=%v",
+			util.FormatTimestamp(dump.oplogEnd))
+	}
+
+	if dump.snapshotTime != nil && !dump.isMongos {
+		log.Logvf(log.Always, "to take an incremental dump from this point, use --incremental --since=%v",
+			util.FormatTimestamp(*dump.snapshotTime))
+	}
+
+	if dump.checkpoint != nil {
+		if err = dump.checkpoint.remove(); err != nil {
+			return fmt.Errorf("error removing checkpoint file: %v", err)
+		}
 	}
 
 	log.Logvf(log.DebugLow, "finishing dump")
@@ -445,6 +1007,51 @@ func (dump *MongoDump) Dump() (err error) {
 	return err
 }
 
+// snapshotEnabled returns true if every collection is read at the same
+// cluster time.
+func (dump *MongoDump) snapshotEnabled() bool {
+	return dump.InputOptions.Snapshot || dump.InputOptions.AtClusterTime != ""
+}
+
+// checkpointsEnabled returns true if the dump records its progress in a
+// checkpoint file.
+func (dump *MongoDump) checkpointsEnabled() bool {
+	return dump.OutputOptions.Resume || dump.OutputOptions.CheckpointFile != ""
+}
+
+// dataExcluded returns whether collection data is left out of the dump by
+// --metadataOnly or --indexesOnly.
+func (dump *MongoDump) dataExcluded() bool {
+	return dump.OutputOptions.MetadataOnly || dump.OutputOptions.IndexesOnly
+}
+
+// dumpsBuckets returns whether the intent's collection is a time-series
+// collection whose buckets are dumped in place of its measurements.
+func (dump *MongoDump) dumpsBuckets(intent *intents.Intent) bool {
+	return dump.OutputOptions.TimeseriesBuckets && intent.IsTimeseries()
+}
+
+// skipsData returns whether the documents of the intent's collection are
+// left out of the dump. --metadataOnly still dumps users, roles and the auth
+// version, and both modes dump system.indexes, which holds the indexes of
+// old servers.
+func (dump *MongoDump) skipsData(intent *intents.Intent) bool {
+	switch {
+	case intent.IsSystemIndexes():
+		return false
+	case intent.IsUsers(), intent.IsRoles(), intent.IsAuthVersion():
+		return dump.OutputOptions.IndexesOnly
+	}
+	return dump.dataExcluded()
+}
+
+// outputCodec returns the compression for output files or the archive.
+// ValidateOptions reports invalid compression options.
+func (dump *MongoDump) outputCodec() compression.Codec {
+	codec, _ := dump.OutputOptions.Codec()
+	return codec
+}
+
 type resettableOutputBuffer interface {
 	io.Writer
 	Close() error
@@ -462,8 +1069,16 @@ func (w closableBufioWriter) Close() error {
 func (dump *MongoDump) getResettableOutputBuffer() resettableOutputBuffer {
 	if dump.OutputOptions.Archive != "" {
 		return nil
-	} else if dump.OutputOptions.Gzip {
-		return gzip.NewWriter(nil)
+	}
+	return newOutputBuffer(dump.outputCodec())
+}
+
+// newOutputBuffer returns a buffer that compresses with codec, which was
+// checked when the options were parsed.
+func newOutputBuffer(codec compression.Codec) resettableOutputBuffer {
+	if !codec.IsNone() {
+		writer, _ := codec.NewWriter(nil)
+		return writer
 	}
 	return &closableBufioWriter{bufio.NewWriter(nil)}
 }
@@ -478,6 +1093,11 @@ func (dump *MongoDump) DumpIntents() error {
 		jobs = numIntents
 	}
 
+	dump.shutdown.track(dump.manager.Intents())
+	dump.sampleRefs.prepare(dump.manager.Intents(), func(intent *intents.Intent) bool {
+		_, sampled := dump.sampleSize(intent)
+		return sampled
+	})
 	if jobs > 1 {
 		dump.manager.Finalize(intents.LongestTaskFirst)
 	} else {
@@ -489,45 +1109,108 @@ func (dump *MongoDump) DumpIntents() error {
 	// start a goroutine for each job thread
 	for i := 0; i < jobs; i++ {
 		go func(id int) {
+			defer util.RecoverCrash()
 			buffer := dump.getResettableOutputBuffer()
-			log.Logvf(log.DebugHigh, "starting dump routine with id=%v", id)
+			workerLog := log.WithFields("worker", id)
+			workerLog.Logvf(log.DebugHigh, "starting dump routine with id=%v", id)
 			for {
+				// no more collections are started once the dump is stopping
+				if dump.shutdownIntentsNotifier.isNotified() {
+					resultChan <- util.ErrTerminated
+					return
+				}
 				intent := dump.manager.Pop()
 				if intent == nil {
-					log.Logvf(log.DebugHigh, "ending dump routine with id=%v, no more work to do", id)
-					resultChan <- nil
-					return
+					// the samples referred to with --sampleReference are
+					// dumped last, once the collections referring to them are
+					if intent = dump.sampleRefs.nextDeferred(); intent == nil {
+						workerLog.Logvf(log.DebugHigh, "ending dump routine with id=%v, no more work to do", id)
+						resultChan <- nil
+						return
+					}
+					dump.sampleRefs.wait(intent)
+				} else if dump.sampleRefs.deferIntent(intent) {
+					continue
 				}
 				if intent.BSONFile != nil {
-					err := dump.DumpIntent(intent, buffer)
+					err := dump.dumpIntent(intent, dump.intentOutputBuffer(intent, buffer), workerLog)
 					if err != nil {
+						// a collection waiting on this one is left to stop
+						dump.sampleRefs.finish(intent)
 						resultChan <- err
 						return
 					}
+					dump.shutdown.complete(intent)
 				}
+				dump.sampleRefs.finish(intent)
 				dump.manager.Finish(intent)
 			}
 		}(i)
 	}
 
-	// wait until all goroutines are done or one of them errors out
+	// wait until all goroutines are done; the first error stops the others,
+	// which close the collections they are dumping before returning
+	var firstErr error
 	for i := 0; i < jobs; i++ {
-		if err := <-resultChan; err != nil {
-			return err
+		if err := <-resultChan; err != nil && firstErr == nil {
+			firstErr = err
+			dump.shutdownIntentsNotifier.Notify()
 		}
 	}
 
-	return nil
+	return firstErr
 }
 
 // DumpIntent dumps the specified database's collection.
 func (dump *MongoDump) DumpIntent(intent *intents.Intent, buffer resettableOutputBuffer) error {
+	return dump.dumpIntent(intent, buffer, log.WithFields())
+}
+
+// dumpIntent dumps a collection, tagging its log messages with the fields
+// of parentLog and, for JSON log output, the namespace and an operation ID.
+func (dump *MongoDump) dumpIntent(intent *intents.Intent, buffer resettableOutputBuffer, parentLog *log.FieldLogger) (err error) {
+	var dumpCount int64
+	start := time.Now()
+	_, span := tracing.Start(context.Background(), "dump collection", "ns", intent.Namespace())
+	intentLog := parentLog
+	if dump.ToolOptions != nil && dump.ToolOptions.LogsJSON() {
+		// text messages already name the namespace
+		intentLog = intentLog.WithFields("ns", intent.Namespace(), "op", log.NewOperationID())
+	}
+	intentLog = intentLog.WithFields(span.LogFields()...)
+	defer func() {
+		if err == nil {
+			err = dump.runPostDumpHook(intent, dumpCount, time.Since(start), intentLog)
+		}
+		span.SetAttributes("documents", dumpCount)
+		span.End(err)
+		log.Summary().AddNamespace(intent.Namespace(), dumpCount, 0, time.Since(start), err)
+	}()
+
+	if err = dump.runPreDumpHook(intent, intentLog); err != nil {
+		return err
+	}
+	if dump.skipsData(intent) {
+		// like a view, a collection in an archive needs an empty stream for
+		// mongorestore to create it
+		intentLog.Logvf(log.DebugLow, "not dumping documents of %v", intent.Namespace())
+		if err = intent.BSONFile.Open(); err != nil {
+			return err
+		}
+		return intent.BSONFile.Close()
+	}
+	if err = dump.checkSnapshotWindow(); err != nil {
+		return err
+	}
 	session, err := dump.SessionProvider.GetSession()
 	if err != nil {
 		return err
 	}
 	intendedDB := session.Database(intent.DB)
 	coll := intendedDB.Collection(intent.C)
+	if dump.dumpsBuckets(intent) {
+		coll = intendedDB.Collection(db.TimeseriesBucketsPrefix + intent.C)
+	}
 	// it is safer to assume that a collection is a view, if we cannot determine that it is not.
 	isView := true
 	// failure to get CollectionInfo should not cause the function to exit. We only use this to
@@ -554,7 +1237,7 @@ func (dump *MongoDump) DumpIntent(intent *intents.Intent, buffer resettableOutpu
 		dump.storageEngine = storageEngineModern
 		isMMAPV1, err := db.IsMMAPV1(intendedDB, intent.C)
 		if err != nil {
-			log.Logvf(log.Always,
+			intentLog.Logvf(log.Always,
 				"failed to determine storage engine, an mmapv1 storage engine could result in"+
 					" inconsistent dump results, error was: %v", err)
 		} else if isMMAPV1 {
@@ -562,38 +1245,90 @@ func (dump *MongoDump) DumpIntent(intent *intents.Intent, buffer resettableOutpu
 		}
 	}
 
-	findQuery := &db.DeferredQuery{Coll: coll}
-	switch {
-	case len(dump.query) > 0:
-		findQuery.Filter = dump.query
+	if documents, reused, err := dump.reuseUnchanged(intent); err != nil {
+		return err
+	} else if reused {
+		dumpCount = documents
+		intentLog.Logvf(log.Always, "reusing %v from %v, where it is unchanged", intent.Namespace(), dump.previous.root)
+		return nil
+	}
+
+	findQuery := &db.DeferredQuery{Coll: coll, AtClusterTime: dump.snapshotTime, Pipeline: dump.pipeline(intent), BatchSize: dump.source.batchSize}
+	if sample, err := dump.sampleStages(intent, coll); err != nil {
+		return err
+	} else if sample != nil {
+		findQuery.Pipeline = append(sample, findQuery.Pipeline...)
+	}
+	switch filter := dump.queryFilter(intent); {
+	case len(filter) > 0:
+		findQuery.Filter = filter
+	case findQuery.Pipeline != nil:
+		// a pipeline's stages are left to choose their own index
 	// we only want to hint _id when the storage engine is MMAPV1 and this isn't a view, a
-	// special collection, the oplog, and the user is not asking to force table scans.
+	// special collection, the oplog, a capped collection read in insertion order, and the
+	// user is not asking to force table scans.
 	case dump.storageEngine == storageEngineMMAPV1 && !dump.InputOptions.TableScan &&
-		!isView && !intent.IsSpecialCollection() && !intent.IsOplog():
+		!isView && !intent.IsSpecialCollection() && !intent.IsOplog() && !intent.IsCapped():
 		autoIndexId, found := intent.Options["autoIndexId"]
 		if !found || autoIndexId == true {
 			findQuery.Hint = bson.D{{"_id", 1}}
 		}
 	}
 
-	var dumpCount int64
+	checkpoint, err := dump.prepareCheckpoint(intent, findQuery)
+	if err != nil {
+		return err
+	}
+	if checkpoint != nil && checkpoint.Complete {
+		dumpCount = checkpoint.Documents
+		intentLog.Logvf(log.Always, "skipping %v, which was already dumped", intent.Namespace())
+		if file, ok := intent.BSONFile.(*realBSONFile); ok {
+			if err = dump.manifest.addExisting(file.path); err != nil {
+				return err
+			}
+			dump.manifest.setDocuments(file.path, dumpCount)
+			dump.manifest.setCollection(file.path, intent.Namespace(), intent.UUID)
+		}
+		return nil
+	}
+	applyCheckpointOrder(checkpoint, findQuery)
+	if dump.InputOptions.MaxRetries > 0 && findQuery.Hint == nil && findQuery.Filter == nil && findQuery.Pipeline == nil &&
+		!dump.source.noIndexes && canSplitCollection(intent, isView) {
+		// reading in _id order lets a failed cursor resume after the last _id;
+		// a pipeline is resumed by skipping the results already read instead
+		findQuery.Hint = bson.D{{"_id", 1}}
+	}
 
-	if dump.OutputOptions.Out == "-" {
-		log.Logvf(log.Always, "writing %v to stdout", intent.Namespace())
-		dumpCount, err = dump.dumpQueryToIntent(findQuery, intent, buffer)
+	queries := []*db.DeferredQuery{findQuery}
+	switch {
+	case dump.shards != nil:
+		if queries, err = dump.shards.queries(findQuery, intent, isView); err != nil {
+			return err
+		}
+	case dump.OutputOptions.NumParallelChunks > 1 && findQuery.Pipeline == nil && canSplitCollection(intent, isView):
+		if queries, err = dump.splitQuery(findQuery, intentLog); err != nil {
+			return err
+		}
+	}
+	queries = dump.withReferencedDocuments(intent, queries)
+	collector := dump.sampleRefs.collector(intent)
+
+	if dump.collectionToStdout() {
+		intentLog.Logmf(log.Always, msgWritingToStdout, intent.Namespace())
+		dumpCount, err = dump.dumpValidatedQueriesToIntent(queries, intent, buffer, collector)
 		if err == nil {
 			// on success, print the document count
-			log.Logvf(log.Always, "dumped %v %v", dumpCount, docPlural(dumpCount))
+			intentLog.Logmf(log.Always, msgDumpedToStdout, dumpCount, docPlural(dumpCount))
 		}
 		return err
 	}
 
-	log.Logvf(log.Always, "writing %v to %v", intent.Namespace(), intent.Location)
-	if dumpCount, err = dump.dumpQueryToIntent(findQuery, intent, buffer); err != nil {
+	intentLog.Logmf(log.Always, msgWritingCollection, intent.Namespace(), intent.Location)
+	if dumpCount, err = dump.dumpValidatedQueriesToIntent(queries, intent, buffer, collector); err != nil {
 		return err
 	}
 
-	log.Logvf(log.Always, "done dumping %v (%v %v)", intent.Namespace(), dumpCount, docPlural(dumpCount))
+	intentLog.Logmf(log.Always, msgDoneDumpingCollection, intent.Namespace(), dumpCount, docPlural(dumpCount))
 	return nil
 }
 
@@ -612,11 +1347,16 @@ func (dump *MongoDump) dumpQueryToIntent(
 // getCount counts the number of documents in the namespace for the given intent. It does not run the count for
 // the oplog collection to avoid the performance issue in TOOLS-2068.
 func (dump *MongoDump) getCount(query *db.DeferredQuery, intent *intents.Intent) (int64, error) {
-	if len(dump.query) != 0 || intent.IsOplog() {
+	if len(dump.queryFilter(intent)) != 0 || dump.pipeline(intent) != nil || intent.IsOplog() {
 		log.Logvf(log.DebugLow, "not counting query on %v", intent.Namespace())
 		return 0, nil
 	}
 
+	if dump.shards != nil {
+		// a shard's query only reads that shard, so count through the mongos
+		query = &db.DeferredQuery{Coll: dump.SessionProvider.DB(intent.DB).Collection(intent.C)}
+	}
+
 	log.Logvf(log.DebugHigh, "Getting estimated count for %v.%v", query.Coll.Database().Name(), query.Coll.Name())
 	total, err := query.EstimatedDocumentCount()
 	if err != nil {
@@ -633,6 +1373,14 @@ func (dump *MongoDump) getCount(query *db.DeferredQuery, intent *intents.Intent)
 // dumped, and any errors that occurred.
 func (dump *MongoDump) dumpValidatedQueryToIntent(
 	query *db.DeferredQuery, intent *intents.Intent, buffer resettableOutputBuffer, validator documentValidator) (dumpCount int64, err error) {
+	return dump.dumpValidatedQueriesToIntent([]*db.DeferredQuery{query}, intent, buffer, validator)
+}
+
+// dumpValidatedQueriesToIntent is like dumpValidatedQueryToIntent, but runs
+// several queries of the same collection at once and writes all of their
+// results to the intent, in the order they are read.
+func (dump *MongoDump) dumpValidatedQueriesToIntent(
+	queries []*db.DeferredQuery, intent *intents.Intent, buffer resettableOutputBuffer, validator documentValidator) (dumpCount int64, err error) {
 
 	// restore of views from archives require an empty collection as the trigger to create the view
 	// so, we open here before the early return if IsView so that we write an empty collection to the archive
@@ -645,13 +1393,27 @@ func (dump *MongoDump) dumpValidatedQueryToIntent(
 		if err == nil && closeErr != nil {
 			err = fmt.Errorf("error writing data for collection `%v` to disk: %v", intent.Namespace(), closeErr)
 		}
+		if file, ok := intent.BSONFile.(*realBSONFile); ok && err == nil {
+			documents := dumpCount
+			if file.checkpoint != nil {
+				// a resumed file also holds the documents of earlier runs
+				documents = file.checkpoint.Documents
+			}
+			path := file.path
+			if file.splitSize > 0 {
+				// the count of a split file is recorded with its first segment
+				path = util.SegmentPath(path, 0)
+			}
+			dump.manifest.setDocuments(path, documents)
+			dump.manifest.setCollection(path, intent.Namespace(), intent.UUID)
+		}
 	}()
 	// don't dump any data for views being dumped as views
 	if intent.IsView() && !dump.OutputOptions.ViewsAsCollections {
 		return 0, nil
 	}
 
-	total, err := dump.getCount(query, intent)
+	total, err := dump.getCount(queries[0], intent)
 	if err != nil {
 		return 0, err
 	}
@@ -675,13 +1437,47 @@ func (dump *MongoDump) dumpValidatedQueryToIntent(
 		}()
 	}
 
-	cursor, err := query.Iter()
-	if err != nil {
-		return
+	var checkpoint *checkpointWriter
+	if file, ok := intent.BSONFile.(*realBSONFile); ok && file.checkpoint != nil {
+		var flush func() error
+		if flusher, ok := f.(writeFlusher); ok {
+			flush = flusher.Flush
+		}
+		checkpoint = dump.newCheckpointWriter(f, file, flush)
+		dumpProgressor.Set(checkpoint.documents)
+		f = checkpoint
+	}
+
+	validator = dump.corruption.validator(intent, validator)
+
+	// the oplog is read from a start time its contents are validated against,
+	// and a random sample can't be read again, so neither is resumed
+	_, sampled := dump.sampleSize(intent)
+	retry := !intent.IsOplog() && !sampled
+	cursors := make([]documentCursor, 0, len(queries))
+	for _, query := range queries {
+		cursor, err := dump.openCursor(query, retry)
+		if err != nil {
+			for _, cursor := range cursors {
+				cursor.Close(context.Background())
+			}
+			return 0, snapshotWindowError(err)
+		}
+		cursors = append(cursors, cursor)
 	}
-	err = dump.dumpValidatedIterToWriter(cursor, f, dumpProgressor, validator)
+	err = dump.dumpValidatedItersToWriter(cursors, f, dumpProgressor, validator)
 	dumpCount, _ = dumpProgressor.Progress()
-	if err != nil {
+	if checkpoint != nil {
+		// record how far we got even if the dump was interrupted
+		saveErr := checkpoint.save(err == nil)
+		if err == nil {
+			err = saveErr
+		}
+	}
+	if err == util.ErrTerminated {
+		err = util.WithErrorCode(util.ErrCodeInterrupted,
+			fmt.Errorf("dumping collection `%v` was interrupted", intent.Namespace()))
+	} else if err != nil {
 		err = fmt.Errorf("error writing data for collection `%v` to disk: %v", intent.Namespace(), err)
 	}
 	return
@@ -698,62 +1494,103 @@ func (dump *MongoDump) dumpIterToWriter(
 // dumps the iterator's contents to the writer.
 func (dump *MongoDump) dumpValidatedIterToWriter(
 	iter *mongo.Cursor, writer io.Writer, progressCount progress.Updateable, validator documentValidator) error {
-	defer iter.Close(context.Background())
+	return dump.dumpValidatedItersToWriter([]documentCursor{plainCursor{iter}}, writer, progressCount, validator)
+}
+
+// dumpValidatedItersToWriter reads from each cursor at once, and validates and dumps all of their contents to
+// the writer, in the order they arrive.
+func (dump *MongoDump) dumpValidatedItersToWriter(
+	iters []documentCursor, writer io.Writer, progressCount progress.Updateable, validator documentValidator) error {
 	var termErr error
+	// terminated is set by a reader stopped by the shutdown notifier, which
+	// leaves the others to finish their batches
+	var terminated int32
+
+	// the first error, or returning, stops every reader
+	done := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func(err error) {
+		stopOnce.Do(func() {
+			termErr = err
+			close(done)
+		})
+	}
+	var readers sync.WaitGroup
+	defer func() {
+		stop(nil)
+		readers.Wait()
+	}()
 
-	// We run the result iteration in its own goroutine,
+	// We run the result iteration in its own goroutines,
 	// this allows disk i/o to not block reads from the db,
 	// which gives a slight speedup on benchmarks
 	buffChan := make(chan []byte)
-	go func() {
-		ctx := context.Background()
-		for {
-			select {
-			case <-dump.shutdownIntentsNotifier.notified:
-				log.Logvf(log.DebugHigh, "terminating writes")
-				termErr = util.ErrTerminated
-				close(buffChan)
-				return
-			default:
+	for _, iter := range iters {
+		readers.Add(1)
+		go func(iter documentCursor) {
+			defer readers.Done()
+			ctx := context.Background()
+			defer iter.Close(ctx)
+			for {
+				select {
+				case <-dump.shutdownIntentsNotifier.notified:
+					// the documents already fetched are still written, so
+					// the collection ends after the batch in flight
+					if !inBatch(iter) {
+						log.Logvf(log.DebugHigh, "terminating writes")
+						atomic.StoreInt32(&terminated, 1)
+						return
+					}
+				case <-done:
+					return
+				default:
+				}
 				if !iter.Next(ctx) {
 					if err := iter.Err(); err != nil {
-						termErr = err
+						stop(fmt.Errorf("error reading collection: %v", snapshotWindowError(err)))
 					}
-					close(buffChan)
 					return
 				}
 
 				if validator != nil {
-					if err := validator(iter.Current); err != nil {
-						termErr = err
-						close(buffChan)
+					if err := validator(iter.Document()); err == errSkipDocument {
+						continue
+					} else if err != nil {
+						stop(err)
 						return
 					}
 				}
-
-				out := make([]byte, len(iter.Current))
-				copy(out, iter.Current)
-				buffChan <- out
+				dump.docLimiter.Wait(1)
+
+				doc := iter.Document()
+				out := make([]byte, len(doc))
+				copy(out, doc)
+				select {
+				case buffChan <- out:
+				case <-done:
+					return
+				}
 			}
-		}
+		}(iter)
+	}
+	go func() {
+		readers.Wait()
+		close(buffChan)
 	}()
 
 	// while there are still results in the database,
-	// grab results from the goroutine and write them to filesystem
-	for {
-		buff, alive := <-buffChan
-		if !alive {
-			if iter.Err() != nil {
-				return fmt.Errorf("error reading collection: %v", iter.Err())
-			}
-			break
-		}
+	// grab results from the goroutines and write them to filesystem
+	for buff := range buffChan {
+		dump.byteLimiter.Wait(len(buff))
 		_, err := writer.Write(buff)
 		if err != nil {
 			return fmt.Errorf("error writing to file: %v", err)
 		}
 		progressCount.Inc(1)
 	}
+	if termErr == nil && atomic.LoadInt32(&terminated) == 1 {
+		return util.ErrTerminated
+	}
 	return termErr
 }
 
@@ -842,7 +1679,7 @@ func (dump *MongoDump) DumpMetadata() error {
 	buffer := dump.getResettableOutputBuffer()
 	for _, intent := range allIntents {
 		if intent.MetadataFile != nil {
-			err := dump.dumpMetadata(intent, buffer)
+			err := dump.dumpMetadata(intent, dump.intentOutputBuffer(intent, buffer))
 			if err != nil {
 				return err
 			}
@@ -864,31 +1701,74 @@ func (*nopCloseWriter) Close() error {
 func (dump *MongoDump) getArchiveOut() (out io.WriteCloser, err error) {
 	if dump.OutputOptions.Archive == "-" {
 		out = &nopCloseWriter{dump.OutputWriter}
+	} else if storage.IsRemote(dump.OutputOptions.Archive) {
+		out, err = storage.Create(dump.OutputOptions.Archive)
+		if err != nil {
+			return nil, err
+		}
+		out = dump.manifest.trackArchive(dump.OutputOptions.Archive, out)
 	} else {
 		targetStat, err := os.Stat(dump.OutputOptions.Archive)
 		if err == nil && targetStat.IsDir() {
 			defaultArchiveFilePath :=
-				filepath.Join(dump.OutputOptions.Archive, "archive")
-			if dump.OutputOptions.Gzip {
-				defaultArchiveFilePath = defaultArchiveFilePath + ".gz"
-			}
+				filepath.Join(dump.OutputOptions.Archive, "archive") + dump.outputCodec().Extension()
 			out, err = os.Create(defaultArchiveFilePath)
 			if err != nil {
 				return nil, err
 			}
+			out = dump.manifest.trackArchive(defaultArchiveFilePath, out)
+			out = dump.indexArchiveOut(defaultArchiveFilePath, out)
 		} else {
 			out, err = os.Create(dump.OutputOptions.Archive)
 			if err != nil {
 				return nil, err
 			}
+			out = dump.manifest.trackArchive(dump.OutputOptions.Archive, out)
+			out = dump.indexArchiveOut(dump.OutputOptions.Archive, out)
 		}
 	}
-	if dump.OutputOptions.Gzip {
-		return &util.WrappedWriteCloser{gzip.NewWriter(out), out}, nil
+	if out, err = dump.teeArchiveOut(out); err != nil {
+		return nil, err
+	}
+	if out, err = dump.deltaArchiveOut(out); err != nil {
+		return nil, err
+	}
+	// encrypted archives are compressed after the header, by encryptArchive
+	if codec := dump.outputCodec(); !codec.IsNone() && dump.dataKey == nil {
+		writer, err := codec.NewWriter(out)
+		if err != nil {
+			return nil, err
+		}
+		return &util.WrappedWriteCloser{writer, out}, nil
 	}
 	return out, nil
 }
 
+// writePrelude writes the archive prelude. The header records the range of
+// an incremental dump, when it is given, and how the archive was sharded
+// and encrypted.
+func (dump *MongoDump) writePrelude(incremental *archive.IncrementalRange) error {
+	serverVersion, err := dump.SessionProvider.ServerVersion()
+	if err != nil {
+		log.Logvf(log.Always, "warning, couldn't get version information from server: %v", err)
+		serverVersion = "unknown"
+	}
+	dump.archive.Prelude, err = archive.NewPrelude(dump.manager, dump.OutputOptions.NumParallelCollections, serverVersion, dump.ToolOptions.VersionStr)
+	if err != nil {
+		return fmt.Errorf("creating archive prelude: %v", err)
+	}
+	header := dump.archive.Prelude.Header
+	header.Incremental = incremental
+	header.Encryption = dump.keyInfo
+	if dump.shards != nil {
+		header.Sharded = dump.shards.snapshot
+	}
+	if err = dump.archive.Prelude.WriteWithBody(dump.archive.Out, dump.archive.Body); err != nil {
+		return fmt.Errorf("error writing metadata into archive: %v", err)
+	}
+	return nil
+}
+
 // docPlural returns "document" or "documents" depending on the
 // count of documents passed in.
 func docPlural(count int64) string {
@@ -896,7 +1776,15 @@ func docPlural(count int64) string {
 }
 
 func (dump *MongoDump) HandleInterrupt() {
-	if dump.shutdownIntentsNotifier != nil {
-		dump.shutdownIntentsNotifier.Notify()
+	if dump.daemon != nil {
+		dump.daemon.interrupt()
+		return
+	}
+	dump.shutdown.interrupt()
+	dump.notifierMutex.Lock()
+	shutdownNotifier := dump.shutdownIntentsNotifier
+	dump.notifierMutex.Unlock()
+	if shutdownNotifier != nil {
+		shutdownNotifier.Notify()
 	}
 }
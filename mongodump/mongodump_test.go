@@ -21,15 +21,15 @@ import (
 	"testing"
 	"time"
 
-	"github.com/mongodb/mongo-tools-common/bsonutil"
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/failpoint"
-	"github.com/mongodb/mongo-tools-common/json"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/options"
-	"github.com/mongodb/mongo-tools-common/testtype"
-	"github.com/mongodb/mongo-tools-common/testutil"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/failpoint"
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	"github.com/mongodb/mongo-tools/common/util"
 	. "github.com/smartystreets/goconvey/convey"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -514,6 +514,338 @@ func TestMongoDumpValidateOptions(t *testing.T) {
 			So(err.Error(), ShouldContainSubstring, "cannot dump using a query without a specified collection")
 		})
 
+		Convey("--incremental requires --since and a full dump", func() {
+			md.ToolOptions.Namespace.DB = ""
+			md.OutputOptions.Incremental = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+
+			md.OutputOptions.Since = "1500000000:2"
+			So(md.ValidateOptions(), ShouldBeNil)
+
+			md.OutputOptions.Oplog = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Oplog = false
+
+			md.ToolOptions.Namespace.DB = testDB
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--incremental can't be used with a query", func() {
+			md.ToolOptions.Namespace.DB = ""
+			md.OutputOptions.Incremental = true
+			md.OutputOptions.Since = "1500000000:2"
+			for _, set := range []func(){
+				func() { md.InputOptions.Query = `{"a":1}` },
+				func() { md.InputOptions.QueryFile = "query.json" },
+				func() { md.InputOptions.NamespaceQueryFile = "queries.json" },
+			} {
+				md.InputOptions.Query, md.InputOptions.QueryFile, md.InputOptions.NamespaceQueryFile = "", "", ""
+				set()
+				err := md.ValidateOptions()
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "--incremental cannot be used with --query")
+			}
+		})
+
+		Convey("--compress must name a known codec and level", func() {
+			for _, spec := range []string{"zstd", "zstd:3", "lz4", "gzip:9", "none"} {
+				md.OutputOptions.Compress = spec
+				So(md.ValidateOptions(), ShouldBeNil)
+			}
+			for _, spec := range []string{"bzip2", "zstd:0", "lz4:1", "gzip:10"} {
+				md.OutputOptions.Compress = spec
+				So(md.ValidateOptions(), ShouldNotBeNil)
+			}
+
+			md.OutputOptions.Compress = "zstd"
+			md.OutputOptions.Gzip = true
+			err := md.ValidateOptions()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "--gzip cannot be used with --compress")
+			md.OutputOptions.Compress = "gzip"
+			So(md.ValidateOptions(), ShouldBeNil)
+		})
+
+		Convey("--compressionWorkers requires gzip or zstd compression", func() {
+			md.OutputOptions.CompressionWorkers = 4
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Compress = "lz4"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Compress = "zstd:3"
+			So(md.ValidateOptions(), ShouldBeNil)
+			So(md.outputCodec().Workers, ShouldEqual, 4)
+			md.OutputOptions.CompressionWorkers = -1
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--since requires --incremental", func() {
+			md.OutputOptions.Since = "1500000000"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--snapshot can't be used with --oplog or checkpoints", func() {
+			md.InputOptions.Snapshot = true
+			So(md.ValidateOptions(), ShouldBeNil)
+
+			md.OutputOptions.Oplog = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Oplog = false
+
+			md.OutputOptions.Resume = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--rateLimit and --maxDocsPerSecond can't be negative", func() {
+			md.OutputOptions.RateLimit = 0.5
+			md.OutputOptions.MaxDocsPerSecond = 1000
+			So(md.ValidateOptions(), ShouldBeNil)
+
+			md.OutputOptions.RateLimit = -1
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.RateLimit = 0
+
+			md.OutputOptions.MaxDocsPerSecond = -1
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--encrypt requires one key option and can't be used with stdout or checkpoints", func() {
+			md.OutputOptions.Encrypt = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+
+			md.OutputOptions.KeyFile = "master.key"
+			So(md.ValidateOptions(), ShouldBeNil)
+
+			md.OutputOptions.KMSProvider = "aws:alias/backups"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.KMSProvider = ""
+
+			md.OutputOptions.Resume = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Resume = false
+
+			md.OutputOptions.Encrypt = false
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--nsInclude and --nsExclude can't be used with --collection or --incremental", func() {
+			md.OutputOptions.NSInclude = []string{"app_*.orders"}
+			md.OutputOptions.NSExclude = []string{"/tmp_/"}
+			So(md.ValidateOptions(), ShouldBeNil)
+
+			md.ToolOptions.Namespace.DB = "app_1"
+			md.ToolOptions.Namespace.Collection = "orders"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.ToolOptions.Namespace.DB = ""
+			md.ToolOptions.Namespace.Collection = ""
+
+			md.OutputOptions.Incremental = true
+			md.OutputOptions.Since = "1600000000"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--metadataOnly and --indexesOnly dump whole collections without data", func() {
+			md.OutputOptions.MetadataOnly = true
+			So(md.ValidateOptions(), ShouldBeNil)
+
+			md.OutputOptions.IndexesOnly = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.MetadataOnly = false
+			So(md.ValidateOptions(), ShouldBeNil)
+
+			md.OutputOptions.DumpDBUsersAndRoles = true
+			md.ToolOptions.Namespace.DB = "app"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.DumpDBUsersAndRoles = false
+			md.ToolOptions.Namespace.DB = ""
+
+			md.InputOptions.Query = `{"a": 1}`
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.InputOptions.Query = ""
+
+			md.OutputOptions.Oplog = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--timeseriesBuckets can't be used with a query on measurements", func() {
+			md.OutputOptions.TimeseriesBuckets = true
+			So(md.ValidateOptions(), ShouldBeNil)
+			md.InputOptions.Query = `{"ts": {"$gt": 1}}`
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--splitSize only applies to directories and must be a positive size", func() {
+			md.OutputOptions.SplitSize = "10GB"
+			So(md.ValidateOptions(), ShouldBeNil)
+
+			md.OutputOptions.Resume = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Resume = false
+
+			md.OutputOptions.Archive = "dump.archive"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Archive = ""
+
+			md.OutputOptions.SplitSize = "ten"
+			err := md.Init()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "--splitSize")
+		})
+
+		Convey("--outFormat must be known, and tar streams need --out and no checkpoints", func() {
+			md.OutputOptions.OutFormat = "zip"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+
+			md.OutputOptions.OutFormat = TarFormat
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Out = "-"
+			So(md.ValidateOptions(), ShouldBeNil)
+
+			md.OutputOptions.Resume = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Resume = false
+
+			md.OutputOptions.SplitSize = "10GB"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--dryRun can't be used with --incremental or checkpoints", func() {
+			md.OutputOptions.DryRun = true
+			So(md.ValidateOptions(), ShouldBeNil)
+			md.OutputOptions.Resume = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--reuseUnchangedFrom requires another local directory and whole collections", func() {
+			md.OutputOptions.ReuseUnchangedFrom = "dump"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.ReuseUnchangedFrom = "dump-yesterday"
+			So(md.ValidateOptions(), ShouldBeNil)
+			md.OutputOptions.Archive = "dump.archive"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Archive = ""
+			md.InputOptions.Query = `{"a": 1}`
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--requireOplogWindow requires --oplog", func() {
+			md.ToolOptions.Namespace.DB = ""
+			md.ToolOptions.Namespace.Collection = ""
+			md.OutputOptions.RequireOplogWindow = "2h"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Oplog = true
+			So(md.ValidateOptions(), ShouldBeNil)
+		})
+
+		Convey("--pipeline and --pipelineFile", func() {
+			md.InputOptions.Pipeline = `[{"$project": {"payload": 0}}]`
+			So(md.ValidateOptions(), ShouldBeNil)
+			md.InputOptions.PipelineFile = "pipelines.yaml"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.InputOptions.PipelineFile = ""
+			md.OutputOptions.IndexesOnly = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.IndexesOnly = false
+			md.OutputOptions.Resume = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--corruptDocumentsFile and --skipCorruptDocuments require --validateDocuments", func() {
+			md.OutputOptions.SkipCorruptDocuments = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.ValidateDocuments = true
+			So(md.ValidateOptions(), ShouldBeNil)
+			md.OutputOptions.CorruptDocumentsFile = "corrupt.jsonl"
+			So(md.ValidateOptions(), ShouldBeNil)
+		})
+
+		Convey("--sourceType must be a known kind of deployment", func() {
+			md.InputOptions.SourceType = SourceDataFederation
+			So(md.ValidateOptions(), ShouldBeNil)
+			md.InputOptions.SourceType = "atlas"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--outputMapFile requires directory output", func() {
+			md.OutputOptions.OutputMapFile = "outputs.yaml"
+			So(md.ValidateOptions(), ShouldBeNil)
+			md.OutputOptions.Encrypt = true
+			md.OutputOptions.KeyFile = "master.key"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Encrypt = false
+			md.OutputOptions.KeyFile = ""
+			md.OutputOptions.Archive = "dump.archive"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--sample, --sampleDocs and --sampleReference", func() {
+			md.InputOptions.SampleReference = []string{"test.orders:customerId=test.customers"}
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.InputOptions.Sample = []string{"1%"}
+			So(md.ValidateOptions(), ShouldBeNil)
+			md.InputOptions.Sample = nil
+			md.InputOptions.SampleDocs = []string{"test.orders=1000"}
+			So(md.ValidateOptions(), ShouldBeNil)
+			md.OutputOptions.Incremental = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Incremental = false
+			md.OutputOptions.MetadataOnly = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--teeArchive requires --archive at a different location", func() {
+			md.ToolOptions.Namespace.Collection = ""
+			md.OutputOptions.TeeArchive = []string{"/mnt/backup/dump.archive"}
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Archive = "dump.archive"
+			So(md.ValidateOptions(), ShouldBeNil)
+			md.OutputOptions.TeeArchive = append(md.OutputOptions.TeeArchive, "dump.archive")
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--deltaBase requires an uncompressed archive", func() {
+			md.ToolOptions.Namespace.Collection = ""
+			md.OutputOptions.DeltaBase = "monday.archive"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Archive = "tuesday.archive"
+			So(md.ValidateOptions(), ShouldBeNil)
+			md.OutputOptions.Gzip = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Gzip = false
+			md.OutputOptions.Archive = "monday.archive"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--archiveIndex requires an uncompressed local archive file", func() {
+			md.ToolOptions.Namespace.Collection = ""
+			md.OutputOptions.ArchiveIndex = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Archive = "dump.archive"
+			So(md.ValidateOptions(), ShouldBeNil)
+			md.OutputOptions.Gzip = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.OutputOptions.Gzip = false
+			md.OutputOptions.Archive = "s3://bucket/dump.archive"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--daemon requires --schedule and an output directory", func() {
+			md.DaemonOptions = &DaemonOptions{Retention: 7}
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.DaemonOptions.Daemon = true
+			So(md.ValidateOptions(), ShouldNotBeNil)
+			md.DaemonOptions.Schedule = "0 2 * * *"
+			So(md.ValidateOptions(), ShouldBeNil)
+			md.OutputOptions.Archive = "dump.archive"
+			So(md.ValidateOptions(), ShouldNotBeNil)
+		})
+
+		Convey("--atClusterTime must be a timestamp", func() {
+			md.InputOptions.AtClusterTime = "yesterday"
+			err := md.Init()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "--atClusterTime")
+		})
+
 	})
 }
 
@@ -1365,6 +1697,19 @@ func TestMongoDumpCollectionOutputPath(t *testing.T) {
 				hash := sha1.Sum([]byte(colName))
 				So(bytes.Compare(hashDecoded, hash[:]), ShouldEqual, 0)
 			})
+
+			Convey("with a compression extension longer than .gz", func() {
+				// 17 bytes * 14 = 238 bytes, which is one byte too long with .metadata.json.zst
+				md.OutputOptions.Compress = "zstd"
+				colName := strings.Repeat("abcdefghijklmnopq", 14)
+
+				fileComponents := strings.Split(md.outputPath(testDB, colName), "/")
+				So(len(fileComponents), ShouldEqual, 3)
+
+				filePath := fileComponents[len(fileComponents)-1]
+				So(len(filePath), ShouldEqual, 237)
+				So(filePath[:210], ShouldEqual, colName[:207]+"%24")
+			})
 		})
 	})
 }
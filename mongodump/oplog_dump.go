@@ -10,9 +10,9 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -20,6 +20,8 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/readconcern"
 )
 
+var oplogLog = log.Component("oplog")
+
 // determineOplogCollectionName uses a command to infer
 // the name of the oplog collection in the connected db
 func (dump *MongoDump) determineOplogCollectionName() error {
@@ -29,18 +31,18 @@ func (dump *MongoDump) determineOplogCollectionName() error {
 		return fmt.Errorf("error running command: %v", err)
 	}
 	if _, ok := masterDoc["hosts"]; ok {
-		log.Logvf(log.DebugLow, "determined cluster to be a replica set")
-		log.Logvf(log.DebugHigh, "oplog located in local.oplog.rs")
+		oplogLog.Logvf(log.DebugLow, "determined cluster to be a replica set")
+		oplogLog.Logvf(log.DebugHigh, "oplog located in local.oplog.rs")
 		dump.oplogCollection = "oplog.rs"
 		return nil
 	}
 	if isMaster := masterDoc["ismaster"]; util.IsFalsy(isMaster) {
-		log.Logvf(log.Info, "mongodump is not connected to a master")
+		oplogLog.Logvf(log.Info, "mongodump is not connected to a master")
 		return fmt.Errorf("not connected to master")
 	}
 
-	log.Logvf(log.DebugLow, "not connected to a replica set, assuming master/slave")
-	log.Logvf(log.DebugHigh, "oplog located in local.oplog.$main")
+	oplogLog.Logvf(log.DebugLow, "not connected to a replica set, assuming master/slave")
+	oplogLog.Logvf(log.DebugHigh, "oplog located in local.oplog.$main")
 	dump.oplogCollection = "oplog.$main"
 	return nil
 
@@ -102,25 +104,35 @@ func (dump *MongoDump) getOplogCopyStartTime() (primitive.Timestamp, error) {
 // still in the database and making sure it happened at or before the timestamp
 // captured at the start of the dump.
 func (dump *MongoDump) checkOplogTimestampExists(ts primitive.Timestamp) (bool, error) {
+	oldest, err := dump.getOldestOplogTime()
+	if err != nil {
+		return false, err
+	}
+
+	oplogLog.Logvf(log.DebugHigh, "oldest oplog entry has timestamp %v", oldest)
+	if util.TimestampGreaterThan(oldest, ts) {
+		oplogLog.Logvf(log.Info, "oldest oplog entry of timestamp %v is newer than %v",
+			oldest, ts)
+		return false, nil
+	}
+	return true, nil
+}
+
+// getOldestOplogTime returns the timestamp of the oldest oplog entry still in
+// the database.
+func (dump *MongoDump) getOldestOplogTime() (primitive.Timestamp, error) {
 	oldestOplogEntry := db.Oplog{}
 	var tempBSON bson.Raw
 
 	err := dump.SessionProvider.FindOne("local", dump.oplogCollection, 0, nil, &bson.M{"$natural": 1}, &tempBSON, 0)
 	if err != nil {
-		return false, fmt.Errorf("unable to read entry from oplog: %v", err)
+		return primitive.Timestamp{}, fmt.Errorf("unable to read entry from oplog: %v", err)
 	}
 	err = bson.Unmarshal(tempBSON, &oldestOplogEntry)
 	if err != nil {
-		return false, err
-	}
-
-	log.Logvf(log.DebugHigh, "oldest oplog entry has timestamp %v", oldestOplogEntry.Timestamp)
-	if util.TimestampGreaterThan(oldestOplogEntry.Timestamp, ts) {
-		log.Logvf(log.Info, "oldest oplog entry of timestamp %v is newer than %v",
-			oldestOplogEntry.Timestamp, ts)
-		return false, nil
+		return primitive.Timestamp{}, err
 	}
-	return true, nil
+	return oldestOplogEntry.Timestamp, nil
 }
 
 func oplogDocumentValidator(in []byte) error {
@@ -141,22 +153,27 @@ func oplogDocumentValidator(in []byte) error {
 // DumpOplogBetweenTimestamps takes two timestamps and writer and dumps all oplog
 // entries between the given timestamp to the writer. Returns any errors that occur.
 func (dump *MongoDump) DumpOplogBetweenTimestamps(start, end primitive.Timestamp) error {
-	session, err := dump.SessionProvider.GetSession()
-	if err != nil {
-		return err
-	}
 	queryObj := bson.M{"$and": []bson.M{
 		{"ts": bson.M{"$gte": start}},
 		{"ts": bson.M{"$lte": end}},
 	}}
+	return dump.dumpOplogQuery(queryObj, oplogDocumentValidator)
+}
+
+// dumpOplogQuery dumps the oplog entries matching filter to the oplog intent.
+func (dump *MongoDump) dumpOplogQuery(filter interface{}, validator documentValidator) error {
+	session, err := dump.SessionProvider.GetSession()
+	if err != nil {
+		return err
+	}
 	oplogQuery := &db.DeferredQuery{
 		Coll:      session.Database("local").Collection(dump.oplogCollection),
-		Filter:    queryObj,
+		Filter:    filter,
 		LogReplay: true,
 	}
-	oplogCount, err := dump.dumpValidatedQueryToIntent(oplogQuery, dump.manager.Oplog(), dump.getResettableOutputBuffer(), oplogDocumentValidator)
+	oplogCount, err := dump.dumpValidatedQueryToIntent(oplogQuery, dump.manager.Oplog(), dump.getResettableOutputBuffer(), validator)
 	if err == nil {
-		log.Logvf(log.Always, "\tdumped %v oplog %v",
+		oplogLog.Logvf(log.Always, "\tdumped %v oplog %v",
 			oplogCount, util.Pluralize(int(oplogCount), "entry", "entries"))
 	}
 	return err
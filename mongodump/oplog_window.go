@@ -0,0 +1,154 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// oplogSlackInterval is how often the oplog slack is logged during a dump
+// with --oplog.
+const oplogSlackInterval = time.Minute
+
+// oplogSpan returns the time between two oplog timestamps, or zero if to is
+// not after from.
+func oplogSpan(from, to primitive.Timestamp) time.Duration {
+	if to.T <= from.T {
+		return 0
+	}
+	return time.Duration(to.T-from.T) * time.Second
+}
+
+// checkOplogWindow measures how much time of writes the oplog holds and
+// compares it with how long the dump is estimated to take, since the oplog
+// entries written while dumping must still be there when the oplog is dumped.
+// It fails if the window is smaller than --requireOplogWindow, and warns if
+// the dump is estimated to take longer than the window.
+func (dump *MongoDump) checkOplogWindow() error {
+	oldest, err := dump.getOldestOplogTime()
+	if err != nil {
+		return fmt.Errorf("error measuring the oplog window: %v", err)
+	}
+	newest, err := dump.getCurrentOplogTime()
+	if err != nil {
+		return fmt.Errorf("error measuring the oplog window: %v", err)
+	}
+	window := oplogSpan(oldest, newest)
+	oplogLog.Logvf(log.Always, "the oplog window is %v", window)
+
+	estimate, err := dump.estimateDumpDuration()
+	if err != nil {
+		return fmt.Errorf("error estimating the duration of the dump: %v", err)
+	}
+	if estimate > 0 {
+		oplogLog.Logvf(log.Info, "the dump is estimated to take %v", estimate)
+	}
+
+	warning, err := evaluateOplogWindow(window, estimate, dump.requireOplogWindow)
+	if warning != "" {
+		oplogLog.Logvf(log.Always, "warning: %v", warning)
+	}
+	return err
+}
+
+// evaluateOplogWindow returns an error if the oplog window is smaller than
+// the one required, and a warning if the dump is estimated to take longer
+// than the window. A zero estimate or requirement is not checked.
+func evaluateOplogWindow(window, estimate, required time.Duration) (string, error) {
+	if required > 0 && window < required {
+		return "", fmt.Errorf("the oplog window of %v is smaller than --requireOplogWindow %v", window, required)
+	}
+	if estimate > 0 && estimate >= window {
+		return fmt.Sprintf("the dump is estimated to take %v, which is not less than the oplog window of %v; "+
+			"it may fail with an oplog overflow", estimate, window), nil
+	}
+	return "", nil
+}
+
+// estimateDumpDuration projects how long the collections take to dump the
+// way a dry run does, from their stats and a short read of the largest one.
+// It returns zero if there is no data to estimate from.
+func (dump *MongoDump) estimateDumpDuration() (time.Duration, error) {
+	session, err := dump.SessionProvider.GetSession()
+	if err != nil {
+		return 0, err
+	}
+	var estimates []*namespaceEstimate
+	for _, intent := range dump.manager.Intents() {
+		if intent.IsOplog() {
+			continue
+		}
+		estimate, err := dump.estimateIntent(session, intent)
+		if err != nil {
+			return 0, err
+		}
+		estimates = append(estimates, estimate)
+	}
+
+	largest := largestEstimate(estimates)
+	if largest == nil {
+		return 0, nil
+	}
+	rate, err := dump.sampleReadRate(session, dump.manager.IntentForNamespace(largest.namespace))
+	if err != nil {
+		return 0, fmt.Errorf("error reading %v to measure the read rate: %v", largest.namespace, err)
+	}
+	return projectRuntime(estimates, rate, dump.OutputOptions.NumParallelCollections, dump.OutputOptions), nil
+}
+
+// monitorOplogSlack logs the oplog slack every oplogSlackInterval until the
+// returned function is called: the time of writes the oplog can still take
+// before the entry the oplog dump starts at is overwritten. It does nothing
+// without --oplog.
+func (dump *MongoDump) monitorOplogSlack() (stop func()) {
+	if !dump.OutputOptions.Oplog {
+		return func() {}
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(oplogSlackInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				dump.logOplogSlack()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// logOplogSlack logs the current oplog slack, warning once the start of the
+// oplog dump has been overwritten.
+func (dump *MongoDump) logOplogSlack() {
+	oldest, err := dump.getOldestOplogTime()
+	if err != nil {
+		oplogLog.Logvf(log.Info, "error measuring the oplog slack: %v", err)
+		return
+	}
+	if util.TimestampGreaterThan(oldest, dump.oplogStart) {
+		oplogLog.Logvf(log.Always, "warning: the oplog has rolled over past %v, where the oplog dump starts; "+
+			"the dump will fail with an oplog overflow", dump.oplogStart)
+		return
+	}
+	oplogLog.Logvf(log.Always, "oplog slack: %v of writes before the oplog dump's start is overwritten",
+		oplogSpan(oldest, dump.oplogStart))
+}
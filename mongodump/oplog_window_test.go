@@ -0,0 +1,42 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOplogWindow(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("The oplog span is the time between two timestamps", t, func() {
+		So(oplogSpan(primitive.Timestamp{T: 100, I: 3}, primitive.Timestamp{T: 7300, I: 1}), ShouldEqual, 2*time.Hour)
+		So(oplogSpan(primitive.Timestamp{T: 100, I: 1}, primitive.Timestamp{T: 100, I: 9}), ShouldEqual, 0)
+		So(oplogSpan(primitive.Timestamp{T: 200}, primitive.Timestamp{T: 100}), ShouldEqual, 0)
+	})
+
+	Convey("A window smaller than --requireOplogWindow is an error", t, func() {
+		_, err := evaluateOplogWindow(time.Hour, 0, 2*time.Hour)
+		So(err, ShouldNotBeNil)
+		warning, err := evaluateOplogWindow(3*time.Hour, time.Minute, 2*time.Hour)
+		So(err, ShouldBeNil)
+		So(warning, ShouldEqual, "")
+	})
+
+	Convey("A dump estimated to outlast the window is warned about", t, func() {
+		warning, err := evaluateOplogWindow(time.Hour, 90*time.Minute, 0)
+		So(err, ShouldBeNil)
+		So(warning, ShouldContainSubstring, "1h30m0s")
+		warning, _ = evaluateOplogWindow(time.Hour, 0, 0)
+		So(warning, ShouldEqual, "")
+	})
+}
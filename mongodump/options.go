@@ -8,9 +8,10 @@ package mongodump
 
 import (
 	"fmt"
-	"io/ioutil"
 
-	"github.com/mongodb/mongo-tools-common/options"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/util"
 )
 
 var Usage = `<options> <connection-string>
@@ -25,10 +26,23 @@ See http://docs.mongodb.com/database-tools/mongodump/ for more information.`
 
 // InputOptions defines the set of options to use in retrieving data from the server.
 type InputOptions struct {
-	Query          string `long:"query" short:"q" description:"query filter, as a v2 Extended JSON string, e.g., '{\"x\":{\"$gt\":1}}'"`
-	QueryFile      string `long:"queryFile" description:"path to a file containing a query filter (v2 Extended JSON)"`
-	ReadPreference string `long:"readPreference" value-name:"<string>|<json>" description:"specify either a preference mode (e.g. 'nearest') or a preference json object (e.g. '{mode: \"nearest\", tagSets: [{a: \"b\"}], maxStalenessSeconds: 123}')"`
-	TableScan      bool   `long:"forceTableScan" description:"force a table scan (do not use $snapshot or hint _id). Deprecated since this is default behavior on WiredTiger"`
+	Query               string   `long:"query" short:"q" description:"query filter, as a v2 Extended JSON string, e.g., '{\"x\":{\"$gt\":1}}'"`
+	QueryFile           string   `long:"queryFile" description:"path to a file containing a query filter (v2 Extended JSON, or YAML for .yaml/.yml files)"`
+	NamespaceQueryFile  string   `long:"namespaceQueryFile" description:"path to a file mapping namespaces to the query filter for each (v2 Extended JSON, or YAML for .yaml/.yml files), e.g. '{\"test.orders\": {\"x\": 1}}'; collections that aren't in the file are dumped whole"`
+	Pipeline            string   `long:"pipeline" description:"aggregation pipeline the documents are dumped through, as a v2 Extended JSON array of stages, e.g. '[{\"$project\":{\"payload\":0}}]'"`
+	PipelineFile        string   `long:"pipelineFile" description:"path to a file containing an aggregation pipeline (v2 Extended JSON, or YAML for .yaml/.yml files). Without --collection, the file maps namespaces to the pipeline for each"`
+	Sample              []string `long:"sample" value-name:"[<namespace>=]<percent>%" description:"dump a random sample of this percentage of the documents of each collection, or of the given namespace, using $sample, e.g. '1%' or 'test.orders=0.5%' (may be specified multiple times)"`
+	SampleDocs          []string `long:"sampleDocs" value-name:"[<namespace>=]<n>" description:"dump a random sample of this many documents of each collection, or of the given namespace, using $sample (may be specified multiple times)"`
+	SampleReference     []string `long:"sampleReference" value-name:"<namespace>:<field>=<namespace>" description:"with --sample or --sampleDocs, also dump the documents of the second namespace whose _id is a value of the field in the documents dumped from the first, e.g. 'test.orders:customerId=test.customers' (may be specified multiple times)"`
+	ReadPreference      string   `long:"readPreference" value-name:"<string>|<json>" description:"specify either a preference mode (e.g. 'nearest') or a preference json object (e.g. '{mode: \"nearest\", tagSets: [{a: \"b\"}], maxStalenessSeconds: 123}')"`
+	ShardReadPreference []string `long:"shardReadPreference" value-name:"<shard>=<string>|<json>" description:"read preference for one shard with --coordinateShards, e.g. 'shard01={mode: \"secondary\", tagSets: [{use: \"analytics\"}]}'; other shards use --readPreference (may be specified multiple times)"`
+	MaxReplicationLag   string   `long:"maxReplicationLag" value-name:"<duration>" description:"before dumping, check that the member each read preference selects is no further behind its primary than this, e.g. '30s'"`
+	Snapshot            bool     `long:"snapshot" description:"read every collection at the same cluster time with snapshot read concern, for a point-in-time dump without --oplog; the whole dump must finish within the server's minSnapshotHistoryWindowInSeconds (300 seconds by default) of that time, and fails early if it is estimated not to (requires MongoDB 5.0+)"`
+	AtClusterTime       string   `long:"atClusterTime" value-name:"<seconds>[:ordinal]" description:"cluster time to read every collection at with snapshot read concern; implies --snapshot (default: the latest majority-committed time)"`
+	SourceType          string   `long:"sourceType" value-name:"<type>" default:"auto" default-mask:"-" description:"kind of deployment being dumped: 'standard', 'serverless' for an Atlas serverless instance, or 'dataFederation' for Atlas Data Federation, which don't support the oplog, snapshot reads or users and roles; options they don't support are reported before dumping, and smaller batches are read (default: auto, detected from the server)"`
+	TableScan           bool     `long:"forceTableScan" description:"force a table scan (do not use $snapshot or hint _id). Deprecated since this is default behavior on WiredTiger"`
+	MaxRetries          int      `long:"maxRetries" value-name:"<n>" description:"number of times to reopen a collection's cursor after a transient error, such as a killed cursor, a network error or a primary stepdown, resuming after the last document read (default: 0)"`
+	RetryBackoff        string   `long:"retryBackoff" value-name:"<duration>" default:"1s" default-mask:"-" description:"delay before the first retry of a cursor, doubled for each further retry up to 30s (default: 1s)"`
 }
 
 // Name returns a human-readable group name for input options.
@@ -37,33 +51,94 @@ func (*InputOptions) Name() string {
 }
 
 func (inputOptions *InputOptions) HasQuery() bool {
-	return inputOptions.Query != "" || inputOptions.QueryFile != ""
+	return inputOptions.Query != "" || inputOptions.QueryFile != "" || inputOptions.NamespaceQueryFile != ""
 }
 
 func (inputOptions *InputOptions) GetQuery() ([]byte, error) {
 	if inputOptions.Query != "" {
 		return []byte(inputOptions.Query), nil
 	} else if inputOptions.QueryFile != "" {
-		content, err := ioutil.ReadFile(inputOptions.QueryFile)
+		content, err := util.ReadConfigFile(inputOptions.QueryFile)
 		if err != nil {
 			err = fmt.Errorf("error reading queryFile: %s", err)
 		}
 		return content, err
+	} else if inputOptions.NamespaceQueryFile != "" {
+		content, err := util.ReadConfigFile(inputOptions.NamespaceQueryFile)
+		if err != nil {
+			err = fmt.Errorf("error reading namespaceQueryFile: %s", err)
+		}
+		return content, err
+	}
+	panic("GetQuery can return valid values only for query, queryFile or namespaceQueryFile input")
+}
+
+func (inputOptions *InputOptions) HasPipeline() bool {
+	return inputOptions.Pipeline != "" || inputOptions.PipelineFile != ""
+}
+
+func (inputOptions *InputOptions) HasSample() bool {
+	return len(inputOptions.Sample) > 0 || len(inputOptions.SampleDocs) > 0
+}
+
+func (inputOptions *InputOptions) GetPipeline() ([]byte, error) {
+	if inputOptions.Pipeline != "" {
+		return []byte(inputOptions.Pipeline), nil
+	} else if inputOptions.PipelineFile != "" {
+		content, err := util.ReadConfigFile(inputOptions.PipelineFile)
+		if err != nil {
+			err = fmt.Errorf("error reading pipelineFile: %s", err)
+		}
+		return content, err
 	}
-	panic("GetQuery can return valid values only for query or queryFile input")
+	panic("GetPipeline can return valid values only for pipeline or pipelineFile input")
 }
 
 // OutputOptions defines the set of options for writing dump data.
 type OutputOptions struct {
-	Out                        string   `long:"out" value-name:"<directory-path>" short:"o" description:"output directory, or '-' for stdout (default: 'dump')"`
+	Out                        string   `long:"out" value-name:"<directory-path>" short:"o" description:"output directory, an s3://, gs:// or azblob:// URL to write to object storage, or '-' for stdout (default: 'dump')"`
+	OutFormat                  string   `long:"outFormat" value-name:"<format>" description:"write --out as a dump directory, or as a 'tar' or 'tar.gz' stream of the same layout to the given file, object storage URL or '-' for stdout; files over 16MB are stored in numbered segments (default: 'directory')"`
 	Gzip                       bool     `long:"gzip" description:"compress archive or collection output with Gzip"`
+	Compress                   string   `long:"compress" value-name:"<codec>[:level]" description:"compress archive or collection output with gzip, zstd or lz4, optionally at the given gzip (1-9) or zstd (1-22) level"`
+	CompressionWorkers         int      `long:"compressionWorkers" value-name:"<n>" description:"number of goroutines that compress each gzip or zstd output file, archive or tar.gz stream (default: 1)"`
 	Oplog                      bool     `long:"oplog" description:"use oplog for taking a point-in-time snapshot"`
-	Archive                    string   `long:"archive" value-name:"<file-path>" optional:"true" optional-value:"-" description:"dump as an archive to the specified path. If flag is specified without a value, archive is written to stdout"`
-	DumpDBUsersAndRoles        bool     `long:"dumpDbUsersAndRoles" description:"dump user and role definitions for the specified database"`
+	RequireOplogWindow         string   `long:"requireOplogWindow" value-name:"<duration>" description:"with --oplog, abort before dumping if the oplog holds less than this much time of writes, e.g. '2h'"`
+	CoordinateShards           bool     `long:"coordinateShards" description:"dump a sharded cluster by reading each shard directly at the same cluster time, with the balancer stopped, into one consistent archive; like --snapshot, the dump must finish within the shards' minSnapshotHistoryWindowInSeconds (requires --archive and MongoDB 5.0+)"`
+	Archive                    string   `long:"archive" value-name:"<file-path>" optional:"true" optional-value:"-" description:"dump as an archive to the specified path or object storage URL. If flag is specified without a value, archive is written to stdout"`
+	TeeArchive                 []string `long:"teeArchive" value-name:"<file-path>|<url>" description:"also write the archive to this path, object storage URL or ssh://[user@]host[:port]/path; a copy that fails is dropped without failing the dump (may be specified multiple times)"`
+	DeltaBase                  string   `long:"deltaBase" value-name:"<file-path>" description:"write the archive as a delta from this previous uncompressed, unencrypted archive, with only the blocks that differ from it; mongorestore --deltaBase reconstructs the archive from both"`
+	ArchiveIndex               bool     `long:"archiveIndex" description:"also write '<archive>.index.json', recording where the blocks of each namespace are in the archive, so that mongorestore reads only the blocks of the namespaces it restores from the archive file; requires an uncompressed, unencrypted archive file"`
+	DumpDBUsersAndRoles        bool     `long:"dumpDbUsersAndRoles" description:"dump user and role definitions for the specified database, with their custom data and authentication restrictions, so that mongorestore can restore them to the same or another database"`
 	ExcludedCollections        []string `long:"excludeCollection" value-name:"<collection-name>" description:"collection to exclude from the dump (may be specified multiple times to exclude additional collections)"`
 	ExcludedCollectionPrefixes []string `long:"excludeCollectionsWithPrefix" value-name:"<collection-prefix>" description:"exclude all collections from the dump that have the given prefix (may be specified multiple times to exclude additional prefixes)"`
+	NSInclude                  []string `long:"nsInclude" value-name:"<namespace-pattern>" description:"include only matching namespaces, with * wildcards or a regular expression between slashes (may be specified multiple times)"`
+	NSExclude                  []string `long:"nsExclude" value-name:"<namespace-pattern>" description:"exclude matching namespaces, with * wildcards or a regular expression between slashes (may be specified multiple times)"`
 	NumParallelCollections     int      `long:"numParallelCollections" short:"j" description:"number of collections to dump in parallel" default:"4" default-mask:"-"`
+	NumParallelChunks          int      `long:"numParallelChunksPerCollection" value-name:"<n>" description:"split each collection into this many ranges of _id and dump them in parallel (default: 1)"`
+	RateLimit                  float64  `long:"rateLimit" value-name:"<MB/s>" description:"limit the rate documents are written to the output to this many megabytes per second, across all collections"`
+	MaxDocsPerSecond           int      `long:"maxDocsPerSecond" value-name:"<n>" description:"limit the rate documents are read from the server to this many per second, across all collections"`
 	ViewsAsCollections         bool     `long:"viewsAsCollections" description:"dump views as normal collections with their produced data, omitting standard collections"`
+	TimeseriesBuckets          bool     `long:"timeseriesBuckets" description:"dump the raw buckets of time-series collections instead of their measurements; restoring them requires a server of the same version"`
+	MetadataOnly               bool     `long:"metadataOnly" description:"dump collection options, indexes, users and roles, but no collection data"`
+	IndexesOnly                bool     `long:"indexesOnly" description:"dump only the index definitions of collections, without their data or options"`
+	ValidateDocuments          bool     `long:"validateDocuments" description:"check that each document read is valid BSON, with valid UTF-8 and no more than 100 levels of nesting, recording corrupt ones in --corruptDocumentsFile; the dump fails on the first unless --skipCorruptDocuments is given"`
+	CorruptDocumentsFile       string   `long:"corruptDocumentsFile" value-name:"<file-path>" description:"with --validateDocuments, file the corrupt documents are recorded in, one JSON line each with its namespace, position, problem and base64 data (default: '<out>.corrupt.jsonl', or '<archive>.corrupt.jsonl')"`
+	SkipCorruptDocuments       bool     `long:"skipCorruptDocuments" description:"with --validateDocuments, leave corrupt documents out of the dump and continue"`
+	OutputMapFile              string   `long:"outputMapFile" value-name:"<file-path>" description:"JSON or YAML file of rules, each writing the collections matching its 'namespaces' patterns to its own 'out' directory or object storage URL, optionally with its own 'compress' codec; each location is a dump of its own with its own manifest, and the rest of the dump goes to --out"`
+	CheckpointFile             string   `long:"checkpointFile" value-name:"<file-path>" description:"record the progress of each collection in this file, so an interrupted dump can be resumed (default: '<out>.checkpoint.json' with --resume)"`
+	Resume                     bool     `long:"resume" description:"continue an interrupted dump from its checkpoint file instead of starting over"`
+	Incremental                bool     `long:"incremental" description:"dump only the oplog entries written since --since, to be replayed on top of a restore of an earlier dump"`
+	Since                      string   `long:"since" value-name:"<seconds>[:ordinal]" description:"oplog timestamp an incremental dump starts after, as printed at the end of the previous dump"`
+	Encrypt                    bool     `long:"encrypt" description:"encrypt collection and metadata files, or the archive, with AES-256-GCM using a data key protected by --keyFile or --kmsProvider"`
+	KeyFile                    string   `long:"keyFile" value-name:"<filename>" description:"file holding a base64-encoded 256-bit master key to encrypt the dump's data key with"`
+	KMSProvider                string   `long:"kmsProvider" value-name:"aws:<keyId>" description:"AWS KMS key to generate the dump's data key with"`
+	SplitSize                  string   `long:"splitSize" value-name:"<size>" description:"split each collection's BSON output into numbered segments no larger than this size, e.g. 10GB"`
+	PreDumpHook                string   `long:"preDumpHook" value-name:"<command>" description:"shell command to run before dumping each namespace, given the namespace and its file path as JSON on stdin and in MONGODUMP_* environment variables; the dump fails if it fails"`
+	PostDumpHook               string   `long:"postDumpHook" value-name:"<command>" description:"shell command to run after each namespace is dumped, given the namespace, its file path, and the documents, bytes and seconds dumped as JSON on stdin and in MONGODUMP_* environment variables; the dump fails if it fails"`
+	ReuseUnchangedFrom         string   `long:"reuseUnchangedFrom" value-name:"<directory-path>" description:"link the files of collections that haven't changed since the dump in this directory was taken, judged by their UUID, document count and the oplog since, instead of dumping them again (requires a replica set)"`
+	DBHash                     bool     `long:"dbHash" description:"record the dbHash of each dumped collection in the manifest after the dump, for mongorestore --verifyHash; the source must not be written to during the dump"`
+	DryRun                     bool     `long:"dryRun" description:"report the namespaces that would be dumped with their estimated output sizes, and a runtime projected from a short read of the largest collection, without dumping any data"`
+	VerifyManifest             string   `long:"verifyManifest" value-name:"<path>" description:"check the files of an existing dump directory or archive against its manifest, instead of dumping"`
 }
 
 // Name returns a human-readable group name for output options.
@@ -71,10 +146,43 @@ func (*OutputOptions) Name() string {
 	return "output"
 }
 
+// Codec returns the compression given by --compress, or by --gzip.
+func (outputOptions *OutputOptions) Codec() (compression.Codec, error) {
+	if outputOptions.Compress == "" {
+		if outputOptions.Gzip {
+			return compression.Codec{Name: compression.GzipName, Workers: outputOptions.CompressionWorkers}, nil
+		}
+		return compression.None, nil
+	}
+	codec, err := compression.Parse(outputOptions.Compress)
+	if err != nil {
+		return compression.None, fmt.Errorf("invalid --compress: %v", err)
+	}
+	if outputOptions.Gzip && codec.Name != compression.GzipName {
+		return compression.None, fmt.Errorf("--gzip cannot be used with --compress=%v", outputOptions.Compress)
+	}
+	codec.Workers = outputOptions.CompressionWorkers
+	return codec, nil
+}
+
+// DaemonOptions defines the set of options for running mongodump as a backup agent.
+type DaemonOptions struct {
+	Daemon     bool   `long:"daemon" description:"run in the foreground as a backup agent, dumping on --schedule to a new directory under --out named by the time of each dump"`
+	Schedule   string `long:"schedule" value-name:"<cron>" description:"schedule of the dumps with --daemon, as the five crontab fields of minute, hour, day of month, month and day of week in UTC, e.g. '0 2 * * *'"`
+	Retention  int    `long:"retention" value-name:"<n>" description:"with --daemon, keep the n most recent complete dumps under --out and remove older ones (default: 0, keep all)"`
+	StatusAddr string `long:"statusAddr" value-name:"<host:port>" description:"with --daemon, serve the status of the agent as JSON at /status and its health at /healthz over HTTP at this address, e.g. 'localhost:9090'"`
+}
+
+// Name returns a human-readable group name for daemon options.
+func (*DaemonOptions) Name() string {
+	return "daemon"
+}
+
 type Options struct {
 	*options.ToolOptions
 	*InputOptions
 	*OutputOptions
+	*DaemonOptions
 }
 
 func ParseOptions(rawArgs []string, versionStr, gitCommit string) (Options, error) {
@@ -84,6 +192,8 @@ func ParseOptions(rawArgs []string, versionStr, gitCommit string) (Options, erro
 	opts.AddOptions(inputOpts)
 	outputOpts := &OutputOptions{}
 	opts.AddOptions(outputOpts)
+	daemonOpts := &DaemonOptions{}
+	opts.AddOptions(daemonOpts)
 
 	extraArgs, err := opts.ParseArgs(rawArgs)
 	if err != nil {
@@ -97,5 +207,5 @@ func ParseOptions(rawArgs []string, versionStr, gitCommit string) (Options, erro
 		)
 	}
 
-	return Options{opts, inputOpts, outputOpts}, nil
+	return Options{opts, inputOpts, outputOpts, daemonOpts}, nil
 }
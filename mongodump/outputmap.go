@@ -0,0 +1,202 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"github.com/mongodb/mongo-tools/common/util"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+)
+
+// outputMapRule is a rule of --outputMapFile: the collections matching any
+// of its namespace patterns are written to out, compressed with compress
+// when it is given, or as the rest of the dump otherwise.
+type outputMapRule struct {
+	Namespaces []string `json:"namespaces"`
+	Out        string   `json:"out"`
+	Compress   *string  `json:"compress,omitempty"`
+}
+
+// outputTarget is a location collections are written to by --outputMapFile.
+// It is a dump directory of its own, with its own manifest, which can be
+// restored like any other.
+type outputTarget struct {
+	out string
+	// storage is set when out is an object storage URL, in which case paths
+	// are relative to it
+	storage  storage.Backend
+	manifest *dumpManifest
+}
+
+// outputMapping is a parsed rule of --outputMapFile.
+type outputMapping struct {
+	matcher *ns.Matcher
+	codec   compression.Codec
+	target  *outputTarget
+}
+
+// parseOutputMap parses the rules of an --outputMapFile, which is a list of
+// them in JSON or YAML.
+func parseOutputMap(content []byte) ([]outputMapRule, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	var rules []outputMapRule
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("expected a list of rules with namespaces, out and compress: %v", err)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no rules found")
+	}
+	for i, rule := range rules {
+		switch {
+		case len(rule.Namespaces) == 0:
+			return nil, fmt.Errorf("rule %v has no namespaces", i+1)
+		case rule.Out == "":
+			return nil, fmt.Errorf("rule %v has no out", i+1)
+		case rule.Out == "-":
+			return nil, fmt.Errorf("rule %v can't write to stdout", i+1)
+		}
+	}
+	return rules, nil
+}
+
+// outputKey returns the key an output location is compared by.
+func outputKey(out string) string {
+	if storage.IsRemote(out) {
+		return strings.TrimSuffix(out, "/")
+	}
+	return filepath.Clean(out)
+}
+
+// initOutputMap reads the --outputMapFile and opens the storage of each of
+// its locations. Rules writing to the same location share it.
+func (dump *MongoDump) initOutputMap() error {
+	content, err := util.ReadConfigFile(dump.OutputOptions.OutputMapFile)
+	if err != nil {
+		return fmt.Errorf("error reading --outputMapFile: %v", err)
+	}
+	rules, err := parseOutputMap(content)
+	if err != nil {
+		return fmt.Errorf("error parsing --outputMapFile: %v", err)
+	}
+
+	targets := map[string]*outputTarget{}
+	for i, rule := range rules {
+		mapping := &outputMapping{codec: dump.outputCodec()}
+		if mapping.matcher, err = ns.NewMatcher(rule.Namespaces); err != nil {
+			return fmt.Errorf("invalid namespaces in rule %v of --outputMapFile: %v", i+1, err)
+		}
+		if rule.Compress != nil {
+			if mapping.codec, err = compression.Parse(*rule.Compress); err != nil {
+				return fmt.Errorf("invalid compress in rule %v of --outputMapFile: %v", i+1, err)
+			}
+			mapping.codec.Workers = dump.OutputOptions.CompressionWorkers
+		}
+
+		key := outputKey(rule.Out)
+		if key == outputKey(dump.outputRoot()) {
+			return fmt.Errorf("rule %v of --outputMapFile writes to --out; leave those namespaces out of the map instead", i+1)
+		}
+		if mapping.target = targets[key]; mapping.target == nil {
+			mapping.target = &outputTarget{out: rule.Out}
+			if storage.IsRemote(rule.Out) {
+				if mapping.target.storage, err = storage.Open(rule.Out); err != nil {
+					return err
+				}
+				mapping.target.manifest = &dumpManifest{files: map[string]*manifestFile{}}
+			} else {
+				mapping.target.manifest = &dumpManifest{root: rule.Out, files: map[string]*manifestFile{}}
+			}
+			targets[key] = mapping.target
+			dump.outputTargets = append(dump.outputTargets, mapping.target)
+		}
+		dump.outputMappings = append(dump.outputMappings, mapping)
+	}
+	return nil
+}
+
+// outputMappingFor returns the first --outputMapFile rule matching a
+// collection, or nil when it is written to --out. The oplog and the files
+// of whole databases are always written to --out.
+func (dump *MongoDump) outputMappingFor(dbName, colName string) *outputMapping {
+	if dbName == "" || colName == "" {
+		return nil
+	}
+	for _, mapping := range dump.outputMappings {
+		if mapping.matcher.Has(dbName + "." + colName) {
+			return mapping
+		}
+	}
+	return nil
+}
+
+// collectionOutput returns the storage, compression and manifest of a
+// collection's files.
+func (dump *MongoDump) collectionOutput(dbName, colName string) (storage.Backend, compression.Codec, *dumpManifest) {
+	if mapping := dump.outputMappingFor(dbName, colName); mapping != nil {
+		return mapping.target.storage, mapping.codec, mapping.target.manifest
+	}
+	return dump.outputStorage, dump.outputCodec(), dump.manifest
+}
+
+// intentOutputBuffer returns the buffer to write an intent's files through:
+// the worker's own, or a new one when --outputMapFile compresses the intent
+// differently from the rest of the dump.
+func (dump *MongoDump) intentOutputBuffer(intent *intents.Intent, buffer resettableOutputBuffer) resettableOutputBuffer {
+	mapping := dump.outputMappingFor(intent.DB, intent.C)
+	if mapping == nil || mapping.codec == dump.outputCodec() {
+		return buffer
+	}
+	return newOutputBuffer(mapping.codec)
+}
+
+// writeTargetManifests writes a manifest to each location of the
+// --outputMapFile, with the details of m and the files written there.
+func (dump *MongoDump) writeTargetManifests(m *manifest) error {
+	for _, target := range dump.outputTargets {
+		targetManifest := *m
+		targetManifest.Files = target.manifest.list()
+		data, err := json.MarshalIndent(&targetManifest, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err = target.writeFile(archive.ManifestFile, data); err != nil {
+			return fmt.Errorf("error writing dump manifest to %v: %v", target.out, err)
+		}
+	}
+	return nil
+}
+
+// writeFile writes a file to the root of the location.
+func (t *outputTarget) writeFile(name string, data []byte) error {
+	if t.storage != nil {
+		out, err := t.storage.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err = out.Write(data); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+	if err := os.MkdirAll(t.out, defaultPermissions); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(t.out, name), data, 0644)
+}
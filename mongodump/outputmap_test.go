@@ -0,0 +1,142 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseOutputMap(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Rules are parsed in order", t, func() {
+		rules, err := parseOutputMap([]byte(`[
+			{"namespaces": ["app.events*"], "out": "/fast/dump", "compress": "zstd:3"},
+			{"namespaces": ["archive.*", "app.logs"], "out": "s3://cold/dump"}
+		]`))
+		So(err, ShouldBeNil)
+		So(rules, ShouldHaveLength, 2)
+		So(rules[0].Namespaces, ShouldResemble, []string{"app.events*"})
+		So(*rules[0].Compress, ShouldEqual, "zstd:3")
+		So(rules[1].Out, ShouldEqual, "s3://cold/dump")
+		So(rules[1].Compress, ShouldBeNil)
+	})
+
+	Convey("Invalid maps are rejected", t, func() {
+		for _, content := range []string{
+			`{"namespaces": ["a.b"], "out": "x"}`,
+			`[]`,
+			`[{"out": "x"}]`,
+			`[{"namespaces": ["a.b"]}]`,
+			`[{"namespaces": ["a.b"], "out": "-"}]`,
+			`[{"namespaces": ["a.b"], "out": "x", "gzip": true}]`,
+		} {
+			_, err := parseOutputMap([]byte(content))
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestOutputMap(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With an --outputMapFile", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump-outputmap")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		fast, cold := filepath.Join(dir, "fast"), filepath.Join(dir, "cold")
+
+		mapFile := filepath.Join(dir, "outputs.yaml")
+		So(ioutil.WriteFile(mapFile, []byte(`
+- namespaces: [app.events]
+  out: `+fast+`
+  compress: zstd
+- namespaces: ["archive.*"]
+  out: `+cold+`
+  compress: none
+- namespaces: [app.clicks]
+  out: `+fast+`/
+`), 0644), ShouldBeNil)
+
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.Out = filepath.Join(dir, "dump")
+		md.OutputOptions.Compress = "gzip"
+		md.OutputOptions.OutputMapFile = mapFile
+		md.manifest = md.newManifest()
+		So(md.initOutputMap(), ShouldBeNil)
+		So(md.outputTargets, ShouldHaveLength, 2)
+
+		Convey("Matching collections are written to their own location", func() {
+			So(md.outputPath("app", "events"), ShouldEqual, filepath.Join(fast, "app", "events"))
+			So(md.outputPath("app", "clicks"), ShouldEqual, filepath.Join(fast, "app", "clicks"))
+			So(md.outputPath("archive", "2019"), ShouldEqual, filepath.Join(cold, "archive", "2019"))
+			So(md.outputPath("app", "users"), ShouldEqual, filepath.Join(dir, "dump", "app", "users"))
+			So(md.outputPath("app", ""), ShouldEqual, filepath.Join(dir, "dump", "app"))
+		})
+
+		Convey("Each rule compresses with its own codec, or that of the dump", func() {
+			_, codec, _ := md.collectionOutput("app", "events")
+			So(codec.Name, ShouldEqual, compression.ZstdName)
+			_, codec, _ = md.collectionOutput("archive", "2019")
+			So(codec.IsNone(), ShouldBeTrue)
+			_, codec, _ = md.collectionOutput("app", "clicks")
+			So(codec.Name, ShouldEqual, compression.GzipName)
+
+			buffer := md.getResettableOutputBuffer()
+			So(md.intentOutputBuffer(&intents.Intent{DB: "app", C: "clicks"}, buffer), ShouldEqual, buffer)
+			So(md.intentOutputBuffer(&intents.Intent{DB: "app", C: "users"}, buffer), ShouldEqual, buffer)
+			So(md.intentOutputBuffer(&intents.Intent{DB: "app", C: "events"}, buffer), ShouldNotEqual, buffer)
+		})
+
+		Convey("Each location gets a manifest of its own files", func() {
+			intent, err := md.NewIntentFromOptions("app", &db.CollectionInfo{
+				Name:    "events",
+				Type:    "view",
+				Options: bson.M{"viewOn": "raw"},
+			})
+			So(err, ShouldBeNil)
+			So(intent.MetadataFile.(*realMetadataFile).path, ShouldEqual, filepath.Join(fast, "app", "events.metadata.json.zst"))
+			So(intent.MetadataFile.Open(), ShouldBeNil)
+			_, err = intent.MetadataFile.Write([]byte("{}"))
+			So(err, ShouldBeNil)
+			So(intent.MetadataFile.Close(), ShouldBeNil)
+
+			So(md.writeTargetManifests(&manifest{Version: manifestVersion}), ShouldBeNil)
+			m, err := readManifest(filepath.Join(fast, archive.ManifestFile))
+			So(err, ShouldBeNil)
+			So(m.Files, ShouldHaveLength, 1)
+			So(m.Files[0].Path, ShouldEqual, "app/events.metadata.json.zst")
+			m, err = readManifest(filepath.Join(cold, archive.ManifestFile))
+			So(err, ShouldBeNil)
+			So(m.Files, ShouldBeEmpty)
+			So(md.manifest.list(), ShouldBeEmpty)
+		})
+	})
+
+	Convey("A rule can't write to --out", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump-outputmap")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		mapFile := filepath.Join(dir, "outputs.json")
+		So(ioutil.WriteFile(mapFile, []byte(`[{"namespaces": ["a.b"], "out": "dump/"}]`), 0644), ShouldBeNil)
+
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.OutputMapFile = mapFile
+		So(md.initOutputMap(), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,88 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// parsePipelines parses --pipeline or --pipelineFile. An array of stages is
+// the pipeline of --collection, and a document maps namespaces to the
+// pipeline for each, where namespaces may be given as bare collection names
+// when a database is specified.
+func parsePipelines(content []byte, dbName, collection string) (map[string]bson.A, error) {
+	// the content is wrapped in a document since it may be an array
+	var doc bson.D
+	wrapped := append(append([]byte(`{"pipeline":`), content...), '}')
+	if err := bson.UnmarshalExtJSON(wrapped, false, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing pipeline as Extended JSON: %v", err)
+	}
+
+	switch value := doc[0].Value.(type) {
+	case bson.A:
+		if collection == "" {
+			return nil, fmt.Errorf("cannot dump using a pipeline of stages without a specified collection; " +
+				"give a document mapping namespaces to pipelines instead")
+		}
+		namespace := dbName + "." + collection
+		if err := validatePipeline(namespace, value); err != nil {
+			return nil, err
+		}
+		return map[string]bson.A{namespace: value}, nil
+	case bson.D:
+		pipelines := make(map[string]bson.A, len(value))
+		for _, elem := range value {
+			namespace, err := qualifyNamespace(elem.Key, dbName, "pipeline")
+			if err != nil {
+				return nil, err
+			}
+			stages, ok := elem.Value.(bson.A)
+			if !ok {
+				return nil, fmt.Errorf("pipeline for '%v' must be an array of stages", elem.Key)
+			}
+			if _, exists := pipelines[namespace]; exists {
+				return nil, fmt.Errorf("more than one pipeline is given for '%v'", namespace)
+			}
+			if err := validatePipeline(namespace, stages); err != nil {
+				return nil, err
+			}
+			pipelines[namespace] = stages
+		}
+		return pipelines, nil
+	default:
+		return nil, fmt.Errorf("pipeline must be an array of stages or a document mapping namespaces to pipelines")
+	}
+}
+
+// validatePipeline checks that each stage of a pipeline is a document with a
+// single stage operator, and that the pipeline doesn't write its results.
+func validatePipeline(namespace string, stages bson.A) error {
+	for i, value := range stages {
+		stage, ok := value.(bson.D)
+		if !ok || len(stage) != 1 || !strings.HasPrefix(stage[0].Key, "$") {
+			return fmt.Errorf("stage %v of the pipeline for '%v' must be a document with one stage operator", i, namespace)
+		}
+		switch stage[0].Key {
+		case "$out", "$merge":
+			return fmt.Errorf("the pipeline for '%v' cannot use %v, since its results are dumped", namespace, stage[0].Key)
+		}
+	}
+	return nil
+}
+
+// pipeline returns the aggregation pipeline to dump an intent through, or nil
+// to dump it with a find. The oplog and views are never dumped through one.
+func (dump *MongoDump) pipeline(intent *intents.Intent) bson.A {
+	if intent.IsOplog() || intent.IsView() && !dump.OutputOptions.ViewsAsCollections {
+		return nil
+	}
+	return dump.pipelines[intent.Namespace()]
+}
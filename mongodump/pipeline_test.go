@@ -0,0 +1,104 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const namespacePipelineYAML = `
+# drop the attachments of messages, and only keep active users
+test.messages:
+  - $project: {attachments: 0}
+users:
+  - $match: {active: true}
+  - $unset: [password]
+`
+
+func TestParsePipelines(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("An array of stages is the pipeline of --collection", t, func() {
+		pipelines, err := parsePipelines([]byte(`[{"$match": {"x": {"$gt": 1}}}, {"$project": {"payload": 0}}]`), "test", "blobs")
+		So(err, ShouldBeNil)
+		So(pipelines, ShouldResemble, map[string]bson.A{
+			"test.blobs": {
+				bson.D{{"$match", bson.D{{"x", bson.D{{"$gt", int32(1)}}}}}},
+				bson.D{{"$project", bson.D{{"payload", int32(0)}}}},
+			},
+		})
+
+		_, err = parsePipelines([]byte(`[{"$project": {"payload": 0}}]`), "test", "")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("A YAML pipelineFile maps namespaces to pipelines", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump_pipelinefile")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "pipelines.yaml")
+		So(ioutil.WriteFile(path, []byte(namespacePipelineYAML), 0644), ShouldBeNil)
+		content, err := (&InputOptions{PipelineFile: path}).GetPipeline()
+		So(err, ShouldBeNil)
+
+		pipelines, err := parsePipelines(content, "test", "")
+		So(err, ShouldBeNil)
+		So(pipelines, ShouldResemble, map[string]bson.A{
+			"test.messages": {bson.D{{"$project", bson.D{{"attachments", int32(0)}}}}},
+			"test.users": {
+				bson.D{{"$match", bson.D{{"active", true}}}},
+				bson.D{{"$unset", bson.A{"password"}}},
+			},
+		})
+
+		Convey("bare collection names need --db", func() {
+			_, err := parsePipelines(content, "", "")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Invalid pipelines are rejected", t, func() {
+		for _, content := range []string{
+			`{"test.a": {"$match": {}}}`,
+			`[{"$match": {}, "$project": {"a": 1}}]`,
+			`[{"match": {}}]`,
+			`[{"$out": "copy"}]`,
+			`[{"$merge": {"into": "copy"}}]`,
+			`"$match"`,
+			`[{"$match": `,
+		} {
+			_, err := parsePipelines([]byte(content), "test", "a")
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestIntentPipeline(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Only the intents of namespaces with a pipeline are dumped through one", t, func() {
+		md := simpleMongoDumpInstance()
+		stages := bson.A{bson.D{{"$project", bson.D{{"payload", 0}}}}}
+		md.pipelines = map[string]bson.A{"test.blobs": stages, "test.view": stages}
+
+		So(md.pipeline(&intents.Intent{DB: "test", C: "blobs"}), ShouldResemble, stages)
+		So(md.pipeline(&intents.Intent{DB: "test", C: "other"}), ShouldBeNil)
+
+		view := &intents.Intent{DB: "test", C: "view", Options: bson.M{"viewOn": "blobs"}}
+		So(md.pipeline(view), ShouldBeNil)
+		md.OutputOptions.ViewsAsCollections = true
+		So(md.pipeline(view), ShouldResemble, stages)
+	})
+}
@@ -17,11 +17,14 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/mongodb/mongo-tools-common/archive"
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/intents"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"github.com/mongodb/mongo-tools/common/util"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
 )
 
 type NilPos struct{}
@@ -65,6 +68,21 @@ type realBSONFile struct {
 	errorReader
 	intent *intents.Intent
 	NilPos
+
+	// checkpoint is set when the dump of this file is checkpointed, and
+	// resumeAt is the length of the file to keep when resuming it
+	checkpoint *collectionCheckpoint
+	resumeAt   int64
+
+	// storage is set when dumping to object storage, in which case path is
+	// relative to the storage location
+	storage storage.Backend
+	// key is set when the file is encrypted
+	key []byte
+	// manifest records the file's size and checksum
+	manifest *dumpManifest
+	// splitSize is set to split the file into segments of at most that size
+	splitSize int64
 }
 
 // Open is part of the intents.file interface. realBSONFiles need to have Open called before
@@ -75,17 +93,78 @@ func (f *realBSONFile) Open() (err error) {
 		return fmt.Errorf("error creating BSON file without a path, namespace: %v",
 			f.intent.Namespace())
 	}
-	err = os.MkdirAll(filepath.Dir(f.path), os.ModeDir|os.ModePerm)
+	if f.storage == nil {
+		err = os.MkdirAll(filepath.Dir(f.path), os.ModeDir|os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("error creating directory for BSON file %v: %v",
+				filepath.Dir(f.path), err)
+		}
+		if f.resumeAt > 0 {
+			return f.openForResume()
+		}
+	}
+
+	if f.splitSize > 0 {
+		f.WriteCloser, err = util.NewSegmentWriter(f.splitSize, func(index int) (io.WriteCloser, error) {
+			return f.create(util.SegmentPath(f.path, index))
+		})
+	} else {
+		f.WriteCloser, err = f.create(f.path)
+	}
 	if err != nil {
-		return fmt.Errorf("error creating directory for BSON file %v: %v",
-			filepath.Dir(f.path), err)
+		return err
 	}
+	f.WriteCloser, err = encryptTo(f.WriteCloser, f.key)
+	return err
+}
 
-	f.WriteCloser, err = os.Create(f.path)
+// create creates the file at path, which is the BSON file or one of its
+// segments.
+func (f *realBSONFile) create(path string) (io.WriteCloser, error) {
+	if f.storage != nil {
+		out, err := f.storage.Create(filepath.ToSlash(path))
+		if err != nil {
+			return nil, fmt.Errorf("error creating BSON file %v: %v", f.storage.Location(filepath.ToSlash(path)), err)
+		}
+		return f.manifest.track(path, out), nil
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating BSON file %v: %v", path, err)
+	}
+	return f.manifest.track(path, out), nil
+}
+
+// openForResume opens an existing BSON file for appending, after discarding
+// anything written past the checkpointed position.
+func (f *realBSONFile) openForResume() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("error opening BSON file %v to resume: %v", f.path, err)
+	}
+	if err = file.Truncate(f.resumeAt); err == nil {
+		_, err = file.Seek(0, io.SeekEnd)
+	}
 	if err != nil {
-		return fmt.Errorf("error creating BSON file %v: %v", f.path, err)
+		file.Close()
+		return fmt.Errorf("error opening BSON file %v to resume: %v", f.path, err)
 	}
+	if f.WriteCloser, err = f.manifest.trackResumed(f.path, f.resumeAt, file); err != nil {
+		file.Close()
+		return fmt.Errorf("error opening BSON file %v to resume: %v", f.path, err)
+	}
+	return nil
+}
 
+// sync commits the file's contents to stable storage.
+func (f *realBSONFile) sync() error {
+	w := f.WriteCloser
+	if tracked, ok := w.(*manifestWriter); ok {
+		w = tracked.WriteCloser
+	}
+	if file, ok := w.(*os.File); ok {
+		return file.Sync()
+	}
 	return nil
 }
 
@@ -98,6 +177,12 @@ type realMetadataFile struct {
 	// intent.file ( a ReadWriteOpenCloser )
 	intent *intents.Intent
 	NilPos
+	// storage is set when dumping to object storage
+	storage storage.Backend
+	// key is set when the file is encrypted
+	key []byte
+	// manifest records the file's size and checksum
+	manifest *dumpManifest
 }
 
 // Open opens the file on disk that the intent indicates. Any directories needed are created.
@@ -105,6 +190,15 @@ func (f *realMetadataFile) Open() (err error) {
 	if f.path == "" {
 		return fmt.Errorf("No metadata path for %v.%v", f.intent.DB, f.intent.C)
 	}
+	if f.storage != nil {
+		f.WriteCloser, err = f.storage.Create(filepath.ToSlash(f.path))
+		if err != nil {
+			return fmt.Errorf("error creating metadata file %v: %v", f.storage.Location(filepath.ToSlash(f.path)), err)
+		}
+		f.WriteCloser = f.manifest.track(f.path, f.WriteCloser)
+		f.WriteCloser, err = encryptTo(f.WriteCloser, f.key)
+		return err
+	}
 	err = os.MkdirAll(filepath.Dir(f.path), os.ModeDir|os.ModePerm)
 	if err != nil {
 		return fmt.Errorf("error creating directory for metadata file %v: %v",
@@ -115,7 +209,9 @@ func (f *realMetadataFile) Open() (err error) {
 	if err != nil {
 		return fmt.Errorf("error creating metadata file %v: %v", f.path, err)
 	}
-	return nil
+	f.WriteCloser = f.manifest.track(f.path, f.WriteCloser)
+	f.WriteCloser, err = encryptTo(f.WriteCloser, f.key)
+	return err
 }
 
 // stdoutFile implements the intents.file interface. stdoutFiles are used when single collections
@@ -187,32 +283,77 @@ func (dump *MongoDump) shouldSkipCollection(colName string) bool {
 	return false
 }
 
+// initNamespaceMatchers parses the patterns of --nsInclude and --nsExclude.
+func (dump *MongoDump) initNamespaceMatchers() (err error) {
+	if len(dump.OutputOptions.NSInclude) > 0 {
+		if dump.includer, err = ns.NewMatcher(dump.OutputOptions.NSInclude); err != nil {
+			return fmt.Errorf("invalid includes: %v", err)
+		}
+	}
+	if len(dump.OutputOptions.NSExclude) > 0 {
+		if dump.excluder, err = ns.NewMatcher(dump.OutputOptions.NSExclude); err != nil {
+			return fmt.Errorf("invalid excludes: %v", err)
+		}
+	}
+	return nil
+}
+
+// isNamespaceSelected returns whether a namespace is included and not
+// excluded by --nsInclude and --nsExclude.
+func (dump *MongoDump) isNamespaceSelected(dbName, colName string) bool {
+	namespace := dbName + "." + colName
+	if dump.includer != nil && !dump.includer.Has(namespace) {
+		return false
+	}
+	return dump.excluder == nil || !dump.excluder.Has(namespace)
+}
+
 // outputPath creates a path for the collection to be written to (sans file extension).
 func (dump *MongoDump) outputPath(dbName, colName string) string {
 	var root string
-	if dump.OutputOptions.Out == "" {
+	backend, codec, _ := dump.collectionOutput(dbName, colName)
+	switch mapping := dump.outputMappingFor(dbName, colName); {
+	case backend != nil:
+		// paths are relative to the storage location
+	case mapping != nil:
+		root = mapping.target.out
+	case dump.OutputOptions.Out == "":
 		root = "dump"
-	} else {
+	default:
 		root = dump.OutputOptions.Out
 	}
 
 	// Encode a new output path for collection names that would result in a file name greater
-	// than 255 bytes long. This includes the longest possible file extension: .metadata.json.gz
+	// than 255 bytes long. This includes the longest possible file extension: .metadata.json.gz,
+	// or .metadata.json.zst and .metadata.json.lz4, which leave one byte less for the name.
 	// The new format is <truncated-url-encoded-collection-name>%24<collection-name-hash-base64>
 	// where %24 represents a $ symbol delimiter (e.g. aVeryVery...VeryLongName%24oPpXMQ...).
+	maxLength := 238
+	if ext := codec.Extension(); len(ext) > len(".gz") {
+		maxLength -= len(ext) - len(".gz")
+	}
 	escapedColName := util.EscapeCollectionName(colName)
-	if len(escapedColName) > 238 {
-		colNameTruncated := escapedColName[:208]
+	if len(escapedColName) > maxLength {
+		colNameTruncated := escapedColName[:maxLength-30]
 		colNameHashBytes := sha1.Sum([]byte(colName))
 		colNameHashBase64 := base64.RawURLEncoding.EncodeToString(colNameHashBytes[:])
 
-		// First 208 bytes of col name + 3 bytes delimiter + 27 bytes base64 hash = 238 bytes max.
+		// First maxLength-30 bytes of col name + 3 bytes delimiter + 27 bytes base64 hash = maxLength bytes.
 		escapedColName = colNameTruncated + "%24" + colNameHashBase64
 	}
 
 	return filepath.Join(root, dbName, escapedColName)
 }
 
+// outputLocation returns the location of an output path in backend, which
+// is nil for local paths, for messages.
+func outputLocation(backend storage.Backend, path string) string {
+	if backend != nil {
+		return backend.Location(filepath.ToSlash(path))
+	}
+	return path
+}
+
 // CreateOplogIntents creates an intents.Intent for the oplog and adds it to the manager
 func (dump *MongoDump) CreateOplogIntents() error {
 	err := dump.determineOplogCollectionName()
@@ -226,7 +367,7 @@ func (dump *MongoDump) CreateOplogIntents() error {
 	if dump.OutputOptions.Archive != "" {
 		oplogIntent.BSONFile = &archive.MuxIn{Mux: dump.archive.Mux, Intent: oplogIntent}
 	} else {
-		oplogIntent.BSONFile = &realBSONFile{path: dump.outputPath("oplog.bson", ""), intent: oplogIntent}
+		oplogIntent.BSONFile = &realBSONFile{path: dump.outputPath("oplog.bson", ""), intent: oplogIntent, storage: dump.outputStorage, key: dump.dataKey, manifest: dump.manifest, splitSize: dump.splitSize}
 	}
 	dump.manager.Put(oplogIntent)
 	return nil
@@ -256,9 +397,9 @@ func (dump *MongoDump) CreateUsersRolesVersionIntentsForDB(db string) error {
 		rolesIntent.BSONFile = &archive.MuxIn{Intent: rolesIntent, Mux: dump.archive.Mux}
 		versionIntent.BSONFile = &archive.MuxIn{Intent: versionIntent, Mux: dump.archive.Mux}
 	} else {
-		usersIntent.BSONFile = &realBSONFile{path: filepath.Join(outDir, nameGz(dump.OutputOptions.Gzip, "$admin.system.users.bson")), intent: usersIntent}
-		rolesIntent.BSONFile = &realBSONFile{path: filepath.Join(outDir, nameGz(dump.OutputOptions.Gzip, "$admin.system.roles.bson")), intent: rolesIntent}
-		versionIntent.BSONFile = &realBSONFile{path: filepath.Join(outDir, nameGz(dump.OutputOptions.Gzip, "$admin.system.version.bson")), intent: versionIntent}
+		usersIntent.BSONFile = &realBSONFile{path: filepath.Join(outDir, nameCompressed(dump.outputCodec(), "$admin.system.users.bson")), intent: usersIntent, storage: dump.outputStorage, key: dump.dataKey, manifest: dump.manifest, splitSize: dump.splitSize}
+		rolesIntent.BSONFile = &realBSONFile{path: filepath.Join(outDir, nameCompressed(dump.outputCodec(), "$admin.system.roles.bson")), intent: rolesIntent, storage: dump.outputStorage, key: dump.dataKey, manifest: dump.manifest, splitSize: dump.splitSize}
+		versionIntent.BSONFile = &realBSONFile{path: filepath.Join(outDir, nameCompressed(dump.outputCodec(), "$admin.system.version.bson")), intent: versionIntent, storage: dump.outputStorage, key: dump.dataKey, manifest: dump.manifest, splitSize: dump.splitSize}
 	}
 	dump.manager.Put(usersIntent)
 	dump.manager.Put(rolesIntent)
@@ -272,6 +413,7 @@ func (dump *MongoDump) CreateUsersRolesVersionIntentsForDB(db string) error {
 func (dump *MongoDump) CreateCollectionIntent(dbName, colName string) error {
 	if dump.shouldSkipCollection(colName) {
 		log.Logvf(log.DebugLow, "skipping dump of %v.%v, it is excluded", dbName, colName)
+		log.Summary().AddSkipped(dbName+"."+colName, "excluded")
 		return nil
 	}
 
@@ -284,6 +426,11 @@ func (dump *MongoDump) CreateCollectionIntent(dbName, colName string) error {
 	if err != nil {
 		return fmt.Errorf("error getting collection options: %v", err)
 	}
+	if dump.OutputOptions.IndexesOnly && collOptions != nil && collOptions.IsView() {
+		log.Logvf(log.Always, "not dumping %v.%v because it is a view, which has no indexes", dbName, colName)
+		log.Summary().AddSkipped(dbName+"."+colName, "view")
+		return nil
+	}
 
 	intent, err := dump.NewIntentFromOptions(dbName, collOptions)
 	if err != nil {
@@ -305,7 +452,8 @@ func (dump *MongoDump) NewIntentFromOptions(dbName string, ci *db.CollectionInfo
 	intent.UUID = ci.GetUUID()
 
 	// Setup output location
-	if dump.OutputOptions.Out == "-" { // regular standard output
+	backend, codec, manifest := dump.collectionOutput(dbName, ci.Name)
+	if dump.collectionToStdout() { // regular standard output
 		intent.BSONFile = &stdoutFile{Writer: dump.OutputWriter}
 	} else {
 		// Set the BSONFile path.
@@ -318,12 +466,14 @@ func (dump *MongoDump) NewIntentFromOptions(dbName string, ci *db.CollectionInfo
 			} else {
 				intent.Location = fmt.Sprintf("archive '%v'", dump.OutputOptions.Archive)
 			}
+		} else if dump.skipsData(intent) {
+			log.Logvf(log.DebugLow, "not dumping data for %v.%v with --metadataOnly or --indexesOnly", dbName, ci.Name)
 		} else if dump.OutputOptions.ViewsAsCollections || !ci.IsView() {
 			// otherwise, if it's either not a view or we're treating views as collections
 			// then create a standard filesystem path for this collection.
-			path := nameGz(dump.OutputOptions.Gzip, dump.outputPath(dbName, ci.Name)+".bson")
-			intent.BSONFile = &realBSONFile{path: path, intent: intent}
-			intent.Location = path
+			path := nameCompressed(codec, dump.outputPath(dbName, ci.Name)+".bson")
+			intent.BSONFile = &realBSONFile{path: path, intent: intent, storage: backend, key: dump.dataKey, manifest: manifest, splitSize: dump.splitSize}
+			intent.Location = outputLocation(backend, path)
 		} else {
 			// otherwise, it's a view and the options specify not dumping a view
 			// so don't dump it.
@@ -342,8 +492,8 @@ func (dump *MongoDump) NewIntentFromOptions(dbName string, ci *db.CollectionInfo
 					Buffer: &bytes.Buffer{},
 				}
 			} else {
-				path := nameGz(dump.OutputOptions.Gzip, dump.outputPath(dbName, ci.Name)+".metadata.json")
-				intent.MetadataFile = &realMetadataFile{path: path, intent: intent}
+				path := nameCompressed(codec, dump.outputPath(dbName, ci.Name)+".metadata.json")
+				intent.MetadataFile = &realMetadataFile{path: path, intent: intent, storage: backend, key: dump.dataKey, manifest: manifest}
 			}
 		}
 	}
@@ -395,13 +545,25 @@ func (dump *MongoDump) CreateIntentsForDatabase(dbName string) error {
 			log.Logvf(log.DebugHigh, "will not dump system collection '%s.%s'", dbName, collInfo.Name)
 			continue
 		}
+		if !dump.isNamespaceSelected(dbName, collInfo.Name) {
+			log.Logvf(log.DebugLow, "skipping dump of %v.%v, it does not match --nsInclude or matches --nsExclude", dbName, collInfo.Name)
+			log.Summary().AddSkipped(dbName+"."+collInfo.Name, "excluded")
+			continue
+		}
 		if dump.shouldSkipCollection(collInfo.Name) {
 			log.Logvf(log.DebugLow, "skipping dump of %v.%v, it is excluded", dbName, collInfo.Name)
+			log.Summary().AddSkipped(dbName+"."+collInfo.Name, "excluded")
 			continue
 		}
 
+		if dump.OutputOptions.IndexesOnly && collInfo.IsView() {
+			log.Logvf(log.DebugLow, "skipping dump of %v.%v because it is a view, which has no indexes", dbName, collInfo.Name)
+			log.Summary().AddSkipped(dbName+"."+collInfo.Name, "view")
+			continue
+		}
 		if dump.OutputOptions.ViewsAsCollections && !collInfo.IsView() {
 			log.Logvf(log.DebugLow, "skipping dump of %v.%v because it is not a view", dbName, collInfo.Name)
+			log.Summary().AddSkipped(dbName+"."+collInfo.Name, "not a view")
 			continue
 		}
 		intent, err := dump.NewIntentFromOptions(dbName, collInfo)
@@ -433,9 +595,6 @@ func (dump *MongoDump) CreateAllIntents() error {
 	return nil
 }
 
-func nameGz(gz bool, name string) string {
-	if gz {
-		return name + ".gz"
-	}
-	return name
+func nameCompressed(codec compression.Codec, name string) string {
+	return name + codec.Extension()
 }
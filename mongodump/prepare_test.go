@@ -7,9 +7,15 @@
 package mongodump
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
-	"github.com/mongodb/mongo-tools-common/testtype"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/common/util"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -57,6 +63,38 @@ func TestSkipCollection(t *testing.T) {
 
 }
 
+func TestNamespaceSelection(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a mongodump that includes 'app_*.orders_2024*' and 'reports.*' "+
+		"but excludes '*.tmp_*' and /_old$/", t, func() {
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.NSInclude = []string{"app_*.orders_2024*", "/^reports\\./"}
+		md.OutputOptions.NSExclude = []string{"*.tmp_*", "/_old$/"}
+		So(md.initNamespaceMatchers(), ShouldBeNil)
+
+		for _, test := range []testTable{
+			{"app_eu", "orders_2024", true},
+			{"app_eu", "orders_2024_03", true},
+			{"reports", "monthly", true},
+			{"app_eu", "orders_2023", false},
+			{"shop", "orders_2024", false},
+			{"reports", "tmp_build", false},
+			{"app_eu", "orders_2024_old", false},
+		} {
+			So(md.isNamespaceSelected(test.db, test.coll), ShouldEqual, test.output)
+		}
+	})
+
+	Convey("An invalid regular expression is an error", t, func() {
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.NSExclude = []string{"/tmp_(/"}
+		err := md.initNamespaceMatchers()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "invalid excludes")
+	})
+}
+
 type testTable struct {
 	db     string
 	coll   string
@@ -99,3 +137,84 @@ func TestShouldSkipSystemNamespace(t *testing.T) {
 		}
 	}
 }
+
+func TestSplitBSONFile(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("A BSON file with a split size is written in numbered segments", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump_split")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		data := bytes.Repeat([]byte("0123456789"), 25)
+		path := filepath.Join(dir, "db", "c.bson")
+		manifest := &dumpManifest{root: dir, files: map[string]*manifestFile{}}
+		file := &realBSONFile{path: path, intent: &intents.Intent{DB: "db", C: "c"}, splitSize: 100, manifest: manifest}
+		So(file.Open(), ShouldBeNil)
+		_, err = file.Write(data[:150])
+		So(err, ShouldBeNil)
+		_, err = file.Write(data[150:])
+		So(err, ShouldBeNil)
+		So(file.Close(), ShouldBeNil)
+
+		var joined []byte
+		for i, size := range []int{100, 100, 50} {
+			segment, err := ioutil.ReadFile(util.SegmentPath(path, i))
+			So(err, ShouldBeNil)
+			So(segment, ShouldHaveLength, size)
+			joined = append(joined, segment...)
+		}
+		So(joined, ShouldResemble, data)
+		_, err = os.Stat(util.SegmentPath(path, 3))
+		So(os.IsNotExist(err), ShouldBeTrue)
+		_, err = os.Stat(path)
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		So(manifest.files, ShouldHaveLength, 3)
+		So(manifest.files["db/c.bson.part0002"].Size, ShouldEqual, 50)
+	})
+
+	Convey("An empty BSON file with a split size still has a segment", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump_split")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "db", "view.bson")
+		file := &realBSONFile{path: path, intent: &intents.Intent{DB: "db", C: "view"}, splitSize: 100}
+		So(file.Open(), ShouldBeNil)
+		So(file.Close(), ShouldBeNil)
+		info, err := os.Stat(util.SegmentPath(path, 0))
+		So(err, ShouldBeNil)
+		So(info.Size(), ShouldEqual, 0)
+	})
+}
+
+func TestSkipsData(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	collection := &intents.Intent{DB: "app", C: "orders"}
+	users := &intents.Intent{DB: "admin", C: "system.users"}
+	indexes := &intents.Intent{DB: "app", C: "system.indexes"}
+
+	Convey("By default every collection's data is dumped", t, func() {
+		md := simpleMongoDumpInstance()
+		So(md.skipsData(collection), ShouldBeFalse)
+		So(md.skipsData(users), ShouldBeFalse)
+	})
+
+	Convey("--metadataOnly still dumps users, roles and legacy indexes", t, func() {
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.MetadataOnly = true
+		So(md.skipsData(collection), ShouldBeTrue)
+		So(md.skipsData(users), ShouldBeFalse)
+		So(md.skipsData(indexes), ShouldBeFalse)
+	})
+
+	Convey("--indexesOnly dumps only legacy indexes", t, func() {
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.IndexesOnly = true
+		So(md.skipsData(collection), ShouldBeTrue)
+		So(md.skipsData(users), ShouldBeTrue)
+		So(md.skipsData(indexes), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,75 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// parseNamespaceQueries parses a namespaceQueryFile, which maps namespaces to
+// the query filter for each. Namespaces may be given as bare collection names
+// when a database is specified.
+func parseNamespaceQueries(content []byte, dbName string) (map[string]bson.D, error) {
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON(content, false, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing namespaceQueryFile as Extended JSON: %v", err)
+	}
+
+	queries := make(map[string]bson.D, len(doc))
+	for _, elem := range doc {
+		namespace := elem.Key
+		if !strings.Contains(namespace, ".") {
+			if dbName == "" {
+				return nil, fmt.Errorf("namespaceQueryFile namespace '%v' must be of the form <database>.<collection> without --db", namespace)
+			}
+			namespace = dbName + "." + namespace
+		} else if dbName != "" && !strings.HasPrefix(namespace, dbName+".") {
+			return nil, fmt.Errorf("namespaceQueryFile namespace '%v' is not in database '%v'", namespace, dbName)
+		}
+		filter, ok := elem.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("query filter for '%v' in namespaceQueryFile must be a document", elem.Key)
+		}
+		if _, exists := queries[namespace]; exists {
+			return nil, fmt.Errorf("namespaceQueryFile has more than one query filter for '%v'", namespace)
+		}
+		queries[namespace] = filter
+	}
+	return queries, nil
+}
+
+// queryFilter returns the query filter to use for an intent: the --query or
+// --queryFile filter, the intent's filter from a namespaceQueryFile, or nil.
+func (dump *MongoDump) queryFilter(intent *intents.Intent) bson.D {
+	if len(dump.query) > 0 {
+		return dump.query
+	}
+	return dump.namespaceQueries[intent.Namespace()]
+}
+
+// checkNamespaceQueries checks that every namespace of a namespaceQueryFile
+// is being dumped, since a filter for a namespace that is misspelled or
+// excluded would otherwise be silently ignored.
+func (dump *MongoDump) checkNamespaceQueries() error {
+	var unmatched []string
+	for namespace := range dump.namespaceQueries {
+		if dump.manager.IntentForNamespace(namespace) == nil {
+			unmatched = append(unmatched, namespace)
+		}
+	}
+	if len(unmatched) == 0 {
+		return nil
+	}
+	sort.Strings(unmatched)
+	return fmt.Errorf("namespaceQueryFile has query filters for namespaces that are not being dumped: %v",
+		strings.Join(unmatched, ", "))
+}
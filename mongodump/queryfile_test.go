@@ -0,0 +1,128 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const namespaceQueryYAML = `
+# dump recent orders and the users in one region
+test.orders:
+  createdAt: {$gte: {$date: "2020-01-01T00:00:00Z"}}
+other.users:
+  region: 'emea'
+  $or:
+    - active: true
+    - plan:
+        $in: [pro, enterprise]
+`
+
+func TestParseNamespaceQueries(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a namespaceQueryFile mapping namespaces to filters", t, func() {
+		expected := map[string]bson.D{
+			"test.orders": {{"createdAt", bson.D{{"$gte", primitive.NewDateTimeFromTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))}}}},
+			"other.users": {
+				{"region", "emea"},
+				{"$or", bson.A{
+					bson.D{{"active", true}},
+					bson.D{{"plan", bson.D{{"$in", bson.A{"pro", "enterprise"}}}}},
+				}},
+			},
+		}
+
+		Convey("YAML files are converted to Extended JSON", func() {
+			dir, err := ioutil.TempDir("", "mongodump_queryfile")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "queries.yaml")
+			So(ioutil.WriteFile(path, []byte(namespaceQueryYAML), 0644), ShouldBeNil)
+			inputOptions := &InputOptions{NamespaceQueryFile: path}
+			content, err := inputOptions.GetQuery()
+			So(err, ShouldBeNil)
+
+			queries, err := parseNamespaceQueries(content, "")
+			So(err, ShouldBeNil)
+			So(queries, ShouldResemble, expected)
+		})
+
+		Convey("bare collection names use the database from --db", func() {
+			queries, err := parseNamespaceQueries([]byte(`{"orders": {"x": 1}, "test.users": {}}`), "test")
+			So(err, ShouldBeNil)
+			So(queries, ShouldResemble, map[string]bson.D{
+				"test.orders": {{"x", int32(1)}},
+				"test.users":  {},
+			})
+		})
+
+		Convey("invalid files are rejected", func() {
+			_, err := parseNamespaceQueries([]byte(`{"orders": {}}`), "")
+			So(err, ShouldNotBeNil)
+			_, err = parseNamespaceQueries([]byte(`{"other.orders": {}}`), "test")
+			So(err, ShouldNotBeNil)
+			_, err = parseNamespaceQueries([]byte(`{"test.orders": 1}`), "")
+			So(err, ShouldNotBeNil)
+			_, err = parseNamespaceQueries([]byte(`{"orders": {}, "test.orders": {}}`), "test")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("--query applies to every intent, otherwise the namespace filter does", t, func() {
+		md := simpleMongoDumpInstance()
+		md.namespaceQueries = map[string]bson.D{"test.orders": {{"x", 1}}}
+		So(md.queryFilter(&intents.Intent{DB: "test", C: "orders"}), ShouldResemble, bson.D{{"x", 1}})
+		So(md.queryFilter(&intents.Intent{DB: "test", C: "users"}), ShouldBeNil)
+
+		md.query = bson.D{{"y", 1}}
+		So(md.queryFilter(&intents.Intent{DB: "test", C: "users"}), ShouldResemble, bson.D{{"y", 1}})
+	})
+
+	Convey("--namespaceQueryFile is used without --collection, and --queryFile with one", t, func() {
+		md := simpleMongoDumpInstance()
+		md.ToolOptions.Namespace.Collection = ""
+		md.InputOptions.NamespaceQueryFile = "queries.json"
+		So(md.ValidateOptions(), ShouldBeNil)
+
+		md.InputOptions.QueryFile = "query.json"
+		So(md.ValidateOptions(), ShouldNotBeNil)
+
+		md.InputOptions.NamespaceQueryFile = ""
+		So(md.ValidateOptions(), ShouldNotBeNil)
+		md.ToolOptions.Namespace.Collection = "orders"
+		So(md.ValidateOptions(), ShouldBeNil)
+
+		md.InputOptions.NamespaceQueryFile = "queries.json"
+		md.InputOptions.QueryFile = ""
+		So(md.ValidateOptions(), ShouldNotBeNil)
+	})
+
+	Convey("every namespace of a namespaceQueryFile must be dumped", t, func() {
+		md := simpleMongoDumpInstance()
+		md.manager = intents.NewIntentManager()
+		md.manager.Put(&intents.Intent{DB: "test", C: "orders"})
+		md.namespaceQueries = map[string]bson.D{"test.orders": {{"x", 1}}}
+		So(md.checkNamespaceQueries(), ShouldBeNil)
+
+		md.namespaceQueries["test.order"] = bson.D{{"x", 1}}
+		md.namespaceQueries["other.users"] = bson.D{}
+		err := md.checkNamespaceQueries()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEndWith, "not being dumped: other.users, test.order")
+	})
+}
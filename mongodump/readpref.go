@@ -0,0 +1,88 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"go.mongodb.org/mongo-driver/bson"
+	mopt "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// parseShardReadPreferences parses the values of --shardReadPreference into
+// the read preference of each shard, by shard ID.
+func parseShardReadPreferences(values []string) (map[string]*readpref.ReadPref, error) {
+	prefs := map[string]*readpref.ReadPref{}
+	for _, value := range values {
+		i := strings.Index(value, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("'%v' is not of the form <shard>=<read preference>", value)
+		}
+		shard := value[:i]
+		if _, ok := prefs[shard]; ok {
+			return nil, fmt.Errorf("shard %v is given more than once", shard)
+		}
+		pref, err := db.NewReadPreference(value[i+1:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid read preference for shard %v: %v", shard, err)
+		}
+		prefs[shard] = pref
+	}
+	return prefs, nil
+}
+
+// memberStatus is the part of an isMaster reply that a member's
+// replication lag is measured from.
+type memberStatus struct {
+	Me        string `bson:"me"`
+	LastWrite struct {
+		LastWriteDate time.Time `bson:"lastWriteDate"`
+	} `bson:"lastWrite"`
+}
+
+// checkReplicationLag fails if the replica set member that the read
+// preference selects is further behind its primary than --maxReplicationLag.
+// Members selected by a primary read preference are never behind.
+func (dump *MongoDump) checkReplicationLag(session *db.SessionProvider, pref *readpref.ReadPref, name string) error {
+	if pref == nil || pref.Mode() == readpref.PrimaryMode {
+		return nil
+	}
+	client, err := session.GetSession()
+	if err != nil {
+		return err
+	}
+	admin := client.Database("admin")
+
+	var primary, member memberStatus
+	err = admin.RunCommand(context.Background(), bson.D{{"isMaster", 1}},
+		mopt.RunCmd().SetReadPreference(readpref.Primary())).Decode(&primary)
+	if err != nil {
+		return fmt.Errorf("error getting the last write of the primary of %v: %v", name, err)
+	}
+	err = admin.RunCommand(context.Background(), bson.D{{"isMaster", 1}},
+		mopt.RunCmd().SetReadPreference(pref)).Decode(&member)
+	if err != nil {
+		return fmt.Errorf("error getting the last write of the member of %v to read from: %v", name, err)
+	}
+
+	lag := primary.LastWrite.LastWriteDate.Sub(member.LastWrite.LastWriteDate)
+	if lag < 0 {
+		lag = 0
+	}
+	if lag > dump.maxReplicationLag {
+		return fmt.Errorf("%v member %v is %v behind its primary, more than --maxReplicationLag %v",
+			name, member.Me, lag, dump.maxReplicationLag)
+	}
+	log.Logvf(log.Info, "reading %v from %v, which is %v behind its primary", name, member.Me, lag)
+	return nil
+}
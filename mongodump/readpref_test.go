@@ -0,0 +1,50 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func TestShardReadPreferences(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Shard read preferences are a mode or document for each shard", t, func() {
+		prefs, err := parseShardReadPreferences([]string{
+			"shard01=secondary",
+			`shard02={"mode": "secondaryPreferred", "tagSets": [{"use": "analytics"}]}`,
+		})
+		So(err, ShouldBeNil)
+		So(prefs, ShouldHaveLength, 2)
+		So(prefs["shard01"].Mode(), ShouldEqual, readpref.SecondaryMode)
+		So(prefs["shard02"].Mode(), ShouldEqual, readpref.SecondaryPreferredMode)
+		So(prefs["shard02"].TagSets(), ShouldHaveLength, 1)
+		So(prefs["shard02"].TagSets()[0][0].Value, ShouldEqual, "analytics")
+	})
+
+	Convey("Malformed and repeated shard read preferences are rejected", t, func() {
+		_, err := parseShardReadPreferences([]string{"secondary"})
+		So(err, ShouldNotBeNil)
+		_, err = parseShardReadPreferences([]string{"shard01=sideways"})
+		So(err, ShouldNotBeNil)
+		_, err = parseShardReadPreferences([]string{"shard01=secondary", "shard01=nearest"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("--shardReadPreference requires --coordinateShards", t, func() {
+		md := simpleMongoDumpInstance()
+		md.InputOptions.ShardReadPreference = []string{"shard01=secondary"}
+		So(md.ValidateOptions(), ShouldNotBeNil)
+		md.OutputOptions.CoordinateShards = true
+		md.OutputOptions.Archive = "dump.archive"
+		So(md.ValidateOptions(), ShouldBeNil)
+	})
+}
@@ -0,0 +1,212 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxRetryDelay caps the delay between retries of a cursor.
+const maxRetryDelay = 30 * time.Second
+
+// transientErrorCodes are the codes of server errors that a new cursor may
+// not run into: a killed or timed out cursor, a stepdown or shutdown of the
+// node, and network failures reported by the server.
+var transientErrorCodes = map[int32]bool{
+	6:     true, // HostUnreachable
+	7:     true, // HostNotFound
+	43:    true, // CursorNotFound
+	89:    true, // NetworkTimeout
+	91:    true, // ShutdownInProgress
+	189:   true, // PrimarySteppedDown
+	9001:  true, // SocketException
+	10107: true, // NotWritablePrimary
+	11600: true, // InterruptedAtShutdown
+	11602: true, // InterruptedDueToReplStateChange
+	13435: true, // NotPrimaryNoSecondaryOk
+	13436: true, // NotPrimaryOrSecondary
+}
+
+// isTransientError returns whether reading a collection may succeed with a
+// new cursor after failing with err.
+func isTransientError(err error) bool {
+	switch e := err.(type) {
+	case mongo.CommandError:
+		return e.HasErrorLabel("NetworkError") || transientErrorCodes[e.Code]
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// documentCursor is what a collection's documents are dumped from.
+type documentCursor interface {
+	Next(ctx context.Context) bool
+	Document() bson.Raw
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// plainCursor is a documentCursor that fails with its cursor.
+type plainCursor struct {
+	*mongo.Cursor
+}
+
+func (c plainCursor) Document() bson.Raw {
+	return c.Current
+}
+
+// resumableCursor is a documentCursor that reopens its query after a
+// transient error, up to --maxRetries times in a row. A query that reads the
+// _id index resumes after the last _id read; any other query skips the
+// documents that were already read.
+type resumableCursor struct {
+	dump   *MongoDump
+	query  *db.DeferredQuery
+	cursor *mongo.Cursor
+	byID   bool
+
+	read    int64
+	lastID  bson.RawValue
+	retries int
+	// skipLast is set after resuming at the last _id read, which the
+	// inclusive bound reads again
+	skipLast bool
+	err      error
+}
+
+// RemainingBatchLength returns the number of documents of the current cursor
+// that have already been fetched.
+func (c *resumableCursor) RemainingBatchLength() int {
+	if c.cursor == nil {
+		return 0
+	}
+	return c.cursor.RemainingBatchLength()
+}
+
+// openCursor runs the query, returning a cursor that retries transient
+// errors when --maxRetries is set.
+func (dump *MongoDump) openCursor(query *db.DeferredQuery, retry bool) (documentCursor, error) {
+	if !retry || dump.InputOptions.MaxRetries == 0 {
+		cursor, err := query.Iter()
+		if err != nil {
+			return nil, err
+		}
+		return plainCursor{cursor}, nil
+	}
+	c := &resumableCursor{dump: dump, query: query, byID: readsIDIndex(query)}
+	var err error
+	if c.cursor, err = query.Iter(); err != nil {
+		if err = c.reopen(err); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// readsIDIndex returns whether a query returns documents in _id order.
+func readsIDIndex(query *db.DeferredQuery) bool {
+	hint, ok := query.Hint.(bson.D)
+	return ok && len(hint) == 1 && hint[0].Key == "_id"
+}
+
+// resumeQuery returns the query that reads the documents after those
+// already read.
+func (c *resumableCursor) resumeQuery() *db.DeferredQuery {
+	query := *c.query
+	switch {
+	case c.read == 0:
+	case c.byID:
+		// an index bound, unlike a filter, matches _id values of any type
+		query.Min = bson.D{{"_id", c.lastID}}
+		c.skipLast = true
+	default:
+		query.Skip += c.read
+	}
+	return &query
+}
+
+// retryDelay returns the delay before the given retry, numbered from 1.
+func (dump *MongoDump) retryDelay(retry int) time.Duration {
+	delay := dump.retryBackoff
+	for i := 1; i < retry && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+// reopen replaces the cursor after it failed with err, if err is transient
+// and there are retries left. It returns the error that ended the retries.
+func (c *resumableCursor) reopen(err error) error {
+	for isTransientError(err) && c.retries < c.dump.InputOptions.MaxRetries {
+		c.retries++
+		delay := c.dump.retryDelay(c.retries)
+		log.Logvf(log.Always, "reading %v.%v failed after %v %v, retrying in %v (%v of %v): %v",
+			c.query.Coll.Database().Name(), c.query.Coll.Name(), c.read, docPlural(c.read),
+			delay, c.retries, c.dump.InputOptions.MaxRetries, err)
+		time.Sleep(delay)
+		var cursor *mongo.Cursor
+		if cursor, err = c.resumeQuery().Iter(); err == nil {
+			c.cursor = cursor
+			return nil
+		}
+	}
+	return err
+}
+
+func (c *resumableCursor) Next(ctx context.Context) bool {
+	for c.err == nil {
+		if c.cursor.Next(ctx) {
+			if c.skipLast {
+				c.skipLast = false
+				id := c.cursor.Current.Lookup("_id")
+				if id.Type == c.lastID.Type && bytes.Equal(id.Value, c.lastID.Value) {
+					continue
+				}
+			}
+			c.read++
+			c.retries = 0
+			if c.byID {
+				// the cursor reuses its buffer, so the _id is copied
+				id := c.cursor.Current.Lookup("_id")
+				c.lastID.Type = id.Type
+				c.lastID.Value = append(c.lastID.Value[:0], id.Value...)
+			}
+			return true
+		}
+		err := c.cursor.Err()
+		if err == nil {
+			return false
+		}
+		c.cursor.Close(ctx)
+		c.err = c.reopen(err)
+	}
+	return false
+}
+
+func (c *resumableCursor) Document() bson.Raw {
+	return c.cursor.Current
+}
+
+func (c *resumableCursor) Err() error {
+	return c.err
+}
+
+func (c *resumableCursor) Close(ctx context.Context) error {
+	return c.cursor.Close(ctx)
+}
@@ -0,0 +1,77 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestCursorRetry(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Killed cursors, stepdowns and network errors are transient", t, func() {
+		So(isTransientError(mongo.CommandError{Code: 43, Name: "CursorNotFound"}), ShouldBeTrue)
+		So(isTransientError(mongo.CommandError{Code: 11602}), ShouldBeTrue)
+		So(isTransientError(mongo.CommandError{Labels: []string{"NetworkError"}}), ShouldBeTrue)
+		So(isTransientError(mongo.CommandError{Code: 13, Name: "Unauthorized"}), ShouldBeFalse)
+		So(isTransientError(fmt.Errorf("invalid document")), ShouldBeFalse)
+	})
+
+	Convey("The delay between retries doubles up to a limit", t, func() {
+		md := simpleMongoDumpInstance()
+		md.retryBackoff = time.Second
+		So(md.retryDelay(1), ShouldEqual, time.Second)
+		So(md.retryDelay(3), ShouldEqual, 4*time.Second)
+		So(md.retryDelay(10), ShouldEqual, maxRetryDelay)
+	})
+
+	Convey("A query in _id order resumes after the last _id read", t, func() {
+		query := &db.DeferredQuery{Hint: bson.D{{"_id", 1}}, Max: bson.D{{"_id", 100}}}
+		c := &resumableCursor{query: query, byID: readsIDIndex(query)}
+		So(c.byID, ShouldBeTrue)
+		So(c.resumeQuery(), ShouldResemble, query)
+		So(c.skipLast, ShouldBeFalse)
+
+		_, value, err := bson.MarshalValue(int32(42))
+		So(err, ShouldBeNil)
+		c.read, c.lastID = 10, bson.RawValue{Type: bsontype.Int32, Value: value}
+		resumed := c.resumeQuery()
+		So(resumed.Min, ShouldResemble, bson.D{{"_id", c.lastID}})
+		So(resumed.Max, ShouldResemble, query.Max)
+		So(c.skipLast, ShouldBeTrue)
+		So(query.Min, ShouldBeNil)
+	})
+
+	Convey("Any other query skips the documents already read", t, func() {
+		query := &db.DeferredQuery{Filter: bson.D{{"x", 1}}, Skip: 5}
+		c := &resumableCursor{query: query, byID: readsIDIndex(query), read: 10}
+		So(c.byID, ShouldBeFalse)
+		So(c.resumeQuery().Skip, ShouldEqual, 15)
+	})
+
+	Convey("--maxRetries can't be negative and --retryBackoff must be a duration", t, func() {
+		md := simpleMongoDumpInstance()
+		md.InputOptions.MaxRetries = -1
+		So(md.ValidateOptions(), ShouldNotBeNil)
+		md.InputOptions.MaxRetries = 3
+		So(md.ValidateOptions(), ShouldBeNil)
+
+		md.InputOptions.RetryBackoff = "soon"
+		err := md.Init()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "--retryBackoff")
+	})
+}
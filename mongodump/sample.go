@@ -0,0 +1,432 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// sampleSize is how much of a collection is dumped with --sample or
+// --sampleDocs: a percentage of its documents, or a number of them.
+type sampleSize struct {
+	percent float64
+	docs    int64
+}
+
+// qualifyNamespace returns a namespace given as a bare collection name in
+// the database specified by --db, checking that it is in that database.
+func qualifyNamespace(namespace, dbName, what string) (string, error) {
+	if !strings.Contains(namespace, ".") {
+		if dbName == "" {
+			return "", fmt.Errorf("%v namespace '%v' must be of the form <database>.<collection> without --db", what, namespace)
+		}
+		return dbName + "." + namespace, nil
+	}
+	if dbName != "" && !strings.HasPrefix(namespace, dbName+".") {
+		return "", fmt.Errorf("%v namespace '%v' is not in database '%v'", what, namespace, dbName)
+	}
+	return namespace, nil
+}
+
+// parseSampleSizes parses --sample and --sampleDocs, each value of which is
+// [<namespace>=]<size>. The size of a value without a namespace, which is
+// keyed by "", applies to every collection not given its own.
+func parseSampleSizes(percents, docs []string, dbName string) (map[string]sampleSize, error) {
+	sizes := map[string]sampleSize{}
+	add := func(value, option string, parse func(string) (sampleSize, error)) error {
+		namespace, size := "", value
+		if i := strings.LastIndex(value, "="); i >= 0 {
+			var err error
+			if namespace, err = qualifyNamespace(value[:i], dbName, option); err != nil {
+				return err
+			}
+			size = value[i+1:]
+		}
+		if _, exists := sizes[namespace]; exists {
+			if namespace == "" {
+				return fmt.Errorf("more than one sample size is given for all collections")
+			}
+			return fmt.Errorf("more than one sample size is given for '%v'", namespace)
+		}
+		parsed, err := parse(size)
+		if err != nil {
+			return fmt.Errorf("invalid %v '%v': %v", option, value, err)
+		}
+		sizes[namespace] = parsed
+		return nil
+	}
+
+	for _, value := range percents {
+		err := add(value, "--sample", func(size string) (sampleSize, error) {
+			if !strings.HasSuffix(size, "%") {
+				return sampleSize{}, fmt.Errorf("expected a percentage, e.g. '1%%'")
+			}
+			percent, err := strconv.ParseFloat(strings.TrimSuffix(size, "%"), 64)
+			if err != nil || percent <= 0 || percent > 100 {
+				return sampleSize{}, fmt.Errorf("percentage must be greater than 0 and at most 100")
+			}
+			return sampleSize{percent: percent}, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, value := range docs {
+		err := add(value, "--sampleDocs", func(size string) (sampleSize, error) {
+			n, err := strconv.ParseInt(size, 10, 64)
+			if err != nil || n <= 0 {
+				return sampleSize{}, fmt.Errorf("number of documents must be a positive integer")
+			}
+			return sampleSize{docs: n}, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sizes, nil
+}
+
+// sampleReference is a field of the documents sampled from source, whose
+// values are the _ids of documents of target that are always dumped.
+type sampleReference struct {
+	source string
+	field  string
+	target string
+}
+
+// parseSampleReferences parses --sampleReference, each value of which is
+// <namespace>:<field>=<namespace>. A collection can't refer to itself, and
+// the references can't form a cycle, since a referred to collection is only
+// dumped once those referring to it are.
+func parseSampleReferences(values []string, dbName string) ([]sampleReference, error) {
+	var references []sampleReference
+	for _, value := range values {
+		i := strings.LastIndex(value, "=")
+		j := -1
+		if i > 0 {
+			j = strings.LastIndex(value[:i], ":")
+		}
+		if j <= 0 || j == i-1 || i == len(value)-1 {
+			return nil, fmt.Errorf("invalid --sampleReference '%v': expected <namespace>:<field>=<namespace>", value)
+		}
+		source, err := qualifyNamespace(value[:j], dbName, "--sampleReference")
+		if err != nil {
+			return nil, err
+		}
+		target, err := qualifyNamespace(value[i+1:], dbName, "--sampleReference")
+		if err != nil {
+			return nil, err
+		}
+		if source == target {
+			return nil, fmt.Errorf("invalid --sampleReference '%v': a collection cannot refer to itself", value)
+		}
+		references = append(references, sampleReference{source: source, field: value[j+1 : i], target: target})
+	}
+
+	// a cycle is found by following the references from each collection
+	var visit func(namespace string, path []string) error
+	visit = func(namespace string, path []string) error {
+		for _, seen := range path {
+			if seen == namespace {
+				return fmt.Errorf("--sampleReference refers in a cycle: %v", strings.Join(append(path, namespace), " -> "))
+			}
+		}
+		for _, reference := range references {
+			if reference.source == namespace {
+				if err := visit(reference.target, append(path, namespace)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, reference := range references {
+		if err := visit(reference.source, nil); err != nil {
+			return nil, err
+		}
+	}
+	return references, nil
+}
+
+// sampleSize returns how much of an intent is sampled, or false if it is
+// dumped whole. The oplog, special collections and views dumped as views
+// are never sampled.
+func (dump *MongoDump) sampleSize(intent *intents.Intent) (sampleSize, bool) {
+	if len(dump.samples) == 0 || intent.IsOplog() || intent.IsSpecialCollection() ||
+		intent.IsView() && !dump.OutputOptions.ViewsAsCollections {
+		return sampleSize{}, false
+	}
+	if size, ok := dump.samples[intent.Namespace()]; ok {
+		return size, true
+	}
+	size, ok := dump.samples[""]
+	return size, ok
+}
+
+// sampleStages returns the stages that sample an intent from coll, or nil if
+// it isn't sampled. A percentage is of the estimated count of the whole
+// collection, before any --query is applied.
+func (dump *MongoDump) sampleStages(intent *intents.Intent, coll *mongo.Collection) (bson.A, error) {
+	size, ok := dump.sampleSize(intent)
+	if !ok {
+		return nil, nil
+	}
+	docs := size.docs
+	if size.percent > 0 {
+		count, err := coll.EstimatedDocumentCount(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("error counting %v to sample it: %v", intent.Namespace(), err)
+		}
+		docs = int64(math.Ceil(float64(count) * size.percent / 100))
+		if docs == 0 {
+			docs = 1
+		}
+	}
+	return bson.A{
+		bson.D{{"$sample", bson.D{{"size", docs}}}},
+		// $sample can return the same document more than once
+		bson.D{{"$group", bson.D{{"_id", "$_id"}, {"doc", bson.D{{"$first", "$$ROOT"}}}}}},
+		bson.D{{"$replaceRoot", bson.D{{"newRoot", "$doc"}}}},
+	}, nil
+}
+
+// sampleReferences collects the values of the --sampleReference fields of
+// the documents dumped from each collection, and holds back dumping the
+// sampled collections they refer to until those collections are dumped.
+// Its methods do nothing on a nil *sampleReferences.
+type sampleReferences struct {
+	references []sampleReference
+
+	mutex sync.Mutex
+	// active are the references whose source and sampled target are both
+	// dumped
+	active []sampleReference
+	// depth is how many held back collections precede each held back one
+	// in the chain of references, plus one
+	depth    map[string]int
+	deferred []*intents.Intent
+	// finished is closed once a referring collection has been dumped
+	finished map[string]chan struct{}
+	closed   map[string]bool
+	// ids are the referenced values for each target, by their type and bytes
+	ids map[string]map[string]bson.RawValue
+}
+
+func newSampleReferences(references []sampleReference) *sampleReferences {
+	return &sampleReferences{
+		references: references,
+		depth:      map[string]int{},
+		finished:   map[string]chan struct{}{},
+		closed:     map[string]bool{},
+		ids:        map[string]map[string]bson.RawValue{},
+	}
+}
+
+// prepare finds the references between the intents being dumped whose
+// target is sampled, since a collection dumped whole already holds every
+// document referred to.
+func (s *sampleReferences) prepare(all []*intents.Intent, sampled func(*intents.Intent) bool) {
+	if s == nil {
+		return
+	}
+	dumped, isSampled := map[string]bool{}, map[string]bool{}
+	for _, intent := range all {
+		dumped[intent.Namespace()] = true
+		isSampled[intent.Namespace()] = sampled(intent)
+	}
+	for _, reference := range s.references {
+		if dumped[reference.source] && isSampled[reference.target] {
+			s.active = append(s.active, reference)
+			s.finished[reference.source] = make(chan struct{})
+			s.ids[reference.target] = map[string]bson.RawValue{}
+		}
+	}
+	// the references don't form a cycle, so this ends
+	var depth func(namespace string) int
+	depth = func(namespace string) int {
+		d := 0
+		for _, reference := range s.active {
+			if reference.target == namespace {
+				if sourceDepth := depth(reference.source) + 1; sourceDepth > d {
+					d = sourceDepth
+				}
+			}
+		}
+		return d
+	}
+	for _, reference := range s.active {
+		s.depth[reference.target] = depth(reference.target)
+	}
+}
+
+// deferIntent holds back an intent referred to by others, returning false
+// if it can be dumped now.
+func (s *sampleReferences) deferIntent(intent *intents.Intent) bool {
+	if s == nil {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.depth[intent.Namespace()] == 0 {
+		return false
+	}
+	s.deferred = append(s.deferred, intent)
+	return true
+}
+
+// nextDeferred returns the held back intent earliest in its chain of
+// references, so that every intent waited on is being dumped or is taken
+// before the one waiting on it.
+func (s *sampleReferences) nextDeferred() *intents.Intent {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.deferred) == 0 {
+		return nil
+	}
+	next := 0
+	for i, intent := range s.deferred {
+		if s.depth[intent.Namespace()] < s.depth[s.deferred[next].Namespace()] {
+			next = i
+		}
+	}
+	intent := s.deferred[next]
+	s.deferred = append(s.deferred[:next], s.deferred[next+1:]...)
+	return intent
+}
+
+// wait blocks until the collections referring to an intent are dumped.
+func (s *sampleReferences) wait(intent *intents.Intent) {
+	if s == nil {
+		return
+	}
+	var waits []chan struct{}
+	s.mutex.Lock()
+	for _, reference := range s.active {
+		if reference.target == intent.Namespace() {
+			waits = append(waits, s.finished[reference.source])
+		}
+	}
+	s.mutex.Unlock()
+	for _, finished := range waits {
+		<-finished
+	}
+}
+
+// finish records that an intent has been dumped.
+func (s *sampleReferences) finish(intent *intents.Intent) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if finished, ok := s.finished[intent.Namespace()]; ok && !s.closed[intent.Namespace()] {
+		s.closed[intent.Namespace()] = true
+		close(finished)
+	}
+}
+
+// collector returns a validator that records the referenced values of each
+// document dumped from an intent, or nil if it refers to no sample.
+func (s *sampleReferences) collector(intent *intents.Intent) documentValidator {
+	if s == nil {
+		return nil
+	}
+	var references []sampleReference
+	for _, reference := range s.active {
+		if reference.source == intent.Namespace() {
+			references = append(references, reference)
+		}
+	}
+	if len(references) == 0 {
+		return nil
+	}
+	return func(doc []byte) error {
+		for _, reference := range references {
+			values := appendFieldValues(nil, bson.Raw(doc), strings.Split(reference.field, "."))
+			if len(values) == 0 {
+				continue
+			}
+			s.mutex.Lock()
+			ids := s.ids[reference.target]
+			for _, value := range values {
+				ids[string(rune(value.Type))+string(value.Value)] = value
+			}
+			s.mutex.Unlock()
+		}
+		return nil
+	}
+}
+
+// referenced returns the values referring to documents of an intent.
+func (s *sampleReferences) referenced(intent *intents.Intent) bson.A {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ids := s.ids[intent.Namespace()]
+	values := make(bson.A, 0, len(ids))
+	for _, value := range ids {
+		values = append(values, value)
+	}
+	return values
+}
+
+// appendFieldValues appends the values of a dotted field of doc to values,
+// following arrays along the path and adding each element of an array.
+func appendFieldValues(values []bson.RawValue, doc bson.Raw, path []string) []bson.RawValue {
+	value, err := doc.LookupErr(path[0])
+	if err != nil {
+		return values
+	}
+	elements := []bson.RawValue{value}
+	if array, ok := value.ArrayOK(); ok {
+		if elements, err = array.Values(); err != nil {
+			return values
+		}
+	}
+	for _, element := range elements {
+		switch {
+		case len(path) == 1:
+			values = append(values, element)
+		case element.Type == bsontype.EmbeddedDocument:
+			values = appendFieldValues(values, element.Document(), path[1:])
+		}
+	}
+	return values
+}
+
+// withReferencedDocuments adds to the query of a sampled intent one for the
+// documents referred to by collections already dumped, which are excluded
+// from the sample so that none is dumped twice.
+func (dump *MongoDump) withReferencedDocuments(intent *intents.Intent, queries []*db.DeferredQuery) []*db.DeferredQuery {
+	ids := dump.sampleRefs.referenced(intent)
+	if len(ids) == 0 {
+		return queries
+	}
+	sample := queries[0]
+	sample.Pipeline = append(sample.Pipeline, bson.D{{"$match", bson.D{{"_id", bson.D{{"$nin", ids}}}}}})
+	return append(queries, &db.DeferredQuery{
+		Coll:          sample.Coll,
+		Filter:        bson.D{{"_id", bson.D{{"$in", ids}}}},
+		AtClusterTime: sample.AtClusterTime,
+	})
+}
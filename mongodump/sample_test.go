@@ -0,0 +1,191 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseSampleSizes(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Sample sizes apply to every collection or to a namespace", t, func() {
+		sizes, err := parseSampleSizes([]string{"1%", "orders=0.5%"}, []string{"test.customers=1000"}, "test")
+		So(err, ShouldBeNil)
+		So(sizes, ShouldResemble, map[string]sampleSize{
+			"":               {percent: 1},
+			"test.orders":    {percent: 0.5},
+			"test.customers": {docs: 1000},
+		})
+	})
+
+	Convey("Invalid sample sizes are rejected", t, func() {
+		for _, percents := range [][]string{{"1"}, {"0%"}, {"101%"}, {"x%"}, {"orders=1%"}, {"1%", "2%"}} {
+			_, err := parseSampleSizes(percents, nil, "")
+			So(err, ShouldNotBeNil)
+		}
+		for _, docs := range [][]string{{"0"}, {"-5"}, {"1.5"}, {"test.orders=10", "test.orders=20"}} {
+			_, err := parseSampleSizes(nil, docs, "")
+			So(err, ShouldNotBeNil)
+		}
+		_, err := parseSampleSizes([]string{"other.orders=1%"}, nil, "test")
+		So(err, ShouldNotBeNil)
+		_, err = parseSampleSizes([]string{"test.orders=1%"}, []string{"test.orders=10"}, "")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestParseSampleReferences(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("A reference names a field of one namespace and the namespace it refers to", t, func() {
+		references, err := parseSampleReferences([]string{
+			"test.orders:customer.id=test.customers",
+			"lines:productId=products",
+		}, "test")
+		So(err, ShouldBeNil)
+		So(references, ShouldResemble, []sampleReference{
+			{source: "test.orders", field: "customer.id", target: "test.customers"},
+			{source: "test.lines", field: "productId", target: "test.products"},
+		})
+	})
+
+	Convey("Malformed references are rejected", t, func() {
+		for _, value := range []string{"test.orders", "test.orders=test.customers", ":id=test.customers",
+			"test.orders:=test.customers", "test.orders:id=", "orders:id=customers"} {
+			_, err := parseSampleReferences([]string{value}, "")
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("References to the same collection or in a cycle are rejected", t, func() {
+		_, err := parseSampleReferences([]string{"test.people:managerId=test.people"}, "")
+		So(err, ShouldNotBeNil)
+		_, err = parseSampleReferences([]string{"test.a:b=test.b", "test.b:c=test.c", "test.c:a=test.a"}, "")
+		So(err, ShouldNotBeNil)
+		_, err = parseSampleReferences([]string{"test.a:b=test.b", "test.b:c=test.c", "test.a:c=test.c"}, "")
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestAppendFieldValues(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("The values of a dotted field follow arrays along the path", t, func() {
+		doc, err := bson.Marshal(bson.D{
+			{"customerId", int32(7)},
+			{"tags", bson.A{"a", "b"}},
+			{"items", bson.A{
+				bson.D{{"productId", int32(1)}},
+				bson.D{{"productId", int32(2)}},
+				bson.D{{"other", int32(3)}},
+			}},
+			{"shipping", bson.D{{"address", bson.D{{"id", "home"}}}}},
+		})
+		So(err, ShouldBeNil)
+
+		valuesOf := func(field ...string) []interface{} {
+			var values []interface{}
+			for _, value := range appendFieldValues(nil, bson.Raw(doc), field) {
+				var v interface{}
+				So(value.Unmarshal(&v), ShouldBeNil)
+				values = append(values, v)
+			}
+			return values
+		}
+		So(valuesOf("customerId"), ShouldResemble, []interface{}{int32(7)})
+		So(valuesOf("tags"), ShouldResemble, []interface{}{"a", "b"})
+		So(valuesOf("items", "productId"), ShouldResemble, []interface{}{int32(1), int32(2)})
+		So(valuesOf("shipping", "address", "id"), ShouldResemble, []interface{}{"home"})
+		So(valuesOf("missing"), ShouldBeNil)
+		So(valuesOf("customerId", "x"), ShouldBeNil)
+	})
+}
+
+func TestSampleReferences(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	intent := func(c string) *intents.Intent { return &intents.Intent{DB: "test", C: c} }
+	orders, customers, regions, products := intent("orders"), intent("customers"), intent("regions"), intent("products")
+
+	Convey("With orders referring to customers, which refer to regions", t, func() {
+		refs := newSampleReferences([]sampleReference{
+			{source: "test.orders", field: "customerId", target: "test.customers"},
+			{source: "test.customers", field: "regionId", target: "test.regions"},
+			{source: "test.orders", field: "productId", target: "test.products"},
+		})
+		// products are dumped whole, so they don't wait for orders
+		refs.prepare([]*intents.Intent{regions, customers, orders, products}, func(intent *intents.Intent) bool {
+			return intent != products
+		})
+
+		Convey("referred to samples are held back and taken in the order of the references", func() {
+			So(refs.deferIntent(regions), ShouldBeTrue)
+			So(refs.deferIntent(customers), ShouldBeTrue)
+			So(refs.deferIntent(orders), ShouldBeFalse)
+			So(refs.deferIntent(products), ShouldBeFalse)
+			So(refs.nextDeferred(), ShouldEqual, customers)
+			So(refs.nextDeferred(), ShouldEqual, regions)
+			So(refs.nextDeferred(), ShouldBeNil)
+		})
+
+		Convey("the referenced values of the dumped documents are collected", func() {
+			So(refs.collector(products), ShouldBeNil)
+			collect := refs.collector(orders)
+			So(collect, ShouldNotBeNil)
+			for _, order := range []bson.D{
+				{{"customerId", int32(1)}, {"productId", int32(5)}},
+				{{"customerId", int32(2)}},
+				{{"customerId", int32(1)}},
+			} {
+				doc, err := bson.Marshal(order)
+				So(err, ShouldBeNil)
+				So(collect(doc), ShouldBeNil)
+			}
+			So(refs.referenced(customers), ShouldHaveLength, 2)
+			So(refs.referenced(regions), ShouldHaveLength, 0)
+			So(refs.referenced(products), ShouldHaveLength, 0)
+
+			refs.finish(orders)
+			refs.finish(orders)
+			refs.wait(customers)
+		})
+
+		Convey("the documents referred to are read besides the sample", func() {
+			collect := refs.collector(orders)
+			doc, err := bson.Marshal(bson.D{{"customerId", int32(1)}})
+			So(err, ShouldBeNil)
+			So(collect(doc), ShouldBeNil)
+
+			dump := &MongoDump{sampleRefs: refs}
+			sample := &db.DeferredQuery{Pipeline: bson.A{bson.D{{"$sample", bson.D{{"size", int64(10)}}}}}}
+			queries := dump.withReferencedDocuments(customers, []*db.DeferredQuery{sample})
+			So(queries, ShouldHaveLength, 2)
+			So(sample.Pipeline, ShouldHaveLength, 2)
+			So(queries[1].Filter, ShouldHaveLength, 1)
+
+			queries = dump.withReferencedDocuments(regions, []*db.DeferredQuery{{}})
+			So(queries, ShouldHaveLength, 1)
+		})
+	})
+
+	Convey("Without --sampleReference nothing is held back", t, func() {
+		var refs *sampleReferences
+		refs.prepare([]*intents.Intent{orders}, func(*intents.Intent) bool { return true })
+		So(refs.deferIntent(orders), ShouldBeFalse)
+		So(refs.nextDeferred(), ShouldBeNil)
+		So(refs.collector(orders), ShouldBeNil)
+		refs.finish(orders)
+		refs.wait(orders)
+	})
+}
@@ -0,0 +1,58 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCronSchedule(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	// a Wednesday
+	now := time.Date(2026, 3, 4, 10, 30, 15, 0, time.UTC)
+	next := func(spec string) string {
+		schedule, err := parseCronSchedule(spec)
+		So(err, ShouldBeNil)
+		if next := schedule.next(now); !next.IsZero() {
+			return next.Format(time.RFC3339)
+		}
+		return ""
+	}
+
+	Convey("Schedules match the next time after the given one", t, func() {
+		So(next("0 2 * * *"), ShouldEqual, "2026-03-05T02:00:00Z")
+		So(next("@daily"), ShouldEqual, "2026-03-05T00:00:00Z")
+		So(next("*/15 * * * *"), ShouldEqual, "2026-03-04T10:45:00Z")
+		So(next("30 10 * * *"), ShouldEqual, "2026-03-05T10:30:00Z")
+		So(next("0 9-17/4 * * 1-5"), ShouldEqual, "2026-03-04T13:00:00Z")
+		So(next("0 0 1 */3 *"), ShouldEqual, "2026-04-01T00:00:00Z")
+		So(next("0 3 * * 7"), ShouldEqual, "2026-03-08T03:00:00Z")
+		So(next("0 0 29 2 *"), ShouldEqual, "2028-02-29T00:00:00Z")
+	})
+
+	Convey("A day matches either restricted day field", t, func() {
+		// the 10th, or the next Friday
+		So(next("0 0 10 * 5"), ShouldEqual, "2026-03-06T00:00:00Z")
+	})
+
+	Convey("A schedule that never matches has no next time", t, func() {
+		So(next("0 0 30 2 *"), ShouldEqual, "")
+	})
+
+	Convey("Invalid schedules are rejected", t, func() {
+		for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *",
+			"* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *", "@sometimes"} {
+			_, err := parseCronSchedule(spec)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopt "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type configTag struct {
+	Tag string `bson:"tag"`
+	Min bson.D `bson:"min"`
+	Max bson.D `bson:"max"`
+}
+
+// shardingMetadata reads the shard key, chunks and zones of the intent's
+// collection from the config servers, returning nil if it isn't sharded.
+func (dump *MongoDump) shardingMetadata(intent *intents.Intent) (*archive.ShardingMetadata, error) {
+	config := dump.SessionProvider.DB("config")
+	ctx := context.Background()
+
+	var coll configCollection
+	err := config.Collection("collections").FindOne(ctx, bson.D{{"_id", intent.Namespace()}}).Decode(&coll)
+	if err == mongo.ErrNoDocuments || (err == nil && coll.Dropped) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading config.collections: %v", err)
+	}
+
+	filter := bson.D{{"ns", coll.ID}}
+	if coll.UUID != nil {
+		filter = bson.D{{"uuid", *coll.UUID}}
+	}
+	var chunks []configChunk
+	cursor, err := config.Collection("chunks").Find(ctx, filter, mopt.Find().SetSort(bson.D{{"min", 1}}))
+	if err == nil {
+		err = cursor.All(ctx, &chunks)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading config.chunks: %v", err)
+	}
+
+	var tags []configTag
+	cursor, err = config.Collection("tags").Find(ctx, bson.D{{"ns", coll.ID}}, mopt.Find().SetSort(bson.D{{"min", 1}}))
+	if err == nil {
+		err = cursor.All(ctx, &tags)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading config.tags: %v", err)
+	}
+	return newShardingMetadata(coll, chunks, tags)
+}
+
+// newShardingMetadata returns the sharding metadata of a collection from
+// its config.collections entry and its chunks and zone ranges sorted by
+// shard key. Each chunk but the first, which starts at MinKey, adds a split
+// point.
+func newShardingMetadata(coll configCollection, chunks []configChunk, tags []configTag) (*archive.ShardingMetadata, error) {
+	sharding := &archive.ShardingMetadata{Key: coll.Key, Unique: coll.Unique}
+	for i, chunk := range chunks {
+		if i == 0 {
+			continue
+		}
+		var point bson.D
+		if err := bson.Unmarshal(chunk.Min, &point); err != nil {
+			return nil, fmt.Errorf("error reading chunk bound of %v: %v", coll.ID, err)
+		}
+		sharding.SplitPoints = append(sharding.SplitPoints, point)
+	}
+	for _, tag := range tags {
+		sharding.Zones = append(sharding.Zones, archive.ShardZone{Zone: tag.Tag, Min: tag.Min, Max: tag.Max})
+	}
+	return sharding, nil
+}
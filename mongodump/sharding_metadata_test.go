@@ -0,0 +1,44 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestShardingMetadata(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("The chunks of a sharded collection become its split points", t, func() {
+		min, ten, twenty, max := chunkBound(primitive.MinKey{}), chunkBound(10), chunkBound(20), chunkBound(primitive.MaxKey{})
+		coll := configCollection{ID: "test.c", Key: bson.D{{"x", 1}}, Unique: true}
+		sharding, err := newShardingMetadata(coll, []configChunk{
+			{Min: min, Max: ten, Shard: "a"},
+			{Min: ten, Max: twenty, Shard: "b"},
+			{Min: twenty, Max: max, Shard: "a"},
+		}, []configTag{{Tag: "eu", Min: bson.D{{"x", int32(10)}}, Max: bson.D{{"x", int32(20)}}}})
+		So(err, ShouldBeNil)
+		So(sharding, ShouldResemble, &archive.ShardingMetadata{
+			Key:         bson.D{{"x", 1}},
+			Unique:      true,
+			SplitPoints: []bson.D{{{"x", int32(10)}}, {{"x", int32(20)}}},
+			Zones:       []archive.ShardZone{{Zone: "eu", Min: bson.D{{"x", int32(10)}}, Max: bson.D{{"x", int32(20)}}}},
+		})
+
+		Convey("and a single chunk has none", func() {
+			sharding, err := newShardingMetadata(coll, []configChunk{{Min: min, Max: max, Shard: "a"}}, nil)
+			So(err, ShouldBeNil)
+			So(sharding.SplitPoints, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,369 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopt "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// shardCoordinator reads every shard of a cluster directly at a single
+// cluster time, for --coordinateShards.
+type shardCoordinator struct {
+	snapshot *archive.ShardedSnapshot
+	// sessions holds a connection to each shard's replica set, by shard ID
+	sessions map[string]*db.SessionProvider
+	// primaries maps each database to the shard holding its unsharded
+	// collections
+	primaries map[string]string
+	// collections holds the config.collections entry of each sharded
+	// collection, by namespace
+	collections map[string]configCollection
+	// restartBalancer is set if the balancer was running before the dump
+	restartBalancer bool
+	// config is the config database, read through the mongos
+	config *mongo.Database
+}
+
+type configCollection struct {
+	ID      string            `bson:"_id"`
+	Key     bson.D            `bson:"key"`
+	Unique  bool              `bson:"unique"`
+	UUID    *primitive.Binary `bson:"uuid"`
+	Dropped bool              `bson:"dropped"`
+}
+
+type configChunk struct {
+	Min   bson.Raw `bson:"min"`
+	Max   bson.Raw `bson:"max"`
+	Shard string   `bson:"shard"`
+}
+
+// shardRange is a range of shard key values owned by one shard, with Min
+// inclusive and Max exclusive.
+type shardRange struct {
+	Shard string
+	Min   bson.Raw
+	Max   bson.Raw
+}
+
+// startShardCoordination stops the balancer, so that no chunks move while
+// the shards are read, chooses the cluster time to read every shard at, and
+// connects to each shard. Like --snapshot, it fails if the dump is estimated
+// to outlast the shards' minSnapshotHistoryWindowInSeconds.
+func (dump *MongoDump) startShardCoordination() (err error) {
+	version, err := dump.SessionProvider.ServerVersionArray()
+	if err != nil {
+		return err
+	}
+	if version.LT(db.Version{5, 0, 0}) {
+		return fmt.Errorf("--coordinateShards requires MongoDB 5.0 or later, found %v.%v.%v", version[0], version[1], version[2])
+	}
+
+	coordinator := &shardCoordinator{
+		snapshot:    &archive.ShardedSnapshot{},
+		sessions:    map[string]*db.SessionProvider{},
+		primaries:   map[string]string{},
+		collections: map[string]configCollection{},
+	}
+	dump.shards = coordinator
+	defer func() {
+		if err != nil {
+			dump.finishShardCoordination()
+		}
+	}()
+
+	var status struct {
+		Mode string `bson:"mode"`
+	}
+	if err = dump.SessionProvider.RunString("balancerStatus", &status, "admin"); err != nil {
+		return fmt.Errorf("error getting balancer status: %v", err)
+	}
+	if status.Mode != "off" {
+		log.Logvf(log.Always, "stopping the balancer")
+		var result bson.Raw
+		if err = dump.SessionProvider.RunString("balancerStop", &result, "admin"); err != nil {
+			return fmt.Errorf("error stopping the balancer: %v", err)
+		}
+		coordinator.restartBalancer = true
+	}
+
+	// the note is written to every shard's oplog, so the cluster time it
+	// returns is at or after the latest write on each shard
+	note := bson.D{
+		{"appendOplogNote", 1},
+		{"data", bson.M{"msg": "mongodump --coordinateShards"}},
+	}
+	var noteResult struct {
+		OperationTime primitive.Timestamp `bson:"operationTime"`
+	}
+	if err = dump.SessionProvider.Run(note, &noteResult, "admin"); err != nil {
+		return fmt.Errorf("error getting a cluster time: %v", err)
+	}
+	chosen := time.Now()
+	coordinator.snapshot.ClusterTime = noteResult.OperationTime
+	log.Logvf(log.Always, "reading all shards at cluster time %v", util.FormatTimestamp(noteResult.OperationTime))
+
+	if err = coordinator.readConfig(dump.SessionProvider); err != nil {
+		return err
+	}
+	shardIDs := map[string]bool{}
+	for _, shard := range coordinator.snapshot.Shards {
+		shardIDs[shard.ID] = true
+	}
+	for id := range dump.shardReadPrefs {
+		if !shardIDs[id] {
+			return fmt.Errorf("--shardReadPreference names shard %v, which is not in the cluster", id)
+		}
+	}
+	for _, shard := range coordinator.snapshot.Shards {
+		log.Logvf(log.Info, "connecting to shard %v at %v", shard.ID, shard.Host)
+		opts := dump.ToolOptions.ShardOptions(shard.Host)
+		if pref, ok := dump.shardReadPrefs[shard.ID]; ok {
+			opts.ReadPreference = pref
+		}
+		session, err := db.NewSessionProvider(*opts)
+		if err != nil {
+			return fmt.Errorf("error connecting to shard %v: %v", shard.ID, err)
+		}
+		coordinator.sessions[shard.ID] = session
+		if dump.maxReplicationLag > 0 {
+			if err = dump.checkReplicationLag(session, opts.ReadPreference, "shard "+shard.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	// fail before reading anything if the shards would discard the history
+	// at the cluster time before every collection is read, rather than
+	// keeping the balancer stopped for a dump that can't finish
+	var window time.Duration
+	for i, shard := range coordinator.snapshot.Shards {
+		if w := getSnapshotHistoryWindow(coordinator.sessions[shard.ID]); i == 0 || w < window {
+			window = w
+		}
+	}
+	return dump.startSnapshotWindow(time.Since(chosen), window)
+}
+
+// finishShardCoordination closes the shard connections and restarts the
+// balancer if it was stopped by startShardCoordination.
+func (dump *MongoDump) finishShardCoordination() {
+	for _, session := range dump.shards.sessions {
+		session.Close()
+	}
+	if dump.shards.restartBalancer {
+		log.Logvf(log.Always, "restarting the balancer")
+		var result bson.Raw
+		if err := dump.SessionProvider.RunString("balancerStart", &result, "admin"); err != nil {
+			log.Logvf(log.Always, "warning: failed to restart the balancer, run sh.startBalancer() to restart it: %v", err)
+		}
+	}
+}
+
+// readConfig reads the shards, database primaries and sharded collections
+// from the config servers.
+func (coordinator *shardCoordinator) readConfig(session *db.SessionProvider) error {
+	config := session.DB("config")
+	coordinator.config = config
+	ctx := context.Background()
+
+	cursor, err := config.Collection("shards").Find(ctx, bson.D{}, mopt.Find().SetSort(bson.D{{"_id", 1}}))
+	if err != nil {
+		return fmt.Errorf("error reading config.shards: %v", err)
+	}
+	if err = cursor.All(ctx, &coordinator.snapshot.Shards); err != nil {
+		return fmt.Errorf("error reading config.shards: %v", err)
+	}
+	if len(coordinator.snapshot.Shards) == 0 {
+		return fmt.Errorf("the cluster has no shards")
+	}
+
+	var databases []struct {
+		Name    string `bson:"_id"`
+		Primary string `bson:"primary"`
+	}
+	if cursor, err = config.Collection("databases").Find(ctx, bson.D{}); err == nil {
+		err = cursor.All(ctx, &databases)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading config.databases: %v", err)
+	}
+	for _, database := range databases {
+		coordinator.primaries[database.Name] = database.Primary
+	}
+
+	var collections []configCollection
+	if cursor, err = config.Collection("collections").Find(ctx, bson.D{}); err == nil {
+		err = cursor.All(ctx, &collections)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading config.collections: %v", err)
+	}
+	for _, coll := range collections {
+		if !coll.Dropped {
+			coordinator.collections[coll.ID] = coll
+		}
+	}
+	return nil
+}
+
+// recordCollections adds the shard keys of the sharded collections being
+// dumped to the snapshot.
+func (coordinator *shardCoordinator) recordCollections(manager *intents.Manager) {
+	for _, intent := range manager.Intents() {
+		if coll, ok := coordinator.collections[intent.Namespace()]; ok {
+			coordinator.snapshot.Collections = append(coordinator.snapshot.Collections,
+				archive.ShardedCollection{Namespace: coll.ID, Key: coll.Key, Unique: coll.Unique})
+		}
+	}
+}
+
+// queries returns a query for each shard that holds documents of the
+// intent's collection, reading that shard's documents at the snapshot's
+// cluster time. Documents of sharded collections are limited to the chunks
+// each shard owns, so orphaned documents left by migrations aren't read
+// twice. It returns the query unchanged for collections that aren't read
+// from the shards.
+func (coordinator *shardCoordinator) queries(query *db.DeferredQuery, intent *intents.Intent, isView bool) ([]*db.DeferredQuery, error) {
+	primary, found := coordinator.primaries[intent.DB]
+	if !found || isView || intent.IsSpecialCollection() || intent.IsOplog() {
+		return []*db.DeferredQuery{query}, nil
+	}
+
+	forShard := func(shard string) *db.DeferredQuery {
+		shardQuery := *query
+		shardQuery.Coll = coordinator.sessions[shard].DB(intent.DB).Collection(intent.C)
+		shardQuery.AtClusterTime = &coordinator.snapshot.ClusterTime
+		return &shardQuery
+	}
+
+	coll, sharded := coordinator.collections[intent.Namespace()]
+	if !sharded {
+		return []*db.DeferredQuery{forShard(primary)}, nil
+	}
+
+	index, err := shardKeyIndex(query.Coll, coll.Key)
+	if err != nil {
+		return nil, fmt.Errorf("error finding the shard key index of %v: %v", intent.Namespace(), err)
+	}
+	ranges, err := coordinator.chunkRanges(coll)
+	if err != nil {
+		return nil, fmt.Errorf("error reading chunks of %v: %v", intent.Namespace(), err)
+	}
+	queries := make([]*db.DeferredQuery, 0, len(ranges))
+	for _, r := range ranges {
+		if _, ok := coordinator.sessions[r.Shard]; !ok {
+			return nil, fmt.Errorf("chunk of %v is on unknown shard %v", intent.Namespace(), r.Shard)
+		}
+		shardQuery := forShard(r.Shard)
+		shardQuery.Hint = index
+		if shardQuery.Min, err = indexBound(r.Min, index); err != nil {
+			return nil, err
+		}
+		if shardQuery.Max, err = indexBound(r.Max, index); err != nil {
+			return nil, err
+		}
+		queries = append(queries, shardQuery)
+	}
+	return queries, nil
+}
+
+// shardKeyIndex returns the key pattern of the index that supports a shard
+// key, which is either the shard key itself or starts with it.
+func shardKeyIndex(coll *mongo.Collection, key bson.D) (bson.D, error) {
+	ctx := context.Background()
+	cursor, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []struct {
+		Key bson.D `bson:"key"`
+	}
+	if err = cursor.All(ctx, &indexes); err != nil {
+		return nil, err
+	}
+	var found bson.D
+	for _, index := range indexes {
+		if len(index.Key) < len(key) || !bsonutil.IsIndexKeysEqual(index.Key[:len(key)], key) {
+			continue
+		}
+		if len(index.Key) == len(key) {
+			return index.Key, nil
+		}
+		if found == nil {
+			found = index.Key
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no index on %v", key)
+	}
+	return found, nil
+}
+
+// indexBound extends a chunk bound on the shard key to the fields of the
+// index used to read it. Extra fields are MinKey, so that the bound falls
+// before every document with the same shard key.
+func indexBound(bound bson.Raw, index bson.D) (bson.D, error) {
+	var doc bson.D
+	if err := bson.Unmarshal(bound, &doc); err != nil {
+		return nil, fmt.Errorf("error reading chunk bound: %v", err)
+	}
+	for _, field := range index[len(doc):] {
+		doc = append(doc, bson.E{Key: field.Key, Value: primitive.MinKey{}})
+	}
+	return doc, nil
+}
+
+// chunkRanges reads the chunks of a sharded collection, in shard key order,
+// and merges adjacent chunks on the same shard into ranges.
+func (coordinator *shardCoordinator) chunkRanges(coll configCollection) ([]shardRange, error) {
+	filter := bson.D{{"ns", coll.ID}}
+	if coll.UUID != nil {
+		filter = bson.D{{"uuid", *coll.UUID}}
+	}
+	ctx := context.Background()
+	cursor, err := coordinator.config.Collection("chunks").Find(ctx, filter, mopt.Find().SetSort(bson.D{{"min", 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var chunks []configChunk
+	if err = cursor.All(ctx, &chunks); err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("no chunks found")
+	}
+	return mergeChunks(chunks), nil
+}
+
+// mergeChunks merges adjacent chunks, sorted by shard key, that are on the
+// same shard.
+func mergeChunks(chunks []configChunk) []shardRange {
+	var ranges []shardRange
+	for _, chunk := range chunks {
+		if n := len(ranges); n > 0 && ranges[n-1].Shard == chunk.Shard && bytes.Equal(ranges[n-1].Max, chunk.Min) {
+			ranges[n-1].Max = chunk.Max
+			continue
+		}
+		ranges = append(ranges, shardRange{Shard: chunk.Shard, Min: chunk.Min, Max: chunk.Max})
+	}
+	return ranges
+}
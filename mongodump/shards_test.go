@@ -0,0 +1,79 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func chunkBound(value interface{}) bson.Raw {
+	raw, err := bson.Marshal(bson.D{{"x", value}})
+	So(err, ShouldBeNil)
+	return raw
+}
+
+func TestShardCoordination(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Adjacent chunks on the same shard are merged", t, func() {
+		min, ten, twenty, max := chunkBound(primitive.MinKey{}), chunkBound(10), chunkBound(20), chunkBound(primitive.MaxKey{})
+		ranges := mergeChunks([]configChunk{
+			{Min: min, Max: ten, Shard: "a"},
+			{Min: ten, Max: twenty, Shard: "a"},
+			{Min: twenty, Max: max, Shard: "b"},
+		})
+		So(ranges, ShouldResemble, []shardRange{
+			{Shard: "a", Min: min, Max: twenty},
+			{Shard: "b", Min: twenty, Max: max},
+		})
+	})
+
+	Convey("Chunk bounds are extended to the fields of the shard key index", t, func() {
+		bound, err := indexBound(chunkBound(10), bson.D{{"x", 1}, {"y", 1}})
+		So(err, ShouldBeNil)
+		So(bound, ShouldResemble, bson.D{{"x", int32(10)}, {"y", primitive.MinKey{}}})
+
+		bound, err = indexBound(chunkBound(10), bson.D{{"x", 1}})
+		So(err, ShouldBeNil)
+		So(bound, ShouldResemble, bson.D{{"x", int32(10)}})
+	})
+
+	Convey("Key patterns match regardless of numeric type", t, func() {
+		So(bsonutil.IsIndexKeysEqual(bson.D{{"x", int32(1)}}, bson.D{{"x", 1.0}}), ShouldBeTrue)
+		So(bsonutil.IsIndexKeysEqual(bson.D{{"x", "hashed"}}, bson.D{{"x", "hashed"}}), ShouldBeTrue)
+		So(bsonutil.IsIndexKeysEqual(bson.D{{"x", 1}}, bson.D{{"x", "hashed"}}), ShouldBeFalse)
+		So(bsonutil.IsIndexKeysEqual(bson.D{{"x", 1}}, bson.D{{"y", 1}}), ShouldBeFalse)
+	})
+
+	Convey("Shard connections use the shard's replica set", t, func() {
+		md := simpleMongoDumpInstance()
+		So(md.ToolOptions.NormalizeOptionsAndURI(), ShouldBeNil)
+		opts := md.ToolOptions.ShardOptions("shard01/host1:27018,host2:27018")
+		So(opts.URI.ConnString.Hosts, ShouldResemble, []string{"host1:27018", "host2:27018"})
+		So(opts.ReplicaSetName, ShouldEqual, "shard01")
+		So(opts.Direct, ShouldBeFalse)
+		So(md.ToolOptions.URI.ConnString.Hosts, ShouldNotResemble, opts.URI.ConnString.Hosts)
+	})
+
+	Convey("--coordinateShards requires an archive and can't be used with --oplog", t, func() {
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.CoordinateShards = true
+		So(md.ValidateOptions(), ShouldNotBeNil)
+
+		md.OutputOptions.Archive = "dump.archive"
+		So(md.ValidateOptions(), ShouldBeNil)
+
+		md.OutputOptions.Oplog = true
+		So(md.ValidateOptions(), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,128 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// shutdownState tracks which collections have been dumped, for finishing a
+// dump interrupted by a signal.
+type shutdownState struct {
+	interrupted int32
+
+	mutex sync.Mutex
+	// dumped are the namespaces of the collections being dumped, and
+	// completed those whose documents have all been dumped
+	dumped    []string
+	completed map[string]bool
+	// partial is set once an interrupted dump has been finished, with the
+	// collections that weren't completely dumped in incomplete
+	partial    bool
+	incomplete []string
+}
+
+// interrupt records that a signal asked the dump to stop.
+func (s *shutdownState) interrupt() {
+	atomic.StoreInt32(&s.interrupted, 1)
+}
+
+func (s *shutdownState) isInterrupted() bool {
+	return atomic.LoadInt32(&s.interrupted) == 1
+}
+
+// track records the collections of the intents to be dumped, which the
+// intent manager no longer lists once it is finalized.
+func (s *shutdownState) track(all []*intents.Intent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, intent := range all {
+		if intent.BSONFile != nil && !intent.IsSpecialCollection() {
+			s.dumped = append(s.dumped, intent.Namespace())
+		}
+	}
+}
+
+// complete records that every document of an intent has been dumped.
+func (s *shutdownState) complete(intent *intents.Intent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.completed == nil {
+		s.completed = map[string]bool{}
+	}
+	s.completed[intent.Namespace()] = true
+}
+
+func (s *shutdownState) isComplete(namespace string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.completed[namespace]
+}
+
+// inBatch returns whether a cursor holds documents it has already fetched.
+func inBatch(cursor documentCursor) bool {
+	batched, ok := cursor.(interface{ RemainingBatchLength() int })
+	return ok && batched.RemainingBatchLength() > 0
+}
+
+// finishInterrupted finishes a dump interrupted by a signal, once every
+// collection being dumped has been closed after its batch in flight. An
+// archive gets an empty stream for each collection that wasn't started, and
+// for the oplog when oplogPending is set, so that it ends like a complete
+// one. The collections that weren't completely dumped are listed in the
+// manifest, and the error returned exits with the code for an interruption.
+func (dump *MongoDump) finishInterrupted(oplogPending bool) error {
+	var unstarted []*intents.Intent
+	for intent := dump.manager.Pop(); intent != nil; intent = dump.manager.Pop() {
+		unstarted = append(unstarted, intent)
+	}
+	for intent := dump.sampleRefs.nextDeferred(); intent != nil; intent = dump.sampleRefs.nextDeferred() {
+		unstarted = append(unstarted, intent)
+	}
+	if oplog := dump.manager.Oplog(); oplogPending && oplog != nil {
+		unstarted = append(unstarted, oplog)
+	}
+	if dump.archive != nil {
+		for _, intent := range unstarted {
+			if intent.BSONFile == nil {
+				continue
+			}
+			if err := intent.BSONFile.Open(); err != nil {
+				return err
+			}
+			if err := intent.BSONFile.Close(); err != nil {
+				return err
+			}
+		}
+	}
+
+	s := &dump.shutdown
+	s.mutex.Lock()
+	var incomplete []string
+	for _, namespace := range s.dumped {
+		if !s.completed[namespace] {
+			incomplete = append(incomplete, namespace)
+		}
+	}
+	sort.Strings(incomplete)
+	total := len(s.dumped)
+	s.partial, s.incomplete = true, incomplete
+	s.mutex.Unlock()
+
+	log.Logvf(log.Always, "dump interrupted: %v of %v %v completely dumped",
+		total-len(incomplete), total, util.Pluralize(total, "collection", "collections"))
+	if dump.checkpoint != nil {
+		log.Logvf(log.Always, "run mongodump again with --resume to continue the dump")
+	}
+	return util.WithErrorCode(util.ErrCodeInterrupted, util.ErrTerminated)
+}
@@ -0,0 +1,122 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/common/util"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// batchCursor returns its documents in batches, and calls onRead after
+// reading each.
+type batchCursor struct {
+	docs   []bson.Raw
+	batch  int
+	read   int
+	onRead func(read int)
+}
+
+func (c *batchCursor) Next(context.Context) bool {
+	if c.read == len(c.docs) {
+		return false
+	}
+	c.read++
+	if c.onRead != nil {
+		c.onRead(c.read)
+	}
+	return true
+}
+
+func (c *batchCursor) Document() bson.Raw          { return c.docs[c.read-1] }
+func (c *batchCursor) Err() error                  { return nil }
+func (c *batchCursor) Close(context.Context) error { return nil }
+
+func (c *batchCursor) RemainingBatchLength() int {
+	remaining := (c.batch - c.read%c.batch) % c.batch
+	if left := len(c.docs) - c.read; remaining > left {
+		remaining = left
+	}
+	return remaining
+}
+
+func TestInterruptedDump(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("An interrupted collection ends after the batch in flight", t, func() {
+		md := simpleMongoDumpInstance()
+		md.shutdownIntentsNotifier = newNotifier()
+		var docs []bson.Raw
+		for i := 0; i < 10; i++ {
+			doc, err := bson.Marshal(bson.D{{"_id", int32(i)}})
+			So(err, ShouldBeNil)
+			docs = append(docs, doc)
+		}
+		cursor := &batchCursor{docs: docs, batch: 4, onRead: func(read int) {
+			if read == 2 {
+				md.shutdownIntentsNotifier.Notify()
+			}
+		}}
+
+		var out bytes.Buffer
+		counter := progress.NewCounter(0)
+		err := md.dumpValidatedItersToWriter([]documentCursor{cursor}, &out, counter, nil)
+		So(err, ShouldEqual, util.ErrTerminated)
+		written, _ := counter.Progress()
+		So(written, ShouldEqual, 4)
+		So(out.Len(), ShouldEqual, 4*len(docs[0]))
+	})
+
+	Convey("An interrupt before the dump starts its notifier still stops the dump", t, func() {
+		md := simpleMongoDumpInstance()
+		md.HandleInterrupt()
+		md.startShutdownNotifier()
+		So(md.shutdownIntentsNotifier.isNotified(), ShouldBeTrue)
+	})
+
+	Convey("An interrupt racing the start of the notifier always notifies it", t, func() {
+		for i := 0; i < 100; i++ {
+			md := simpleMongoDumpInstance()
+			var interrupted sync.WaitGroup
+			interrupted.Add(1)
+			go func() {
+				defer interrupted.Done()
+				md.HandleInterrupt()
+			}()
+			md.startShutdownNotifier()
+			interrupted.Wait()
+			So(md.shutdownIntentsNotifier.isNotified(), ShouldBeTrue)
+		}
+	})
+
+	Convey("Finishing an interrupted dump lists the incomplete collections", t, func() {
+		md := simpleMongoDumpInstance()
+		md.manager = intents.NewIntentManager()
+		for _, c := range []string{"a", "b", "c"} {
+			md.manager.Put(&intents.Intent{DB: "test", C: c, BSONFile: &realBSONFile{}})
+		}
+		md.shutdown.track(md.manager.Intents())
+		md.manager.Finalize(intents.Legacy)
+		md.shutdown.interrupt()
+		So(md.shutdown.isInterrupted(), ShouldBeTrue)
+		md.shutdown.complete(md.manager.Pop())
+
+		err := md.finishInterrupted(false)
+		So(util.ErrorCodeOf(err), ShouldResemble, util.ErrCodeInterrupted)
+		So(md.shutdown.partial, ShouldBeTrue)
+		So(md.shutdown.incomplete, ShouldResemble, []string{"test.b", "test.c"})
+		So(md.manager.Pop(), ShouldBeNil)
+	})
+}
@@ -0,0 +1,148 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// defaultSnapshotHistoryWindow is the default of the server's
+// minSnapshotHistoryWindowInSeconds, used when it cannot be read.
+const defaultSnapshotHistoryWindow = 300 * time.Second
+
+// codeSnapshotTooOld is returned for a read at a cluster time whose history
+// the server has discarded.
+const codeSnapshotTooOld = 239
+
+// initSnapshot checks that the server supports snapshot reads and, unless
+// --atClusterTime was given, sets the cluster time every collection is read
+// at to the latest majority-committed time. The server only keeps the
+// history for minSnapshotHistoryWindowInSeconds, so every collection has to
+// be read within that window of the cluster time; initSnapshot fails if the
+// dump is estimated to take longer than what is left of it.
+func (dump *MongoDump) initSnapshot() error {
+	version, err := dump.SessionProvider.ServerVersionArray()
+	if err != nil {
+		return err
+	}
+	if version.LT(db.Version{5, 0, 0}) {
+		return fmt.Errorf("--snapshot requires MongoDB 5.0 or later, found %v.%v.%v", version[0], version[1], version[2])
+	}
+
+	now, err := dump.currentClusterTime()
+	if err != nil {
+		return fmt.Errorf("error getting a cluster time for --snapshot: %v", err)
+	}
+	if dump.snapshotTime == nil {
+		dump.snapshotTime = &now
+	}
+	window := getSnapshotHistoryWindow(dump.SessionProvider)
+	if err = dump.startSnapshotWindow(oplogSpan(*dump.snapshotTime, now), window); err != nil {
+		return err
+	}
+	log.Logvf(log.Always, "reading all collections at cluster time %v", util.FormatTimestamp(*dump.snapshotTime))
+	return nil
+}
+
+// startSnapshotWindow checks that the dump can read every collection at a
+// cluster time that is age old, within the server's snapshot history window,
+// and sets the deadline checkSnapshotWindow enforces.
+func (dump *MongoDump) startSnapshotWindow(age, window time.Duration) error {
+	estimate, err := dump.estimateDumpDuration()
+	if err != nil {
+		return fmt.Errorf("error estimating the duration of the dump: %v", err)
+	}
+	if err = evaluateSnapshotWindow(age, estimate, window); err != nil {
+		return err
+	}
+	dump.snapshotDeadline = time.Now().Add(window - age)
+	return nil
+}
+
+// getSnapshotHistoryWindow returns the server's
+// minSnapshotHistoryWindowInSeconds, or its default if it can't be read.
+func getSnapshotHistoryWindow(session *db.SessionProvider) time.Duration {
+	cmd := bson.D{{"getParameter", 1}, {"minSnapshotHistoryWindowInSeconds", 1}}
+	result := bson.M{}
+	if err := session.Run(cmd, &result, "admin"); err != nil {
+		log.Logvf(log.Info, "cannot read minSnapshotHistoryWindowInSeconds, assuming %v: %v",
+			defaultSnapshotHistoryWindow, err)
+		return defaultSnapshotHistoryWindow
+	}
+	seconds, err := util.ToInt(result["minSnapshotHistoryWindowInSeconds"])
+	if err != nil {
+		return defaultSnapshotHistoryWindow
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// evaluateSnapshotWindow returns an error if a cluster time that is age old
+// is outside the server's snapshot history window, or if a dump estimated to
+// take estimate would outlast what is left of the window. A zero estimate is
+// not checked.
+func evaluateSnapshotWindow(age, estimate, window time.Duration) error {
+	if age >= window {
+		return fmt.Errorf("the cluster time to read at is %v old, outside the server's snapshot history window "+
+			"of %v (minSnapshotHistoryWindowInSeconds)", age, window)
+	}
+	if estimate > 0 && estimate >= window-age {
+		return fmt.Errorf("the dump is estimated to take %v, longer than the %v left of the server's snapshot "+
+			"history window of %v; increase minSnapshotHistoryWindowInSeconds on the server, or dump without "+
+			"reading at a single cluster time", estimate, window-age, window)
+	}
+	return nil
+}
+
+// checkSnapshotWindow returns an error if the snapshot history window has
+// passed, so that no collection is read once the server may fail the read.
+func (dump *MongoDump) checkSnapshotWindow() error {
+	if dump.snapshotDeadline.IsZero() || time.Now().Before(dump.snapshotDeadline) {
+		return nil
+	}
+	return fmt.Errorf("the dump has outlasted the server's snapshot history window " +
+		"(minSnapshotHistoryWindowInSeconds) for the cluster time it reads at")
+}
+
+// snapshotWindowError explains a read that failed because the history at the
+// cluster time was discarded.
+func snapshotWindowError(err error) error {
+	if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Code == codeSnapshotTooOld {
+		return fmt.Errorf("%v: the dump took longer than the server's snapshot history window "+
+			"(minSnapshotHistoryWindowInSeconds)", err)
+	}
+	return err
+}
+
+// currentClusterTime returns the cluster time a snapshot read chooses when
+// it isn't given one, which is the latest majority-committed time.
+func (dump *MongoDump) currentClusterTime() (primitive.Timestamp, error) {
+	cmd := bson.D{
+		{"find", "system.version"},
+		{"limit", 1},
+		{"readConcern", bson.D{{"level", "snapshot"}}},
+	}
+	var result struct {
+		Cursor struct {
+			AtClusterTime *primitive.Timestamp `bson:"atClusterTime"`
+		} `bson:"cursor"`
+	}
+	if err := dump.SessionProvider.Run(cmd, &result, "admin"); err != nil {
+		return primitive.Timestamp{}, err
+	}
+	if result.Cursor.AtClusterTime == nil {
+		return primitive.Timestamp{}, fmt.Errorf("server did not return the snapshot's cluster time")
+	}
+	return *result.Cursor.AtClusterTime, nil
+}
@@ -0,0 +1,53 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestSnapshotWindow(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("A cluster time outside the history window is an error", t, func() {
+		err := evaluateSnapshotWindow(6*time.Minute, 0, 5*time.Minute)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "minSnapshotHistoryWindowInSeconds")
+		So(evaluateSnapshotWindow(time.Minute, 0, 5*time.Minute), ShouldBeNil)
+	})
+
+	Convey("A dump estimated to outlast what is left of the window is an error", t, func() {
+		err := evaluateSnapshotWindow(2*time.Minute, 4*time.Minute, 5*time.Minute)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "estimated to take 4m0s, longer than the 3m0s left")
+		So(evaluateSnapshotWindow(0, 4*time.Minute, 5*time.Minute), ShouldBeNil)
+	})
+
+	Convey("Collections are not read once the window has passed", t, func() {
+		md := simpleMongoDumpInstance()
+		So(md.checkSnapshotWindow(), ShouldBeNil)
+		md.snapshotTime = &primitive.Timestamp{T: 100}
+		md.snapshotDeadline = time.Now().Add(time.Minute)
+		So(md.checkSnapshotWindow(), ShouldBeNil)
+		md.snapshotDeadline = time.Now().Add(-time.Second)
+		So(md.checkSnapshotWindow(), ShouldNotBeNil)
+	})
+
+	Convey("A read of discarded history is explained", t, func() {
+		err := snapshotWindowError(mongo.CommandError{Code: codeSnapshotTooOld, Message: "Read timestamp is older"})
+		So(err.Error(), ShouldContainSubstring, "minSnapshotHistoryWindowInSeconds")
+		other := errors.New("other")
+		So(snapshotWindowError(other), ShouldEqual, other)
+	})
+}
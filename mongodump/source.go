@@ -0,0 +1,186 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The kinds of deployment given to --sourceType.
+const (
+	SourceAuto           = "auto"
+	SourceStandard       = "standard"
+	SourceServerless     = "serverless"
+	SourceDataFederation = "dataFederation"
+)
+
+// sourceLimits are the features mongodump depends on that the deployment
+// being dumped lacks. Serverless instances and Atlas Data Federation lack
+// several, which are reported before dumping rather than when the first
+// command using one fails. The zero value is a standard deployment.
+type sourceLimits struct {
+	kind string
+	// noOplog is set when the oplog can't be read, for --oplog,
+	// --incremental and the checks of --reuseUnchangedFrom
+	noOplog bool
+	// noSnapshot is set when snapshot read concern isn't supported
+	noSnapshot bool
+	// noReplication is set when replica set and shard commands can't be run
+	noReplication bool
+	// noIndexes is set when collections have no indexes to hint and bound,
+	// so they can't be split into ranges of _id or resumed in _id order
+	noIndexes bool
+	// noUsersAndRoles is set when users and roles can't be read
+	noUsersAndRoles bool
+	// batchSize is the number of documents to ask for in each batch, or 0
+	// for the server's default
+	batchSize int32
+}
+
+// sourceBatchSize keeps each getMore of a serverless instance or a
+// federated query short, since both limit the time and resources of each
+// operation rather than of the cursor as a whole.
+const sourceBatchSize = 1000
+
+// limitsOf returns the limits of a kind of deployment.
+func limitsOf(kind string) sourceLimits {
+	limits := sourceLimits{kind: kind}
+	switch kind {
+	case SourceServerless:
+		limits.noOplog, limits.noSnapshot, limits.noReplication, limits.noUsersAndRoles = true, true, true, true
+		limits.batchSize = sourceBatchSize
+	case SourceDataFederation:
+		limits.noOplog, limits.noSnapshot, limits.noReplication, limits.noUsersAndRoles = true, true, true, true
+		limits.noIndexes = true
+		limits.batchSize = sourceBatchSize
+	}
+	return limits
+}
+
+// isServerlessHost returns whether a host is that of an Atlas serverless
+// instance.
+func isServerlessHost(host string) bool {
+	host = strings.ToLower(host)
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	return strings.HasPrefix(host, "serverless") && strings.HasSuffix(host, ".mongodb.net")
+}
+
+// detectSourceType returns the kind of deployment connected to: Atlas Data
+// Federation reports its own version in buildInfo, and serverless
+// instances are served behind a load balancer, from their own hosts.
+func (dump *MongoDump) detectSourceType() (string, error) {
+	var buildInfo bson.M
+	if err := dump.SessionProvider.RunString("buildInfo", &buildInfo, "admin"); err != nil {
+		return "", fmt.Errorf("error getting buildInfo: %v", err)
+	}
+	if _, ok := buildInfo["dataLake"]; ok {
+		return SourceDataFederation, nil
+	}
+	var hello bson.M
+	if err := dump.SessionProvider.RunString("isMaster", &hello, "admin"); err == nil {
+		if _, ok := hello["serviceId"]; ok {
+			return SourceServerless, nil
+		}
+	}
+	for _, host := range dump.connectionHosts() {
+		if isServerlessHost(host) {
+			return SourceServerless, nil
+		}
+	}
+	return SourceStandard, nil
+}
+
+// connectionHosts returns the hosts given by the connection string or
+// --host.
+func (dump *MongoDump) connectionHosts() []string {
+	if dump.ToolOptions.URI != nil {
+		if cs := dump.ToolOptions.URI.ParsedConnString(); cs != nil {
+			return cs.Hosts
+		}
+	}
+	if dump.ToolOptions.Connection == nil || dump.ToolOptions.Connection.Host == "" {
+		return nil
+	}
+	hosts := dump.ToolOptions.Connection.Host
+	// a replica set is given as <setName>/<host>,...
+	if i := strings.Index(hosts, "/"); i >= 0 {
+		hosts = hosts[i+1:]
+	}
+	return strings.Split(hosts, ",")
+}
+
+// unsupportedOptions returns the options given that the source doesn't
+// support.
+func (dump *MongoDump) unsupportedOptions(limits sourceLimits) []string {
+	var unsupported []string
+	check := func(lacking, given bool, option string) {
+		if lacking && given {
+			unsupported = append(unsupported, option)
+		}
+	}
+	check(limits.noOplog, dump.OutputOptions.Oplog, "--oplog")
+	check(limits.noOplog, dump.OutputOptions.Incremental, "--incremental")
+	check(limits.noOplog, dump.OutputOptions.RequireOplogWindow != "", "--requireOplogWindow")
+	check(limits.noOplog, dump.OutputOptions.ReuseUnchangedFrom != "", "--reuseUnchangedFrom")
+	check(limits.noSnapshot, dump.snapshotEnabled(), "--snapshot")
+	check(limits.noReplication, dump.OutputOptions.CoordinateShards, "--coordinateShards")
+	check(limits.noReplication, dump.InputOptions.MaxReplicationLag != "", "--maxReplicationLag")
+	check(limits.noIndexes, dump.OutputOptions.NumParallelChunks > 1, "--numParallelChunksPerCollection")
+	check(limits.noIndexes, dump.OutputOptions.IndexesOnly, "--indexesOnly")
+	check(limits.noUsersAndRoles, dump.OutputOptions.DumpDBUsersAndRoles, "--dumpDbUsersAndRoles")
+	return unsupported
+}
+
+// initSource determines the kind of deployment being dumped, from
+// --sourceType or by detecting it, and fails if it doesn't support the
+// options given.
+func (dump *MongoDump) initSource() error {
+	kind := dump.InputOptions.SourceType
+	if kind == "" || kind == SourceAuto {
+		var err error
+		if kind, err = dump.detectSourceType(); err != nil {
+			return fmt.Errorf("error detecting the kind of deployment: %v", err)
+		}
+	}
+	dump.source = limitsOf(kind)
+	if kind == SourceStandard {
+		return nil
+	}
+
+	log.Logvf(log.Always, "dumping from %v, which doesn't support oplog or snapshot reads", sourceName(kind))
+	if unsupported := dump.unsupportedOptions(dump.source); len(unsupported) > 0 {
+		return util.WithErrorCode(util.ErrCodeBadOptions, fmt.Errorf("%v cannot be used when dumping from %v",
+			strings.Join(unsupported, ", "), sourceName(kind)))
+	}
+	if dump.source.noUsersAndRoles && !dump.SkipUsersAndRoles {
+		log.Logvf(log.Info, "not dumping users and roles, which %v doesn't expose", sourceName(kind))
+		dump.SkipUsersAndRoles = true
+	}
+	if dump.source.noIndexes {
+		// there is no storage engine to read in _id order for
+		dump.storageEngine = storageEngineModern
+	}
+	return nil
+}
+
+// sourceName returns the name of a kind of deployment for messages.
+func sourceName(kind string) string {
+	switch kind {
+	case SourceServerless:
+		return "a serverless instance"
+	case SourceDataFederation:
+		return "Atlas Data Federation"
+	}
+	return "a standard deployment"
+}
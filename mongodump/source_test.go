@@ -0,0 +1,71 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIsServerlessHost(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Serverless hosts are recognized by name", t, func() {
+		So(isServerlessHost("serverlessinstance0.abcde.mongodb.net:27017"), ShouldBeTrue)
+		So(isServerlessHost("ServerlessInstance0-pe-0.abcde.mongodb.net"), ShouldBeTrue)
+		So(isServerlessHost("cluster0-shard-00-00.abcde.mongodb.net:27017"), ShouldBeFalse)
+		So(isServerlessHost("serverless.example.com"), ShouldBeFalse)
+		So(isServerlessHost("localhost:27017"), ShouldBeFalse)
+	})
+}
+
+func TestSourceLimits(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Standard deployments have no limits", t, func() {
+		So(limitsOf(SourceStandard), ShouldResemble, sourceLimits{kind: SourceStandard})
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.Oplog = true
+		md.OutputOptions.NumParallelChunks = 4
+		So(md.unsupportedOptions(limitsOf(SourceStandard)), ShouldBeEmpty)
+	})
+
+	Convey("Serverless instances can't read the oplog or snapshots", t, func() {
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.Oplog = true
+		md.InputOptions.Snapshot = true
+		md.OutputOptions.NumParallelChunks = 4
+		md.OutputOptions.DumpDBUsersAndRoles = true
+		So(md.unsupportedOptions(limitsOf(SourceServerless)), ShouldResemble,
+			[]string{"--oplog", "--snapshot", "--dumpDbUsersAndRoles"})
+		So(limitsOf(SourceServerless).batchSize, ShouldEqual, sourceBatchSize)
+	})
+
+	Convey("Atlas Data Federation also has no indexes", t, func() {
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.NumParallelChunks = 4
+		md.OutputOptions.ReuseUnchangedFrom = "previous"
+		So(md.unsupportedOptions(limitsOf(SourceDataFederation)), ShouldResemble,
+			[]string{"--reuseUnchangedFrom", "--numParallelChunksPerCollection"})
+	})
+}
+
+func TestConnectionHosts(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Hosts come from --host without a connection string", t, func() {
+		md := simpleMongoDumpInstance()
+		md.ToolOptions.URI = &options.URI{}
+		md.ToolOptions.Connection = &options.Connection{Host: "rs0/a.example.com:27017,b.example.com"}
+		So(md.connectionHosts(), ShouldResemble, []string{"a.example.com:27017", "b.example.com"})
+		md.ToolOptions.Connection = &options.Connection{}
+		So(md.connectionHosts(), ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,205 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// Output formats for --outFormat.
+const (
+	DirectoryFormat = "directory"
+	TarFormat       = "tar"
+	TarGzipFormat   = "tar.gz"
+)
+
+// tarSegmentSize is how much of a file is held in memory before it is added
+// to a tar stream. Files that are larger are added as numbered segments, as
+// with --splitSize, so that collections can be written in parallel without
+// staging them on disk.
+const tarSegmentSize = 16 * 1024 * 1024
+
+// tarOutput is a storage.Backend that adds each file to a tar stream, with
+// the same layout as a dump directory.
+type tarOutput struct {
+	mutex       sync.Mutex
+	out         io.WriteCloser
+	compressor  io.WriteCloser
+	writer      *tar.Writer
+	location    string
+	segmentSize int
+	modTime     time.Time
+	// dirs are the directories already added to the stream
+	dirs map[string]bool
+}
+
+// newTarOutput returns a tarOutput writing to out, which is closed with it,
+// and compresses the stream with codec.
+func newTarOutput(out io.WriteCloser, location string, codec compression.Codec) (*tarOutput, error) {
+	t := &tarOutput{
+		out:         out,
+		location:    location,
+		segmentSize: tarSegmentSize,
+		modTime:     time.Now(),
+		dirs:        map[string]bool{},
+	}
+	var stream io.Writer = out
+	if !codec.IsNone() {
+		compressor, err := codec.NewWriter(out)
+		if err != nil {
+			return nil, err
+		}
+		t.compressor, stream = compressor, compressor
+	}
+	t.writer = tar.NewWriter(stream)
+	return t, nil
+}
+
+// tarOutputEnabled returns whether the dump is written as a tar stream.
+func (dump *MongoDump) tarOutputEnabled() bool {
+	return dump.OutputOptions.OutFormat == TarFormat || dump.OutputOptions.OutFormat == TarGzipFormat
+}
+
+// collectionToStdout returns whether a single collection is dumped to
+// standard output.
+func (dump *MongoDump) collectionToStdout() bool {
+	return dump.OutputOptions.Out == "-" && !dump.tarOutputEnabled()
+}
+
+// openTarOutput creates the tar stream given by --out and writes the dump's
+// files to it.
+func (dump *MongoDump) openTarOutput() (err error) {
+	var out io.WriteCloser
+	location := dump.OutputOptions.Out
+	switch {
+	case location == "-":
+		out, location = &nopCloseWriter{dump.OutputWriter}, "stdout"
+	case storage.IsRemote(location):
+		out, err = storage.Create(location)
+	default:
+		out, err = os.Create(location)
+	}
+	if err != nil {
+		return fmt.Errorf("error creating tar output %v: %v", location, err)
+	}
+	codec := compression.None
+	if dump.OutputOptions.OutFormat == TarGzipFormat {
+		codec = compression.Codec{Name: compression.GzipName, Workers: dump.OutputOptions.CompressionWorkers}
+	}
+	dump.tarOut, err = newTarOutput(out, location, codec)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	dump.outputStorage = dump.tarOut
+	return nil
+}
+
+// Create returns a writer for the file at name, which is added to the stream
+// once it is closed or, for a large file, one segment at a time.
+func (t *tarOutput) Create(name string) (io.WriteCloser, error) {
+	return &tarEntry{output: t, name: name}, nil
+}
+
+// Location returns where the file at name is in the stream, for messages.
+func (t *tarOutput) Location(name string) string {
+	return fmt.Sprintf("%v in %v", name, t.location)
+}
+
+// add adds a file to the stream after any of its directories that aren't
+// in it yet.
+func (t *tarOutput) add(name string, data []byte) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var dirs []string
+	for dir := path.Dir(name); dir != "." && dir != "/" && !t.dirs[dir]; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		header := &tar.Header{Typeflag: tar.TypeDir, Name: dirs[i] + "/", Mode: 0755, ModTime: t.modTime}
+		if err := t.writer.WriteHeader(header); err != nil {
+			return err
+		}
+		t.dirs[dirs[i]] = true
+	}
+
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(data)), ModTime: t.modTime}
+	if err := t.writer.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := t.writer.Write(data)
+	return err
+}
+
+// Close ends the stream and closes the output.
+func (t *tarOutput) Close() error {
+	err := t.writer.Close()
+	if t.compressor != nil {
+		if closeErr := t.compressor.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := t.out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing tar output %v: %v", t.location, err)
+	}
+	return nil
+}
+
+// tarEntry buffers a file of a tar stream. A file that fills its buffer is
+// added as segments, each one as the next byte arrives.
+type tarEntry struct {
+	output   *tarOutput
+	name     string
+	buffer   []byte
+	segments int
+}
+
+func (e *tarEntry) Write(p []byte) (int, error) {
+	var total int
+	for len(p) > 0 {
+		if len(e.buffer) == e.output.segmentSize {
+			if err := e.output.add(util.SegmentPath(e.name, e.segments), e.buffer); err != nil {
+				return total, err
+			}
+			e.segments++
+			e.buffer = e.buffer[:0]
+		}
+		chunk := p
+		if remaining := e.output.segmentSize - len(e.buffer); len(chunk) > remaining {
+			chunk = chunk[:remaining]
+		}
+		e.buffer = append(e.buffer, chunk...)
+		total += len(chunk)
+		p = p[len(chunk):]
+	}
+	return total, nil
+}
+
+// Close adds the rest of the file to the stream.
+func (e *tarEntry) Close() error {
+	name := e.name
+	if e.segments > 0 {
+		name = util.SegmentPath(e.name, e.segments)
+	}
+	err := e.output.add(name, e.buffer)
+	e.buffer = nil
+	return err
+}
@@ -0,0 +1,128 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// readTar returns the names and contents of the entries of a tar stream.
+func readTar(in io.Reader) ([]string, map[string][]byte) {
+	var names []string
+	contents := map[string][]byte{}
+	reader := tar.NewReader(in)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return names, contents
+		}
+		So(err, ShouldBeNil)
+		names = append(names, header.Name)
+		contents[header.Name], err = ioutil.ReadAll(reader)
+		So(err, ShouldBeNil)
+	}
+}
+
+func TestTarOutput(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a dump written as a tar stream", t, func() {
+		var out bytes.Buffer
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.Out = "-"
+		md.OutputOptions.OutFormat = TarFormat
+		md.OutputWriter = &out
+		md.manifest = md.newManifest()
+		So(md.openTarOutput(), ShouldBeNil)
+		md.tarOut.segmentSize = 10
+
+		bsonFile := &realBSONFile{path: md.outputPath("db", "c") + ".bson", intent: &intents.Intent{DB: "db", C: "c"},
+			storage: md.outputStorage, manifest: md.manifest}
+		So(bsonFile.Open(), ShouldBeNil)
+		_, err := bsonFile.Write([]byte("twenty-five bytes of data"))
+		So(err, ShouldBeNil)
+		So(bsonFile.Close(), ShouldBeNil)
+
+		metadataFile := &realMetadataFile{path: md.outputPath("db", "c") + ".metadata.json",
+			intent: &intents.Intent{DB: "db", C: "c"}, storage: md.outputStorage, manifest: md.manifest}
+		So(metadataFile.Open(), ShouldBeNil)
+		_, err = metadataFile.Write([]byte("{}"))
+		So(err, ShouldBeNil)
+		So(metadataFile.Close(), ShouldBeNil)
+		So(md.tarOut.Close(), ShouldBeNil)
+
+		Convey("files have the directory layout, with large ones in segments", func() {
+			names, contents := readTar(&out)
+			So(names, ShouldResemble, []string{
+				"db/", "db/c.bson.part0000", "db/c.bson.part0001", "db/c.bson.part0002", "db/c.metadata.json",
+			})
+			So(string(contents["db/c.bson.part0002"]), ShouldEqual, " data")
+			So(string(contents["db/c.metadata.json"]), ShouldEqual, "{}")
+		})
+
+		Convey("the manifest lists whole files", func() {
+			So(md.manifest.files["db/c.bson"].Size, ShouldEqual, 25)
+		})
+	})
+
+	Convey("A tar.gz stream is gzipped as a whole", t, func() {
+		var out bytes.Buffer
+		output, err := newTarOutput(&nopCloseWriter{&out}, "test", compression.Codec{Name: compression.GzipName})
+		So(err, ShouldBeNil)
+		entry, err := output.Create("oplog.bson")
+		So(err, ShouldBeNil)
+		_, err = entry.Write([]byte("oplog"))
+		So(err, ShouldBeNil)
+		So(entry.Close(), ShouldBeNil)
+		So(output.Close(), ShouldBeNil)
+
+		reader, err := gzip.NewReader(&out)
+		So(err, ShouldBeNil)
+		names, contents := readTar(reader)
+		So(names, ShouldResemble, []string{"oplog.bson"})
+		So(string(contents["oplog.bson"]), ShouldEqual, "oplog")
+	})
+
+	Convey("Streams compressed with several workers decompress to their input", t, func() {
+		input := bytes.Repeat([]byte("compressed by several workers "), 150000)
+		for _, name := range []string{compression.GzipName, compression.ZstdName} {
+			codec := compression.Codec{Name: name, Workers: 3}
+			var out bytes.Buffer
+			writer, err := codec.NewWriter(&out)
+			So(err, ShouldBeNil)
+			_, err = writer.Write(input)
+			So(err, ShouldBeNil)
+			So(writer.Close(), ShouldBeNil)
+
+			reader, err := codec.NewReader(&out)
+			So(err, ShouldBeNil)
+			output, err := ioutil.ReadAll(reader)
+			So(err, ShouldBeNil)
+			So(bytes.Equal(output, input), ShouldBeTrue)
+
+			// a reset writer writes a new stream, which is valid even if empty
+			out.Reset()
+			writer.Reset(&out)
+			So(writer.Close(), ShouldBeNil)
+			reader, err = codec.NewReader(&out)
+			So(err, ShouldBeNil)
+			output, err = ioutil.ReadAll(reader)
+			So(err, ShouldBeNil)
+			So(output, ShouldBeEmpty)
+		}
+	})
+}
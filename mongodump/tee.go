@@ -0,0 +1,211 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
+)
+
+// teeQueueSize is how many writes of the archive are queued for a tee
+// target before the dump waits for it.
+const teeQueueSize = 64
+
+// sshScheme is the scheme of --teeArchive locations written over SSH, of the
+// form ssh://[user@]host[:port]/path.
+const sshScheme = "ssh://"
+
+// teeTarget is one copy of the archive stream. Each target is written on its
+// own goroutine, and one that fails is dropped without failing the dump or
+// the other targets.
+type teeTarget struct {
+	location string
+	out      io.WriteCloser
+	queue    chan []byte
+	done     chan struct{}
+	err      error
+}
+
+func newTeeTarget(location string, out io.WriteCloser) *teeTarget {
+	t := &teeTarget{
+		location: location,
+		out:      out,
+		queue:    make(chan []byte, teeQueueSize),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// run writes the queued data until the queue is closed, then closes the
+// target. Data after an error is discarded.
+func (t *teeTarget) run() {
+	defer close(t.done)
+	for data := range t.queue {
+		if t.err != nil {
+			continue
+		}
+		if _, err := t.out.Write(data); err != nil {
+			t.err = err
+			log.Logvf(log.Always, "warning: error writing the archive to %v, which is no longer written: %v", t.location, err)
+		}
+	}
+	if err := t.out.Close(); err != nil && t.err == nil {
+		t.err = err
+	}
+}
+
+// teeWriter writes the archive to its output and copies it to every tee
+// target. Only errors of the output are returned.
+type teeWriter struct {
+	out     io.WriteCloser
+	targets []*teeTarget
+	once    sync.Once
+}
+
+// Write writes p to the output, and queues a copy of it for each target.
+func (w *teeWriter) Write(p []byte) (int, error) {
+	n, err := w.out.Write(p)
+	if n > 0 {
+		data := make([]byte, n)
+		copy(data, p[:n])
+		for _, target := range w.targets {
+			target.queue <- data
+		}
+	}
+	return n, err
+}
+
+// Close closes the output and waits for every target to be written and
+// closed, logging the targets that failed.
+func (w *teeWriter) Close() error {
+	err := w.out.Close()
+	w.once.Do(func() {
+		for _, target := range w.targets {
+			close(target.queue)
+		}
+		for _, target := range w.targets {
+			<-target.done
+			if target.err != nil {
+				log.Logvf(log.Always, "warning: the archive was not copied to %v: %v", target.location, target.err)
+			} else {
+				log.Logvf(log.Info, "copied the archive to %v", target.location)
+			}
+		}
+	})
+	return err
+}
+
+// teeArchiveOut returns out, copying what is written to it to each location
+// of --teeArchive.
+func (dump *MongoDump) teeArchiveOut(out io.WriteCloser) (io.WriteCloser, error) {
+	if len(dump.OutputOptions.TeeArchive) == 0 {
+		return out, nil
+	}
+	tee := &teeWriter{out: out}
+	for _, location := range dump.OutputOptions.TeeArchive {
+		target, err := openTeeTarget(location)
+		if err != nil {
+			tee.Close()
+			return nil, fmt.Errorf("error opening --teeArchive %v: %v", location, err)
+		}
+		tee.targets = append(tee.targets, newTeeTarget(location, target))
+		log.Logvf(log.DebugLow, "copying the archive to %v", location)
+	}
+	return tee, nil
+}
+
+// openTeeTarget returns a writer for a --teeArchive location: an object
+// storage URL, an ssh:// URL or a local path.
+func openTeeTarget(location string) (io.WriteCloser, error) {
+	switch {
+	case storage.IsRemote(location):
+		return storage.Create(location)
+	case strings.HasPrefix(location, sshScheme):
+		return newSSHWriter(location)
+	default:
+		return os.Create(location)
+	}
+}
+
+// sshCommand returns the arguments of the ssh command that writes its input
+// to the file an ssh:// URL names.
+func sshCommand(location string) ([]string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh location '%v': %v", location, err)
+	}
+	if u.Hostname() == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, fmt.Errorf("ssh location '%v' must be of the form ssh://[user@]host[:port]/path", location)
+	}
+	args := []string{"-o", "BatchMode=yes"}
+	if u.Port() != "" {
+		args = append(args, "-p", u.Port())
+	}
+	host := u.Hostname()
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	// the path is relative to the home directory unless it starts with //
+	path := strings.TrimPrefix(u.Path, "/")
+	quoted := "'" + strings.Replace(path, "'", `'\''`, -1) + "'"
+	return append(args, host, "cat > "+quoted), nil
+}
+
+// sshWriter writes to a file on another host through the ssh command.
+type sshWriter struct {
+	io.WriteCloser
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+}
+
+func newSSHWriter(location string) (*sshWriter, error) {
+	args, err := sshCommand(location)
+	if err != nil {
+		return nil, err
+	}
+	w := &sshWriter{cmd: exec.Command("ssh", args...)}
+	w.cmd.Stderr = &w.stderr
+	if w.WriteCloser, err = w.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if err = w.cmd.Start(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Close ends the input of the ssh command and waits for it to exit.
+func (w *sshWriter) Close() error {
+	closeErr := w.WriteCloser.Close()
+	if err := w.cmd.Wait(); err != nil {
+		return fmt.Errorf("ssh failed: %v: %v", err, strings.TrimSpace(w.stderr.String()))
+	}
+	return closeErr
+}
+
+// teeArchiveConflict returns a --teeArchive location that is the same as
+// --archive or another copy, or "" if there is none.
+func (dump *MongoDump) teeArchiveConflict() string {
+	seen := map[string]bool{dump.OutputOptions.Archive: true}
+	for _, location := range dump.OutputOptions.TeeArchive {
+		if seen[location] {
+			return location
+		}
+		seen[location] = true
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// failingWriter fails every write after the first n bytes.
+type failingWriter struct {
+	n      int
+	closed bool
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return 0, fmt.Errorf("disk full")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func (w *failingWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestTeeArchive(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With an archive copied to local files", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump_tee")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.TeeArchive = []string{filepath.Join(dir, "a.archive"), filepath.Join(dir, "b.archive")}
+		var primary bytes.Buffer
+		out, err := md.teeArchiveOut(&nopCloseWriter{&primary})
+		So(err, ShouldBeNil)
+
+		data := bytes.Repeat([]byte("archive"), 10000)
+		for i := 0; i < len(data); i += 1000 {
+			_, err = out.Write(data[i : i+1000])
+			So(err, ShouldBeNil)
+		}
+		So(out.Close(), ShouldBeNil)
+
+		Convey("every copy has the whole stream", func() {
+			So(primary.Bytes(), ShouldResemble, data)
+			for _, location := range md.OutputOptions.TeeArchive {
+				copied, err := ioutil.ReadFile(location)
+				So(err, ShouldBeNil)
+				So(copied, ShouldResemble, data)
+			}
+		})
+	})
+
+	Convey("A copy that fails doesn't fail the archive or the other copies", t, func() {
+		var primary, other bytes.Buffer
+		failing := &failingWriter{n: 10}
+		tee := &teeWriter{out: &nopCloseWriter{&primary}, targets: []*teeTarget{
+			newTeeTarget("failing", failing),
+			newTeeTarget("other", &nopCloseWriter{&other}),
+		}}
+		for i := 0; i < 3; i++ {
+			_, err := tee.Write([]byte("0123456789"))
+			So(err, ShouldBeNil)
+		}
+		So(tee.Close(), ShouldBeNil)
+
+		So(primary.String(), ShouldEqual, "012345678901234567890123456789")
+		So(other.String(), ShouldEqual, primary.String())
+		So(tee.targets[0].err, ShouldNotBeNil)
+		So(failing.closed, ShouldBeTrue)
+		So(tee.targets[1].err, ShouldBeNil)
+	})
+
+	Convey("ssh:// locations are written with the ssh command", t, func() {
+		args, err := sshCommand("ssh://backup@vault.example.com:2222/dumps/it's.archive")
+		So(err, ShouldBeNil)
+		So(args, ShouldResemble, []string{"-o", "BatchMode=yes", "-p", "2222", "backup@vault.example.com",
+			`cat > 'dumps/it'\''s.archive'`})
+
+		args, err = sshCommand("ssh://vault//var/backups/dump.archive")
+		So(err, ShouldBeNil)
+		So(args, ShouldResemble, []string{"-o", "BatchMode=yes", "vault", "cat > '/var/backups/dump.archive'"})
+
+		_, err = sshCommand("ssh://vault/")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,303 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mopt "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// previousDump is the dump given by --reuseUnchangedFrom, with the
+// namespaces that were written to since it was taken.
+type previousDump struct {
+	root string
+	// files are the files of the dump's manifest, by path
+	files   map[string]*manifestFile
+	changes oplogChanges
+}
+
+// oplogChanges is the set of namespaces that oplog entries changed. A
+// namespace of the form <db>.* means the whole database was dropped.
+type oplogChanges map[string]bool
+
+// add records the namespaces an oplog entry changes. Commands are recorded
+// as changing the collection they name, and the entries of an applyOps
+// command, such as those of transactions, are added too.
+func (c oplogChanges) add(entry bson.Raw) {
+	ns, _ := entry.Lookup("ns").StringValueOK()
+	if op, _ := entry.Lookup("op").StringValueOK(); op != "c" {
+		if ns != "" {
+			c[ns] = true
+		}
+		return
+	}
+	o, ok := entry.Lookup("o").DocumentOK()
+	if !ok {
+		return
+	}
+	elements, err := o.Elements()
+	if err != nil || len(elements) == 0 {
+		return
+	}
+	database := strings.SplitN(ns, ".", 2)[0]
+	switch command := elements[0]; command.Key() {
+	case "applyOps":
+		ops, _ := command.Value().ArrayOK()
+		values, _ := ops.Values()
+		for _, value := range values {
+			if op, ok := value.DocumentOK(); ok {
+				c.add(op)
+			}
+		}
+	case "renameCollection":
+		if from, ok := command.Value().StringValueOK(); ok {
+			c[from] = true
+		}
+		if to, ok := o.Lookup("to").StringValueOK(); ok {
+			c[to] = true
+		}
+	case "dropDatabase":
+		c[database+".*"] = true
+	default:
+		if collection, ok := command.Value().StringValueOK(); ok {
+			c[database+"."+collection] = true
+		}
+	}
+}
+
+// includes returns whether the namespace was changed.
+func (c oplogChanges) includes(ns string) bool {
+	database := strings.SplitN(ns, ".", 2)[0]
+	return c[ns] || c[database+".*"]
+}
+
+// recordChangeMarker records the latest oplog time in the manifest of a
+// dump to a directory, so that a later dump can reuse the collections that
+// haven't changed since. Dumps of anything but a replica set have no marker.
+func (dump *MongoDump) recordChangeMarker() error {
+	if dump.manifest == nil || dump.OutputOptions.Archive != "" {
+		return nil
+	}
+	err := fmt.Errorf("not connected to a replica set")
+	if !dump.isMongos {
+		err = dump.determineOplogCollectionName()
+		if err == nil && dump.oplogCollection != "oplog.rs" {
+			err = fmt.Errorf("not connected to a replica set")
+		}
+	}
+	var marker primitive.Timestamp
+	if err == nil {
+		marker, err = dump.getCurrentOplogTime()
+	}
+	if err != nil {
+		if dump.OutputOptions.ReuseUnchangedFrom != "" {
+			return fmt.Errorf("--reuseUnchangedFrom requires reading the oplog of a replica set: %v", err)
+		}
+		log.Logvf(log.DebugLow, "not recording a change marker in the manifest: %v", err)
+		return nil
+	}
+	dump.changeMarker = &marker
+	return nil
+}
+
+// loadPreviousDump reads the manifest of --reuseUnchangedFrom and the
+// namespaces the oplog has changed since its change marker. Every
+// collection is dumped if the marker is missing or the oplog no longer goes
+// back to it.
+func (dump *MongoDump) loadPreviousDump() error {
+	root := dump.OutputOptions.ReuseUnchangedFrom
+	m, err := readManifest(filepath.Join(root, archive.ManifestFile))
+	if err != nil {
+		return fmt.Errorf("error reading the dump to reuse: %v", err)
+	}
+	if m.Interrupted {
+		log.Logvf(log.Always, "the dump in %v was interrupted, so every collection is dumped", root)
+		return nil
+	}
+	if m.ChangeMarker == "" {
+		log.Logvf(log.Always, "the dump in %v has no change marker, so every collection is dumped", root)
+		return nil
+	}
+	since, err := util.ParseTimestamp(m.ChangeMarker)
+	if err != nil {
+		return fmt.Errorf("invalid change marker in the manifest of %v: %v", root, err)
+	}
+	exists, err := dump.checkOplogTimestampExists(since)
+	if err != nil {
+		return fmt.Errorf("unable to check the oplog for the change marker of %v: %v", root, err)
+	}
+	if !exists {
+		log.Logvf(log.Always, "the oplog no longer goes back to the dump in %v, so every collection is dumped", root)
+		return nil
+	}
+
+	changes, err := dump.readOplogChanges(since)
+	if err != nil {
+		return fmt.Errorf("error reading the oplog since %v: %v", util.FormatTimestamp(since), err)
+	}
+	previous := &previousDump{root: root, files: map[string]*manifestFile{}, changes: changes}
+	for _, file := range m.Files {
+		previous.files[file.Path] = file
+	}
+	dump.previous = previous
+	log.Logvf(log.Always, "reusing unchanged collections from %v; %v %v changed since it was taken",
+		root, len(changes), util.Pluralize(len(changes), "namespace", "namespaces"))
+	return nil
+}
+
+// readOplogChanges returns the namespaces changed by oplog entries after
+// since. Commands are read whole for the collections they name, but only
+// the namespace of other entries is read.
+func (dump *MongoDump) readOplogChanges(since primitive.Timestamp) (oplogChanges, error) {
+	session, err := dump.SessionProvider.GetSession()
+	if err != nil {
+		return nil, err
+	}
+	coll := session.Database("local").Collection(dump.oplogCollection)
+	after := bson.D{{"$gt", since}}
+	queries := []struct {
+		filter bson.D
+		opts   *mopt.FindOptions
+	}{
+		{bson.D{{"ts", after}, {"op", bson.D{{"$nin", bson.A{"c", "n"}}}}}, mopt.Find().SetProjection(bson.D{{"ns", 1}, {"op", 1}})},
+		{bson.D{{"ts", after}, {"op", "c"}}, mopt.Find()},
+	}
+
+	changes := oplogChanges{}
+	for _, query := range queries {
+		cursor, err := coll.Find(context.Background(), query.filter, query.opts)
+		if err != nil {
+			return nil, err
+		}
+		for cursor.Next(context.Background()) {
+			changes.add(cursor.Current)
+		}
+		err = cursor.Err()
+		cursor.Close(context.Background())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// unchangedFiles returns the files of the previous dump that hold the
+// collection at rel, in order, if the collection has the same UUID and
+// document count and the oplog has no changes to it. It returns nil if the
+// collection has to be dumped.
+func (p *previousDump) unchangedFiles(rel, ns, uuid string, count int64) []*manifestFile {
+	var files []*manifestFile
+	for path, file := range p.files {
+		if base, _, ok := util.SplitSegmentPath(path); path == rel || ok && base == rel {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 || p.changes.includes(ns) {
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	// the collection is recorded with the file, or the first segment of a
+	// split file
+	head := files[0]
+	if head.Documents == nil || *head.Documents != count || head.Namespace != ns || head.UUID != uuid {
+		return nil
+	}
+	return files
+}
+
+// reuseUnchanged links the files of an unchanged collection from the
+// previous dump into the output, instead of dumping it. It returns the number
+// of documents in the reused files, and false if the collection has to be
+// dumped.
+func (dump *MongoDump) reuseUnchanged(intent *intents.Intent) (int64, bool, error) {
+	file, ok := intent.BSONFile.(*realBSONFile)
+	if dump.previous == nil || !ok || intent.UUID == "" || intent.IsView() {
+		return 0, false, nil
+	}
+	session, err := dump.SessionProvider.GetSession()
+	if err != nil {
+		return 0, false, err
+	}
+	count, err := session.Database(intent.DB).Collection(intent.C).EstimatedDocumentCount(context.Background())
+	if err != nil {
+		return 0, false, fmt.Errorf("error counting documents of %v: %v", intent.Namespace(), err)
+	}
+	files := dump.previous.unchangedFiles(dump.manifest.relative(file.path), intent.Namespace(), intent.UUID, count)
+	if files == nil {
+		return 0, false, nil
+	}
+	if err = dump.reuseFiles(files); err != nil {
+		return 0, false, fmt.Errorf("error reusing %v from %v: %v", intent.Namespace(), dump.previous.root, err)
+	}
+	return count, true, nil
+}
+
+// reuseFiles links files of the previous dump into the output at the same
+// paths, copying them where they can't be linked. The files are checked to
+// still have the size the previous manifest records first.
+func (dump *MongoDump) reuseFiles(files []*manifestFile) error {
+	for _, file := range files {
+		from := filepath.Join(dump.previous.root, filepath.FromSlash(file.Path))
+		info, err := os.Stat(from)
+		if err != nil {
+			return err
+		}
+		if info.Size() != file.Size {
+			return fmt.Errorf("%v is %v bytes, but its manifest has %v", from, info.Size(), file.Size)
+		}
+	}
+	for _, file := range files {
+		from := filepath.Join(dump.previous.root, filepath.FromSlash(file.Path))
+		to := filepath.Join(dump.outputRoot(), filepath.FromSlash(file.Path))
+		if err := linkOrCopy(from, to); err != nil {
+			return err
+		}
+		dump.manifest.addReused(to, file)
+	}
+	return nil
+}
+
+// linkOrCopy hard links to to from, replacing any file at to, or copies from
+// if it can't be linked, such as across file systems.
+func linkOrCopy(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), defaultPermissions); err != nil {
+		return err
+	}
+	if err := os.Remove(to); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(from, to); err == nil {
+		return nil
+	}
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
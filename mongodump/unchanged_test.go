@@ -0,0 +1,108 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestOplogChanges(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	entry := func(doc bson.D) bson.Raw {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	Convey("Oplog entries change the namespaces they and their commands name", t, func() {
+		changes := oplogChanges{}
+		changes.add(entry(bson.D{{"op", "i"}, {"ns", "app.users"}}))
+		changes.add(entry(bson.D{{"op", "c"}, {"ns", "app.$cmd"}, {"o", bson.D{{"createIndexes", "orders"}, {"v", 2}}}}))
+		changes.add(entry(bson.D{{"op", "c"}, {"ns", "admin.$cmd"}, {"o", bson.D{
+			{"renameCollection", "app.old"}, {"to", "app.new"},
+		}}}))
+		changes.add(entry(bson.D{{"op", "c"}, {"ns", "admin.$cmd"}, {"o", bson.D{{"applyOps", bson.A{
+			bson.D{{"op", "u"}, {"ns", "billing.invoices"}},
+		}}}}}))
+		changes.add(entry(bson.D{{"op", "c"}, {"ns", "scratch.$cmd"}, {"o", bson.D{{"dropDatabase", 1}}}}))
+		changes.add(entry(bson.D{{"op", "n"}, {"ns", ""}}))
+
+		for _, ns := range []string{"app.users", "app.orders", "app.old", "app.new", "billing.invoices", "scratch.anything"} {
+			So(changes.includes(ns), ShouldBeTrue)
+		}
+		So(changes.includes("app.static"), ShouldBeFalse)
+		So(changes.includes("billing.customers"), ShouldBeFalse)
+	})
+}
+
+func TestReuseUnchanged(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	documents := int64(42)
+	previous := &previousDump{
+		files: map[string]*manifestFile{
+			"app/static.bson":          {Path: "app/static.bson", Size: 5, Documents: &documents, Namespace: "app.static", UUID: "u1"},
+			"app/split.bson.part0000":  {Path: "app/split.bson.part0000", Size: 4, Documents: &documents, Namespace: "app.split", UUID: "u2"},
+			"app/split.bson.part0001":  {Path: "app/split.bson.part0001", Size: 2},
+			"app/busy.bson":            {Path: "app/busy.bson", Size: 5, Documents: &documents, Namespace: "app.busy", UUID: "u3"},
+			"app/static.metadata.json": {Path: "app/static.metadata.json", Size: 2},
+		},
+		changes: oplogChanges{"app.busy": true},
+	}
+
+	Convey("Collections are unchanged with the same UUID and count, and no oplog changes", t, func() {
+		files := previous.unchangedFiles("app/static.bson", "app.static", "u1", 42)
+		So(files, ShouldHaveLength, 1)
+		So(files[0].Path, ShouldEqual, "app/static.bson")
+
+		files = previous.unchangedFiles("app/split.bson", "app.split", "u2", 42)
+		So(files, ShouldHaveLength, 2)
+		So(files[1].Path, ShouldEqual, "app/split.bson.part0001")
+
+		So(previous.unchangedFiles("app/static.bson", "app.static", "recreated", 42), ShouldBeNil)
+		So(previous.unchangedFiles("app/static.bson", "app.static", "u1", 43), ShouldBeNil)
+		So(previous.unchangedFiles("app/busy.bson", "app.busy", "u3", 42), ShouldBeNil)
+		So(previous.unchangedFiles("app/new.bson", "app.new", "u4", 0), ShouldBeNil)
+	})
+
+	Convey("Reused files are linked into the output and recorded in its manifest", t, func() {
+		dir, err := ioutil.TempDir("", "mongodump-reuse")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		previous.root = filepath.Join(dir, "previous")
+		So(os.MkdirAll(filepath.Join(previous.root, "app"), 0755), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(previous.root, "app", "static.bson"), []byte("data!"), 0644), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(previous.root, "app", "busy.bson"), []byte("old"), 0644), ShouldBeNil)
+
+		md := simpleMongoDumpInstance()
+		md.OutputOptions.Out = filepath.Join(dir, "dump")
+		md.manifest = &dumpManifest{root: md.OutputOptions.Out, files: map[string]*manifestFile{}}
+		md.previous = previous
+
+		So(md.reuseFiles([]*manifestFile{previous.files["app/static.bson"]}), ShouldBeNil)
+		data, err := ioutil.ReadFile(filepath.Join(md.OutputOptions.Out, "app", "static.bson"))
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "data!")
+		reused := md.manifest.files["app/static.bson"]
+		So(reused.UUID, ShouldEqual, "u1")
+		So(*reused.Documents, ShouldEqual, 42)
+
+		Convey("unless they were modified since their manifest was written", func() {
+			So(md.reuseFiles([]*manifestFile{previous.files["app/busy.bson"]}), ShouldNotBeNil)
+		})
+	})
+}
@@ -7,13 +7,15 @@
 package mongoexport
 
 import (
-	"encoding/csv"
 	"fmt"
-	"github.com/mongodb/mongo-tools-common/bsonutil"
-	"github.com/mongodb/mongo-tools-common/json"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"io"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -34,32 +36,72 @@ type CSVExportOutput struct {
 	// NoHeaderLine, if set, will export CSV data without a list of field names at the first line
 	NoHeaderLine bool
 
-	csvWriter *csv.Writer
+	csvWriter *csvWriter
+
+	// flattener flattens documents with --flatten; without Fields, the
+	// fields are those of the first document, and the fields of later
+	// documents that aren't among them are counted in dropped
+	flattener      *flattener
+	discoverFields bool
+	headerPending  bool
+	dropped        map[string]bool
 }
 
 // NewCSVExportOutput returns a CSVExportOutput configured to write output to the
 // given io.Writer, extracting the specified fields only.
 func NewCSVExportOutput(fields []string, noHeaderLine bool, out io.Writer) *CSVExportOutput {
+	return NewCSVDialectExportOutput(fields, noHeaderLine, DefaultCSVDialect, out)
+}
+
+// NewCSVDialectExportOutput returns a CSVExportOutput that writes output to the
+// given io.Writer in the given dialect, extracting the specified fields only.
+func NewCSVDialectExportOutput(fields []string, noHeaderLine bool, dialect CSVDialect, out io.Writer) *CSVExportOutput {
 	return &CSVExportOutput{
-		fields,
-		0,
-		noHeaderLine,
-		csv.NewWriter(out),
+		Fields:       fields,
+		NoHeaderLine: noHeaderLine,
+		csvWriter:    newCSVWriter(dialect, out),
 	}
 }
 
+// NewCSVFlattenedExportOutput returns a CSVExportOutput like
+// NewCSVDialectExportOutput that flattens documents with f. Without fields, the
+// fields of the first document are exported.
+func NewCSVFlattenedExportOutput(fields []string, noHeaderLine bool, dialect CSVDialect, f *flattener, out io.Writer) *CSVExportOutput {
+	csvExporter := NewCSVDialectExportOutput(fields, noHeaderLine, dialect, out)
+	csvExporter.flattener = f
+	csvExporter.discoverFields = len(fields) == 0
+	csvExporter.dropped = map[string]bool{}
+	return csvExporter
+}
+
 // WriteHeader writes a comma-delimited list of fields as the output header row.
 func (csvExporter *CSVExportOutput) WriteHeader() error {
+	if csvExporter.discoverFields && len(csvExporter.Fields) == 0 {
+		// written with the fields of the first document
+		csvExporter.headerPending = !csvExporter.NoHeaderLine
+		return nil
+	}
 	if !csvExporter.NoHeaderLine {
-		csvExporter.csvWriter.Write(csvExporter.Fields)
+		csvExporter.csvWriter.WriteStrings(csvExporter.Fields)
 		return csvExporter.csvWriter.Error()
 	}
 	return nil
 }
 
-// WriteFooter is a no-op for CSV export formats.
+// WriteFooter is a no-op for CSV export formats, except to warn of the
+// flattened fields that weren't exported.
 func (csvExporter *CSVExportOutput) WriteFooter() error {
 	// no CSV footer
+	if len(csvExporter.dropped) > 0 {
+		dropped := make([]string, 0, len(csvExporter.dropped))
+		for key := range csvExporter.dropped {
+			dropped = append(dropped, key)
+		}
+		sort.Strings(dropped)
+		log.Logvf(log.Always, "did not export %v %v missing from the first document, which the columns are taken from: %v; "+
+			"use --fields to export them", len(dropped), util.Pluralize(len(dropped), "field", "fields"),
+			strings.Join(dropped, ", "))
+	}
 	return nil
 }
 
@@ -71,53 +113,106 @@ func (csvExporter *CSVExportOutput) Flush() error {
 
 // ExportDocument writes a line to output with the CSV representation of a document.
 func (csvExporter *CSVExportOutput) ExportDocument(document bson.D) error {
-	rowOut := make([]string, 0, len(csvExporter.Fields))
+	rowOut := make([]csvField, 0, len(csvExporter.Fields))
 	extendedDoc, err := bsonutil.ConvertBSONValueToLegacyExtJSON(document)
 	if err != nil {
 		return err
 	}
 
+	if csvExporter.flattener != nil {
+		return csvExporter.exportFlattened(extendedDoc)
+	}
+
 	for _, fieldName := range csvExporter.Fields {
-		fieldVal := extractFieldByName(fieldName, extendedDoc)
-		if fieldVal == nil {
-			rowOut = append(rowOut, "")
-		} else if reflect.TypeOf(fieldVal) == reflect.TypeOf(bson.M{}) ||
-			reflect.TypeOf(fieldVal) == reflect.TypeOf(bson.D{}) ||
-			reflect.TypeOf(fieldVal) == marshalDType ||
-			reflect.TypeOf(fieldVal) == reflect.TypeOf([]interface{}{}) {
-			buf, err := json.Marshal(fieldVal)
-			if err != nil {
-				rowOut = append(rowOut, "")
-			} else {
-				rowOut = append(rowOut, string(buf))
+		rowOut = append(rowOut, csvValue(findFieldByName(fieldName, extendedDoc)))
+	}
+	csvExporter.csvWriter.Write(rowOut)
+	csvExporter.NumExported++
+	return csvExporter.csvWriter.Error()
+}
+
+// exportFlattened writes the rows of a flattened document.
+func (csvExporter *CSVExportOutput) exportFlattened(extendedDoc interface{}) error {
+	rows := csvExporter.flattener.flatten(extendedDoc)
+	if csvExporter.discoverFields && csvExporter.Fields == nil {
+		csvExporter.Fields = make([]string, 0, len(rows[0]))
+		for _, field := range rows[0] {
+			csvExporter.Fields = append(csvExporter.Fields, field.key)
+		}
+		if csvExporter.headerPending {
+			csvExporter.csvWriter.WriteStrings(csvExporter.Fields)
+			csvExporter.headerPending = false
+		}
+	}
+
+	for _, row := range rows {
+		values := make(map[string]interface{}, len(row))
+		for _, field := range row {
+			values[field.key] = field.value
+		}
+		rowOut := make([]csvField, 0, len(csvExporter.Fields))
+		for _, fieldName := range csvExporter.Fields {
+			value, found := values[fieldName]
+			rowOut = append(rowOut, csvValue(value, found))
+			delete(values, fieldName)
+		}
+		if csvExporter.discoverFields {
+			for key := range values {
+				csvExporter.dropped[key] = true
 			}
-		} else {
-			rowOut = append(rowOut, fmt.Sprintf("%v", fieldVal))
 		}
+		csvExporter.csvWriter.Write(rowOut)
 	}
-	csvExporter.csvWriter.Write(rowOut)
 	csvExporter.NumExported++
 	return csvExporter.csvWriter.Error()
 }
 
+// csvValue returns the CSV field of a value found in a document, which is
+// null if it is null or wasn't found.
+func csvValue(fieldVal interface{}, found bool) csvField {
+	if fieldVal == nil || !found {
+		return csvField{null: true}
+	} else if reflect.TypeOf(fieldVal) == reflect.TypeOf(bson.M{}) ||
+		reflect.TypeOf(fieldVal) == reflect.TypeOf(bson.D{}) ||
+		reflect.TypeOf(fieldVal) == marshalDType ||
+		reflect.TypeOf(fieldVal) == reflect.TypeOf([]interface{}{}) {
+		buf, err := json.Marshal(fieldVal)
+		if err != nil {
+			return csvField{}
+		}
+		return csvField{value: string(buf)}
+	}
+	return csvField{value: fmt.Sprintf("%v", fieldVal)}
+}
+
 // extractFieldByName takes a field name and document, and returns a value representing
 // the value of that field in the document in a format that can be printed as a string.
 // It will also handle dot-delimited field names for nested arrays or documents.
 func extractFieldByName(fieldName string, document interface{}) interface{} {
+	value, found := findFieldByName(fieldName, document)
+	if !found {
+		return ""
+	}
+	return value
+}
+
+// findFieldByName returns the value of a field like extractFieldByName, and
+// whether the document has the field.
+func findFieldByName(fieldName string, document interface{}) (interface{}, bool) {
 	dotParts := strings.Split(fieldName, ".")
 	var subdoc interface{} = document
 
 	for _, path := range dotParts {
 		docValue := reflect.ValueOf(subdoc)
 		if !docValue.IsValid() {
-			return ""
+			return nil, false
 		}
 		docType := docValue.Type()
 		docKind := docType.Kind()
 		if docKind == reflect.Map {
 			subdocVal := docValue.MapIndex(reflect.ValueOf(path))
 			if subdocVal.Kind() == reflect.Invalid {
-				return ""
+				return nil, false
 			}
 			subdoc = subdocVal.Interface()
 		} else if docKind == reflect.Slice {
@@ -127,28 +222,28 @@ func extractFieldByName(fieldName string, document interface{}) interface{} {
 				var err error
 				subdoc, err = bsonutil.FindValueByKey(path, &asD)
 				if err != nil {
-					return ""
+					return nil, false
 				}
 			} else {
 				//  check that the path can be converted to int
 				arrayIndex, err := strconv.Atoi(path)
 				if err != nil {
-					return ""
+					return nil, false
 				}
 				// bounds check for slice
 				if arrayIndex < 0 || arrayIndex >= docValue.Len() {
-					return ""
+					return nil, false
 				}
 				subdocVal := docValue.Index(arrayIndex)
 				if subdocVal.Kind() == reflect.Invalid {
-					return ""
+					return nil, false
 				}
 				subdoc = subdocVal.Interface()
 			}
 		} else {
-			// trying to index into a non-compound type - just return blank.
-			return ""
+			// trying to index into a non-compound type - the field is missing.
+			return nil, false
 		}
 	}
-	return subdoc
+	return subdoc, true
 }
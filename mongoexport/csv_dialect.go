@@ -0,0 +1,185 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CSVDialect controls how the fields of a CSV export are delimited, quoted
+// and escaped, and how its lines end.
+type CSVDialect struct {
+	Delimiter rune
+	Quote     rune
+	// Escape precedes the quotes, and itself, inside quoted fields; quotes
+	// are doubled if it is the quote
+	Escape rune
+	CRLF   bool
+	// NullString is written for null values and missing fields; fields
+	// equal to it are quoted
+	NullString string
+}
+
+// DefaultCSVDialect is the dialect of RFC 4180, which encoding/csv reads.
+var DefaultCSVDialect = CSVDialect{Delimiter: ',', Quote: '"', Escape: '"'}
+
+// csvField is a field of a CSV record, written as the null string if null
+// is set.
+type csvField struct {
+	value string
+	null  bool
+}
+
+// CSVDialect returns the dialect given by --csvDelimiter, --csvQuote,
+// --csvEscape, --crlf and --nullString.
+func (outputOptions *OutputFormatOptions) CSVDialect() (CSVDialect, error) {
+	dialect := DefaultCSVDialect
+	var err error
+	if dialect.Delimiter, err = parseCSVChar("--csvDelimiter", outputOptions.CSVDelimiter, dialect.Delimiter); err != nil {
+		return dialect, err
+	}
+	if dialect.Quote, err = parseCSVChar("--csvQuote", outputOptions.CSVQuote, dialect.Quote); err != nil {
+		return dialect, err
+	}
+	if dialect.Escape, err = parseCSVChar("--csvEscape", outputOptions.CSVEscape, dialect.Quote); err != nil {
+		return dialect, err
+	}
+	dialect.CRLF = outputOptions.CRLF
+	dialect.NullString = outputOptions.NullString
+
+	if dialect.Delimiter == dialect.Quote || dialect.Delimiter == dialect.Escape {
+		return dialect, fmt.Errorf("the CSV delimiter must differ from its quote and escape characters")
+	}
+	if strings.ContainsAny(dialect.NullString, "\r\n") {
+		return dialect, fmt.Errorf("--nullString cannot contain line breaks")
+	}
+	return dialect, nil
+}
+
+// hasCSVDialect returns whether any option of the CSV dialect is set.
+func (outputOptions *OutputFormatOptions) hasCSVDialect() bool {
+	return outputOptions.CSVDelimiter != "" || outputOptions.CSVQuote != "" || outputOptions.CSVEscape != "" ||
+		outputOptions.CRLF || outputOptions.NullString != ""
+}
+
+// parseCSVChar returns the single character of a CSV dialect option, which
+// may be given as '\t' or 'tab' for a tab, or def if it isn't set.
+func parseCSVChar(option, value string, def rune) (rune, error) {
+	switch value {
+	case "":
+		return def, nil
+	case `\t`, "tab":
+		return '\t', nil
+	}
+	char, size := utf8.DecodeRuneInString(value)
+	if size != len(value) || char == utf8.RuneError {
+		return 0, fmt.Errorf("%v must be a single character, got '%v'", option, value)
+	}
+	if char == '\r' || char == '\n' {
+		return 0, fmt.Errorf("%v cannot be a line break", option)
+	}
+	return char, nil
+}
+
+// csvWriter writes CSV records in a dialect. Like a csv.Writer, it buffers
+// its output until Flush, and its first error is kept for Error.
+type csvWriter struct {
+	dialect CSVDialect
+	w       *bufio.Writer
+	err     error
+}
+
+func newCSVWriter(dialect CSVDialect, out io.Writer) *csvWriter {
+	return &csvWriter{dialect: dialect, w: bufio.NewWriter(out)}
+}
+
+// WriteStrings writes a record of non-null fields.
+func (w *csvWriter) WriteStrings(record []string) {
+	fields := make([]csvField, len(record))
+	for i, value := range record {
+		fields[i] = csvField{value: value}
+	}
+	w.Write(fields)
+}
+
+// Write writes a record.
+func (w *csvWriter) Write(record []csvField) {
+	if w.err != nil {
+		return
+	}
+	for i, field := range record {
+		if i > 0 {
+			w.w.WriteRune(w.dialect.Delimiter)
+		}
+		switch {
+		case field.null:
+			w.w.WriteString(w.dialect.NullString)
+		case !w.needsQuotes(field.value):
+			w.w.WriteString(field.value)
+		default:
+			w.writeQuoted(field.value)
+		}
+	}
+	if w.dialect.CRLF {
+		_, w.err = w.w.WriteString("\r\n")
+	} else {
+		w.err = w.w.WriteByte('\n')
+	}
+}
+
+func (w *csvWriter) writeQuoted(value string) {
+	dialect := w.dialect
+	w.w.WriteRune(dialect.Quote)
+	for _, char := range value {
+		switch {
+		case char == dialect.Quote || char == dialect.Escape:
+			w.w.WriteRune(dialect.Escape)
+			w.w.WriteRune(char)
+		case char == '\r' && dialect.CRLF:
+			// line breaks in fields end like those between records
+		case char == '\n' && dialect.CRLF:
+			w.w.WriteString("\r\n")
+		default:
+			w.w.WriteRune(char)
+		}
+	}
+	w.w.WriteRune(dialect.Quote)
+}
+
+// needsQuotes returns whether a field has to be quoted to be read back as
+// it is, by the rules of encoding/csv, or to tell it from the null string.
+func (w *csvWriter) needsQuotes(value string) bool {
+	dialect := w.dialect
+	switch {
+	case value == "":
+		return false
+	case value == `\.` || value == dialect.NullString:
+		return true
+	case strings.ContainsRune(value, dialect.Delimiter) || strings.ContainsRune(value, dialect.Quote) ||
+		strings.ContainsRune(value, dialect.Escape) || strings.ContainsAny(value, "\r\n"):
+		return true
+	}
+	first, _ := utf8.DecodeRuneInString(value)
+	return unicode.IsSpace(first)
+}
+
+// Flush writes the buffered records.
+func (w *csvWriter) Flush() {
+	if err := w.w.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+// Error returns the first error of a Write or Flush.
+func (w *csvWriter) Error() error {
+	return w.err
+}
@@ -0,0 +1,80 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCSVDialect(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	export := func(dialect CSVDialect, docs ...bson.D) string {
+		out := &bytes.Buffer{}
+		csvExporter := NewCSVDialectExportOutput([]string{"_id", "a", "b"}, false, dialect, out)
+		So(csvExporter.WriteHeader(), ShouldBeNil)
+		for _, doc := range docs {
+			So(csvExporter.ExportDocument(doc), ShouldBeNil)
+		}
+		So(csvExporter.Flush(), ShouldBeNil)
+		return out.String()
+	}
+	doc := bson.D{{"_id", "1"}, {"a", "say \"hi\", then\nleave"}, {"b", nil}}
+
+	Convey("The default dialect is written as encoding/csv writes it", t, func() {
+		rows := [][]string{
+			{"_id", "a", "b"},
+			{"1", "say \"hi\", then\nleave", ""},
+			{"2", " leading space", ""},
+			{"3", `\.`, ""},
+		}
+		expected := &bytes.Buffer{}
+		writer := csv.NewWriter(expected)
+		So(writer.WriteAll(rows), ShouldBeNil)
+		So(export(DefaultCSVDialect, doc,
+			bson.D{{"_id", "2"}, {"a", " leading space"}},
+			bson.D{{"_id", "3"}, {"a", `\.`}}), ShouldEqual, expected.String())
+	})
+
+	Convey("Fields are delimited, quoted and escaped in the dialect", t, func() {
+		dialect := CSVDialect{Delimiter: '|', Quote: '\'', Escape: '\\', CRLF: true, NullString: `\N`}
+		So(export(dialect, doc, bson.D{{"_id", "2"}, {"a", `\N`}, {"b", "it's"}}), ShouldEqual,
+			"_id|a|b\r\n"+
+				"1|'say \"hi\", then\r\nleave'|\\N\r\n"+
+				"2|'\\\\N'|'it\\'s'\r\n")
+	})
+
+	Convey("The dialect is read from the options", t, func() {
+		dialect, err := (&OutputFormatOptions{}).CSVDialect()
+		So(err, ShouldBeNil)
+		So(dialect, ShouldResemble, DefaultCSVDialect)
+
+		dialect, err = (&OutputFormatOptions{CSVDelimiter: `\t`, CSVQuote: "'", CRLF: true, NullString: "NULL"}).CSVDialect()
+		So(err, ShouldBeNil)
+		So(dialect, ShouldResemble, CSVDialect{Delimiter: '\t', Quote: '\'', Escape: '\'', CRLF: true, NullString: "NULL"})
+
+		_, err = (&OutputFormatOptions{CSVDelimiter: "||"}).CSVDialect()
+		So(err, ShouldNotBeNil)
+		_, err = (&OutputFormatOptions{CSVDelimiter: `"`}).CSVDialect()
+		So(err, ShouldNotBeNil)
+		_, err = (&OutputFormatOptions{CSVQuote: "\n"}).CSVDialect()
+		So(err, ShouldNotBeNil)
+		_, err = (&OutputFormatOptions{NullString: "a\nb"}).CSVDialect()
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("The dialect options only apply to CSV exports", t, func() {
+		So((&OutputFormatOptions{}).hasCSVDialect(), ShouldBeFalse)
+		So((&OutputFormatOptions{CRLF: true}).hasCSVDialect(), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,141 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The ways --flattenArrays flattens arrays.
+const (
+	flattenIndex   = "index"
+	flattenExplode = "explode"
+	flattenJSON    = "json"
+)
+
+// defaultFlattenSeparator joins the keys of flattened fields by default.
+const defaultFlattenSeparator = "."
+
+// flattener flattens the nested documents and arrays of a document into
+// rows of fields for --flatten.
+type flattener struct {
+	separator string
+	// arrays is how arrays are flattened: their elements are fields keyed
+	// by index, rows of their own, or a single field encoded as JSON
+	arrays string
+}
+
+// flatField is a field of a flattened document. Its value is never a
+// document or array with elements, unless arrays are encoded as JSON.
+type flatField struct {
+	key   string
+	value interface{}
+}
+
+// newFlattener returns the flattener of --flatten, or nil if it isn't set.
+func (outputOptions *OutputFormatOptions) newFlattener() (*flattener, error) {
+	if !outputOptions.Flatten {
+		if outputOptions.FlattenSeparator != "" || outputOptions.FlattenArrays != "" {
+			return nil, fmt.Errorf("--flattenSeparator and --flattenArrays require --flatten")
+		}
+		return nil, nil
+	}
+	f := &flattener{separator: outputOptions.FlattenSeparator, arrays: outputOptions.FlattenArrays}
+	if f.separator == "" {
+		f.separator = defaultFlattenSeparator
+	}
+	if f.arrays == "" {
+		f.arrays = flattenIndex
+	}
+	return f, nil
+}
+
+// flatten returns the rows of a document converted to extended JSON. It is
+// a single row unless arrays are exploded, in which case there is a row for
+// each combination of the elements of its arrays.
+func (f *flattener) flatten(document interface{}) [][]flatField {
+	return f.flattenValue("", document)
+}
+
+func (f *flattener) flattenValue(key string, value interface{}) [][]flatField {
+	leaf := [][]flatField{{{key: key, value: value}}}
+	switch v := value.(type) {
+	case bsonutil.MarshalD:
+		return f.flattenDocument(key, bson.D(v))
+	case bson.D:
+		return f.flattenDocument(key, v)
+	case bson.M:
+		// sort the keys for a deterministic order
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		doc := make(bson.D, 0, len(v))
+		for _, k := range keys {
+			doc = append(doc, bson.E{Key: k, Value: v[k]})
+		}
+		return f.flattenDocument(key, doc)
+	case []interface{}:
+		switch {
+		case len(v) == 0 || f.arrays == flattenJSON:
+			return leaf
+		case f.arrays == flattenExplode:
+			var rows [][]flatField
+			for _, element := range v {
+				rows = append(rows, f.flattenValue(key, element)...)
+			}
+			return rows
+		}
+		doc := make(bson.D, len(v))
+		for i, element := range v {
+			doc[i] = bson.E{Key: strconv.Itoa(i), Value: element}
+		}
+		return f.flattenDocument(key, doc)
+	}
+	return leaf
+}
+
+// flattenDocument returns the rows of each field of a document, combined.
+// An empty document is kept as a field of its own.
+func (f *flattener) flattenDocument(key string, doc bson.D) [][]flatField {
+	if len(doc) == 0 && key != "" {
+		return [][]flatField{{{key: key, value: bsonutil.MarshalD{}}}}
+	}
+	rows := [][]flatField{nil}
+	for _, elem := range doc {
+		fieldKey := elem.Key
+		if key != "" {
+			fieldKey = key + f.separator + elem.Key
+		}
+		rows = combineRows(rows, f.flattenValue(fieldKey, elem.Value))
+	}
+	return rows
+}
+
+// combineRows returns a row for each pair of a row of a and a row of b.
+func combineRows(a, b [][]flatField) [][]flatField {
+	if len(b) == 1 {
+		for i := range a {
+			a[i] = append(a[i], b[0]...)
+		}
+		return a
+	}
+	combined := make([][]flatField, 0, len(a)*len(b))
+	for _, x := range a {
+		for _, y := range b {
+			row := make([]flatField, 0, len(x)+len(y))
+			combined = append(combined, append(append(row, x...), y...))
+		}
+	}
+	return combined
+}
@@ -0,0 +1,87 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFlatten(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	export := func(options OutputFormatOptions, fields []string, docs ...bson.D) string {
+		options.Flatten = true
+		f, err := options.newFlattener()
+		So(err, ShouldBeNil)
+		out := &bytes.Buffer{}
+		csvExporter := NewCSVFlattenedExportOutput(fields, false, DefaultCSVDialect, f, out)
+		So(csvExporter.WriteHeader(), ShouldBeNil)
+		for _, doc := range docs {
+			So(csvExporter.ExportDocument(doc), ShouldBeNil)
+		}
+		So(csvExporter.WriteFooter(), ShouldBeNil)
+		So(csvExporter.Flush(), ShouldBeNil)
+		return out.String()
+	}
+	order := bson.D{
+		{"_id", "1"},
+		{"customer", bson.D{{"name", "Ann"}, {"address", bson.D{{"city", "Oslo"}}}}},
+		{"items", bson.A{bson.D{{"sku", "a"}, {"qty", "2"}}, bson.D{{"sku", "b"}, {"qty", "1"}}}},
+		{"notes", bson.D{}},
+	}
+
+	Convey("Nested documents and arrays are exported as columns named by their keys", t, func() {
+		So(export(OutputFormatOptions{}, nil, order), ShouldEqual,
+			"_id,customer.name,customer.address.city,items.0.sku,items.0.qty,items.1.sku,items.1.qty,notes\n"+
+				"1,Ann,Oslo,a,2,b,1,{}\n")
+		So(export(OutputFormatOptions{FlattenSeparator: "_"}, []string{"_id", "customer_address_city"}, order),
+			ShouldEqual, "_id,customer_address_city\n1,Oslo\n")
+	})
+
+	Convey("Arrays can be exploded into rows or encoded as JSON", t, func() {
+		So(export(OutputFormatOptions{FlattenArrays: flattenExplode}, []string{"_id", "items.sku", "items.qty"}, order),
+			ShouldEqual, "_id,items.sku,items.qty\n1,a,2\n1,b,1\n")
+		So(export(OutputFormatOptions{FlattenArrays: flattenJSON}, []string{"_id", "items"}, order),
+			ShouldEqual, "_id,items\n1,\"[{\"\"sku\"\":\"\"a\"\",\"\"qty\"\":\"\"2\"\"},{\"\"sku\"\":\"\"b\"\",\"\"qty\"\":\"\"1\"\"}]\"\n")
+	})
+
+	Convey("Several exploded arrays give a row for each combination of their elements", t, func() {
+		f := &flattener{separator: ".", arrays: flattenExplode}
+		rows := f.flatten(bson.D{{"a", []interface{}{"1", "2"}}, {"b", []interface{}{"x", "y"}}})
+		So(rows, ShouldHaveLength, 4)
+		So(rows[1], ShouldResemble, []flatField{{key: "a", value: "1"}, {key: "b", value: "y"}})
+		So(rows[2], ShouldResemble, []flatField{{key: "a", value: "2"}, {key: "b", value: "x"}})
+	})
+
+	Convey("The columns of later documents missing from the first are left out", t, func() {
+		So(export(OutputFormatOptions{}, nil,
+			bson.D{{"_id", "1"}, {"a", bson.D{{"b", "x"}}}},
+			bson.D{{"_id", "2"}, {"a", bson.D{{"c", "y"}}}}), ShouldEqual,
+			"_id,a.b\n1,x\n2,\n")
+	})
+
+	Convey("The flattening options require --flatten and CSV output", t, func() {
+		f, err := (&OutputFormatOptions{}).newFlattener()
+		So(err, ShouldBeNil)
+		So(f, ShouldBeNil)
+		_, err = (&OutputFormatOptions{FlattenArrays: flattenJSON}).newFlattener()
+		So(err, ShouldNotBeNil)
+
+		exp := &MongoExport{
+			InputOpts:  &InputOptions{NumParallelPartitions: 2},
+			OutputOpts: &OutputFormatOptions{Type: CSV, Flatten: true, OutputFile: "out.csv"},
+		}
+		So(exp.validatePartitions(), ShouldNotBeNil)
+		exp.OutputOpts.Fields = "_id,a.b"
+		So(exp.validatePartitions(), ShouldBeNil)
+	})
+}
@@ -10,7 +10,7 @@ import (
 	"bytes"
 	"io"
 
-	"github.com/mongodb/mongo-tools-common/json"
+	"github.com/mongodb/mongo-tools/common/json"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
@@ -25,17 +25,18 @@ type JSONExportOutput struct {
 	Out          io.Writer
 	NumExported  int64
 	JSONFormat   JSONFormat
+	// TypeFormats are the plain JSON formats of the types set by --jsonType.
+	TypeFormats JSONTypeFormats
 }
 
 // NewJSONExportOutput creates a new JSONExportOutput in array mode if specified,
 // configured to write data to the given io.Writer.
 func NewJSONExportOutput(arrayOutput bool, prettyOutput bool, out io.Writer, jsonFormat JSONFormat) *JSONExportOutput {
 	return &JSONExportOutput{
-		arrayOutput,
-		prettyOutput,
-		out,
-		0,
-		jsonFormat,
+		ArrayOutput:  arrayOutput,
+		PrettyOutput: prettyOutput,
+		Out:          out,
+		JSONFormat:   jsonFormat,
 	}
 }
 
@@ -92,7 +93,7 @@ func (jsonExporter *JSONExportOutput) ExportDocument(document bson.D) error {
 			}
 		}
 
-		jsonOut, err := bson.MarshalExtJSON(document, jsonExporter.JSONFormat == Canonical, false)
+		jsonOut, err := jsonExporter.marshal(document)
 		if err != nil {
 			return err
 		}
@@ -109,7 +110,7 @@ func (jsonExporter *JSONExportOutput) ExportDocument(document bson.D) error {
 			return err
 		}
 	} else {
-		extendedDoc, err := bson.MarshalExtJSON(document, jsonExporter.JSONFormat == Canonical, false)
+		extendedDoc, err := jsonExporter.marshal(document)
 		if err != nil {
 			return err
 		}
@@ -122,3 +123,12 @@ func (jsonExporter *JSONExportOutput) ExportDocument(document bson.D) error {
 	jsonExporter.NumExported++
 	return nil
 }
+
+// marshal converts the given document to JSON in the output's format.
+func (jsonExporter *JSONExportOutput) marshal(document bson.D) ([]byte, error) {
+	document = jsonExporter.TypeFormats.convert(document).(bson.D)
+	if jsonExporter.JSONFormat == Legacy {
+		return marshalLegacyJSON(document)
+	}
+	return bson.MarshalExtJSON(document, jsonExporter.JSONFormat == Canonical, false)
+}
@@ -0,0 +1,151 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/json"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The BSON types whose format --jsonType sets, and the formats of each.
+const (
+	jsonTypeDate     = "date"
+	jsonTypeObjectID = "objectId"
+	jsonTypeLong     = "long"
+	jsonTypeDecimal  = "decimal"
+	jsonTypeBinary   = "binary"
+
+	jsonFormatISO    = "iso"
+	jsonFormatEpoch  = "epoch"
+	jsonFormatHex    = "hex"
+	jsonFormatString = "string"
+	jsonFormatBase64 = "base64"
+)
+
+// jsonTypeChoices are the formats each type can be given by --jsonType.
+var jsonTypeChoices = map[string][]string{
+	jsonTypeDate:     {jsonFormatISO, jsonFormatEpoch},
+	jsonTypeObjectID: {jsonFormatHex},
+	jsonTypeLong:     {jsonFormatString},
+	jsonTypeDecimal:  {jsonFormatString},
+	jsonTypeBinary:   {jsonFormatBase64},
+}
+
+// isoDateFormat is the format of dates exported as ISO-8601 strings.
+const isoDateFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// JSONTypeFormats are the formats, keyed by type, that values of some BSON
+// types are exported in as plain JSON, rather than as extended JSON.
+type JSONTypeFormats map[string]string
+
+// JSONTypeFormats returns the formats given by --jsonType.
+func (outputOptions *OutputFormatOptions) JSONTypeFormats() (JSONTypeFormats, error) {
+	if len(outputOptions.JSONTypes) == 0 {
+		return nil, nil
+	}
+	formats := JSONTypeFormats{}
+	for _, spec := range outputOptions.JSONTypes {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid --jsonType '%v', expected <type>=<format>", spec)
+		}
+		typeName, format := parts[0], parts[1]
+		choices, ok := jsonTypeChoices[typeName]
+		if !ok {
+			return nil, fmt.Errorf("invalid --jsonType '%v', the type must be one of %v", spec, jsonTypeNames())
+		}
+		valid := false
+		for _, choice := range choices {
+			valid = valid || choice == format
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid --jsonType '%v', %v values can be formatted as %v",
+				spec, typeName, strings.Join(choices, " or "))
+		}
+		formats[typeName] = format
+	}
+	return formats, nil
+}
+
+func jsonTypeNames() string {
+	names := make([]string, 0, len(jsonTypeChoices))
+	for name := range jsonTypeChoices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// convert replaces the values of a document, and of its nested documents and
+// arrays, whose types have formats with their plain JSON values. The document
+// is changed in place.
+func (formats JSONTypeFormats) convert(value interface{}) interface{} {
+	if len(formats) == 0 {
+		return value
+	}
+	switch v := value.(type) {
+	case bson.D:
+		for i := range v {
+			v[i].Value = formats.convert(v[i].Value)
+		}
+	case bson.M:
+		for key, elem := range v {
+			v[key] = formats.convert(elem)
+		}
+	case bson.A:
+		for i := range v {
+			v[i] = formats.convert(v[i])
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = formats.convert(v[i])
+		}
+	case primitive.DateTime:
+		switch formats[jsonTypeDate] {
+		case jsonFormatISO:
+			return v.Time().UTC().Format(isoDateFormat)
+		case jsonFormatEpoch:
+			return int64(v)
+		}
+	case primitive.ObjectID:
+		if formats[jsonTypeObjectID] == jsonFormatHex {
+			return v.Hex()
+		}
+	case int64:
+		if formats[jsonTypeLong] == jsonFormatString {
+			return strconv.FormatInt(v, 10)
+		}
+	case primitive.Decimal128:
+		if formats[jsonTypeDecimal] == jsonFormatString {
+			return v.String()
+		}
+	case primitive.Binary:
+		if formats[jsonTypeBinary] == jsonFormatBase64 {
+			return base64.StdEncoding.EncodeToString(v.Data)
+		}
+	}
+	return value
+}
+
+// marshalLegacyJSON returns the legacy extended JSON of a document, in the
+// format of mongoexport before version 4.2 and of the mongo shell's strict
+// mode, e.g. {"$date":"2019-01-01T00:00:00.000Z"} and {"$numberLong":"1"}.
+func marshalLegacyJSON(document bson.D) ([]byte, error) {
+	converted, err := bsonutil.ConvertBSONValueToLegacyExtJSON(document)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(converted)
+}
@@ -0,0 +1,76 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestJSONTypes(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	id, _ := primitive.ObjectIDFromHex("5d0a8cd0e231e4bfd0c4b1a2")
+	date := primitive.NewDateTimeFromTime(time.Date(2019, 6, 19, 19, 30, 0, 0, time.UTC))
+	// each export gets its own document, as the legacy format and the type
+	// formats convert the document in place
+	newDoc := func() bson.D {
+		return bson.D{
+			{"_id", id},
+			{"at", date},
+			{"n", int64(5)},
+			{"nested", bson.D{{"ids", bson.A{id}}}},
+		}
+	}
+	export := func(format JSONFormat, formats JSONTypeFormats) string {
+		out := &bytes.Buffer{}
+		jsonExporter := NewJSONExportOutput(false, false, out, format)
+		jsonExporter.TypeFormats = formats
+		So(jsonExporter.ExportDocument(newDoc()), ShouldBeNil)
+		return out.String()
+	}
+
+	Convey("Documents are exported in the legacy extended JSON format", t, func() {
+		So(export(Legacy, nil), ShouldEqual,
+			`{"_id":{"$oid":"5d0a8cd0e231e4bfd0c4b1a2"},"at":{"$date":"2019-06-19T19:30:00.000Z"},`+
+				`"n":{"$numberLong":"5"},"nested":{"ids":[{"$oid":"5d0a8cd0e231e4bfd0c4b1a2"}]}}`+"\n")
+	})
+
+	Convey("Values of types with formats are exported as plain JSON", t, func() {
+		formats := JSONTypeFormats{jsonTypeDate: jsonFormatISO, jsonTypeObjectID: jsonFormatHex, jsonTypeLong: jsonFormatString}
+		expected := `{"_id":"5d0a8cd0e231e4bfd0c4b1a2","at":"2019-06-19T19:30:00.000Z","n":"5",` +
+			`"nested":{"ids":["5d0a8cd0e231e4bfd0c4b1a2"]}}` + "\n"
+		So(export(Relaxed, formats), ShouldEqual, expected)
+		So(export(Canonical, formats), ShouldEqual, expected)
+		So(export(Legacy, formats), ShouldEqual, expected)
+
+		So(export(Relaxed, JSONTypeFormats{jsonTypeDate: jsonFormatEpoch}), ShouldEqual,
+			`{"_id":{"$oid":"5d0a8cd0e231e4bfd0c4b1a2"},"at":1560972600000,"n":5,`+
+				`"nested":{"ids":[{"$oid":"5d0a8cd0e231e4bfd0c4b1a2"}]}}`+"\n")
+	})
+
+	Convey("The type formats are read from the options", t, func() {
+		formats, err := (&OutputFormatOptions{}).JSONTypeFormats()
+		So(err, ShouldBeNil)
+		So(formats, ShouldBeNil)
+
+		formats, err = (&OutputFormatOptions{JSONTypes: []string{"date=epoch", "binary=base64", "date=iso"}}).JSONTypeFormats()
+		So(err, ShouldBeNil)
+		So(formats, ShouldResemble, JSONTypeFormats{jsonTypeDate: jsonFormatISO, jsonTypeBinary: jsonFormatBase64})
+
+		for _, spec := range []string{"date", "uuid=hex", "objectId=iso"} {
+			_, err = (&OutputFormatOptions{JSONTypes: []string{spec}}).JSONTypeFormats()
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
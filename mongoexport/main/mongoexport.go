@@ -8,11 +8,13 @@
 package main
 
 import (
+	"fmt"
+	"io"
 	"os"
 
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/signals"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/signals"
+	"github.com/mongodb/mongo-tools/common/util"
 	"github.com/mongodb/mongo-tools/mongoexport"
 )
 
@@ -26,8 +28,10 @@ func main() {
 	if err != nil {
 		log.Logvf(log.Always, "error parsing command line options: %v", err)
 		log.Logvf(log.Always, util.ShortUsage("mongoexport"))
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitBadOptions)
 	}
+	defer util.Shutdown()
+	defer util.RecoverCrash()
 
 	signals.Handle()
 
@@ -49,25 +53,32 @@ func main() {
 			log.Logv(log.Always, se.Message)
 		}
 
-		os.Exit(util.ExitFailure)
+		util.Exit(util.FailureExitCode(err))
 	}
 	defer exporter.Close()
 
-	writer, err := exporter.GetOutputWriter()
-	if err != nil {
-		log.Logvf(log.Always, "error opening output stream: %v", err)
-		os.Exit(util.ExitFailure)
-	}
-	if writer == nil {
-		writer = os.Stdout
+	var numDocs int64
+	if exporter.Partitioned() {
+		numDocs, err = exporter.ExportPartitions()
 	} else {
-		defer writer.Close()
+		var writer io.WriteCloser
+		writer, err = exporter.GetOutputWriter()
+		if err != nil {
+			log.Logvf(log.Always, "error opening output stream: %v", err)
+			util.Exit(util.ExitFailure)
+		}
+		if writer == nil {
+			numDocs, err = exporter.Export(os.Stdout)
+		} else {
+			numDocs, err = exporter.Export(writer)
+			// an uploaded object is only complete once it is closed
+			if closeErr := writer.Close(); err == nil && closeErr != nil {
+				err = fmt.Errorf("error closing output: %v", closeErr)
+			}
+		}
 	}
-
-	numDocs, err := exporter.Export(writer)
 	if err != nil {
-		log.Logvf(log.Always, "Failed: %v", err)
-		os.Exit(util.ExitFailure)
+		util.Exit(util.LogFailure(err))
 	}
 
 	if numDocs == 1 {
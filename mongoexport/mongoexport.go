@@ -15,13 +15,14 @@ import (
 	"strings"
 	"time"
 
-	"github.com/mongodb/mongo-tools-common/bsonutil"
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/json"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/options"
-	"github.com/mongodb/mongo-tools-common/progress"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	mopt "go.mongodb.org/mongo-driver/mongo/options"
@@ -43,6 +44,8 @@ const (
 	Canonical JSONFormat = "canonical"
 	// Relaxed indicates relaxed json format
 	Relaxed JSONFormat = "relaxed"
+	// Legacy indicates the legacy extended json format of mongoexport before 4.2
+	Legacy JSONFormat = "legacy"
 )
 
 const (
@@ -69,6 +72,17 @@ type MongoExport struct {
 
 	// Cached version of the collection info
 	collInfo *db.CollectionInfo
+
+	// codec compresses the output with --compress
+	codec compression.Codec
+	// csvDialect is the dialect of CSV output
+	csvDialect CSVDialect
+	// flattener flattens the documents of CSV output with --flatten
+	flattener *flattener
+	// jsonTypeFormats are the plain JSON formats of types set by --jsonType
+	jsonTypeFormats JSONTypeFormats
+	// checkpoint records the checkpoints of the export to --out with --resume
+	checkpoint *checkpointer
 }
 
 // ExportOutput is an interface that specifies how a document should be formatted
@@ -157,6 +171,9 @@ func (exp *MongoExport) validateSettings() error {
 	if exp.ToolOptions.Namespace.Collection == "" {
 		return fmt.Errorf("must specify a collection")
 	}
+	if exp.OutputOpts.OutputFile == "" && exp.ToolOptions.LogsToStdout() {
+		return fmt.Errorf("--logSplitStreams and --progressJson=- require --out, since exported documents are written to stdout by default")
+	}
 	if err = util.ValidateCollectionGrammar(exp.ToolOptions.Namespace.Collection); err != nil {
 		return err
 	}
@@ -176,8 +193,31 @@ func (exp *MongoExport) validateSettings() error {
 		return fmt.Errorf("invalid output type '%v', choose 'json' or 'csv'", exp.OutputOpts.Type)
 	}
 
-	if exp.OutputOpts.JSONFormat != Canonical && exp.OutputOpts.JSONFormat != Relaxed {
-		return fmt.Errorf("invalid JSON format '%v', choose 'relaxed' or 'canonical'", exp.OutputOpts.JSONFormat)
+	if exp.OutputOpts.JSONFormat != Canonical && exp.OutputOpts.JSONFormat != Relaxed && exp.OutputOpts.JSONFormat != Legacy {
+		return fmt.Errorf("invalid JSON format '%v', choose 'relaxed', 'canonical' or 'legacy'", exp.OutputOpts.JSONFormat)
+	}
+	if exp.jsonTypeFormats, err = exp.OutputOpts.JSONTypeFormats(); err != nil {
+		return err
+	}
+	if exp.jsonTypeFormats != nil && exp.OutputOpts.Type != JSON {
+		return fmt.Errorf("--jsonType requires --type=json")
+	}
+
+	if exp.codec, err = exp.OutputOpts.Codec(); err != nil {
+		return err
+	}
+
+	if exp.OutputOpts.hasCSVDialect() && exp.OutputOpts.Type != CSV {
+		return fmt.Errorf("--csvDelimiter, --csvQuote, --csvEscape, --crlf and --nullString require --type=csv")
+	}
+	if exp.csvDialect, err = exp.OutputOpts.CSVDialect(); err != nil {
+		return err
+	}
+	if exp.flattener, err = exp.OutputOpts.newFlattener(); err != nil {
+		return err
+	}
+	if exp.flattener != nil && exp.OutputOpts.Type != CSV {
+		return fmt.Errorf("--flatten requires --type=csv")
 	}
 
 	if exp.InputOpts.Query != "" && exp.InputOpts.ForceTableScan {
@@ -205,31 +245,47 @@ func (exp *MongoExport) validateSettings() error {
 			return err
 		}
 	}
-	return nil
+	if err = exp.validatePartitions(); err != nil {
+		return err
+	}
+	return exp.validateResume()
 }
 
 // GetOutputWriter opens and returns an io.WriteCloser for the output
-// options or nil if none is set. The caller is responsible for closing it.
+// options or nil if none is set and the output isn't compressed. The caller
+// is responsible for closing it.
 func (exp *MongoExport) GetOutputWriter() (io.WriteCloser, error) {
-	if exp.OutputOpts.OutputFile != "" {
-		// If the directory in which the output file is to be
-		// written does not exist, create it
-		fileDir := filepath.Dir(exp.OutputOpts.OutputFile)
-		err := os.MkdirAll(fileDir, 0750)
+	if exp.OutputOpts.Resume {
+		sortKey, err := exp.resumeSortKey()
 		if err != nil {
 			return nil, err
 		}
-
-		file, err := os.Create(util.ToUniversalPath(exp.OutputOpts.OutputFile))
-		if err != nil {
+		if exp.checkpoint, err = openCheckpointed(exp.OutputOpts.OutputFile, sortKey); err != nil {
 			return nil, err
 		}
-		return file, err
+		return exp.checkpoint.file, nil
+	}
+	if exp.OutputOpts.OutputFile != "" {
+		return exp.createOutput(exp.OutputOpts.OutputFile)
+	}
+	if !exp.codec.IsNone() {
+		return newCompressedOutput(exp.codec, os.Stdout, nil)
 	}
 	// No writer, so caller should assume Stdout (or some other reasonable default)
 	return nil, nil
 }
 
+// createOutputFile creates the file at path, and the directory it is to be
+// written in if it does not exist.
+func createOutputFile(path string) (*os.File, error) {
+	fileDir := filepath.Dir(path)
+	err := os.MkdirAll(fileDir, 0750)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(util.ToUniversalPath(path))
+}
+
 // Take a comma-delimited set of field names and build a selector doc for query projection.
 // For fields containing a dot '.', we project the entire top-level portion.
 // e.g. "a,b,c.d.e,f.$" -> {a:1, b:1, "c":1, "f.$": 1}.
@@ -297,6 +353,13 @@ func (exp *MongoExport) getCount() (int64, error) {
 // to export, based on the options given to mongoexport. Also returns the
 // associated session, so that it can be closed once the cursor is used up.
 func (exp *MongoExport) getCursor() (*mongo.Cursor, error) {
+	return exp.findDocuments(nil, exp.checkpoint)
+}
+
+// findDocuments returns a cursor over the documents to export, only those
+// of part if it is set, and only those after the last checkpoint, in the
+// order of its sort key, if checkpoint is set.
+func (exp *MongoExport) findDocuments(part *partition, checkpoint *checkpointer) (*mongo.Cursor, error) {
 	findOpts := mopt.Find()
 
 	if exp.InputOpts != nil && exp.InputOpts.Sort != "" {
@@ -349,7 +412,17 @@ func (exp *MongoExport) getCursor() (*mongo.Cursor, error) {
 	// we want to hint _id if shouldHintId is true, and there is no query, and
 	// there is no sorting, as hinting is not needed if there is a query or sorting.
 	// we also do not want to hint for system collections or views.
-	if shouldHintId && len(query) == 0 && noSorting &&
+	if part != nil {
+		// the bounds of the partition are those of its index, which unlike
+		// those of a range query include the values of every BSON type
+		findOpts.SetHint(part.key)
+		if part.min != nil {
+			findOpts.SetMin(part.min)
+		}
+		if part.max != nil {
+			findOpts.SetMax(part.max)
+		}
+	} else if shouldHintId && len(query) == 0 && noSorting &&
 		!exp.collInfo.IsView() && !exp.collInfo.IsSystemCollection() {
 
 		// Don't hint autoIndexId:false collections
@@ -358,21 +431,45 @@ func (exp *MongoExport) getCursor() (*mongo.Cursor, error) {
 			findOpts.SetHint(bson.D{{"_id", 1}})
 		}
 	}
+	if checkpoint != nil {
+		// the documents are exported in the order of an index, so that the
+		// export can continue from the index key of the last one
+		findOpts.SetHint(checkpoint.sortKey)
+		findOpts.SetSort(checkpoint.sortKey)
+		if checkpoint.resumed() {
+			findOpts.SetMin(checkpoint.state.Key)
+		}
+	}
 
 	if exp.InputOpts != nil {
 		findOpts.SetSkip(exp.InputOpts.Skip)
 	}
+	if checkpoint.resumed() {
+		// skip the documents of the last key already exported
+		findOpts.SetSkip(checkpoint.state.Ties)
+	}
 	if exp.InputOpts != nil {
 		findOpts.SetLimit(exp.InputOpts.Limit)
 	}
 
-	if len(exp.OutputOpts.Fields) > 0 {
-		findOpts.SetProjection(makeFieldSelector(exp.OutputOpts.Fields))
+	if projection := exp.projection(); projection != nil {
+		findOpts.SetProjection(projection)
 	}
 
 	return coll.Find(nil, query, findOpts)
 }
 
+// projection returns the projection of the fields to export, or nil if all
+// of them are.
+func (exp *MongoExport) projection() bson.M {
+	// the top-level fields of flattened fields can only be projected if
+	// they are separated by dots
+	if len(exp.OutputOpts.Fields) > 0 && (exp.flattener == nil || exp.flattener.separator == defaultFlattenSeparator) {
+		return makeFieldSelector(exp.OutputOpts.Fields)
+	}
+	return nil
+}
+
 // verifyCollectionExists checks if the collection exists. If it does, a copy of the collection info will be cached
 // on the receiver. If the collection does not exist and AssertExists was specified, a non-nil error is returned.
 func (exp *MongoExport) verifyCollectionExists() (bool, error) {
@@ -408,6 +505,18 @@ func (exp *MongoExport) exportInternal(out io.Writer) (int64, error) {
 	if err != nil || !exists {
 		return 0, err
 	}
+	if exp.checkpoint.done() {
+		log.Logvf(log.Always, "the export to %v was already complete", exp.OutputOpts.OutputFile)
+		return 0, exp.checkpoint.remove()
+	}
+	if exp.checkpoint != nil {
+		if exp.collInfo.IsView() {
+			return 0, fmt.Errorf("cannot use --resume to export view '%v'", exp.ToolOptions.Namespace)
+		}
+		if err = exp.checkResumeKey(exp.checkpoint.sortKey); err != nil {
+			return 0, err
+		}
+	}
 
 	max, err := exp.getCount()
 	if err != nil {
@@ -426,19 +535,36 @@ func (exp *MongoExport) exportInternal(out io.Writer) (int64, error) {
 		return 0, err
 	}
 
+	exp.checkpoint.resume(exportOutput)
+
 	cursor, err := exp.getCursor()
 	if err != nil {
 		return 0, err
 	}
 	defer cursor.Close(nil)
-
-	// Write headers
-	err = exportOutput.WriteHeader()
+	count, err := writeDocuments(cursor, exportOutput, watchProgressor, exp.checkpoint)
 	if err != nil {
-		return 0, err
+		return count, err
+	}
+	return count, exp.checkpoint.remove()
+}
+
+// writeDocuments writes the documents of the cursor to exportOutput, with
+// its headers and footers, adding them to watchProgressor, and recording
+// them in checkpoint if it is set.
+func writeDocuments(cursor *mongo.Cursor, exportOutput ExportOutput, watchProgressor *progress.CountProgressor, checkpoint *checkpointer) (int64, error) {
+	// Write headers, unless they were written before the checkpoint
+	if checkpoint.count() == 0 {
+		if err := exportOutput.WriteHeader(); err != nil {
+			return 0, err
+		}
 	}
+	watchProgressor.Inc(checkpoint.count())
 
 	docsCount := int64(0)
+	// the progressor may be shared by the partitions of a collection, so
+	// only the documents exported since the last update are added
+	reported := int64(0)
 
 	// Write document content
 	for cursor.Next(nil) {
@@ -447,34 +573,43 @@ func (exp *MongoExport) exportInternal(out io.Writer) (int64, error) {
 			return docsCount, err
 		}
 
-		err := exportOutput.ExportDocument(result)
+		// the key is read first, as exporting may convert the document
+		key, err := checkpoint.keyOf(result)
 		if err != nil {
 			return docsCount, err
 		}
+		if err = exportOutput.ExportDocument(result); err != nil {
+			return docsCount, err
+		}
+		if err = checkpoint.update(key); err != nil {
+			return docsCount, err
+		}
 		docsCount++
 		if docsCount%watchProgressorUpdateFrequency == 0 {
-			watchProgressor.Set(docsCount)
+			watchProgressor.Inc(docsCount - reported)
+			reported = docsCount
 		}
 	}
-	watchProgressor.Set(docsCount)
+	watchProgressor.Inc(docsCount - reported)
 	if err := cursor.Err(); err != nil {
 		return docsCount, err
 	}
 
 	// Write footers
-	err = exportOutput.WriteFooter()
-	if err != nil {
+	if err := exportOutput.WriteFooter(); err != nil {
 		return docsCount, err
 	}
 	exportOutput.Flush()
-	return docsCount, nil
+	return docsCount, checkpoint.finish()
 }
 
 // Export executes the entire export operation. It returns an integer of the count
 // of documents successfully exported, and a non-nil error if something went wrong
 // during the export operation.
 func (exp *MongoExport) Export(out io.Writer) (int64, error) {
+	start := time.Now()
 	count, err := exp.exportInternal(out)
+	log.Summary().AddNamespace(exp.ToolOptions.Namespace.String(), count, 0, time.Since(start), err)
 	return count, err
 }
 
@@ -493,7 +628,7 @@ func (exp *MongoExport) getExportOutput(out io.Writer) (ExportOutput, error) {
 			if err != nil {
 				return nil, err
 			}
-		} else {
+		} else if exp.flattener == nil {
 			return nil, fmt.Errorf("CSV mode requires a field list")
 		}
 
@@ -507,9 +642,18 @@ func (exp *MongoExport) getExportOutput(out io.Writer) (ExportOutput, error) {
 			}
 		}
 
-		return NewCSVExportOutput(exportFields, exp.OutputOpts.NoHeaderLine, out), nil
+		dialect := exp.csvDialect
+		if dialect.Delimiter == 0 {
+			dialect = DefaultCSVDialect
+		}
+		if exp.flattener != nil {
+			return NewCSVFlattenedExportOutput(exportFields, exp.OutputOpts.NoHeaderLine, dialect, exp.flattener, out), nil
+		}
+		return NewCSVDialectExportOutput(exportFields, exp.OutputOpts.NoHeaderLine, dialect, out), nil
 	}
-	return NewJSONExportOutput(exp.OutputOpts.JSONArray, exp.OutputOpts.Pretty, out, exp.OutputOpts.JSONFormat), nil
+	jsonExporter := NewJSONExportOutput(exp.OutputOpts.JSONArray, exp.OutputOpts.Pretty, out, exp.OutputOpts.JSONFormat)
+	jsonExporter.TypeFormats = exp.jsonTypeFormats
+	return jsonExporter, nil
 }
 
 // getObjectFromByteArg takes an object in extended JSON, and converts it to an object that
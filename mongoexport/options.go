@@ -10,9 +10,10 @@ import (
 	"fmt"
 	"io/ioutil"
 
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/options"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
 )
 
 var Usage = `<options> <connection-string>
@@ -38,7 +39,7 @@ type OutputFormatOptions struct {
 	CSVOutputType bool `long:"csv" hidden:"true"`
 
 	// OutputFile specifies an output file path.
-	OutputFile string `long:"out" value-name:"<filename>" short:"o" description:"output file; if not specified, stdout is used"`
+	OutputFile string `long:"out" value-name:"<filename>" short:"o" description:"output file, or an s3://, gs:// or azblob:// URL of an object to upload it to, or an http(s):// URL to stream it to with a PUT request; if not specified, stdout is used"`
 
 	// JSONArray if set will export the documents an array of JSON documents.
 	JSONArray bool `long:"jsonArray" description:"output to a JSON array rather than one object per line"`
@@ -49,8 +50,48 @@ type OutputFormatOptions struct {
 	// NoHeaderLine, if set, will export CSV data without a list of field names at the first line.
 	NoHeaderLine bool `long:"noHeaderLine" description:"export CSV data without a list of field names at the first line"`
 
-	// JSONFormat specifies what extended JSON format to export (canonical or relaxed). Defaults to relaxed.
-	JSONFormat JSONFormat `long:"jsonFormat" value-name:"<type>" default:"relaxed" description:"the extended JSON format to output, either canonical or relaxed (defaults to 'relaxed')"`
+	// Flatten exports the fields of nested documents and arrays as CSV columns of their own.
+	Flatten          bool   `long:"flatten" description:"export each field of the nested documents and arrays of CSV output as a column of its own, named by its keys joined with --flattenSeparator, e.g. 'address.city'; without --fields, the columns are the fields of the first document"`
+	FlattenSeparator string `long:"flattenSeparator" value-name:"<string>" description:"with --flatten, the string the keys of nested fields are joined with (defaults to '.')"`
+	FlattenArrays    string `long:"flattenArrays" choice:"index" choice:"explode" choice:"json" description:"with --flatten, how arrays are exported. index: a column for each element, named by its index, e.g. 'tags.0'. explode: a row for each element, named like the array, repeating the other fields, or for each combination of the elements of several arrays. json: a single column encoded as JSON. (defaults to 'index')"`
+
+	// CSVDelimiter, CSVQuote and CSVEscape are the characters that delimit, quote and escape CSV fields.
+	CSVDelimiter string `long:"csvDelimiter" value-name:"<char>" description:"the character that separates CSV fields, e.g. '|' or '\\t' for a tab (defaults to ',')"`
+	CSVQuote     string `long:"csvQuote" value-name:"<char>" description:"the character that CSV fields containing delimiters, quotes or line breaks are quoted with (defaults to '\"')"`
+	CSVEscape    string `long:"csvEscape" value-name:"<char>" description:"the character that escapes quotes and itself in quoted CSV fields, e.g. '\\' (defaults to the quote character, which doubles quotes)"`
+
+	// CRLF ends CSV lines with \r\n.
+	CRLF bool `long:"crlf" description:"end CSV lines, and the line breaks of quoted fields, with \\r\\n rather than \\n"`
+
+	// NullString is written for null and missing CSV fields.
+	NullString string `long:"nullString" value-name:"<string>" description:"the string written for null values and missing fields in CSV, e.g. '\\N'; fields equal to it are quoted (defaults to an empty field)"`
+
+	// JSONFormat specifies what extended JSON format to export (canonical, relaxed or legacy). Defaults to relaxed.
+	JSONFormat JSONFormat `long:"jsonFormat" value-name:"<type>" default:"relaxed" description:"the extended JSON format to output, either canonical, relaxed or legacy, the format of mongoexport before 4.2 (defaults to 'relaxed')"`
+
+	// JSONTypes export the values of some BSON types as plain JSON rather than extended JSON.
+	JSONTypes []string `long:"jsonType" value-name:"<type>=<format>" description:"export values of a type as plain JSON rather than extended JSON: date=iso or date=epoch for ISO-8601 strings or milliseconds since the epoch, objectId=hex, long=string, decimal=string or binary=base64; may be repeated"`
+
+	// Compress is the codec, and optionally its level, to compress the output with.
+	Compress string `long:"compress" value-name:"<codec>[:level]" description:"compress the output with gzip, zstd or lz4, optionally at the given gzip (1-9) or zstd (1-22) level, adding .gz, .zst or .lz4 to the name of --out unless it already ends with it"`
+
+	// ConcatPartitions joins the part files of --numParallelPartitions into the output file.
+	ConcatPartitions bool `long:"concatPartitions" description:"with --numParallelPartitions, join the part files into the --out file once they are all exported, removing them"`
+
+	// Resume records checkpoints of the export, from which a failed export continues when run again.
+	Resume bool `long:"resume" description:"record checkpoints of the export next to --out, and of each part file with --numParallelPartitions, so that a failed export run again with --resume continues from the last one, rather than starting over. Documents are exported in the order of an index on --sort, which must exist, or else _id, or the partition key"`
+}
+
+// Codec returns the compression given by --compress.
+func (outputOptions *OutputFormatOptions) Codec() (compression.Codec, error) {
+	if outputOptions.Compress == "" {
+		return compression.None, nil
+	}
+	codec, err := compression.Parse(outputOptions.Compress)
+	if err != nil {
+		return compression.None, fmt.Errorf("invalid --compress: %v", err)
+	}
+	return codec, nil
 }
 
 // Name returns a human-readable group name for output format options.
@@ -60,15 +101,16 @@ func (*OutputFormatOptions) Name() string {
 
 // InputOptions defines the set of options to use in retrieving data from the server.
 type InputOptions struct {
-	Query          string `long:"query" value-name:"<json>" short:"q" description:"query filter, as a JSON string, e.g., '{x:{$gt:1}}'"`
-	QueryFile      string `long:"queryFile" value-name:"<filename>" description:"path to a file containing a query filter (JSON)"`
-	SlaveOk        bool   `long:"slaveOk" short:"k" description:"allow secondary reads if available" default-mask:"-"`
-	ReadPreference string `long:"readPreference" value-name:"<string>|<json>" description:"specify either a preference mode (e.g. 'nearest') or a preference json object (e.g. '{mode: \"nearest\", tagSets: [{a: \"b\"}], maxStalenessSeconds: 123}')"`
-	ForceTableScan bool   `long:"forceTableScan" description:"force a table scan (do not use $snapshot or hint _id). Deprecated since this is default behavior on WiredTiger"`
-	Skip           int64  `long:"skip" value-name:"<count>" description:"number of documents to skip"`
-	Limit          int64  `long:"limit" value-name:"<count>" description:"limit the number of documents to export"`
-	Sort           string `long:"sort" value-name:"<json>" description:"sort order, as a JSON string, e.g. '{x:1}'"`
-	AssertExists   bool   `long:"assertExists" description:"if specified, export fails if the collection does not exist"`
+	Query                 string `long:"query" value-name:"<json>" short:"q" description:"query filter, as a JSON string, e.g., '{x:{$gt:1}}'"`
+	QueryFile             string `long:"queryFile" value-name:"<filename>" description:"path to a file containing a query filter (JSON)"`
+	SlaveOk               bool   `long:"slaveOk" short:"k" description:"allow secondary reads if available" default-mask:"-"`
+	ReadPreference        string `long:"readPreference" value-name:"<string>|<json>" description:"specify either a preference mode (e.g. 'nearest') or a preference json object (e.g. '{mode: \"nearest\", tagSets: [{a: \"b\"}], maxStalenessSeconds: 123}')"`
+	ForceTableScan        bool   `long:"forceTableScan" description:"force a table scan (do not use $snapshot or hint _id). Deprecated since this is default behavior on WiredTiger"`
+	Skip                  int64  `long:"skip" value-name:"<count>" description:"number of documents to skip"`
+	Limit                 int64  `long:"limit" value-name:"<count>" description:"limit the number of documents to export"`
+	Sort                  string `long:"sort" value-name:"<json>" description:"sort order, as a JSON string, e.g. '{x:1}'"`
+	AssertExists          bool   `long:"assertExists" description:"if specified, export fails if the collection does not exist"`
+	NumParallelPartitions int    `long:"numParallelPartitions" value-name:"<n>" description:"split the collection into up to this many ranges of its shard key, if it is sharded on a ranged key, or else of _id, and export them at once, each to a part file named after --out with its number, e.g. out.json.0001"`
 }
 
 // Name returns a human-readable group name for input options.
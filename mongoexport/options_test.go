@@ -10,8 +10,8 @@ import (
 	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
 	"testing"
 
-	"github.com/mongodb/mongo-tools-common/options"
-	"github.com/mongodb/mongo-tools-common/testtype"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testtype"
 	. "github.com/smartystreets/goconvey/convey"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
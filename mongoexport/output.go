@@ -0,0 +1,81 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"io"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/storage"
+)
+
+// compressedOutput compresses what is written to it with --compress,
+// closing the file it writes to, if any, once the stream is flushed.
+type compressedOutput struct {
+	compression.Writer
+	file   io.Closer
+	closed bool
+}
+
+func newCompressedOutput(codec compression.Codec, w io.Writer, file io.Closer) (io.WriteCloser, error) {
+	writer, err := codec.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	return &compressedOutput{Writer: writer, file: file}, nil
+}
+
+// Close flushes the compressed stream, then closes the file. Closing it
+// again does nothing.
+func (out *compressedOutput) Close() error {
+	if out.closed {
+		return nil
+	}
+	out.closed = true
+	err := out.Writer.Close()
+	if out.file != nil {
+		if closeErr := out.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// outputName returns the name of an output file, ending with the extension
+// of --compress.
+func (exp *MongoExport) outputName(name string) string {
+	if strings.HasSuffix(name, exp.codec.Extension()) {
+		return name
+	}
+	return name + exp.codec.Extension()
+}
+
+// createOutput creates the output file named after path, or the object if
+// it is in object storage or at an HTTP endpoint, compressing what is
+// written to it with --compress.
+func (exp *MongoExport) createOutput(path string) (io.WriteCloser, error) {
+	var file io.WriteCloser
+	var err error
+	if storage.IsRemote(path) {
+		file, err = storage.Create(exp.outputName(path))
+	} else {
+		file, err = createOutputFile(exp.outputName(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if exp.codec.IsNone() {
+		return file, nil
+	}
+	out, err := newCompressedOutput(exp.codec, file, file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return out, nil
+}
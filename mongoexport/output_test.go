@@ -0,0 +1,145 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCompressedOutput(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("--compress takes a codec and an optional level", t, func() {
+		codec, err := (&OutputFormatOptions{Compress: "zstd:3"}).Codec()
+		So(err, ShouldBeNil)
+		So(codec, ShouldResemble, compression.Codec{Name: compression.ZstdName, Level: 3})
+		codec, err = (&OutputFormatOptions{}).Codec()
+		So(err, ShouldBeNil)
+		So(codec.IsNone(), ShouldBeTrue)
+		_, err = (&OutputFormatOptions{Compress: "gzip:12"}).Codec()
+		So(err, ShouldNotBeNil)
+		_, err = (&OutputFormatOptions{Compress: "bzip2"}).Codec()
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("The output file is named with the extension of the codec", t, func() {
+		exp := &MongoExport{OutputOpts: &OutputFormatOptions{OutputFile: "out.json.gz"}, codec: compression.Gzip}
+		So(exp.outputName("out.json"), ShouldEqual, "out.json.gz")
+		So(exp.outputName("out.json.gz"), ShouldEqual, "out.json.gz")
+		// each part file is compressed on its own
+		So(exp.outputName(partFileName(exp.partsName(), 0)), ShouldEqual, "out.json.0001.gz")
+
+		exp.codec = compression.None
+		So(exp.outputName("out.json"), ShouldEqual, "out.json")
+	})
+
+	for _, name := range []string{compression.GzipName, compression.ZstdName} {
+		codec := compression.Codec{Name: name}
+		Convey("Documents are exported compressed with "+name, t, func() {
+			dir, err := ioutil.TempDir("", "mongoexport-compress")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "out.json")
+			exp := &MongoExport{OutputOpts: &OutputFormatOptions{OutputFile: path}, codec: codec}
+			out, err := exp.GetOutputWriter()
+			So(err, ShouldBeNil)
+			jsonOutput := NewJSONExportOutput(false, false, out, Relaxed)
+			So(jsonOutput.ExportDocument(bson.D{{"_id", int32(1)}}), ShouldBeNil)
+			So(jsonOutput.Flush(), ShouldBeNil)
+			So(out.Close(), ShouldBeNil)
+			So(out.Close(), ShouldBeNil)
+
+			file, err := os.Open(path + codec.Extension())
+			So(err, ShouldBeNil)
+			defer file.Close()
+			in, err := codec.NewReader(file)
+			So(err, ShouldBeNil)
+			defer in.Close()
+			data, err := ioutil.ReadAll(in)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "{\"_id\":1}\n")
+		})
+	}
+}
+
+func TestRemoteOutput(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	type upload struct {
+		path    string
+		user    string
+		encoded []string
+		body    []byte
+	}
+	uploads := make(chan upload, 1)
+	status := http.StatusCreated
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		body, _ := ioutil.ReadAll(r.Body)
+		uploads <- upload{path: r.URL.Path, user: user, encoded: r.TransferEncoding, body: body}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	location := "http://exporter:secret@" + server.Listener.Addr().String() + "/landing/orders.json"
+
+	Convey("The output is streamed to an HTTP endpoint with a PUT request", t, func() {
+		status = http.StatusCreated
+		exp := &MongoExport{OutputOpts: &OutputFormatOptions{OutputFile: location}, codec: compression.Gzip}
+		out, err := exp.GetOutputWriter()
+		So(err, ShouldBeNil)
+		jsonOutput := NewJSONExportOutput(false, false, out, Relaxed)
+		So(jsonOutput.ExportDocument(bson.D{{"_id", int32(1)}}), ShouldBeNil)
+		So(jsonOutput.Flush(), ShouldBeNil)
+		So(out.Close(), ShouldBeNil)
+
+		received := <-uploads
+		So(received.path, ShouldEqual, "/landing/orders.json.gz")
+		So(received.user, ShouldEqual, "exporter")
+		So(received.encoded, ShouldResemble, []string{"chunked"})
+		in, err := gzip.NewReader(bytes.NewReader(received.body))
+		So(err, ShouldBeNil)
+		data, err := ioutil.ReadAll(in)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "{\"_id\":1}\n")
+	})
+
+	Convey("An upload that the endpoint rejects fails once the output is closed", t, func() {
+		status = http.StatusForbidden
+		exp := &MongoExport{OutputOpts: &OutputFormatOptions{OutputFile: location}}
+		out, err := exp.GetOutputWriter()
+		So(err, ShouldBeNil)
+		out.Write([]byte("{}\n"))
+		err = out.Close()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "Forbidden")
+		So(err.Error(), ShouldNotContainSubstring, "secret")
+		<-uploads
+	})
+
+	Convey("Part files in object storage can't be joined", t, func() {
+		exp := &MongoExport{
+			InputOpts:  &InputOptions{NumParallelPartitions: 2},
+			OutputOpts: &OutputFormatOptions{OutputFile: "s3://bucket/orders.json", ConcatPartitions: true},
+		}
+		So(exp.validatePartitions(), ShouldNotBeNil)
+		exp.OutputOpts.ConcatPartitions = false
+		So(exp.validatePartitions(), ShouldBeNil)
+	})
+}
@@ -0,0 +1,359 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopt "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// samplesPerPartition is how many _id values are sampled for each partition
+// of --numParallelPartitions to split the collection evenly.
+const samplesPerPartition = 100
+
+// partition is a range of an index of the collection, exported by
+// --numParallelPartitions to its own part file. min is inclusive and max
+// exclusive; the first partition has no min and the last no max.
+type partition struct {
+	key bson.D
+	min bson.D
+	max bson.D
+}
+
+// configCollection is the config.collections entry of a sharded collection.
+type configCollection struct {
+	ID      string            `bson:"_id"`
+	UUID    *primitive.Binary `bson:"uuid"`
+	Key     bson.D            `bson:"key"`
+	Dropped bool              `bson:"dropped"`
+}
+
+// validatePartitions validates --numParallelPartitions and
+// --concatPartitions.
+func (exp *MongoExport) validatePartitions() error {
+	if exp.InputOpts == nil {
+		return nil
+	}
+	switch n := exp.InputOpts.NumParallelPartitions; {
+	case n < 0:
+		return fmt.Errorf("--numParallelPartitions must be positive")
+	case n <= 1:
+		if exp.OutputOpts.ConcatPartitions {
+			return fmt.Errorf("cannot use --concatPartitions without --numParallelPartitions")
+		}
+	case exp.OutputOpts.OutputFile == "":
+		return fmt.Errorf("--numParallelPartitions requires --out, which the part files are named after")
+	case exp.InputOpts.Sort != "" || exp.InputOpts.Skip != 0 || exp.InputOpts.Limit != 0:
+		return fmt.Errorf("cannot use --numParallelPartitions with --sort, --skip or --limit")
+	case exp.OutputOpts.JSONArray && exp.OutputOpts.ConcatPartitions:
+		return fmt.Errorf("cannot use --concatPartitions with --jsonArray")
+	case exp.OutputOpts.Flatten && exp.OutputOpts.Fields == "" && exp.OutputOpts.FieldFile == "":
+		return fmt.Errorf("--numParallelPartitions requires --fields with --flatten, " +
+			"since each part would otherwise take its columns from its first document")
+	case storage.IsRemote(exp.OutputOpts.OutputFile) && exp.OutputOpts.ConcatPartitions:
+		return fmt.Errorf("cannot use --concatPartitions with --out in object storage or at an HTTP endpoint, " +
+			"whose part files can't be joined")
+	}
+	return nil
+}
+
+// Partitioned returns whether the collection is exported in partitions
+// with ExportPartitions.
+func (exp *MongoExport) Partitioned() bool {
+	return exp.InputOpts != nil && exp.InputOpts.NumParallelPartitions > 1
+}
+
+// ExportPartitions exports the collection with --numParallelPartitions,
+// splitting it into ranges of its shard key or _id that are exported at
+// once, each to a part file named after --out, which --concatPartitions
+// joins into --out. It returns the number of documents exported.
+func (exp *MongoExport) ExportPartitions() (int64, error) {
+	start := time.Now()
+	count, err := exp.exportPartitions()
+	log.Summary().AddNamespace(exp.ToolOptions.Namespace.String(), count, 0, time.Since(start), err)
+	return count, err
+}
+
+func (exp *MongoExport) exportPartitions() (int64, error) {
+	exists, err := exp.verifyCollectionExists()
+	if err != nil || !exists {
+		return 0, err
+	}
+	name := exp.ToolOptions.Namespace.String()
+	if exp.collInfo.IsView() {
+		return 0, fmt.Errorf("cannot split view '%v' into partitions", name)
+	}
+	partitions, err := exp.resumePartitions()
+	if err != nil {
+		return 0, fmt.Errorf("error splitting %v into partitions: %v", name, err)
+	}
+
+	max, err := exp.getCount()
+	if err != nil {
+		return 0, err
+	}
+	watchProgressor := progress.NewCounter(max)
+	if exp.ProgressManager != nil {
+		exp.ProgressManager.Attach(name, watchProgressor)
+		defer exp.ProgressManager.Detach(name)
+	}
+
+	log.Logvf(log.Always, "exporting %v in %v %v", name, len(partitions),
+		util.Pluralize(len(partitions), "partition", "partitions"))
+	parts := make([]string, len(partitions))
+	counts := make([]int64, len(partitions))
+	errs := make([]error, len(partitions))
+	var wg sync.WaitGroup
+	for i := range partitions {
+		parts[i] = exp.outputName(partFileName(exp.partsName(), i))
+		wg.Add(1)
+		go func(i int) {
+			defer util.RecoverCrash()
+			defer wg.Done()
+			// the header of a CSV export is only written once, to the first
+			// part, if the parts are to be joined
+			header := i == 0 || !exp.OutputOpts.ConcatPartitions
+			counts[i], errs[i] = exp.exportPartition(partitions[i], parts[i], header, watchProgressor)
+		}(i)
+	}
+	wg.Wait()
+
+	var total int64
+	for i := range partitions {
+		total += counts[i]
+		if errs[i] != nil {
+			return total, fmt.Errorf("error exporting %v: %v", parts[i], errs[i])
+		}
+	}
+	if exp.OutputOpts.ConcatPartitions {
+		if err = concatParts(exp.outputName(exp.OutputOpts.OutputFile), parts); err != nil {
+			return total, err
+		}
+	}
+	return total, exp.removePartitionCheckpoints(parts)
+}
+
+// exportPartition exports the documents of part to the file at path.
+func (exp *MongoExport) exportPartition(part partition, path string, header bool, watchProgressor *progress.CountProgressor) (int64, error) {
+	var file io.WriteCloser
+	var checkpoint *checkpointer
+	var err error
+	if exp.OutputOpts.Resume {
+		if err = exp.checkResumeKey(part.key); err != nil {
+			return 0, err
+		}
+		if checkpoint, err = openCheckpointed(path, part.key); err != nil {
+			return 0, err
+		}
+		file = checkpoint.file
+	} else if file, err = exp.createOutput(path); err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if checkpoint.done() {
+		log.Logvf(log.Info, "the export to %v was already complete", path)
+		watchProgressor.Inc(checkpoint.count())
+		return 0, file.Close()
+	}
+
+	exportOutput, err := exp.getExportOutput(file)
+	if err != nil {
+		return 0, err
+	}
+	if csvOutput, ok := exportOutput.(*CSVExportOutput); ok && !header {
+		csvOutput.NoHeaderLine = true
+	}
+	checkpoint.resume(exportOutput)
+	cursor, err := exp.findDocuments(&part, checkpoint)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(nil)
+	count, err := writeDocuments(cursor, exportOutput, watchProgressor, checkpoint)
+	if err != nil {
+		return count, err
+	}
+	log.Logvf(log.Info, "exported %v %v to %v", count, util.Pluralize(int(count), "record", "records"), path)
+	return count, file.Close()
+}
+
+// getPartitions splits the collection into up to --numParallelPartitions
+// ranges of its shard key, if it is sharded on a ranged key, at the bounds
+// of its chunks, or else of _id, at values sampled from it.
+func (exp *MongoExport) getPartitions() ([]partition, error) {
+	n := exp.InputOpts.NumParallelPartitions
+	key, points, err := exp.shardKeyPoints()
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		key = bson.D{{"_id", 1}}
+		if points, err = exp.sampleIDs(n * samplesPerPartition); err != nil {
+			return nil, err
+		}
+	}
+	return newPartitions(key, evenBounds(points, n)), nil
+}
+
+// shardKeyPoints returns the shard key of the collection and the lower
+// bound of each of its chunks but the first, in order, or a nil key if it
+// isn't sharded on a ranged key.
+func (exp *MongoExport) shardKeyPoints() (bson.D, []bson.D, error) {
+	isMongos, err := exp.SessionProvider.IsMongos()
+	if err != nil || !isMongos {
+		return nil, nil, err
+	}
+	config := exp.SessionProvider.DB("config")
+	ctx := context.Background()
+
+	var coll configCollection
+	err = config.Collection("collections").FindOne(ctx, bson.D{{"_id", exp.ToolOptions.Namespace.String()}}).Decode(&coll)
+	if err == mongo.ErrNoDocuments || (err == nil && coll.Dropped) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("error reading config.collections: %v", err)
+	}
+	for _, field := range coll.Key {
+		if field.Value == "hashed" {
+			// the chunks are ranges of the hashes of the key
+			return nil, nil, nil
+		}
+	}
+
+	filter := bson.D{{"ns", coll.ID}}
+	if coll.UUID != nil {
+		filter = bson.D{{"uuid", *coll.UUID}}
+	}
+	var chunks []struct {
+		Min bson.D `bson:"min"`
+	}
+	cursor, err := config.Collection("chunks").Find(ctx, filter, mopt.Find().SetSort(bson.D{{"min", 1}}))
+	if err == nil {
+		err = cursor.All(ctx, &chunks)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading config.chunks: %v", err)
+	}
+	var points []bson.D
+	for i, chunk := range chunks {
+		if i > 0 {
+			points = append(points, chunk.Min)
+		}
+	}
+	return coll.Key, points, nil
+}
+
+// sampleIDs returns up to size _id values sampled from the collection, in
+// order.
+func (exp *MongoExport) sampleIDs(size int) ([]bson.D, error) {
+	session, err := exp.SessionProvider.GetSession()
+	if err != nil {
+		return nil, err
+	}
+	coll := session.Database(exp.ToolOptions.Namespace.DB).Collection(exp.ToolOptions.Namespace.Collection)
+	ctx := context.Background()
+	pipeline := mongo.Pipeline{
+		{{"$sample", bson.D{{"size", size}}}},
+		{{"$project", bson.D{{"_id", 1}}}},
+		{{"$sort", bson.D{{"_id", 1}}}},
+	}
+	cursor, err := coll.Aggregate(ctx, pipeline, mopt.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, fmt.Errorf("error sampling _id values: %v", err)
+	}
+	var ids []bson.D
+	if err = cursor.All(ctx, &ids); err != nil {
+		return nil, fmt.Errorf("error sampling _id values: %v", err)
+	}
+	return ids, nil
+}
+
+// evenBounds picks the bounds that split the sorted points into up to n
+// ranges of about as many points each, skipping repeated points.
+func evenBounds(points []bson.D, n int) []bson.D {
+	var bounds []bson.D
+	var last []byte
+	for i := 1; i < n && len(points) > 0; i++ {
+		point := points[i*len(points)/n]
+		raw, err := bson.Marshal(point)
+		if err != nil || bytes.Equal(raw, last) {
+			continue
+		}
+		bounds = append(bounds, point)
+		last = raw
+	}
+	return bounds
+}
+
+// newPartitions returns the partitions of key between the bounds.
+func newPartitions(key bson.D, bounds []bson.D) []partition {
+	partitions := make([]partition, 0, len(bounds)+1)
+	var min bson.D
+	for _, bound := range bounds {
+		partitions = append(partitions, partition{key: key, min: min, max: bound})
+		min = bound
+	}
+	return append(partitions, partition{key: key, min: min})
+}
+
+// partsName returns the name the part files are named after: --out,
+// without the extension of --compress, which each part file ends with.
+func (exp *MongoExport) partsName() string {
+	return strings.TrimSuffix(exp.OutputOpts.OutputFile, exp.codec.Extension())
+}
+
+// partFileName returns the name of the part file of the partition with the
+// given index.
+func partFileName(out string, index int) string {
+	return fmt.Sprintf("%v.%04d", out, index+1)
+}
+
+// concatParts joins the part files, in order, into the file at out, then
+// removes them.
+func concatParts(out string, parts []string) error {
+	file, err := createOutputFile(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for _, part := range parts {
+		in, err := os.Open(part)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, in)
+		in.Close()
+		if err != nil {
+			return fmt.Errorf("error joining %v into %v: %v", part, out, err)
+		}
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	for _, part := range parts {
+		if err = os.Remove(part); err != nil {
+			return err
+		}
+	}
+	log.Logvf(log.Info, "joined %v part files into %v", len(parts), out)
+	return nil
+}
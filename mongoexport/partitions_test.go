@@ -0,0 +1,120 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestValidatePartitions(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	validate := func(input InputOptions, output OutputFormatOptions) error {
+		exp := &MongoExport{InputOpts: &input, OutputOpts: &output}
+		return exp.validatePartitions()
+	}
+
+	Convey("Partitions are exported to part files named after --out", t, func() {
+		So(validate(InputOptions{}, OutputFormatOptions{}), ShouldBeNil)
+		So(validate(InputOptions{NumParallelPartitions: 4}, OutputFormatOptions{OutputFile: "out.json"}), ShouldBeNil)
+		So(validate(InputOptions{NumParallelPartitions: 4, Query: `{"a": 1}`},
+			OutputFormatOptions{OutputFile: "out.json", ConcatPartitions: true}), ShouldBeNil)
+		So(validate(InputOptions{NumParallelPartitions: 4}, OutputFormatOptions{}), ShouldNotBeNil)
+		So(validate(InputOptions{NumParallelPartitions: -1}, OutputFormatOptions{}), ShouldNotBeNil)
+	})
+
+	Convey("Options that depend on the order of the whole collection can't be used", t, func() {
+		out := OutputFormatOptions{OutputFile: "out.json"}
+		So(validate(InputOptions{NumParallelPartitions: 4, Sort: `{"a": 1}`}, out), ShouldNotBeNil)
+		So(validate(InputOptions{NumParallelPartitions: 4, Skip: 10}, out), ShouldNotBeNil)
+		So(validate(InputOptions{NumParallelPartitions: 4, Limit: 10}, out), ShouldNotBeNil)
+		So(validate(InputOptions{NumParallelPartitions: 4},
+			OutputFormatOptions{OutputFile: "out.json", JSONArray: true, ConcatPartitions: true}), ShouldNotBeNil)
+		So(validate(InputOptions{}, OutputFormatOptions{OutputFile: "out.json", ConcatPartitions: true}), ShouldNotBeNil)
+	})
+
+	Convey("Partitions are validated with the other settings", t, func() {
+		exp := &MongoExport{
+			ToolOptions: &options.ToolOptions{Namespace: &options.Namespace{DB: "test", Collection: "c"}},
+			InputOpts:   &InputOptions{NumParallelPartitions: 2, Limit: 5},
+			OutputOpts:  &OutputFormatOptions{Type: JSON, JSONFormat: Relaxed, OutputFile: "out.json"},
+		}
+		So(exp.validateSettings(), ShouldNotBeNil)
+		exp.InputOpts.Limit = 0
+		So(exp.validateSettings(), ShouldBeNil)
+		So(exp.Partitioned(), ShouldBeTrue)
+	})
+}
+
+func TestPartitionBounds(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	points := func(ids ...interface{}) []bson.D {
+		var docs []bson.D
+		for _, id := range ids {
+			docs = append(docs, bson.D{{"_id", id}})
+		}
+		return docs
+	}
+
+	Convey("The sampled points are split evenly", t, func() {
+		bounds := evenBounds(points(int32(1), int32(2), int32(3), int32(4), int32(5), int32(6), "a", "b"), 4)
+		So(bounds, ShouldResemble, points(int32(3), int32(5), "a"))
+	})
+
+	Convey("Repeated points and fewer points than partitions give fewer partitions", t, func() {
+		So(evenBounds(points(int32(1), int32(1), int32(1), int32(2)), 4), ShouldResemble, points(int32(1), int32(2)))
+		So(evenBounds(points(int32(7)), 4), ShouldResemble, points(int32(7)))
+		So(evenBounds(nil, 4), ShouldBeNil)
+	})
+
+	Convey("The partitions cover the whole index between the bounds", t, func() {
+		key := bson.D{{"_id", 1}}
+		partitions := newPartitions(key, points(int32(3), int32(5)))
+		So(partitions, ShouldResemble, []partition{
+			{key: key, max: bson.D{{"_id", int32(3)}}},
+			{key: key, min: bson.D{{"_id", int32(3)}}, max: bson.D{{"_id", int32(5)}}},
+			{key: key, min: bson.D{{"_id", int32(5)}}},
+		})
+		So(newPartitions(key, nil), ShouldResemble, []partition{{key: key}})
+	})
+}
+
+func TestConcatParts(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("The part files are joined in order and removed", t, func() {
+		dir, err := ioutil.TempDir("", "mongoexport-parts")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		out := filepath.Join(dir, "out.json")
+		var parts []string
+		for i, content := range []string{"{\"_id\":1}\n", "", "{\"_id\":2}\n"} {
+			parts = append(parts, partFileName(out, i))
+			So(ioutil.WriteFile(parts[i], []byte(content), 0600), ShouldBeNil)
+		}
+		So(parts[0], ShouldEqual, out+".0001")
+
+		So(concatParts(out, parts), ShouldBeNil)
+		data, err := ioutil.ReadFile(out)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "{\"_id\":1}\n{\"_id\":2}\n")
+		for _, part := range parts {
+			_, err = os.Stat(part)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		}
+	})
+}
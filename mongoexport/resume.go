@@ -0,0 +1,346 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// checkpointInterval is how many documents are exported between the
+// checkpoints of --resume.
+const checkpointInterval = 1000
+
+// checkpointSuffix is added to the name of an output or part file to name
+// its checkpoint file.
+const checkpointSuffix = ".checkpoint"
+
+// partitionsCheckpointSuffix is added to --out to name the file that the
+// partitions of --numParallelPartitions are recorded in, so that a resumed
+// export splits the collection the same way.
+const partitionsCheckpointSuffix = ".partitions" + checkpointSuffix
+
+// checkpoint is the state of the export to a file, recorded by --resume:
+// after the checkpoint, the file is Offset bytes of Count documents, the
+// last Ties of which have the sort key Key.
+type checkpoint struct {
+	Sort   bson.D   `bson:"sort"`
+	Offset int64    `bson:"offset"`
+	Count  int64    `bson:"count"`
+	Key    bson.Raw `bson:"key,omitempty"`
+	Ties   int64    `bson:"ties"`
+	Done   bool     `bson:"done"`
+}
+
+// partitionsCheckpoint records the partitions of --numParallelPartitions.
+type partitionsCheckpoint struct {
+	Key    bson.D   `bson:"key"`
+	Bounds []bson.D `bson:"bounds"`
+}
+
+// checkpointer records the checkpoints of the export to a file with
+// --resume. The documents are exported in the order of an index on the sort
+// key, so that the export can continue from the key of the last document
+// recorded, as the index bound min, skipping the documents of the same key
+// already exported.
+type checkpointer struct {
+	path    string
+	file    *os.File
+	output  ExportOutput
+	sortKey bson.D
+	state   checkpoint
+}
+
+// validateResume validates --resume, which needs an output file that can
+// be truncated to a checkpoint and appended to.
+func (exp *MongoExport) validateResume() error {
+	if !exp.OutputOpts.Resume {
+		return nil
+	}
+	switch {
+	case exp.OutputOpts.OutputFile == "":
+		return fmt.Errorf("--resume requires --out")
+	case storage.IsRemote(exp.OutputOpts.OutputFile):
+		return fmt.Errorf("cannot use --resume with --out in object storage or at an HTTP endpoint")
+	case exp.OutputOpts.Compress != "":
+		return fmt.Errorf("cannot use --resume with --compress")
+	case exp.OutputOpts.JSONArray:
+		return fmt.Errorf("cannot use --resume with --jsonArray")
+	case exp.InputOpts != nil && exp.InputOpts.Limit != 0:
+		return fmt.Errorf("cannot use --resume with --limit")
+	case exp.OutputOpts.Flatten && exp.OutputOpts.Fields == "" && exp.OutputOpts.FieldFile == "":
+		return fmt.Errorf("--resume requires --fields with --flatten, " +
+			"since a resumed export would otherwise take its columns from its first document")
+	}
+	return nil
+}
+
+// resumeSortKey returns the key that the documents of an export without
+// partitions are sorted by with --resume: --sort, or else _id.
+func (exp *MongoExport) resumeSortKey() (bson.D, error) {
+	if exp.InputOpts == nil || exp.InputOpts.Sort == "" {
+		return bson.D{{"_id", 1}}, nil
+	}
+	return getSortFromArg(exp.InputOpts.Sort)
+}
+
+// checkResumeKey checks that the fields of the sort key are exported, as
+// the key of each document is read from it.
+func (exp *MongoExport) checkResumeKey(sortKey bson.D) error {
+	projection := exp.projection()
+	if projection == nil {
+		return nil
+	}
+	for _, field := range sortKey {
+		if _, ok := projection[strings.SplitN(field.Key, ".", 2)[0]]; !ok {
+			return fmt.Errorf("--resume requires --fields to include '%v', which the documents are sorted by", field.Key)
+		}
+	}
+	return nil
+}
+
+// openCheckpointed opens the file at path to export to it with --resume.
+// If it has a checkpoint, the file is truncated to it, dropping what was
+// written after it, to be appended to, unless the export was complete;
+// otherwise it is created.
+func openCheckpointed(path string, sortKey bson.D) (*checkpointer, error) {
+	c := &checkpointer{path: path + checkpointSuffix, sortKey: sortKey}
+	found, err := readCheckpointFile(c.path, &c.state)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		c.state.Sort = sortKey
+		c.file, err = createOutputFile(path)
+		return c, err
+	}
+
+	sortRaw, _ := bson.Marshal(sortKey)
+	savedRaw, _ := bson.Marshal(c.state.Sort)
+	if !bytes.Equal(sortRaw, savedRaw) {
+		return nil, fmt.Errorf("cannot resume the export to %v, which was sorted by %v rather than %v",
+			path, c.state.Sort, sortKey)
+	}
+	if c.file, err = os.OpenFile(path, os.O_RDWR, 0); err != nil {
+		return nil, fmt.Errorf("cannot resume the export to %v: %v", path, err)
+	}
+	if err = c.file.Truncate(c.state.Offset); err == nil {
+		_, err = c.file.Seek(c.state.Offset, io.SeekStart)
+	}
+	if err != nil {
+		c.file.Close()
+		return nil, fmt.Errorf("error truncating %v to its checkpoint: %v", path, err)
+	}
+	if !c.state.Done {
+		log.Logvf(log.Always, "resuming the export to %v after %v records", path, c.state.Count)
+	}
+	return c, nil
+}
+
+// done returns whether the export has already been completed.
+func (c *checkpointer) done() bool {
+	return c != nil && c.state.Done
+}
+
+// resumed returns whether the export continues from a checkpoint.
+func (c *checkpointer) resumed() bool {
+	return c != nil && c.state.Key != nil
+}
+
+// count returns the number of documents exported as of the checkpoint.
+func (c *checkpointer) count() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.state.Count
+}
+
+// resume sets the output the documents are exported with, which continues
+// the documents already exported, if any.
+func (c *checkpointer) resume(output ExportOutput) {
+	if c == nil {
+		return
+	}
+	c.output = output
+	if c.state.Count == 0 {
+		return
+	}
+	switch output := output.(type) {
+	case *CSVExportOutput:
+		output.NoHeaderLine = true
+		output.NumExported = c.state.Count
+	case *JSONExportOutput:
+		output.NumExported = c.state.Count
+	}
+}
+
+// keyOf returns the sort key of a document. Missing fields are null, as in
+// an index.
+func (c *checkpointer) keyOf(document bson.D) (bson.Raw, error) {
+	if c == nil {
+		return nil, nil
+	}
+	key := make(bson.D, len(c.sortKey))
+	for i, field := range c.sortKey {
+		key[i] = bson.E{Key: field.Key, Value: sortKeyValue(document, field.Key)}
+	}
+	return bson.Marshal(key)
+}
+
+func sortKeyValue(document bson.D, field string) interface{} {
+	var value interface{} = document
+	for _, name := range strings.Split(field, ".") {
+		doc, ok := value.(bson.D)
+		if !ok {
+			return nil
+		}
+		if value, _ = bsonutil.FindValueByKey(name, &doc); value == nil {
+			return nil
+		}
+	}
+	return value
+}
+
+// update records that the document with the given sort key was exported,
+// saving a checkpoint every checkpointInterval documents.
+func (c *checkpointer) update(key bson.Raw) error {
+	if c == nil {
+		return nil
+	}
+	if bytes.Equal(key, c.state.Key) {
+		c.state.Ties++
+	} else {
+		c.state.Key = key
+		c.state.Ties = 1
+	}
+	c.state.Count++
+	if c.state.Count%checkpointInterval == 0 {
+		return c.save()
+	}
+	return nil
+}
+
+// finish records that the export is complete.
+func (c *checkpointer) finish() error {
+	if c == nil {
+		return nil
+	}
+	c.state.Done = true
+	return c.save()
+}
+
+// save flushes the output to the file, then records its size in the
+// checkpoint file.
+func (c *checkpointer) save() error {
+	if err := c.output.Flush(); err != nil {
+		return err
+	}
+	offset, err := c.file.Seek(0, io.SeekCurrent)
+	if err == nil {
+		err = c.file.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("error saving checkpoint: %v", err)
+	}
+	c.state.Offset = offset
+	return writeCheckpointFile(c.path, &c.state)
+}
+
+// remove removes the checkpoint file, once the whole export is complete.
+func (c *checkpointer) remove() error {
+	if c == nil {
+		return nil
+	}
+	return removeCheckpointFile(c.path)
+}
+
+// resumePartitions returns the partitions recorded for --out by an earlier
+// run of the export, or else the partitions split by getPartitions, which
+// are recorded.
+func (exp *MongoExport) resumePartitions() ([]partition, error) {
+	if !exp.OutputOpts.Resume {
+		return exp.getPartitions()
+	}
+	path := exp.OutputOpts.OutputFile + partitionsCheckpointSuffix
+	var saved partitionsCheckpoint
+	found, err := readCheckpointFile(path, &saved)
+	if err != nil || found {
+		return newPartitions(saved.Key, saved.Bounds), err
+	}
+	partitions, err := exp.getPartitions()
+	if err != nil {
+		return nil, err
+	}
+	saved.Key = partitions[0].key
+	for _, part := range partitions[1:] {
+		saved.Bounds = append(saved.Bounds, part.min)
+	}
+	return partitions, writeCheckpointFile(path, &saved)
+}
+
+// removePartitionCheckpoints removes the checkpoint files of the parts and
+// their partitions, once the whole export is complete.
+func (exp *MongoExport) removePartitionCheckpoints(parts []string) error {
+	if !exp.OutputOpts.Resume {
+		return nil
+	}
+	for _, part := range parts {
+		if err := removeCheckpointFile(part + checkpointSuffix); err != nil {
+			return err
+		}
+	}
+	return removeCheckpointFile(exp.OutputOpts.OutputFile + partitionsCheckpointSuffix)
+}
+
+// readCheckpointFile reads the checkpoint file at path into value, and
+// returns whether it exists.
+func readCheckpointFile(path string, value interface{}) (bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if err = bson.UnmarshalExtJSON(data, true, value); err != nil {
+		return false, fmt.Errorf("error reading checkpoint %v: %v", path, err)
+	}
+	return true, nil
+}
+
+// writeCheckpointFile writes value to the checkpoint file at path, as
+// canonical extended JSON. It is replaced by renaming, so that it is never
+// left partly written.
+func writeCheckpointFile(path string, value interface{}) error {
+	data, err := bson.MarshalExtJSON(value, true, false)
+	if err != nil {
+		return fmt.Errorf("error writing checkpoint %v: %v", path, err)
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, append(data, '\n'), 0640); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing checkpoint %v: %v", path, err)
+	}
+	return nil
+}
+
+func removeCheckpointFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoexport
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestResume(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With an export to a file with --resume", t, func() {
+		dir, err := ioutil.TempDir("", "mongoexport-resume")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "out.json")
+		// the key isn't unique, so the export resumes after the documents
+		// of its last key already exported
+		sortKey := bson.D{{"a.b", 1}}
+
+		// export writes the documents like writeDocuments, stopping before
+		// the document at stop without finishing, as a failed export would
+		export := func(docs []bson.D, stop int) *checkpointer {
+			c, err := openCheckpointed(path, sortKey)
+			So(err, ShouldBeNil)
+			if c.done() {
+				return c
+			}
+			output := NewJSONExportOutput(false, true, c.file, Relaxed)
+			c.resume(output)
+			for i := c.count(); i < int64(len(docs)); i++ {
+				if i == int64(stop) {
+					So(c.file.Close(), ShouldBeNil)
+					return c
+				}
+				key, err := c.keyOf(docs[i])
+				if err == nil {
+					err = output.ExportDocument(docs[i])
+				}
+				if err == nil {
+					err = c.update(key)
+				}
+				So(err, ShouldBeNil)
+			}
+			So(output.WriteFooter(), ShouldBeNil)
+			So(c.finish(), ShouldBeNil)
+			So(c.file.Close(), ShouldBeNil)
+			return c
+		}
+		var docs []bson.D
+		for i := 0; i < checkpointInterval*2+10; i++ {
+			docs = append(docs, bson.D{{"_id", int32(i)}, {"a", bson.D{{"b", int32(i / 700)}}}})
+		}
+
+		Convey("a failed export continues from its last checkpoint", func() {
+			c := export(docs, checkpointInterval+5)
+			So(c.state.Count, ShouldEqual, checkpointInterval+5)
+			var saved checkpoint
+			found, err := readCheckpointFile(c.path, &saved)
+			So(err, ShouldBeNil)
+			So(found, ShouldBeTrue)
+			So(saved.Count, ShouldEqual, checkpointInterval)
+			So(saved.Ties, ShouldEqual, checkpointInterval-700)
+			So(saved.Key, ShouldResemble, bson.Raw(mustMarshal(bson.D{{"a.b", int32(1)}})))
+
+			c = export(docs, -1)
+			So(c.done(), ShouldBeTrue)
+			So(c.remove(), ShouldBeNil)
+			_, err = os.Stat(c.path)
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			data, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			expected, err := ioutil.TempFile(dir, "expected")
+			So(err, ShouldBeNil)
+			output := NewJSONExportOutput(false, true, expected, Relaxed)
+			for _, doc := range docs {
+				So(output.ExportDocument(doc), ShouldBeNil)
+			}
+			So(output.WriteFooter(), ShouldBeNil)
+			So(expected.Close(), ShouldBeNil)
+			expectedData, err := ioutil.ReadFile(expected.Name())
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, string(expectedData))
+		})
+
+		Convey("a complete export isn't exported again", func() {
+			So(export(docs, -1).done(), ShouldBeTrue)
+			So(export(docs, 0).done(), ShouldBeTrue)
+		})
+
+		Convey("an export can't resume with a different sort", func() {
+			export(docs, checkpointInterval+5)
+			_, err := openCheckpointed(path, bson.D{{"_id", 1}})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("A resumed export has the partitions recorded by the export it continues", t, func() {
+		dir, err := ioutil.TempDir("", "mongoexport-resume")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		exp := &MongoExport{OutputOpts: &OutputFormatOptions{OutputFile: filepath.Join(dir, "out.json"), Resume: true}}
+		saved := partitionsCheckpoint{Key: bson.D{{"_id", int32(1)}}, Bounds: []bson.D{{{"_id", "m"}}}}
+		So(writeCheckpointFile(exp.OutputOpts.OutputFile+partitionsCheckpointSuffix, &saved), ShouldBeNil)
+		partitions, err := exp.resumePartitions()
+		So(err, ShouldBeNil)
+		So(partitions, ShouldResemble, newPartitions(saved.Key, saved.Bounds))
+
+		So(exp.removePartitionCheckpoints(nil), ShouldBeNil)
+		_, err = os.Stat(exp.OutputOpts.OutputFile + partitionsCheckpointSuffix)
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("The sort key of a document is null for missing fields", t, func() {
+		c := &checkpointer{sortKey: bson.D{{"a.b", 1}, {"c", -1}}}
+		key, err := c.keyOf(bson.D{{"a", bson.D{{"b", "x"}}}})
+		So(err, ShouldBeNil)
+		So(key, ShouldResemble, bson.Raw(mustMarshal(bson.D{{"a.b", "x"}, {"c", nil}})))
+	})
+
+	Convey("--resume needs an output file that can be appended to", t, func() {
+		newExport := func(outputOpts OutputFormatOptions, inputOpts InputOptions) *MongoExport {
+			outputOpts.Resume = true
+			return &MongoExport{OutputOpts: &outputOpts, InputOpts: &inputOpts}
+		}
+		So(newExport(OutputFormatOptions{OutputFile: "out.json"}, InputOptions{Skip: 5}).validateResume(), ShouldBeNil)
+		So(newExport(OutputFormatOptions{}, InputOptions{}).validateResume(), ShouldNotBeNil)
+		So(newExport(OutputFormatOptions{OutputFile: "s3://bucket/out.json"}, InputOptions{}).validateResume(), ShouldNotBeNil)
+		So(newExport(OutputFormatOptions{OutputFile: "out.json", Compress: "gzip"}, InputOptions{}).validateResume(), ShouldNotBeNil)
+		So(newExport(OutputFormatOptions{OutputFile: "out.json", JSONArray: true}, InputOptions{}).validateResume(), ShouldNotBeNil)
+		So(newExport(OutputFormatOptions{OutputFile: "out.json"}, InputOptions{Limit: 5}).validateResume(), ShouldNotBeNil)
+
+		exp := newExport(OutputFormatOptions{OutputFile: "out.csv", Fields: "a.b,c"}, InputOptions{})
+		So(exp.checkResumeKey(bson.D{{"a.c", 1}}), ShouldBeNil)
+		So(exp.checkResumeKey(bson.D{{"_id", 1}}), ShouldBeNil)
+		So(exp.checkResumeKey(bson.D{{"d", 1}}), ShouldNotBeNil)
+	})
+}
+
+func mustMarshal(doc bson.D) []byte {
+	raw, err := bson.Marshal(doc)
+	So(err, ShouldBeNil)
+	return raw
+}
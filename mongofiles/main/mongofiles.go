@@ -8,9 +8,9 @@
 package main
 
 import (
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/signals"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/signals"
+	"github.com/mongodb/mongo-tools/common/util"
 	"github.com/mongodb/mongo-tools/mongofiles"
 
 	"fmt"
@@ -27,19 +27,21 @@ func main() {
 	if err != nil {
 		log.Logvf(log.Always, "error parsing command line options: %s", err.Error())
 		log.Logv(log.Always, util.ShortUsage("mongofiles"))
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitBadOptions)
 	}
+	defer util.Shutdown()
+	defer util.RecoverCrash()
 
 	signals.Handle()
 
 	// print help, if specified
 	if opts.PrintHelp(false) {
-		os.Exit(util.ExitSuccess)
+		util.Exit(util.ExitSuccess)
 	}
 
 	// print version, if specified
 	if opts.PrintVersion() {
-		os.Exit(util.ExitSuccess)
+		util.Exit(util.ExitSuccess)
 	}
 
 	mf, err := mongofiles.New(opts)
@@ -48,14 +50,13 @@ func main() {
 		if setupErr, ok := err.(util.SetupError); ok && setupErr.Message != "" {
 			log.Logvf(log.Always, setupErr.Message)
 		}
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitFailure)
 	}
 	defer mf.Close()
 
 	output, err := mf.Run(true)
 	if err != nil {
-		log.Logvf(log.Always, "Failed: %v", err)
-		os.Exit(util.ExitFailure)
+		util.Exit(util.LogFailure(err))
 	}
 	fmt.Printf("%s", output)
 }
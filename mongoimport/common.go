@@ -16,13 +16,17 @@ import (
 	"sync"
 	"sync/atomic"
 
-	"github.com/mongodb/mongo-tools-common/bsonutil"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"gopkg.in/tomb.v2"
 )
 
+// documentLog carries the per-document trace output, which can be sampled
+// separately with --logSampling
+var documentLog = log.Component("import.documents")
+
 type ParseGrace int
 
 // FieldInfo contains information about field names. It is used in validateFields.
@@ -488,10 +492,27 @@ type coercionError struct{}
 
 func (coercionError) Error() string { return "coercionError" }
 
+// loggedTokens returns the tokens of a row as they should appear in log
+// output, with the values of scrubbed columns replaced by hashes.
+func loggedTokens(colSpecs []ColumnSpec, tokens []string) []interface{} {
+	logged := make([]interface{}, len(tokens))
+	for index, token := range tokens {
+		if index < len(colSpecs) {
+			logged[index] = log.ScrubField(colSpecs[index].Name, token)
+		} else {
+			logged[index] = token
+		}
+	}
+	return logged
+}
+
 // tokensToBSON reads in slice of records - along with ordered column names -
 // and returns a BSON document for the record.
 func tokensToBSON(colSpecs []ColumnSpec, tokens []string, numProcessed uint64, ignoreBlanks bool, useArrayIndexFields bool) (bson.D, error) {
-	log.Logvf(log.DebugHigh, "got line: %v", tokens)
+	if documentLog.IsInVerbosity(log.DebugHigh) {
+		// scrubbing the tokens costs too much to do for every row
+		documentLog.Logvf(log.DebugHigh, "got line: %v", loggedTokens(colSpecs, tokens))
+	}
 	var parsedValue interface{}
 	document := bson.D{}
 	for index, token := range tokens {
@@ -501,21 +522,21 @@ func tokensToBSON(colSpecs []ColumnSpec, tokens []string, numProcessed uint64, i
 		if index < len(colSpecs) {
 			parsedValue, err := colSpecs[index].Parser.Parse(token)
 			if err != nil {
-				log.Logvf(log.DebugHigh, "parse failure in document #%d for column '%s',"+
+				documentLog.Logvf(log.DebugHigh, "parse failure in document #%d for column '%s',"+
 					"could not parse token '%s' to type %s",
-					numProcessed, colSpecs[index].Name, token, colSpecs[index].TypeName)
+					numProcessed, colSpecs[index].Name, log.ScrubField(colSpecs[index].Name, token), colSpecs[index].TypeName)
 				switch colSpecs[index].ParseGrace {
 				case pgAutoCast:
 					parsedValue = autoParse(token)
 				case pgSkipField:
 					continue
 				case pgSkipRow:
-					log.Logvf(log.Always, "skipping row #%d: %v", numProcessed, tokens)
+					log.Logvf(log.Always, "skipping row #%d: %v", numProcessed, loggedTokens(colSpecs, tokens))
 					return nil, coercionError{}
 				case pgStop:
 					return nil, fmt.Errorf("type coercion failure in document #%d for column '%s', "+
 						"could not parse token '%s' to type %s",
-						numProcessed, colSpecs[index].Name, token, colSpecs[index].TypeName)
+						numProcessed, colSpecs[index].Name, log.ScrubField(colSpecs[index].Name, token), colSpecs[index].TypeName)
 				}
 			}
 			if len(colSpecs[index].NameParts) > 1 {
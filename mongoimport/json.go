@@ -12,9 +12,9 @@ import (
 	"io"
 	"strings"
 
-	"github.com/mongodb/mongo-tools-common/bsonutil"
-	"github.com/mongodb/mongo-tools-common/json"
-	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/log"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
@@ -174,13 +174,13 @@ func (c JSONConverter) convertLegacyExtJSON() (bson.D, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error unmarshaling bytes on document #%v: %v", c.index, err)
 	}
-	log.Logvf(log.DebugHigh, "got line: %v", document)
+	documentLog.Logvf(log.DebugHigh, "got line: %v", log.Document(document))
 
 	bsonD, err := bsonutil.GetExtendedBsonD(document)
 	if err != nil {
 		return nil, fmt.Errorf("error getting extended BSON for document #%v: %v", c.index, err)
 	}
-	log.Logvf(log.DebugHigh, "got extended line: %#v", bsonD)
+	documentLog.Logvf(log.DebugHigh, "got extended line: %#v", log.Document(bsonD))
 	return bsonD, nil
 }
 
@@ -10,9 +10,10 @@ package main
 import (
 	"os"
 
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/signals"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/signals"
+	"github.com/mongodb/mongo-tools/common/util"
 	"github.com/mongodb/mongo-tools/mongoimport"
 )
 
@@ -26,8 +27,10 @@ func main() {
 	if err != nil {
 		log.Logvf(log.Always, "error parsing command line options: %v", err)
 		log.Logvf(log.Always, util.ShortUsage("mongoimport"))
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitBadOptions)
 	}
+	defer util.Shutdown()
+	defer util.RecoverCrash()
 
 	signals.Handle()
 
@@ -44,15 +47,17 @@ func main() {
 	m, err := mongoimport.New(opts)
 	if err != nil {
 		log.Logvf(log.Always, err.Error())
-		os.Exit(util.ExitFailure)
+		util.Exit(util.FailureExitCode(err))
 	}
 	defer m.Close()
 
 	numDocs, numFailure, err := m.ImportDocuments()
+	db.FlushIgnoredErrors()
+	exitCode := util.ExitSuccess
+	if err != nil {
+		exitCode = util.LogFailure(err)
+	}
 	if !opts.Quiet {
-		if err != nil {
-			log.Logvf(log.Always, "Failed: %v", err)
-		}
 		if m.ToolOptions.WriteConcern.Acknowledged() {
 			if opts.Mode == "delete" {
 				log.Logvf(log.Always, "%v document(s) deleted successfully. %v document(s) failed to delete.", numDocs, numFailure)
@@ -64,6 +69,6 @@ func main() {
 		}
 	}
 	if err != nil {
-		os.Exit(util.ExitFailure)
+		util.Exit(exitCode)
 	}
 }
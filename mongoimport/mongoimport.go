@@ -8,11 +8,11 @@
 package mongoimport
 
 import (
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/options"
-	"github.com/mongodb/mongo-tools-common/progress"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"gopkg.in/tomb.v2"
@@ -24,6 +24,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Input format types accepted by mongoimport.
@@ -260,6 +261,11 @@ func (imp *MongoImport) validateSettings(args []string) error {
 		imp.IngestOptions.BulkBufferSize = 1000
 	}
 
+	if imp.IngestOptions.IgnoredErrorLogLimit < 0 {
+		return fmt.Errorf("--ignoredErrorLogLimit cannot be negative")
+	}
+	db.SetIgnoredErrorLimit(imp.IngestOptions.IgnoredErrorLogLimit)
+
 	// ensure we have a valid string to use for the collection
 	if imp.ToolOptions.Collection == "" {
 		log.Logvf(log.Always, "no collection specified")
@@ -347,7 +353,12 @@ func (imp *MongoImport) ImportDocuments() (uint64, uint64, error) {
 	}
 	bar.Start()
 	defer bar.Stop()
-	return imp.importDocuments(inputReader)
+
+	start := time.Now()
+	numImported, numFailed, err := imp.importDocuments(inputReader)
+	log.Summary().AddNamespace(imp.ToolOptions.Namespace.String(), int64(numImported), int64(numFailed),
+		time.Since(start), err)
+	return numImported, numFailed, err
 }
 
 // importDocuments is a helper to ImportDocuments and does all the ingestion
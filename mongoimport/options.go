@@ -9,9 +9,9 @@ package mongoimport
 import (
 	"fmt"
 
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/options"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
 )
 
 var Usage = `<options> <connection-string> <file> 
@@ -76,6 +76,9 @@ type IngestOptions struct {
 	// Forces mongoimport to halt the import operation at the first insert or upsert error.
 	StopOnError bool `long:"stopOnError" description:"halt after encountering any error during importing. By default, mongoimport will attempt to continue through document validation and DuplicateKey errors, but with this option enabled, the tool will stop instead. A small number of documents may be inserted after encountering an error even with this option enabled; use --maintainInsertionOrder to halt immediately after an error"`
 
+	// Limits the rate of the messages about errors continued through.
+	IgnoredErrorLogLimit int `long:"ignoredErrorLogLimit" value-name:"<n>" description:"log at most this many of the errors continued through with each error code per minute, such as duplicate keys, and summarize the rest (default: 0, logging all of them)"`
+
 	// Modify the import process.
 	// For existing documents (match --upsertFields) in the database:
 	// "insert": Insert only, skip existing documents.
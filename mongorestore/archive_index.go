@@ -0,0 +1,68 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// seekArchive makes the demultiplexer read only the blocks of the archive
+// of the namespaces being restored, when the archive is a local file with
+// an index. It returns the file they are read from, or nil if the whole
+// archive is read.
+func (restore *MongoRestore) seekArchive() (io.Closer, error) {
+	if restore.archivePath == "" {
+		return nil, nil
+	}
+	indexPath := restore.archivePath + archive.IndexSuffix
+	content, err := ioutil.ReadFile(indexPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading the archive index %v: %v", indexPath, err)
+	}
+	var index archive.Index
+	if err = json.Unmarshal(content, &index); err != nil {
+		return nil, fmt.Errorf("error reading the archive index %v: %v", indexPath, err)
+	}
+	if index.FormatVersion != archive.IndexFormatVersion {
+		log.Logvf(log.Always, "warning: ignoring the archive index %v, whose format version %v isn't supported",
+			indexPath, index.FormatVersion)
+		return nil, nil
+	}
+	file, err := os.Open(restore.archivePath)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if stat.Size() != index.Size {
+		log.Logvf(log.Always, "warning: ignoring the archive index %v, which doesn't match the archive", indexPath)
+		file.Close()
+		return nil, nil
+	}
+	skipped := restore.archive.Demux.SkipMuted()
+	if len(skipped) == 0 {
+		file.Close()
+		return nil, nil
+	}
+	log.Logvf(log.Info, "using the archive index %v to skip the blocks of %v namespaces not restored",
+		indexPath, len(skipped))
+	restore.archive.Demux.In = bufio.NewReaderSize(index.Reader(file, skipped), readAheadChunkSize)
+	return file, nil
+}
@@ -0,0 +1,95 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSeekArchive(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With an indexed archive of a restored and a skipped collection", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_index")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "dump.archive")
+
+		file := []byte("prelude")
+		var kept []byte
+		index := archive.Index{FormatVersion: archive.IndexFormatVersion, Namespaces: map[string][]archive.BlockRange{}}
+		block := func(c string, eof bool) {
+			start := len(file)
+			file = append(file, mustMarshal(bson.D{{Key: "db", Value: "test"}, {Key: "collection", Value: c}, {Key: "EOF", Value: eof}})...)
+			if !eof {
+				file = append(file, mustMarshal(bson.D{{Key: "_id", Value: len(file)}})...)
+			}
+			file = append(file, 0xff, 0xff, 0xff, 0xff)
+			index.Namespaces["test."+c] = append(index.Namespaces["test."+c],
+				archive.BlockRange{Offset: int64(start), Length: int64(len(file) - start)})
+			if c == "keep" {
+				kept = append(kept, file[start:]...)
+			}
+		}
+		block("keep", false)
+		block("skip", false)
+		block("keep", false)
+		block("skip", true)
+		block("keep", true)
+		index.Size = int64(len(file))
+		So(ioutil.WriteFile(path, file, 0644), ShouldBeNil)
+		content, err := json.Marshal(index)
+		So(err, ShouldBeNil)
+		So(ioutil.WriteFile(path+archive.IndexSuffix, content, 0644), ShouldBeNil)
+
+		restore := &MongoRestore{archivePath: path, archive: &archive.Reader{}}
+		restore.archive.Demux = archive.CreateDemux([]*archive.CollectionMetadata{
+			{Database: "test", Collection: "keep", Size: 10},
+			{Database: "test", Collection: "skip", Size: 20},
+		}, nil)
+		restore.archive.Demux.Open("test.skip", &archive.MutedCollection{})
+
+		Convey("only the blocks of the restored collection are read", func() {
+			closer, err := restore.seekArchive()
+			So(err, ShouldBeNil)
+			So(closer, ShouldNotBeNil)
+			defer closer.Close()
+			read, err := ioutil.ReadAll(restore.archive.Demux.In)
+			So(err, ShouldBeNil)
+			So(read, ShouldResemble, kept)
+			So(restore.archive.Demux.NamespaceStatus["test.skip"], ShouldEqual, archive.NamespaceClosed)
+			_, total := restore.archive.Demux.Progress()
+			So(total, ShouldEqual, 10)
+		})
+
+		Convey("an index that doesn't match the archive is ignored", func() {
+			So(ioutil.WriteFile(path, append(file, 0), 0644), ShouldBeNil)
+			closer, err := restore.seekArchive()
+			So(err, ShouldBeNil)
+			So(closer, ShouldBeNil)
+			So(restore.archive.Demux.In, ShouldBeNil)
+			So(restore.archive.Demux.NamespaceStatus["test.skip"], ShouldEqual, archive.NamespaceUnopened)
+		})
+
+		Convey("an archive without an index is read whole", func() {
+			So(os.Remove(path+archive.IndexSuffix), ShouldBeNil)
+			closer, err := restore.seekArchive()
+			So(err, ShouldBeNil)
+			So(closer, ShouldBeNil)
+			So(restore.archive.Demux.In, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,140 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/text"
+)
+
+// readAheadChunkSize is the size of the reads a readAheadReader makes.
+const readAheadChunkSize = 1024 * 1024
+
+// archiveProgressName is the name of the progress bar of the whole archive.
+const archiveProgressName = "archive"
+
+// validateArchiveStreamOptions checks the options bounding the memory used
+// to stream an archive.
+func (restore *MongoRestore) validateArchiveStreamOptions() error {
+	sizes := []struct {
+		option string
+		size   int
+	}{
+		{ArchiveBufferSizeOption, restore.InputOptions.ArchiveBufferSize},
+		{ArchiveReadAheadOption, restore.InputOptions.ArchiveReadAhead},
+	}
+	for _, size := range sizes {
+		if size.size < 0 {
+			return fmt.Errorf("%v must not be negative", size.option)
+		}
+		if size.size > 0 && restore.InputOptions.Archive == "" {
+			return fmt.Errorf("cannot use %v without --archive", size.option)
+		}
+	}
+	return nil
+}
+
+// archiveStream returns the reader the archive is demultiplexed from, which
+// reads ahead of the demultiplexer with --archiveReadAhead.
+func (restore *MongoRestore) archiveStream(in io.ReadCloser) io.ReadCloser {
+	size := restore.InputOptions.ArchiveReadAhead * 1024 * 1024
+	if size == 0 {
+		return in
+	}
+	log.Logvf(log.DebugLow, "reading up to %v of the archive ahead", text.FormatByteAmount(int64(size)))
+	return newReadAheadReader(in, size)
+}
+
+// initArchiveBuffers sets how much of each collection the demultiplexer
+// holds for its consumer.
+func (restore *MongoRestore) initArchiveBuffers() {
+	size := restore.InputOptions.ArchiveBufferSize * 1024 * 1024
+	if size == 0 {
+		return
+	}
+	restore.archive.Demux.BufferSize = size
+	restore.archive.Demux.Budget = restore.memory
+	log.Logvf(log.Info, "buffering up to %v of each of up to %v collections read from the archive",
+		text.FormatByteAmount(int64(size)), restore.OutputOptions.NumParallelCollections)
+}
+
+// readAheadReader reads up to a given number of bytes of its input ahead of
+// its consumer on another goroutine. It stops reading while that many are
+// unconsumed, so a writer piping to it is held back rather than it growing.
+type readAheadReader struct {
+	in        io.ReadCloser
+	chunks    chan []byte
+	current   []byte
+	err       error // set before chunks is closed
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newReadAheadReader(in io.ReadCloser, size int) *readAheadReader {
+	count := size / readAheadChunkSize
+	if count < 1 {
+		count = 1
+	}
+	reader := &readAheadReader{
+		in:     in,
+		chunks: make(chan []byte, count),
+		done:   make(chan struct{}),
+	}
+	go reader.fill()
+	return reader
+}
+
+func (reader *readAheadReader) fill() {
+	defer close(reader.chunks)
+	for {
+		chunk := make([]byte, readAheadChunkSize)
+		n, err := io.ReadFull(reader.in, chunk)
+		if n > 0 {
+			select {
+			case reader.chunks <- chunk[:n]:
+			case <-reader.done:
+				reader.err = io.ErrClosedPipe
+				return
+			}
+		}
+		switch err {
+		case nil:
+		case io.ErrUnexpectedEOF:
+			reader.err = io.EOF
+			return
+		default:
+			reader.err = err
+			return
+		}
+	}
+}
+
+// Read is part of the io.Reader interface.
+func (reader *readAheadReader) Read(p []byte) (int, error) {
+	for len(reader.current) == 0 {
+		chunk, ok := <-reader.chunks
+		if !ok {
+			return 0, reader.err
+		}
+		reader.current = chunk
+	}
+	n := copy(p, reader.current)
+	reader.current = reader.current[n:]
+	return n, nil
+}
+
+// Close stops reading ahead and closes the input.
+func (reader *readAheadReader) Close() error {
+	reader.closeOnce.Do(func() {
+		close(reader.done)
+	})
+	return reader.in.Close()
+}
@@ -0,0 +1,169 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (reader *failingReader) Read(p []byte) (int, error) {
+	if len(reader.data) == 0 {
+		return 0, reader.err
+	}
+	n := copy(p, reader.data)
+	reader.data = reader.data[n:]
+	return n, nil
+}
+
+func TestValidateArchiveStreamOptions(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("The archive buffer options require --archive and a size", t, func() {
+		restore := &MongoRestore{InputOptions: &InputOptions{Archive: "-", ArchiveBufferSize: 16, ArchiveReadAhead: 64}}
+		So(restore.validateArchiveStreamOptions(), ShouldBeNil)
+		restore.InputOptions.ArchiveReadAhead = -1
+		So(restore.validateArchiveStreamOptions(), ShouldNotBeNil)
+		restore.InputOptions = &InputOptions{ArchiveBufferSize: 16}
+		err := restore.validateArchiveStreamOptions()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, ArchiveBufferSizeOption)
+	})
+}
+
+func TestReadAheadReader(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 3*readAheadChunkSize/16+5)
+
+	Convey("A read-ahead reader returns its input", t, func() {
+		reader := newReadAheadReader(ioutil.NopCloser(bytes.NewReader(data)), 2*readAheadChunkSize)
+		read, err := ioutil.ReadAll(reader)
+		So(err, ShouldBeNil)
+		So(bytes.Equal(read, data), ShouldBeTrue)
+		So(reader.Close(), ShouldBeNil)
+	})
+
+	Convey("A read-ahead reader returns the error of its input after its data", t, func() {
+		failure := errors.New("broken pipe")
+		reader := newReadAheadReader(ioutil.NopCloser(&failingReader{data: data[:100], err: failure}), 1)
+		read, err := ioutil.ReadAll(reader)
+		So(err, ShouldEqual, failure)
+		So(read, ShouldResemble, data[:100])
+	})
+
+	Convey("A read-ahead reader stops reading when it is full", t, func() {
+		in, out := io.Pipe()
+		reader := newReadAheadReader(in, readAheadChunkSize)
+		written := make(chan struct{})
+		go func() {
+			out.Write(data)
+			close(written)
+		}()
+		select {
+		case <-written:
+			So("the whole input was read ahead", ShouldBeNil)
+		case <-time.After(100 * time.Millisecond):
+		}
+		So(reader.Close(), ShouldBeNil)
+		<-written
+	})
+}
+
+func TestBufferedDemux(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	bodies := [][]byte{
+		mustMarshal(bson.D{{Key: "_id", Value: 1}}),
+		mustMarshal(bson.D{{Key: "_id", Value: 2}}),
+		mustMarshal(bson.D{{Key: "_id", Value: 3}}),
+	}
+	size := 0
+	for _, body := range bodies {
+		size += len(body)
+	}
+
+	open := func(bufferSize int) (*archive.Demultiplexer, *archive.RegularCollectionReceiver) {
+		demux := archive.CreateDemux([]*archive.CollectionMetadata{{Database: "test", Collection: "c", Size: size}}, nil)
+		demux.BufferSize = bufferSize
+		receiver := &archive.RegularCollectionReceiver{Origin: "test.c", Demux: demux}
+		So(receiver.Open(), ShouldBeNil)
+		So(demux.HeaderBSON(mustMarshal(bson.D{{Key: "db", Value: "test"}, {Key: "collection", Value: "c"}})), ShouldBeNil)
+		return demux, receiver
+	}
+
+	Convey("A buffered namespace is demultiplexed ahead of its consumer", t, func() {
+		demux, receiver := open(1024)
+		for _, body := range bodies {
+			So(demux.BodyBSON(body), ShouldBeNil)
+		}
+		current, total := demux.Progress()
+		So(current, ShouldEqual, size)
+		So(total, ShouldEqual, size)
+
+		hash := crc64.New(crc64.MakeTable(crc64.ECMA))
+		for _, body := range bodies {
+			hash.Write(body)
+		}
+		ended := make(chan error)
+		go func() {
+			ended <- demux.HeaderBSON(mustMarshal(bson.D{
+				{Key: "db", Value: "test"},
+				{Key: "collection", Value: "c"},
+				{Key: "EOF", Value: true},
+				{Key: "CRC", Value: int64(hash.Sum64())},
+			}))
+		}()
+		read, err := ioutil.ReadAll(receiver)
+		So(err, ShouldBeNil)
+		So(read, ShouldResemble, bytes.Join(bodies, nil))
+		So(receiver.Close(), ShouldBeNil)
+		So(<-ended, ShouldBeNil)
+	})
+
+	Convey("A full buffer holds the demultiplexer back", t, func() {
+		demux, receiver := open(len(bodies[0]))
+		So(demux.BodyBSON(bodies[0]), ShouldBeNil)
+		written := make(chan error)
+		go func() {
+			written <- demux.BodyBSON(bodies[1])
+		}()
+		select {
+		case <-written:
+			So("the second body was buffered", ShouldBeNil)
+		case <-time.After(100 * time.Millisecond):
+		}
+		buf := make([]byte, len(bodies[0]))
+		_, err := io.ReadFull(receiver, buf)
+		So(err, ShouldBeNil)
+		So(buf, ShouldResemble, bodies[0])
+		So(<-written, ShouldBeNil)
+
+		Convey("until its consumer stops reading", func() {
+			go func() {
+				written <- demux.BodyBSON(bodies[2])
+			}()
+			So(receiver.Close(), ShouldBeNil)
+			So(<-written, ShouldNotBeNil)
+		})
+	})
+}
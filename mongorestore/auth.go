@@ -0,0 +1,132 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// remapAuthSource returns the users or roles of a database-specific dump as
+// ones of the database they are restored to. Only documents of a database
+// other than the destination are changed, so a source that is already of the
+// destination is returned as it is.
+func remapAuthSource(source *db.DecodedBSONSource, intentType, destination string) (*db.DecodedBSONSource, error) {
+	var documents []bson.D
+	var remapped bool
+	for {
+		var doc bson.D
+		if !source.Next(&doc) {
+			break
+		}
+		if from := authDocumentDB(doc); from != "" && from != destination {
+			doc = remapAuthDocument(doc, from, destination)
+			remapped = true
+		}
+		documents = append(documents, doc)
+	}
+	if err := source.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %v: %v", intentType, err)
+	}
+
+	var buffer bytes.Buffer
+	for _, doc := range documents {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("error remapping %v: %v", intentType, err)
+		}
+		buffer.Write(raw)
+	}
+	if remapped {
+		log.Logvf(log.Always, "remapping %v %v to database %v", len(documents), intentType, destination)
+	}
+	return db.NewDecodedBSONSource(db.NewBSONSource(ioutil.NopCloser(&buffer))), nil
+}
+
+// authDocumentDB returns the database a user or role is defined on.
+func authDocumentDB(doc bson.D) string {
+	for _, elem := range doc {
+		if elem.Key == "db" {
+			name, _ := elem.Value.(string)
+			return name
+		}
+	}
+	return ""
+}
+
+// remapAuthDocument returns a user or role of database from as one of
+// database to: its _id and db, and the roles and privileges it has on from,
+// refer to to instead. Roles and privileges on other databases are kept, and
+// so are credentials, which don't depend on the database, custom data and
+// authentication restrictions. The userId of a user is dropped, since the
+// remapped user is a different user.
+func remapAuthDocument(doc bson.D, from, to string) bson.D {
+	remapped := make(bson.D, 0, len(doc))
+	for _, elem := range doc {
+		switch elem.Key {
+		case "_id":
+			if id, ok := elem.Value.(string); ok && strings.HasPrefix(id, from+".") {
+				elem.Value = to + id[len(from):]
+			}
+		case "db":
+			elem.Value = to
+		case "userId":
+			continue
+		case "roles":
+			elem.Value = remapAuthArray(elem.Value, func(role bson.D) bson.D {
+				return remapDBField(role, from, to)
+			})
+		case "privileges":
+			elem.Value = remapAuthArray(elem.Value, func(privilege bson.D) bson.D {
+				privilege = append(bson.D{}, privilege...)
+				for i, field := range privilege {
+					if resource, ok := field.Value.(bson.D); ok && field.Key == "resource" {
+						privilege[i].Value = remapDBField(resource, from, to)
+					}
+				}
+				return privilege
+			})
+		}
+		remapped = append(remapped, elem)
+	}
+	return remapped
+}
+
+// remapAuthArray applies remap to the documents of an array.
+func remapAuthArray(value interface{}, remap func(bson.D) bson.D) interface{} {
+	array, ok := value.(bson.A)
+	if !ok {
+		return value
+	}
+	remapped := make(bson.A, len(array))
+	for i, item := range array {
+		if doc, ok := item.(bson.D); ok {
+			remapped[i] = remap(doc)
+		} else {
+			remapped[i] = item
+		}
+	}
+	return remapped
+}
+
+// remapDBField returns a copy of doc with a db field of from changed to to.
+func remapDBField(doc bson.D, from, to string) bson.D {
+	remapped := make(bson.D, len(doc))
+	copy(remapped, doc)
+	for i, elem := range remapped {
+		if elem.Key == "db" && elem.Value == from {
+			remapped[i].Value = to
+		}
+	}
+	return remapped
+}
@@ -0,0 +1,91 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRemapAuthDocuments(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	restrictions := bson.A{bson.D{{Key: "clientSource", Value: bson.A{"10.0.0.0/8"}}}}
+	user := bson.D{
+		{Key: "_id", Value: "tenantA.alice"},
+		{Key: "userId", Value: "uuid"},
+		{Key: "user", Value: "alice"},
+		{Key: "db", Value: "tenantA"},
+		{Key: "credentials", Value: bson.D{{Key: "SCRAM-SHA-256", Value: "hash"}}},
+		{Key: "customData", Value: bson.D{{Key: "team", Value: "billing"}}},
+		{Key: "roles", Value: bson.A{
+			bson.D{{Key: "role", Value: "readWrite"}, {Key: "db", Value: "tenantA"}},
+			bson.D{{Key: "role", Value: "read"}, {Key: "db", Value: "reporting"}},
+		}},
+		{Key: "authenticationRestrictions", Value: restrictions},
+	}
+
+	Convey("A remapped user refers to the new database, keeping its auth data", t, func() {
+		remapped := remapAuthDocument(user, "tenantA", "tenantB")
+		So(remapped, ShouldResemble, bson.D{
+			{Key: "_id", Value: "tenantB.alice"},
+			{Key: "user", Value: "alice"},
+			{Key: "db", Value: "tenantB"},
+			{Key: "credentials", Value: bson.D{{Key: "SCRAM-SHA-256", Value: "hash"}}},
+			{Key: "customData", Value: bson.D{{Key: "team", Value: "billing"}}},
+			{Key: "roles", Value: bson.A{
+				bson.D{{Key: "role", Value: "readWrite"}, {Key: "db", Value: "tenantB"}},
+				bson.D{{Key: "role", Value: "read"}, {Key: "db", Value: "reporting"}},
+			}},
+			{Key: "authenticationRestrictions", Value: restrictions},
+		})
+		// the original is unchanged
+		So(user[0].Value, ShouldEqual, "tenantA.alice")
+	})
+
+	Convey("A remapped role's privileges on its database are moved with it", t, func() {
+		role := bson.D{
+			{Key: "_id", Value: "tenantA.auditor"},
+			{Key: "role", Value: "auditor"},
+			{Key: "db", Value: "tenantA"},
+			{Key: "privileges", Value: bson.A{
+				bson.D{
+					{Key: "resource", Value: bson.D{{Key: "db", Value: "tenantA"}, {Key: "collection", Value: "logs"}}},
+					{Key: "actions", Value: bson.A{"find"}},
+				},
+			}},
+			{Key: "roles", Value: bson.A{}},
+		}
+		remapped := remapAuthDocument(role, "tenantA", "tenantB")
+		So(remapped[0].Value, ShouldEqual, "tenantB.auditor")
+		privilege := remapped[3].Value.(bson.A)[0].(bson.D)
+		So(privilege[0].Value, ShouldResemble, bson.D{{Key: "db", Value: "tenantB"}, {Key: "collection", Value: "logs"}})
+	})
+
+	Convey("A source of users is only remapped when they are of another database", t, func() {
+		raw, err := bson.Marshal(user)
+		So(err, ShouldBeNil)
+		newSource := func() *db.DecodedBSONSource {
+			return db.NewDecodedBSONSource(db.NewBSONSource(ioutil.NopCloser(bytes.NewReader(raw))))
+		}
+
+		for _, destination := range []string{"tenantA", "tenantB"} {
+			source, err := remapAuthSource(newSource(), "users", destination)
+			So(err, ShouldBeNil)
+			var doc bson.D
+			So(source.Next(&doc), ShouldBeTrue)
+			So(authDocumentDB(doc), ShouldEqual, destination)
+			So(source.Next(&doc), ShouldBeFalse)
+		}
+	})
+}
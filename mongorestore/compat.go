@@ -0,0 +1,340 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The --compatMode values, for metadata the target version doesn't
+// support: fail before restoring anything, adapt the metadata, or don't
+// restore the collections.
+const (
+	compatStrict = "strict"
+	compatAdapt  = "adapt"
+	compatSkip   = "skip"
+)
+
+// compatIssue is metadata of a collection that the target's version
+// doesn't support.
+type compatIssue struct {
+	problem string
+	// adaptation describes what fix does to the metadata; fix is nil if the
+	// collection can't be restored to the target
+	adaptation string
+	fix        func(metadata *Metadata)
+}
+
+// collectionOptionRule is a collection option that servers before since,
+// or from until on, don't support.
+type collectionOptionRule struct {
+	option       string
+	since, until db.Version
+	adaptation   string
+	fix          func(options *bson.D)
+}
+
+var collectionOptionRules = []collectionOptionRule{
+	{option: "timeseries", since: db.Version{5, 0, 0}},
+	{option: "encryptedFields", since: db.Version{7, 0, 0}},
+	{option: "clusteredIndex", since: db.Version{5, 3, 0},
+		adaptation: "creating it as a collection clustered by the default _id index, without expiry",
+		fix: func(options *bson.D) {
+			bsonutil.RemoveKey("clusteredIndex", options)
+			bsonutil.RemoveKey("expireAfterSeconds", options)
+		}},
+	{option: "changeStreamPreAndPostImages", since: db.Version{6, 0, 0},
+		adaptation: "not recording pre- and post-images",
+		fix: func(options *bson.D) {
+			bsonutil.RemoveKey("changeStreamPreAndPostImages", options)
+		}},
+	{option: "recordPreImages", until: db.Version{6, 0, 0},
+		adaptation: "recording pre- and post-images with changeStreamPreAndPostImages instead",
+		fix: func(options *bson.D) {
+			enabled, _ := bsonutil.FindValueByKey("recordPreImages", options)
+			bsonutil.RemoveKey("recordPreImages", options)
+			if enabled == true {
+				*options = append(*options, bson.E{Key: "changeStreamPreAndPostImages", Value: bson.D{{Key: "enabled", Value: true}}})
+			}
+		}},
+	{option: "flags", until: db.Version{4, 2, 0},
+		adaptation: "removing the MMAPv1 allocation flags",
+		fix: func(options *bson.D) {
+			bsonutil.RemoveKey("flags", options)
+		}},
+}
+
+// versionString returns the major and minor version of a server.
+func versionString(version db.Version) string {
+	return fmt.Sprintf("%v.%v", version[0], version[1])
+}
+
+// compatIssues returns the metadata of a collection that servers of
+// version don't support.
+func compatIssues(metadata *Metadata, version db.Version) []compatIssue {
+	var issues []compatIssue
+	for _, rule := range collectionOptionRules {
+		if _, err := bsonutil.FindValueByKey(rule.option, &metadata.Options); err != nil {
+			continue
+		}
+		var problem string
+		switch {
+		case rule.since != (db.Version{}) && version.LT(rule.since):
+			problem = fmt.Sprintf("the collection option %v requires MongoDB %v", rule.option, versionString(rule.since))
+		case rule.until != (db.Version{}) && version.GTE(rule.until):
+			problem = fmt.Sprintf("the collection option %v was removed in MongoDB %v", rule.option, versionString(rule.until))
+		default:
+			continue
+		}
+		issue := compatIssue{problem: problem, adaptation: rule.adaptation}
+		if rule.fix != nil {
+			fix := rule.fix
+			issue.fix = func(metadata *Metadata) { fix(&metadata.Options) }
+		}
+		issues = append(issues, issue)
+	}
+	if types := deprecatedTypes(metadata.Options); len(types) > 0 {
+		issues = append(issues, compatIssue{
+			problem:    fmt.Sprintf("the collection options contain the deprecated BSON types %v", strings.Join(types, ", ")),
+			adaptation: "converting them",
+			fix: func(metadata *Metadata) {
+				metadata.Options = convertDeprecatedTypes(metadata.Options).(bson.D)
+			},
+		})
+	}
+	for _, index := range metadata.Indexes {
+		issues = append(issues, indexCompatIssues(index, version)...)
+	}
+	return issues
+}
+
+// indexCompatIssues returns the options and keys of an index that servers
+// of version don't support.
+func indexCompatIssues(index IndexDocument, version db.Version) []compatIssue {
+	name := fmt.Sprint(index.Options["name"])
+	var issues []compatIssue
+	dropIndex := func(metadata *Metadata) {
+		var kept []IndexDocument
+		for _, other := range metadata.Indexes {
+			if fmt.Sprint(other.Options["name"]) != name {
+				kept = append(kept, other)
+			}
+		}
+		metadata.Indexes = kept
+	}
+	removeOption := func(option string) func(metadata *Metadata) {
+		return func(metadata *Metadata) {
+			for _, other := range metadata.Indexes {
+				if fmt.Sprint(other.Options["name"]) == name {
+					delete(other.Options, option)
+				}
+			}
+		}
+	}
+
+	var hashed, wildcard, haystack bool
+	for _, key := range index.Key {
+		switch {
+		case key.Value == "hashed":
+			hashed = true
+		case key.Value == "geoHaystack":
+			haystack = true
+		case key.Key == "$**" || strings.HasSuffix(key.Key, ".$**"):
+			wildcard = true
+		}
+	}
+	switch {
+	case haystack && version.GTE(db.Version{5, 0, 0}):
+		issues = append(issues, compatIssue{
+			problem:    fmt.Sprintf("index %v is a geoHaystack index, which was removed in MongoDB 5.0", name),
+			adaptation: "not building it; a 2d index can replace it",
+			fix:        dropIndex,
+		})
+	case wildcard && version.LT(db.Version{4, 2, 0}):
+		issues = append(issues, compatIssue{
+			problem:    fmt.Sprintf("index %v is a wildcard index, which requires MongoDB 4.2", name),
+			adaptation: "not building it",
+			fix:        dropIndex,
+		})
+	case hashed && len(index.Key) > 1 && version.LT(db.Version{4, 4, 0}):
+		issues = append(issues, compatIssue{
+			problem:    fmt.Sprintf("index %v is a compound hashed index, which requires MongoDB 4.4", name),
+			adaptation: "not building it",
+			fix:        dropIndex,
+		})
+	}
+	if _, ok := index.Options["hidden"]; ok && version.LT(db.Version{4, 4, 0}) {
+		issues = append(issues, compatIssue{
+			problem:    fmt.Sprintf("the hidden option of index %v requires MongoDB 4.4", name),
+			adaptation: "building it as a visible index",
+			fix:        removeOption("hidden"),
+		})
+	}
+	if _, ok := index.Options["dropDups"]; ok && version.GTE(db.Version{3, 0, 0}) {
+		issues = append(issues, compatIssue{
+			problem:    fmt.Sprintf("the dropDups option of index %v was removed in MongoDB 3.0", name),
+			adaptation: "removing it",
+			fix:        removeOption("dropDups"),
+		})
+	}
+	if types := deprecatedTypes(index.PartialFilterExpression); len(types) > 0 {
+		issues = append(issues, compatIssue{
+			problem: fmt.Sprintf("the partial filter expression of index %v contains the deprecated BSON types %v",
+				name, strings.Join(types, ", ")),
+			adaptation: "converting them",
+			fix: func(metadata *Metadata) {
+				for i, other := range metadata.Indexes {
+					if fmt.Sprint(other.Options["name"]) == name {
+						metadata.Indexes[i].PartialFilterExpression = convertDeprecatedTypes(other.PartialFilterExpression).(bson.D)
+					}
+				}
+			},
+		})
+	}
+	return issues
+}
+
+// deprecatedTypes returns the names of the deprecated BSON types in value.
+func deprecatedTypes(value interface{}) []string {
+	found := map[string]bool{}
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch value := value.(type) {
+		case bson.D:
+			for _, elem := range value {
+				walk(elem.Value)
+			}
+		case bson.A:
+			for _, elem := range value {
+				walk(elem)
+			}
+		case primitive.Symbol:
+			found["symbol"] = true
+		case primitive.Undefined:
+			found["undefined"] = true
+		case primitive.DBPointer:
+			found["dbPointer"] = true
+		}
+	}
+	walk(value)
+	var types []string
+	for name := range found {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// convertDeprecatedTypes returns value with the deprecated BSON types
+// replaced by their modern equivalents: symbols by strings, undefined by
+// null and DBPointers by DBRefs.
+func convertDeprecatedTypes(value interface{}) interface{} {
+	switch value := value.(type) {
+	case bson.D:
+		converted := make(bson.D, 0, len(value))
+		for _, elem := range value {
+			converted = append(converted, bson.E{Key: elem.Key, Value: convertDeprecatedTypes(elem.Value)})
+		}
+		return converted
+	case bson.A:
+		converted := make(bson.A, 0, len(value))
+		for _, elem := range value {
+			converted = append(converted, convertDeprecatedTypes(elem))
+		}
+		return converted
+	case primitive.Symbol:
+		return string(value)
+	case primitive.Undefined:
+		return nil
+	case primitive.DBPointer:
+		return bson.D{{Key: "$ref", Value: value.DB}, {Key: "$id", Value: value.Pointer}}
+	}
+	return value
+}
+
+// checkCompatibility checks the metadata of the collections to restore
+// against the target's version before anything is restored, with
+// --compatMode. Under strict any incompatibility is an error, under adapt
+// only those that can't be adapted are, and under skip the collections
+// with any are recorded to be skipped.
+func (restore *MongoRestore) checkCompatibility() error {
+	mode := restore.OutputOptions.CompatMode
+	if mode == "" || restore.serverVersion == (db.Version{}) {
+		return nil
+	}
+	restore.compatSkipped = map[string]bool{}
+	var problems []string
+	for _, intent := range restore.manager.Intents() {
+		if intent.MetadataFile == nil {
+			continue
+		}
+		metadata, err := restore.readPlanMetadata(intent)
+		if err != nil {
+			return err
+		}
+		if metadata == nil {
+			continue
+		}
+		for _, issue := range compatIssues(metadata, restore.serverVersion) {
+			switch {
+			case mode == compatSkip:
+				log.Logvf(log.Always, "warning: not restoring %v: %v", intent.Namespace(), issue.problem)
+				restore.compatSkipped[intent.Namespace()] = true
+			case mode == compatStrict || issue.fix == nil:
+				problems = append(problems, fmt.Sprintf("%v: %v", intent.Namespace(), issue.problem))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("the dump is incompatible with the MongoDB %v target:\n  %v",
+			versionString(restore.serverVersion), strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// compatProblems describes the incompatibilities of a collection's metadata
+// with the target for --dryRun, and what --compatMode does about them.
+func (restore *MongoRestore) compatProblems(ns string, metadata *Metadata) []string {
+	if restore.serverVersion == (db.Version{}) {
+		return nil
+	}
+	var problems []string
+	for _, issue := range compatIssues(metadata, restore.serverVersion) {
+		problem := fmt.Sprintf("%v: %v", ns, issue.problem)
+		switch {
+		case restore.OutputOptions.CompatMode == compatSkip:
+			problem += "; the collection won't be restored"
+		case restore.OutputOptions.CompatMode == compatAdapt && issue.fix != nil:
+			problem += "; adapted by " + issue.adaptation
+		}
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// adaptMetadata adapts the metadata of a collection to the target's version
+// with --compatMode adapt. Metadata that can't be adapted was reported by
+// checkCompatibility.
+func (restore *MongoRestore) adaptMetadata(ns string, metadata *Metadata, intentLog *log.FieldLogger) {
+	if restore.OutputOptions.CompatMode != compatAdapt || restore.serverVersion == (db.Version{}) {
+		return
+	}
+	for _, issue := range compatIssues(metadata, restore.serverVersion) {
+		if issue.fix != nil {
+			intentLog.Logvf(log.Always, "warning: %v: %v; %v", ns, issue.problem, issue.adaptation)
+			issue.fix(metadata)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func compatMetadata() *Metadata {
+	return &Metadata{
+		Options: bson.D{
+			{Key: "recordPreImages", Value: true},
+			{Key: "validator", Value: bson.D{{Key: "kind", Value: primitive.Symbol("order")}}},
+		},
+		Indexes: []IndexDocument{
+			{Options: bson.M{"name": "_id_"}, Key: bson.D{{Key: "_id", Value: int32(1)}}},
+			{Options: bson.M{"name": "place_haystack", "bucketSize": int32(1)},
+				Key: bson.D{{Key: "place", Value: "geoHaystack"}, {Key: "type", Value: int32(1)}}},
+			{Options: bson.M{"name": "sku_1", "hidden": true}, Key: bson.D{{Key: "sku", Value: int32(1)}}},
+		},
+	}
+}
+
+func TestCompatIssues(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	problems := func(metadata *Metadata, version db.Version) []string {
+		var problems []string
+		for _, issue := range compatIssues(metadata, version) {
+			problems = append(problems, issue.problem)
+		}
+		return problems
+	}
+
+	Convey("Metadata is checked against the target's version", t, func() {
+		So(problems(compatMetadata(), db.Version{6, 0, 3}), ShouldResemble, []string{
+			"the collection option recordPreImages was removed in MongoDB 6.0",
+			"the collection options contain the deprecated BSON types symbol",
+			"index place_haystack is a geoHaystack index, which was removed in MongoDB 5.0",
+		})
+		So(problems(compatMetadata(), db.Version{4, 2, 0}), ShouldResemble, []string{
+			"the collection options contain the deprecated BSON types symbol",
+			"the hidden option of index sku_1 requires MongoDB 4.4",
+		})
+	})
+
+	Convey("Time-series collections can't be adapted to servers before 5.0", t, func() {
+		metadata := &Metadata{Options: bson.D{{Key: "timeseries", Value: bson.D{{Key: "timeField", Value: "t"}}}}}
+		issues := compatIssues(metadata, db.Version{4, 4, 0})
+		So(issues, ShouldHaveLength, 1)
+		So(issues[0].fix, ShouldBeNil)
+		So(compatIssues(metadata, db.Version{5, 0, 0}), ShouldBeEmpty)
+	})
+
+	Convey("Under adapt, the metadata is changed to what the target supports", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{CompatMode: compatAdapt}, serverVersion: db.Version{6, 0, 3}}
+		metadata := compatMetadata()
+		restore.adaptMetadata("test.c", metadata, log.WithFields())
+		So(metadata.Options, ShouldResemble, bson.D{
+			{Key: "validator", Value: bson.D{{Key: "kind", Value: "order"}}},
+			{Key: "changeStreamPreAndPostImages", Value: bson.D{{Key: "enabled", Value: true}}},
+		})
+		So(metadata.Indexes, ShouldHaveLength, 2)
+		So(metadata.Indexes[1].Options["name"], ShouldEqual, "sku_1")
+		So(compatIssues(metadata, restore.serverVersion), ShouldBeEmpty)
+
+		Convey("and the dry run reports what is adapted", func() {
+			So(restore.compatProblems("test.c", compatMetadata()), ShouldContain,
+				"test.c: index place_haystack is a geoHaystack index, which was removed in MongoDB 5.0; "+
+					"adapted by not building it; a 2d index can replace it")
+		})
+	})
+
+	Convey("Without --compatMode, the metadata isn't changed", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}, serverVersion: db.Version{6, 0, 3}}
+		metadata := compatMetadata()
+		restore.adaptMetadata("test.c", metadata, log.WithFields())
+		So(metadata, ShouldResemble, compatMetadata())
+	})
+}
+
+func TestConvertDeprecatedTypes(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Deprecated BSON types are found and converted in nested documents and arrays", t, func() {
+		oid := primitive.NewObjectID()
+		value := bson.D{
+			{Key: "a", Value: bson.A{primitive.Undefined{}, primitive.Symbol("s")}},
+			{Key: "b", Value: bson.D{{Key: "ref", Value: primitive.DBPointer{DB: "test.c", Pointer: oid}}}},
+			{Key: "c", Value: int32(1)},
+		}
+		So(deprecatedTypes(value), ShouldResemble, []string{"dbPointer", "symbol", "undefined"})
+		converted := convertDeprecatedTypes(value)
+		So(converted, ShouldResemble, bson.D{
+			{Key: "a", Value: bson.A{nil, "s"}},
+			{Key: "b", Value: bson.D{{Key: "ref", Value: bson.D{{Key: "$ref", Value: "test.c"}, {Key: "$id", Value: oid}}}}},
+			{Key: "c", Value: int32(1)},
+		})
+		So(deprecatedTypes(converted), ShouldBeEmpty)
+	})
+}
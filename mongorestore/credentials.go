@@ -0,0 +1,201 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"github.com/xdg/stringprep"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// The --unsupportedCredentials actions for users whose credentials the
+// target can't authenticate them with.
+const (
+	credentialsWarn       = "warn"
+	credentialsSkip       = "skip"
+	credentialsRegenerate = "regenerate"
+)
+
+const (
+	mechanismMongoCR     = "MONGODB-CR"
+	mechanismSCRAMSHA1   = "SCRAM-SHA-1"
+	mechanismSCRAMSHA256 = "SCRAM-SHA-256"
+)
+
+// initCredentials checks --unsupportedCredentials and reads the passwords
+// of --userPasswordFile.
+func (restore *MongoRestore) initCredentials() error {
+	options := restore.OutputOptions
+	switch {
+	case options.UnsupportedCredentials == credentialsRegenerate && options.UserPasswordFile == "":
+		return fmt.Errorf("cannot use %v=%v without %v", UnsupportedCredentialsOption, credentialsRegenerate, UserPasswordFileOption)
+	case options.UserPasswordFile != "" && options.UnsupportedCredentials != credentialsRegenerate:
+		return fmt.Errorf("cannot use %v without %v=%v", UserPasswordFileOption, UnsupportedCredentialsOption, credentialsRegenerate)
+	case options.UserPasswordFile == "":
+		return nil
+	}
+	content, err := util.ReadConfigFile(options.UserPasswordFile)
+	if err != nil {
+		return fmt.Errorf("error reading %v: %v", UserPasswordFileOption, err)
+	}
+	if err = json.Unmarshal(content, &restore.userPasswords); err != nil {
+		return fmt.Errorf("error parsing %v: %v", UserPasswordFileOption, err)
+	}
+	return nil
+}
+
+// supportedMechanisms returns the credential mechanisms servers of version
+// authenticate users with: MONGODB-CR before 4.0, SCRAM-SHA-1 from 3.0 and
+// SCRAM-SHA-256 from 4.0.
+func supportedMechanisms(version db.Version) map[string]bool {
+	return map[string]bool{
+		mechanismMongoCR:     version.LT(db.Version{4, 0, 0}),
+		mechanismSCRAMSHA1:   version.GTE(db.Version{3, 0, 0}),
+		mechanismSCRAMSHA256: version.GTE(db.Version{4, 0, 0}),
+	}
+}
+
+// unsupportedCredentials returns the mechanisms of the credentials of a
+// user if the target supports none of them, or nil if it supports one of
+// them or the user is authenticated externally.
+func unsupportedCredentials(user bson.D, supported map[string]bool) []string {
+	credentials, err := bsonutil.FindSubdocumentByKey("credentials", &user)
+	if err != nil {
+		return nil
+	}
+	var mechanisms []string
+	for _, credential := range credentials {
+		if credential.Key == "external" || supported[credential.Key] {
+			return nil
+		}
+		mechanisms = append(mechanisms, credential.Key)
+	}
+	sort.Strings(mechanisms)
+	return mechanisms
+}
+
+// checkUserCredentials returns the users of source with the unusable
+// credentials of --unsupportedCredentials handled: the users that the
+// target can't authenticate with any of their credentials are restored with
+// a warning, skipped, or given credentials generated from their passwords
+// in --userPasswordFile.
+func (restore *MongoRestore) checkUserCredentials(source *db.DecodedBSONSource) (*db.DecodedBSONSource, error) {
+	supported := supportedMechanisms(restore.serverVersion)
+	var buffer bytes.Buffer
+	for {
+		var user bson.D
+		if !source.Next(&user) {
+			break
+		}
+		if mechanisms := unsupportedCredentials(user, supported); mechanisms != nil {
+			id, _ := bsonutil.FindStringValueByKey("_id", &user)
+			switch restore.OutputOptions.UnsupportedCredentials {
+			case credentialsSkip:
+				log.Logvf(log.Always, "skipping user %v, whose %v credentials a %v server doesn't support",
+					id, strings.Join(mechanisms, " and "), restore.serverVersion)
+				continue
+			case credentialsRegenerate:
+				password, ok := restore.userPasswords[id]
+				if !ok {
+					log.Logvf(log.Always, "warning: user %v has only %v credentials, which a %v server doesn't support, "+
+						"and no password in %v; it won't be able to authenticate", id, strings.Join(mechanisms, " and "),
+						restore.serverVersion, UserPasswordFileOption)
+					break
+				}
+				name, _ := bsonutil.FindStringValueByKey("user", &user)
+				credentials, err := generateCredentials(name, password, supported)
+				if err != nil {
+					return nil, fmt.Errorf("error generating the credentials of user %v: %v", id, err)
+				}
+				log.Logvf(log.Info, "regenerating the credentials of user %v", id)
+				bsonutil.RemoveKey("credentials", &user)
+				user = append(user, bson.E{Key: "credentials", Value: credentials})
+			default:
+				log.Logvf(log.Always, "warning: user %v has only %v credentials, which a %v server doesn't support; "+
+					"it won't be able to authenticate", id, strings.Join(mechanisms, " and "), restore.serverVersion)
+			}
+		}
+		raw, err := bson.Marshal(user)
+		if err != nil {
+			return nil, fmt.Errorf("error restoring users: %v", err)
+		}
+		buffer.Write(raw)
+	}
+	if err := source.Err(); err != nil {
+		return nil, fmt.Errorf("error reading users: %v", err)
+	}
+	return db.NewDecodedBSONSource(db.NewBSONSource(ioutil.NopCloser(&buffer))), nil
+}
+
+// generateCredentials returns the SCRAM credentials of the supported
+// mechanisms for a user with the given password.
+func generateCredentials(user, password string, supported map[string]bool) (bson.D, error) {
+	var credentials bson.D
+	if supported[mechanismSCRAMSHA1] {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		// SCRAM-SHA-1 hashes the digest of MONGODB-CR rather than the password
+		digest := md5.Sum([]byte(user + ":mongo:" + password))
+		credentials = append(credentials, bson.E{Key: mechanismSCRAMSHA1,
+			Value: scramCredential(sha1.New, hex.EncodeToString(digest[:]), salt, 10000)})
+	}
+	if supported[mechanismSCRAMSHA256] {
+		prepared, err := stringprep.SASLprep.Prepare(password)
+		if err != nil {
+			return nil, fmt.Errorf("error preparing password: %v", err)
+		}
+		salt := make([]byte, 28)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, bson.E{Key: mechanismSCRAMSHA256,
+			Value: scramCredential(sha256.New, prepared, salt, 15000)})
+	}
+	return credentials, nil
+}
+
+// scramCredential returns the stored SCRAM credential of a password, as the
+// server keeps it in the credentials of a user.
+func scramCredential(h func() hash.Hash, password string, salt []byte, iterations int) bson.D {
+	salted := pbkdf2.Key([]byte(password), salt, iterations, h().Size(), h)
+	clientKey := hmacSum(h, salted, "Client Key")
+	stored := h()
+	stored.Write(clientKey)
+	return bson.D{
+		{Key: "iterationCount", Value: int32(iterations)},
+		{Key: "salt", Value: base64.StdEncoding.EncodeToString(salt)},
+		{Key: "storedKey", Value: base64.StdEncoding.EncodeToString(stored.Sum(nil))},
+		{Key: "serverKey", Value: base64.StdEncoding.EncodeToString(hmacSum(h, salted, "Server Key"))},
+	}
+}
+
+func hmacSum(h func() hash.Hash, key []byte, message string) []byte {
+	mac := hmac.New(h, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
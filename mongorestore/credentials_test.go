@@ -0,0 +1,166 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func testUser(name string, credentials bson.D) bson.D {
+	return bson.D{
+		{Key: "_id", Value: "admin." + name},
+		{Key: "user", Value: name},
+		{Key: "db", Value: "admin"},
+		{Key: "credentials", Value: credentials},
+		{Key: "roles", Value: bson.A{}},
+	}
+}
+
+func restoredUsers(source *db.DecodedBSONSource) []bson.D {
+	var users []bson.D
+	for {
+		var user bson.D
+		if !source.Next(&user) {
+			return users
+		}
+		users = append(users, user)
+	}
+}
+
+func TestUnsupportedCredentials(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	mongoCR := testUser("legacy", bson.D{{Key: "MONGODB-CR", Value: "0123456789abcdef"}})
+	scram := testUser("current", bson.D{
+		{Key: "MONGODB-CR", Value: "0123456789abcdef"},
+		{Key: "SCRAM-SHA-1", Value: bson.D{{Key: "iterationCount", Value: int32(10000)}}},
+	})
+	external := testUser("x509", bson.D{{Key: "external", Value: true}})
+
+	Convey("Users are unusable if the target supports none of their credentials", t, func() {
+		modern := supportedMechanisms(db.Version{4, 4, 0})
+		So(unsupportedCredentials(mongoCR, modern), ShouldResemble, []string{"MONGODB-CR"})
+		So(unsupportedCredentials(scram, modern), ShouldBeNil)
+		So(unsupportedCredentials(external, modern), ShouldBeNil)
+		So(unsupportedCredentials(bson.D{{Key: "_id", Value: "admin.role"}}, modern), ShouldBeNil)
+
+		legacy := supportedMechanisms(db.Version{3, 6, 0})
+		So(unsupportedCredentials(mongoCR, legacy), ShouldBeNil)
+		sha256 := testUser("new", bson.D{{Key: "SCRAM-SHA-256", Value: bson.D{}}})
+		So(unsupportedCredentials(sha256, legacy), ShouldResemble, []string{"SCRAM-SHA-256"})
+	})
+
+	source := func() *db.DecodedBSONSource {
+		var buffer bytes.Buffer
+		for _, user := range []bson.D{mongoCR, scram, external} {
+			buffer.Write(mustMarshal(user))
+		}
+		return db.NewDecodedBSONSource(db.NewBSONSource(ioutil.NopCloser(&buffer)))
+	}
+
+	Convey("Unusable users", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}, serverVersion: db.Version{4, 4, 0}}
+
+		Convey("are restored with a warning by default", func() {
+			checked, err := restore.checkUserCredentials(source())
+			So(err, ShouldBeNil)
+			So(restoredUsers(checked), ShouldResemble, []bson.D{mongoCR, scram, external})
+		})
+
+		Convey("are skipped with skip", func() {
+			restore.OutputOptions.UnsupportedCredentials = credentialsSkip
+			checked, err := restore.checkUserCredentials(source())
+			So(err, ShouldBeNil)
+			So(restoredUsers(checked), ShouldResemble, []bson.D{scram, external})
+		})
+
+		Convey("are given SCRAM credentials from their passwords with regenerate", func() {
+			restore.OutputOptions.UnsupportedCredentials = credentialsRegenerate
+			restore.userPasswords = map[string]string{"admin.legacy": "pencil"}
+			checked, err := restore.checkUserCredentials(source())
+			So(err, ShouldBeNil)
+			users := restoredUsers(checked)
+			So(users, ShouldHaveLength, 3)
+			credentials, err := bsonutil.FindSubdocumentByKey("credentials", &users[0])
+			So(err, ShouldBeNil)
+			So(credentials, ShouldHaveLength, 2)
+			So(credentials[0].Key, ShouldEqual, "SCRAM-SHA-1")
+			So(credentials[1].Key, ShouldEqual, "SCRAM-SHA-256")
+			So(users[1:], ShouldResemble, []bson.D{scram, external})
+
+			Convey("unless they have no password", func() {
+				restore.userPasswords = nil
+				checked, err := restore.checkUserCredentials(source())
+				So(err, ShouldBeNil)
+				So(restoredUsers(checked), ShouldResemble, []bson.D{mongoCR, scram, external})
+			})
+		})
+	})
+}
+
+func TestGenerateCredentials(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("The credentials of the mechanisms the target supports are generated", t, func() {
+		credentials, err := generateCredentials("user", "pencil", supportedMechanisms(db.Version{3, 6, 0}))
+		So(err, ShouldBeNil)
+		So(credentials, ShouldHaveLength, 1)
+		So(credentials[0].Key, ShouldEqual, "SCRAM-SHA-1")
+	})
+
+	Convey("A SCRAM credential has the key sizes of its hash", t, func() {
+		credentials, err := generateCredentials("user", "pencil", supportedMechanisms(db.Version{5, 0, 0}))
+		So(err, ShouldBeNil)
+		for i, sizes := range []struct{ salt, key int }{{16, 20}, {28, 32}} {
+			credential := credentials[i].Value.(bson.D)
+			So(credential[0], ShouldResemble, bson.E{Key: "iterationCount", Value: int32([]int{10000, 15000}[i])})
+			for j, size := range []int{sizes.salt, sizes.key, sizes.key} {
+				decoded, err := base64.StdEncoding.DecodeString(credential[j+1].Value.(string))
+				So(err, ShouldBeNil)
+				So(decoded, ShouldHaveLength, size)
+			}
+		}
+	})
+}
+
+func TestInitCredentials(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "passwords.yaml")
+	if err = ioutil.WriteFile(path, []byte("admin.legacy: pencil\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("regenerate and --userPasswordFile require each other", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{UnsupportedCredentials: credentialsRegenerate}}
+		So(restore.initCredentials(), ShouldNotBeNil)
+		restore.OutputOptions = &OutputOptions{UnsupportedCredentials: credentialsSkip, UserPasswordFile: path}
+		So(restore.initCredentials(), ShouldNotBeNil)
+	})
+
+	Convey("The passwords are read from the file", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{UnsupportedCredentials: credentialsRegenerate, UserPasswordFile: path}}
+		So(restore.initCredentials(), ShouldBeNil)
+		So(restore.userPasswords, ShouldResemble, map[string]string{"admin.legacy": "pencil"})
+	})
+}
@@ -0,0 +1,66 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/mongodb/mongo-tools/common/delta"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// deltaReadCloser reads an archive reconstructed from a delta, and closes
+// the delta's base.
+type deltaReadCloser struct {
+	*delta.Reader
+	base *os.File
+}
+
+func (r *deltaReadCloser) Close() error {
+	return r.base.Close()
+}
+
+// applyDelta returns the archive read from rc, reconstructing it from
+// --deltaBase if rc is a delta written by mongodump --deltaBase.
+func (restore *MongoRestore) applyDelta(rc io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(rc)
+	// an archive shorter than the magic can't be a delta
+	start, _ := buffered.Peek(len(delta.Magic))
+	isDelta := delta.IsDelta(start)
+	switch {
+	case !isDelta && restore.InputOptions.DeltaBase == "":
+		return &util.WrappedReadCloser{ioutil.NopCloser(buffered), rc}, nil
+	case !isDelta:
+		rc.Close()
+		return nil, fmt.Errorf("--deltaBase was given, but the archive is not a delta")
+	case restore.InputOptions.DeltaBase == "":
+		rc.Close()
+		return nil, fmt.Errorf("the archive is a delta from another archive, which must be given with --deltaBase")
+	}
+
+	base, err := os.Open(restore.InputOptions.DeltaBase)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("error opening --deltaBase: %v", err)
+	}
+	info, err := base.Stat()
+	if err == nil {
+		var reader *delta.Reader
+		if reader, err = delta.NewReader(buffered, base, info.Size()); err == nil {
+			log.Logvf(log.DebugLow, "reconstructing the archive from %v", restore.InputOptions.DeltaBase)
+			return &util.WrappedReadCloser{&deltaReadCloser{reader, base}, rc}, nil
+		}
+	}
+	base.Close()
+	rc.Close()
+	return nil, fmt.Errorf("error reading --deltaBase: %v", err)
+}
@@ -0,0 +1,72 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/delta"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestApplyDelta(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a delta written from a base archive", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_delta")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		base := bytes.Repeat([]byte("the base archive "), 5000)
+		basePath := filepath.Join(dir, "base.archive")
+		So(ioutil.WriteFile(basePath, base, 0644), ShouldBeNil)
+		archive := append(append([]byte("new prelude "), base...), []byte(" new collection")...)
+
+		sig, err := delta.NewSignature(bytes.NewReader(base), 4096)
+		So(err, ShouldBeNil)
+		var out bytes.Buffer
+		writer, err := delta.NewWriter(&out, sig)
+		So(err, ShouldBeNil)
+		_, err = writer.Write(archive)
+		So(err, ShouldBeNil)
+		So(writer.Close(), ShouldBeNil)
+
+		restore := newMongoRestore()
+
+		Convey("the archive is reconstructed with --deltaBase", func() {
+			restore.InputOptions.DeltaBase = basePath
+			rc, err := restore.applyDelta(ioutil.NopCloser(&out))
+			So(err, ShouldBeNil)
+			reconstructed, err := ioutil.ReadAll(rc)
+			So(err, ShouldBeNil)
+			So(rc.Close(), ShouldBeNil)
+			So(reconstructed, ShouldResemble, archive)
+		})
+
+		Convey("a delta can't be restored without --deltaBase", func() {
+			_, err := restore.applyDelta(ioutil.NopCloser(&out))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("an archive that isn't a delta is read as is without --deltaBase", func() {
+			rc, err := restore.applyDelta(ioutil.NopCloser(bytes.NewReader(archive)))
+			So(err, ShouldBeNil)
+			read, err := ioutil.ReadAll(rc)
+			So(err, ShouldBeNil)
+			So(read, ShouldResemble, archive)
+
+			restore.InputOptions.DeltaBase = basePath
+			_, err = restore.applyDelta(ioutil.NopCloser(bytes.NewReader(archive)))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,406 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/text"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Operations of a restore plan
+const (
+	planDrop         = "drop"
+	planCreate       = "create"
+	planShard        = "shard"
+	planUseExisting  = "use existing"
+	planInsert       = "insert"
+	planBuildIndexes = "build indexes"
+	planCreateView   = "create view"
+	planRestoreAuth  = "restore auth"
+	planReplayOplog  = "replay oplog"
+)
+
+// planStep is an operation that the restore would run, as printed by
+// --dryRun.
+type planStep struct {
+	Operation string
+	Namespace string
+	Detail    string
+}
+
+// restorePlan is the sequence of operations of a restore, and the problems
+// found in the dump and target while planning it.
+type restorePlan struct {
+	Steps    []planStep
+	Problems []string
+	// IndexKeys is the estimated number of index keys to build, if known
+	IndexKeys int64
+}
+
+func (plan *restorePlan) add(operation, namespace, detail string, a ...interface{}) {
+	plan.Steps = append(plan.Steps, planStep{Operation: operation, Namespace: namespace, Detail: fmt.Sprintf(detail, a...)})
+}
+
+// collectionPlan is what planCollection needs to know about a collection of
+// the dump and its target.
+type collectionPlan struct {
+	intent   *intents.Intent
+	metadata *Metadata
+	// exists and targetDocuments describe the target collection
+	exists          bool
+	targetDocuments int64
+	// documents is the count from the dump's manifest, if it has one
+	documents *int64
+}
+
+// dryRun prints the plan of the restore without writing anything, and fails
+// if the dump or target has problems that would fail the restore.
+func (restore *MongoRestore) dryRun() error {
+	plan := restore.restorePlan()
+	out := restore.planOut
+	if out == nil {
+		out = os.Stdout
+	}
+	plan.print(out)
+	if len(plan.Problems) > 0 {
+		return fmt.Errorf("dry run found %v %v", len(plan.Problems), util.Pluralize(len(plan.Problems), "problem", "problems"))
+	}
+	return nil
+}
+
+// restorePlan reads the metadata of every collection of the dump and the
+// state of the target to plan the restore.
+func (restore *MongoRestore) restorePlan() *restorePlan {
+	plan := &restorePlan{}
+	var manifestRoot string
+	var manifest map[string]*verifyManifestFile
+	if restore.InputOptions.Archive == "" && restore.TargetDirectory != "-" {
+		// document counts are only estimated without a manifest
+		manifestRoot, manifest, _ = restore.readVerifyManifest()
+	}
+
+	var collections []*intents.Intent
+	for _, intent := range restore.manager.Intents() {
+		if intent.IsOplog() || intent.IsSpecialCollection() || intent.IsSystemIndexes() {
+			continue
+		}
+		collections = append(collections, intent)
+	}
+	sort.Slice(collections, func(i, j int) bool { return collections[i].Namespace() < collections[j].Namespace() })
+
+	// views are planned after the collections, in the order they are created
+	var views []*pendingView
+	viewSteps := map[string]planStep{}
+	var deferred restorePlan
+	for _, intent := range collections {
+		c := collectionPlan{intent: intent}
+		var err error
+		if c.exists, err = restore.CollectionExists(intent); err != nil {
+			plan.Problems = append(plan.Problems, fmt.Sprintf("%v: error reading the target: %v", intent.Namespace(), err))
+			continue
+		}
+		if c.exists {
+			if c.targetDocuments, err = restore.targetDocumentCount(intent); err != nil {
+				plan.Problems = append(plan.Problems, fmt.Sprintf("%v: error counting the target's documents: %v", intent.Namespace(), err))
+			}
+		}
+		if intent.MetadataFile != nil {
+			if c.metadata, err = restore.readPlanMetadata(intent); err != nil {
+				plan.Problems = append(plan.Problems, fmt.Sprintf("%v: %v", intent.Namespace(), err))
+				continue
+			}
+		}
+		if manifest != nil && intent.Location != "" {
+			if file := manifestEntryFor(manifest, relativeLocation(manifestRoot, intent.Location)); file != nil {
+				c.documents = file.Documents
+			}
+		}
+		if c.metadata != nil {
+			plan.Problems = append(plan.Problems, restore.compatProblems(intent.Namespace(), c.metadata)...)
+		}
+		collection := restore.planCollection(c)
+		for _, step := range collection.Steps {
+			switch {
+			case step.Operation == planCreateView:
+				var view *pendingView
+				if c.metadata != nil {
+					view = restore.newPendingView(intent, c.metadata.Options, c.metadata.ViewDependencies)
+				} else {
+					view = &pendingView{intent: intent}
+				}
+				views = append(views, view)
+				viewSteps[step.Namespace] = step
+			case step.Operation == planBuildIndexes && restore.OutputOptions.DeferIndexBuilds:
+				deferred.Steps = append(deferred.Steps, step)
+			default:
+				plan.Steps = append(plan.Steps, step)
+			}
+		}
+		plan.Problems = append(plan.Problems, collection.Problems...)
+		plan.IndexKeys += collection.IndexKeys
+	}
+	plan.Steps = append(plan.Steps, deferred.Steps...)
+	if ordered, err := orderViews(views); err != nil {
+		plan.Problems = append(plan.Problems, err.Error())
+	} else {
+		views = ordered
+	}
+	for _, view := range views {
+		plan.Steps = append(plan.Steps, viewSteps[view.intent.Namespace()])
+	}
+
+	if restore.ShouldRestoreUsersAndRoles() {
+		for _, intent := range []*intents.Intent{restore.manager.Users(), restore.manager.Roles()} {
+			if intent != nil {
+				plan.add(planRestoreAuth, intent.Namespace(), "from %v", intent.Location)
+			}
+		}
+	}
+	if oplog := restore.manager.Oplog(); restore.InputOptions.OplogReplay && oplog != nil {
+		detail := fmt.Sprintf("%v from %v", text.FormatByteAmount(oplog.Size), oplog.Location)
+		switch {
+		case restore.InputOptions.OplogLimit != "":
+			detail += ", entries before " + restore.InputOptions.OplogLimit
+		case restore.InputOptions.OplogReplayUntil != "":
+			detail += ", entries up to " + restore.InputOptions.OplogReplayUntil
+		}
+		if restore.oplogFilter != nil {
+			detail += ", filtered by namespace and operation"
+		}
+		plan.add(planReplayOplog, "", "%v", detail)
+	}
+	return plan
+}
+
+// shardingDetail describes how --shardCollections shards a collection.
+func shardingDetail(sharding *archive.ShardingMetadata, writeToShards bool) string {
+	key, err := bson.MarshalExtJSON(sharding.Key, false, false)
+	if err != nil {
+		key = []byte(fmt.Sprint(sharding.Key))
+	}
+	chunks := len(sharding.SplitPoints) + 1
+	detail := fmt.Sprintf("on key %s, %v %v", key, chunks, util.Pluralize(chunks, "chunk", "chunks"))
+	if isHashedKey(sharding.Key) {
+		detail += " distributed by the server"
+	} else {
+		detail += " moved across the shards"
+		if writeToShards {
+			detail += ", documents written to the shards"
+		}
+	}
+	if n := len(sharding.Zones); n > 0 {
+		detail += fmt.Sprintf(", %v zone %v", n, util.Pluralize(n, "range", "ranges"))
+	}
+	return detail
+}
+
+// planCollection plans the restore of one collection, in the order of
+// restoreIntent and with the same options.
+func (restore *MongoRestore) planCollection(c collectionPlan) *restorePlan {
+	plan := &restorePlan{}
+	options := restore.OutputOptions
+	intent := c.intent
+	ns := intent.Namespace()
+
+	var collectionOptions bson.D
+	var indexes []IndexDocument
+	dataCollection := ns
+	if c.metadata != nil {
+		collectionOptions, indexes = c.metadata.Options, c.metadata.Indexes
+		if c.metadata.TimeseriesBuckets {
+			dataCollection = intent.DB + "." + db.TimeseriesBucketsPrefix + intent.C
+		}
+		if err := restore.checkTimeseriesRestore(intent, c.metadata); err != nil {
+			plan.Problems = append(plan.Problems, err.Error())
+		}
+	} else if intent.MetadataFile == nil {
+		indexes = restore.dbCollectionIndexes[intent.DB][intent.C]
+	}
+	if options.NoOptionsRestore {
+		collectionOptions = nil
+	}
+
+	exists := c.exists
+	if exists && options.Drop && !strings.HasPrefix(intent.C, "system.") {
+		plan.add(planDrop, ns, "%v %v in the target", c.targetDocuments, util.Pluralize(int(c.targetDocuments), "document", "documents"))
+		exists = false
+	}
+
+	view := isViewOptions(collectionOptions)
+	switch {
+	case exists:
+		detail := "collection already exists in the target"
+		if c.targetDocuments > 0 {
+			detail += fmt.Sprintf(" with %v %v", c.targetDocuments, util.Pluralize(int(c.targetDocuments), "document", "documents"))
+			if options.Mode == "" || options.Mode == modeInsert {
+				detail += "; documents with the same _id will fail with duplicate key errors"
+			}
+		}
+		plan.add(planUseExisting, ns, "%v", detail)
+	case view:
+		viewOn, _ := bsonutil.FindValueByKey("viewOn", &collectionOptions)
+		plan.add(planCreateView, ns, "on %v, after the collections are restored", viewOn)
+	default:
+		collectionOptions, validator := restore.collectionValidator(intent, collectionOptions)
+		detail := "with no options"
+		if len(collectionOptions) > 0 {
+			var keys []string
+			for _, option := range collectionOptions {
+				keys = append(keys, option.Key)
+			}
+			detail = "with options " + strings.Join(keys, ", ")
+		}
+		if len(validator) > 0 {
+			detail += ", validator applied after the documents are restored"
+		}
+		if restore.setsUUIDs() {
+			uuid, err := restore.collectionUUID(intent, c.metadata)
+			if err != nil {
+				plan.Problems = append(plan.Problems, err.Error())
+			} else if uuid != "" {
+				detail += ", UUID " + uuid
+			}
+		}
+		plan.add(planCreate, ns, "%v", detail)
+
+		var recorded *archive.ShardingMetadata
+		if c.metadata != nil {
+			recorded = c.metadata.Sharding
+		}
+		if sharding := restore.collectionSharding(intent, recorded); sharding != nil && dataCollection == ns {
+			plan.add(planShard, ns, "%v", shardingDetail(sharding, options.WriteToShards))
+		}
+	}
+
+	if intent.BSONFile != nil && !view && !options.MetadataOnly && !options.IndexesOnly {
+		count := "documents"
+		if c.documents != nil {
+			count = fmt.Sprintf("%v %v", *c.documents, util.Pluralize(int(*c.documents), "document", "documents"))
+		}
+		mode := options.Mode
+		if mode == "" {
+			mode = modeInsert
+		}
+		detail := fmt.Sprintf("%v (%v) from %v, mode %v", count, text.FormatByteAmount(intent.Size), intent.Location, mode)
+		if dataCollection != ns {
+			detail += ", into " + dataCollection
+		}
+		if writes := restore.writesFor(dataCollection).String(); writes != "" {
+			detail += ", " + writes
+		}
+		plan.add(planInsert, ns, "%v", detail)
+	}
+
+	if view || options.NoIndexRestore {
+		return plan
+	}
+	// the _id index is created with the collection
+	var names []string
+	var built []IndexDocument
+	for _, index := range restore.filterIndexes(ns, indexes) {
+		name, ok := index.Options["name"].(string)
+		if !ok {
+			plan.Problems = append(plan.Problems, fmt.Sprintf("%v: an index has no name", ns))
+			continue
+		}
+		if len(index.Key) == 0 {
+			plan.Problems = append(plan.Problems, fmt.Sprintf("%v: index %v has no key", ns, name))
+			continue
+		}
+		if name == "_id_" || index.Options["clustered"] == true {
+			continue
+		}
+		names = append(names, name)
+		built = append(built, index)
+	}
+	if len(built) == 0 {
+		return plan
+	}
+	detail := strings.Join(names, ", ")
+	if c.documents != nil {
+		plan.IndexKeys = int64(len(built)) * *c.documents
+		detail += fmt.Sprintf("; about %v index keys", plan.IndexKeys)
+	}
+	switch {
+	case options.IndexDefinitionsFile != "":
+		detail += ", written to " + options.IndexDefinitionsFile + " without building"
+	case options.DeferIndexBuilds:
+		detail += ", after all collections are restored"
+	}
+	plan.add(planBuildIndexes, ns, "%v %v: %v", len(built), util.Pluralize(len(built), "index", "indexes"), detail)
+	return plan
+}
+
+// readPlanMetadata reads and checks the metadata of a collection of the
+// dump.
+func (restore *MongoRestore) readPlanMetadata(intent *intents.Intent) (*Metadata, error) {
+	if err := intent.MetadataFile.Open(); err != nil {
+		return nil, fmt.Errorf("error opening metadata %v: %v", intent.MetadataLocation, err)
+	}
+	defer intent.MetadataFile.Close()
+	data, err := ioutil.ReadAll(intent.MetadataFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading metadata from %v: %v", intent.MetadataLocation, err)
+	}
+	metadata, err := restore.MetadataFromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing metadata from %v: %v", intent.MetadataLocation, err)
+	}
+	return metadata, nil
+}
+
+// targetDocumentCount returns the estimated number of documents of the
+// intent's collection in the target.
+func (restore *MongoRestore) targetDocumentCount(intent *intents.Intent) (int64, error) {
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return 0, fmt.Errorf("error establishing connection: %v", err)
+	}
+	return session.Database(intent.DB).Collection(intent.C).EstimatedDocumentCount(nil)
+}
+
+// print writes the steps of the plan, numbered, followed by its problems.
+func (plan *restorePlan) print(out io.Writer) {
+	fmt.Fprintf(out, "restore plan (%v %v):\n", len(plan.Steps), util.Pluralize(len(plan.Steps), "step", "steps"))
+	width := 0
+	for _, step := range plan.Steps {
+		if len(step.Operation) > width {
+			width = len(step.Operation)
+		}
+	}
+	for i, step := range plan.Steps {
+		line := fmt.Sprintf("%4d. %-*v", i+1, width, step.Operation)
+		if step.Namespace != "" {
+			line += "  " + step.Namespace
+		}
+		if step.Detail != "" {
+			line += "  " + step.Detail
+		}
+		fmt.Fprintln(out, line)
+	}
+	if plan.IndexKeys > 0 {
+		fmt.Fprintf(out, "estimated index build cost: about %v index keys\n", plan.IndexKeys)
+	}
+	if len(plan.Problems) > 0 {
+		fmt.Fprintf(out, "%v %v:\n", len(plan.Problems), util.Pluralize(len(plan.Problems), "problem", "problems"))
+		for _, problem := range plan.Problems {
+			fmt.Fprintf(out, "  - %v\n", problem)
+		}
+	}
+}
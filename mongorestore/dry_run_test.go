@@ -0,0 +1,117 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// planOperations returns the operations of the steps of a plan.
+func planOperations(plan *restorePlan) []string {
+	var operations []string
+	for _, step := range plan.Steps {
+		operations = append(operations, step.Operation)
+	}
+	return operations
+}
+
+func TestPlanCollection(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	intent := &intents.Intent{DB: "test", C: "c", Location: "dump/test/c.bson", Size: 2048}
+	intent.BSONFile = &realBSONFile{path: intent.Location, intent: intent}
+	metadata := &Metadata{
+		Options: bson.D{{Key: "capped", Value: true}, {Key: "size", Value: 4096}},
+		Indexes: []IndexDocument{
+			{Options: bson.M{"name": "_id_"}, Key: bson.D{{Key: "_id", Value: 1}}},
+			testIndex("a_1"),
+			testIndex("b_1"),
+		},
+	}
+	documents := int64(10)
+
+	Convey("A new collection is created, filled and indexed", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}}
+		plan := restore.planCollection(collectionPlan{intent: intent, metadata: metadata, documents: &documents})
+		So(planOperations(plan), ShouldResemble, []string{planCreate, planInsert, planBuildIndexes})
+		So(plan.Steps[0].Detail, ShouldEqual, "with options capped, size")
+		So(plan.Steps[1].Detail, ShouldContainSubstring, "10 documents")
+		So(plan.Steps[1].Detail, ShouldContainSubstring, "mode insert")
+		So(plan.Steps[2].Detail, ShouldStartWith, "2 indexes: a_1, b_1")
+		So(plan.IndexKeys, ShouldEqual, 20)
+		So(plan.Problems, ShouldBeEmpty)
+
+		Convey("and indexes can be skipped or left out", func() {
+			restore.OutputOptions.SkipIndexes = []string{"b_*"}
+			So(restore.initIndexBuilds(), ShouldBeNil)
+			plan := restore.planCollection(collectionPlan{intent: intent, metadata: metadata})
+			So(plan.Steps[2].Detail, ShouldEqual, "1 index: a_1")
+
+			restore.OutputOptions = &OutputOptions{NoIndexRestore: true}
+			plan = restore.planCollection(collectionPlan{intent: intent, metadata: metadata})
+			So(planOperations(plan), ShouldResemble, []string{planCreate, planInsert})
+		})
+	})
+
+	Convey("An existing collection is dropped with --drop", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{Drop: true}}
+		plan := restore.planCollection(collectionPlan{intent: intent, metadata: metadata, exists: true, targetDocuments: 5})
+		So(planOperations(plan), ShouldResemble, []string{planDrop, planCreate, planInsert, planBuildIndexes})
+		So(plan.Steps[0].Detail, ShouldEqual, "5 documents in the target")
+
+		Convey("and otherwise restored into, with a warning about duplicates", func() {
+			restore.OutputOptions.Drop = false
+			plan := restore.planCollection(collectionPlan{intent: intent, metadata: metadata, exists: true, targetDocuments: 5})
+			So(planOperations(plan), ShouldResemble, []string{planUseExisting, planInsert, planBuildIndexes})
+			So(plan.Steps[0].Detail, ShouldContainSubstring, "duplicate key")
+
+			restore.OutputOptions.Mode = modeUpsert
+			plan = restore.planCollection(collectionPlan{intent: intent, metadata: metadata, exists: true, targetDocuments: 5})
+			So(plan.Steps[0].Detail, ShouldNotContainSubstring, "duplicate key")
+		})
+	})
+
+	Convey("Views are created without data or indexes", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}}
+		view := &Metadata{Options: bson.D{{Key: "viewOn", Value: "c"}, {Key: "pipeline", Value: bson.A{}}}}
+		plan := restore.planCollection(collectionPlan{intent: intent, metadata: view})
+		So(planOperations(plan), ShouldResemble, []string{planCreateView})
+		So(plan.Steps[0].Detail, ShouldStartWith, "on c")
+	})
+
+	Convey("Invalid indexes are reported as problems", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}}
+		invalid := &Metadata{Indexes: []IndexDocument{{Options: bson.M{"name": "a_1"}}}}
+		plan := restore.planCollection(collectionPlan{intent: intent, metadata: invalid})
+		So(plan.Problems, ShouldHaveLength, 1)
+		So(plan.Problems[0], ShouldContainSubstring, "has no key")
+	})
+}
+
+func TestPrintPlan(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("A plan is printed as numbered steps followed by its problems", t, func() {
+		plan := &restorePlan{IndexKeys: 20, Problems: []string{"test.d: bad metadata"}}
+		plan.add(planCreate, "test.c", "with no options")
+		plan.add(planReplayOplog, "", "1 KB from dump/oplog.bson")
+		var out bytes.Buffer
+		plan.print(&out)
+		So(out.String(), ShouldEqual, "restore plan (2 steps):\n"+
+			"   1. create        test.c  with no options\n"+
+			"   2. replay oplog  1 KB from dump/oplog.bson\n"+
+			"estimated index build cost: about 20 index keys\n"+
+			"1 problem:\n"+
+			"  - test.d: bad metadata\n")
+	})
+}
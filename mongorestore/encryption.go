@@ -0,0 +1,91 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/encryption"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// loadDataKey recovers the data key of an encrypted dump with the master
+// key given by --keyFile or --kmsProvider.
+func (restore *MongoRestore) loadDataKey(info *encryption.KeyInfo) (err error) {
+	if restore.keyProvider == nil {
+		return fmt.Errorf("dump is encrypted with a %v key; use --keyFile or --kmsProvider to restore it", info.Provider)
+	}
+	restore.dataKey, err = restore.keyProvider.DataKey(info)
+	if err != nil {
+		return err
+	}
+	log.Logvf(log.Always, "decrypting the dump with a data key protected by %v key %v", info.Provider, info.MasterKeyID)
+	return nil
+}
+
+// decryptArchive is called when the archive header shows it is encrypted,
+// and replaces the archive's input with the decrypted and decompressed rest
+// of the archive.
+func (restore *MongoRestore) decryptArchive(info *encryption.KeyInfo, in io.Reader) (io.Reader, error) {
+	if err := restore.loadDataKey(info); err != nil {
+		return nil, err
+	}
+	decrypted, err := encryption.NewReader(in, restore.dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting archive: %v", err)
+	}
+	body := ioutil.NopCloser(decrypted)
+	if info.Compression != "" {
+		codec, err := compression.Parse(info.Compression)
+		if err != nil {
+			return nil, fmt.Errorf("archive has unknown compression: %v", err)
+		}
+		codec.Workers = restore.InputOptions.DecompressionWorkers
+		body, err = codec.NewReader(decrypted)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing archive: %v", err)
+		}
+	}
+	restore.archive.In = &util.WrappedReadCloser{ReadCloser: body, Inner: restore.archive.In}
+	return restore.archive.In, nil
+}
+
+// readEncryptionMetadata loads the data key of an encrypted dump directory
+// from the encryption metadata in its root, which is the target or its
+// parent for a restore of one database or collection.
+func (restore *MongoRestore) readEncryptionMetadata(target archive.DirLike) error {
+	dir := target.Path()
+	if !target.IsDir() {
+		dir = parentPath(dir)
+	}
+	for _, root := range []string{dir, parentPath(dir)} {
+		data, err := readFile(joinPath(root, archive.EncryptionMetadataFile))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error reading encryption metadata: %v", err)
+		}
+		info := &encryption.KeyInfo{}
+		if err = json.Unmarshal(data, info); err != nil {
+			return fmt.Errorf("error parsing encryption metadata %v: %v", joinPath(root, archive.EncryptionMetadataFile), err)
+		}
+		return restore.loadDataKey(info)
+	}
+	if restore.keyProvider != nil {
+		return fmt.Errorf("--keyFile and --kmsProvider can only be used to restore an encrypted dump, "+
+			"but %v has no %v", target.Path(), archive.EncryptionMetadataFile)
+	}
+	return nil
+}
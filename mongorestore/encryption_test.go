@@ -0,0 +1,181 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/encryption"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func writeMasterKey(path string, fill byte) {
+	key := bytes.Repeat([]byte{fill}, encryption.KeySize)
+	So(ioutil.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600), ShouldBeNil)
+}
+
+func writeEncrypted(path string, key, data []byte) {
+	file, err := os.Create(path)
+	So(err, ShouldBeNil)
+	defer file.Close()
+	w, err := encryption.NewWriter(file, key)
+	So(err, ShouldBeNil)
+	_, err = w.Write(data)
+	So(err, ShouldBeNil)
+	So(w.Close(), ShouldBeNil)
+}
+
+func TestEncryptedInput(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a dump directory encrypted with a local key", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_encrypted")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		keyFile := filepath.Join(dir, "master.key")
+		writeMasterKey(keyFile, 1)
+
+		provider, err := encryption.NewKeyProvider(keyFile, "")
+		So(err, ShouldBeNil)
+		dataKey, info, err := provider.NewDataKey()
+		So(err, ShouldBeNil)
+		infoJSON, err := json.Marshal(info)
+		So(err, ShouldBeNil)
+
+		dumpDir := filepath.Join(dir, "dump")
+		So(os.MkdirAll(filepath.Join(dumpDir, "db1"), 0755), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dumpDir, archive.EncryptionMetadataFile), infoJSON, 0644), ShouldBeNil)
+		doc, err := bson.Marshal(bson.M{"_id": 1})
+		So(err, ShouldBeNil)
+		writeEncrypted(filepath.Join(dumpDir, "db1", "c1.bson"), dataKey, doc)
+		writeEncrypted(filepath.Join(dumpDir, "db1", "c1.metadata.json"), dataKey, []byte(`{"indexes":[]}`))
+
+		Convey("the collection and its metadata are decrypted with the key file", func() {
+			mr := newMongoRestore()
+			mr.keyProvider = provider
+			ddl, err := newActualPath(filepath.Join(dumpDir, "db1"))
+			So(err, ShouldBeNil)
+			So(mr.readEncryptionMetadata(ddl), ShouldBeNil)
+			So(mr.dataKey, ShouldResemble, dataKey)
+
+			So(mr.CreateIntentsForDB("db1", ddl), ShouldBeNil)
+			mr.manager.Finalize(intents.Legacy)
+			intent := mr.manager.Pop()
+			So(intent, ShouldNotBeNil)
+
+			So(intent.BSONFile.Open(), ShouldBeNil)
+			contents, err := ioutil.ReadAll(intent.BSONFile)
+			So(err, ShouldBeNil)
+			So(intent.BSONFile.Close(), ShouldBeNil)
+			So(contents, ShouldResemble, doc)
+
+			So(intent.MetadataFile.Open(), ShouldBeNil)
+			contents, err = ioutil.ReadAll(intent.MetadataFile)
+			So(err, ShouldBeNil)
+			So(intent.MetadataFile.Close(), ShouldBeNil)
+			So(string(contents), ShouldEqual, `{"indexes":[]}`)
+		})
+
+		Convey("a different key file is rejected", func() {
+			otherKeyFile := filepath.Join(dir, "other.key")
+			writeMasterKey(otherKeyFile, 2)
+			mr := newMongoRestore()
+			mr.keyProvider, err = encryption.NewKeyProvider(otherKeyFile, "")
+			So(err, ShouldBeNil)
+			target, err := newActualPath(dumpDir)
+			So(err, ShouldBeNil)
+			err = mr.readEncryptionMetadata(target)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "different key")
+		})
+
+		Convey("a key is required", func() {
+			target, err := newActualPath(dumpDir)
+			So(err, ShouldBeNil)
+			So(newMongoRestore().readEncryptionMetadata(target), ShouldNotBeNil)
+		})
+
+		Convey("a modified file can't be read", func() {
+			path := filepath.Join(dumpDir, "db1", "c1.bson")
+			data, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			data[len(data)-1] ^= 1
+			So(ioutil.WriteFile(path, data, 0644), ShouldBeNil)
+
+			file := &realBSONFile{path: path, intent: &intents.Intent{DB: "db1", C: "c1"}, key: dataKey}
+			So(file.Open(), ShouldBeNil)
+			_, err = ioutil.ReadAll(file)
+			So(err, ShouldNotBeNil)
+			So(file.Close(), ShouldBeNil)
+		})
+	})
+
+	Convey("With an encrypted, compressed archive", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_encrypted")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		keyFile := filepath.Join(dir, "master.key")
+		writeMasterKey(keyFile, 1)
+		provider, err := encryption.NewKeyProvider(keyFile, "")
+		So(err, ShouldBeNil)
+		dataKey, info, err := provider.NewDataKey()
+		So(err, ShouldBeNil)
+		info.Compression = compression.ZstdName
+
+		prelude := &archive.Prelude{Header: &archive.Header{FormatVersion: "0.1", Encryption: info}}
+		prelude.AddMetadata(&archive.CollectionMetadata{Database: "db1", Collection: "c1", Metadata: `{"indexes":[]}`})
+		out := &bytes.Buffer{}
+		encrypted, err := encryption.NewWriter(out, dataKey)
+		So(err, ShouldBeNil)
+		compressed, err := compression.Codec{Name: compression.ZstdName}.NewWriter(encrypted)
+		So(err, ShouldBeNil)
+		So(prelude.WriteWithBody(out, compressed), ShouldBeNil)
+		body := []byte("collection data")
+		_, err = compressed.Write(body)
+		So(err, ShouldBeNil)
+		So(compressed.Close(), ShouldBeNil)
+		So(encrypted.Close(), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "dump.archive"), out.Bytes(), 0644), ShouldBeNil)
+
+		Convey("the header is read in plaintext and the rest is decrypted", func() {
+			mr := newMongoRestore()
+			mr.InputOptions.Archive = filepath.Join(dir, "dump.archive")
+			mr.keyProvider = provider
+			in, err := mr.getArchiveReader()
+			So(err, ShouldBeNil)
+			mr.archive = &archive.Reader{In: in, Prelude: &archive.Prelude{Decrypt: mr.decryptArchive}}
+			So(mr.archive.Prelude.Read(mr.archive.In), ShouldBeNil)
+			So(mr.archive.Prelude.Header.Encryption, ShouldNotBeNil)
+			So(mr.archive.Prelude.NamespaceMetadatas, ShouldHaveLength, 1)
+			So(mr.archive.Prelude.NamespaceMetadatas[0].Metadata, ShouldEqual, `{"indexes":[]}`)
+
+			rest, err := ioutil.ReadAll(mr.archive.In)
+			So(err, ShouldBeNil)
+			So(rest, ShouldResemble, body)
+			So(mr.archive.In.Close(), ShouldBeNil)
+		})
+
+		Convey("reading it without a key is an error", func() {
+			mr := newMongoRestore()
+			mr.archive = &archive.Reader{In: ioutil.NopCloser(bytes.NewReader(out.Bytes())), Prelude: &archive.Prelude{Decrypt: mr.decryptArchive}}
+			err := mr.archive.Prelude.Read(mr.archive.In)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "--keyFile")
+		})
+	})
+}
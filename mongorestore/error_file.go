@@ -0,0 +1,206 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errorFile records the documents whose writes fail with --errorFile, as
+// BSON documents, or as lines of extended JSON for a file named .json. A
+// nil errorFile records nothing.
+type errorFile struct {
+	path      string
+	json      bool
+	documents bool
+
+	mutex  sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	count  int
+	// err is the first error writing the file, returned by close
+	err error
+}
+
+// validateErrorFile checks the options of --errorFile.
+func (restore *MongoRestore) validateErrorFile() error {
+	if restore.OutputOptions.ErrorFileDocuments && restore.OutputOptions.ErrorFile == "" {
+		return fmt.Errorf("cannot use %v without %v", ErrorFileDocumentsOption, ErrorFileOption)
+	}
+	return nil
+}
+
+// openErrorFile creates the --errorFile, if one is given.
+func (restore *MongoRestore) openErrorFile() error {
+	if restore.OutputOptions.ErrorFile == "" {
+		return nil
+	}
+	var err error
+	restore.errorFile, err = newErrorFile(restore.OutputOptions.ErrorFile, restore.OutputOptions.ErrorFileDocuments)
+	return err
+}
+
+// closeErrorFile closes the --errorFile and logs how many failed writes it
+// holds.
+func (restore *MongoRestore) closeErrorFile() error {
+	if restore.errorFile == nil {
+		return nil
+	}
+	count, err := restore.errorFile.close()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		log.Logvf(log.Always, "recorded %v failed %v in %v",
+			count, util.Pluralize(count, "write", "writes"), restore.errorFile.path)
+	}
+	return nil
+}
+
+func newErrorFile(path string, documents bool) (*errorFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %v: %v", ErrorFileOption, err)
+	}
+	return &errorFile{
+		path:      path,
+		json:      strings.EqualFold(filepath.Ext(path), ".json"),
+		documents: documents,
+		file:      file,
+		writer:    bufio.NewWriter(file),
+	}, nil
+}
+
+// writeErrorHandler returns the handler of the write errors of the bulk
+// inserters of a namespace, which records them in the --errorFile and
+// passes them to OnWriteError. The duplicates skipped by
+// --mode=insertIgnore aren't recorded.
+func (restore *MongoRestore) writeErrorHandler(namespace string) func(mongo.BulkWriteError) {
+	callback := restore.writeErrorCallback(namespace)
+	if restore.errorFile == nil && callback == nil {
+		return nil
+	}
+	return func(writeErr mongo.BulkWriteError) {
+		if restore.OutputOptions.Mode == modeInsertIgnore && writeErr.Code == db.ErrDuplicateKeyCode {
+			return
+		}
+		restore.errorFile.record(namespace, writeErr)
+		if callback != nil {
+			callback(writeErr)
+		}
+	}
+}
+
+// record adds the failed write of writeErr to the file.
+func (file *errorFile) record(namespace string, writeErr mongo.BulkWriteError) {
+	if file == nil {
+		return
+	}
+	entry := writeErrorEntry(namespace, writeErr, file.documents)
+	var out []byte
+	var err error
+	if file.json {
+		out, err = bson.MarshalExtJSON(entry, false, false)
+		out = append(out, '\n')
+	} else {
+		out, err = bson.Marshal(entry)
+	}
+
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+	if file.err != nil {
+		return
+	}
+	if err != nil {
+		file.err = fmt.Errorf("error recording a failed write to %v in %v: %v", namespace, file.path, err)
+		return
+	}
+	if _, err = file.writer.Write(out); err != nil {
+		file.err = fmt.Errorf("error writing %v: %v", file.path, err)
+		return
+	}
+	file.count++
+}
+
+// close flushes and closes the file, returning the number of failed writes
+// it holds.
+func (file *errorFile) close() (int, error) {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+	err := file.writer.Flush()
+	if closeErr := file.file.Close(); err == nil {
+		err = closeErr
+	}
+	if file.err != nil {
+		return file.count, file.err
+	}
+	if err != nil {
+		return file.count, fmt.Errorf("error writing %v: %v", file.path, err)
+	}
+	return file.count, nil
+}
+
+// writeErrorEntry returns the entry of the error file for a failed write:
+// its namespace, the _id of its document and its error code and message,
+// followed by the document if documents is set. The document of an update
+// of --mode=merge is the update.
+func writeErrorEntry(namespace string, writeErr mongo.BulkWriteError, documents bool) bson.D {
+	operation, id, document := describeWrite(writeErr)
+	entry := bson.D{{Key: "ns", Value: namespace}}
+	if id != nil {
+		entry = append(entry, bson.E{Key: "_id", Value: id})
+	}
+	entry = append(entry,
+		bson.E{Key: "operation", Value: operation},
+		bson.E{Key: "code", Value: writeErr.Code},
+		bson.E{Key: "errmsg", Value: writeErr.Message},
+	)
+	if documents && document != nil {
+		entry = append(entry, bson.E{Key: "document", Value: document})
+	}
+	return entry
+}
+
+// describeWrite returns the operation of a failed write, and the _id and
+// document it wrote, if known.
+func describeWrite(writeErr mongo.BulkWriteError) (operation string, id, document interface{}) {
+	switch model := writeErr.Request.(type) {
+	case *mongo.InsertOneModel:
+		operation = "insert"
+		document = model.Document
+		if raw, ok := model.Document.([]byte); ok {
+			document = bson.Raw(raw)
+			if value, err := bson.Raw(raw).LookupErr("_id"); err == nil {
+				id = value
+			}
+		}
+	case *mongo.ReplaceOneModel:
+		operation, id, document = "replace", filterID(model.Filter), model.Replacement
+	case *mongo.UpdateOneModel:
+		operation, id, document = "update", filterID(model.Filter), model.Update
+	}
+	return operation, id, document
+}
+
+// filterID returns the _id a replacement or update selects its document by.
+func filterID(filter interface{}) interface{} {
+	if selector, ok := filter.(bson.D); ok && len(selector) > 0 && selector[0].Key == "_id" {
+		return selector[0].Value
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func failedWrite(model mongo.WriteModel, code int) mongo.BulkWriteError {
+	return mongo.BulkWriteError{WriteError: mongo.WriteError{Code: code, Message: "failed"}, Request: model}
+}
+
+func TestWriteErrorEntry(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	doc := mustMarshal(bson.D{{Key: "_id", Value: 7}, {Key: "a", Value: "x"}})
+
+	Convey("A failed insert is recorded with the _id of its document", t, func() {
+		entry := writeErrorEntry("test.c", failedWrite(mongo.NewInsertOneModel().SetDocument(doc), db.ErrDuplicateKeyCode), false)
+		So(entry, ShouldResemble, bson.D{
+			{Key: "ns", Value: "test.c"},
+			{Key: "_id", Value: bson.Raw(doc).Lookup("_id")},
+			{Key: "operation", Value: "insert"},
+			{Key: "code", Value: db.ErrDuplicateKeyCode},
+			{Key: "errmsg", Value: "failed"},
+		})
+
+		entry = writeErrorEntry("test.c", failedWrite(mongo.NewInsertOneModel().SetDocument(doc), 121), true)
+		So(entry[len(entry)-1], ShouldResemble, bson.E{Key: "document", Value: bson.Raw(doc)})
+	})
+
+	Convey("Failed replacements and updates are recorded with the _id they select", t, func() {
+		replacement := bson.D{{Key: "_id", Value: 7}, {Key: "a", Value: "x"}}
+		entry := writeErrorEntry("test.c", failedWrite(mongo.NewReplaceOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: 7}}).SetReplacement(replacement), 121), true)
+		So(entry[1], ShouldResemble, bson.E{Key: "_id", Value: 7})
+		So(entry[2], ShouldResemble, bson.E{Key: "operation", Value: "replace"})
+		So(entry[5], ShouldResemble, bson.E{Key: "document", Value: replacement})
+
+		entry = writeErrorEntry("test.c", failedWrite(mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: 8}}).SetUpdate(bson.D{{Key: "$set", Value: bson.D{{Key: "a", Value: 1}}}}), 121), false)
+		So(entry[1], ShouldResemble, bson.E{Key: "_id", Value: 8})
+		So(entry[2], ShouldResemble, bson.E{Key: "operation", Value: "update"})
+		So(entry, ShouldHaveLength, 5)
+	})
+
+	Convey("A document without an _id is recorded without one", t, func() {
+		entry := writeErrorEntry("test.c", failedWrite(mongo.NewInsertOneModel().SetDocument(mustMarshal(bson.D{{Key: "a", Value: 1}})), 121), false)
+		So(entry[1], ShouldResemble, bson.E{Key: "operation", Value: "insert"})
+	})
+}
+
+func TestErrorFile(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	dir, err := ioutil.TempDir("", "error-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doc := mustMarshal(bson.D{{Key: "_id", Value: 1}})
+	duplicate := failedWrite(mongo.NewInsertOneModel().SetDocument(doc), db.ErrDuplicateKeyCode)
+	invalid := failedWrite(mongo.NewInsertOneModel().SetDocument(doc), 121)
+
+	Convey("--errorFileDocuments requires --errorFile", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{ErrorFileDocuments: true}}
+		So(restore.validateErrorFile(), ShouldNotBeNil)
+		restore.OutputOptions.ErrorFile = filepath.Join(dir, "errors.bson")
+		So(restore.validateErrorFile(), ShouldBeNil)
+	})
+
+	Convey("Without --errorFile no write errors are handled", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}}
+		So(restore.openErrorFile(), ShouldBeNil)
+		So(restore.writeErrorHandler("test.c"), ShouldBeNil)
+		So(restore.closeErrorFile(), ShouldBeNil)
+	})
+
+	Convey("Failed writes are recorded as BSON", t, func() {
+		path := filepath.Join(dir, "errors.bson")
+		restore := &MongoRestore{OutputOptions: &OutputOptions{ErrorFile: path, ErrorFileDocuments: true}}
+		So(restore.openErrorFile(), ShouldBeNil)
+		handler := restore.writeErrorHandler("test.c")
+		handler(duplicate)
+		handler(invalid)
+		So(restore.closeErrorFile(), ShouldBeNil)
+
+		content, err := ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		source := db.NewDecodedBSONSource(db.NewBSONSource(ioutil.NopCloser(bytes.NewReader(content))))
+		var codes []int32
+		for raw := source.LoadNext(); raw != nil; raw = source.LoadNext() {
+			codes = append(codes, bson.Raw(raw).Lookup("code").Int32())
+			So(bson.Raw(raw).Lookup("document", "_id").Int32(), ShouldEqual, 1)
+		}
+		So(codes, ShouldResemble, []int32{int32(db.ErrDuplicateKeyCode), 121})
+	})
+
+	Convey("Failed writes are recorded as extended JSON lines in a .json file", t, func() {
+		path := filepath.Join(dir, "errors.json")
+		restore := &MongoRestore{OutputOptions: &OutputOptions{ErrorFile: path, Mode: modeInsertIgnore}}
+		So(restore.openErrorFile(), ShouldBeNil)
+		handler := restore.writeErrorHandler("test.c")
+		handler(duplicate)
+		handler(invalid)
+		So(restore.errorFile.count, ShouldEqual, 1)
+		So(restore.closeErrorFile(), ShouldBeNil)
+
+		content, err := ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, `{"ns":"test.c","_id":1,"operation":"insert","code":121,"errmsg":"failed"}`+"\n")
+	})
+
+	Convey("An error file that can't be created is an error", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{ErrorFile: filepath.Join(dir, "missing", "errors.bson")}}
+		So(restore.openErrorFile(), ShouldNotBeNil)
+	})
+}
@@ -7,7 +7,9 @@
 package mongorestore
 
 import (
-	"compress/gzip"
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -16,10 +18,14 @@ import (
 	"strings"
 	"sync/atomic"
 
-	"github.com/mongodb/mongo-tools-common/archive"
-	"github.com/mongodb/mongo-tools-common/intents"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/encryption"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"github.com/mongodb/mongo-tools/common/util"
 )
 
 // FileType describes the various types of restore documents.
@@ -97,7 +103,9 @@ type realBSONFile struct {
 	// intent.file ( a ReadWriteOpenCloser )
 	errorWriter
 	intent *intents.Intent
-	gzip   bool
+	codec  compression.Codec
+	// key is set when the file is encrypted
+	key []byte
 }
 
 // Open is part of the intents.file interface. realBSONFiles need to be Opened before Read
@@ -107,14 +115,37 @@ func (f *realBSONFile) Open() (err error) {
 		// this error shouldn't happen normally
 		return fmt.Errorf("error reading BSON file for %v", f.intent.Namespace())
 	}
-	file, err := os.Open(f.path)
+	file, err := openBSONFile(f.path)
 	if err != nil {
 		return fmt.Errorf("error reading BSON file %v: %v", f.path, err)
 	}
 	posFile := &posTrackingReader{0, file}
-	if f.gzip {
-		gzFile, err := gzip.NewReader(posFile)
-		posUncompressedFile := &posTrackingReader{0, gzFile}
+	var in io.Reader = posFile
+	if f.key != nil {
+		in, err = encryption.NewReader(posFile, f.key)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("error decrypting BSON file %v: %v", f.path, err)
+		}
+	} else if f.codec.IsNone() {
+		// BSON files compressed by other tools keep their .bson name, so
+		// they are recognized by their contents
+		buffered := bufio.NewReader(posFile)
+		codec, err := detectBSONCodec(buffered)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("error reading BSON file %v: %v", f.path, err)
+		}
+		if !codec.IsNone() {
+			log.Logvf(log.DebugLow, "BSON file %v is compressed with %v", f.path, codec)
+			codec.Workers = f.codec.Workers
+			f.codec = codec
+		}
+		in = buffered
+	}
+	if !f.codec.IsNone() {
+		uncompressedFile, err := f.codec.NewReader(in)
+		posUncompressedFile := &posTrackingReader{0, uncompressedFile}
 		if err != nil {
 			return fmt.Errorf("error decompressing compresed BSON file %v: %v", f.path, err)
 		}
@@ -122,11 +153,50 @@ func (f *realBSONFile) Open() (err error) {
 			readHolder: posUncompressedFile,
 			posHolder:  posFile}
 	} else {
-		f.PosReader = posFile
+		f.PosReader = &mixedPosTrackingReader{
+			readHolder: &posTrackingReader{0, ioutil.NopCloser(in)},
+			posHolder:  posFile}
 	}
 	return nil
 }
 
+// detectBSONCodec returns the compression of the BSON data in r from its
+// magic number, without consuming any input. Data that starts with the
+// length of a BSON document is taken to be uncompressed, so that documents
+// whose first bytes happen to look like a magic number are not mistaken
+// for compressed data.
+func detectBSONCodec(r *bufio.Reader) (compression.Codec, error) {
+	prefix, err := r.Peek(4)
+	if err == io.EOF || err == bufio.ErrBufferFull || len(prefix) < 4 {
+		return compression.None, nil
+	} else if err != nil {
+		return compression.None, err
+	}
+	size := int32(binary.LittleEndian.Uint32(prefix))
+	if size >= 5 && size <= db.MaxBSONSize+16*1024 {
+		return compression.None, nil
+	}
+	return compression.Detect(r)
+}
+
+// openBSONFile opens the BSON file at path, which may have been split into
+// segments by mongodump --splitSize, or is an object storage URL.
+func openBSONFile(path string) (io.ReadCloser, error) {
+	if storage.IsRemote(path) {
+		return storage.OpenObject(path)
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		if segments, segmentsErr := util.OpenSegments(path); !os.IsNotExist(segmentsErr) {
+			return segments, segmentsErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
 // realMetadataFile implements the intents.file interface. It lets intents read from real
 // metadata.json files on disk via an embedded os.File
 // The Read, Write and Close methods of the intents.file interface is implemented here by the
@@ -139,7 +209,9 @@ type realMetadataFile struct {
 	// intent.file ( a ReadWriteOpenCloser )
 	errorWriter
 	intent *intents.Intent
-	gzip   bool
+	codec  compression.Codec
+	// key is set when the file is encrypted
+	key []byte
 }
 
 // Open is part of the intents.file interface. realMetadataFiles need to be Opened before Read
@@ -148,18 +220,27 @@ func (f *realMetadataFile) Open() (err error) {
 	if f.path == "" {
 		return fmt.Errorf("error reading metadata for %v", f.intent.Namespace())
 	}
-	file, err := os.Open(f.path)
+	file, err := openFile(f.path)
 	if err != nil {
 		return fmt.Errorf("error reading metadata %v: %v", f.path, err)
 	}
-	if f.gzip {
-		gzFile, err := gzip.NewReader(file)
+	var in io.ReadCloser = file
+	if f.key != nil {
+		decrypted, err := encryption.NewReader(file, f.key)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("error decrypting metadata %v: %v", f.path, err)
+		}
+		in = &util.WrappedReadCloser{ioutil.NopCloser(decrypted), file}
+	}
+	if !f.codec.IsNone() {
+		uncompressedFile, err := f.codec.NewReader(in)
 		if err != nil {
 			return fmt.Errorf("error reading compressed metadata %v: %v", f.path, err)
 		}
-		f.ReadCloser = &util.WrappedReadCloser{gzFile, file}
+		f.ReadCloser = &util.WrappedReadCloser{uncompressedFile, in}
 	} else {
-		f.ReadCloser = file
+		f.ReadCloser = in
 	}
 	return nil
 }
@@ -180,11 +261,29 @@ type stdinFile struct {
 	pos int64 // updated atomically, aligned at the beginning of the struct
 	io.Reader
 	errorWriter
+	// workers is the number of goroutines compressed input is
+	// decompressed with
+	workers int
 }
 
 // Open is part of the intents.file interface. stdinFile needs to have Open called on it before
 // Read can be called on it.
 func (f *stdinFile) Open() error {
+	buffered := bufio.NewReader(f.Reader)
+	codec, err := detectBSONCodec(buffered)
+	if err != nil {
+		return fmt.Errorf("error reading standard input: %v", err)
+	}
+	f.Reader = buffered
+	if !codec.IsNone() {
+		log.Logvf(log.DebugLow, "standard input is compressed with %v", codec)
+		codec.Workers = f.workers
+		decompressed, err := codec.NewReader(buffered)
+		if err != nil {
+			return fmt.Errorf("error decompressing standard input: %v", err)
+		}
+		f.Reader = decompressed
+	}
 	return nil
 }
 
@@ -204,6 +303,38 @@ func (f *stdinFile) Close() error {
 	return nil
 }
 
+// fileCodec returns the compression of a file of a dump directory: that
+// given by --decompress or --gzip, or else that of the file's extension, so
+// that dumps written with mongodump --compress are detected. Files without
+// an extension are checked for a magic number when they are opened.
+func (restore *MongoRestore) fileCodec(path string) compression.Codec {
+	codec := restore.inputCodec()
+	if codec.IsNone() {
+		codec = compression.FromExtension(path)
+		codec.Workers = restore.InputOptions.DecompressionWorkers
+	}
+	return codec
+}
+
+// fileExtension returns the compression extension that follows .bson or
+// .metadata.json in the name of a file of a dump directory, or an empty
+// string. Without --decompress or --gzip, any codec's extension is
+// accepted.
+func (restore *MongoRestore) fileExtension(name string) string {
+	if restore.InputOptions.Archive != "" {
+		// the "files" of an archive are never compressed individually
+		return ""
+	}
+	if ext := restore.inputCodec().Extension(); ext != "" {
+		return ext
+	}
+	ext := compression.FromExtension(name).Extension()
+	if ext == "" || !(strings.HasSuffix(name, ".bson"+ext) || strings.HasSuffix(name, ".metadata.json"+ext)) {
+		return ""
+	}
+	return ext
+}
+
 // getInfoFromFile returns the collection name and FileType from a bson or metadata file.
 // The collection name may be pulled from either the file name itself, or from the content
 // of a .metadata.json file if the file name is truncated.
@@ -221,18 +352,18 @@ func (restore *MongoRestore) getInfoFromFile(filename string) (string, FileType,
 	if strings.HasSuffix(baseFileName, ".bin") {
 		collName = strings.TrimSuffix(baseFileName, ".bin")
 		fileType = BSONFileType
-	} else if restore.InputOptions.Gzip && restore.InputOptions.Archive == "" {
-		// Gzip indicates that files in a dump directory should have a .gz suffix
-		// but it does not indicate that the "files" provided by the archive should,
+	} else if ext := restore.fileExtension(baseFileName); ext != "" {
+		// Compression indicates that files in a dump directory should have the codec's
+		// suffix, but it does not indicate that the "files" provided by the archive should,
 		// compressed or otherwise.
-		if strings.HasSuffix(baseFileName, ".metadata.json.gz") {
-			collName = strings.TrimSuffix(baseFileName, ".metadata.json.gz")
+		if strings.HasSuffix(baseFileName, ".metadata.json"+ext) {
+			collName = strings.TrimSuffix(baseFileName, ".metadata.json"+ext)
 			fileType = MetadataFileType
 			metadataFullPath = filename
-		} else if strings.HasSuffix(baseFileName, ".bson.gz") {
-			collName = strings.TrimSuffix(baseFileName, ".bson.gz")
+		} else if strings.HasSuffix(baseFileName, ".bson"+ext) {
+			collName = strings.TrimSuffix(baseFileName, ".bson"+ext)
 			fileType = BSONFileType
-			metadataFullPath = strings.TrimSuffix(filename, ".bson.gz") + ".metadata.json.gz"
+			metadataFullPath = strings.TrimSuffix(filename, ".bson"+ext) + ".metadata.json" + ext
 		}
 	} else if strings.HasSuffix(baseFileName, ".metadata.json") {
 		collName = strings.TrimSuffix(baseFileName, ".metadata.json")
@@ -251,7 +382,8 @@ func (restore *MongoRestore) getInfoFromFile(filename string) (string, FileType,
 	// (1) $admin.system.users
 	// (2) $admin.system.roles
 	// (3) $admin.system.version
-	if strings.Contains(collName, "%24") && len(collName) == 238 {
+	// Names are truncated to 238 bytes, or 237 with a compression extension longer than .gz.
+	if strings.Contains(collName, "%24") && (len(collName) == 238 || len(collName) == 237) {
 		collName, err = restore.getCollectionNameFromMetadata(metadataFullPath)
 		if err != nil {
 			return "", UnknownFileType, err
@@ -278,7 +410,7 @@ func (restore *MongoRestore) getCollectionNameFromMetadata(metadataFullPath stri
 	}
 
 	// Open the metadata file for reading.
-	metadataFile := &realMetadataFile{path: metadataFullPath, gzip: strings.HasSuffix(metadataFullPath, ".gz")}
+	metadataFile := &realMetadataFile{path: metadataFullPath, codec: restore.fileCodec(metadataFullPath), key: restore.dataKey}
 	err := metadataFile.Open()
 	if err != nil {
 		return "", fmt.Errorf("error opening metadata file \"%s\": %v", metadataFullPath, err)
@@ -359,9 +491,16 @@ func (restore *MongoRestore) CreateAllIntents(dir archive.DirLike) error {
 						Demux:  restore.archive.Demux,
 					}
 				} else {
-					oplogIntent.BSONFile = &realBSONFile{path: entry.Path(), intent: oplogIntent, gzip: restore.InputOptions.Gzip}
+					oplogIntent.BSONFile = &realBSONFile{path: entry.Path(), intent: oplogIntent, codec: restore.fileCodec(entry.Path()), key: restore.dataKey}
 				}
 				restore.manager.Put(oplogIntent)
+			} else if entry.Name() == archive.IncrementalMetadataFile {
+				log.Logvf(log.DebugLow, "found incremental dump metadata %v", entry.Path())
+			} else if entry.Name() == archive.EncryptionMetadataFile {
+				log.Logvf(log.DebugLow, "found encryption metadata %v", entry.Path())
+			} else if entry.Name() == archive.ManifestFile {
+				log.Logvf(log.DebugLow, "found dump manifest %v", entry.Path())
+				warnIfInterrupted(entry.Path())
 			} else {
 				log.Logvf(log.Always, `don't know what to do with file "%v", skipping...`, entry.Path())
 			}
@@ -370,28 +509,64 @@ func (restore *MongoRestore) CreateAllIntents(dir archive.DirLike) error {
 	return nil
 }
 
-// CreateIntentForOplog creates an intent for a file that we want to treat as an oplog.
+// warnIfInterrupted warns that a dump is incomplete when its manifest shows
+// that mongodump was interrupted.
+func warnIfInterrupted(manifestPath string) {
+	data, err := readFile(manifestPath)
+	if err != nil {
+		return
+	}
+	var m struct {
+		Interrupted bool     `json:"interrupted"`
+		Incomplete  []string `json:"incomplete"`
+	}
+	if json.Unmarshal(data, &m) != nil || !m.Interrupted {
+		return
+	}
+	log.Logvf(log.Always, "the dump was interrupted; these collections will not be completely restored: %v",
+		strings.Join(m.Incomplete, ", "))
+}
+
+// CreateIntentForOplog creates an intent for the files that we want to treat as an oplog.
+// More than one file is read as slices of the same oplog.
 func (restore *MongoRestore) CreateIntentForOplog() error {
-	target, err := newActualPath(restore.InputOptions.OplogFile)
 	db := ""
 	collection := "oplog"
-	if err != nil {
-		return err
+	intent := &intents.Intent{
+		DB: db,
+		C:  collection,
 	}
-	log.Logvf(log.DebugLow, "reading oplog from %v", target.Path())
+	var files []*realBSONFile
+	for _, oplogFile := range restore.InputOptions.OplogFile {
+		target, err := newActualPath(oplogFile)
+		if err != nil {
+			return err
+		}
+		log.Logvf(log.DebugLow, "reading oplog from %v", target.Path())
 
-	if target.IsDir() {
-		return fmt.Errorf("file %v is a directory, not a bson file", target.Path())
+		if target.IsDir() {
+			return fmt.Errorf("file %v is a directory, not a bson file", target.Path())
+		}
+		intent.Size += target.Size()
+		files = append(files, &realBSONFile{path: target.Path(), intent: intent, codec: restore.fileCodec(target.Path())})
 	}
 
 	// Then create its intent.
-	intent := &intents.Intent{
-		DB:       db,
-		C:        collection,
-		Size:     target.Size(),
-		Location: target.Path(),
+	if len(files) == 1 {
+		intent.Location = files[0].path
+		intent.BSONFile = files[0]
+	} else {
+		slices, err := sortOplogSlices(files)
+		if err != nil {
+			return err
+		}
+		locations := make([]string, len(slices))
+		for i, slice := range slices {
+			locations[i] = slice.path
+		}
+		intent.Location = strings.Join(locations, ", ")
+		intent.BSONFile = &oplogSlices{slices: slices}
 	}
-	intent.BSONFile = &realBSONFile{path: target.Path(), intent: intent, gzip: restore.InputOptions.Gzip}
 	restore.manager.PutOplogIntent(intent, "oplogFile")
 	return nil
 }
@@ -435,6 +610,7 @@ func (restore *MongoRestore) CreateIntentsForDB(db string, dir archive.DirLike)
 				// it would likely fail anyway.
 				if collection == "system.profile" {
 					log.Logvf(log.DebugLow, "skipping restore of system.profile collection in %v", db)
+					log.Summary().AddSkipped(sourceNS, "system.profile is not restored")
 					skip = true
 				}
 				// skip restoring the indexes collection if we are using metadata
@@ -452,6 +628,11 @@ func (restore *MongoRestore) CreateIntentsForDB(db string, dir archive.DirLike)
 				}
 				if restore.excluder.Has(sourceNS) {
 					log.Logvf(log.DebugLow, "skipping restoring %v.%v, it is excluded", db, collection)
+					log.Summary().AddSkipped(sourceNS, "excluded")
+					skip = true
+				}
+				if !skip && restore.skipsData(&intents.Intent{DB: db, C: collection}) {
+					log.Logvf(log.DebugLow, "not restoring documents of %v.%v with --metadataOnly or --indexesOnly", db, collection)
 					skip = true
 				}
 				destNS := restore.renamer.Get(sourceNS)
@@ -489,7 +670,7 @@ func (restore *MongoRestore) CreateIntentsForDB(db string, dir archive.DirLike)
 						continue
 					}
 					intent.Location = entry.Path()
-					intent.BSONFile = &realBSONFile{path: entry.Path(), intent: intent, gzip: restore.InputOptions.Gzip}
+					intent.BSONFile = &realBSONFile{path: entry.Path(), intent: intent, codec: restore.fileCodec(entry.Path()), key: restore.dataKey}
 				}
 				log.Logvf(log.Info, "found collection %v bson to restore to %v", sourceNS, destNS)
 				restore.manager.PutWithNamespace(sourceNS, intent)
@@ -524,7 +705,7 @@ func (restore *MongoRestore) CreateIntentsForDB(db string, dir archive.DirLike)
 					intent.MetadataFile = &archive.MetadataPreludeFile{Origin: sourceNS, Intent: intent, Prelude: restore.archive.Prelude}
 				} else {
 					intent.MetadataLocation = entry.Path()
-					intent.MetadataFile = &realMetadataFile{path: entry.Path(), intent: intent, gzip: restore.InputOptions.Gzip}
+					intent.MetadataFile = &realMetadataFile{path: entry.Path(), intent: intent, codec: restore.fileCodec(entry.Path()), key: restore.dataKey}
 				}
 				log.Logvf(log.Info, "found collection metadata from %v to restore to %v", sourceNS, destNS)
 				restore.manager.PutWithNamespace(sourceNS, intent)
@@ -547,7 +728,7 @@ func (restore *MongoRestore) CreateStdinIntentForCollection(db string, collectio
 		C:        collection,
 		Location: "-",
 	}
-	intent.BSONFile = &stdinFile{Reader: restore.InputReader}
+	intent.BSONFile = &stdinFile{Reader: restore.InputReader, workers: restore.InputOptions.DecompressionWorkers}
 	restore.manager.Put(intent)
 	return nil
 }
@@ -574,7 +755,7 @@ func (restore *MongoRestore) CreateIntentForCollection(db string, collection str
 		return err
 	}
 	if fileType != BSONFileType {
-		return fmt.Errorf("file %v does not have .bson or .bson.gz extension", bsonFile.Path())
+		return fmt.Errorf("file %v does not have .bson%v extension", bsonFile.Path(), restore.fileExtension(bsonFile.Name()))
 	}
 
 	// Create the intent using the bson file.
@@ -584,7 +765,9 @@ func (restore *MongoRestore) CreateIntentForCollection(db string, collection str
 		Size:     bsonFile.Size(),
 		Location: bsonFile.Path(),
 	}
-	intent.BSONFile = &realBSONFile{path: bsonFile.Path(), intent: intent, gzip: restore.InputOptions.Gzip}
+	if !restore.skipsData(intent) {
+		intent.BSONFile = &realBSONFile{path: bsonFile.Path(), intent: intent, codec: restore.fileCodec(bsonFile.Path()), key: restore.dataKey}
+	}
 
 	// Check if the bson file has a corresponding .metadata.json file in its folder. If there's a
 	// directory error, log a note but attempt to restore without the metadata file anyway.
@@ -598,12 +781,8 @@ func (restore *MongoRestore) CreateIntentForCollection(db string, collection str
 	}
 
 	// Change out the extension from the bson file name to get the metadata file name.
-	var metadataName string
-	if restore.InputOptions.Gzip {
-		metadataName = strings.TrimSuffix(bsonFile.Name(), ".bson.gz") + ".metadata.json.gz"
-	} else {
-		metadataName = strings.TrimSuffix(bsonFile.Name(), ".bson") + ".metadata.json"
-	}
+	ext := restore.fileExtension(bsonFile.Name())
+	metadataName := strings.TrimSuffix(bsonFile.Name(), ".bson"+ext) + ".metadata.json" + ext
 
 	// If the metadata file is found, add it to the intent.
 	for _, entry := range entries {
@@ -611,7 +790,7 @@ func (restore *MongoRestore) CreateIntentForCollection(db string, collection str
 			metadataPath := entry.Path()
 			log.Logvf(log.Info, "found metadata for collection at %v", metadataPath)
 			intent.MetadataLocation = metadataPath
-			intent.MetadataFile = &realMetadataFile{path: metadataPath, intent: intent, gzip: restore.InputOptions.Gzip}
+			intent.MetadataFile = &realMetadataFile{path: metadataPath, intent: intent, codec: restore.fileCodec(metadataPath), key: restore.dataKey}
 			break
 		}
 	}
@@ -679,6 +858,11 @@ type actualPath struct {
 
 func newActualPath(dir string) (*actualPath, error) {
 	stat, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		if segments, segmentsErr := util.StatSegments(dir); !os.IsNotExist(segmentsErr) {
+			stat, err = segments, segmentsErr
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -712,7 +896,19 @@ func (ap actualPath) ReadDir() ([]archive.DirLike, error) {
 		return nil, err
 	}
 	var returnFileInfo = make([]archive.DirLike, 0, len(entries))
+	segmented := map[string]bool{}
 	for _, entry := range entries {
+		// the segments of a file split by mongodump --splitSize are listed
+		// as the file itself
+		if name, _, ok := util.SplitSegmentPath(entry.Name()); ok && !entry.IsDir() {
+			if segmented[name] {
+				continue
+			}
+			segmented[name] = true
+			if entry, err = util.StatSegments(filepath.Join(ap.Path(), name)); err != nil {
+				return nil, err
+			}
+		}
 		returnFileInfo = append(returnFileInfo,
 			actualPath{
 				FileInfo: entry,
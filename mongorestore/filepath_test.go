@@ -8,17 +8,22 @@ package mongorestore
 
 import (
 	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
-	"github.com/mongodb/mongo-tools-common/intents"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/options"
-	commonOpts "github.com/mongodb/mongo-tools-common/options"
-	"github.com/mongodb/mongo-tools-common/testtype"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	commonOpts "github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/common/util"
 	"github.com/mongodb/mongo-tools/mongorestore/ns"
 	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 func init() {
@@ -33,13 +38,14 @@ func newMongoRestore() *MongoRestore {
 	includer, _ := ns.NewMatcher([]string{"*"})
 	excluder, _ := ns.NewMatcher([]string{})
 	return &MongoRestore{
-		manager:      intents.NewIntentManager(),
-		InputOptions: &InputOptions{},
-		ToolOptions:  &commonOpts.ToolOptions{},
-		NSOptions:    &NSOptions{},
-		renamer:      renamer,
-		includer:     includer,
-		excluder:     excluder,
+		manager:       intents.NewIntentManager(),
+		InputOptions:  &InputOptions{},
+		OutputOptions: &OutputOptions{},
+		ToolOptions:   &commonOpts.ToolOptions{},
+		NSOptions:     &NSOptions{},
+		renamer:       renamer,
+		includer:      includer,
+		excluder:      excluder,
 	}
 }
 
@@ -366,9 +372,10 @@ func TestCreateIntentsForCollection(t *testing.T) {
 	Convey("With a test MongoRestore", t, func() {
 		buff = bytes.Buffer{}
 		mr = &MongoRestore{
-			manager:      intents.NewIntentManager(),
-			ToolOptions:  &commonOpts.ToolOptions{},
-			InputOptions: &InputOptions{},
+			manager:       intents.NewIntentManager(),
+			ToolOptions:   &commonOpts.ToolOptions{},
+			InputOptions:  &InputOptions{},
+			OutputOptions: &OutputOptions{},
 		}
 		log.SetWriter(&buff)
 
@@ -466,9 +473,10 @@ func TestCreateIntentsForLongCollectionName(t *testing.T) {
 	Convey("With a test MongoRestore", t, func() {
 		buff = bytes.Buffer{}
 		mr = &MongoRestore{
-			manager:      intents.NewIntentManager(),
-			ToolOptions:  &commonOpts.ToolOptions{},
-			InputOptions: &InputOptions{},
+			manager:       intents.NewIntentManager(),
+			ToolOptions:   &commonOpts.ToolOptions{},
+			InputOptions:  &InputOptions{},
+			OutputOptions: &OutputOptions{},
 		}
 		log.SetWriter(&buff)
 
@@ -507,3 +515,267 @@ func TestCreateIntentsForLongCollectionName(t *testing.T) {
 		})
 	})
 }
+
+func writeCompressed(path string, codec compression.Codec, data []byte) {
+	file, err := os.Create(path)
+	So(err, ShouldBeNil)
+	defer file.Close()
+	w, err := codec.NewWriter(file)
+	So(err, ShouldBeNil)
+	_, err = w.Write(data)
+	So(err, ShouldBeNil)
+	So(w.Close(), ShouldBeNil)
+}
+
+func TestCompressedInput(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a dump directory compressed with zstd", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_compressed")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(os.Mkdir(filepath.Join(dir, "db1"), 0755), ShouldBeNil)
+
+		doc, err := bson.Marshal(bson.M{"_id": 1})
+		So(err, ShouldBeNil)
+		codec := compression.Codec{Name: compression.ZstdName}
+		writeCompressed(filepath.Join(dir, "db1", "c1.bson.zst"), codec, doc)
+		writeCompressed(filepath.Join(dir, "db1", "c1.metadata.json.zst"), codec, []byte(`{"indexes":[]}`))
+
+		mr := newMongoRestore()
+		mr.InputOptions.Decompress = "zstd"
+		ddl, err := newActualPath(filepath.Join(dir, "db1"))
+		So(err, ShouldBeNil)
+		So(mr.CreateIntentsForDB("db1", ddl), ShouldBeNil)
+		mr.manager.Finalize(intents.Legacy)
+
+		Convey("the collection and its metadata are decompressed", func() {
+			intent := mr.manager.Pop()
+			So(intent, ShouldNotBeNil)
+			So(intent.C, ShouldEqual, "c1")
+
+			So(intent.BSONFile.Open(), ShouldBeNil)
+			contents, err := ioutil.ReadAll(intent.BSONFile)
+			So(err, ShouldBeNil)
+			So(intent.BSONFile.Close(), ShouldBeNil)
+			So(contents, ShouldResemble, doc)
+
+			So(intent.MetadataFile, ShouldNotBeNil)
+			So(intent.MetadataFile.Open(), ShouldBeNil)
+			contents, err = ioutil.ReadAll(intent.MetadataFile)
+			So(err, ShouldBeNil)
+			So(intent.MetadataFile.Close(), ShouldBeNil)
+			So(string(contents), ShouldEqual, `{"indexes":[]}`)
+		})
+
+		Convey("files are detected by their extension without --decompress", func() {
+			mr := newMongoRestore()
+			mr.InputOptions.DecompressionWorkers = 4
+			So(mr.CreateIntentsForDB("db1", ddl), ShouldBeNil)
+			mr.manager.Finalize(intents.Legacy)
+			intent := mr.manager.Pop()
+			So(intent, ShouldNotBeNil)
+			So(intent.C, ShouldEqual, "c1")
+			So(intent.MetadataFile, ShouldNotBeNil)
+
+			So(intent.BSONFile.Open(), ShouldBeNil)
+			contents, err := ioutil.ReadAll(intent.BSONFile)
+			So(err, ShouldBeNil)
+			So(intent.BSONFile.Close(), ShouldBeNil)
+			So(contents, ShouldResemble, doc)
+		})
+
+		Convey("files are left out with another --decompress codec", func() {
+			mr := newMongoRestore()
+			mr.InputOptions.Decompress = "lz4"
+			So(mr.CreateIntentsForDB("db1", ddl), ShouldBeNil)
+			mr.manager.Finalize(intents.Legacy)
+			So(mr.manager.Pop(), ShouldBeNil)
+		})
+	})
+
+	Convey("With an archive", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_compressed")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		data := bytes.Repeat([]byte("archive contents "), 1000)
+
+		mr := newMongoRestore()
+		mr.InputOptions.Archive = filepath.Join(dir, "dump.archive")
+
+		for _, name := range append(compression.Names(), "none") {
+			Convey("compressed with "+name+", the codec is detected", func() {
+				codec, err := compression.Parse(name)
+				So(err, ShouldBeNil)
+				if codec.IsNone() {
+					So(ioutil.WriteFile(mr.InputOptions.Archive, data, 0644), ShouldBeNil)
+				} else {
+					writeCompressed(mr.InputOptions.Archive, codec, data)
+				}
+
+				rc, err := mr.getArchiveReader()
+				So(err, ShouldBeNil)
+				contents, err := ioutil.ReadAll(rc)
+				So(err, ShouldBeNil)
+				So(rc.Close(), ShouldBeNil)
+				So(contents, ShouldResemble, data)
+			})
+		}
+
+		Convey("an option that doesn't match the archive is an error", func() {
+			writeCompressed(mr.InputOptions.Archive, compression.Codec{Name: compression.LZ4Name}, data)
+			mr.InputOptions.Gzip = true
+			_, err := mr.getArchiveReader()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestDetectedCompression(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With bson files compressed without an extension", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_detected")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		// enough documents to span several lz4 blocks
+		var data []byte
+		for i := 0; len(data) < 10<<20; i++ {
+			doc, err := bson.Marshal(bson.M{"_id": i, "padding": strings.Repeat("x", 1000)})
+			So(err, ShouldBeNil)
+			data = append(data, doc...)
+		}
+
+		Convey("an uncompressed file is read as it is", func() {
+			path := filepath.Join(dir, "plain.bson")
+			So(ioutil.WriteFile(path, data, 0644), ShouldBeNil)
+			file := &realBSONFile{path: path, intent: &intents.Intent{}, codec: compression.Codec{Workers: 4}}
+			So(file.Open(), ShouldBeNil)
+			contents, err := ioutil.ReadAll(file)
+			So(err, ShouldBeNil)
+			So(file.Close(), ShouldBeNil)
+			So(contents, ShouldResemble, data)
+		})
+
+		for _, name := range []string{compression.ZstdName, compression.LZ4Name} {
+			Convey("a file compressed with "+name+" is decompressed in parallel", func() {
+				path := filepath.Join(dir, name+".bson")
+				writeCompressed(path, compression.Codec{Name: name}, data)
+				file := &realBSONFile{path: path, intent: &intents.Intent{}, codec: compression.Codec{Workers: 4}}
+				So(file.Open(), ShouldBeNil)
+				contents, err := ioutil.ReadAll(file)
+				So(err, ShouldBeNil)
+				So(file.Close(), ShouldBeNil)
+				So(contents, ShouldResemble, data)
+			})
+		}
+	})
+}
+
+func TestSplitInput(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a collection split into segments", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_split")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(os.Mkdir(filepath.Join(dir, "db1"), 0755), ShouldBeNil)
+
+		var data []byte
+		for i := 0; i < 10; i++ {
+			doc, err := bson.Marshal(bson.M{"_id": i})
+			So(err, ShouldBeNil)
+			data = append(data, doc...)
+		}
+		path := filepath.Join(dir, "db1", "c1.bson")
+		for i := 0; i*64 < len(data); i++ {
+			end := (i + 1) * 64
+			if end > len(data) {
+				end = len(data)
+			}
+			So(ioutil.WriteFile(util.SegmentPath(path, i), data[i*64:end], 0644), ShouldBeNil)
+		}
+		So(ioutil.WriteFile(filepath.Join(dir, "db1", "c1.metadata.json"), []byte(`{"indexes":[]}`), 0644), ShouldBeNil)
+
+		Convey("the segments are restored as one collection", func() {
+			mr := newMongoRestore()
+			ddl, err := newActualPath(filepath.Join(dir, "db1"))
+			So(err, ShouldBeNil)
+			So(mr.CreateIntentsForDB("db1", ddl), ShouldBeNil)
+			mr.manager.Finalize(intents.Legacy)
+			intent := mr.manager.Pop()
+			So(intent, ShouldNotBeNil)
+			So(intent.C, ShouldEqual, "c1")
+			So(intent.Size, ShouldEqual, len(data))
+			So(intent.MetadataFile, ShouldNotBeNil)
+			So(mr.manager.Pop(), ShouldBeNil)
+
+			So(intent.BSONFile.Open(), ShouldBeNil)
+			contents, err := ioutil.ReadAll(intent.BSONFile)
+			So(err, ShouldBeNil)
+			So(intent.BSONFile.Close(), ShouldBeNil)
+			So(contents, ShouldResemble, data)
+		})
+
+		Convey("the split file can be named as the target", func() {
+			target, err := newActualPath(path)
+			So(err, ShouldBeNil)
+			So(target.IsDir(), ShouldBeFalse)
+			So(target.Name(), ShouldEqual, "c1.bson")
+			So(target.Size(), ShouldEqual, len(data))
+		})
+
+		Convey("a missing segment is an error", func() {
+			So(os.Remove(util.SegmentPath(path, 1)), ShouldBeNil)
+			ddl, err := newActualPath(filepath.Join(dir, "db1"))
+			So(err, ShouldBeNil)
+			_, err = ddl.ReadDir()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "missing")
+		})
+	})
+}
+
+func TestDataExcludedInput(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a dump of a collection and its metadata", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_metadata_only")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(os.Mkdir(filepath.Join(dir, "db1"), 0755), ShouldBeNil)
+		doc, err := bson.Marshal(bson.M{"_id": 1})
+		So(err, ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "db1", "c1.bson"), doc, 0644), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "db1", "c1.metadata.json"), []byte(`{"indexes":[]}`), 0644), ShouldBeNil)
+
+		Convey("--metadataOnly restores the metadata without the documents", func() {
+			mr := newMongoRestore()
+			mr.OutputOptions.MetadataOnly = true
+			ddl, err := newActualPath(filepath.Join(dir, "db1"))
+			So(err, ShouldBeNil)
+			So(mr.CreateIntentsForDB("db1", ddl), ShouldBeNil)
+			mr.manager.Finalize(intents.Legacy)
+			intent := mr.manager.Pop()
+			So(intent, ShouldNotBeNil)
+			So(intent.C, ShouldEqual, "c1")
+			So(intent.MetadataFile, ShouldNotBeNil)
+			So(intent.BSONFile, ShouldBeNil)
+			So(mr.manager.Pop(), ShouldBeNil)
+		})
+
+		Convey("--indexesOnly works with a single collection as the target", func() {
+			mr := newMongoRestore()
+			mr.OutputOptions.IndexesOnly = true
+			bsonFile, err := newActualPath(filepath.Join(dir, "db1", "c1.bson"))
+			So(err, ShouldBeNil)
+			So(mr.CreateIntentForCollection("db1", "c1", bsonFile), ShouldBeNil)
+			mr.manager.Finalize(intents.Legacy)
+			intent := mr.manager.Pop()
+			So(intent, ShouldNotBeNil)
+			So(intent.MetadataFile, ShouldNotBeNil)
+			So(intent.BSONFile, ShouldBeNil)
+		})
+	})
+}
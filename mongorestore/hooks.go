@@ -0,0 +1,145 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// Events that restore hooks are run for: once before and after the whole
+// restore, and before and after each namespace.
+const (
+	preRunEvent      = "preRun"
+	postRunEvent     = "postRun"
+	preRestoreEvent  = "preRestore"
+	postRestoreEvent = "postRestore"
+)
+
+// restoreHookEvent describes what a hook is run for. It is written to the
+// hook's stdin as JSON, and is also in its environment.
+type restoreHookEvent struct {
+	Event string `json:"event"`
+	// the namespace is only set for the events of a namespace
+	Namespace  string `json:"ns,omitempty"`
+	DB         string `json:"db,omitempty"`
+	Collection string `json:"collection,omitempty"`
+	// Source is where the namespace is restored from
+	Source string `json:"source,omitempty"`
+	// the rest is only set after the namespace or the run is restored
+	Documents *int64   `json:"documents,omitempty"`
+	Failures  *int64   `json:"failures,omitempty"`
+	Seconds   *float64 `json:"seconds,omitempty"`
+	// Error is the error the run failed with, for a postRun hook
+	Error string `json:"error,omitempty"`
+}
+
+// newRestoreHookEvent returns the event of a hook for intent.
+func newRestoreHookEvent(event string, intent *intents.Intent) *restoreHookEvent {
+	return &restoreHookEvent{
+		Event:      event,
+		Namespace:  intent.Namespace(),
+		DB:         intent.DB,
+		Collection: intent.C,
+		Source:     intent.Location,
+	}
+}
+
+// setResult records how a namespace or the run was restored.
+func (e *restoreHookEvent) setResult(result Result, elapsed time.Duration) {
+	seconds := elapsed.Seconds()
+	e.Documents, e.Failures, e.Seconds = &result.Successes, &result.Failures, &seconds
+}
+
+// environment returns the event as environment variables.
+func (e *restoreHookEvent) environment() []string {
+	env := []string{
+		"MONGORESTORE_EVENT=" + e.Event,
+		"MONGORESTORE_NS=" + e.Namespace,
+		"MONGORESTORE_DB=" + e.DB,
+		"MONGORESTORE_COLLECTION=" + e.Collection,
+		"MONGORESTORE_SOURCE=" + e.Source,
+	}
+	if e.Documents != nil {
+		env = append(env, "MONGORESTORE_DOCUMENTS="+strconv.FormatInt(*e.Documents, 10))
+	}
+	if e.Failures != nil {
+		env = append(env, "MONGORESTORE_FAILURES="+strconv.FormatInt(*e.Failures, 10))
+	}
+	if e.Seconds != nil {
+		env = append(env, "MONGORESTORE_SECONDS="+strconv.FormatFloat(*e.Seconds, 'f', 3, 64))
+	}
+	if e.Error != "" {
+		env = append(env, "MONGORESTORE_ERROR="+e.Error)
+	}
+	return env
+}
+
+// runPreRunHook runs --preRestoreHook, if it is set, before anything is
+// restored.
+func (restore *MongoRestore) runPreRunHook() error {
+	if restore.OutputOptions.PreRestoreHook == "" {
+		return nil
+	}
+	return runRestoreHook(restore.OutputOptions.PreRestoreHook, &restoreHookEvent{Event: preRunEvent}, log.WithFields())
+}
+
+// runPostRunHook runs --postRestoreHook, if it is set, once the restore is
+// finished, whether or not it failed. It returns the result of the restore
+// with the error of the hook if the restore succeeded.
+func (restore *MongoRestore) runPostRunHook(result Result, elapsed time.Duration) Result {
+	if restore.OutputOptions.PostRestoreHook == "" {
+		return result
+	}
+	event := &restoreHookEvent{Event: postRunEvent}
+	event.setResult(result, elapsed)
+	if result.Err != nil {
+		event.Error = result.Err.Error()
+	}
+	err := runRestoreHook(restore.OutputOptions.PostRestoreHook, event, log.WithFields())
+	if err != nil && result.Err != nil {
+		log.Logvf(log.Always, "%v", err)
+	} else if err != nil {
+		result.Err = err
+	}
+	return result
+}
+
+// runPreRestoreHook runs --preRestoreHook, if it is set, before intent is
+// restored.
+func (restore *MongoRestore) runPreRestoreHook(intent *intents.Intent, intentLog *log.FieldLogger) error {
+	if restore.OutputOptions.PreRestoreHook == "" {
+		return nil
+	}
+	return runRestoreHook(restore.OutputOptions.PreRestoreHook, newRestoreHookEvent(preRestoreEvent, intent), intentLog)
+}
+
+// runPostRestoreHook runs --postRestoreHook, if it is set, after intent is
+// restored, with the number of documents restored and how long it took.
+func (restore *MongoRestore) runPostRestoreHook(intent *intents.Intent, result Result, elapsed time.Duration,
+	intentLog *log.FieldLogger) error {
+	if restore.OutputOptions.PostRestoreHook == "" {
+		return nil
+	}
+	event := newRestoreHookEvent(postRestoreEvent, intent)
+	event.setResult(result, elapsed)
+	return runRestoreHook(restore.OutputOptions.PostRestoreHook, event, intentLog)
+}
+
+// runRestoreHook runs command with the shell, giving it event on stdin and
+// in its environment. A command that fails fails the restore.
+func runRestoreHook(command string, event *restoreHookEvent, hookLog *log.FieldLogger) error {
+	subject := "the restore"
+	if event.Namespace != "" {
+		subject = event.Namespace
+	}
+	return util.RunHook(command, event.Event, subject, event, event.environment(), hookLog)
+}
@@ -0,0 +1,105 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRestoreHooks(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands are run with sh")
+	}
+
+	dir, err := ioutil.TempDir("", "mongorestore-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	intent := &intents.Intent{DB: "db", C: "c", Location: "dump/db/c.bson"}
+	stdin, env := filepath.Join(dir, "stdin.json"), filepath.Join(dir, "env")
+	record := "cat > " + stdin + "; env | grep ^MONGORESTORE_ | sort > " + env
+
+	Convey("A post-restore hook gets the namespace and its result on stdin and in its environment", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{PostRestoreHook: record}}
+		err := restore.runPostRestoreHook(intent, Result{Successes: 3, Failures: 1}, 1500*time.Millisecond, log.WithFields())
+		So(err, ShouldBeNil)
+
+		input, err := ioutil.ReadFile(stdin)
+		So(err, ShouldBeNil)
+		var event restoreHookEvent
+		So(json.Unmarshal(input, &event), ShouldBeNil)
+		So(event.Event, ShouldEqual, postRestoreEvent)
+		So(event.Namespace, ShouldEqual, "db.c")
+		So(event.Source, ShouldEqual, "dump/db/c.bson")
+		So(*event.Documents, ShouldEqual, 3)
+		So(*event.Failures, ShouldEqual, 1)
+		So(*event.Seconds, ShouldEqual, 1.5)
+
+		variables, err := ioutil.ReadFile(env)
+		So(err, ShouldBeNil)
+		So(strings.Split(strings.TrimSpace(string(variables)), "\n"), ShouldResemble, []string{
+			"MONGORESTORE_COLLECTION=c",
+			"MONGORESTORE_DB=db",
+			"MONGORESTORE_DOCUMENTS=3",
+			"MONGORESTORE_EVENT=postRestore",
+			"MONGORESTORE_FAILURES=1",
+			"MONGORESTORE_NS=db.c",
+			"MONGORESTORE_SECONDS=1.500",
+			"MONGORESTORE_SOURCE=dump/db/c.bson",
+		})
+	})
+
+	Convey("A post-run hook gets the error of a failed restore, without replacing it", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{PostRestoreHook: record + "; exit 1"}}
+		result := restore.runPostRunHook(Result{Successes: 2, Err: errors.New("no space left")}, time.Second)
+		So(result.Err.Error(), ShouldEqual, "no space left")
+
+		input, err := ioutil.ReadFile(stdin)
+		So(err, ShouldBeNil)
+		var event restoreHookEvent
+		So(json.Unmarshal(input, &event), ShouldBeNil)
+		So(event.Event, ShouldEqual, postRunEvent)
+		So(event.Namespace, ShouldEqual, "")
+		So(event.Error, ShouldEqual, "no space left")
+		So(*event.Documents, ShouldEqual, 2)
+
+		Convey("and fails a restore that succeeded", func() {
+			result := restore.runPostRunHook(Result{Successes: 2}, time.Second)
+			So(result.Err, ShouldNotBeNil)
+			So(result.Err.Error(), ShouldContainSubstring, "postRun hook for the restore failed")
+		})
+	})
+
+	Convey("A failing pre-restore hook is an error, and unset hooks aren't run", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}}
+		So(restore.runPreRunHook(), ShouldBeNil)
+		So(restore.runPreRestoreHook(intent, log.WithFields()), ShouldBeNil)
+		restore.OutputOptions.PreRestoreHook = "echo not ready; exit 3"
+		err := restore.runPreRestoreHook(intent, log.WithFields())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "preRestore hook for db.c failed")
+		err = restore.runPreRunHook()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "preRun hook for the restore failed")
+	})
+}
@@ -0,0 +1,246 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// indexBuild is the indexes of one collection, queued by --deferIndexBuilds
+// or --indexDefinitionsFile.
+type indexBuild struct {
+	db                    string
+	collection            string
+	indexes               []IndexDocument
+	hasNonSimpleCollation bool
+}
+
+// indexSkip is a pattern of --skipIndex, which is an index name pattern
+// optionally preceded by a namespace pattern and a colon, e.g.
+// 'test.*:tmp_*'.
+type indexSkip struct {
+	// namespace is nil if the pattern applies to every namespace
+	namespace *ns.Matcher
+	name      string
+}
+
+// parseIndexSkip parses a --skipIndex pattern.
+func parseIndexSkip(pattern string) (indexSkip, error) {
+	var skip indexSkip
+	if i := strings.LastIndex(pattern, ":"); i >= 0 {
+		matcher, err := ns.NewMatcher([]string{pattern[:i]})
+		if err != nil {
+			return skip, err
+		}
+		skip.namespace, pattern = matcher, pattern[i+1:]
+	}
+	if pattern == "" {
+		return skip, fmt.Errorf("missing index name")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return skip, fmt.Errorf("invalid index name pattern '%v': %v", pattern, err)
+	}
+	skip.name = pattern
+	return skip, nil
+}
+
+// matches returns whether the index of the given name on the namespace is
+// skipped.
+func (skip indexSkip) matches(namespace, name string) bool {
+	if skip.namespace != nil && !skip.namespace.Has(namespace) {
+		return false
+	}
+	matched, _ := path.Match(skip.name, name)
+	return matched
+}
+
+// parseCommitQuorum parses --indexCommitQuorum, which is either a number of
+// data-bearing voting members or the name of a quorum ('majority',
+// 'votingMembers' or a replica set tag).
+func parseCommitQuorum(value string) interface{} {
+	if n, err := strconv.ParseInt(value, 10, 32); err == nil {
+		return int32(n)
+	}
+	return value
+}
+
+// initIndexBuilds checks and sets up the options controlling index builds:
+// --deferIndexBuilds, --indexCommitQuorum, --maxConcurrentIndexBuilds,
+// --skipIndex and --indexDefinitionsFile.
+func (restore *MongoRestore) initIndexBuilds() error {
+	options := restore.OutputOptions
+	controlled := options.DeferIndexBuilds || options.IndexCommitQuorum != "" ||
+		options.MaxConcurrentIndexBuilds != 0 || len(options.SkipIndexes) > 0 || options.IndexDefinitionsFile != ""
+	switch {
+	case !controlled:
+		return nil
+	case options.NoIndexRestore:
+		return fmt.Errorf("cannot use --noIndexRestore with options controlling index builds")
+	case options.MaxConcurrentIndexBuilds < 0:
+		return fmt.Errorf("maxConcurrentIndexBuilds must be positive")
+	case options.DeferIndexBuilds && options.IndexDefinitionsFile != "":
+		return fmt.Errorf("cannot use %v with %v", DeferIndexBuildsOption, IndexDefinitionsFileOption)
+	case options.DeferIndexBuilds && options.Resume:
+		return fmt.Errorf("cannot use %v with %v", DeferIndexBuildsOption, ResumeOption)
+	case options.IndexDefinitionsFile != "" && options.Verify:
+		return fmt.Errorf("cannot use %v with %v", IndexDefinitionsFileOption, VerifyOption)
+	}
+	if options.IndexCommitQuorum != "" {
+		if restore.serverVersion.LT(db.Version{4, 4, 0}) {
+			return fmt.Errorf("%v requires a server version of 4.4 or later", IndexCommitQuorumOption)
+		}
+		restore.indexCommitQuorum = parseCommitQuorum(options.IndexCommitQuorum)
+	}
+	if options.MaxConcurrentIndexBuilds > 0 {
+		restore.indexBuildSlots = make(chan struct{}, options.MaxConcurrentIndexBuilds)
+	}
+	for _, pattern := range options.SkipIndexes {
+		skip, err := parseIndexSkip(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid --skipIndex '%v': %v", pattern, err)
+		}
+		restore.indexSkips = append(restore.indexSkips, skip)
+	}
+	return nil
+}
+
+// filterIndexes returns the indexes of the namespace not skipped by
+// --skipIndex.
+func (restore *MongoRestore) filterIndexes(namespace string, indexes []IndexDocument) []IndexDocument {
+	if len(restore.indexSkips) == 0 {
+		return indexes
+	}
+	var kept []IndexDocument
+	for _, index := range indexes {
+		name, _ := index.Options["name"].(string)
+		skipped := false
+		for _, skip := range restore.indexSkips {
+			if skip.matches(namespace, name) {
+				skipped = true
+				break
+			}
+		}
+		if skipped {
+			indexLog.Logvf(log.Info, "skipping index %v on %v", name, namespace)
+			continue
+		}
+		kept = append(kept, index)
+	}
+	return kept
+}
+
+// queuesIndexBuilds returns whether the indexes of restored collections are
+// queued until all of them are restored rather than built right away.
+func (restore *MongoRestore) queuesIndexBuilds() bool {
+	return restore.OutputOptions.DeferIndexBuilds || restore.OutputOptions.IndexDefinitionsFile != ""
+}
+
+// buildIndexes builds the indexes of a restored collection, or queues them
+// with --deferIndexBuilds or --indexDefinitionsFile.
+func (restore *MongoRestore) buildIndexes(build indexBuild) error {
+	if restore.queuesIndexBuilds() {
+		restore.indexBuildsMutex.Lock()
+		restore.deferredIndexBuilds = append(restore.deferredIndexBuilds, build)
+		restore.indexBuildsMutex.Unlock()
+		return nil
+	}
+	err := restore.CreateIndexes(build.db, build.collection, build.indexes, build.hasNonSimpleCollation)
+	if err != nil {
+		return err
+	}
+	log.Summary().AddCount("indexes", int64(len(build.indexes)))
+	return nil
+}
+
+// finishIndexBuilds builds the indexes queued by --deferIndexBuilds, or
+// writes them to the --indexDefinitionsFile.
+func (restore *MongoRestore) finishIndexBuilds() error {
+	if len(restore.deferredIndexBuilds) == 0 {
+		return nil
+	}
+	if restore.OutputOptions.IndexDefinitionsFile != "" {
+		return restore.writeIndexDefinitions()
+	}
+	log.Logvf(log.Always, "building the deferred indexes of %v collections", len(restore.deferredIndexBuilds))
+
+	builds := make(chan indexBuild, len(restore.deferredIndexBuilds))
+	for _, build := range restore.deferredIndexBuilds {
+		builds <- build
+	}
+	close(builds)
+
+	workers := restore.OutputOptions.NumParallelCollections
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	var errMutex sync.Mutex
+	var firstErr error
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer util.RecoverCrash()
+			defer wg.Done()
+			for build := range builds {
+				namespace := build.db + "." + build.collection
+				log.Logmf(log.Always, msgRestoringIndexes, namespace)
+				err := restore.CreateIndexes(build.db, build.collection, build.indexes, build.hasNonSimpleCollation)
+				if err != nil {
+					errMutex.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("error creating indexes for %v: %v", namespace, err)
+					}
+					errMutex.Unlock()
+					return
+				}
+				log.Summary().AddCount("indexes", int64(len(build.indexes)))
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// writeIndexDefinitions writes the queued indexes to the
+// --indexDefinitionsFile, one extended JSON document per collection with
+// its namespace and the indexes of a createIndexes command.
+func (restore *MongoRestore) writeIndexDefinitions() error {
+	var out bytes.Buffer
+	count := 0
+	for _, build := range restore.deferredIndexBuilds {
+		if _, err := restore.prepareIndexes(build.db, build.collection, build.indexes, build.hasNonSimpleCollation); err != nil {
+			return err
+		}
+		line, err := bson.MarshalExtJSON(bson.D{
+			{Key: "ns", Value: build.db + "." + build.collection},
+			{Key: "indexes", Value: build.indexes},
+		}, false, false)
+		if err != nil {
+			return fmt.Errorf("error encoding the indexes of %v.%v: %v", build.db, build.collection, err)
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+		count += len(build.indexes)
+	}
+	if err := ioutil.WriteFile(restore.OutputOptions.IndexDefinitionsFile, out.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing --indexDefinitionsFile: %v", err)
+	}
+	log.Logvf(log.Always, "wrote %v index definitions to %v without building them", count, restore.OutputOptions.IndexDefinitionsFile)
+	return nil
+}
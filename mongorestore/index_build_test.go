@@ -0,0 +1,133 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func testIndex(name string) IndexDocument {
+	return IndexDocument{
+		Options: bson.M{"name": name, "v": 2},
+		Key:     bson.D{{Key: name, Value: 1}},
+	}
+}
+
+func TestIndexSkips(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Index skip patterns match index names on namespaces", t, func() {
+		skip, err := parseIndexSkip("tmp_*")
+		So(err, ShouldBeNil)
+		So(skip.matches("test.c", "tmp_1"), ShouldBeTrue)
+		So(skip.matches("other.c", "tmp_1"), ShouldBeTrue)
+		So(skip.matches("test.c", "a_1"), ShouldBeFalse)
+
+		skip, err = parseIndexSkip("test.*:a_1")
+		So(err, ShouldBeNil)
+		So(skip.matches("test.c", "a_1"), ShouldBeTrue)
+		So(skip.matches("other.c", "a_1"), ShouldBeFalse)
+
+		_, err = parseIndexSkip("test.c:")
+		So(err, ShouldNotBeNil)
+		_, err = parseIndexSkip("[")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Skipped indexes are filtered out", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{SkipIndexes: []string{"test.c:b_*"}}}
+		So(restore.initIndexBuilds(), ShouldBeNil)
+		indexes := []IndexDocument{testIndex("a_1"), testIndex("b_1")}
+		kept := restore.filterIndexes("test.c", indexes)
+		So(kept, ShouldHaveLength, 1)
+		So(kept[0].Options["name"], ShouldEqual, "a_1")
+		So(restore.filterIndexes("test.d", indexes), ShouldHaveLength, 2)
+	})
+}
+
+func TestInitIndexBuilds(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("The index build options are validated", t, func() {
+		restore := &MongoRestore{
+			OutputOptions: &OutputOptions{MaxConcurrentIndexBuilds: 2, IndexCommitQuorum: "majority"},
+			serverVersion: db.Version{4, 4, 0},
+		}
+		So(restore.initIndexBuilds(), ShouldBeNil)
+		So(cap(restore.indexBuildSlots), ShouldEqual, 2)
+		So(restore.indexCommitQuorum, ShouldEqual, "majority")
+
+		restore.OutputOptions = &OutputOptions{IndexCommitQuorum: "2"}
+		So(restore.initIndexBuilds(), ShouldBeNil)
+		So(restore.indexCommitQuorum, ShouldEqual, int32(2))
+
+		restore.serverVersion = db.Version{4, 2, 0}
+		So(restore.initIndexBuilds(), ShouldNotBeNil)
+
+		for _, options := range []*OutputOptions{
+			{MaxConcurrentIndexBuilds: -1},
+			{DeferIndexBuilds: true, NoIndexRestore: true},
+			{DeferIndexBuilds: true, IndexDefinitionsFile: "indexes.json"},
+			{DeferIndexBuilds: true, Resume: true},
+			{IndexDefinitionsFile: "indexes.json", Verify: true},
+		} {
+			restore.OutputOptions = options
+			So(restore.initIndexBuilds(), ShouldNotBeNil)
+		}
+	})
+}
+
+func TestIndexDefinitionsFile(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With --indexDefinitionsFile", t, func() {
+		dir, err := ioutil.TempDir("", "index-definitions")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "indexes.json")
+		restore := &MongoRestore{OutputOptions: &OutputOptions{IndexDefinitionsFile: path}}
+		So(restore.initIndexBuilds(), ShouldBeNil)
+
+		Convey("indexes are queued and written without being built", func() {
+			So(restore.buildIndexes(indexBuild{db: "test", collection: "c", indexes: []IndexDocument{testIndex("a_1")}}), ShouldBeNil)
+			So(restore.buildIndexes(indexBuild{db: "test", collection: "d", indexes: []IndexDocument{testIndex("b_1")}}), ShouldBeNil)
+			So(restore.deferredIndexBuilds, ShouldHaveLength, 2)
+			So(restore.finishIndexBuilds(), ShouldBeNil)
+
+			data, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			So(lines, ShouldHaveLength, 2)
+
+			var definition struct {
+				NS      string   `bson:"ns"`
+				Indexes []bson.M `bson:"indexes"`
+			}
+			So(bson.UnmarshalExtJSON([]byte(lines[0]), false, &definition), ShouldBeNil)
+			So(definition.NS, ShouldEqual, "test.c")
+			So(definition.Indexes, ShouldHaveLength, 1)
+			So(definition.Indexes[0]["name"], ShouldEqual, "a_1")
+			So(definition.Indexes[0]["ns"], ShouldEqual, "test.c")
+			So(definition.Indexes[0], ShouldNotContainKey, "v")
+		})
+
+		Convey("nothing is written if there are no indexes", func() {
+			So(restore.finishIndexBuilds(), ShouldBeNil)
+			_, err := os.Stat(path)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
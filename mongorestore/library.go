@@ -0,0 +1,143 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// progressInterval is how often OnProgress is called for each part of the
+// restore in progress.
+const progressInterval = time.Second
+
+// Progress is the state of a part of a restore, given to OnProgress. Name
+// is the namespace being restored, or "total", "archive" or "oplog".
+type Progress struct {
+	Name    string
+	Current int64
+	Total   int64
+	// Documents is set if Current and Total count documents rather than
+	// bytes
+	Documents bool
+	// Done is set on the last call for the part
+	Done bool
+}
+
+// WriteError is a write rejected by the target, given to OnWriteError. The
+// restore goes on unless --stopOnError is set.
+type WriteError struct {
+	Namespace string
+	// Operation is "insert", "replace" or "update", as given by --mode
+	Operation string
+	// ID is the _id of the document, if known
+	ID       interface{}
+	Document interface{}
+	Code     int
+	Message  string
+}
+
+// fsCount numbers the file systems registered by RestoreFS.
+var fsCount int64
+
+// RestoreArchive restores the archive read from r, as mongorestore does
+// with --archive from stdin. It stops when ctx is done, in which case the
+// result's error is ctx.Err().
+//
+//	opts, err := mongorestore.ParseOptions([]string{"--uri=mongodb://localhost", "--drop"}, "", "")
+//	...
+//	restore, err := mongorestore.New(opts)
+//	...
+//	defer restore.Close()
+//	restore.OnProgress = func(p mongorestore.Progress) { ... }
+//	result := restore.RestoreArchive(ctx, archive)
+func (restore *MongoRestore) RestoreArchive(ctx context.Context, r io.Reader) Result {
+	restore.InputOptions.Archive = "-"
+	restore.InputReader = r
+	return restore.RestoreContext(ctx)
+}
+
+// RestoreFS restores the dump directory at the root of fsys, as
+// mongorestore does with --dir, e.g. an http.Dir. It stops when ctx is done, in which case
+// the result's error is ctx.Err().
+func (restore *MongoRestore) RestoreFS(ctx context.Context, fsys http.FileSystem) Result {
+	name := fmt.Sprintf("mongorestore-%v", atomic.AddInt64(&fsCount, 1))
+	restore.TargetDirectory = storage.RegisterFS(name, fsys)
+	defer storage.UnregisterFS(name)
+	return restore.RestoreContext(ctx)
+}
+
+// RestoreContext restores with the options of the MongoRestore, like
+// Restore, stopping when ctx is done, in which case the result's error is
+// ctx.Err(). If OnProgress is set, it is called with the progress of the
+// restore instead of showing progress bars.
+func (restore *MongoRestore) RestoreContext(ctx context.Context) Result {
+	if err := ctx.Err(); err != nil {
+		return Result{Err: err}
+	}
+	if restore.OnProgress != nil {
+		if barWriter, ok := restore.ProgressManager.(*progress.BarWriter); ok {
+			barWriter.Stop()
+		}
+		callbacks := progress.NewCallbacks(func(update progress.Update) {
+			restore.OnProgress(libraryProgress(update))
+		}, progressInterval)
+		defer callbacks.Stop()
+		restore.ProgressManager = callbacks
+	}
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			restore.HandleInterrupt()
+		case <-finished:
+		}
+	}()
+	result := restore.Restore()
+	db.FlushIgnoredErrors()
+	if ctx.Err() != nil {
+		result.Err = ctx.Err()
+	}
+	return result
+}
+
+// writeErrorCallback returns the function that calls OnWriteError with the
+// write errors of a namespace, or nil if it isn't set.
+func (restore *MongoRestore) writeErrorCallback(namespace string) func(mongo.BulkWriteError) {
+	if restore.OnWriteError == nil {
+		return nil
+	}
+	return func(writeErr mongo.BulkWriteError) {
+		operation, id, document := describeWrite(writeErr)
+		restore.OnWriteError(WriteError{
+			Namespace: namespace,
+			Operation: operation,
+			ID:        id,
+			Document:  document,
+			Code:      writeErr.Code,
+			Message:   writeErr.Message,
+		})
+	}
+}
+
+// libraryProgress converts the progress of a part of the restore to what
+// OnProgress is given.
+func libraryProgress(update progress.Update) Progress {
+	return Progress{Name: update.Name, Current: update.Current, Total: update.Total,
+		Documents: update.Documents, Done: update.Done}
+}
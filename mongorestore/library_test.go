@@ -0,0 +1,151 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestRestoreContext(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("A cancelled context stops the restore before it starts", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		restore := &MongoRestore{InputOptions: &InputOptions{}, OutputOptions: &OutputOptions{}}
+		So(restore.RestoreContext(ctx).Err, ShouldEqual, context.Canceled)
+		So(restore.RestoreFS(ctx, http.Dir(".")).Err, ShouldEqual, context.Canceled)
+	})
+}
+
+func TestRestoreFromFS(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	contents := map[string][]byte{
+		"test/orders.bson":          mustMarshal(bson.D{{Key: "_id", Value: int32(1)}}),
+		"test/orders.metadata.json": []byte(`{"indexes": []}`),
+		"admin/system.users.bson":   {},
+	}
+	dir, err := ioutil.TempDir("", "mongorestore-library")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, data := range contents {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = ioutil.WriteFile(path, data, 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys := http.Dir(dir)
+
+	Convey("A registered file system is read like a dump in object storage", t, func() {
+		location := storage.RegisterFS("library-test", fsys)
+		defer storage.UnregisterFS("library-test")
+		So(storage.IsRemote(location), ShouldBeTrue)
+
+		root, err := newTargetPath(location)
+		So(err, ShouldBeNil)
+		So(root.IsDir(), ShouldBeTrue)
+		dbs, err := root.ReadDir()
+		So(err, ShouldBeNil)
+		So(dbs, ShouldHaveLength, 2)
+		So(dbs[1].Name(), ShouldEqual, "test")
+		files, err := dbs[1].ReadDir()
+		So(err, ShouldBeNil)
+		So(files, ShouldHaveLength, 2)
+		So(files[0].Path(), ShouldEqual, location+"/test/orders.bson")
+		So(files[0].Size(), ShouldEqual, len(contents["test/orders.bson"]))
+
+		data, err := readFile(files[1].Path())
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, `{"indexes": []}`)
+
+		Convey("and a single file of it can be restored", func() {
+			file, err := newTargetPath(location + "/test/orders.bson")
+			So(err, ShouldBeNil)
+			So(file.IsDir(), ShouldBeFalse)
+			in, err := openFile(file.Path())
+			So(err, ShouldBeNil)
+			defer in.Close()
+			data, err := ioutil.ReadAll(in)
+			So(err, ShouldBeNil)
+			So(data, ShouldResemble, contents["test/orders.bson"])
+		})
+	})
+
+	Convey("An unregistered file system can't be read", t, func() {
+		location := storage.RegisterFS("library-gone", fsys)
+		_, err := readFile(location + "/test/orders.metadata.json")
+		So(err, ShouldBeNil)
+		storage.UnregisterFS("library-gone")
+		_, err = readFile(location + "/test/orders.metadata.json")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestWriteErrorCallback(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("OnWriteError is given the writes the target rejects", t, func() {
+		var writeErrors []WriteError
+		restore := &MongoRestore{
+			OutputOptions: &OutputOptions{Mode: modeInsertIgnore},
+			OnWriteError:  func(writeErr WriteError) { writeErrors = append(writeErrors, writeErr) },
+		}
+		handler := restore.writeErrorHandler("test.orders")
+		So(handler, ShouldNotBeNil)
+
+		doc := mustMarshal(bson.D{{Key: "_id", Value: int32(7)}, {Key: "a", Value: "x"}})
+		handler(mongo.BulkWriteError{
+			WriteError: mongo.WriteError{Code: 121, Message: "Document failed validation"},
+			Request:    mongo.NewInsertOneModel().SetDocument(doc),
+		})
+		// skipped duplicates aren't errors
+		handler(mongo.BulkWriteError{
+			WriteError: mongo.WriteError{Code: 11000, Message: "duplicate key"},
+			Request:    mongo.NewInsertOneModel().SetDocument(doc),
+		})
+		So(writeErrors, ShouldHaveLength, 1)
+		So(writeErrors[0].Namespace, ShouldEqual, "test.orders")
+		So(writeErrors[0].Operation, ShouldEqual, "insert")
+		So(writeErrors[0].ID, ShouldResemble, bson.Raw(doc).Lookup("_id"))
+		So(writeErrors[0].Document, ShouldResemble, bson.Raw(doc))
+		So(writeErrors[0].Code, ShouldEqual, 121)
+	})
+
+	Convey("Without --errorFile or OnWriteError write errors aren't handled", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}}
+		So(restore.writeErrorHandler("test.orders"), ShouldBeNil)
+	})
+}
+
+func TestLibraryProgress(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Progress of a part is given to OnProgress", t, func() {
+		So(libraryProgress(progress.Update{Name: "test.orders", Current: 4, Total: 10, Documents: true, Done: true}),
+			ShouldResemble, Progress{Name: "test.orders", Current: 4, Total: 10, Documents: true, Done: true})
+		So(libraryProgress(progress.Update{Name: "archive", Current: 100, Total: 200}),
+			ShouldResemble, Progress{Name: "archive", Current: 100, Total: 200})
+	})
+}
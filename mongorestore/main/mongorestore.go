@@ -8,9 +8,10 @@
 package main
 
 import (
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/signals"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/signals"
+	"github.com/mongodb/mongo-tools/common/util"
 	"github.com/mongodb/mongo-tools/mongorestore"
 
 	"os"
@@ -27,8 +28,10 @@ func main() {
 	if err != nil {
 		log.Logvf(log.Always, "error parsing command line options: %s", err.Error())
 		log.Logvf(log.Always, util.ShortUsage("mongorestore"))
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitBadOptions)
 	}
+	defer util.Shutdown()
+	defer util.RecoverCrash()
 
 	// print help or version info, if specified
 	if opts.PrintHelp(false) {
@@ -42,7 +45,7 @@ func main() {
 	restore, err := mongorestore.New(opts)
 	if err != nil {
 		log.Logvf(log.Always, err.Error())
-		os.Exit(util.ExitFailure)
+		util.Exit(util.FailureExitCode(err))
 	}
 	defer restore.Close()
 
@@ -50,18 +53,20 @@ func main() {
 	defer close(finishedChan)
 
 	result := restore.Restore()
+	db.FlushIgnoredErrors()
+	exitCode := util.ExitSuccess
 	if result.Err != nil {
-		log.Logvf(log.Always, "Failed: %v", result.Err)
+		exitCode = util.LogFailure(result.Err)
 	}
 
 	if restore.ToolOptions.WriteConcern.Acknowledged() {
 		log.Logvf(log.Always, "%v document(s) restored successfully. %v document(s) failed to restore.", result.Successes, result.Failures)
+		if result.Skipped > 0 {
+			log.Logvf(log.Always, "%v document(s) skipped with --mode.", result.Skipped)
+		}
 	} else {
 		log.Logvf(log.Always, "done")
 	}
 
-	if result.Err != nil {
-		os.Exit(util.ExitFailure)
-	}
-	os.Exit(util.ExitSuccess)
+	util.Exit(exitCode)
 }
@@ -0,0 +1,59 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/text"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// minMemoryBudget is the smallest --maxMemory, enough for a few batches of
+// documents of the largest size.
+const minMemoryBudget = 64 * 1024 * 1024
+
+// initMemoryBudget parses --maxMemory, which bounds the documents held by
+// the archive's buffers and the batches of all collections together. The
+// --archiveReadAhead buffer has a fixed size, so it is taken from the
+// budget up front.
+func (restore *MongoRestore) initMemoryBudget() error {
+	if restore.OutputOptions.MaxMemory == "" {
+		return nil
+	}
+	limit, err := text.ParseByteAmount(restore.OutputOptions.MaxMemory)
+	if err != nil {
+		return fmt.Errorf("invalid %v: %v", MaxMemoryOption, err)
+	}
+	readAhead := int64(restore.InputOptions.ArchiveReadAhead) * 1024 * 1024
+	if limit-readAhead < minMemoryBudget {
+		return fmt.Errorf("%v must be at least %v more than %v", MaxMemoryOption,
+			text.FormatByteAmount(minMemoryBudget), ArchiveReadAheadOption)
+	}
+	restore.memory = util.NewMemoryBudget(limit - readAhead)
+	log.Logvf(log.Info, "holding up to %v of documents in memory", text.FormatByteAmount(limit))
+	return nil
+}
+
+// batchBytes returns the bytes the documents of a batch hold.
+func batchBytes(docs []bson.Raw) int64 {
+	var size int64
+	for _, doc := range docs {
+		size += int64(len(doc))
+	}
+	return size
+}
+
+// getBatchDocs returns the documents of a batch from pool, at their full
+// length however many of them the batch they were last used for held.
+func getBatchDocs(pool *sync.Pool) []bson.Raw {
+	docs := pool.Get().([]bson.Raw)
+	return docs[:cap(docs)]
+}
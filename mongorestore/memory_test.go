@@ -0,0 +1,106 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/common/util"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestInitMemoryBudget(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	newRestore := func(maxMemory string, readAhead int) *MongoRestore {
+		return &MongoRestore{
+			InputOptions:  &InputOptions{ArchiveReadAhead: readAhead},
+			OutputOptions: &OutputOptions{MaxMemory: maxMemory},
+		}
+	}
+
+	Convey("Without --maxMemory nothing is bounded", t, func() {
+		restore := newRestore("", 0)
+		So(restore.initMemoryBudget(), ShouldBeNil)
+		So(restore.memory, ShouldBeNil)
+	})
+
+	Convey("--maxMemory takes a size with a unit", t, func() {
+		restore := newRestore("2GB", 0)
+		So(restore.initMemoryBudget(), ShouldBeNil)
+		So(restore.memory, ShouldNotBeNil)
+		So(restore.memory.TryAcquire(2*1024*1024*1024), ShouldBeTrue)
+		So(restore.memory.TryAcquire(1), ShouldBeFalse)
+	})
+
+	Convey("The read-ahead buffer is taken from the budget", t, func() {
+		restore := newRestore("1GB", 512)
+		So(restore.initMemoryBudget(), ShouldBeNil)
+		So(restore.memory.TryAcquire(512*1024*1024), ShouldBeTrue)
+		So(restore.memory.TryAcquire(1), ShouldBeFalse)
+
+		So(newRestore("100MB", 64).initMemoryBudget(), ShouldNotBeNil)
+	})
+
+	Convey("An invalid or too small --maxMemory is an error", t, func() {
+		So(newRestore("lots", 0).initMemoryBudget(), ShouldNotBeNil)
+		So(newRestore("1MB", 0).initMemoryBudget(), ShouldNotBeNil)
+	})
+}
+
+func TestReadBatchesWithMemoryBudget(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	doc := mustMarshal(bson.D{{Key: "_id", Value: int32(0)}})
+	var file []byte
+	for i := 0; i < 10; i++ {
+		file = append(file, mustMarshal(bson.D{{Key: "_id", Value: int32(i)}})...)
+	}
+	pool := sync.Pool{New: func() interface{} { return make([]bson.Raw, 5) }}
+	read := func(restore *MongoRestore) []docsBatch {
+		batches := make(chan docsBatch, 10)
+		source := db.NewDecodedBSONSource(db.NewBSONSource(ioutil.NopCloser(bytes.NewReader(file))))
+		restore.readBatches(source, 0, 0, &pool, batches, func() {})
+		close(batches)
+		var read []docsBatch
+		for batch := range batches {
+			read = append(read, batch)
+		}
+		return read
+	}
+
+	Convey("Batches are full while the budget has room", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{BulkBufferSize: 5}, memory: util.NewMemoryBudget(1024)}
+		batches := read(restore)
+		So(batches, ShouldHaveLength, 2)
+		So(batches[0].docs, ShouldHaveLength, 5)
+		So(batches[0].size, ShouldEqual, 5*len(doc))
+		So(restore.memory.Used(), ShouldEqual, 10*len(doc))
+	})
+
+	Convey("Batches are sent smaller once the budget is spent", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{BulkBufferSize: 5}, memory: util.NewMemoryBudget(int64(2 * len(doc)))}
+		batches := read(restore)
+		So(len(batches), ShouldBeGreaterThan, 2)
+		var documents int
+		var position int64
+		for _, batch := range batches {
+			So(len(batch.docs), ShouldBeLessThanOrEqualTo, 2)
+			So(batch.start, ShouldEqual, position)
+			So(batch.size, ShouldEqual, len(batch.docs)*len(doc))
+			documents += len(batch.docs)
+			position += int64(len(batch.docs))
+		}
+		So(documents, ShouldEqual, 10)
+	})
+}
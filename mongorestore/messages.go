@@ -0,0 +1,44 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// IDs of the user-facing messages logged by mongorestore
+const (
+	msgFinishedRestoring    log.MessageID = "restore.finishedRestoring"
+	msgRestoringExisting    log.MessageID = "restore.restoringToExistingCollection"
+	msgReadingMetadata      log.MessageID = "restore.readingMetadata"
+	msgRestoringCollection  log.MessageID = "restore.restoringCollection"
+	msgRestoringIndexes     log.MessageID = "restore.restoringIndexes"
+	msgNoIndexes            log.MessageID = "restore.noIndexes"
+	msgPreparingCollections log.MessageID = "restore.preparingCollections"
+	msgBuildingListFromDir  log.MessageID = "restore.buildingListFromDir"
+	msgReadingFromStdin     log.MessageID = "restore.readingFromStdin"
+	msgCheckingForData      log.MessageID = "restore.checkingForData"
+	msgDryRunCompleted      log.MessageID = "restore.dryRunCompleted"
+	msgSkippedDocuments     log.MessageID = "restore.skippedDocuments"
+)
+
+func init() {
+	log.RegisterMessages(map[log.MessageID]string{
+		msgFinishedRestoring:    "finished restoring %v (%v %v, %v %v)",
+		msgRestoringExisting:    "restoring to existing collection %v without dropping",
+		msgReadingMetadata:      "reading metadata for %v from %v",
+		msgRestoringCollection:  "restoring %v from %v",
+		msgRestoringIndexes:     "restoring indexes for collection %v from metadata",
+		msgNoIndexes:            "no indexes to restore",
+		msgPreparingCollections: "preparing collections to restore from",
+		msgBuildingListFromDir:  "building a list of collections to restore from %v dir",
+		msgReadingFromStdin:     "setting up a collection to be read from standard input",
+		msgCheckingForData:      "checking for collection data in %v",
+		msgDryRunCompleted:      "dry run completed",
+		msgSkippedDocuments:     "skipped %v %v of %v with --mode",
+	})
+}
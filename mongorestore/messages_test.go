@@ -0,0 +1,62 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// messageRecorder collects the messages logged through the catalog.
+type messageRecorder struct {
+	mutex    sync.Mutex
+	messages map[string]string
+}
+
+func (r *messageRecorder) hook(level int, msg string, fields map[string]interface{}) {
+	if id, ok := fields[log.MessageIDField].(string); ok {
+		r.mutex.Lock()
+		r.messages[id] = msg
+		r.mutex.Unlock()
+	}
+}
+
+func (r *messageRecorder) get(id log.MessageID) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.messages[string(id)]
+}
+
+func TestMessageCatalog(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	recorder := &messageRecorder{messages: map[string]string{}}
+	defer log.AddHook(recorder.hook)()
+
+	Convey("With a result to log", t, func() {
+		result := Result{Successes: 2, Failures: 1}
+
+		Convey("the default message is used without a catalog", func() {
+			result.log("db.c")
+			So(recorder.get(msgFinishedRestoring), ShouldEqual, "finished restoring db.c (2 documents, 1 failure)")
+		})
+
+		Convey("a translation replaces the default message", func() {
+			log.SetCatalog(map[log.MessageID]string{
+				msgFinishedRestoring: "%[1]v: %[2]v ok, %[4]v failed",
+			})
+			defer log.SetCatalog(nil)
+
+			result.log("db.c")
+			So(recorder.get(msgFinishedRestoring), ShouldEqual, "db.c: 2 ok, 1 failed")
+		})
+	})
+}
@@ -11,10 +11,11 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/intents"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -26,6 +27,8 @@ const (
 	Roles = "roles"
 )
 
+var indexLog = log.Component("restore.indexes")
+
 // struct for working with auth versions
 type authVersionPair struct {
 	// Dump is the auth version of the users/roles collection files in the target dump directory
@@ -36,10 +39,14 @@ type authVersionPair struct {
 
 // Metadata holds information about a collection's options and indexes.
 type Metadata struct {
-	Options        bson.D          `bson:"options,omitempty"`
-	Indexes        []IndexDocument `bson:"indexes"`
-	UUID           string          `bson:"uuid"`
-	CollectionName string          `bson:"collectionName"`
+	Options           bson.D                    `bson:"options,omitempty"`
+	Indexes           []IndexDocument           `bson:"indexes"`
+	UUID              string                    `bson:"uuid"`
+	CollectionName    string                    `bson:"collectionName"`
+	ViewDependencies  []string                  `bson:"viewDependencies,omitempty"`
+	TimeseriesBuckets bool                      `bson:"timeseriesBuckets,omitempty"`
+	ServerVersion     string                    `bson:"serverVersion,omitempty"`
+	Sharding          *archive.ShardingMetadata `bson:"sharding,omitempty"`
 }
 
 // IndexDocument holds information about a collection's index.
@@ -159,33 +166,15 @@ func (restore *MongoRestore) addToKnownCollections(intent *intents.Intent) {
 // fails, we fall back to individual index creation.
 func (restore *MongoRestore) CreateIndexes(dbName string, collectionName string, indexes []IndexDocument, hasNonSimpleCollation bool) error {
 	// first, sanitize the indexes
-	var indexNames []string
-	for _, index := range indexes {
-		// update the namespace of the index before inserting
-		index.Options["ns"] = dbName + "." + collectionName
-
-		// check for length violations before building the command
-		if restore.serverVersion.LT(db.Version{4, 2, 0}) {
-			fullIndexName := fmt.Sprintf("%v.$%v", index.Options["ns"], index.Options["name"])
-			if len(fullIndexName) > 127 {
-				return fmt.Errorf(
-					"cannot restore index with namespace '%v': "+
-						"namespace is too long (max size is 127 bytes)", fullIndexName)
-			}
-		}
-		indexNames = append(indexNames, index.Options["name"].(string))
-
-		// remove the index version, forcing an update,
-		// unless we specifically want to keep it
-		if !restore.OutputOptions.KeepIndexVersion {
-			delete(index.Options, "v")
-		}
+	indexNames, err := restore.prepareIndexes(dbName, collectionName, indexes, hasNonSimpleCollation)
+	if err != nil {
+		return err
+	}
 
-		// for non-simple default collation on the collection, indexes without
-		// a collation option need to add "collation:{locale:"simple"}}
-		if _, ok := index.Options["collation"]; hasNonSimpleCollation && !ok {
-			index.Options["collation"] = bson.D{{"locale", "simple"}}
-		}
+	// wait for a slot if --maxConcurrentIndexBuilds limits the builds
+	if restore.indexBuildSlots != nil {
+		restore.indexBuildSlots <- struct{}{}
+		defer func() { <-restore.indexBuildSlots }()
 	}
 
 	session, err := restore.SessionProvider.GetSession()
@@ -199,11 +188,14 @@ func (restore *MongoRestore) CreateIndexes(dbName string, collectionName string,
 		{"indexes", indexes},
 	}
 
-	log.Logvf(log.Info, "\trun create Index command for indexes: %v", strings.Join(indexNames, ", "))
+	indexLog.Logvf(log.Info, "\trun create Index command for indexes: %v", strings.Join(indexNames, ", "))
 
 	if restore.serverVersion.GTE(db.Version{4, 1, 9}) {
 		rawCommand = append(rawCommand, bson.E{"ignoreUnknownIndexOptions", true})
 	}
+	if restore.indexCommitQuorum != nil {
+		rawCommand = append(rawCommand, bson.E{"commitQuorum", restore.indexCommitQuorum})
+	}
 
 	err = session.Database(dbName).RunCommand(nil, rawCommand).Err()
 	if err == nil {
@@ -214,9 +206,9 @@ func (restore *MongoRestore) CreateIndexes(dbName string, collectionName string,
 	}
 
 	// if we're here, the connected server does not support the command, so we fall back
-	log.Logv(log.Info, "\tcreateIndexes command not supported, attemping legacy index insertion")
+	indexLog.Logv(log.Info, "\tcreateIndexes command not supported, attemping legacy index insertion")
 	for _, idx := range indexes {
-		log.Logvf(log.Info, "\tmanually creating index %v", idx.Options["name"])
+		indexLog.Logvf(log.Info, "\tmanually creating index %v", idx.Options["name"])
 		err = restore.LegacyInsertIndex(dbName, idx)
 		if err != nil {
 			return fmt.Errorf("error creating index %v: %v", idx.Options["name"], err)
@@ -225,6 +217,40 @@ func (restore *MongoRestore) CreateIndexes(dbName string, collectionName string,
 	return nil
 }
 
+// prepareIndexes sanitizes index documents for the createIndexes command
+// on the given collection, and returns their names.
+func (restore *MongoRestore) prepareIndexes(dbName string, collectionName string, indexes []IndexDocument, hasNonSimpleCollation bool) ([]string, error) {
+	var indexNames []string
+	for _, index := range indexes {
+		// update the namespace of the index before inserting
+		index.Options["ns"] = dbName + "." + collectionName
+
+		// check for length violations before building the command
+		if restore.serverVersion.LT(db.Version{4, 2, 0}) {
+			fullIndexName := fmt.Sprintf("%v.$%v", index.Options["ns"], index.Options["name"])
+			if len(fullIndexName) > 127 {
+				return nil, fmt.Errorf(
+					"cannot restore index with namespace '%v': "+
+						"namespace is too long (max size is 127 bytes)", fullIndexName)
+			}
+		}
+		indexNames = append(indexNames, index.Options["name"].(string))
+
+		// remove the index version, forcing an update,
+		// unless we specifically want to keep it
+		if !restore.OutputOptions.KeepIndexVersion {
+			delete(index.Options, "v")
+		}
+
+		// for non-simple default collation on the collection, indexes without
+		// a collation option need to add "collation:{locale:"simple"}}
+		if _, ok := index.Options["collation"]; hasNonSimpleCollation && !ok {
+			index.Options["collation"] = bson.D{{"locale", "simple"}}
+		}
+	}
+	return indexNames, nil
+}
+
 // LegacyInsertIndex takes in an intent and an index document and attempts to
 // create the index on the "system.indexes" collection.
 func (restore *MongoRestore) LegacyInsertIndex(dbName string, index IndexDocument) error {
@@ -314,7 +340,10 @@ func (restore *MongoRestore) createCollectionWithApplyOps(session *mongo.Client,
 		UI:        &primitive.Binary{Subtype: 0x04, Data: uuid},
 	}
 
-	return restore.ApplyOps(session, []interface{}{createOp})
+	if err = restore.ApplyOps(session, []interface{}{createOp}); err != nil {
+		return fmt.Errorf("server refused to create the collection with UUID %v, which needs the applyOps privilege and a UUID not used by another collection: %v", uuidHex, err)
+	}
+	return nil
 }
 
 func createCollectionCommand(intent *intents.Intent, options bson.D) bson.D {
@@ -408,6 +437,18 @@ func (restore *MongoRestore) RestoreUsersOrRoles(users, roles *intents.Intent) e
 		defer arg.intent.BSONFile.Close()
 		bsonSource := db.NewDecodedBSONSource(db.NewBSONSource(arg.intent.BSONFile))
 		defer bsonSource.Close()
+		if arg.intent.DB != "admin" {
+			// users and roles dumped from one database can be restored to another
+			if bsonSource, err = remapAuthSource(bsonSource, arg.intentType, arg.intent.DB); err != nil {
+				return err
+			}
+		}
+		if arg.intentType == "users" && restore.serverVersion != (db.Version{}) {
+			// users the target can't authenticate aren't restored silently
+			if bsonSource, err = restore.checkUserCredentials(bsonSource); err != nil {
+				return err
+			}
+		}
 
 		tempCollectionNameExists, err := restore.CollectionExists(&intents.Intent{DB: "admin", C: arg.tempCollectionName})
 		if err != nil {
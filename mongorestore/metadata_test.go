@@ -11,10 +11,10 @@ import (
 	"io/ioutil"
 	"testing"
 
-	"github.com/mongodb/mongo-tools-common/intents"
-	commonOpts "github.com/mongodb/mongo-tools-common/options"
-	"github.com/mongodb/mongo-tools-common/testtype"
-	"github.com/mongodb/mongo-tools-common/testutil"
+	"github.com/mongodb/mongo-tools/common/intents"
+	commonOpts "github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/common/testutil"
 	. "github.com/smartystreets/goconvey/convey"
 	"go.mongodb.org/mongo-driver/bson"
 )
@@ -230,3 +230,58 @@ func readCollationTestData(filename string) (bson.D, error) {
 	}
 	return data, nil
 }
+
+func TestTimeseriesMetadata(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	restore := &MongoRestore{}
+	Convey("With the metadata of a time-series collection dumped as buckets", t, func() {
+		metadata, err := restore.MetadataFromJSON([]byte(`{"options":{"timeseries":{"timeField":"ts",` +
+			`"granularity":"hours","bucketMaxSpanSeconds":{"$numberInt":"2592000"}},"expireAfterSeconds":{"$numberInt":"60"}},` +
+			`"indexes":[],"collectionName":"readings","timeseriesBuckets":true}`))
+		So(err, ShouldBeNil)
+		So(metadata.TimeseriesBuckets, ShouldBeTrue)
+
+		Convey("the bucket span implied by the granularity is removed", func() {
+			removeImpliedBucketOptions(metadata.Options)
+			So(metadata.Options, ShouldResemble, bson.D{
+				{Key: "timeseries", Value: bson.D{{Key: "timeField", Value: "ts"}, {Key: "granularity", Value: "hours"}}},
+				{Key: "expireAfterSeconds", Value: int32(60)},
+			})
+		})
+	})
+
+	Convey("Custom bucketing without a granularity is kept", t, func() {
+		options := bson.D{{Key: "timeseries", Value: bson.D{{Key: "timeField", Value: "ts"}, {Key: "bucketMaxSpanSeconds", Value: int32(100)}}}}
+		removeImpliedBucketOptions(options)
+		So(options, ShouldResemble, bson.D{{Key: "timeseries", Value: bson.D{{Key: "timeField", Value: "ts"}, {Key: "bucketMaxSpanSeconds", Value: int32(100)}}}})
+	})
+}
+
+func TestCappedAndClusteredMetadata(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	restore := &MongoRestore{}
+	Convey("With the metadata of a clustered collection", t, func() {
+		metadata, err := restore.MetadataFromJSON([]byte(`{"options":{"clusteredIndex":{"v":{"$numberInt":"2"},` +
+			`"key":{"_id":{"$numberInt":"1"}},"name":"_id_","unique":true},"expireAfterSeconds":{"$numberInt":"3600"}},` +
+			`"indexes":[{"v":{"$numberInt":"2"},"key":{"_id":{"$numberInt":"1"}},"name":"_id_","unique":true,"clustered":true},` +
+			`{"v":{"$numberInt":"2"},"key":{"a":{"$numberInt":"1"}},"name":"a_1"}],"collectionName":"events"}`))
+		So(err, ShouldBeNil)
+		So(isCappedOptions(metadata.Options), ShouldBeFalse)
+
+		Convey("the clustered index is left to the clusteredIndex option", func() {
+			indexes := removeClusteredIndexes(metadata.Indexes)
+			So(indexes, ShouldHaveLength, 1)
+			So(indexes[0].Options["name"], ShouldEqual, "a_1")
+		})
+	})
+
+	Convey("Capped collections are recognized by their options", t, func() {
+		metadata, err := restore.MetadataFromJSON([]byte(`{"options":{"capped":true,"size":{"$numberInt":"4096"},` +
+			`"max":{"$numberInt":"10"}},"indexes":[],"collectionName":"log"}`))
+		So(err, ShouldBeNil)
+		So(isCappedOptions(metadata.Options), ShouldBeTrue)
+		So(isCappedOptions(bson.D{{Key: "capped", Value: false}}), ShouldBeFalse)
+	})
+}
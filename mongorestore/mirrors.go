@@ -0,0 +1,292 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// restoreMirror is a cluster of --mirrorUri or --mirrorsFile that the
+// collections are restored to along with the target, from the same read of
+// the dump. A mirror that fails is no longer written, without failing the
+// restore or the other mirrors.
+type restoreMirror struct {
+	name     string
+	provider *db.SessionProvider
+
+	mutex     sync.Mutex
+	err       error
+	documents int64
+	failures  int64
+}
+
+// mirrorSpec is an entry of --mirrorsFile.
+type mirrorSpec struct {
+	Name string `json:"name"`
+	URI  string `json:"uri"`
+}
+
+// readMirrorSpecs returns the mirrors of --mirrorUri and --mirrorsFile.
+// Mirrors without a name are named by their hosts.
+func (restore *MongoRestore) readMirrorSpecs() ([]mirrorSpec, error) {
+	var specs []mirrorSpec
+	for _, uri := range restore.OutputOptions.MirrorURIs {
+		specs = append(specs, mirrorSpec{URI: uri})
+	}
+	if restore.OutputOptions.MirrorsFile != "" {
+		content, err := util.ReadConfigFile(restore.OutputOptions.MirrorsFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %v: %v", MirrorsFileOption, err)
+		}
+		var fromFile []mirrorSpec
+		if err = json.Unmarshal(content, &fromFile); err != nil {
+			return nil, fmt.Errorf("error parsing %v: %v", MirrorsFileOption, err)
+		}
+		specs = append(specs, fromFile...)
+	}
+	names := map[string]bool{}
+	for i, spec := range specs {
+		uri, err := options.NewURI(spec.URI)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mirror connection string: %v", err)
+		}
+		if spec.Name == "" {
+			specs[i].Name = strings.Join(uri.GetConnectionAddrs(), ",")
+		}
+		if names[specs[i].Name] {
+			return nil, fmt.Errorf("more than one mirror is named '%v'", specs[i].Name)
+		}
+		names[specs[i].Name] = true
+	}
+	return specs, nil
+}
+
+// initMirrors connects to the mirrors. They are connected to with the TLS
+// and timeout options of the target, and the credentials of their
+// connection strings.
+func (restore *MongoRestore) initMirrors() error {
+	specs, err := restore.readMirrorSpecs()
+	if err != nil || len(specs) == 0 {
+		return err
+	}
+	switch {
+	case restore.OutputOptions.Resume:
+		return fmt.Errorf("cannot restore to mirrors with %v", ResumeOption)
+	case restore.OutputOptions.WriteToShards:
+		return fmt.Errorf("cannot restore to mirrors with %v", WriteToShardsOption)
+	}
+	if restore.InputOptions.OplogReplay {
+		log.Logvf(log.Always, "warning: the oplog is only replayed to the target, not to the mirrors")
+	}
+	for _, spec := range specs {
+		opts, err := clusterToolOptions(restore.ToolOptions, spec.URI)
+		if err != nil {
+			return fmt.Errorf("invalid connection string of mirror %v: %v", spec.Name, err)
+		}
+		log.Logvf(log.Info, "connecting to mirror %v", spec.Name)
+		provider, err := db.NewSessionProvider(*opts)
+		if err != nil {
+			return fmt.Errorf("error connecting to mirror %v: %v", spec.Name, err)
+		}
+		restore.mirrors = append(restore.mirrors, &restoreMirror{name: spec.Name, provider: provider})
+	}
+	return nil
+}
+
+// clusterToolOptions returns a copy of the tool options that connects to the
+// cluster at uri, such as a mirror, with the credentials it gives rather
+// than the target's.
+func clusterToolOptions(opts *options.ToolOptions, uri string) (*options.ToolOptions, error) {
+	parsed, err := options.NewURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	mirrorOpts := *opts
+	connection := *opts.Connection
+	connection.Host, connection.Port = "", ""
+	mirrorOpts.Connection = &connection
+	mirrorOpts.Auth = &options.Auth{}
+	mirrorOpts.URI = parsed
+	mirrorOpts.ReplicaSetName = ""
+	mirrorOpts.Direct = false
+	if err = mirrorOpts.NormalizeOptionsAndURI(); err != nil {
+		return nil, err
+	}
+	return &mirrorOpts, nil
+}
+
+// closeMirrors closes the connections to the mirrors.
+func (restore *MongoRestore) closeMirrors() {
+	for _, mirror := range restore.mirrors {
+		mirror.provider.Close()
+	}
+}
+
+// failed returns whether the mirror failed and is no longer written.
+func (mirror *restoreMirror) failed() bool {
+	mirror.mutex.Lock()
+	defer mirror.mutex.Unlock()
+	return mirror.err != nil
+}
+
+// fail records the error the mirror failed with, if it hadn't already.
+func (mirror *restoreMirror) fail(err error) {
+	mirror.mutex.Lock()
+	defer mirror.mutex.Unlock()
+	if mirror.err == nil {
+		mirror.err = err
+		log.Logvf(log.Always, "warning: error restoring to mirror %v, which is no longer written: %v", mirror.name, err)
+	}
+}
+
+// record counts the documents of a write to the mirror, failing it for an
+// error that would stop the restore.
+func (mirror *restoreMirror) record(result Result, stopOnError bool) {
+	mirror.mutex.Lock()
+	mirror.documents += result.Successes
+	mirror.failures += result.Failures
+	mirror.mutex.Unlock()
+	if err := db.FilterError(stopOnError, result.Err); err != nil {
+		mirror.fail(err)
+	}
+}
+
+// prepareMirrors drops, with --drop, and creates the collection of intent
+// on each mirror with the options it is created with on the target.
+func (restore *MongoRestore) prepareMirrors(intent *intents.Intent, options bson.D) {
+	for _, mirror := range restore.mirrors {
+		if mirror.failed() {
+			continue
+		}
+		if err := restore.prepareMirror(mirror, intent, options); err != nil {
+			mirror.fail(fmt.Errorf("error creating collection %v: %v", intent.Namespace(), err))
+		}
+	}
+}
+
+func (restore *MongoRestore) prepareMirror(mirror *restoreMirror, intent *intents.Intent, options bson.D) error {
+	session, err := mirror.provider.GetSession()
+	if err != nil {
+		return err
+	}
+	database := session.Database(intent.DB)
+	if restore.OutputOptions.Drop && !strings.HasPrefix(intent.C, "system.") {
+		if err = database.Collection(intent.C).Drop(nil); err != nil {
+			return err
+		}
+	}
+	names, err := database.ListCollectionNames(nil, bson.D{{"name", intent.C}})
+	if err != nil || len(names) > 0 {
+		return err
+	}
+	return database.RunCommand(nil, createCollectionCommand(intent, options)).Err()
+}
+
+// buildMirrorIndexes builds the indexes of a collection on each mirror, as
+// they are prepared for the target. They are built right away, even when
+// the target's are deferred.
+func (restore *MongoRestore) buildMirrorIndexes(build indexBuild) {
+	if len(restore.mirrors) == 0 {
+		return
+	}
+	if _, err := restore.prepareIndexes(build.db, build.collection, build.indexes, build.hasNonSimpleCollation); err != nil {
+		return
+	}
+	for _, mirror := range restore.mirrors {
+		if mirror.failed() {
+			continue
+		}
+		session, err := mirror.provider.GetSession()
+		if err == nil {
+			command := bson.D{{"createIndexes", build.collection}, {"indexes", build.indexes}}
+			err = session.Database(build.db).RunCommand(nil, command).Err()
+		}
+		if err != nil {
+			mirror.fail(fmt.Errorf("error creating indexes for %v.%v: %v", build.db, build.collection, err))
+		}
+	}
+}
+
+// mirrorWriter writes the documents of a collection to a mirror.
+type mirrorWriter struct {
+	mirror *restoreMirror
+	bulk   *db.BufferedBulkInserter
+}
+
+// mirrorWriters is the writers of a collection's insertion worker.
+type mirrorWriters []*mirrorWriter
+
+// newMirrorWriters returns the writers of a collection to each mirror that
+// hasn't failed, creating their bulk inserters with newBulk. Their write
+// errors aren't recorded in --errorFile, which is for the target.
+func (restore *MongoRestore) newMirrorWriters(dbName, colName string, newBulk func(*mongo.Collection) *db.BufferedBulkInserter) mirrorWriters {
+	// users and roles are only restored to the target, through its
+	// temporary collections
+	if dbName == "admin" && (colName == restore.OutputOptions.TempUsersColl || colName == restore.OutputOptions.TempRolesColl) {
+		return nil
+	}
+	var writers mirrorWriters
+	for _, mirror := range restore.mirrors {
+		if mirror.failed() {
+			continue
+		}
+		session, err := mirror.provider.GetSession()
+		if err != nil {
+			mirror.fail(err)
+			continue
+		}
+		bulk := newBulk(session.Database(dbName).Collection(colName)).SetWriteErrorHandler(nil)
+		writers = append(writers, &mirrorWriter{mirror: mirror, bulk: bulk})
+	}
+	return writers
+}
+
+// write writes doc to each mirror that hasn't failed.
+func (writers mirrorWriters) write(restore *MongoRestore, doc bson.Raw) {
+	for _, writer := range writers {
+		if !writer.mirror.failed() {
+			writer.mirror.record(restore.writeDocument(writer.bulk, doc), restore.OutputOptions.StopOnError)
+		}
+	}
+}
+
+// flush flushes the documents buffered for each mirror that hasn't failed.
+func (writers mirrorWriters) flush(restore *MongoRestore) {
+	for _, writer := range writers {
+		if !writer.mirror.failed() {
+			writer.mirror.record(restore.writeResult(writer.bulk.Flush()), restore.OutputOptions.StopOnError)
+		}
+	}
+}
+
+// reportMirrors logs the documents restored to each mirror, and the error
+// of each mirror that failed.
+func (restore *MongoRestore) reportMirrors() {
+	for _, mirror := range restore.mirrors {
+		mirror.mutex.Lock()
+		if mirror.err != nil {
+			log.Logvf(log.Always, "mirror %v failed after %v %v: %v", mirror.name, mirror.documents,
+				util.Pluralize(int(mirror.documents), "document", "documents"), mirror.err)
+		} else {
+			log.Logvf(log.Always, "mirror %v: %v %v restored successfully. %v %v failed to restore.", mirror.name,
+				mirror.documents, util.Pluralize(int(mirror.documents), "document", "documents"),
+				mirror.failures, util.Pluralize(int(mirror.failures), "document", "documents"))
+		}
+		mirror.mutex.Unlock()
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The values of --mode, which is how documents are written to collections
+// that may already hold documents with the same _id.
+const (
+	modeInsert       = "insert"
+	modeUpsert       = "upsert"
+	modeMerge        = "merge"
+	modeInsertIgnore = "insertIgnore"
+	modeReplace      = "replace"
+)
+
+// validateMode checks that --mode can be used with the other options.
+func (restore *MongoRestore) validateMode() error {
+	switch restore.OutputOptions.Mode {
+	case "", modeInsert, modeUpsert, modeMerge, modeReplace:
+	case modeInsertIgnore:
+		// an ordered bulk write stops at the first duplicate, so the
+		// documents after it would be lost rather than skipped
+		if restore.OutputOptions.MaintainInsertionOrder {
+			return fmt.Errorf("cannot use --mode=%v with %v", modeInsertIgnore, MaintainInsertionOrderOption)
+		}
+	default:
+		return fmt.Errorf("invalid --mode '%v'; expected %v, %v, %v, %v or %v", restore.OutputOptions.Mode,
+			modeInsert, modeUpsert, modeMerge, modeInsertIgnore, modeReplace)
+	}
+	return nil
+}
+
+// modeUpserts returns whether the writes of --mode insert the documents
+// that don't match one in the collection.
+func (restore *MongoRestore) modeUpserts() bool {
+	return restore.OutputOptions.Mode == modeUpsert || restore.OutputOptions.Mode == modeMerge
+}
+
+// writeDocument adds the write of a document given by --mode to bulk.
+func (restore *MongoRestore) writeDocument(bulk *db.BufferedBulkInserter, doc bson.Raw) Result {
+	switch restore.OutputOptions.Mode {
+	case modeUpsert:
+		return restore.writeResult(upsertRaw(bulk, doc))
+	case modeMerge:
+		return restore.writeResult(mergeRaw(bulk, doc))
+	case modeReplace:
+		if _, err := doc.LookupErr("_id"); err != nil {
+			// only documents already in the collection are replaced
+			return Result{Skipped: 1}
+		}
+		return restore.writeResult(upsertRaw(bulk, doc))
+	default:
+		return restore.writeResult(bulk.InsertRaw(doc))
+	}
+}
+
+// writeResult returns the result of a bulk write. With --mode=insertIgnore,
+// duplicate key errors are counted as skipped documents rather than
+// failures, even with --stopOnError.
+func (restore *MongoRestore) writeResult(result *mongo.BulkWriteResult, err error) Result {
+	bwe, ok := err.(mongo.BulkWriteException)
+	if restore.OutputOptions.Mode != modeInsertIgnore || !ok {
+		return NewResultFromBulkResult(result, err)
+	}
+	var skipped int64
+	var writeErrors []mongo.BulkWriteError
+	for _, writeErr := range bwe.WriteErrors {
+		if writeErr.Code == db.ErrDuplicateKeyCode {
+			skipped++
+		} else {
+			writeErrors = append(writeErrors, writeErr)
+		}
+	}
+	bwe.WriteErrors = writeErrors
+	if len(writeErrors) == 0 && bwe.WriteConcernError == nil {
+		err = nil
+	} else {
+		err = bwe
+	}
+	counted := NewResultFromBulkResult(result, err)
+	counted.Skipped = skipped
+	return counted
+}
+
+// mergeRaw adds an update of the document with the same _id as doc to bulk,
+// setting each of its top-level fields. A document without an _id is
+// inserted.
+func mergeRaw(bulk *db.BufferedBulkInserter, doc bson.Raw) (*mongo.BulkWriteResult, error) {
+	id, err := doc.LookupErr("_id")
+	if err != nil {
+		return bulk.InsertRaw(doc)
+	}
+	var fields bson.D
+	if err = bson.Unmarshal(doc, &fields); err != nil {
+		return nil, err
+	}
+	set := make(bson.D, 0, len(fields))
+	for _, field := range fields {
+		if field.Key != "_id" {
+			set = append(set, field)
+		}
+	}
+	if len(set) == 0 {
+		// an update must set something, and there is nothing to merge
+		return bulk.Replace(bson.D{{Key: "_id", Value: id}}, fields)
+	}
+	return bulk.Update(bson.D{{Key: "_id", Value: id}}, bson.D{{Key: "$set", Value: set}})
+}
@@ -0,0 +1,77 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestValidateMode(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("--mode is checked against the other options", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}}
+		So(restore.validateMode(), ShouldBeNil)
+		So(restore.modeUpserts(), ShouldBeFalse)
+
+		restore.OutputOptions.Mode = modeMerge
+		So(restore.validateMode(), ShouldBeNil)
+		So(restore.modeUpserts(), ShouldBeTrue)
+
+		restore.OutputOptions.Mode = modeInsertIgnore
+		So(restore.validateMode(), ShouldBeNil)
+		restore.OutputOptions.MaintainInsertionOrder = true
+		So(restore.validateMode(), ShouldNotBeNil)
+
+		restore.OutputOptions.Mode = "overwrite"
+		So(restore.validateMode(), ShouldNotBeNil)
+	})
+}
+
+func TestWriteResult(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	duplicates := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+		{WriteError: mongo.WriteError{Code: db.ErrDuplicateKeyCode}},
+		{WriteError: mongo.WriteError{Code: db.ErrDuplicateKeyCode}},
+	}}
+	written := &mongo.BulkWriteResult{InsertedCount: 3}
+
+	Convey("Duplicate key errors are failures when inserting", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{Mode: modeInsert}}
+		result := restore.writeResult(written, duplicates)
+		So(result.Successes, ShouldEqual, 3)
+		So(result.Failures, ShouldEqual, 2)
+		So(result.Skipped, ShouldEqual, 0)
+		So(result.Err, ShouldNotBeNil)
+	})
+
+	Convey("Duplicate key errors are skipped with --mode=insertIgnore", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{Mode: modeInsertIgnore}}
+		result := restore.writeResult(written, duplicates)
+		So(result.Successes, ShouldEqual, 3)
+		So(result.Failures, ShouldEqual, 0)
+		So(result.Skipped, ShouldEqual, 2)
+		So(result.Err, ShouldBeNil)
+
+		Convey("but other errors are not", func() {
+			mixed := duplicates
+			mixed.WriteErrors = append([]mongo.BulkWriteError{
+				{WriteError: mongo.WriteError{Code: db.ErrFailedDocumentValidation}},
+			}, duplicates.WriteErrors...)
+			result := restore.writeResult(written, mixed)
+			So(result.Failures, ShouldEqual, 1)
+			So(result.Skipped, ShouldEqual, 2)
+			So(result.Err, ShouldNotBeNil)
+		})
+	})
+}
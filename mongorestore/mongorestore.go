@@ -8,7 +8,7 @@
 package mongorestore
 
 import (
-	"compress/gzip"
+	"bufio"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -17,14 +17,17 @@ import (
 	"sync"
 	"time"
 
-	"github.com/mongodb/mongo-tools-common/archive"
-	"github.com/mongodb/mongo-tools-common/auth"
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/intents"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/options"
-	"github.com/mongodb/mongo-tools-common/progress"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/auth"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/encryption"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"github.com/mongodb/mongo-tools/common/util"
 	"github.com/mongodb/mongo-tools/mongorestore/ns"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -50,6 +53,11 @@ type MongoRestore struct {
 
 	SessionProvider *db.SessionProvider
 	ProgressManager progress.Manager
+	// OnProgress, if set, is called with the progress of each part of the
+	// restore by RestoreContext, which then shows no progress bars
+	OnProgress func(Progress)
+	// OnWriteError, if set, is called with each write the target rejects
+	OnWriteError func(WriteError)
 
 	TargetDirectory string
 
@@ -61,6 +69,8 @@ type MongoRestore struct {
 
 	objCheck         bool
 	oplogLimit       primitive.Timestamp
+	oplogUntil       primitive.Timestamp
+	oplogFilter      *oplogFilter
 	isMongos         bool
 	useWriteCommands bool
 	authVersions     authVersionPair
@@ -69,6 +79,10 @@ type MongoRestore struct {
 	knownCollections      map[string][]string
 	knownCollectionsMutex sync.Mutex
 
+	// views are created after the collections, in the order of their dependencies
+	pendingViews      []*pendingView
+	pendingViewsMutex sync.Mutex
+
 	renamer  *ns.Renamer
 	includer *ns.Matcher
 	excluder *ns.Matcher
@@ -77,6 +91,85 @@ type MongoRestore struct {
 	dbCollectionIndexes map[string]collectionIndexes
 
 	archive *archive.Reader
+	// compatSkipped holds the namespaces --compatMode=skip doesn't restore
+	compatSkipped map[string]bool
+	// queries holds the filters of --query or --queryFile
+	queries []namespaceQuery
+	// progress tracks the restore against the totals the dump records
+	progress *restoreProgress
+	// memory is the --maxMemory budget
+	memory *util.MemoryBudget
+	// failures holds the namespaces to retry with --namespaceRetries
+	failures namespaceFailures
+	// verification is the report of --verify and --verifyAgainst
+	verification *verifyReport
+	// mirrors are the clusters of --mirrorUri and --mirrorsFile
+	mirrors []*restoreMirror
+
+	// archivePath is the path of an archive read from a local file, whose
+	// index is used to skip the namespaces not restored
+	archivePath string
+
+	// keyProvider is set by --keyFile or --kmsProvider, and recovers the
+	// dataKey of an encrypted dump
+	keyProvider encryption.KeyProvider
+	dataKey     []byte
+
+	// resumeState records the progress of the restore with --resume
+	resumeState *restoreState
+
+	// byteLimiter, docLimiter and lagThrottle throttle writes for
+	// --rateLimit, --maxDocsPerSecond and --maxReplicationLag
+	byteLimiter *util.RateLimiter
+	docLimiter  *util.RateLimiter
+	lagThrottle *lagThrottle
+	// retryBackoff is parsed from --retryBackoff
+	retryBackoff time.Duration
+
+	// indexSkips, indexCommitQuorum and indexBuildSlots control index
+	// builds for --skipIndex, --indexCommitQuorum and
+	// --maxConcurrentIndexBuilds; deferredIndexBuilds are queued by
+	// --deferIndexBuilds or --indexDefinitionsFile
+	indexSkips          []indexSkip
+	indexCommitQuorum   interface{}
+	indexBuildSlots     chan struct{}
+	deferredIndexBuilds []indexBuild
+	indexBuildsMutex    sync.Mutex
+	// indexBuilder builds indexes in the background with
+	// --interleaveIndexBuilds
+	indexBuilder *indexBuilder
+
+	// transforms are the parsed --transform options
+	transforms []transformSpec
+
+	// uuidMap maps the namespaces and dump UUIDs of --uuidMap to the UUIDs
+	// collections are created with
+	uuidMap map[string]string
+
+	// nsWriteRules are the rules of --nsWriteOptions, in order
+	nsWriteRules []nsWriteRule
+	// validatorRules are the rules of --validatorAction, in order
+	validatorRules []validatorRule
+	// userPasswords are read from --userPasswordFile
+	userPasswords map[string]string
+
+	// errorFile records the failed writes of --errorFile
+	errorFile *errorFile
+
+	// measurementFields hold the fields of the time-series collections
+	// whose measurements are restored, by namespace
+	measurementFields      map[string]timeseriesFields
+	measurementFieldsMutex sync.Mutex
+
+	// shardSessions connect to the shards with --writeToShards, and
+	// shardRoutes hold the chunks of the collections written to them
+	shardSessions    map[string]*db.SessionProvider
+	shardRoutes      map[string]*shardRoutes
+	shardRoutesMutex sync.Mutex
+
+	// planOut is where --dryRun prints the plan of the restore; it is
+	// os.Stdout if unset
+	planOut io.Writer
 
 	// boolean set if termination signal received; false by default
 	terminate bool
@@ -144,6 +237,8 @@ func SupportsCollectionUUID(sp *db.SessionProvider) (bool, error) {
 // Close ends any connections and cleans up other internal state.
 func (restore *MongoRestore) Close() {
 	restore.SessionProvider.Close()
+	restore.closeShards()
+	restore.closeMirrors()
 	barWriter, ok := restore.ProgressManager.(*progress.BarWriter)
 	if ok { // should always be ok
 		barWriter.Stop()
@@ -192,6 +287,9 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 	if restore.isMongos {
 		log.Logv(log.DebugLow, "restoring to a sharded system")
 	}
+	if err = restore.initThrottles(); err != nil {
+		return err
+	}
 
 	if restore.InputOptions.OplogLimit != "" {
 		if !restore.InputOptions.OplogReplay {
@@ -202,7 +300,39 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 			return fmt.Errorf("error parsing timestamp argument to --oplogLimit: %v", err)
 		}
 	}
-	if restore.InputOptions.OplogFile != "" {
+	if restore.InputOptions.OplogReplayUntil != "" {
+		if !restore.InputOptions.OplogReplay {
+			return fmt.Errorf("cannot use --oplogReplayUntil without --oplogReplay enabled")
+		}
+		if restore.InputOptions.OplogLimit != "" {
+			return fmt.Errorf("cannot use both --oplogReplayUntil and --oplogLimit")
+		}
+		restore.oplogUntil, err = ParseTimestampFlag(restore.InputOptions.OplogReplayUntil)
+		if err != nil {
+			return fmt.Errorf("error parsing timestamp argument to --oplogReplayUntil: %v", err)
+		}
+	}
+	restore.oplogFilter, err = newOplogFilter(restore.InputOptions.OplogNSInclude,
+		restore.InputOptions.OplogNSExclude, restore.InputOptions.OplogExcludeOps)
+	if err != nil {
+		return err
+	}
+	if restore.oplogFilter != nil && !restore.InputOptions.OplogReplay {
+		return fmt.Errorf("cannot use --oplogNsInclude, --oplogNsExclude or --oplogExcludeOps without --oplogReplay enabled")
+	}
+	if _, err = restore.InputOptions.Codec(); err != nil {
+		return err
+	}
+	if restore.InputOptions.DecompressionWorkers < 0 {
+		return fmt.Errorf("decompressionWorkers must be positive")
+	}
+	if restore.InputOptions.KeyFile != "" || restore.InputOptions.KMSProvider != "" {
+		restore.keyProvider, err = encryption.NewKeyProvider(restore.InputOptions.KeyFile, restore.InputOptions.KMSProvider)
+		if err != nil {
+			return err
+		}
+	}
+	if len(restore.InputOptions.OplogFile) > 0 {
 		if !restore.InputOptions.OplogReplay {
 			return fmt.Errorf("cannot use --oplogFile without --oplogReplay enabled")
 		}
@@ -211,6 +341,27 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 		}
 	}
 
+	if restore.InputOptions.DeltaBase != "" && restore.InputOptions.Archive == "" {
+		return fmt.Errorf("cannot use --deltaBase without --archive")
+	}
+	if err = restore.validateArchiveStreamOptions(); err != nil {
+		return err
+	}
+
+	if restore.OutputOptions.MetadataOnly || restore.OutputOptions.IndexesOnly {
+		switch {
+		case restore.OutputOptions.MetadataOnly && restore.OutputOptions.IndexesOnly:
+			return fmt.Errorf("cannot use --metadataOnly and --indexesOnly together")
+		case restore.InputOptions.OplogReplay:
+			return fmt.Errorf("cannot use --oplogReplay with --metadataOnly or --indexesOnly")
+		case restore.OutputOptions.IndexesOnly && restore.OutputOptions.NoIndexRestore:
+			return fmt.Errorf("cannot use --indexesOnly with --noIndexRestore")
+		}
+		if restore.OutputOptions.IndexesOnly {
+			restore.OutputOptions.NoOptionsRestore = true
+		}
+	}
+
 	// check if we are using a replica set and fall back to w=1 if we aren't (for <= 2.4)
 	nodeType, err := restore.SessionProvider.GetNodeType()
 	if err != nil {
@@ -238,7 +389,7 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 			len(restore.NSOptions.ExcludedCollectionPrefixes) > 0 {
 			return fmt.Errorf("cannot use --oplogReplay with excludes specified")
 		}
-		if len(restore.NSOptions.NSFrom) > 0 {
+		if len(restore.NSOptions.NSFrom) > 0 || restore.NSOptions.NSMapFile != "" {
 			return fmt.Errorf("cannot use --oplogReplay with namespace renames specified")
 		}
 	}
@@ -279,7 +430,11 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 	if len(restore.NSOptions.NSFrom) != len(restore.NSOptions.NSTo) {
 		return fmt.Errorf("--nsFrom and --nsTo arguments must be specified an equal number of times")
 	}
-	restore.renamer, err = ns.NewRenamer(restore.NSOptions.NSFrom, restore.NSOptions.NSTo)
+	from, to, err := restore.renames()
+	if err != nil {
+		return err
+	}
+	restore.renamer, err = ns.NewRenamer(from, to)
 	if err != nil {
 		return fmt.Errorf("invalid renames: %v", err)
 	}
@@ -288,23 +443,35 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 		return fmt.Errorf(
 			"cannot specify a negative number of insertion workers per collection")
 	}
+	if restore.OutputOptions.NumReadersPerCollection < 0 {
+		return fmt.Errorf("cannot specify a negative number of readers per collection")
+	}
+	if restore.OutputOptions.IgnoredErrorLogLimit < 0 {
+		return fmt.Errorf("--ignoredErrorLogLimit cannot be negative")
+	}
+	db.SetIgnoredErrorLimit(restore.OutputOptions.IgnoredErrorLogLimit)
 
+	if err = restore.validateMode(); err != nil {
+		return err
+	}
 	if restore.OutputOptions.MaintainInsertionOrder {
 		restore.OutputOptions.StopOnError = true
 		restore.OutputOptions.NumInsertionWorkers = 1
 	}
 
-	if restore.OutputOptions.PreserveUUID {
-		if !restore.OutputOptions.Drop {
-			return fmt.Errorf("cannot specify --preserveUUID without --drop")
-		}
+	if err = restore.initUUIDs(); err != nil {
+		return err
+	}
+	if err = restore.initSharding(); err != nil {
+		return err
+	}
 
-		ok, err := SupportsCollectionUUID(restore.SessionProvider)
-		if err != nil {
-			return err
-		}
-		if !ok {
-			return fmt.Errorf("target host does not support --preserveUUID")
+	if restore.InputOptions.Tar != "" {
+		switch {
+		case restore.InputOptions.Archive != "":
+			return fmt.Errorf("cannot use --tar with --archive specified")
+		case restore.TargetDirectory != "":
+			return fmt.Errorf("cannot restore from a directory when --tar is specified")
 		}
 	}
 
@@ -322,16 +489,59 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 		restore.InputReader = os.Stdin
 	}
 
+	if err = restore.validateResumeOptions(); err != nil {
+		return err
+	}
+	if err = restore.validateVerifyOptions(); err != nil {
+		return err
+	}
+	if err = restore.initNSWriteOptions(); err != nil {
+		return err
+	}
+	if err = restore.validateErrorFile(); err != nil {
+		return err
+	}
+	if err = restore.initWriteRetries(); err != nil {
+		return err
+	}
+	if err = restore.initValidatorActions(); err != nil {
+		return err
+	}
+	if err = restore.initCredentials(); err != nil {
+		return err
+	}
+	if err = restore.initIndexBuilds(); err != nil {
+		return err
+	}
+	if err = restore.initTransforms(); err != nil {
+		return err
+	}
+	if err = restore.initQueries(); err != nil {
+		return err
+	}
+	if err = restore.initMemoryBudget(); err != nil {
+		return err
+	}
+	if err = restore.initMirrors(); err != nil {
+		return err
+	}
+	if err = restore.validateNamespaceRetries(); err != nil {
+		return err
+	}
+	if err = restore.initSchedule(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 // Restore runs the mongorestore program.
-func (restore *MongoRestore) Restore() Result {
+func (restore *MongoRestore) Restore() (result Result) {
 	var target archive.DirLike
 	err := restore.ParseAndValidateOptions()
 	if err != nil {
 		log.Logvf(log.DebugLow, "got error from options parsing: %v", err)
-		return Result{Err: err}
+		return Result{Err: util.WithErrorCode(util.ErrCodeBadOptions, err)}
 	}
 
 	// Build up all intents to be restored
@@ -340,6 +550,15 @@ func (restore *MongoRestore) Restore() Result {
 		restore.manager.SetSmartPickOplog(true)
 	}
 
+	if restore.InputOptions.Tar != "" {
+		dir, err := restore.extractTar()
+		if err != nil {
+			return Result{Err: util.WithErrorCode(util.ErrCodeRestoreSource, err)}
+		}
+		defer os.RemoveAll(dir)
+		restore.TargetDirectory = dir
+	}
+
 	if restore.InputOptions.Archive != "" {
 		if restore.archive == nil {
 			archiveReader, err := restore.getArchiveReader()
@@ -347,17 +566,29 @@ func (restore *MongoRestore) Restore() Result {
 				return Result{Err: err}
 			}
 			restore.archive = &archive.Reader{
-				In:      archiveReader,
+				In:      restore.archiveStream(archiveReader),
 				Prelude: &archive.Prelude{},
 			}
 		}
+		restore.archive.Prelude.Decrypt = restore.decryptArchive
 		err = restore.archive.Prelude.Read(restore.archive.In)
 		if err != nil {
 			return Result{Err: err}
 		}
+		if restore.keyProvider != nil && restore.archive.Prelude.Header.Encryption == nil {
+			return Result{Err: util.WithErrorCode(util.ErrCodeBadOptions,
+				fmt.Errorf("--keyFile and --kmsProvider can only be used to restore an encrypted archive"))}
+		}
 		log.Logvf(log.DebugLow, `archive format version "%v"`, restore.archive.Prelude.Header.FormatVersion)
 		log.Logvf(log.DebugLow, `archive server version "%v"`, restore.archive.Prelude.Header.ServerVersion)
 		log.Logvf(log.DebugLow, `archive tool version "%v"`, restore.archive.Prelude.Header.ToolVersion)
+		if sharded := restore.archive.Prelude.Header.Sharded; sharded != nil {
+			log.Logvf(log.Always, "archive is a dump of %v shards at cluster time %v",
+				len(sharded.Shards), util.FormatTimestamp(sharded.ClusterTime))
+			for _, coll := range sharded.Collections {
+				log.Logvf(log.Info, "%v was sharded with key %v", coll.Namespace, coll.Key)
+			}
+		}
 		target, err = restore.archive.Prelude.NewPreludeExplorer()
 		if err != nil {
 			return Result{Err: err}
@@ -369,12 +600,16 @@ func (restore *MongoRestore) Restore() Result {
 			log.Logv(log.Always, "using default 'dump' directory")
 			usedDefaultTarget = true
 		}
-		target, err = newActualPath(restore.TargetDirectory)
+		target, err = newTargetPath(restore.TargetDirectory)
 		if err != nil {
 			if usedDefaultTarget {
 				log.Logv(log.Always, util.ShortUsage("mongorestore"))
 			}
-			return Result{Err: fmt.Errorf("mongorestore target '%v' invalid: %v", restore.TargetDirectory, err)}
+			return Result{Err: util.WithErrorCode(util.ErrCodeRestoreSource,
+				fmt.Errorf("mongorestore target '%v' invalid: %v", restore.TargetDirectory, err))}
+		}
+		if err = restore.readEncryptionMetadata(target); err != nil {
+			return Result{Err: err}
 		}
 		// handle cases where the user passes in a file instead of a directory
 		if !target.IsDir() {
@@ -408,43 +643,47 @@ func (restore *MongoRestore) Restore() Result {
 		}
 	}
 
+	if err = restore.checkIncrementalDump(target); err != nil {
+		return Result{Err: util.WithErrorCode(util.ErrCodeBadOptions, err)}
+	}
+
 	// Create the demux before intent creation, because muted archive intents need
 	// to register themselves with the demux directly
 	if restore.InputOptions.Archive != "" {
 		restore.archive.Demux = archive.CreateDemux(restore.archive.Prelude.NamespaceMetadatas, restore.archive.In)
+		restore.initArchiveBuffers()
 	}
 
 	switch {
 	case restore.InputOptions.Archive != "":
-		log.Logvf(log.Always, "preparing collections to restore from")
+		log.Logmf(log.Always, msgPreparingCollections)
 		err = restore.CreateAllIntents(target)
 	case restore.NSOptions.DB != "" && restore.NSOptions.Collection == "":
-		log.Logvf(log.Always,
-			"building a list of collections to restore from %v dir",
-			target.Path())
+		log.Logmf(log.Always, msgBuildingListFromDir, target.Path())
 		err = restore.CreateIntentsForDB(
 			restore.NSOptions.DB,
 			target,
 		)
 	case restore.NSOptions.DB != "" && restore.NSOptions.Collection != "" && restore.TargetDirectory == "-":
-		log.Logvf(log.Always, "setting up a collection to be read from standard input")
+		log.Logmf(log.Always, msgReadingFromStdin)
 		err = restore.CreateStdinIntentForCollection(
 			restore.NSOptions.DB,
 			restore.NSOptions.Collection,
 		)
 	case restore.NSOptions.DB != "" && restore.NSOptions.Collection != "":
-		log.Logvf(log.Always, "checking for collection data in %v", target.Path())
+		log.Logmf(log.Always, msgCheckingForData, target.Path())
 		err = restore.CreateIntentForCollection(
 			restore.NSOptions.DB,
 			restore.NSOptions.Collection,
 			target,
 		)
 	default:
-		log.Logvf(log.Always, "preparing collections to restore from")
+		log.Logmf(log.Always, msgPreparingCollections)
 		err = restore.CreateAllIntents(target)
 	}
 	if err != nil {
-		return Result{Err: fmt.Errorf("error scanning filesystem: %v", err)}
+		return Result{Err: util.WithErrorCode(util.ErrCodeRestoreSource,
+			fmt.Errorf("error scanning filesystem: %v", err))}
 	}
 
 	if restore.isMongos && restore.manager.HasConfigDBIntent() && restore.NSOptions.DB == "" {
@@ -452,14 +691,15 @@ func (restore *MongoRestore) Restore() Result {
 			"remove the 'config' directory from the dump directory first")}
 	}
 
-	if restore.InputOptions.OplogFile != "" {
+	if len(restore.InputOptions.OplogFile) > 0 {
 		err = restore.CreateIntentForOplog()
 		if err != nil {
 			return Result{Err: fmt.Errorf("error reading oplog file: %v", err)}
 		}
 	}
 	if restore.InputOptions.OplogReplay && restore.manager.Oplog() == nil {
-		return Result{Err: fmt.Errorf("no oplog file to replay; make sure you run mongodump with --oplog")}
+		return Result{Err: util.WithErrorCode(util.ErrCodeRestoreSource,
+			fmt.Errorf("no oplog file to replay; make sure you run mongodump with --oplog"))}
 	}
 	if restore.manager.GetOplogConflict() {
 		return Result{Err: fmt.Errorf("cannot provide both an oplog.bson file and an oplog file with --oplogFile, " +
@@ -475,20 +715,54 @@ func (restore *MongoRestore) Restore() Result {
 	}
 
 	if restore.OutputOptions.DryRun {
-		log.Logvf(log.Always, "dry run completed")
+		if err = restore.dryRun(); err != nil {
+			return Result{Err: util.WithErrorCode(util.ErrCodeRestoreSource, err)}
+		}
+		log.Logmf(log.Always, msgDryRunCompleted)
 		return Result{}
 	}
 
+	if err = restore.checkCompatibility(); err != nil {
+		return Result{Err: util.WithErrorCode(util.ErrCodeRestoreSource, err)}
+	}
+
+	if restore.OutputOptions.Resume {
+		if err = restore.initResumeState(); err != nil {
+			return Result{Err: util.WithErrorCode(util.ErrCodeBadOptions, err)}
+		}
+	}
+
+	if err = restore.runPreRunHook(); err != nil {
+		return Result{Err: err}
+	}
+	runStart := time.Now()
+	defer func() {
+		result = restore.runPostRunHook(result, time.Since(runStart))
+	}()
+
 	demuxFinished := make(chan interface{})
 	var demuxErr error
 	if restore.InputOptions.Archive != "" {
+		archiveFile, err := restore.seekArchive()
+		if err != nil {
+			return Result{Err: util.WithErrorCode(util.ErrCodeRestoreSource, err)}
+		}
+		if archiveFile != nil {
+			defer archiveFile.Close()
+		}
 		namespaceChan := make(chan string, 1)
 		namespaceErrorChan := make(chan error)
 		restore.archive.Demux.NamespaceChan = namespaceChan
 		restore.archive.Demux.NamespaceErrorChan = namespaceErrorChan
 
+		if restore.ProgressManager != nil {
+			restore.ProgressManager.Attach(archiveProgressName, restore.archive.Demux)
+		}
 		go func() {
 			demuxErr = restore.archive.Demux.Run()
+			if restore.ProgressManager != nil {
+				restore.ProgressManager.Detach(archiveProgressName)
+			}
 			close(demuxFinished)
 		}()
 		// consume the new namespace announcement from the demux for all of the special collections
@@ -536,37 +810,55 @@ func (restore *MongoRestore) Restore() Result {
 		}
 		err = restore.ValidateAuthVersions()
 		if err != nil {
-			return Result{Err: fmt.Errorf(
+			return Result{Err: util.WithErrorCode(util.ErrCodeRestoreAuthVersion, fmt.Errorf(
 				"the users and roles collections in the dump have an incompatible auth version with target server: %v",
-				err)}
+				err))}
 		}
 	}
 
 	err = restore.LoadIndexesFromBSON()
 	if err != nil {
-		return Result{Err: fmt.Errorf("restore error: %v", err)}
+		return Result{Err: util.WithErrorCode(util.ErrCodeRestoreIndexes, fmt.Errorf("restore error: %v", err))}
 	}
 
 	// Restore the regular collections
-	if restore.InputOptions.Archive != "" {
-		restore.manager.UsePrioritizer(restore.archive.Demux.NewPrioritizer(restore.manager))
-	} else if restore.OutputOptions.NumParallelCollections > 1 {
-		restore.manager.Finalize(intents.MultiDatabaseLTF)
-	} else {
-		// use legacy restoration order if we are single-threaded
-		restore.manager.Finalize(intents.Legacy)
-	}
+	restore.finalizeIntents()
 
-	result := restore.RestoreIntents()
+	if err = restore.openErrorFile(); err != nil {
+		return Result{Err: err}
+	}
+	detachProgress := restore.attachProgress()
+	result = restore.RestoreIntents()
+	if err = restore.indexBuilder.finish(); err != nil && result.Err == nil {
+		result.Err = err
+	}
+	detachProgress()
+	defer restore.reportMirrors()
+	if err = restore.closeErrorFile(); err != nil && result.Err == nil {
+		result.Err = err
+	}
 	if result.Err != nil {
-		return result
+		return result.withErr(util.WithErrorCode(util.ErrCodeRestoreData, result.Err))
+	}
+	if err = restore.finishIndexBuilds(); err != nil {
+		return result.withErr(util.WithErrorCode(util.ErrCodeRestoreData, fmt.Errorf("restore error: %v", err)))
+	}
+	if err = restore.createViews(); err != nil {
+		return result.withErr(util.WithErrorCode(util.ErrCodeRestoreData, fmt.Errorf("restore error: %v", err)))
+	}
+
+	// Verify the data before the oplog changes it
+	if restore.OutputOptions.Verify {
+		if err = restore.verify(); err != nil {
+			return result.withErr(util.WithErrorCode(util.ErrCodeRestoreVerify, err))
+		}
 	}
 
 	// Restore users/roles
 	if restore.ShouldRestoreUsersAndRoles() {
 		err = restore.RestoreUsersOrRoles(restore.manager.Users(), restore.manager.Roles())
 		if err != nil {
-			return result.withErr(fmt.Errorf("restore error: %v", err))
+			return result.withErr(util.WithErrorCode(util.ErrCodeRestoreUsers, fmt.Errorf("restore error: %v", err)))
 		}
 	}
 
@@ -574,50 +866,107 @@ func (restore *MongoRestore) Restore() Result {
 	if restore.InputOptions.OplogReplay {
 		err = restore.RestoreOplog()
 		if err != nil {
-			return result.withErr(fmt.Errorf("restore error: %v", err))
+			return result.withErr(util.WithErrorCode(util.ErrCodeRestoreOplog, fmt.Errorf("restore error: %v", err)))
+		}
+	}
+
+	// Compare the restored collections with the source once nothing more
+	// is written to them
+	if restore.OutputOptions.VerifyAgainst != "" {
+		if err = restore.verifyAgainst(); err != nil {
+			return result.withErr(util.WithErrorCode(util.ErrCodeRestoreVerify, err))
 		}
 	}
 
 	if restore.InputOptions.Archive != "" {
 		<-demuxFinished
-		return result.withErr(demuxErr)
+		return result.withErr(util.WithErrorCode(util.ErrCodeRestoreArchive, demuxErr))
 	}
 
+	if restore.resumeState != nil {
+		if err = restore.resumeState.remove(); err != nil {
+			log.Logvf(log.Always, "error removing resume state file: %v", err)
+		}
+	}
 	return result
 }
 
 func (restore *MongoRestore) getArchiveReader() (rc io.ReadCloser, err error) {
 	if restore.InputOptions.Archive == "-" {
 		rc = ioutil.NopCloser(restore.InputReader)
+	} else if storage.IsRemote(restore.InputOptions.Archive) {
+		if rc, err = storage.OpenObject(restore.InputOptions.Archive); err != nil {
+			return nil, err
+		}
 	} else {
 		targetStat, err := os.Stat(restore.InputOptions.Archive)
 		if err != nil {
 			return nil, err
 		}
 		if targetStat.IsDir() {
-			defaultArchiveFilePath := filepath.Join(restore.InputOptions.Archive, "archive")
-			if restore.InputOptions.Gzip {
-				defaultArchiveFilePath = defaultArchiveFilePath + ".gz"
-			}
+			defaultArchiveFilePath := filepath.Join(restore.InputOptions.Archive, "archive") + restore.inputCodec().Extension()
 			rc, err = os.Open(defaultArchiveFilePath)
 			if err != nil {
 				return nil, err
 			}
+			restore.archivePath = defaultArchiveFilePath
 		} else {
 			rc, err = os.Open(restore.InputOptions.Archive)
 			if err != nil {
 				return nil, err
 			}
+			restore.archivePath = restore.InputOptions.Archive
 		}
 	}
-	if restore.InputOptions.Gzip {
-		gzrc, err := gzip.NewReader(rc)
+	if rc, err = restore.applyDelta(rc); err != nil {
+		return nil, err
+	}
+	codec := restore.inputCodec()
+	if codec.IsNone() || restore.keyProvider != nil {
+		// detect compressed archives, so the codec they were dumped with needn't be given.
+		// Encrypted archives are compressed after their header, and their
+		// codec is recorded in it
+		buffered := bufio.NewReader(rc)
+		codec, err = compression.Detect(buffered)
 		if err != nil {
+			rc.Close()
 			return nil, err
 		}
-		return &util.WrappedReadCloser{gzrc, rc}, nil
+		if codec.IsNone() {
+			return &util.WrappedReadCloser{ioutil.NopCloser(buffered), rc}, nil
+		}
+		codec.Workers = restore.InputOptions.DecompressionWorkers
+		log.Logvf(log.DebugLow, "archive is compressed with %v", codec)
+		rc = &util.WrappedReadCloser{ioutil.NopCloser(buffered), rc}
+	}
+	decompressed, err := codec.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &util.WrappedReadCloser{decompressed, rc}, nil
+}
+
+// inputCodec returns the compression of the files in a dump directory, or
+// of the archive. ParseAndValidateOptions reports invalid compression options.
+func (restore *MongoRestore) inputCodec() compression.Codec {
+	codec, _ := restore.InputOptions.Codec()
+	codec.Workers = restore.InputOptions.DecompressionWorkers
+	return codec
+}
+
+// skipsData returns whether the documents of the intent's collection are
+// left out of the restore by --metadataOnly or --indexesOnly. Users, roles
+// and the auth version are still restored with --metadataOnly, and
+// system.indexes holds the indexes of dumps from old servers.
+func (restore *MongoRestore) skipsData(intent *intents.Intent) bool {
+	switch {
+	case intent.IsSystemIndexes():
+		return false
+	case intent.IsUsers(), intent.IsRoles(), intent.IsAuthVersion():
+		return restore.OutputOptions.IndexesOnly
 	}
-	return rc, nil
+	return restore.OutputOptions.MetadataOnly || restore.OutputOptions.IndexesOnly
 }
 
 func (restore *MongoRestore) HandleInterrupt() {
@@ -0,0 +1,165 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/text"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// failedNamespace is a namespace whose restore failed, to be retried by
+// --namespaceRetries once the others are done.
+type failedNamespace struct {
+	intent *intents.Intent
+	// results holds the result of each attempt
+	results []Result
+}
+
+// namespaceFailures collects the namespaces that failed while the others
+// are restored.
+type namespaceFailures struct {
+	mutex      sync.Mutex
+	namespaces []*failedNamespace
+}
+
+// validateNamespaceRetries checks that the namespaces of the dump can be
+// read again for --namespaceRetries.
+func (restore *MongoRestore) validateNamespaceRetries() error {
+	retries := restore.OutputOptions.NamespaceRetries
+	switch {
+	case retries < 0:
+		return fmt.Errorf("cannot specify a negative number of namespace retries")
+	case retries == 0:
+		return nil
+	case restore.InputOptions.Archive != "":
+		return fmt.Errorf("cannot use %v with --archive, which can't be read again", NamespaceRetriesOption)
+	case restore.TargetDirectory == "-":
+		return fmt.Errorf("cannot use %v when restoring from stdin", NamespaceRetriesOption)
+	}
+	return nil
+}
+
+// deferFailure records a failed namespace to be retried, if
+// --namespaceRetries is set and the restore wasn't interrupted. It returns
+// whether it did.
+func (restore *MongoRestore) deferFailure(intent *intents.Intent, result Result) bool {
+	if restore.OutputOptions.NamespaceRetries == 0 || restore.terminate {
+		return false
+	}
+	log.Logvf(log.Always, "failed to restore %v, which will be retried once the other namespaces are done: %v",
+		intent.Namespace(), result.Err)
+	restore.failures.mutex.Lock()
+	defer restore.failures.mutex.Unlock()
+	restore.failures.namespaces = append(restore.failures.namespaces, &failedNamespace{
+		intent:  intent,
+		results: []Result{result},
+	})
+	return true
+}
+
+// retryFailures retries the namespaces that failed up to --namespaceRetries
+// times each, one at a time, and logs the status of each. Documents
+// restored by a failed attempt are skipped as duplicates by the attempts
+// after it, unless --drop drops them first. It returns the documents of
+// the namespaces, with the error of the first one that still failed.
+func (restore *MongoRestore) retryFailures() Result {
+	failures := restore.failures.namespaces
+	if len(failures) == 0 {
+		return Result{}
+	}
+	sort.Slice(failures, func(i, j int) bool { return failures[i].intent.Namespace() < failures[j].intent.Namespace() })
+
+	mode := restore.OutputOptions.Mode
+	if mode == "" || mode == modeInsert {
+		restore.OutputOptions.Mode = modeInsertIgnore
+		defer func() { restore.OutputOptions.Mode = mode }()
+	}
+	for attempt := 1; attempt <= restore.OutputOptions.NamespaceRetries; attempt++ {
+		for _, failure := range failures {
+			if failure.restored() || restore.terminate {
+				continue
+			}
+			namespace := failure.intent.Namespace()
+			log.Logvf(log.Always, "retrying %v (retry %v of %v)", namespace, attempt, restore.OutputOptions.NamespaceRetries)
+			result := restore.restoreIntent(failure.intent, log.WithFields("retry", attempt))
+			result.log(namespace)
+			failure.results = append(failure.results, result)
+			if result.Err != nil {
+				log.Logvf(log.Always, "failed to restore %v: %v", namespace, result.Err)
+			} else {
+				log.Summary().ClearNamespaceError(namespace)
+			}
+		}
+	}
+	restore.reportFailures(failures)
+
+	var total Result
+	var failed int
+	var firstErr error
+	for _, failure := range failures {
+		total.combineWith(failure.total(restore.OutputOptions.Drop))
+		if !failure.restored() {
+			failed++
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%v: %v", failure.intent.Namespace(), failure.last().Err)
+			}
+		}
+	}
+	if failed > 0 {
+		return total.withErr(fmt.Errorf("%v %v still failed after %v %v; %v", failed,
+			util.Pluralize(failed, "namespace", "namespaces"), restore.OutputOptions.NamespaceRetries,
+			util.Pluralize(restore.OutputOptions.NamespaceRetries, "retry", "retries"), firstErr))
+	}
+	return total.withErr(nil)
+}
+
+// reportFailures logs a table of the namespaces that were retried, with
+// their attempts and final status.
+func (restore *MongoRestore) reportFailures(failures []*failedNamespace) {
+	grid := &text.GridWriter{ColumnPadding: 2}
+	grid.WriteCells("namespace", "attempts", "documents", "status")
+	grid.EndRow()
+	for _, failure := range failures {
+		status := "restored"
+		if !failure.restored() {
+			status = fmt.Sprintf("failed: %v", failure.last().Err)
+		}
+		grid.WriteCells(failure.intent.Namespace(), fmt.Sprint(len(failure.results)),
+			fmt.Sprint(failure.total(restore.OutputOptions.Drop).Successes), status)
+		grid.EndRow()
+	}
+	grid.FlushRows(log.Writer(0))
+}
+
+// last returns the result of the latest attempt.
+func (failure *failedNamespace) last() Result {
+	return failure.results[len(failure.results)-1]
+}
+
+// restored returns whether the latest attempt succeeded.
+func (failure *failedNamespace) restored() bool {
+	return failure.last().Err == nil
+}
+
+// total returns the documents of each attempt, or only of the latest if
+// dropped is set, as each attempt drops the documents of the one before.
+func (failure *failedNamespace) total(dropped bool) Result {
+	if dropped {
+		return failure.last().withErr(nil)
+	}
+	var total Result
+	for _, result := range failure.results {
+		total.combineWith(result)
+	}
+	return total.withErr(nil)
+}
@@ -0,0 +1,101 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidateNamespaceRetries(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	validate := func(retries int, archive, dir string) error {
+		restore := &MongoRestore{
+			InputOptions:    &InputOptions{Archive: archive},
+			OutputOptions:   &OutputOptions{NamespaceRetries: retries},
+			TargetDirectory: dir,
+		}
+		return restore.validateNamespaceRetries()
+	}
+
+	Convey("Namespaces can be retried from a dump directory", t, func() {
+		So(validate(0, "", "dump"), ShouldBeNil)
+		So(validate(3, "", "dump"), ShouldBeNil)
+		So(validate(0, "-", "-"), ShouldBeNil)
+	})
+
+	Convey("A negative count or a dump that can't be read again is an error", t, func() {
+		So(validate(-1, "", "dump"), ShouldNotBeNil)
+		So(validate(2, "dump.archive", ""), ShouldNotBeNil)
+		So(validate(2, "", "-"), ShouldNotBeNil)
+	})
+}
+
+func TestRetryFailures(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	newRestore := func(retries int) *MongoRestore {
+		return &MongoRestore{
+			InputOptions:  &InputOptions{},
+			OutputOptions: &OutputOptions{NamespaceRetries: retries},
+			compatSkipped: map[string]bool{},
+		}
+	}
+	orders := &intents.Intent{DB: "test", C: "orders"}
+	failed := Result{Successes: 5, Failures: 1, Err: fmt.Errorf("index build failed")}
+
+	Convey("Without --namespaceRetries a failure isn't deferred", t, func() {
+		restore := newRestore(0)
+		So(restore.deferFailure(orders, failed), ShouldBeFalse)
+		So(restore.retryFailures(), ShouldResemble, Result{})
+	})
+
+	Convey("An interrupted restore is not retried", t, func() {
+		restore := newRestore(2)
+		restore.terminate = true
+		So(restore.deferFailure(orders, failed), ShouldBeFalse)
+	})
+
+	Convey("A failed namespace is retried once the others are done", t, func() {
+		restore := newRestore(2)
+		So(restore.deferFailure(orders, failed), ShouldBeTrue)
+		// the retry succeeds without restoring anything, as the namespace
+		// is skipped
+		restore.compatSkipped["test.orders"] = true
+		result := restore.retryFailures()
+		So(result.Err, ShouldBeNil)
+		So(result.Successes, ShouldEqual, 5)
+
+		failure := restore.failures.namespaces[0]
+		So(failure.results, ShouldHaveLength, 2)
+		So(failure.restored(), ShouldBeTrue)
+		// the mode is only changed for the retries
+		So(restore.OutputOptions.Mode, ShouldEqual, "")
+	})
+
+	Convey("A namespace that still fails is reported after all its retries", t, func() {
+		restore := newRestore(3)
+		So(restore.deferFailure(orders, failed), ShouldBeTrue)
+		restore.terminate = true
+		result := restore.retryFailures()
+		So(result.Err, ShouldNotBeNil)
+		So(result.Err.Error(), ShouldContainSubstring, "test.orders: index build failed")
+		So(result.Successes, ShouldEqual, 5)
+		So(result.Failures, ShouldEqual, 1)
+	})
+
+	Convey("With --drop only the documents of the last attempt are counted", t, func() {
+		failure := &failedNamespace{intent: orders, results: []Result{failed, {Successes: 7}}}
+		So(failure.total(true), ShouldResemble, Result{Successes: 7})
+		So(failure.total(false), ShouldResemble, Result{Successes: 12, Failures: 1})
+	})
+}
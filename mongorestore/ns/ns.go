@@ -9,7 +9,9 @@ package ns
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -178,8 +180,55 @@ func processReplacement(from, to string) (re *regexp.Regexp, replacer string, er
 	return
 }
 
+// validateRegexReplacement returns an error if the replacement string of a
+// regular expression refers to a group the expression doesn't have, which
+// would silently be replaced by nothing
+func validateRegexReplacement(re *regexp.Regexp, to string) error {
+	names := map[string]bool{}
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			names[name] = true
+		}
+	}
+	for i := 0; i < len(to); i++ {
+		if to[i] != '$' {
+			continue
+		}
+		i++
+		if i < len(to) && to[i] == '$' {
+			continue
+		}
+		var name string
+		if i < len(to) && to[i] == '{' {
+			end := strings.IndexByte(to[i:], '}')
+			if end < 0 {
+				return fmt.Errorf("Unterminated '${' in to: '%s'", to)
+			}
+			name = to[i+1 : i+end]
+			i += end
+		} else {
+			start := i
+			for i < len(to) && (to[i] == '_' || unicode.IsLetter(rune(to[i])) || unicode.IsDigit(rune(to[i]))) {
+				i++
+			}
+			name = to[start:i]
+			i--
+		}
+		if num, e := strconv.Atoi(name); e == nil {
+			if num > re.NumSubexp() {
+				return fmt.Errorf("Unknown group $%d; the expression has %d", num, re.NumSubexp())
+			}
+		} else if !names[name] {
+			return fmt.Errorf("Unknown group '%s'; use ${1} for a group followed by letters or digits", name)
+		}
+	}
+	return nil
+}
+
 // NewRenamer creates a Renamer that will use the given from and to slices to
-// map namespaces
+// map namespaces. A from pattern between slashes is a regular expression,
+// and its to is a replacement string that refers to its groups as $1 or
+// ${name}.
 func NewRenamer(fromSlice, toSlice []string) (r *Renamer, err error) {
 	if len(fromSlice) != len(toSlice) {
 		err = fmt.Errorf("Different number of froms and tos")
@@ -190,6 +239,19 @@ func NewRenamer(fromSlice, toSlice []string) (r *Renamer, err error) {
 		// reversed for replacement precedence
 		from := fromSlice[i]
 		to := toSlice[i]
+		if isRegexPattern(from) {
+			re, e := regexp.Compile(from[1 : len(from)-1])
+			if e == nil {
+				e = validateRegexReplacement(re, to)
+			}
+			if e != nil {
+				err = fmt.Errorf("Invalid replacement from '%s' to '%s': %s", from, to, e)
+				return
+			}
+			r.matchers = append(r.matchers, re)
+			r.replacers = append(r.replacers, to)
+			continue
+		}
 		err = validateReplacement(from, to)
 		if err != nil {
 			return
@@ -215,11 +277,27 @@ func (r *Renamer) Get(name string) string {
 	return name
 }
 
+// isRegexPattern returns whether a pattern is a regular expression between
+// slashes, rather than a namespace with wildcards
+func isRegexPattern(pattern string) bool {
+	return len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
 // NewMatcher creates a matcher that will use the given list patterns to
-// match namespaces
+// match namespaces. A pattern between slashes is a regular expression, which
+// matches any namespace it is found in unless it is anchored.
 func NewMatcher(patterns []string) (m *Matcher, err error) {
 	m = new(Matcher)
 	for _, pattern := range patterns {
+		if isRegexPattern(pattern) {
+			re, e := regexp.Compile(pattern[1 : len(pattern)-1])
+			if e != nil {
+				err = fmt.Errorf("%s processing include/exclude pattern: '%s'", e, pattern)
+				return
+			}
+			m.matchers = append(m.matchers, re)
+			continue
+		}
 		if strings.Contains(pattern, "$") {
 			err = fmt.Errorf("'$' is not allowed in include/exclude patternsj")
 		}
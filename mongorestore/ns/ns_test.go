@@ -9,9 +9,9 @@ package ns
 import (
 	"testing"
 
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/options"
-	"github.com/mongodb/mongo-tools-common/testtype"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testtype"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -93,6 +93,30 @@ func TestReplacer(t *testing.T) {
 			So(err, ShouldNotBeNil)
 		})
 	})
+	Convey("with regular expressions", t, func() {
+		r, err := NewRenamer(
+			[]string{`/^prod_(.*)\.(.*)$/`, `/^(?P<tenant>t\d+)_(?P<db>\w+)\.(.*)$/`, `*.legacy`},
+			[]string{`staging_$1.$2`, `${db}.${tenant}_${3}`, `*.current`})
+		So(err, ShouldBeNil)
+		So(r.Get("prod_app.orders"), ShouldEqual, "staging_app.orders")
+		So(r.Get("prod_app.orders.archive"), ShouldEqual, "staging_app.orders.archive")
+		So(r.Get("t42_app.users"), ShouldEqual, "app.t42_users")
+		So(r.Get("app.legacy"), ShouldEqual, "app.current")
+		So(r.Get("dev_app.orders"), ShouldEqual, "dev_app.orders")
+
+		Convey("that refer to groups they don't have", func() {
+			_, err := NewRenamer([]string{`/^prod_(.*)$/`}, []string{`staging_$2`})
+			So(err, ShouldNotBeNil)
+			_, err = NewRenamer([]string{`/^prod_(.*)$/`}, []string{`staging_$1_x`})
+			So(err, ShouldNotBeNil)
+			_, err = NewRenamer([]string{`/^prod_(.*)$/`}, []string{`staging_${1`})
+			So(err, ShouldNotBeNil)
+			_, err = NewRenamer([]string{`/^prod_(.*$/`}, []string{`staging`})
+			So(err, ShouldNotBeNil)
+			_, err = NewRenamer([]string{`/^prod_(.*)$/`}, []string{`cost$$_${1}`})
+			So(err, ShouldBeNil)
+		})
+	})
 }
 
 func TestMatcher(t *testing.T) {
@@ -129,6 +153,20 @@ func TestMatcher(t *testing.T) {
 			So(m.Has("ÿœp.tāx"), ShouldBeTrue)
 		})
 	})
+	Convey("with regular expressions", t, func() {
+		m, err := NewMatcher([]string{`/^app_\d+\.orders_2024/`, `*.users`})
+		So(err, ShouldBeNil)
+		So(m.Has("app_1.orders_2024_01"), ShouldBeTrue)
+		So(m.Has("app_22.orders_2024"), ShouldBeTrue)
+		So(m.Has("app_x.orders_2024"), ShouldBeFalse)
+		So(m.Has("old_app_1.orders_2024"), ShouldBeFalse)
+		So(m.Has("app_1.users"), ShouldBeTrue)
+
+		m, err = NewMatcher([]string{`/tmp_/`})
+		So(err, ShouldBeNil)
+		So(m.Has("db.tmp_1"), ShouldBeTrue)
+		So(m.Has("db.temp"), ShouldBeFalse)
+	})
 	Convey("with invalid matcher", t, func() {
 		Convey("'$.user$'", func() {
 			_, err := NewMatcher([]string{"$.user$"})
@@ -138,5 +176,9 @@ func TestMatcher(t *testing.T) {
 			_, err := NewMatcher([]string{"*.user$"})
 			So(err, ShouldNotBeNil)
 		})
+		Convey("'/app_(/'", func() {
+			_, err := NewMatcher([]string{"/app_(/"})
+			So(err, ShouldNotBeNil)
+		})
 	})
 }
@@ -0,0 +1,60 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// nsMapRule is a rename of --nsMapFile, with the same patterns as --nsFrom
+// and --nsTo.
+type nsMapRule struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// parseNSMap parses the renames of an --nsMapFile, which is a list of them
+// in JSON or YAML.
+func parseNSMap(content []byte) ([]nsMapRule, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	var rules []nsMapRule
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("expected a list of renames with from and to: %v", err)
+	}
+	for i, rule := range rules {
+		if rule.From == "" || rule.To == "" {
+			return nil, fmt.Errorf("rename %v needs both from and to", i+1)
+		}
+	}
+	return rules, nil
+}
+
+// renames returns the from and to patterns of the renames of the
+// --nsMapFile followed by those of --nsFrom and --nsTo, which take
+// precedence over them.
+func (restore *MongoRestore) renames() (from, to []string, err error) {
+	if restore.NSOptions.NSMapFile != "" {
+		content, err := util.ReadConfigFile(restore.NSOptions.NSMapFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading --nsMapFile: %v", err)
+		}
+		rules, err := parseNSMap(content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing --nsMapFile: %v", err)
+		}
+		for _, rule := range rules {
+			from = append(from, rule.From)
+			to = append(to, rule.To)
+		}
+	}
+	return append(from, restore.NSOptions.NSFrom...), append(to, restore.NSOptions.NSTo...), nil
+}
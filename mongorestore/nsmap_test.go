@@ -0,0 +1,62 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNSMapFile(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Invalid maps are rejected", t, func() {
+		for _, content := range []string{
+			`{"from": "a.*", "to": "b.*"}`,
+			`[{"from": "a.*"}]`,
+			`[{"from": "a.*", "to": "b.*", "drop": true}]`,
+		} {
+			_, err := parseNSMap([]byte(content))
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("With an --nsMapFile", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_nsmap")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		mapFile := filepath.Join(dir, "tenants.yaml")
+		So(ioutil.WriteFile(mapFile, []byte(`
+- from: /^prod_(.*)\.(.*)$/
+  to: staging_$1.$2
+- from: prod_billing.*
+  to: billing.*
+`), 0644), ShouldBeNil)
+
+		restore := &MongoRestore{NSOptions: &NSOptions{
+			NSMapFile: mapFile,
+			NSFrom:    []string{"prod_app.*"},
+			NSTo:      []string{"app.*"},
+		}}
+		from, to, err := restore.renames()
+		So(err, ShouldBeNil)
+		So(from, ShouldResemble, []string{`/^prod_(.*)\.(.*)$/`, "prod_billing.*", "prod_app.*"})
+		So(to, ShouldResemble, []string{"staging_$1.$2", "billing.*", "app.*"})
+
+		renamer, err := ns.NewRenamer(from, to)
+		So(err, ShouldBeNil)
+		So(renamer.Get("prod_app.users"), ShouldEqual, "app.users")
+		So(renamer.Get("prod_billing.invoices"), ShouldEqual, "billing.invoices")
+		So(renamer.Get("prod_search.index"), ShouldEqual, "staging_search.index")
+	})
+}
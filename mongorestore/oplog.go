@@ -7,16 +7,20 @@
 package mongorestore
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"strconv"
+	"os"
 	"strings"
 
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/intents"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/progress"
-	"github.com/mongodb/mongo-tools-common/txn"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/tracing"
+	"github.com/mongodb/mongo-tools/common/txn"
+	"github.com/mongodb/mongo-tools/common/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -28,6 +32,8 @@ import (
 // Note that ops > 8MB will still be buffered, just as single elements.
 const oplogMaxCommandSize = 1024 * 1024 * 8
 
+var oplogLog = log.Component("oplog")
+
 type oplogContext struct {
 	progressor *progress.CountProgressor
 	session    *mongo.Client
@@ -38,19 +44,22 @@ type oplogContext struct {
 // shouldIgnoreNamespace returns true if the given namespace should be ignored during applyOps.
 func shouldIgnoreNamespace(ns string) bool {
 	if strings.HasPrefix(ns, "config.cache.") || ns == "config.system.sessions" || ns == "config.system.indexBuilds" {
-		log.Logv(log.Always, "skipping applying the "+ns+" namespace in applyOps")
+		oplogLog.Logv(log.Always, "skipping applying the "+ns+" namespace in applyOps")
 		return true
 	}
 	return false
 }
 
 // RestoreOplog attempts to restore a MongoDB oplog.
-func (restore *MongoRestore) RestoreOplog() error {
-	log.Logv(log.Always, "replaying oplog")
+func (restore *MongoRestore) RestoreOplog() (err error) {
+	_, span := tracing.Start(context.Background(), "oplog apply")
+	defer func() { span.End(err) }()
+
+	oplogLog.Logv(log.Always, "replaying oplog")
 	intent := restore.manager.Oplog()
 	if intent == nil {
 		// this should not be reached
-		log.Logv(log.Always, "no oplog file provided, skipping oplog application")
+		oplogLog.Logv(log.Always, "no oplog file provided, skipping oplog application")
 		return nil
 	}
 	if err := intent.BSONFile.Open(); err != nil {
@@ -88,6 +97,11 @@ func (restore *MongoRestore) RestoreOplog() error {
 		defer restore.ProgressManager.Detach("oplog")
 	}
 
+	// the slices of more than one --oplogFile may overlap, so entries that
+	// don't follow the last one read are skipped
+	_, sliced := intent.BSONFile.(*oplogSlices)
+	var lastTimestamp primitive.Timestamp
+
 	for {
 		rawOplogEntry := decodedBsonSource.LoadNext()
 		if rawOplogEntry == nil {
@@ -102,6 +116,13 @@ func (restore *MongoRestore) RestoreOplog() error {
 			return fmt.Errorf("error reading oplog: %v", err)
 		}
 
+		if sliced {
+			if !util.TimestampGreaterThan(entryAsOplog.Timestamp, lastTimestamp) {
+				continue
+			}
+			lastTimestamp = entryAsOplog.Timestamp
+		}
+
 		if shouldIgnoreNamespace(entryAsOplog.Namespace) {
 			continue
 		}
@@ -114,21 +135,24 @@ func (restore *MongoRestore) RestoreOplog() error {
 		if entryAsOplog.Operation == "c" && len(entryAsOplog.Object) > 0 {
 			entryName := entryAsOplog.Object[0].Key
 			if entryName == "startIndexBuild" || entryName == "abortIndexBuild" {
-				log.Logv(log.Always, "skipping applying the oplog entry "+entryName)
+				oplogLog.Logv(log.Always, "skipping applying the oplog entry "+entryName)
 				continue
 			}
 		}
 
 		if !restore.TimestampBeforeLimit(entryAsOplog.Timestamp) {
-			log.Logvf(
+			oplogLog.Logvf(
 				log.DebugLow,
-				"timestamp %v is not below limit of %v; ending oplog restoration",
+				"timestamp %v is past the limit of the oplog replay; ending oplog restoration",
 				entryAsOplog.Timestamp,
-				restore.oplogLimit,
 			)
 			break
 		}
 
+		if err = restore.throttle(1, len(rawOplogEntry)); err != nil {
+			return err
+		}
+
 		meta, err := txn.NewMeta(entryAsOplog)
 		if err != nil {
 			return fmt.Errorf("error getting op metadata: %v", err)
@@ -151,7 +175,8 @@ func (restore *MongoRestore) RestoreOplog() error {
 		fileNeedsIOBuffer.ReleaseIOBuffer()
 	}
 
-	log.Logvf(log.Always, "applied %v oplog entries", oplogCtx.totalOps)
+	oplogLog.Logvf(log.Always, "applied %v oplog entries", oplogCtx.totalOps)
+	log.Summary().AddCount("oplogEntries", int64(oplogCtx.totalOps))
 	if err := decodedBsonSource.Err(); err != nil {
 		return fmt.Errorf("error reading oplog bson input: %v", err)
 	}
@@ -160,6 +185,10 @@ func (restore *MongoRestore) RestoreOplog() error {
 }
 
 func (restore *MongoRestore) HandleNonTxnOp(oplogCtx *oplogContext, op db.Oplog) error {
+	if !restore.oplogFilter.allows(op) {
+		oplogLog.Logvf(log.DebugHigh, "skipping filtered oplog entry on %v", op.Namespace)
+		return nil
+	}
 	oplogCtx.totalOps++
 
 	op, err := restore.filterUUIDs(op)
@@ -178,8 +207,12 @@ func (restore *MongoRestore) HandleNonTxnOp(oplogCtx *oplogContext, op db.Oplog)
 		if restore.OutputOptions.ConvertLegacyIndexes {
 			indexes = restore.convertLegacyIndexes(indexes, op.Namespace)
 		}
+		dbName := strings.Split(op.Namespace, ".")[0]
+		if indexes = restore.filterIndexes(dbName+"."+collectionName, indexes); len(indexes) == 0 {
+			return nil
+		}
 
-		return restore.CreateIndexes(strings.Split(op.Namespace, ".")[0], collectionName, indexes, false)
+		return restore.CreateIndexes(dbName, collectionName, indexes, false)
 	} else if op.Operation == "c" && op.Object[0].Key == "createIndexes" {
 		// server > 4.4 no longer supports applying createIndexes oplog, we need to convert the oplog to createIndexes command and execute it
 		collectionName, index := extractIndexDocumentFromCreateIndexes(op)
@@ -191,8 +224,12 @@ func (restore *MongoRestore) HandleNonTxnOp(oplogCtx *oplogContext, op db.Oplog)
 		if restore.OutputOptions.ConvertLegacyIndexes {
 			indexes = restore.convertLegacyIndexes(indexes, op.Namespace)
 		}
+		dbName := strings.Split(op.Namespace, ".")[0]
+		if indexes = restore.filterIndexes(dbName+"."+collectionName, indexes); len(indexes) == 0 {
+			return nil
+		}
 
-		return restore.CreateIndexes(strings.Split(op.Namespace, ".")[0], collectionName, indexes, false)
+		return restore.CreateIndexes(dbName, collectionName, indexes, false)
 	}
 
 	return restore.ApplyOps(oplogCtx.session, []interface{}{op})
@@ -266,6 +303,10 @@ func (restore *MongoRestore) ApplyOps(session *mongo.Client, entries []interface
 // TimestampBeforeLimit returns true if the given timestamp is allowed to be
 // applied to mongorestore's target database.
 func (restore *MongoRestore) TimestampBeforeLimit(ts primitive.Timestamp) bool {
+	if restore.oplogUntil.T != 0 || restore.oplogUntil.I != 0 {
+		// --oplogReplayUntil includes the entry at its timestamp
+		return !util.TimestampGreaterThan(ts, restore.oplogUntil)
+	}
 	if restore.oplogLimit.T == 0 && restore.oplogLimit.I == 0 {
 		// always valid if there is no --oplogLimit set
 		return true
@@ -278,31 +319,41 @@ func (restore *MongoRestore) TimestampBeforeLimit(ts primitive.Timestamp) bool {
 // a counter of operations in the oplog that occurred in the specified second.
 // It parses this timestamp string and returns a bson.MongoTimestamp type.
 func ParseTimestampFlag(ts string) (primitive.Timestamp, error) {
-	var seconds, increment int
-	timestampFields := strings.Split(ts, ":")
-	if len(timestampFields) > 2 {
-		return primitive.Timestamp{}, fmt.Errorf("too many : characters")
-	}
-
-	seconds, err := strconv.Atoi(timestampFields[0])
-	if err != nil {
-		return primitive.Timestamp{}, fmt.Errorf("error parsing timestamp seconds: %v", err)
-	}
+	return util.ParseTimestamp(ts)
+}
 
-	// parse the increment field if it exists
-	if len(timestampFields) == 2 {
-		if len(timestampFields[1]) > 0 {
-			increment, err = strconv.Atoi(timestampFields[1])
-			if err != nil {
-				return primitive.Timestamp{}, fmt.Errorf("error parsing timestamp increment: %v", err)
-			}
-		} else {
-			// handle the case where the user writes "<time_t>:" with no ordinal
-			increment = 0
+// checkIncrementalDump looks for the range recorded by an incremental dump in
+// the archive header or the dump directory. An incremental dump only holds
+// oplog entries, so it can only be restored by replaying them on top of a
+// restore of the dump it follows.
+func (restore *MongoRestore) checkIncrementalDump(target archive.DirLike) error {
+	var incremental *archive.IncrementalRange
+	if restore.InputOptions.Archive != "" {
+		incremental = restore.archive.Prelude.Header.Incremental
+	} else if target != nil && target.IsDir() {
+		data, err := readFile(joinPath(target.Path(), archive.IncrementalMetadataFile))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading incremental dump metadata: %v", err)
+		}
+		incremental = &archive.IncrementalRange{}
+		if err = json.Unmarshal(data, incremental); err != nil {
+			return fmt.Errorf("error parsing incremental dump metadata: %v", err)
 		}
 	}
-
-	return primitive.Timestamp{T: uint32(seconds), I: uint32(increment)}, nil
+	if incremental == nil {
+		return nil
+	}
+	if !restore.InputOptions.OplogReplay {
+		return fmt.Errorf("this is an incremental dump of oplog entries after %v; "+
+			"restore it with --oplogReplay on top of a restore of the dump taken at that time",
+			util.FormatTimestamp(incremental.Since))
+	}
+	log.Logvf(log.Always, "restoring incremental dump of oplog entries after %v up to %v",
+		util.FormatTimestamp(incremental.Since), util.FormatTimestamp(incremental.Until))
+	return nil
 }
 
 // Server versions 3.6.0-3.6.8 and 4.0.0-4.0.2 require a 'ui' field
@@ -316,13 +367,12 @@ func (restore *MongoRestore) needsCreateIndexWorkaround() bool {
 	return false
 }
 
-// filterUUIDs removes 'ui' entries from ops, including nested applyOps ops.
-// It also modifies ops that rely on 'ui'.
+// filterUUIDs removes 'ui' entries from ops, or maps them with --uuidMap,
+// including nested applyOps ops. It also modifies ops that rely on 'ui'.
 func (restore *MongoRestore) filterUUIDs(op db.Oplog) (db.Oplog, error) {
-	// Remove UUIDs from oplog entries
-	if !restore.OutputOptions.PreserveUUID {
-		op.UI = nil
-
+	// Remove or map UUIDs of oplog entries
+	op.UI = restore.oplogUUID(op)
+	if op.UI == nil && !restore.OutputOptions.PreserveUUID {
 		// The createIndexes oplog command requires 'ui' for some server versions, so
 		// in that case we fall back to an old-style system.indexes insert.
 		if op.Operation == "c" && op.Object[0].Key == "createIndexes" && restore.needsCreateIndexWorkaround() {
@@ -0,0 +1,99 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+)
+
+// oplogOperations maps the operation types accepted by --oplogExcludeOps to
+// the op field of oplog entries.
+var oplogOperations = map[string]string{
+	"insert":  "i",
+	"update":  "u",
+	"delete":  "d",
+	"command": "c",
+}
+
+// oplogFilter selects the oplog entries to replay by their namespaces and
+// operation types, so that the oplog of a whole cluster can be replayed
+// onto a restore of part of it.
+type oplogFilter struct {
+	// includer is nil if every namespace is included
+	includer *ns.Matcher
+	excluder *ns.Matcher
+	// excludedOps holds the op fields of the excluded operation types
+	excludedOps map[string]bool
+}
+
+// newOplogFilter returns a filter of the oplog entries in the namespaces
+// matched by includes and not by excludes, and not of one of the operation
+// types of excludedOps. It returns nil if nothing would be filtered.
+func newOplogFilter(includes, excludes, excludedOps []string) (*oplogFilter, error) {
+	if len(includes) == 0 && len(excludes) == 0 && len(excludedOps) == 0 {
+		return nil, nil
+	}
+	filter := &oplogFilter{excludedOps: map[string]bool{}}
+	var err error
+	if len(includes) > 0 {
+		filter.includer, err = ns.NewMatcher(includes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --oplogNsInclude: %v", err)
+		}
+	}
+	filter.excluder, err = ns.NewMatcher(excludes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --oplogNsExclude: %v", err)
+	}
+	for _, name := range excludedOps {
+		op, ok := oplogOperations[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("invalid --oplogExcludeOps operation '%v'; expected insert, update, delete or command", name)
+		}
+		filter.excludedOps[op] = true
+	}
+	return filter, nil
+}
+
+// allows returns whether an oplog entry should be replayed. A nil filter
+// allows every entry.
+func (filter *oplogFilter) allows(op db.Oplog) bool {
+	if filter == nil {
+		return true
+	}
+	if filter.excludedOps[op.Operation] {
+		return false
+	}
+	namespace := oplogNamespace(op)
+	if filter.includer != nil && !filter.includer.Has(namespace) {
+		return false
+	}
+	return !filter.excluder.Has(namespace)
+}
+
+// oplogNamespace returns the namespace an oplog entry applies to. Commands
+// are logged against the $cmd namespace of their database, so those that
+// name a collection are given that collection's namespace instead.
+func oplogNamespace(op db.Oplog) string {
+	if op.Operation != "c" || len(op.Object) == 0 {
+		return op.Namespace
+	}
+	target, ok := op.Object[0].Value.(string)
+	if !ok {
+		return op.Namespace
+	}
+	if op.Object[0].Key == "renameCollection" {
+		// the source of a rename is a full namespace
+		return target
+	}
+	database := strings.SplitN(op.Namespace, ".", 2)[0]
+	return database + "." + target
+}
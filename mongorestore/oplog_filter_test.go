@@ -0,0 +1,67 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestOplogFilter(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	insert := db.Oplog{Operation: "i", Namespace: "app.users"}
+	remove := db.Oplog{Operation: "d", Namespace: "app.users"}
+	scratch := db.Oplog{Operation: "i", Namespace: "scratch.tmp"}
+	create := db.Oplog{Operation: "c", Namespace: "scratch.$cmd", Object: bson.D{{Key: "create", Value: "tmp"}}}
+	rename := db.Oplog{Operation: "c", Namespace: "app.$cmd", Object: bson.D{
+		{Key: "renameCollection", Value: "scratch.tmp"},
+		{Key: "to", Value: "app.users"},
+	}}
+	dropDatabase := db.Oplog{Operation: "c", Namespace: "scratch.$cmd", Object: bson.D{{Key: "dropDatabase", Value: 1}}}
+
+	Convey("Without oplog filtering options, every entry is replayed", t, func() {
+		filter, err := newOplogFilter(nil, nil, nil)
+		So(err, ShouldBeNil)
+		So(filter, ShouldBeNil)
+		So(filter.allows(remove), ShouldBeTrue)
+	})
+
+	Convey("Commands are matched by the namespaces they apply to", t, func() {
+		So(oplogNamespace(insert), ShouldEqual, "app.users")
+		So(oplogNamespace(create), ShouldEqual, "scratch.tmp")
+		So(oplogNamespace(rename), ShouldEqual, "scratch.tmp")
+		So(oplogNamespace(dropDatabase), ShouldEqual, "scratch.$cmd")
+	})
+
+	Convey("Excluding a database and deletes", t, func() {
+		filter, err := newOplogFilter(nil, []string{"scratch.*"}, []string{"Delete"})
+		So(err, ShouldBeNil)
+		So(filter.allows(insert), ShouldBeTrue)
+		So(filter.allows(remove), ShouldBeFalse)
+		So(filter.allows(scratch), ShouldBeFalse)
+		So(filter.allows(create), ShouldBeFalse)
+		So(filter.allows(dropDatabase), ShouldBeFalse)
+	})
+
+	Convey("Including namespaces by regular expression", t, func() {
+		filter, err := newOplogFilter([]string{"/^app\\./"}, nil, nil)
+		So(err, ShouldBeNil)
+		So(filter.allows(insert), ShouldBeTrue)
+		So(filter.allows(remove), ShouldBeTrue)
+		So(filter.allows(scratch), ShouldBeFalse)
+	})
+
+	Convey("An unknown operation type is rejected", t, func() {
+		_, err := newOplogFilter(nil, nil, []string{"upsert"})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,133 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// oplogSlices implements the intents.file interface for the oplog files
+// given by more than one --oplogFile. The slices are read one after
+// another, in the order of their first entries; entries that overlap those
+// of an earlier slice are skipped by RestoreOplog.
+type oplogSlices struct {
+	slices []*realBSONFile
+	// current is the index of the slice being read
+	current int
+	// done is the size of the slices that have been read
+	done int64
+	open bool
+	errorWriter
+}
+
+// Open is part of the intents.file interface.
+func (o *oplogSlices) Open() error {
+	o.current, o.done = 0, 0
+	if len(o.slices) == 0 {
+		return nil
+	}
+	if err := o.slices[0].Open(); err != nil {
+		return err
+	}
+	o.open = true
+	return nil
+}
+
+// Read is part of the intents.file interface. It moves on to the next
+// slice at the end of each one.
+func (o *oplogSlices) Read(p []byte) (int, error) {
+	for o.open {
+		n, err := o.slices[o.current].Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+		o.done += o.slices[o.current].Pos()
+		o.slices[o.current].Close()
+		o.open = false
+		if o.current+1 == len(o.slices) {
+			break
+		}
+		o.current++
+		if err := o.slices[o.current].Open(); err != nil {
+			return 0, err
+		}
+		o.open = true
+	}
+	return 0, io.EOF
+}
+
+// Close is part of the intents.file interface.
+func (o *oplogSlices) Close() error {
+	if !o.open {
+		return nil
+	}
+	o.open = false
+	return o.slices[o.current].Close()
+}
+
+// Pos is part of the intents.file interface. It is the position across all
+// of the slices.
+func (o *oplogSlices) Pos() int64 {
+	if !o.open {
+		return o.done
+	}
+	return o.done + o.slices[o.current].Pos()
+}
+
+// firstOplogTimestamp returns the timestamp of the first entry of an oplog
+// file, and false if it has none.
+func firstOplogTimestamp(file *realBSONFile) (primitive.Timestamp, bool, error) {
+	if err := file.Open(); err != nil {
+		return primitive.Timestamp{}, false, err
+	}
+	defer file.Close()
+	source := db.NewBufferlessBSONSource(file)
+	source.SetMaxBSONSize(db.MaxBSONSize + 16*1024)
+	raw := source.LoadNext()
+	if raw == nil {
+		if err := source.Err(); err != nil {
+			return primitive.Timestamp{}, false, fmt.Errorf("error reading oplog file %v: %v", file.path, err)
+		}
+		return primitive.Timestamp{}, false, nil
+	}
+	var entry db.Oplog
+	if err := bson.Unmarshal(raw, &entry); err != nil {
+		return primitive.Timestamp{}, false, fmt.Errorf("error reading oplog file %v: %v", file.path, err)
+	}
+	return entry.Timestamp, true, nil
+}
+
+// sortOplogSlices orders oplog files by their first entries, so that they
+// can be given to --oplogFile in any order. Empty files are dropped.
+func sortOplogSlices(files []*realBSONFile) ([]*realBSONFile, error) {
+	firsts := map[*realBSONFile]primitive.Timestamp{}
+	var slices []*realBSONFile
+	for _, file := range files {
+		ts, ok, err := firstOplogTimestamp(file)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			firsts[file] = ts
+			slices = append(slices, file)
+		}
+	}
+	sort.SliceStable(slices, func(i, j int) bool {
+		return util.TimestampLessThan(firsts[slices[i]], firsts[slices[j]])
+	})
+	return slices, nil
+}
@@ -0,0 +1,77 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// writeOplogSlice writes oplog entries with the given times to a file.
+func writeOplogSlice(path string, times ...uint32) error {
+	var data []byte
+	for _, t := range times {
+		raw, err := bson.Marshal(db.Oplog{
+			Timestamp: primitive.Timestamp{T: t},
+			Operation: "n",
+		})
+		if err != nil {
+			return err
+		}
+		data = append(data, raw...)
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func TestOplogSlices(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With oplog files given to more than one --oplogFile", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_oplog_slices")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		later := filepath.Join(dir, "later.bson")
+		earlier := filepath.Join(dir, "earlier.bson")
+		empty := filepath.Join(dir, "empty.bson")
+		So(writeOplogSlice(later, 3, 4, 5), ShouldBeNil)
+		So(writeOplogSlice(earlier, 1, 2, 3), ShouldBeNil)
+		So(writeOplogSlice(empty), ShouldBeNil)
+
+		restore := &MongoRestore{
+			InputOptions: &InputOptions{OplogFile: []string{later, empty, earlier}},
+			manager:      intents.NewIntentManager(),
+		}
+		So(restore.CreateIntentForOplog(), ShouldBeNil)
+		intent := restore.manager.Oplog()
+		So(intent, ShouldNotBeNil)
+		So(intent.Location, ShouldEqual, earlier+", "+later)
+
+		Convey("the slices are read in the order of their first entries", func() {
+			So(intent.BSONFile.Open(), ShouldBeNil)
+			source := db.NewDecodedBSONSource(db.NewBufferlessBSONSource(intent.BSONFile))
+			var times []uint32
+			var entry db.Oplog
+			for source.Next(&entry) {
+				times = append(times, entry.Timestamp.T)
+			}
+			So(source.Err(), ShouldBeNil)
+			So(times, ShouldResemble, []uint32{1, 2, 3, 3, 4, 5})
+			So(intent.BSONFile.Pos(), ShouldEqual, intent.Size)
+			So(intent.BSONFile.Close(), ShouldBeNil)
+		})
+	})
+}
@@ -13,9 +13,9 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/testtype"
-	"github.com/mongodb/mongo-tools-common/testutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/common/testutil"
 	. "github.com/smartystreets/goconvey/convey"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -111,6 +111,24 @@ func TestValidOplogLimitChecking(t *testing.T) {
 		})
 	})
 
+	Convey("With a MongoRestore instance with oplogReplayUntil of 5:0", t, func() {
+		mr := &MongoRestore{
+			oplogUntil: primitive.Timestamp{T: 5, I: 0},
+		}
+
+		Convey("an oplog entry with ts=5:1 should be invalid", func() {
+			So(mr.TimestampBeforeLimit(primitive.Timestamp{T: 5, I: 1}), ShouldBeFalse)
+		})
+
+		Convey("an oplog entry with ts=5:0 should be valid", func() {
+			So(mr.TimestampBeforeLimit(primitive.Timestamp{T: 5, I: 0}), ShouldBeTrue)
+		})
+
+		Convey("an oplog entry with ts=4:9 should be valid", func() {
+			So(mr.TimestampBeforeLimit(primitive.Timestamp{T: 4, I: 9}), ShouldBeTrue)
+		})
+	})
+
 	Convey("With a MongoRestore instance with no oplogLimit", t, func() {
 		mr := &MongoRestore{}
 
@@ -7,10 +7,11 @@
 package mongorestore
 
 import (
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/options"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/util"
 
 	"fmt"
 )
@@ -42,22 +43,46 @@ const (
 	OplogReplayOption            = "--oplogReplay"
 	OplogLimitOption             = "--oplogLimit"
 	OplogFileOption              = "--oplogFile"
+	OplogReplayUntilOption       = "--oplogReplayUntil"
+	OplogNSIncludeOption         = "--oplogNsInclude"
+	OplogNSExcludeOption         = "--oplogNsExclude"
+	OplogExcludeOpsOption        = "--oplogExcludeOps"
 	ArchiveOption                = "--archive" // Value is optional, so must use '=' if specifying one
+	ArchiveBufferSizeOption      = "--archiveBufferSize"
+	ArchiveReadAheadOption       = "--archiveReadAhead"
 	RestoreDBUsersAndRolesOption = "--restoreDbUsersAndRoles"
 	DirectoryOption              = "--dir"
 	GzipOption                   = "--gzip"
+	DecompressOption             = "--decompress"
+	DecompressionWorkersOption   = "--decompressionWorkers"
+	QueryOption                  = "--query"
+	QueryFileOption              = "--queryFile"
 )
 
 // InputOptions defines the set of options to use in configuring the restore process.
 type InputOptions struct {
-	Objcheck               bool   `long:"objcheck" description:"validate all objects before inserting"`
-	OplogReplay            bool   `long:"oplogReplay" description:"replay oplog for point-in-time restore"`
-	OplogLimit             string `long:"oplogLimit" value-name:"<seconds>[:ordinal]" description:"only include oplog entries before the provided Timestamp"`
-	OplogFile              string `long:"oplogFile" value-name:"<filename>" description:"oplog file to use for replay of oplog"`
-	Archive                string `long:"archive" value-name:"<filename>" optional:"true" optional-value:"-" description:"restore dump from the specified archive file.  If flag is specified without a value, archive is read from stdin"`
-	RestoreDBUsersAndRoles bool   `long:"restoreDbUsersAndRoles" description:"restore user and role definitions for the given database"`
-	Directory              string `long:"dir" value-name:"<directory-name>" description:"input directory, use '-' for stdin"`
-	Gzip                   bool   `long:"gzip" description:"decompress gzipped input"`
+	Objcheck               bool     `long:"objcheck" description:"validate all objects before inserting"`
+	OplogReplay            bool     `long:"oplogReplay" description:"replay oplog for point-in-time restore"`
+	OplogLimit             string   `long:"oplogLimit" value-name:"<seconds>[:ordinal]" description:"only include oplog entries before the provided Timestamp"`
+	OplogReplayUntil       string   `long:"oplogReplayUntil" value-name:"<seconds>[:ordinal]" description:"only include oplog entries up to and including the provided Timestamp, for a restore to a point in time"`
+	OplogFile              []string `long:"oplogFile" value-name:"<filename>" description:"oplog file to use for replay of oplog (may be specified multiple times to replay overlapping slices of an oplog, which are ordered by their first entries)"`
+	OplogNSInclude         []string `long:"oplogNsInclude" value-name:"<namespace-pattern>" description:"only replay the oplog entries of matching namespaces; a pattern between slashes is a regular expression (may be specified multiple times)"`
+	OplogNSExclude         []string `long:"oplogNsExclude" value-name:"<namespace-pattern>" description:"skip the oplog entries of matching namespaces; a pattern between slashes is a regular expression (may be specified multiple times)"`
+	OplogExcludeOps        []string `long:"oplogExcludeOps" value-name:"<operation>" description:"skip the oplog entries of an operation type: insert, update, delete or command (may be specified multiple times)"`
+	Archive                string   `long:"archive" value-name:"<filename>" optional:"true" optional-value:"-" description:"restore dump from the specified archive file, or an s3://, gs:// or azblob:// URL.  If flag is specified without a value, archive is read from stdin"`
+	ArchiveBufferSize      int      `long:"archiveBufferSize" value-name:"<MB>" description:"hold up to this many megabytes of each collection read from --archive in memory, so that collections restored in parallel don't wait on each other's inserts; the archive isn't read further while a collection's buffer is full (default: 0, each document is handed over as it is read)"`
+	ArchiveReadAhead       int      `long:"archiveReadAhead" value-name:"<MB>" description:"read up to this many megabytes of --archive ahead of the restore, for streaming restores from a pipe such as mongodump --archive; the pipe isn't read while the read-ahead is full (default: 0)"`
+	DeltaBase              string   `long:"deltaBase" value-name:"<filename>" description:"archive a delta given by --archive was written from with mongodump --deltaBase; the full archive is reconstructed from both as it is restored"`
+	Tar                    string   `long:"tar" value-name:"<filename>" optional:"true" optional-value:"-" description:"restore a dump written by mongodump --outFormat tar or tar.gz, which is extracted to a temporary directory first. If flag is specified without a value, the tar stream is read from stdin"`
+	RestoreDBUsersAndRoles bool     `long:"restoreDbUsersAndRoles" description:"restore user and role definitions for the given database; users and roles dumped from another database are remapped to it"`
+	Directory              string   `long:"dir" value-name:"<directory-name>" description:"input directory, or an s3://, gs:// or azblob:// URL of a dump in object storage; use '-' for stdin"`
+	Gzip                   bool     `long:"gzip" description:"decompress gzipped input"`
+	Decompress             string   `long:"decompress" value-name:"<codec>" description:"decompress input compressed with gzip, zstd or lz4 (compressed archives, and files named with the codec's extension or compressed by other tools, are detected without this option)"`
+	DecompressionWorkers   int      `long:"decompressionWorkers" value-name:"<n>" description:"number of goroutines that decompress each zstd or lz4 input file or archive; gzip input is decompressed on one (default: 1)"`
+	KeyFile                string   `long:"keyFile" value-name:"<filename>" description:"file holding the master key an encrypted dump's data key was encrypted with"`
+	KMSProvider            string   `long:"kmsProvider" value-name:"aws" description:"decrypt an encrypted dump's data key with the KMS it was generated by"`
+	Query                  string   `long:"query" short:"q" value-name:"<json>" description:"only restore the documents matching a query filter, as Extended JSON; matched by mongorestore, which supports the comparison, logical, element and array operators and $regex"`
+	QueryFile              string   `long:"queryFile" value-name:"<filename>" description:"path to a JSON or YAML file holding the query filter of the documents to restore, or, without --collection, an object of namespace patterns and the filters of the matching namespaces"`
 }
 
 // Name returns a human-readable group name for input options.
@@ -65,6 +90,27 @@ func (*InputOptions) Name() string {
 	return "input"
 }
 
+// Codec returns the compression given by --decompress, or by --gzip.
+func (inputOptions *InputOptions) Codec() (compression.Codec, error) {
+	if inputOptions.Decompress == "" {
+		if inputOptions.Gzip {
+			return compression.Gzip, nil
+		}
+		return compression.None, nil
+	}
+	codec, err := compression.Parse(inputOptions.Decompress)
+	if err != nil {
+		return compression.None, fmt.Errorf("invalid --decompress: %v", err)
+	}
+	if codec.Level != 0 {
+		return compression.None, fmt.Errorf("--decompress does not take a compression level")
+	}
+	if inputOptions.Gzip && codec.Name != compression.GzipName {
+		return compression.None, fmt.Errorf("--gzip cannot be used with --decompress=%v", inputOptions.Decompress)
+	}
+	return codec, nil
+}
+
 // OutputOptions command line argument long names
 const (
 	DropOption                     = "--drop"
@@ -77,36 +123,114 @@ const (
 	MaintainInsertionOrderOption   = "--maintainInsertionOrder"
 	NumParallelCollectionsOption   = "--numParallelCollections"
 	NumInsertionWorkersOption      = "--numInsertionWorkersPerCollection"
+	ScheduleOption                 = "--schedule"
+	InterleaveIndexBuildsOption    = "--interleaveIndexBuilds"
+	NumReadersOption               = "--numReadersPerCollection"
+	MaxWriteRetriesOption          = "--maxWriteRetries"
+	NamespaceRetriesOption         = "--namespaceRetries"
+	ValidatorActionOption          = "--validatorAction"
+	UnsupportedCredentialsOption   = "--unsupportedCredentials"
+	UserPasswordFileOption         = "--userPasswordFile"
+	RetryBackoffOption             = "--retryBackoff"
 	StopOnErrorOption              = "--stopOnError"
 	BypassDocumentValidationOption = "--bypassDocumentValidation"
+	NSWriteOptionsOption           = "--nsWriteOptions"
+	ErrorFileOption                = "--errorFile"
+	TimeseriesBucketsOption        = "--timeseriesBuckets"
+	ErrorFileDocumentsOption       = "--errorFileDocuments"
 	PreserveUUIDOption             = "--preserveUUID"
+	UUIDMapOption                  = "--uuidMap"
+	ShardCollectionsOption         = "--shardCollections"
+	WriteToShardsOption            = "--writeToShards"
 	TempUsersCollOption            = "--tempUsersColl"
 	TempRolesCollOption            = "--tempRolesColl"
 	BulkBufferSizeOption           = "--batchSize"
 	FixDottedHashedIndexesOption   = "--fixDottedHashIndex"
+	MetadataOnlyOption             = "--metadataOnly"
+	IndexesOnlyOption              = "--indexesOnly"
+	ResumeOption                   = "--resume"
+	ResumeFileOption               = "--resumeFile"
+	ModeOption                     = "--mode"
+	RateLimitOption                = "--rateLimit"
+	MaxDocsPerSecondOption         = "--maxDocsPerSecond"
+	MaxReplicationLagOption        = "--maxReplicationLag"
+	MaxMemoryOption                = "--maxMemory"
+	MirrorURIOption                = "--mirrorUri"
+	MirrorsFileOption              = "--mirrorsFile"
+	VerifyOption                   = "--verify"
+	VerifyHashOption               = "--verifyHash"
+	VerifyReportOption             = "--verifyReport"
+	VerifyAgainstOption            = "--verifyAgainst"
+	DeferIndexBuildsOption         = "--deferIndexBuilds"
+	IndexCommitQuorumOption        = "--indexCommitQuorum"
+	MaxConcurrentIndexBuildsOption = "--maxConcurrentIndexBuilds"
+	SkipIndexOption                = "--skipIndex"
+	IndexDefinitionsFileOption     = "--indexDefinitionsFile"
+	TransformOption                = "--transform"
 )
 
 // OutputOptions defines the set of options for restoring dump data.
 type OutputOptions struct {
 	Drop   bool `long:"drop" description:"drop each collection before import"`
-	DryRun bool `long:"dryRun" description:"view summary without importing anything. recommended with verbosity"`
+	DryRun bool `long:"dryRun" description:"print the plan of the restore, checking the metadata of the dump and the collections of the target, and exit without writing anything"`
 
 	// By default mongorestore uses a write concern of 'majority'.
-	WriteConcern             string `long:"writeConcern" value-name:"<write-concern>" default-mask:"-" description:"write concern options e.g. --writeConcern majority, --writeConcern '{w: 3, wtimeout: 500, fsync: true, j: true}'"`
-	NoIndexRestore           bool   `long:"noIndexRestore" description:"don't restore indexes"`
-	ConvertLegacyIndexes     bool   `long:"convertLegacyIndexes" description:"Removes invalid index options and rewrites legacy option values (e.g. true becomes 1)."`
-	NoOptionsRestore         bool   `long:"noOptionsRestore" description:"don't restore collection options"`
-	KeepIndexVersion         bool   `long:"keepIndexVersion" description:"don't update index version"`
-	MaintainInsertionOrder   bool   `long:"maintainInsertionOrder" description:"restore the documents in the order of their appearance in the input source. By default the insertions will be performed in an arbitrary order. Setting this flag also enables the behavior of --stopOnError and restricts NumInsertionWorkersPerCollection to 1."`
-	NumParallelCollections   int    `long:"numParallelCollections" short:"j" description:"number of collections to restore in parallel" default:"4" default-mask:"-"`
-	NumInsertionWorkers      int    `long:"numInsertionWorkersPerCollection" description:"number of insert operations to run concurrently per collection" default:"1" default-mask:"-"`
-	StopOnError              bool   `long:"stopOnError" description:"halt after encountering any error during insertion. By default, mongorestore will attempt to continue through document validation and DuplicateKey errors, but with this option enabled, the tool will stop instead. A small number of documents may be inserted after encountering an error even with this option enabled; use --maintainInsertionOrder to halt immediately after an error"`
-	BypassDocumentValidation bool   `long:"bypassDocumentValidation" description:"bypass document validation"`
-	PreserveUUID             bool   `long:"preserveUUID" description:"preserve original collection UUIDs (off by default, requires drop)"`
-	TempUsersColl            string `long:"tempUsersColl" default:"tempusers" hidden:"true"`
-	TempRolesColl            string `long:"tempRolesColl" default:"temproles" hidden:"true"`
-	BulkBufferSize           int    `long:"batchSize" default:"1000" hidden:"true"`
-	FixDottedHashedIndexes   bool   `long:"fixDottedHashIndex" description:"when enabled, all the hashed indexes on dotted fields will be created as single field ascending indexes on the destination"`
+	WriteConcern             string   `long:"writeConcern" value-name:"<write-concern>" default-mask:"-" description:"write concern options e.g. --writeConcern majority, --writeConcern '{w: 3, wtimeout: 500, fsync: true, j: true}'"`
+	NoIndexRestore           bool     `long:"noIndexRestore" description:"don't restore indexes"`
+	ConvertLegacyIndexes     bool     `long:"convertLegacyIndexes" description:"Removes invalid index options and rewrites legacy option values (e.g. true becomes 1)."`
+	NoOptionsRestore         bool     `long:"noOptionsRestore" description:"don't restore collection options"`
+	ValidatorActions         []string `long:"validatorAction" value-name:"[<namespace-pattern>:]<action>" description:"when the document validators of collections are applied: restore creates collections with them, rejecting restored documents that don't pass them (default); restoreAfterData applies them once the documents of each collection are restored; skip doesn't restore them. With a namespace pattern, e.g. 'test.*:skip', the action applies to matching namespaces only; may be repeated"`
+	KeepIndexVersion         bool     `long:"keepIndexVersion" description:"don't update index version"`
+	MaintainInsertionOrder   bool     `long:"maintainInsertionOrder" description:"restore the documents in the order of their appearance in the input source. By default the insertions will be performed in an arbitrary order. Setting this flag also enables the behavior of --stopOnError and restricts NumInsertionWorkersPerCollection to 1."`
+	NumParallelCollections   int      `long:"numParallelCollections" short:"j" description:"number of collections to restore in parallel" default:"4" default-mask:"-"`
+	Schedule                 string   `long:"schedule" choice:"database" choice:"discovery" choice:"size" description:"the order collections are restored in. database: the largest first, restoring collections of different databases at once. discovery: the order of the dump. size: the largest first across all databases, each worker taking the largest left once it is done with one, which balances the workers best across collections of mixed sizes. Not with --archive. (default: database, or discovery with --numParallelCollections=1)"`
+	InterleaveIndexBuilds    bool     `long:"interleaveIndexBuilds" description:"build the indexes of each collection in the background once its documents are restored, up to --numParallelCollections at once, while the workers go on restoring the documents of other collections"`
+	NumInsertionWorkers      int      `long:"numInsertionWorkersPerCollection" description:"number of insert operations to run concurrently per collection" default:"1" default-mask:"-"`
+	NumReadersPerCollection  int      `long:"numReadersPerCollection" value-name:"<n>" description:"split the BSON file of each collection at document boundaries into up to this many segments of at least 64MB, each read by its own reader and inserted by its own --numInsertionWorkersPerCollection workers; only uncompressed, unencrypted local files are split, and not with --maintainInsertionOrder or --resume" default:"1" default-mask:"-"`
+	StopOnError              bool     `long:"stopOnError" description:"halt after encountering any error during insertion. By default, mongorestore will attempt to continue through document validation and DuplicateKey errors, but with this option enabled, the tool will stop instead. A small number of documents may be inserted after encountering an error even with this option enabled; use --maintainInsertionOrder to halt immediately after an error"`
+	IgnoredErrorLogLimit     int      `long:"ignoredErrorLogLimit" value-name:"<n>" description:"log at most this many of the errors continued through with each error code per minute, such as duplicate keys, and summarize the rest (default: 0, logging all of them)"`
+	BypassDocumentValidation bool     `long:"bypassDocumentValidation" description:"bypass document validation"`
+	MaxWriteRetries          int      `long:"maxWriteRetries" value-name:"<n>" description:"retry a batch of documents whose write fails with a network error or while the primary steps down up to this many times, replaying it so that documents written before the error aren't reported as duplicates" default:"5" default-mask:"-"`
+	NamespaceRetries         int      `long:"namespaceRetries" value-name:"<n>" description:"instead of stopping at a namespace that fails to restore, such as on an index build error, retry it up to this many times once the other namespaces are done, skipping the documents already restored as duplicates unless --drop is set, then report the status of each namespace retried (default: 0)"`
+	RetryBackoff             string   `long:"retryBackoff" value-name:"<duration>" description:"how long to wait before the first retry of a failed batch, doubled for each further retry up to 30s" default:"500ms" default-mask:"-"`
+	NSWriteOptions           string   `long:"nsWriteOptions" value-name:"<file-path>" description:"JSON or YAML list of namespace patterns, each with the writeConcern, ordered and bypassDocumentValidation settings the documents of the collections restored to matching namespaces are inserted with, in place of --writeConcern, --maintainInsertionOrder and --bypassDocumentValidation; the first matching pattern applies"`
+	ErrorFile                string   `long:"errorFile" value-name:"<file-path>" description:"record each document that fails to be written in this file, with its namespace, _id, error code and message, as BSON or, for a file named .json, as lines of extended JSON"`
+	ErrorFileDocuments       bool     `long:"errorFileDocuments" description:"with --errorFile, also record the full documents that failed to be written, so that they can be retried"`
+	TimeseriesBuckets        bool     `long:"timeseriesBuckets" description:"restore the raw buckets of time-series collections dumped with mongodump --timeseriesBuckets into their system.buckets collections; the target must run the major and minor server version they were dumped from"`
+	PreserveUUID             bool     `long:"preserveUUID" description:"preserve original collection UUIDs (off by default, requires drop)"`
+	ShardCollections         bool     `long:"shardCollections" description:"when restoring through mongos, shard the collections that were sharded in the dump with their shard keys, and split them and move their chunks across the shards as they were, with their zone ranges, before inserting their documents"`
+	WriteToShards            bool     `long:"writeToShards" description:"insert the documents of the collections sharded by --shardCollections on a ranged shard key straight into the primaries of the shards that own them, rather than through mongos"`
+	UUIDMap                  string   `long:"uuidMap" value-name:"<file-path>" description:"JSON or YAML object mapping the namespaces collections are restored to, or their UUIDs in the dump, to the UUIDs to create them with; other collections get new UUIDs unless --preserveUUID is given (requires drop)"`
+	UnsupportedCredentials   string   `long:"unsupportedCredentials" choice:"warn" choice:"skip" choice:"regenerate" description:"how users whose credentials the target can't authenticate them with, such as MONGODB-CR credentials restored to 4.0 or later, are restored. warn: restore them with a warning. skip: don't restore them. regenerate: give them SCRAM credentials generated from their passwords in --userPasswordFile. (default: warn)"`
+	UserPasswordFile         string   `long:"userPasswordFile" value-name:"<file-path>" description:"JSON or YAML object mapping users, as '<db>.<user>', to the passwords --unsupportedCredentials=regenerate generates their credentials from"`
+	CompatMode               string   `long:"compatMode" choice:"strict" choice:"adapt" choice:"skip" description:"check the metadata of the collections against the target's version before restoring, for options, index types and deprecated BSON types it doesn't support. strict: fail before restoring anything. adapt: remove or convert what the target doesn't support, with a warning, failing only for collections that can't be restored to it, such as time-series collections before 5.0. skip: don't restore the collections with unsupported metadata. (default: no check)"`
+	TempUsersColl            string   `long:"tempUsersColl" default:"tempusers" hidden:"true"`
+	TempRolesColl            string   `long:"tempRolesColl" default:"temproles" hidden:"true"`
+	BulkBufferSize           int      `long:"batchSize" default:"1000" hidden:"true"`
+	DeferIndexBuilds         bool     `long:"deferIndexBuilds" description:"build the indexes of all collections after all of their data is restored, rather than after each collection"`
+	IndexCommitQuorum        string   `long:"indexCommitQuorum" value-name:"<quorum>" description:"the commitQuorum of index builds: a number of data-bearing voting members, 'majority', 'votingMembers' or a replica set tag (requires 4.4+)"`
+	MaxConcurrentIndexBuilds int      `long:"maxConcurrentIndexBuilds" value-name:"<n>" description:"limit the number of index builds run at once across all collections"`
+	SkipIndexes              []string `long:"skipIndex" value-name:"[<namespace-pattern>:]<index-name-pattern>" description:"don't build the indexes whose names match the pattern, on the namespaces matching the namespace pattern if one is given, e.g. 'test.*:tmp_*'; may be repeated"`
+	IndexDefinitionsFile     string   `long:"indexDefinitionsFile" value-name:"<file-path>" description:"write the index definitions of the restored collections to this file as extended JSON, one line per collection, without building them"`
+	FixDottedHashedIndexes   bool     `long:"fixDottedHashIndex" description:"when enabled, all the hashed indexes on dotted fields will be created as single field ascending indexes on the destination"`
+	Mode                     string   `long:"mode" choice:"insert" choice:"upsert" choice:"merge" choice:"insertIgnore" choice:"replace" description:"how documents with the same _id as one in the collection are written. insert: insert only, reporting duplicate key errors. upsert: insert new documents or replace existing documents. merge: insert new documents or set the top-level fields of existing documents. insertIgnore: insert only, skipping and counting duplicates. replace: replace existing documents only, skipping new documents. (default: insert)"`
+	Transforms               []string `long:"transform" value-name:"<expression>|exec:<command>" description:"rewrite each document before it is written, with a jq-like expression of stages separated by '|' ('.a.b = <json or field>', 'del(.a, .b)', 'select(.a == <json>)'), or with 'exec:<command>', which reads documents as extended JSON lines and writes back one line each, empty to drop the document; may be repeated"`
+	RateLimit                float64  `long:"rateLimit" value-name:"<MB/s>" description:"limit the rate documents are written to this many megabytes per second, across all collections and the oplog replay"`
+	MaxDocsPerSecond         int      `long:"maxDocsPerSecond" value-name:"<n>" description:"limit the rate documents are written to this many per second, across all collections and the oplog replay"`
+	MaxReplicationLag        string   `long:"maxReplicationLag" value-name:"<duration>" description:"pause writes while a secondary of the target replica set, other than a delayed one, is further behind its primary than this, e.g. '10s'"`
+	MaxMemory                string   `long:"maxMemory" value-name:"<size>" description:"bound the documents held in memory by the --archiveBufferSize and --archiveReadAhead buffers, the batches being inserted and their --transform output, across all collections, e.g. '2GB'; batches are sent smaller while the budget is spent, and reading waits for it (minimum: 64MB more than --archiveReadAhead)"`
+	MirrorURIs               []string `long:"mirrorUri" value-name:"<uri>" description:"also restore the collections and their indexes to the cluster at this connection string, from the same read of the dump; a mirror that fails is reported and no longer written, without failing the restore. The oplog, users, roles and views are only restored to the target; may be repeated"`
+	MirrorsFile              string   `long:"mirrorsFile" value-name:"<file-path>" description:"also restore to the clusters listed in this JSON or YAML file, as a list of {\"name\": <name>, \"uri\": <connection string>}, as with --mirrorUri"`
+	Verify                   bool     `long:"verify" description:"after restoring the data, check the document counts, indexes and collection options of each restored collection against the dump's manifest and metadata, and fail if any differ"`
+	VerifyHash               bool     `long:"verifyHash" description:"with --verify, also compare the dbHash of each restored collection with the one recorded by mongodump --dbHash"`
+	VerifyReport             string   `long:"verifyReport" value-name:"<file-path>" description:"with --verify or --verifyAgainst, write the pass/fail report of each collection to this file as JSON"`
+	VerifyAgainst            string   `long:"verifyAgainst" value-name:"<uri>" description:"once the restore is done, including the oplog replay, compare the document count and dbHash of each restored collection with those of the collection it was dumped from in the cluster at this connection string, and fail if any differ; writes to the source should be stopped for the comparison to be meaningful"`
+	MetadataOnly             bool     `long:"metadataOnly" description:"restore collection options, indexes, users and roles, but no collection data"`
+	IndexesOnly              bool     `long:"indexesOnly" description:"restore only the indexes of collections, without their data or options"`
+	Resume                   bool     `long:"resume" description:"record the progress of each collection, and continue an interrupted restore of the same dump, skipping the collections already restored"`
+	ResumeFile               string   `long:"resumeFile" value-name:"<file-path>" description:"record the progress of the restore in this file (default: '<dir>.restore-state.json' with --resume)"`
+	PreRestoreHook           string   `long:"preRestoreHook" value-name:"<command>" description:"shell command to run once before the restore, with the event 'preRun', and before restoring each namespace, with the event 'preRestore' and the namespace and where it is restored from, given as JSON on stdin and in MONGORESTORE_* environment variables; the restore fails if it fails"`
+	PostRestoreHook          string   `long:"postRestoreHook" value-name:"<command>" description:"shell command to run after each namespace is restored, with the event 'postRestore', and once the restore finishes, with the event 'postRun' and its error if it failed, given the documents and failures restored and the seconds taken as JSON on stdin and in MONGORESTORE_* environment variables; the restore fails if it fails"`
 }
 
 // Name returns a human-readable group name for output options.
@@ -124,6 +248,7 @@ const (
 	NSIncludeOption                  = "--nsInclude"
 	NSFromOption                     = "--nsFrom"
 	NSToOption                       = "--nsTo"
+	NSMapFileOption                  = "--nsMapFile"
 )
 
 // NSOptions defines the set of options for configuring involved namespaces
@@ -132,10 +257,11 @@ type NSOptions struct {
 	Collection                 string   `short:"c" long:"collection" value-name:"<collection-name>" description:"collection to use when restoring from a BSON file"`
 	ExcludedCollections        []string `long:"excludeCollection" value-name:"<collection-name>" description:"DEPRECATED; collection to skip over during restore (may be specified multiple times to exclude additional collections)"`
 	ExcludedCollectionPrefixes []string `long:"excludeCollectionsWithPrefix" value-name:"<collection-prefix>" description:"DEPRECATED; collections to skip over during restore that have the given prefix (may be specified multiple times to exclude additional prefixes)"`
-	NSExclude                  []string `long:"nsExclude" value-name:"<namespace-pattern>" description:"exclude matching namespaces"`
-	NSInclude                  []string `long:"nsInclude" value-name:"<namespace-pattern>" description:"include matching namespaces"`
-	NSFrom                     []string `long:"nsFrom" value-name:"<namespace-pattern>" description:"rename matching namespaces, must have matching nsTo"`
-	NSTo                       []string `long:"nsTo" value-name:"<namespace-pattern>" description:"rename matched namespaces, must have matching nsFrom"`
+	NSExclude                  []string `long:"nsExclude" value-name:"<namespace-pattern>" description:"exclude matching namespaces; a pattern between slashes is a regular expression"`
+	NSInclude                  []string `long:"nsInclude" value-name:"<namespace-pattern>" description:"include matching namespaces; a pattern between slashes is a regular expression"`
+	NSFrom                     []string `long:"nsFrom" value-name:"<namespace-pattern>" description:"rename matching namespaces, must have matching nsTo; a pattern between slashes is a regular expression, e.g. '/^prod_(.*)\\.(.*)$/'"`
+	NSTo                       []string `long:"nsTo" value-name:"<namespace-pattern>" description:"rename matched namespaces, must have matching nsFrom; the groups of a regular expression are given as $1 or ${name}, e.g. 'staging_$1.$2'"`
+	NSMapFile                  string   `long:"nsMapFile" value-name:"<file-path>" description:"JSON or YAML file of renames, each with the from and to patterns of --nsFrom and --nsTo, which take precedence over them"`
 }
 
 // Name returns a human-readable group name for output options.
@@ -0,0 +1,165 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/progress"
+)
+
+// overallProgressName is the name of the progress bar of the whole restore.
+const overallProgressName = "total"
+
+// restoreProgress tracks the namespaces of a restore against what the dump
+// recorded of them, for progress bars with true totals: the document counts
+// of the dump's manifest or archive prelude when it has them for every
+// namespace, or else the sizes of the dump's files.
+type restoreProgress struct {
+	sync.Mutex
+	countsDocuments bool
+	// expected holds the documents or bytes of each namespace to restore
+	expected map[string]int64
+	total    int64
+	counters map[string]progress.Progressor
+	finished map[string]bool
+}
+
+// initProgress reads the totals of the namespaces to restore. Archives whose
+// prelude has no document counts only have the archive's progress bar, as
+// the sizes of their namespaces aren't known.
+func (restore *MongoRestore) initProgress() {
+	documents := restore.dumpDocumentCounts()
+	p := &restoreProgress{
+		countsDocuments: true,
+		expected:        map[string]int64{},
+		counters:        map[string]progress.Progressor{},
+		finished:        map[string]bool{},
+	}
+	sizes := map[string]int64{}
+	for _, intent := range restore.manager.Intents() {
+		if intent.BSONFile == nil || intent.IsOplog() || intent.IsSpecialCollection() {
+			continue
+		}
+		count, ok := documents[intent.Namespace()]
+		if !ok {
+			p.countsDocuments = false
+		}
+		p.expected[intent.Namespace()] = count
+		sizes[intent.Namespace()] = intent.Size
+	}
+	if !p.countsDocuments {
+		if restore.InputOptions.Archive != "" {
+			return
+		}
+		p.expected = sizes
+	}
+	for _, amount := range p.expected {
+		p.total += amount
+	}
+	restore.progress = p
+}
+
+// dumpDocumentCounts returns the document count of each namespace of the
+// dump, by destination namespace, from the archive's prelude or the dump's
+// manifest.
+func (restore *MongoRestore) dumpDocumentCounts() map[string]int64 {
+	counts := map[string]int64{}
+	if restore.InputOptions.Archive != "" {
+		if restore.archive == nil || restore.archive.Prelude == nil {
+			return counts
+		}
+		for _, cm := range restore.archive.Prelude.NamespaceMetadatas {
+			if cm.Documents != nil {
+				counts[restore.renamer.Get(cm.Database+"."+cm.Collection)] = *cm.Documents
+			}
+		}
+		return counts
+	}
+	if restore.TargetDirectory == "-" {
+		return counts
+	}
+	root, manifest, err := restore.readVerifyManifest()
+	if err != nil {
+		return counts
+	}
+	for _, intent := range restore.manager.Intents() {
+		if intent.Location == "" {
+			continue
+		}
+		if file := manifestEntryFor(manifest, relativeLocation(root, intent.Location)); file != nil && file.Documents != nil {
+			counts[intent.Namespace()] = *file.Documents
+		}
+	}
+	return counts
+}
+
+// counter returns the progressor of a namespace being restored from a file
+// of fileSize bytes, which counts documents if the dump's document counts
+// are known.
+func (p *restoreProgress) counter(ns string, fileSize int64) *progress.CountProgressor {
+	if p == nil {
+		return progress.NewCounter(fileSize)
+	}
+	p.Lock()
+	defer p.Unlock()
+	var counter *progress.CountProgressor
+	if expected, ok := p.expected[ns]; ok && p.countsDocuments {
+		counter = progress.NewDocumentCounter(expected)
+	} else {
+		counter = progress.NewCounter(fileSize)
+	}
+	p.counters[ns] = counter
+	return counter
+}
+
+// finish records that a namespace is done, whether it was restored or
+// skipped.
+func (p *restoreProgress) finish(ns string) {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.finished[ns] = true
+}
+
+// Progress returns the amount of the namespaces to restore done, counting
+// finished namespaces in full, and their total.
+func (p *restoreProgress) Progress() (int64, int64) {
+	p.Lock()
+	defer p.Unlock()
+	var done int64
+	for ns, expected := range p.expected {
+		if p.finished[ns] {
+			done += expected
+		} else if counter := p.counters[ns]; counter != nil {
+			current, _ := counter.Progress()
+			if current > expected {
+				current = expected
+			}
+			done += current
+		}
+	}
+	return done, p.total
+}
+
+// CountsDocuments returns whether the progress is counted in documents.
+func (p *restoreProgress) CountsDocuments() bool {
+	return p.countsDocuments
+}
+
+// attachProgress shows the progress bar of the whole restore, returning a
+// function that removes it.
+func (restore *MongoRestore) attachProgress() func() {
+	restore.initProgress()
+	if restore.progress == nil || restore.ProgressManager == nil {
+		return func() {}
+	}
+	restore.ProgressManager.Attach(overallProgressName, restore.progress)
+	return func() { restore.ProgressManager.Detach(overallProgressName) }
+}
@@ -0,0 +1,111 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRestoreProgress(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	newRestore := func(inputOptions *InputOptions, dir string) *MongoRestore {
+		renamer, _ := ns.NewRenamer([]string{"src.*"}, []string{"dst.*"})
+		restore := &MongoRestore{
+			InputOptions:    inputOptions,
+			TargetDirectory: dir,
+			manager:         intents.NewIntentManager(),
+			renamer:         renamer,
+		}
+		restore.manager.Put(&intents.Intent{DB: "dst", C: "a", Location: filepath.Join(dir, "src", "a.bson"), Size: 100, BSONFile: &realBSONFile{}})
+		restore.manager.Put(&intents.Intent{DB: "dst", C: "b", Location: filepath.Join(dir, "src", "b.bson"), Size: 300, BSONFile: &realBSONFile{}})
+		return restore
+	}
+	documents := func(n int64) *int64 { return &n }
+
+	Convey("An archive's prelude gives the document totals", t, func() {
+		restore := newRestore(&InputOptions{Archive: "dump.archive"}, "")
+		restore.archive = &archive.Reader{Prelude: &archive.Prelude{NamespaceMetadatas: []*archive.CollectionMetadata{
+			{Database: "src", Collection: "a", Documents: documents(10)},
+			{Database: "src", Collection: "b", Documents: documents(30)},
+		}}}
+		restore.initProgress()
+		So(restore.progress, ShouldNotBeNil)
+		So(restore.progress.CountsDocuments(), ShouldBeTrue)
+		done, total := restore.progress.Progress()
+		So(done, ShouldEqual, 0)
+		So(total, ShouldEqual, 40)
+
+		counter := restore.progress.counter("dst.a", 100)
+		So(counter.CountsDocuments(), ShouldBeTrue)
+		counter.Inc(4)
+		current, max := counter.Progress()
+		So(current, ShouldEqual, 4)
+		So(max, ShouldEqual, 10)
+		done, _ = restore.progress.Progress()
+		So(done, ShouldEqual, 4)
+
+		Convey("and finished or skipped namespaces count in full", func() {
+			restore.progress.finish("dst.a")
+			restore.progress.finish("dst.b")
+			done, _ := restore.progress.Progress()
+			So(done, ShouldEqual, 40)
+		})
+	})
+
+	Convey("An archive without document counts has no overall progress", t, func() {
+		restore := newRestore(&InputOptions{Archive: "dump.archive"}, "")
+		restore.archive = &archive.Reader{Prelude: &archive.Prelude{NamespaceMetadatas: []*archive.CollectionMetadata{
+			{Database: "src", Collection: "a"},
+			{Database: "src", Collection: "b"},
+		}}}
+		restore.initProgress()
+		So(restore.progress, ShouldBeNil)
+		So(restore.progress.counter("dst.a", 100).CountsDocuments(), ShouldBeFalse)
+	})
+
+	Convey("With a dump directory", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_progress")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		Convey("its manifest gives the document totals", func() {
+			manifest := `{"files": [
+				{"path": "src/a.bson", "documents": 7},
+				{"path": "src/b.bson", "documents": 3}
+			]}`
+			So(ioutil.WriteFile(filepath.Join(dir, "manifest.json"), []byte(manifest), 0644), ShouldBeNil)
+			restore := newRestore(&InputOptions{}, dir)
+			restore.initProgress()
+			So(restore.progress.CountsDocuments(), ShouldBeTrue)
+			_, total := restore.progress.Progress()
+			So(total, ShouldEqual, 10)
+		})
+
+		Convey("without a manifest the file sizes are the totals", func() {
+			restore := newRestore(&InputOptions{}, dir)
+			restore.initProgress()
+			So(restore.progress.CountsDocuments(), ShouldBeFalse)
+			_, total := restore.progress.Progress()
+			So(total, ShouldEqual, 400)
+			counter := restore.progress.counter("dst.b", 300)
+			So(counter.CountsDocuments(), ShouldBeFalse)
+			counter.Set(150)
+			done, _ := restore.progress.Progress()
+			So(done, ShouldEqual, 150)
+		})
+	})
+}
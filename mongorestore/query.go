@@ -0,0 +1,543 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// documentQuery is a --query or --queryFile filter, matched against each
+// document of the dump before it is restored.
+type documentQuery func(doc bson.D) bool
+
+// namespaceQuery is the filter of the namespaces matching a pattern of a
+// --queryFile given without --collection.
+type namespaceQuery struct {
+	namespace *ns.Matcher
+	query     documentQuery
+}
+
+// initQueries parses --query or --queryFile. Without --collection, a
+// queryFile maps namespace patterns to the filter of the matching
+// namespaces.
+func (restore *MongoRestore) initQueries() error {
+	options := restore.InputOptions
+	if options.Query == "" && options.QueryFile == "" {
+		return nil
+	}
+	if options.Query != "" && options.QueryFile != "" {
+		return fmt.Errorf("cannot use both %v and %v", QueryOption, QueryFileOption)
+	}
+	if restore.InputOptions.OplogReplay {
+		log.Logvf(log.Always, "warning: the oplog is replayed in full, including on the documents %v doesn't restore",
+			QueryOption)
+	}
+	if options.Query != "" {
+		query, err := parseDocumentQuery([]byte(options.Query))
+		if err != nil {
+			return fmt.Errorf("invalid %v: %v", QueryOption, err)
+		}
+		restore.queries = []namespaceQuery{{query: query}}
+		return nil
+	}
+	content, err := util.ReadConfigFile(options.QueryFile)
+	if err != nil {
+		return fmt.Errorf("error reading %v: %v", QueryFileOption, err)
+	}
+	if restore.NSOptions.Collection != "" {
+		query, err := parseDocumentQuery(content)
+		if err != nil {
+			return fmt.Errorf("invalid %v: %v", QueryFileOption, err)
+		}
+		restore.queries = []namespaceQuery{{query: query}}
+		return nil
+	}
+	var doc bson.D
+	if err = bson.UnmarshalExtJSON(content, false, &doc); err != nil {
+		return fmt.Errorf("error parsing %v as Extended JSON: %v", QueryFileOption, err)
+	}
+	for _, elem := range doc {
+		pattern := elem.Key
+		if !strings.Contains(pattern, ".") && restore.NSOptions.DB != "" {
+			pattern = restore.NSOptions.DB + "." + pattern
+		}
+		matcher, err := ns.NewMatcher([]string{pattern})
+		if err != nil {
+			return fmt.Errorf("invalid namespace '%v' in %v: %v", elem.Key, QueryFileOption, err)
+		}
+		filter, ok := elem.Value.(bson.D)
+		if !ok {
+			return fmt.Errorf("query filter for '%v' in %v must be a document", elem.Key, QueryFileOption)
+		}
+		query, err := compileQuery(filter)
+		if err != nil {
+			return fmt.Errorf("invalid query filter for '%v' in %v: %v", elem.Key, QueryFileOption, err)
+		}
+		restore.queries = append(restore.queries, namespaceQuery{namespace: matcher, query: query})
+	}
+	return nil
+}
+
+// queryFor returns the filter of the documents restored to a namespace, or
+// nil if all of them are: the first of a --queryFile matching it.
+func (restore *MongoRestore) queryFor(namespace string) documentQuery {
+	for _, query := range restore.queries {
+		if query.namespace == nil || query.namespace.Has(namespace) {
+			return query.query
+		}
+	}
+	return nil
+}
+
+// matches returns whether a filtered document is restored. A nil query
+// matches every document.
+func (query documentQuery) matches(raw bson.Raw) (bool, error) {
+	if query == nil {
+		return true, nil
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return false, fmt.Errorf("error decoding document to match: %v", err)
+	}
+	return query(doc), nil
+}
+
+// parseDocumentQuery parses a query filter given as Extended JSON.
+func parseDocumentQuery(content []byte) (documentQuery, error) {
+	var filter bson.D
+	if err := bson.UnmarshalExtJSON(content, false, &filter); err != nil {
+		return nil, fmt.Errorf("error parsing query as Extended JSON: %v", err)
+	}
+	return compileQuery(filter)
+}
+
+// compileQuery returns the matcher of a query filter. It supports the
+// comparison, logical, element and array query operators, and $regex;
+// others, like $expr and $where, are an error.
+func compileQuery(filter bson.D) (documentQuery, error) {
+	var clauses []documentQuery
+	for _, elem := range filter {
+		switch elem.Key {
+		case "$and", "$or", "$nor":
+			queries, err := compileQueries(elem.Key, elem.Value)
+			if err != nil {
+				return nil, err
+			}
+			clauses = append(clauses, combineQueries(elem.Key, queries))
+		case "$comment":
+		default:
+			if strings.HasPrefix(elem.Key, "$") {
+				return nil, fmt.Errorf("unsupported query operator %v", elem.Key)
+			}
+			condition, err := compileCondition(elem.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", elem.Key, err)
+			}
+			path := strings.Split(elem.Key, ".")
+			clauses = append(clauses, func(doc bson.D) bool {
+				return condition(lookupQueryPath(doc, path))
+			})
+		}
+	}
+	return combineQueries("$and", clauses), nil
+}
+
+// compileQueries compiles the array of filters of a logical operator.
+func compileQueries(operator string, value interface{}) ([]documentQuery, error) {
+	filters, ok := value.(bson.A)
+	if !ok || len(filters) == 0 {
+		return nil, fmt.Errorf("%v must be a nonempty array", operator)
+	}
+	var queries []documentQuery
+	for _, filter := range filters {
+		doc, ok := filter.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("%v must be an array of documents", operator)
+		}
+		query, err := compileQuery(doc)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, query)
+	}
+	return queries, nil
+}
+
+func combineQueries(operator string, queries []documentQuery) documentQuery {
+	return func(doc bson.D) bool {
+		for _, query := range queries {
+			matched := query(doc)
+			switch {
+			case operator == "$and" && !matched:
+				return false
+			case operator == "$or" && matched:
+				return true
+			case operator == "$nor" && matched:
+				return false
+			}
+		}
+		return operator != "$or"
+	}
+}
+
+// valueCondition matches the values found at the path of a field. values
+// holds each array as well as its elements, and is empty for a missing
+// field.
+type valueCondition func(values []interface{}) bool
+
+// lookupQueryPath returns the values at a dotted path of a document,
+// following arrays of documents, and with the elements of arrays found.
+func lookupQueryPath(value interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		if array, ok := value.(bson.A); ok {
+			return append([]interface{}{array}, array...)
+		}
+		return []interface{}{value}
+	}
+	switch value := value.(type) {
+	case bson.D:
+		for _, elem := range value {
+			if elem.Key == path[0] {
+				return lookupQueryPath(elem.Value, path[1:])
+			}
+		}
+	case bson.A:
+		if i, err := strconv.Atoi(path[0]); err == nil {
+			if i >= 0 && i < len(value) {
+				return lookupQueryPath(value[i], path[1:])
+			}
+			return nil
+		}
+		var values []interface{}
+		for _, elem := range value {
+			if _, ok := elem.(bson.D); ok {
+				values = append(values, lookupQueryPath(elem, path)...)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// isOperatorDocument returns whether a condition is a document of query
+// operators rather than a value to match.
+func isOperatorDocument(value interface{}) (bson.D, bool) {
+	doc, ok := value.(bson.D)
+	if !ok || len(doc) == 0 || !strings.HasPrefix(doc[0].Key, "$") {
+		return nil, false
+	}
+	return doc, true
+}
+
+// compileCondition returns the matcher of the condition on a field.
+func compileCondition(value interface{}) (valueCondition, error) {
+	operators, ok := isOperatorDocument(value)
+	if !ok {
+		return equalCondition(value), nil
+	}
+	var conditions []valueCondition
+	var regexOptions string
+	if options, err := findQueryOperator(operators, "$options"); err == nil {
+		if regexOptions, ok = options.(string); !ok {
+			return nil, fmt.Errorf("$options must be a string")
+		}
+	}
+	for _, elem := range operators {
+		condition, err := compileOperator(elem.Key, elem.Value, regexOptions)
+		if err != nil {
+			return nil, err
+		}
+		if condition != nil {
+			conditions = append(conditions, condition)
+		}
+	}
+	return func(values []interface{}) bool {
+		for _, condition := range conditions {
+			if !condition(values) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func findQueryOperator(operators bson.D, key string) (interface{}, error) {
+	for _, elem := range operators {
+		if elem.Key == key {
+			return elem.Value, nil
+		}
+	}
+	return nil, fmt.Errorf("no %v", key)
+}
+
+// compileOperator returns the matcher of a query operator, or nil for
+// $options, which applies to $regex.
+func compileOperator(operator string, value interface{}, regexOptions string) (valueCondition, error) {
+	switch operator {
+	case "$eq":
+		return equalCondition(value), nil
+	case "$ne":
+		equal := equalCondition(value)
+		return func(values []interface{}) bool { return !equal(values) }, nil
+	case "$gt", "$gte", "$lt", "$lte":
+		return func(values []interface{}) bool {
+			for _, v := range values {
+				if cmp, ok := compareQueryValues(v, value); ok {
+					switch {
+					case operator == "$gt" && cmp > 0, operator == "$gte" && cmp >= 0,
+						operator == "$lt" && cmp < 0, operator == "$lte" && cmp <= 0:
+						return true
+					}
+				}
+			}
+			return false
+		}, nil
+	case "$in", "$nin":
+		list, ok := value.(bson.A)
+		if !ok {
+			return nil, fmt.Errorf("%v needs an array", operator)
+		}
+		var conditions []valueCondition
+		for _, elem := range list {
+			conditions = append(conditions, equalCondition(elem))
+		}
+		return func(values []interface{}) bool {
+			for _, condition := range conditions {
+				if condition(values) {
+					return operator == "$in"
+				}
+			}
+			return operator == "$nin"
+		}, nil
+	case "$all":
+		list, ok := value.(bson.A)
+		if !ok {
+			return nil, fmt.Errorf("$all needs an array")
+		}
+		var conditions []valueCondition
+		for _, elem := range list {
+			conditions = append(conditions, equalCondition(elem))
+		}
+		return func(values []interface{}) bool {
+			for _, condition := range conditions {
+				if !condition(values) {
+					return false
+				}
+			}
+			return len(conditions) > 0
+		}, nil
+	case "$exists":
+		exists := value != false && value != int32(0) && value != int64(0) && value != float64(0)
+		return func(values []interface{}) bool { return (len(values) > 0) == exists }, nil
+	case "$size":
+		size, err := util.ToInt(value)
+		if err != nil {
+			return nil, fmt.Errorf("$size needs a number")
+		}
+		return func(values []interface{}) bool {
+			for _, v := range values {
+				if array, ok := v.(bson.A); ok && len(array) == size {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case "$regex":
+		re, err := compileQueryRegex(value, regexOptions)
+		if err != nil {
+			return nil, err
+		}
+		return regexCondition(re), nil
+	case "$options":
+		return nil, nil
+	case "$not":
+		var condition valueCondition
+		var err error
+		if operators, ok := isOperatorDocument(value); ok {
+			condition, err = compileCondition(operators)
+		} else if regex, ok := value.(primitive.Regex); ok {
+			var re *regexp.Regexp
+			if re, err = compileQueryRegex(regex, ""); err == nil {
+				condition = regexCondition(re)
+			}
+		} else {
+			err = fmt.Errorf("$not needs a document of operators or a regular expression")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return func(values []interface{}) bool { return !condition(values) }, nil
+	case "$elemMatch":
+		doc, ok := value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("$elemMatch needs a document")
+		}
+		var match func(elem interface{}) bool
+		if _, ok := isOperatorDocument(doc); ok {
+			condition, err := compileCondition(doc)
+			if err != nil {
+				return nil, err
+			}
+			match = func(elem interface{}) bool { return condition(lookupQueryPath(elem, nil)) }
+		} else {
+			query, err := compileQuery(doc)
+			if err != nil {
+				return nil, err
+			}
+			match = func(elem interface{}) bool {
+				sub, ok := elem.(bson.D)
+				return ok && query(sub)
+			}
+		}
+		return func(values []interface{}) bool {
+			for _, v := range values {
+				if array, ok := v.(bson.A); ok {
+					for _, elem := range array {
+						if match(elem) {
+							return true
+						}
+					}
+				}
+			}
+			return false
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported query operator %v", operator)
+}
+
+// equalCondition matches a field equal to value, or containing it in an
+// array. A regular expression matches the strings it matches, and null
+// matches a missing field.
+func equalCondition(value interface{}) valueCondition {
+	if regex, ok := value.(primitive.Regex); ok {
+		if re, err := compileQueryRegex(regex, ""); err == nil {
+			return regexCondition(re)
+		}
+	}
+	return func(values []interface{}) bool {
+		if value == nil && len(values) == 0 {
+			return true
+		}
+		for _, v := range values {
+			if sameValue(v, value) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func regexCondition(re *regexp.Regexp) valueCondition {
+	return func(values []interface{}) bool {
+		for _, v := range values {
+			if s, ok := v.(string); ok && re.MatchString(s) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// compileQueryRegex compiles a $regex, given as a string or a regular
+// expression, with its options.
+func compileQueryRegex(value interface{}, options string) (*regexp.Regexp, error) {
+	var pattern string
+	switch value := value.(type) {
+	case string:
+		pattern = value
+	case primitive.Regex:
+		pattern = value.Pattern
+		options += value.Options
+	default:
+		return nil, fmt.Errorf("$regex needs a string or a regular expression")
+	}
+	var flags string
+	for _, option := range options {
+		switch option {
+		case 'i', 'm', 's':
+			flags += string(option)
+		case 'x':
+		default:
+			return nil, fmt.Errorf("unsupported regular expression option '%c'", option)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// compareQueryValues compares two values of the same kind, like the
+// server's query comparisons, reporting false for values of different
+// kinds.
+func compareQueryValues(a, b interface{}) (int, bool) {
+	if x, err := util.ToFloat64(a); err == nil {
+		y, err := util.ToFloat64(b)
+		if err != nil {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case primitive.DateTime:
+		if y, ok := b.(primitive.DateTime); ok {
+			return compareInt64(int64(x), int64(y)), true
+		}
+	case primitive.Timestamp:
+		if y, ok := b.(primitive.Timestamp); ok {
+			if cmp := compareInt64(int64(x.T), int64(y.T)); cmp != 0 {
+				return cmp, true
+			}
+			return compareInt64(int64(x.I), int64(y.I)), true
+		}
+	case primitive.ObjectID:
+		if y, ok := b.(primitive.ObjectID); ok {
+			return bytes.Compare(x[:], y[:]), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, true
+			case y:
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+func compareInt64(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
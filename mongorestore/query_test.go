@@ -0,0 +1,156 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// queryMatches reports whether doc matches the Extended JSON filter.
+func queryMatches(filter string, doc bson.D) bool {
+	query, err := parseDocumentQuery([]byte(filter))
+	So(err, ShouldBeNil)
+	matched, err := query.matches(mustMarshal(doc))
+	So(err, ShouldBeNil)
+	return matched
+}
+
+func TestDocumentQuery(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	doc := bson.D{
+		{Key: "_id", Value: int32(1)},
+		{Key: "name", Value: "Alice"},
+		{Key: "age", Value: int32(30)},
+		{Key: "created", Value: primitive.NewDateTimeFromTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))},
+		{Key: "tags", Value: bson.A{"a", "b"}},
+		{Key: "address", Value: bson.D{{Key: "city", Value: "Paris"}}},
+		{Key: "orders", Value: bson.A{
+			bson.D{{Key: "item", Value: "x"}, {Key: "qty", Value: int32(2)}},
+			bson.D{{Key: "item", Value: "y"}, {Key: "qty", Value: int32(5)}},
+		}},
+	}
+
+	Convey("Query filters match decoded documents", t, func() {
+		So(queryMatches(`{}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"name": "Alice", "age": 30}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"age": {"$numberLong": "30"}}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"name": "Bob"}`, doc), ShouldBeFalse)
+		So(queryMatches(`{"address.city": "Paris"}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"tags": "b"}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"tags": ["a", "b"]}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"orders.item": "y"}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"orders.1.qty": 5}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"missing": null}`, doc), ShouldBeTrue)
+	})
+
+	Convey("Comparison operators compare values of the same kind", t, func() {
+		So(queryMatches(`{"age": {"$gt": 20, "$lte": 30}}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"age": {"$lt": 30}}`, doc), ShouldBeFalse)
+		So(queryMatches(`{"age": {"$gt": "20"}}`, doc), ShouldBeFalse)
+		So(queryMatches(`{"created": {"$gte": {"$date": "2019-01-01T00:00:00Z"}}}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"created": {"$lt": {"$date": "2019-01-01T00:00:00Z"}}}`, doc), ShouldBeFalse)
+		So(queryMatches(`{"name": {"$ne": "Bob"}}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"name": {"$in": ["Bob", "Alice"]}}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"tags": {"$nin": ["c"]}}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"tags": {"$nin": ["a"]}}`, doc), ShouldBeFalse)
+	})
+
+	Convey("Logical, element and array operators are supported", t, func() {
+		So(queryMatches(`{"$or": [{"name": "Bob"}, {"age": 30}]}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"$and": [{"name": "Alice"}, {"age": 31}]}`, doc), ShouldBeFalse)
+		So(queryMatches(`{"$nor": [{"name": "Bob"}]}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"age": {"$not": {"$gt": 40}}}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"address": {"$exists": true}, "zip": {"$exists": false}}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"tags": {"$size": 2, "$all": ["b", "a"]}}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"orders": {"$elemMatch": {"item": "x", "qty": {"$gt": 3}}}}`, doc), ShouldBeFalse)
+		So(queryMatches(`{"orders": {"$elemMatch": {"item": "y", "qty": {"$gt": 3}}}}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"name": {"$regex": "^ali", "$options": "i"}}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"name": {"$regularExpression": {"pattern": "ce$", "options": ""}}}`, doc), ShouldBeTrue)
+		So(queryMatches(`{"name": {"$not": {"$regularExpression": {"pattern": "^A", "options": ""}}}}`, doc), ShouldBeFalse)
+	})
+
+	Convey("Unsupported operators are rejected", t, func() {
+		for _, filter := range []string{
+			`{"$where": "true"}`,
+			`{"$expr": {"$eq": ["$a", 1]}}`,
+			`{"age": {"$mod": [2, 0]}}`,
+			`{"$or": []}`,
+			`{"age": {"$in": 1}}`,
+		} {
+			_, err := parseDocumentQuery([]byte(filter))
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestInitQueries(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	newRestore := func(inputOptions *InputOptions, db, collection string) *MongoRestore {
+		return &MongoRestore{
+			InputOptions: inputOptions,
+			NSOptions:    &NSOptions{DB: db, Collection: collection},
+		}
+	}
+
+	Convey("--query applies to every namespace", t, func() {
+		restore := newRestore(&InputOptions{Query: `{"a": 1}`}, "", "")
+		So(restore.initQueries(), ShouldBeNil)
+		So(restore.queryFor("test.c1"), ShouldNotBeNil)
+		So(restore.queryFor("other.c2"), ShouldNotBeNil)
+	})
+
+	Convey("--query and --queryFile are mutually exclusive", t, func() {
+		restore := newRestore(&InputOptions{Query: `{}`, QueryFile: "q.json"}, "", "")
+		So(restore.initQueries(), ShouldNotBeNil)
+	})
+
+	Convey("An invalid --query is an error", t, func() {
+		restore := newRestore(&InputOptions{Query: `{"a": {"$where": 1}}`}, "", "")
+		So(restore.initQueries(), ShouldNotBeNil)
+	})
+
+	Convey("--queryFile maps namespace patterns to filters", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_query")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "queries.yaml")
+		So(ioutil.WriteFile(path, []byte("users:\n  active: true\n'logs.*':\n  level: error\n"), 0644), ShouldBeNil)
+
+		restore := newRestore(&InputOptions{QueryFile: path}, "test", "")
+		So(restore.initQueries(), ShouldBeNil)
+		users := restore.queryFor("test.users")
+		So(users, ShouldNotBeNil)
+		matched, err := users.matches(mustMarshal(bson.D{{Key: "active", Value: true}}))
+		So(err, ShouldBeNil)
+		So(matched, ShouldBeTrue)
+		logs := restore.queryFor("logs.app")
+		So(logs, ShouldNotBeNil)
+		matched, err = logs.matches(mustMarshal(bson.D{{Key: "active", Value: true}}))
+		So(err, ShouldBeNil)
+		So(matched, ShouldBeFalse)
+		So(restore.queryFor("test.other"), ShouldBeNil)
+
+		Convey("and holds a single filter with --collection", func() {
+			path := filepath.Join(dir, "query.json")
+			So(ioutil.WriteFile(path, []byte(`{"active": true}`), 0644), ShouldBeNil)
+			restore := newRestore(&InputOptions{QueryFile: path}, "test", "users")
+			So(restore.initQueries(), ShouldBeNil)
+			So(restore.queryFor("test.users"), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,219 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/storage"
+)
+
+// remotePath implements archive.DirLike for a dump in object storage. The
+// objects under the dump are listed once, and their names, which are
+// slash-separated, are treated as paths.
+type remotePath struct {
+	// location is the URL of the root of the listing
+	location string
+	// name is relative to location, and empty for the root
+	name     string
+	size     int64
+	dir      bool
+	parent   *remotePath
+	children []*remotePath
+}
+
+// newRemotePath lists the dump at an object storage URL, which is either a
+// prefix of the objects of a dump, or a single object of one.
+func newRemotePath(location string) (*remotePath, error) {
+	location = strings.TrimSuffix(location, "/")
+	root, err := listRemotePath(location)
+	if err != nil {
+		return nil, err
+	}
+	if len(root.children) > 0 {
+		return root, nil
+	}
+
+	// the location may be a single object, which is found in the listing
+	// of its parent
+	dir, name := path.Split(location)
+	if !strings.HasSuffix(dir, "://") {
+		parent, err := listRemotePath(strings.TrimSuffix(dir, "/"))
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range parent.children {
+			if child.name == name {
+				return child, nil
+			}
+		}
+	}
+	return nil, &os.PathError{Op: "list", Path: location, Err: os.ErrNotExist}
+}
+
+// listRemotePath lists the objects under location, as a tree.
+func listRemotePath(location string) (*remotePath, error) {
+	reader, err := storage.OpenReader(location)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := reader.Objects("")
+	if err != nil {
+		return nil, err
+	}
+	root := &remotePath{location: location, dir: true}
+	dirs := map[string]*remotePath{"": root}
+	var dirFor func(name string) *remotePath
+	dirFor = func(name string) *remotePath {
+		if dir, ok := dirs[name]; ok {
+			return dir
+		}
+		parent := dirFor(dirOf(name))
+		dir := &remotePath{location: location, name: name, dir: true, parent: parent}
+		parent.children = append(parent.children, dir)
+		dirs[name] = dir
+		return dir
+	}
+	for _, object := range objects {
+		if object.Name == "" || strings.HasSuffix(object.Name, "/") {
+			// placeholders created for directories by some tools
+			continue
+		}
+		parent := dirFor(dirOf(object.Name))
+		parent.children = append(parent.children, &remotePath{
+			location: location,
+			name:     object.Name,
+			size:     object.Size,
+			parent:   parent,
+		})
+	}
+	for _, dir := range dirs {
+		sort.Slice(dir.children, func(i, j int) bool { return dir.children[i].name < dir.children[j].name })
+	}
+	return root, nil
+}
+
+// dirOf returns the directory of a slash-separated name, which is empty at
+// the root.
+func dirOf(name string) string {
+	if dir := path.Dir(name); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// Name is part of the archive.DirLike interface.
+func (rp *remotePath) Name() string {
+	if rp.name == "" {
+		return path.Base(rp.location)
+	}
+	return path.Base(rp.name)
+}
+
+// Path is part of the archive.DirLike interface. It is the URL of the
+// object or prefix.
+func (rp *remotePath) Path() string {
+	if rp.name == "" {
+		return rp.location
+	}
+	return rp.location + "/" + rp.name
+}
+
+// Size is part of the archive.DirLike interface.
+func (rp *remotePath) Size() int64 {
+	return rp.size
+}
+
+// IsDir is part of the archive.DirLike interface.
+func (rp *remotePath) IsDir() bool {
+	return rp.dir
+}
+
+// Stat is part of the archive.DirLike interface.
+func (rp *remotePath) Stat() (archive.DirLike, error) {
+	return rp, nil
+}
+
+// ReadDir is part of the archive.DirLike interface.
+func (rp *remotePath) ReadDir() ([]archive.DirLike, error) {
+	if !rp.dir {
+		return nil, fmt.Errorf("%v is not a directory", rp.Path())
+	}
+	entries := make([]archive.DirLike, len(rp.children))
+	for i, child := range rp.children {
+		entries[i] = child
+	}
+	return entries, nil
+}
+
+// Parent is part of the archive.DirLike interface.
+func (rp *remotePath) Parent() archive.DirLike {
+	if rp.parent == nil {
+		return nil
+	}
+	return rp.parent
+}
+
+// newTargetPath returns the dump at dir, on local disk or in object
+// storage.
+func newTargetPath(dir string) (archive.DirLike, error) {
+	if storage.IsRemote(dir) {
+		return newRemotePath(dir)
+	}
+	return newActualPath(dir)
+}
+
+// joinPath joins a path or object storage URL and a name.
+func joinPath(dir, name string) string {
+	if storage.IsRemote(dir) {
+		return strings.TrimSuffix(dir, "/") + "/" + name
+	}
+	return filepath.Join(dir, name)
+}
+
+// parentPath returns the directory of a path or object storage URL.
+func parentPath(location string) string {
+	if storage.IsRemote(location) {
+		dir, _ := path.Split(strings.TrimSuffix(location, "/"))
+		if strings.HasSuffix(dir, "://") {
+			return location
+		}
+		return strings.TrimSuffix(dir, "/")
+	}
+	return filepath.Dir(location)
+}
+
+// openFile opens the file at a path, or the object at an object storage
+// URL.
+func openFile(location string) (io.ReadCloser, error) {
+	if storage.IsRemote(location) {
+		return storage.OpenObject(location)
+	}
+	return os.Open(location)
+}
+
+// readFile reads the file at a path, or the object at an object storage
+// URL.
+func readFile(location string) ([]byte, error) {
+	if !storage.IsRemote(location) {
+		return ioutil.ReadFile(location)
+	}
+	in, err := storage.OpenObject(location)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return ioutil.ReadAll(in)
+}
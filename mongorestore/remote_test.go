@@ -0,0 +1,142 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeGCS serves the objects of a bucket as the Cloud Storage emulator
+// does, with ranged reads.
+func fakeGCS(bucket string, objects map[string]string) *httptest.Server {
+	listPath := "/storage/v1/b/" + bucket + "/o"
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == listPath {
+			prefix := r.URL.Query().Get("prefix")
+			var names []string
+			for name := range objects {
+				if strings.HasPrefix(name, prefix) {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			var items []map[string]string
+			for _, name := range names {
+				items = append(items, map[string]string{"name": name, "size": fmt.Sprint(len(objects[name]))})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+			return
+		}
+		content, ok := objects[strings.TrimPrefix(r.URL.Path, listPath+"/")]
+		if !ok || r.URL.Query().Get("alt") != "media" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+			w.Write([]byte(content))
+			return
+		}
+		if start >= len(content) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if end >= len(content) {
+			end = len(content) - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %v-%v/%v", start, end, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(content[start : end+1]))
+	}))
+}
+
+func TestRemotePath(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	// the backends of each location are cached, so the server is shared
+	server := fakeGCS("backups", map[string]string{
+		"dump/db/c.bson":          "documents",
+		"dump/db/c.metadata.json": "{}",
+		"dump/db/empty.bson":      "",
+		"dump/oplog.bson":         "oplog",
+		"other/db/c.bson":         "other",
+	})
+	defer server.Close()
+	os.Setenv("STORAGE_EMULATOR_HOST", server.URL)
+	defer os.Unsetenv("STORAGE_EMULATOR_HOST")
+
+	Convey("With a dump in object storage", t, func() {
+		Convey("its objects are listed as a directory tree", func() {
+			root, err := newTargetPath("gs://backups/dump/")
+			So(err, ShouldBeNil)
+			So(root.IsDir(), ShouldBeTrue)
+			So(root.Path(), ShouldEqual, "gs://backups/dump")
+			entries, err := root.ReadDir()
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 2)
+			So(entries[0].Name(), ShouldEqual, "db")
+			So(entries[0].IsDir(), ShouldBeTrue)
+			So(entries[1].Name(), ShouldEqual, "oplog.bson")
+			So(entries[1].Size(), ShouldEqual, 5)
+
+			collections, err := entries[0].ReadDir()
+			So(err, ShouldBeNil)
+			So(collections, ShouldHaveLength, 3)
+			So(collections[0].Path(), ShouldEqual, "gs://backups/dump/db/c.bson")
+			So(collections[0].Parent().Path(), ShouldEqual, "gs://backups/dump/db")
+		})
+
+		Convey("a single object is found in its parent", func() {
+			file, err := newTargetPath("gs://backups/dump/db/c.bson")
+			So(err, ShouldBeNil)
+			So(file.IsDir(), ShouldBeFalse)
+			So(file.Size(), ShouldEqual, 9)
+			siblings, err := file.Parent().ReadDir()
+			So(err, ShouldBeNil)
+			So(siblings, ShouldHaveLength, 3)
+
+			_, err = newTargetPath("gs://backups/dump/db/missing.bson")
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("objects are read in ranges", func() {
+			data, err := readFile("gs://backups/dump/db/c.bson")
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "documents")
+			data, err = readFile("gs://backups/dump/db/empty.bson")
+			So(err, ShouldBeNil)
+			So(data, ShouldBeEmpty)
+			_, err = readFile(joinPath("gs://backups/dump/", "missing.json"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			in, err := openBSONFile("gs://backups/other/db/c.bson")
+			So(err, ShouldBeNil)
+			data, err = ioutil.ReadAll(in)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "other")
+			So(in.Close(), ShouldBeNil)
+		})
+	})
+
+	Convey("Object storage URLs are joined and split like paths", t, func() {
+		So(joinPath("s3://bucket/dump/", "db"), ShouldEqual, "s3://bucket/dump/db")
+		So(parentPath("s3://bucket/dump/db"), ShouldEqual, "s3://bucket/dump")
+		So(parentPath("s3://bucket"), ShouldEqual, "s3://bucket")
+		So(parentPath("dump/db"), ShouldEqual, "dump")
+	})
+}
@@ -7,18 +7,20 @@
 package mongorestore
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/mongodb/mongo-tools-common/bsonutil"
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/intents"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/progress"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/tracing"
+	"github.com/mongodb/mongo-tools/common/util"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -30,14 +32,20 @@ const insertBufferFactor = 16
 type Result struct {
 	Successes int64
 	Failures  int64
-	Err       error
+	// Skipped counts the documents not written because of --mode
+	Skipped int64
+	Err     error
 }
 
 // log pretty-prints the result, associated with restoring the given namespace
 func (result *Result) log(ns string) {
-	log.Logvf(log.Always, "finished restoring %v (%v %v, %v %v)",
+	log.Logmf(log.Always, msgFinishedRestoring,
 		ns, result.Successes, util.Pluralize(int(result.Successes), "document", "documents"),
 		result.Failures, util.Pluralize(int(result.Failures), "failure", "failures"))
+	if result.Skipped > 0 {
+		log.Logmf(log.Always, msgSkippedDocuments,
+			result.Skipped, util.Pluralize(int(result.Skipped), "document", "documents"), ns)
+	}
 }
 
 // combineWith sums the successes and failures from both results and the overwrites the existing Err with the Err from
@@ -45,6 +53,7 @@ func (result *Result) log(ns string) {
 func (result *Result) combineWith(other Result) {
 	result.Successes += other.Successes
 	result.Failures += other.Failures
+	result.Skipped += other.Skipped
 	result.Err = other.Err
 }
 
@@ -59,7 +68,8 @@ func NewResultFromBulkResult(result *mongo.BulkWriteResult, err error) Result {
 		return Result{}
 	}
 
-	nSuccess := result.InsertedCount
+	// documents upserted when resuming a restore are matched or upserted
+	nSuccess := result.InsertedCount + result.MatchedCount + result.UpsertedCount
 	var nFailure int64
 
 	// if a write concern error is encountered, the failure count may be inaccurate.
@@ -67,7 +77,7 @@ func NewResultFromBulkResult(result *mongo.BulkWriteResult, err error) Result {
 		nFailure = int64(len(bwe.WriteErrors))
 	}
 
-	return Result{nSuccess, nFailure, err}
+	return Result{Successes: nSuccess, Failures: nFailure, Err: err}
 }
 
 // RestoreIntents iterates through all of the intents stored in the IntentManager, and restores them.
@@ -80,13 +90,15 @@ func (restore *MongoRestore) RestoreIntents() Result {
 		// start a goroutine for each job thread
 		for i := 0; i < restore.OutputOptions.NumParallelCollections; i++ {
 			go func(id int) {
+				defer util.RecoverCrash()
 				var workerResult Result
-				log.Logvf(log.DebugHigh, "starting restore routine with id=%v", id)
+				workerLog := log.WithFields("worker", id)
+				workerLog.Logvf(log.DebugHigh, "starting restore routine with id=%v", id)
 				var ioBuf []byte
 				for {
 					intent := restore.manager.Pop()
 					if intent == nil {
-						log.Logvf(log.DebugHigh, "ending restore routine with id=%v, no more work to do", id)
+						workerLog.Logvf(log.DebugHigh, "ending restore routine with id=%v, no more work to do", id)
 						resultChan <- workerResult // done
 						return
 					}
@@ -96,8 +108,11 @@ func (restore *MongoRestore) RestoreIntents() Result {
 						}
 						fileNeedsIOBuffer.TakeIOBuffer(ioBuf)
 					}
-					result := restore.RestoreIntent(intent)
+					result := restore.restoreIntent(intent, workerLog)
 					result.log(intent.Namespace())
+					if result.Err != nil && restore.deferFailure(intent, result) {
+						result = Result{}
+					}
 					workerResult.combineWith(result)
 					if result.Err != nil {
 						resultChan <- workerResult.withErr(fmt.Errorf("%v: %v", intent.Namespace(), result.Err))
@@ -121,6 +136,7 @@ func (restore *MongoRestore) RestoreIntents() Result {
 				return totalResult
 			}
 		}
+		totalResult.combineWith(restore.retryFailures())
 		return totalResult
 	}
 
@@ -133,32 +149,83 @@ func (restore *MongoRestore) RestoreIntents() Result {
 		}
 		result := restore.RestoreIntent(intent)
 		result.log(intent.Namespace())
+		if result.Err != nil && restore.deferFailure(intent, result) {
+			result = Result{}
+		}
 		totalResult.combineWith(result)
 		if result.Err != nil {
 			return totalResult.withErr(fmt.Errorf("%v: %v", intent.Namespace(), result.Err))
 		}
 		restore.manager.Finish(intent)
 	}
+	totalResult.combineWith(restore.retryFailures())
 	return totalResult
 }
 
 // RestoreIntent attempts to restore a given intent into MongoDB.
 func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
+	return restore.restoreIntent(intent, log.WithFields())
+}
+
+// restoreIntent restores an intent, tagging its log messages with the fields
+// of parentLog and, for JSON log output, the namespace and an operation ID.
+func (restore *MongoRestore) restoreIntent(intent *intents.Intent, parentLog *log.FieldLogger) (result Result) {
+	start := time.Now()
+	ctx, span := tracing.Start(context.Background(), "restore collection", "ns", intent.Namespace())
+	defer func() {
+		span.SetAttributes("documents", result.Successes, "failures", result.Failures)
+		span.End(result.Err)
+		log.Summary().AddNamespace(intent.Namespace(), result.Successes, result.Failures, time.Since(start), result.Err)
+		if result.Skipped > 0 {
+			log.Summary().AddCount("skippedDocuments", result.Skipped)
+		}
+	}()
+
+	intentLog := parentLog
+	if restore.ToolOptions != nil && restore.ToolOptions.LogsJSON() {
+		// text messages already name the namespace
+		intentLog = intentLog.WithFields("ns", intent.Namespace(), "op", log.NewOperationID())
+	}
+	intentLog = intentLog.WithFields(span.LogFields()...)
+	defer restore.progress.finish(intent.Namespace())
+	if restore.compatSkipped[intent.Namespace()] {
+		intentLog.Logvf(log.Always, "skipping %v, which the target doesn't support", intent.Namespace())
+		return Result{}
+	}
 	collectionExists, err := restore.CollectionExists(intent)
 	if err != nil {
 		return Result{Err: fmt.Errorf("error reading database: %v", err)}
 	}
 
 	if !restore.OutputOptions.Drop && collectionExists {
-		log.Logvf(log.Always, "restoring to existing collection %v without dropping", intent.Namespace())
+		intentLog.Logmf(log.Always, msgRestoringExisting, intent.Namespace())
+	}
+
+	var resumed *namespaceState
+	if intent.BSONFile != nil {
+		if resumed = restore.resumeState.started(intent.Namespace()); resumed != nil && resumed.Complete {
+			intentLog.Logvf(log.Always, "%v was already restored, skipping", intent.Namespace())
+			return Result{}
+		}
 	}
 
-	if restore.OutputOptions.Drop {
+	if err = restore.runPreRestoreHook(intent, intentLog); err != nil {
+		return Result{Err: err}
+	}
+	defer func() {
+		if result.Err == nil {
+			result.Err = restore.runPostRestoreHook(intent, result, time.Since(start), intentLog)
+		}
+	}()
+
+	if restore.OutputOptions.Drop && resumed != nil {
+		intentLog.Logvf(log.Always, "not dropping %v, which is being resumed", intent.Namespace())
+	} else if restore.OutputOptions.Drop {
 		if collectionExists {
 			if strings.HasPrefix(intent.C, "system.") {
-				log.Logvf(log.Always, "cannot drop system collection %v, skipping", intent.Namespace())
+				intentLog.Logvf(log.Always, "cannot drop system collection %v, skipping", intent.Namespace())
 			} else {
-				log.Logvf(log.Info, "dropping collection %v before restoring", intent.Namespace())
+				intentLog.Logvf(log.Info, "dropping collection %v before restoring", intent.Namespace())
 				err = restore.DropCollection(intent)
 				if err != nil {
 					return Result{Err: err} // no context needed
@@ -166,19 +233,24 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 				collectionExists = false
 			}
 		} else {
-			log.Logvf(log.DebugLow, "collection %v doesn't exist, skipping drop command", intent.Namespace())
+			intentLog.Logvf(log.DebugLow, "collection %v doesn't exist, skipping drop command", intent.Namespace())
 		}
 	}
 
 	var options bson.D
 	var indexes []IndexDocument
 	var uuid string
+	var sharding *archive.ShardingMetadata
+	var viewDependencies []string
+	// the documents of a time-series collection dumped as raw buckets are
+	// inserted into its system.buckets collection
+	dataCollection := intent.C
 
 	// get indexes from system.indexes dump if we have it but don't have metadata files
 	if intent.MetadataFile == nil {
 		if _, ok := restore.dbCollectionIndexes[intent.DB]; ok {
 			if indexes, ok = restore.dbCollectionIndexes[intent.DB][intent.C]; ok {
-				log.Logvf(log.Always, "no metadata; falling back to system.indexes")
+				intentLog.Logvf(log.Always, "no metadata; falling back to system.indexes")
 			}
 		}
 	}
@@ -194,7 +266,7 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 		}
 		defer intent.MetadataFile.Close()
 
-		log.Logvf(log.Always, "reading metadata for %v from %v", intent.Namespace(), intent.MetadataLocation)
+		intentLog.Logmf(log.Always, msgReadingMetadata, intent.Namespace(), intent.MetadataLocation)
 		metadataJSON, err := ioutil.ReadAll(intent.MetadataFile)
 		if err != nil {
 			return Result{Err: fmt.Errorf("error reading metadata from %v: %v", intent.MetadataLocation, err)}
@@ -204,13 +276,25 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 			return Result{Err: fmt.Errorf("error parsing metadata from %v: %v", intent.MetadataLocation, err)}
 		}
 		if metadata != nil {
+			restore.adaptMetadata(intent.Namespace(), metadata, intentLog)
 			options = metadata.Options
 			indexes = metadata.Indexes
-			if restore.OutputOptions.PreserveUUID {
-				if metadata.UUID == "" {
-					log.Logvf(log.Always, "--preserveUUID used but no UUID found in %v, generating new UUID for %v", intent.MetadataLocation, intent.Namespace())
+			viewDependencies = metadata.ViewDependencies
+			sharding = metadata.Sharding
+			if metadata.TimeseriesBuckets {
+				dataCollection = db.TimeseriesBucketsPrefix + intent.C
+			}
+			if err = restore.checkTimeseriesRestore(intent, metadata); err != nil {
+				return Result{Err: err}
+			}
+			if fields, ok := timeseriesFieldsOf(metadata.Options); ok && !metadata.TimeseriesBuckets {
+				restore.setMeasurementFields(intent.Namespace(), fields)
+			}
+			removeImpliedBucketOptions(options)
+			if restore.setsUUIDs() {
+				if uuid, err = restore.collectionUUID(intent, metadata); err != nil {
+					return Result{Err: err}
 				}
-				uuid = metadata.UUID
 			}
 
 			collation, err := bsonutil.FindSubdocumentByKey("collation", &options)
@@ -222,15 +306,21 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 			}
 		}
 
+		// The clustered index of a clustered collection is created by its
+		// clusteredIndex option, and it must not be given as its idIndex.
+		if _, err := bsonutil.FindValueByKey("clusteredIndex", &options); err == nil {
+			indexes = removeClusteredIndexes(indexes)
+		}
+
 		// The only way to specify options on the idIndex is at collection creation time.
 		// This loop pulls out the idIndex from `indexes` and sets it in `options`.
 		for i, index := range indexes {
 			// The index with the name "_id_" will always be the idIndex.
 			if index.Options["name"].(string) == "_id_" {
 				// Remove the index version (to use the default) unless otherwise specified.
-				// If preserving UUID, we have to create a collection via
+				// If setting the UUID, we have to create a collection via
 				// applyops, which requires the "v" key.
-				if !restore.OutputOptions.KeepIndexVersion && !restore.OutputOptions.PreserveUUID {
+				if !restore.OutputOptions.KeepIndexVersion && uuid == "" {
 					delete(index.Options, "v")
 				}
 				index.Options["ns"] = intent.Namespace()
@@ -249,24 +339,45 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 		}
 
 		if restore.OutputOptions.NoOptionsRestore {
-			log.Logv(log.Info, "not restoring collection options")
+			intentLog.Logv(log.Info, "not restoring collection options")
 			logMessageSuffix = "with no collection options"
 			options = nil
 		}
 	}
+	// the validator of --validatorAction restoreAfterData is applied once
+	// the documents are restored
+	var validator bson.D
 	if !collectionExists {
-		log.Logvf(log.Info, "creating collection %v %s", intent.Namespace(), logMessageSuffix)
-		log.Logvf(log.DebugHigh, "using collection options: %#v", options)
+		options, validator = restore.collectionValidator(intent, options)
+	}
+	if !collectionExists && isViewOptions(options) {
+		// views on views must be created in order, so all of them are created
+		// once the collections have been restored
+		intentLog.Logvf(log.Info, "deferring creation of view %v until the collections are restored", intent.Namespace())
+		restore.deferView(intent, options, viewDependencies)
+	} else if !collectionExists {
+		intentLog.Logvf(log.Info, "creating collection %v %s", intent.Namespace(), logMessageSuffix)
+		intentLog.Logvf(log.DebugHigh, "using collection options: %#v", options)
 		err = restore.CreateCollection(intent, options, uuid)
 		if err != nil {
 			return Result{Err: fmt.Errorf("error creating collection %v: %v", intent.Namespace(), err)}
 		}
+		if uuid != "" {
+			if err = restore.checkCollectionUUID(intent, uuid); err != nil {
+				return Result{Err: err}
+			}
+		}
 		restore.addToKnownCollections(intent)
+		restore.prepareMirrors(intent, options)
+		if sharding = restore.collectionSharding(intent, sharding); sharding != nil && dataCollection == intent.C {
+			if indexes, err = restore.shardCollection(intent, sharding, indexes, hasNonSimpleCollation); err != nil {
+				return Result{Err: err}
+			}
+		}
 	} else {
-		log.Logvf(log.Info, "collection %v already exists - skipping collection create", intent.Namespace())
+		intentLog.Logvf(log.Info, "collection %v already exists - skipping collection create", intent.Namespace())
 	}
 
-	var result Result
 	if intent.BSONFile != nil {
 		err = intent.BSONFile.Open()
 		if err != nil {
@@ -274,36 +385,94 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 		}
 		defer intent.BSONFile.Close()
 
-		log.Logvf(log.Always, "restoring %v from %v", intent.Namespace(), intent.Location)
+		intentLog.Logmf(log.Always, msgRestoringCollection, intent.Namespace(), intent.Location)
 
 		bsonSource := db.NewDecodedBSONSource(db.NewBSONSource(intent.BSONFile))
 		defer bsonSource.Close()
 
-		result = restore.RestoreCollectionToDB(intent.DB, intent.C, bsonSource, intent.BSONFile, intent.Size)
+		ordered := restore.writesFor(intent.DB + "." + dataCollection).ordered
+		if isCappedOptions(options) && !ordered {
+			// the documents of a capped collection are returned in the order
+			// they are inserted, so they are inserted in the order dumped
+			intentLog.Logvf(log.Info, "restoring capped collection %v in insertion order", intent.Namespace())
+			ordered = true
+		}
+		resumed, err = restore.resumeState.track(intent.Namespace(), intent.Location, intent.Size)
+		if err != nil {
+			return Result{Err: err}
+		}
+		segments := []bsonSegment{{source: bsonSource, file: intent.BSONFile}}
+		// the parts of a file are only read in parallel when neither the
+		// order of its documents nor their positions for resuming matter
+		if !ordered && resumed == nil {
+			split, splitFile, err := restore.splitBSONFile(intent)
+			if err != nil {
+				return Result{Err: err}
+			}
+			if split != nil {
+				defer splitFile.Close()
+				segments = split
+			}
+		}
+		result = restore.restoreCollectionToDB(intent.DB, dataCollection, segments, intent.Size, ordered, resumed)
 		if result.Err != nil {
 			result.Err = fmt.Errorf("error restoring from %v: %v", intent.Location, result.Err)
 			return result
 		}
 	}
+	if len(validator) > 0 {
+		if err = restore.applyValidator(intent, validator); err != nil {
+			result.Err = err
+			return result
+		}
+	}
 
 	// finally, add indexes
+	indexes = restore.filterIndexes(intent.Namespace(), indexes)
 	if len(indexes) > 0 && !restore.OutputOptions.NoIndexRestore {
-		log.Logvf(log.Always, "restoring indexes for collection %v from metadata", intent.Namespace())
 		if restore.OutputOptions.ConvertLegacyIndexes {
 			indexes = restore.convertLegacyIndexes(indexes, intent.Namespace())
 		}
 		if restore.OutputOptions.FixDottedHashedIndexes {
 			fixDottedHashedIndexes(indexes)
 		}
-		err = restore.CreateIndexes(intent.DB, intent.C, indexes, hasNonSimpleCollation)
+		build := indexBuild{db: intent.DB, collection: intent.C, indexes: indexes, hasNonSimpleCollation: hasNonSimpleCollation}
+		if restore.indexBuilder != nil {
+			// the collection is only complete once its indexes are built
+			restore.indexBuilder.add(intent.Namespace(), func() error {
+				if err := restore.buildIndexes(build); err != nil {
+					return err
+				}
+				restore.buildMirrorIndexes(build)
+				if resumed != nil {
+					return restore.resumeState.complete(resumed)
+				}
+				return nil
+			})
+			return result
+		}
+		if restore.queuesIndexBuilds() {
+			intentLog.Logvf(log.Info, "deferring the indexes of %v until all collections are restored", intent.Namespace())
+		} else {
+			intentLog.Logmf(log.Always, msgRestoringIndexes, intent.Namespace())
+		}
+		_, indexSpan := tracing.Start(ctx, "build indexes", "ns", intent.Namespace(), "indexes", len(indexes))
+		err = restore.buildIndexes(build)
+		indexSpan.End(err)
 		if err != nil {
 			result.Err = fmt.Errorf("error creating indexes for %v: %v", intent.Namespace(), err)
 			return result
 		}
+		restore.buildMirrorIndexes(build)
 	} else {
-		log.Logv(log.Always, "no indexes to restore")
+		intentLog.Logmf(log.Always, msgNoIndexes)
 	}
 
+	if resumed != nil {
+		if err = restore.resumeState.complete(resumed); err != nil {
+			result.Err = err
+		}
+	}
 	return result
 }
 
@@ -359,18 +528,152 @@ func fixDottedHashedIndex(index IndexDocument) {
 	}
 }
 
+// removeImpliedBucketOptions removes the bucketing parameters from the
+// time-series options of a collection that sets a granularity, which implies
+// them. Servers before 6.3 reject them, even though they list them.
+func removeImpliedBucketOptions(options bson.D) {
+	for i, elem := range options {
+		timeseries, ok := elem.Value.(bson.D)
+		if elem.Key != "timeseries" || !ok {
+			continue
+		}
+		if _, err := bsonutil.FindValueByKey("granularity", &timeseries); err != nil {
+			return
+		}
+		kept := bson.D{}
+		for _, opt := range timeseries {
+			if opt.Key != "bucketMaxSpanSeconds" && opt.Key != "bucketRoundingSeconds" {
+				kept = append(kept, opt)
+			}
+		}
+		options[i].Value = kept
+	}
+}
+
+// removeClusteredIndexes returns the indexes without the clustered index of
+// a clustered collection.
+func removeClusteredIndexes(indexes []IndexDocument) []IndexDocument {
+	kept := make([]IndexDocument, 0, len(indexes))
+	for _, index := range indexes {
+		if clustered, _ := index.Options["clustered"].(bool); !clustered {
+			kept = append(kept, index)
+		}
+	}
+	return kept
+}
+
+// isCappedOptions returns whether collection options are of a capped
+// collection.
+func isCappedOptions(options bson.D) bool {
+	capped, err := bsonutil.FindValueByKey("capped", &options)
+	return err == nil && capped == true
+}
+
 // RestoreCollectionToDB pipes the given BSON data into the database.
 // Returns the number of documents restored and any errors that occurred.
 func (restore *MongoRestore) RestoreCollectionToDB(dbName, colName string,
 	bsonSource *db.DecodedBSONSource, file PosReader, fileSize int64) Result {
+	return restore.restoreCollectionToDB(dbName, colName, []bsonSegment{{source: bsonSource, file: file}}, fileSize,
+		restore.writesFor(dbName+"."+colName).ordered, nil)
+}
+
+// docsBatch is a batch of documents read from a BSON file, starting with
+// the document at start.
+type docsBatch struct {
+	docs  []bson.Raw
+	start int64
+	// size is the bytes taken from the --maxMemory budget for the batch
+	size int64
+}
+
+// readBatches reads the documents of bsonSource, the first of which is the
+// document at position of the BSON file, in batches on batches, skipping
+// those before skip. terminated is called if the restore is terminated.
+func (restore *MongoRestore) readBatches(bsonSource *db.DecodedBSONSource, position, skip int64,
+	pool *sync.Pool, batches chan<- docsBatch, terminated func()) {
+
+	count := 0
+	start := position
+	if skip > start {
+		start = skip
+	}
+	batch := docsBatch{docs: getBatchDocs(pool), start: start}
+
+	for {
+		doc := bsonSource.LoadNext()
+		if doc == nil {
+			break
+		}
+		if position < skip {
+			position++
+			continue
+		}
+
+		if restore.terminate {
+			restore.memory.Release(batch.size)
+			terminated()
+			return
+		}
+
+		if count == restore.OutputOptions.BulkBufferSize {
+			batches <- batch
+			count = 0
+			batch = docsBatch{docs: getBatchDocs(pool), start: position}
+		}
+		// when --maxMemory's budget is spent the batch is sent as it is,
+		// and an empty batch always takes a document so that it progresses
+		if !restore.memory.TryAcquire(int64(len(doc))) {
+			if count > 0 {
+				batch.docs = batch.docs[0:count]
+				batches <- batch
+				count = 0
+				batch = docsBatch{docs: getBatchDocs(pool), start: position}
+			}
+			restore.memory.Grow(int64(len(doc)))
+		}
+		batch.size += int64(len(doc))
+
+		if len(doc) > cap(batch.docs[count]) {
+			batch.docs[count] = make([]byte, len(doc))
+		} else {
+			batch.docs[count] = batch.docs[count][0:len(doc)]
+		}
+
+		copy(batch.docs[count], doc)
+		count++
+		position++
+	}
+
+	if count > 0 {
+		batch.docs = batch.docs[0:count]
+		batches <- batch
+	}
+}
+
+// restoreCollectionToDB is RestoreCollectionToDB, reading each segment of
+// the BSON file on a reader of its own with its own insertion workers, and
+// inserting the documents in order on a single worker if ordered is set.
+// When resuming, the documents progress records as applied are skipped,
+// those that may have been are upserted, and the documents applied are
+// recorded in resumed.
+func (restore *MongoRestore) restoreCollectionToDB(dbName, colName string,
+	segments []bsonSegment, fileSize int64, ordered bool, resumed *namespaceState) Result {
 
 	var termErr error
+	var termMutex sync.Mutex
+	terminated := func() error {
+		termMutex.Lock()
+		defer termMutex.Unlock()
+		return termErr
+	}
 	session, err := restore.SessionProvider.GetSession()
 	if err != nil {
 		return Result{Err: fmt.Errorf("error establishing connection: %v", err)}
 	}
 
 	collection := session.Database(dbName).Collection(colName)
+	writes := restore.writesFor(dbName + "." + colName)
+	measurements := restore.measurementFieldsOf(dbName + "." + colName)
 
 	pool := sync.Pool{
 		New: func() interface{} {
@@ -382,57 +685,50 @@ func (restore *MongoRestore) RestoreCollectionToDB(dbName, colName string,
 		},
 	}
 
-	watchProgressor := progress.NewCounter(fileSize)
+	watchProgressor := restore.progress.counter(dbName+"."+colName, fileSize)
 	if restore.ProgressManager != nil {
 		name := fmt.Sprintf("%v.%v", dbName, colName)
 		restore.ProgressManager.Attach(name, watchProgressor)
 		defer restore.ProgressManager.Detach(name)
 	}
 
-	maxInsertWorkers := restore.OutputOptions.NumInsertionWorkers
-
-	docsBatchChan := make(chan []bson.Raw, insertBufferFactor)
-	resultChan := make(chan Result, maxInsertWorkers)
-
-	// stream documents for this collection on docChan
-	go func() {
+	maxInsertWorkers := restore.OutputOptions.NumInsertionWorkers * len(segments)
+	if ordered {
+		maxInsertWorkers = 1
+	}
 
-		count := 0
-		docsBatch := pool.Get().([]bson.Raw)
+	var skip, upsertBefore, reserve int64
+	if resumed != nil {
+		skip, upsertBefore = resumed.Documents, resumed.Started
+		reserve = int64(restore.OutputOptions.BulkBufferSize * maxInsertWorkers * resumeReserveBatches)
+		if skip > 0 || upsertBefore > skip {
+			log.Logvf(log.Always, "resuming %v.%v after %v %v, upserting up to %v more",
+				dbName, colName, skip, util.Pluralize(int(skip), "document", "documents"), upsertBefore-skip)
+		}
+		if watchProgressor.CountsDocuments() {
+			watchProgressor.Set(skip)
+		}
+	}
 
-		for {
-			doc := bsonSource.LoadNext()
-			if doc == nil {
-				break
-			}
+	docsBatchChan := make(chan docsBatch, insertBufferFactor)
+	resultChan := make(chan Result, maxInsertWorkers)
 
-			if restore.terminate {
+	// stream documents for this collection on docChan, from each segment
+	var readers sync.WaitGroup
+	for _, segment := range segments {
+		readers.Add(1)
+		go func(bsonSource *db.DecodedBSONSource, position int64) {
+			defer readers.Done()
+			restore.readBatches(bsonSource, position, skip, &pool, docsBatchChan, func() {
 				log.Logvf(log.Always, "terminating read on %v.%v", dbName, colName)
+				termMutex.Lock()
 				termErr = util.ErrTerminated
-				close(docsBatchChan)
-				return
-			}
-
-			if count == restore.OutputOptions.BulkBufferSize {
-				docsBatchChan <- docsBatch
-				count = 0
-				docsBatch = pool.Get().([]bson.Raw)
-			}
-
-			if len(doc) > cap(docsBatch[count]) {
-				docsBatch[count] = make([]byte, len(doc))
-			} else {
-				docsBatch[count] = docsBatch[count][0:len(doc)]
-			}
-
-			copy(docsBatch[count], doc)
-			count++
-		}
-
-		if count > 0 {
-			docsBatchChan <- docsBatch[0:count]
-		}
-
+				termMutex.Unlock()
+			})
+		}(segment.source, segment.start)
+	}
+	go func() {
+		readers.Wait()
 		close(docsBatchChan)
 	}()
 
@@ -442,12 +738,41 @@ func (restore *MongoRestore) RestoreCollectionToDB(dbName, colName string,
 		go func() {
 			var result Result
 
-			bulk := db.NewUnorderedBufferedBulkInserter(collection, restore.OutputOptions.BulkBufferSize).
-				SetOrdered(restore.OutputOptions.MaintainInsertionOrder)
-			bulk.SetBypassDocumentValidation(restore.OutputOptions.BypassDocumentValidation)
-			for docsBatch := range docsBatchChan {
+			newBulk := func(collection *mongo.Collection) *db.BufferedBulkInserter {
+				bulk := db.NewUnorderedBufferedBulkInserter(writes.collection(collection), restore.OutputOptions.BulkBufferSize).
+					SetOrdered(ordered)
+				bulk.SetBypassDocumentValidation(writes.bypassDocumentValidation)
+				bulk.SetWriteErrorHandler(restore.writeErrorHandler(dbName + "." + colName))
+				bulk.SetRetries(restore.OutputOptions.MaxWriteRetries, restore.retryBackoff)
+				bulk.SetUpsert(restore.modeUpserts() || (restore.OutputOptions.Mode != modeReplace && upsertBefore > skip))
+				return bulk
+			}
+			bulk := newBulk(collection)
+			// with --writeToShards the documents of a sharded collection
+			// are written to the shards that own them
+			shards, err := restore.newShardWriter(dbName, colName, newBulk)
+			if err != nil {
+				resultChan <- Result{Err: err}
+				return
+			}
+			// the --mirrorUri clusters are written along with the target,
+			// their failures kept apart from its result
+			mirrors := restore.newMirrorWriters(dbName, colName, newBulk)
+			// the replacements of --mode=replace that match no document
+			// are counted as skipped once they have all been written
+			var replaced int64
+			// each worker runs its own --transform commands
+			transformer := restore.newTransformer()
+			defer transformer.close()
+			query := restore.queryFor(dbName + "." + colName)
+			// the budget of the batch being written is given back however
+			// the worker ends
+			var held int64
+			defer func() { restore.memory.Release(held) }()
+			for batch := range docsBatchChan {
+				held = batch.size
 				if restore.objCheck {
-					for _, rawDoc := range docsBatch {
+					for _, rawDoc := range batch.docs {
 						result.Err = bson.Unmarshal(rawDoc, &bson.D{})
 						if result.Err != nil {
 							resultChan <- result
@@ -455,20 +780,94 @@ func (restore *MongoRestore) RestoreCollectionToDB(dbName, colName string,
 						}
 					}
 				}
-				for _, rawDoc := range docsBatch {
-					result.combineWith(NewResultFromBulkResult(bulk.InsertRaw(rawDoc)))
+				size := 0
+				for i, rawDoc := range batch.docs {
+					// dropped documents are left as nil, keeping the
+					// positions of the others in the batch
+					matched, err := query.matches(rawDoc)
+					if err != nil {
+						resultChan <- Result{Err: err}
+						return
+					}
+					if !matched {
+						batch.docs[i] = nil
+						continue
+					}
+					if batch.docs[i], result.Err = transformer.apply(rawDoc); result.Err != nil {
+						resultChan <- result
+						return
+					}
+					size += len(batch.docs[i])
+				}
+				// documents grown by --transform take more of the budget
+				if grown := int64(size) - held; grown > 0 {
+					restore.memory.Grow(grown)
+					held += grown
+				}
+				// positions in the batch are kept when resuming
+				if measurements != nil && !ordered && resumed == nil {
+					sortMeasurements(batch.docs, *measurements)
+				}
+				if result.Err = restore.throttle(len(batch.docs), size); result.Err != nil {
+					resultChan <- result
+					return
+				}
+				end := batch.start + int64(len(batch.docs))
+				if result.Err = restore.resumeState.start(resumed, end, reserve); result.Err != nil {
+					resultChan <- result
+					return
+				}
+				for i, rawDoc := range batch.docs {
+					if rawDoc == nil {
+						result.Skipped++
+						continue
+					}
+					if batch.start+int64(i) < upsertBefore && !restore.modeUpserts() && restore.OutputOptions.Mode != modeReplace {
+						result.combineWith(restore.writeResult(upsertRaw(shards.bulkFor(rawDoc, bulk), rawDoc)))
+					} else {
+						written := restore.writeDocument(shards.bulkFor(rawDoc, bulk), rawDoc)
+						if restore.OutputOptions.Mode == modeReplace && written.Skipped == 0 {
+							replaced++
+						}
+						result.combineWith(written)
+					}
+					mirrors.write(restore, rawDoc)
+					result.Err = db.FilterError(restore.OutputOptions.StopOnError, result.Err)
+					if result.Err != nil {
+						resultChan <- result
+						return
+					}
+				}
+				if resumed != nil {
+					// the batch is only applied once nothing of it is left
+					// buffered
+					result.combineWith(restore.flushBulks(shards, bulk))
 					result.Err = db.FilterError(restore.OutputOptions.StopOnError, result.Err)
 					if result.Err != nil {
 						resultChan <- result
 						return
 					}
+					restore.resumeState.finish(resumed, batch.start, end)
 				}
 
-				pool.Put(docsBatch)
-				watchProgressor.Set(file.Pos())
+				if watchProgressor.CountsDocuments() {
+					watchProgressor.Inc(int64(len(batch.docs)))
+				} else {
+					watchProgressor.Set(segmentsPos(segments))
+				}
+				pool.Put(batch.docs)
+				restore.memory.Release(held)
+				held = 0
 			}
 			// flush the remaining docs
-			result.combineWith(NewResultFromBulkResult(bulk.Flush()))
+			result.combineWith(restore.flushBulks(shards, bulk))
+			mirrors.flush(restore)
+			if err := transformer.close(); err != nil && result.Err == nil {
+				result.Err = err
+			}
+			if replaced > 0 {
+				result.Skipped += replaced - result.Successes - result.Failures
+			}
 			resultChan <- result.withErr(db.FilterError(restore.OutputOptions.StopOnError, result.Err))
 			return
 		}()
@@ -488,13 +887,22 @@ func (restore *MongoRestore) RestoreCollectionToDB(dbName, colName string,
 			restore.terminate = true
 		}
 	}
+	if restore.memory != nil {
+		// batches left unwritten after an error give back their budget, so
+		// that the archive's buffers don't wait on it
+		go func() {
+			for batch := range docsBatchChan {
+				restore.memory.Release(batch.size)
+			}
+		}()
+	}
 
 	if finalErr != nil {
 		totalResult.Err = finalErr
-	} else if err = bsonSource.Err(); err != nil {
+	} else if err = segmentsErr(segments); err != nil {
 		totalResult.Err = fmt.Errorf("reading bson input: %v", err)
-	} else if termErr != nil {
-		totalResult.Err = termErr
+	} else if err = terminated(); err != nil {
+		totalResult.Err = err
 	}
 	return totalResult
 }
@@ -0,0 +1,271 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// resumeStateVersion is the version of the resume state file format.
+const resumeStateVersion = 1
+
+// resumeReserveBatches is how many batches of documents each insertion
+// worker may send past the ones recorded in the resume state file before it
+// is saved again.
+const resumeReserveBatches = 16
+
+// restoreState records how far a restore got, so that an interrupted
+// restore can be continued with --resume.
+type restoreState struct {
+	Version    int                        `json:"version"`
+	Source     string                     `json:"source"`
+	Namespaces map[string]*namespaceState `json:"namespaces"`
+
+	path  string
+	mutex sync.Mutex
+}
+
+// namespaceState is the progress of a single namespace. The first Documents
+// documents of its BSON file were all applied, and no document after the
+// first Started was sent to the server, so on resume the documents in
+// between are upserted in case they were applied too.
+type namespaceState struct {
+	Location  string `json:"location"`
+	Size      int64  `json:"size"`
+	Documents int64  `json:"documents"`
+	Started   int64  `json:"started"`
+	Complete  bool   `json:"complete"`
+
+	// finished holds the end of each batch finished after Documents, by its
+	// start
+	finished map[int64]int64
+}
+
+// resumeSource returns the dump being restored, which identifies the resume
+// state.
+func (restore *MongoRestore) resumeSource() string {
+	source := restore.TargetDirectory
+	if restore.InputOptions.Tar != "" {
+		source = restore.InputOptions.Tar
+	}
+	if storage.IsRemote(source) {
+		return strings.TrimSuffix(source, "/")
+	}
+	return filepath.Clean(source)
+}
+
+// resumeStatePath returns the resume state file location, which defaults
+// to a file next to the dump being restored, or in the current directory
+// for a dump in object storage.
+func (restore *MongoRestore) resumeStatePath() string {
+	switch source := restore.resumeSource(); {
+	case restore.OutputOptions.ResumeFile != "":
+		return restore.OutputOptions.ResumeFile
+	case storage.IsRemote(source):
+		return "mongorestore.restore-state.json"
+	default:
+		return source + ".restore-state.json"
+	}
+}
+
+// validateResumeOptions returns an error if --resume is used with options
+// it can't be.
+func (restore *MongoRestore) validateResumeOptions() error {
+	if !restore.OutputOptions.Resume {
+		if restore.OutputOptions.ResumeFile != "" {
+			return fmt.Errorf("cannot use %v without %v", ResumeFileOption, ResumeOption)
+		}
+		return nil
+	}
+	switch {
+	case restore.InputOptions.Archive != "":
+		return fmt.Errorf("cannot use %v with --archive specified", ResumeOption)
+	case restore.TargetDirectory == "-":
+		return fmt.Errorf("cannot use %v when restoring from stdin", ResumeOption)
+	case restore.OutputOptions.MetadataOnly || restore.OutputOptions.IndexesOnly:
+		return fmt.Errorf("cannot use %v with --metadataOnly or --indexesOnly", ResumeOption)
+	}
+	return nil
+}
+
+// initResumeState loads the state of an interrupted restore of the same
+// dump, or starts a new one.
+func (restore *MongoRestore) initResumeState() error {
+	path := restore.resumeStatePath()
+	state := &restoreState{
+		Version:    resumeStateVersion,
+		Source:     restore.resumeSource(),
+		Namespaces: map[string]*namespaceState{},
+		path:       path,
+	}
+	restore.resumeState = state
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Logvf(log.Always, "recording the progress of the restore in %v", path)
+		return state.save()
+	}
+	if err != nil {
+		return fmt.Errorf("error reading resume state file: %v", err)
+	}
+	saved := &restoreState{}
+	if err = json.Unmarshal(data, saved); err != nil {
+		return fmt.Errorf("error parsing resume state file %v: %v", path, err)
+	}
+	switch {
+	case saved.Version != resumeStateVersion:
+		return fmt.Errorf("resume state file %v has unsupported version %v", path, saved.Version)
+	case saved.Source != state.Source:
+		return fmt.Errorf("resume state file %v is for a restore of '%v', not '%v'", path, saved.Source, state.Source)
+	}
+	if saved.Namespaces != nil {
+		state.Namespaces = saved.Namespaces
+	}
+	log.Logvf(log.Always, "resuming restore from %v", path)
+	return nil
+}
+
+// save atomically replaces the resume state file.
+func (state *restoreState) save() error {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return state.saveLocked()
+}
+
+func (state *restoreState) saveLocked() error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := state.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing resume state file: %v", err)
+	}
+	if err = os.Rename(tmp, state.path); err != nil {
+		return fmt.Errorf("error writing resume state file: %v", err)
+	}
+	return nil
+}
+
+// remove deletes the resume state file once the restore has completed.
+func (state *restoreState) remove() error {
+	err := os.Remove(state.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// started returns the recorded progress of a namespace, or nil if it
+// wasn't restored yet. Its methods do nothing on a nil *restoreState.
+func (state *restoreState) started(namespace string) *namespaceState {
+	if state == nil {
+		return nil
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return state.Namespaces[namespace]
+}
+
+// track returns the progress of a namespace restored from the given file,
+// starting it if it wasn't restored yet. A dump file that changed since
+// it was recorded can't be resumed.
+func (state *restoreState) track(namespace, location string, size int64) (*namespaceState, error) {
+	if state == nil {
+		return nil, nil
+	}
+	state.mutex.Lock()
+	ns, found := state.Namespaces[namespace]
+	if !found {
+		ns = &namespaceState{Location: location, Size: size}
+		state.Namespaces[namespace] = ns
+	}
+	ns.finished = map[int64]int64{}
+	state.mutex.Unlock()
+
+	if !found {
+		return ns, state.save()
+	}
+	if ns.Location != location || ns.Size != size {
+		return nil, fmt.Errorf("cannot resume %v: %v changed since it was partially restored", namespace, location)
+	}
+	return ns, nil
+}
+
+// start records that the documents of a namespace before end are about to
+// be sent, saving the state first when the documents recorded as started
+// don't cover them, with room for reserve more.
+func (state *restoreState) start(ns *namespaceState, end, reserve int64) error {
+	if state == nil {
+		return nil
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if end <= ns.Started {
+		return nil
+	}
+	ns.Started = end + reserve
+	return state.saveLocked()
+}
+
+// finish records that the documents of a namespace from start to end were
+// applied, advancing past every batch finished without a gap before it.
+func (state *restoreState) finish(ns *namespaceState, start, end int64) {
+	if state == nil {
+		return
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	ns.finished[start] = end
+	for {
+		next, ok := ns.finished[ns.Documents]
+		if !ok {
+			return
+		}
+		delete(ns.finished, ns.Documents)
+		ns.Documents = next
+	}
+}
+
+// complete records that a namespace was fully restored, with its indexes.
+func (state *restoreState) complete(ns *namespaceState) error {
+	if state == nil {
+		return nil
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	ns.Complete = true
+	return state.saveLocked()
+}
+
+// upsertRaw adds a replacement of the document with the same _id as doc to
+// bulk, for --mode=upsert and --mode=replace and for the documents of a
+// resumed restore that may already have been applied. It is upserted if
+// bulk upserts. A document without an _id is inserted.
+func upsertRaw(bulk *db.BufferedBulkInserter, doc bson.Raw) (*mongo.BulkWriteResult, error) {
+	id, err := doc.LookupErr("_id")
+	if err != nil {
+		return bulk.InsertRaw(doc)
+	}
+	var replacement bson.D
+	if err = bson.Unmarshal(doc, &replacement); err != nil {
+		return nil, err
+	}
+	return bulk.Replace(bson.D{{Key: "_id", Value: id}}, replacement)
+}
@@ -0,0 +1,122 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestResumeState(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With --resume", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_resume")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		newRestore := func() *MongoRestore {
+			return &MongoRestore{
+				InputOptions:    &InputOptions{},
+				OutputOptions:   &OutputOptions{Resume: true},
+				TargetDirectory: filepath.Join(dir, "dump") + "/",
+			}
+		}
+		restore := newRestore()
+		So(restore.resumeStatePath(), ShouldEqual, filepath.Join(dir, "dump.restore-state.json"))
+		So(restore.initResumeState(), ShouldBeNil)
+		state := restore.resumeState
+		So(state.started("db.c"), ShouldBeNil)
+
+		Convey("only the batches applied without a gap count as restored", func() {
+			ns, err := state.track("db.c", "dump/db/c.bson", 100)
+			So(err, ShouldBeNil)
+			So(state.start(ns, 10, 40), ShouldBeNil)
+			So(ns.Started, ShouldEqual, 50)
+			So(state.start(ns, 20, 40), ShouldBeNil)
+			So(ns.Started, ShouldEqual, 50)
+			state.finish(ns, 10, 20)
+			So(ns.Documents, ShouldEqual, 0)
+			state.finish(ns, 0, 10)
+			So(ns.Documents, ShouldEqual, 20)
+
+			Convey("and are skipped on resume", func() {
+				So(state.start(ns, 60, 40), ShouldBeNil)
+				resumed := newRestore()
+				So(resumed.initResumeState(), ShouldBeNil)
+				saved := resumed.resumeState.started("db.c")
+				So(saved, ShouldNotBeNil)
+				So(saved.Documents, ShouldEqual, 20)
+				So(saved.Started, ShouldEqual, 100)
+				So(saved.Complete, ShouldBeFalse)
+
+				_, err = resumed.resumeState.track("db.c", "dump/db/c.bson", 200)
+				So(err, ShouldNotBeNil)
+				tracked, err := resumed.resumeState.track("db.c", "dump/db/c.bson", 100)
+				So(err, ShouldBeNil)
+				So(resumed.resumeState.complete(tracked), ShouldBeNil)
+
+				again := newRestore()
+				So(again.initResumeState(), ShouldBeNil)
+				So(again.resumeState.started("db.c").Complete, ShouldBeTrue)
+				So(again.resumeState.remove(), ShouldBeNil)
+				_, err = os.Stat(again.resumeStatePath())
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+
+		Convey("the state of another dump is not resumed", func() {
+			other := newRestore()
+			other.TargetDirectory = filepath.Join(dir, "other")
+			other.OutputOptions.ResumeFile = restore.resumeStatePath()
+			So(other.initResumeState(), ShouldNotBeNil)
+		})
+	})
+
+	Convey("A nil state records nothing", t, func() {
+		var state *restoreState
+		ns, err := state.track("db.c", "c.bson", 1)
+		So(err, ShouldBeNil)
+		So(ns, ShouldBeNil)
+		So(state.start(ns, 10, 10), ShouldBeNil)
+		state.finish(ns, 0, 10)
+		So(state.complete(ns), ShouldBeNil)
+	})
+}
+
+func TestValidateResumeOptions(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("--resume can only be used to restore a directory", t, func() {
+		restore := &MongoRestore{
+			InputOptions:  &InputOptions{},
+			OutputOptions: &OutputOptions{Resume: true},
+		}
+		So(restore.validateResumeOptions(), ShouldBeNil)
+
+		restore.InputOptions.Archive = "dump.archive"
+		So(restore.validateResumeOptions(), ShouldNotBeNil)
+		restore.InputOptions.Archive = ""
+
+		restore.TargetDirectory = "-"
+		So(restore.validateResumeOptions(), ShouldNotBeNil)
+		restore.TargetDirectory = ""
+
+		restore.OutputOptions.IndexesOnly = true
+		So(restore.validateResumeOptions(), ShouldNotBeNil)
+		restore.OutputOptions.IndexesOnly = false
+
+		restore.OutputOptions.Resume = false
+		restore.OutputOptions.ResumeFile = "state.json"
+		So(restore.validateResumeOptions(), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,45 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// defaultRetryBackoff is the --retryBackoff of options that don't set one.
+const defaultRetryBackoff = 500 * time.Millisecond
+
+// initWriteRetries parses the --maxWriteRetries and --retryBackoff the bulk
+// writes of documents are retried with after transient errors, such as a
+// primary stepping down. The driver's retryable writes, which retry each
+// write once, are used unless they are turned off.
+func (restore *MongoRestore) initWriteRetries() error {
+	options := restore.OutputOptions
+	if options.MaxWriteRetries < 0 {
+		return fmt.Errorf("cannot specify a negative number of write retries")
+	}
+	if restore.ToolOptions != nil && restore.ToolOptions.RetryWrites != nil && !*restore.ToolOptions.RetryWrites {
+		log.Logvf(log.Always, "warning: retryable writes are turned off; "+
+			"writes interrupted by a transient error are only retried by %v", MaxWriteRetriesOption)
+	}
+	restore.retryBackoff = defaultRetryBackoff
+	if options.RetryBackoff == "" {
+		return nil
+	}
+	backoff, err := time.ParseDuration(options.RetryBackoff)
+	if err == nil && backoff <= 0 {
+		err = fmt.Errorf("duration must be positive")
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing %v: %v", RetryBackoffOption, err)
+	}
+	restore.retryBackoff = backoff
+	return nil
+}
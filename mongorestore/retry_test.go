@@ -0,0 +1,62 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestInitWriteRetries(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("--retryBackoff is parsed as a positive duration", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{MaxWriteRetries: 3, RetryBackoff: "2s"}}
+		So(restore.initWriteRetries(), ShouldBeNil)
+		So(restore.retryBackoff, ShouldEqual, 2*time.Second)
+
+		restore.OutputOptions.RetryBackoff = ""
+		So(restore.initWriteRetries(), ShouldBeNil)
+		So(restore.retryBackoff, ShouldEqual, defaultRetryBackoff)
+
+		for _, backoff := range []string{"0s", "-1s", "soon"} {
+			restore.OutputOptions.RetryBackoff = backoff
+			So(restore.initWriteRetries(), ShouldNotBeNil)
+		}
+	})
+
+	Convey("A negative --maxWriteRetries is an error", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{MaxWriteRetries: -1}}
+		So(restore.initWriteRetries(), ShouldNotBeNil)
+	})
+}
+
+func TestIsTransientError(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Network errors and primary step downs are transient", t, func() {
+		So(db.IsTransientError(mongo.CommandError{Labels: []string{"NetworkError"}}), ShouldBeTrue)
+		So(db.IsTransientError(mongo.CommandError{Code: 189}), ShouldBeTrue)
+		So(db.IsTransientError(mongo.BulkWriteException{WriteConcernError: &mongo.WriteConcernError{Code: 91}}), ShouldBeTrue)
+		So(db.IsTransientError(mongo.BulkWriteException{Labels: []string{"RetryableWriteError"}}), ShouldBeTrue)
+		So(db.IsTransientError(errors.New("server selection error: context deadline exceeded")), ShouldBeTrue)
+	})
+
+	Convey("Write errors of documents are not", t, func() {
+		So(db.IsTransientError(nil), ShouldBeFalse)
+		So(db.IsTransientError(mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+			failedWrite(mongo.NewInsertOneModel(), db.ErrDuplicateKeyCode),
+		}}), ShouldBeFalse)
+		So(db.IsTransientError(mongo.CommandError{Code: 13}), ShouldBeFalse)
+	})
+}
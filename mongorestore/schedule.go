@@ -0,0 +1,128 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// The orders of --schedule.
+const (
+	scheduleDatabase  = "database"
+	scheduleDiscovery = "discovery"
+	scheduleSize      = "size"
+)
+
+// indexBuilder builds the indexes of the collections restored with
+// --interleaveIndexBuilds in the background, while the workers restore the
+// documents of other collections.
+type indexBuilder struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+
+	mutex sync.Mutex
+	err   error
+}
+
+// initSchedule validates --schedule and --interleaveIndexBuilds.
+func (restore *MongoRestore) initSchedule() error {
+	options := restore.OutputOptions
+	switch {
+	case options.Schedule != "" && restore.InputOptions.Archive != "":
+		return fmt.Errorf("cannot use %v with --archive, whose namespaces are restored in the order they are archived",
+			ScheduleOption)
+	case !options.InterleaveIndexBuilds:
+		return nil
+	case options.NoIndexRestore:
+		return fmt.Errorf("cannot use %v with --noIndexRestore", InterleaveIndexBuildsOption)
+	case options.DeferIndexBuilds:
+		return fmt.Errorf("cannot use %v with %v", InterleaveIndexBuildsOption, DeferIndexBuildsOption)
+	case options.IndexDefinitionsFile != "":
+		return fmt.Errorf("cannot use %v with %v", InterleaveIndexBuildsOption, IndexDefinitionsFileOption)
+	}
+	restore.indexBuilder = newIndexBuilder(options.NumParallelCollections)
+	return nil
+}
+
+// finalizeIntents sets the order the intents are restored in: that of the
+// archive, or of --schedule.
+func (restore *MongoRestore) finalizeIntents() {
+	if restore.InputOptions.Archive != "" {
+		restore.manager.UsePrioritizer(restore.archive.Demux.NewPrioritizer(restore.manager))
+		return
+	}
+	restore.manager.Finalize(restore.schedulePriority())
+}
+
+// schedulePriority returns the prioritizer of --schedule. By default, the
+// largest collections are restored first, spread across databases, unless
+// a single collection is restored at a time.
+func (restore *MongoRestore) schedulePriority() intents.PriorityType {
+	switch restore.OutputOptions.Schedule {
+	case scheduleSize:
+		// each worker takes the largest collection left once it is done
+		// with one, so that no worker is left with a large collection
+		// after the others are done
+		log.Logv(log.DebugLow, "restoring the largest collections first")
+		return intents.LongestTaskFirst
+	case scheduleDiscovery:
+		return intents.Legacy
+	case scheduleDatabase:
+		return intents.MultiDatabaseLTF
+	}
+	if restore.OutputOptions.NumParallelCollections > 1 {
+		return intents.MultiDatabaseLTF
+	}
+	// use legacy restoration order if we are single-threaded
+	return intents.Legacy
+}
+
+func newIndexBuilder(workers int) *indexBuilder {
+	if workers < 1 {
+		workers = 1
+	}
+	return &indexBuilder{slots: make(chan struct{}, workers)}
+}
+
+// add builds the indexes of a namespace with build once one of the
+// builders is free, without waiting for it.
+func (builder *indexBuilder) add(namespace string, build func() error) {
+	builder.wg.Add(1)
+	go func() {
+		defer util.RecoverCrash()
+		defer builder.wg.Done()
+		builder.slots <- struct{}{}
+		defer func() { <-builder.slots }()
+
+		log.Logmf(log.Always, msgRestoringIndexes, namespace)
+		if err := build(); err != nil {
+			log.Logvf(log.Always, "error creating indexes for %v: %v", namespace, err)
+			builder.mutex.Lock()
+			defer builder.mutex.Unlock()
+			if builder.err == nil {
+				builder.err = fmt.Errorf("error creating indexes for %v: %v", namespace, err)
+			}
+		}
+	}()
+}
+
+// finish waits for the index builds added, returning the error of the
+// first that failed.
+func (builder *indexBuilder) finish() error {
+	if builder == nil {
+		return nil
+	}
+	builder.wg.Wait()
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	return builder.err
+}
@@ -0,0 +1,123 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInitSchedule(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	newRestore := func(archive string, output OutputOptions) *MongoRestore {
+		output.NumParallelCollections = 2
+		return &MongoRestore{InputOptions: &InputOptions{Archive: archive}, OutputOptions: &output}
+	}
+
+	Convey("Any schedule can be used with a dump directory", t, func() {
+		for _, schedule := range []string{"", scheduleDatabase, scheduleDiscovery, scheduleSize} {
+			So(newRestore("", OutputOptions{Schedule: schedule}).initSchedule(), ShouldBeNil)
+		}
+		So(newRestore("dump.archive", OutputOptions{}).initSchedule(), ShouldBeNil)
+		So(newRestore("dump.archive", OutputOptions{Schedule: scheduleSize}).initSchedule(), ShouldNotBeNil)
+	})
+
+	Convey("Index builds can't be interleaved when they are deferred or not built", t, func() {
+		restore := newRestore("", OutputOptions{InterleaveIndexBuilds: true})
+		So(restore.initSchedule(), ShouldBeNil)
+		So(restore.indexBuilder, ShouldNotBeNil)
+		So(cap(restore.indexBuilder.slots), ShouldEqual, 2)
+
+		So(newRestore("", OutputOptions{InterleaveIndexBuilds: true, DeferIndexBuilds: true}).initSchedule(), ShouldNotBeNil)
+		So(newRestore("", OutputOptions{InterleaveIndexBuilds: true, NoIndexRestore: true}).initSchedule(), ShouldNotBeNil)
+		So(newRestore("", OutputOptions{InterleaveIndexBuilds: true, IndexDefinitionsFile: "indexes.json"}).initSchedule(),
+			ShouldNotBeNil)
+	})
+}
+
+func TestSchedulePriority(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	priority := func(schedule string, parallel int) intents.PriorityType {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{Schedule: schedule, NumParallelCollections: parallel}}
+		return restore.schedulePriority()
+	}
+
+	Convey("By default collections are spread across databases unless one is restored at a time", t, func() {
+		So(priority("", 4), ShouldEqual, intents.MultiDatabaseLTF)
+		So(priority("", 1), ShouldEqual, intents.Legacy)
+		So(priority(scheduleSize, 1), ShouldEqual, intents.LongestTaskFirst)
+		So(priority(scheduleDiscovery, 4), ShouldEqual, intents.Legacy)
+		So(priority(scheduleDatabase, 1), ShouldEqual, intents.MultiDatabaseLTF)
+	})
+
+	Convey("With --schedule=size the largest collections are restored first", t, func() {
+		manager := intents.NewIntentManager()
+		manager.Put(&intents.Intent{DB: "a", C: "small", Size: 10, Location: "a/small.bson"})
+		manager.Put(&intents.Intent{DB: "b", C: "large", Size: 1000, Location: "b/large.bson"})
+		manager.Put(&intents.Intent{DB: "a", C: "medium", Size: 100, Location: "a/medium.bson"})
+		manager.Finalize(priority(scheduleSize, 4))
+
+		var order []string
+		for intent := manager.Pop(); intent != nil; intent = manager.Pop() {
+			order = append(order, intent.Namespace())
+		}
+		So(order, ShouldResemble, []string{"b.large", "a.medium", "a.small"})
+		// the intents are still known once they are restored
+		So(manager.Intents(), ShouldHaveLength, 3)
+	})
+}
+
+func TestIndexBuilder(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("No more builds than the builders run at once", t, func() {
+		builder := newIndexBuilder(2)
+		var running, most, built int32
+		for i := 0; i < 6; i++ {
+			builder.add(fmt.Sprintf("test.c%v", i), func() error {
+				now := atomic.AddInt32(&running, 1)
+				for {
+					seen := atomic.LoadInt32(&most)
+					if now <= seen || atomic.CompareAndSwapInt32(&most, seen, now) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				atomic.AddInt32(&built, 1)
+				return nil
+			})
+		}
+		So(builder.finish(), ShouldBeNil)
+		So(built, ShouldEqual, 6)
+		So(most, ShouldBeLessThanOrEqualTo, 2)
+	})
+
+	Convey("The first failed build is returned once all are done", t, func() {
+		builder := newIndexBuilder(1)
+		var built int32
+		builder.add("test.a", func() error { return fmt.Errorf("index too large") })
+		builder.add("test.b", func() error { atomic.AddInt32(&built, 1); return nil })
+		err := builder.finish()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "index too large")
+		So(built, ShouldEqual, 1)
+	})
+
+	Convey("Without --interleaveIndexBuilds there is nothing to wait for", t, func() {
+		var builder *indexBuilder
+		So(builder.finish(), ShouldBeNil)
+	})
+}
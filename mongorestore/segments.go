@@ -0,0 +1,166 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
+)
+
+const (
+	// minSegmentSize is the smallest part of a BSON file read by a reader of
+	// its own with --numReadersPerCollection
+	minSegmentSize = 64 * 1024 * 1024
+	// segmentScanWindow is the size of the reads the document boundaries of
+	// a BSON file are scanned with
+	segmentScanWindow = 1024 * 1024
+)
+
+// bsonSegment is a part of the BSON file of a collection that is read on a
+// reader of its own, whose first document is the document at start.
+type bsonSegment struct {
+	source *db.DecodedBSONSource
+	file   PosReader
+	start  int64
+}
+
+// segmentBounds are the byte range of a segment of a BSON file, and the
+// position of its first document in the file.
+type segmentBounds struct {
+	offset int64
+	length int64
+	start  int64
+}
+
+// segmentCount returns the number of segments a BSON file of size bytes is
+// split into for readers, each of which reads at least minSegmentSize.
+func segmentCount(size int64, readers int) int {
+	if max := size / minSegmentSize; int64(readers) > max {
+		readers = int(max)
+	}
+	if readers < 1 {
+		return 1
+	}
+	return readers
+}
+
+// splitBSONFile splits the BSON file of intent into the segments of
+// --numReadersPerCollection, returning the file they are read from, which
+// must be closed once they are. Only uncompressed, unencrypted local files
+// are split; nil is returned for the others, which are read whole.
+func (restore *MongoRestore) splitBSONFile(intent *intents.Intent) ([]bsonSegment, io.Closer, error) {
+	readers := restore.OutputOptions.NumReadersPerCollection
+	bsonFile, ok := intent.BSONFile.(*realBSONFile)
+	if readers <= 1 || !ok {
+		return nil, nil, nil
+	}
+	if bsonFile.key != nil || !bsonFile.codec.IsNone() || storage.IsRemote(bsonFile.path) {
+		log.Logvf(log.DebugLow, "reading %v with one reader, since it isn't an uncompressed local file", bsonFile.path)
+		return nil, nil, nil
+	}
+	file, err := os.Open(bsonFile.path)
+	if os.IsNotExist(err) {
+		// files split by mongodump --maxFileSize are read whole
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading BSON file %v: %v", bsonFile.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("error reading BSON file %v: %v", bsonFile.path, err)
+	}
+	count := segmentCount(info.Size(), readers)
+	if count == 1 {
+		file.Close()
+		return nil, nil, nil
+	}
+	bounds, err := scanSegments(file, info.Size(), count)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("error splitting BSON file %v: %v", bsonFile.path, err)
+	}
+	log.Logvf(log.Info, "reading %v in %v segments", bsonFile.path, len(bounds))
+
+	segments := make([]bsonSegment, len(bounds))
+	for i, bound := range bounds {
+		section := bufio.NewReader(io.NewSectionReader(file, bound.offset, bound.length))
+		in := &posTrackingReader{0, ioutil.NopCloser(section)}
+		segments[i] = bsonSegment{
+			source: db.NewDecodedBSONSource(db.NewBSONSource(in)),
+			file:   in,
+			start:  bound.start,
+		}
+	}
+	return segments, file, nil
+}
+
+// scanSegments walks the document headers of a BSON file of size bytes,
+// splitting it at the first document boundaries past each of count equal
+// parts. Fewer segments are returned if the file holds too few documents.
+func scanSegments(file io.ReaderAt, size int64, count int) ([]segmentBounds, error) {
+	var bounds []segmentBounds
+	current := segmentBounds{}
+	window := make([]byte, segmentScanWindow)
+	// the window holds the file from windowOffset to windowEnd
+	var windowOffset, windowEnd int64
+	var offset, docs int64
+	for offset < size {
+		if offset+4 > windowEnd {
+			n, err := file.ReadAt(window, offset)
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			windowOffset, windowEnd = offset, offset+int64(n)
+			if offset+4 > windowEnd {
+				return nil, fmt.Errorf("truncated document at offset %v", offset)
+			}
+		}
+		length := int64(int32(binary.LittleEndian.Uint32(window[offset-windowOffset:])))
+		if length < 5 || offset+length > size {
+			return nil, fmt.Errorf("invalid document length %v at offset %v", length, offset)
+		}
+		offset += length
+		docs++
+		if target := size * int64(len(bounds)+1) / int64(count); offset >= target && offset < size && len(bounds) < count-1 {
+			current.length = offset - current.offset
+			bounds = append(bounds, current)
+			current = segmentBounds{offset: offset, start: docs}
+		}
+	}
+	current.length = offset - current.offset
+	return append(bounds, current), nil
+}
+
+// segmentsPos returns the number of bytes read of all segments.
+func segmentsPos(segments []bsonSegment) int64 {
+	var pos int64
+	for _, segment := range segments {
+		pos += segment.file.Pos()
+	}
+	return pos
+}
+
+// segmentsErr returns the first error reading the segments.
+func segmentsErr(segments []bsonSegment) error {
+	for _, segment := range segments {
+		if err := segment.source.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSegmentCount(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Each reader reads at least the minimum segment size", t, func() {
+		So(segmentCount(10*minSegmentSize, 4), ShouldEqual, 4)
+		So(segmentCount(3*minSegmentSize, 4), ShouldEqual, 3)
+		So(segmentCount(minSegmentSize/2, 4), ShouldEqual, 1)
+		So(segmentCount(10*minSegmentSize, 1), ShouldEqual, 1)
+	})
+}
+
+func TestScanSegments(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	var file []byte
+	for i := 0; i < 100; i++ {
+		file = append(file, mustMarshal(bson.D{{Key: "_id", Value: i}})...)
+	}
+	docSize := int64(len(file) / 100)
+
+	Convey("A BSON file is split at the document boundaries past each part", t, func() {
+		bounds, err := scanSegments(bytes.NewReader(file), int64(len(file)), 4)
+		So(err, ShouldBeNil)
+		So(bounds, ShouldHaveLength, 4)
+		var next int64
+		for _, bound := range bounds {
+			So(bound.offset, ShouldEqual, next)
+			So(bound.offset%docSize, ShouldEqual, 0)
+			So(bound.start, ShouldEqual, bound.offset/docSize)
+			next = bound.offset + bound.length
+		}
+		So(next, ShouldEqual, len(file))
+	})
+
+	Convey("A file with fewer documents than parts is split into fewer segments", t, func() {
+		two := file[:2*docSize]
+		bounds, err := scanSegments(bytes.NewReader(two), int64(len(two)), 4)
+		So(err, ShouldBeNil)
+		So(bounds, ShouldResemble, []segmentBounds{
+			{offset: 0, length: docSize, start: 0},
+			{offset: docSize, length: docSize, start: 1},
+		})
+	})
+
+	Convey("A corrupt file is an error", t, func() {
+		truncated := file[:len(file)-1]
+		_, err := scanSegments(bytes.NewReader(truncated), int64(len(truncated)), 4)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "invalid document length")
+	})
+}
+
+func TestReadSegments(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	var file []byte
+	for i := 0; i < 10; i++ {
+		file = append(file, mustMarshal(bson.D{{Key: "_id", Value: i}})...)
+	}
+	restore := &MongoRestore{OutputOptions: &OutputOptions{BulkBufferSize: 3}}
+	pool := sync.Pool{New: func() interface{} { return make([]bson.Raw, 3) }}
+
+	Convey("The batches of each segment start at the positions of their documents", t, func() {
+		bounds, err := scanSegments(bytes.NewReader(file), int64(len(file)), 2)
+		So(err, ShouldBeNil)
+		So(bounds, ShouldHaveLength, 2)
+
+		batches := make(chan docsBatch, 10)
+		for _, bound := range bounds {
+			source := db.NewDecodedBSONSource(db.NewBSONSource(ioutil.NopCloser(
+				bytes.NewReader(file[bound.offset : bound.offset+bound.length]))))
+			restore.readBatches(source, bound.start, 0, &pool, batches, func() {})
+		}
+		close(batches)
+		for batch := range batches {
+			for i, doc := range batch.docs {
+				So(doc.Lookup("_id").Int32(), ShouldEqual, batch.start+int64(i))
+			}
+		}
+	})
+
+	Convey("Only uncompressed local files are split", t, func() {
+		dir, err := ioutil.TempDir("", "segments")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "c.bson")
+		So(ioutil.WriteFile(path, file, 0644), ShouldBeNil)
+
+		intent := &intents.Intent{DB: "test", C: "c"}
+		intent.BSONFile = &realBSONFile{path: path, intent: intent}
+		restore.OutputOptions.NumReadersPerCollection = 4
+		segments, closer, err := restore.splitBSONFile(intent)
+		So(err, ShouldBeNil)
+		So(segments, ShouldBeNil)
+		So(closer, ShouldBeNil)
+
+		intent.BSONFile = &realBSONFile{path: path, intent: intent, key: []byte("key")}
+		segments, _, err = restore.splitBSONFile(intent)
+		So(err, ShouldBeNil)
+		So(segments, ShouldBeNil)
+	})
+}
@@ -0,0 +1,625 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopt "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type configShard struct {
+	ID   string   `bson:"_id"`
+	Host string   `bson:"host"`
+	Tags []string `bson:"tags"`
+}
+
+type configChunk struct {
+	Min   bson.Raw `bson:"min"`
+	Max   bson.Raw `bson:"max"`
+	Shard string   `bson:"shard"`
+}
+
+// shardRoutes maps ranges of shard key values of a collection to the
+// shards owning them, as its chunks did once it was distributed.
+type shardRoutes struct {
+	fields []string
+	// mins are the lower bounds of the chunks, sorted, and shards the
+	// shards owning them
+	mins   []bson.Raw
+	shards []string
+}
+
+// initSharding checks the --shardCollections and --writeToShards options,
+// and connects to the shards for --writeToShards.
+func (restore *MongoRestore) initSharding() error {
+	outputOptions := restore.OutputOptions
+	if outputOptions.WriteToShards && !outputOptions.ShardCollections {
+		return fmt.Errorf("%v requires %v", WriteToShardsOption, ShardCollectionsOption)
+	}
+	if !outputOptions.ShardCollections {
+		return nil
+	}
+	if !restore.isMongos {
+		return fmt.Errorf("%v requires restoring through mongos", ShardCollectionsOption)
+	}
+	if !outputOptions.WriteToShards {
+		return nil
+	}
+	if outputOptions.MaintainInsertionOrder {
+		return fmt.Errorf("cannot use %v with --maintainInsertionOrder, as each shard is written separately", WriteToShardsOption)
+	}
+
+	shards, err := restore.readShards()
+	if err != nil {
+		return err
+	}
+	restore.shardRoutes = map[string]*shardRoutes{}
+	restore.shardSessions = map[string]*db.SessionProvider{}
+	for _, shard := range shards {
+		log.Logvf(log.Info, "connecting to shard %v at %v", shard.ID, shard.Host)
+		session, err := db.NewSessionProvider(*restore.ToolOptions.ShardOptions(shard.Host))
+		if err != nil {
+			return fmt.Errorf("error connecting to shard %v: %v", shard.ID, err)
+		}
+		restore.shardSessions[shard.ID] = session
+	}
+	return nil
+}
+
+// closeShards closes the connections to the shards of --writeToShards.
+func (restore *MongoRestore) closeShards() {
+	for _, session := range restore.shardSessions {
+		session.Close()
+	}
+}
+
+// collectionSharding returns how the collection of intent was sharded, as
+// recorded in its metadata or, for archives of a cluster dumped with
+// --coordinateShards, in the archive's header. It returns nil for
+// collections that weren't sharded or when --shardCollections isn't given.
+func (restore *MongoRestore) collectionSharding(intent *intents.Intent, recorded *archive.ShardingMetadata) *archive.ShardingMetadata {
+	if !restore.OutputOptions.ShardCollections {
+		return nil
+	}
+	if recorded != nil {
+		return recorded
+	}
+	if restore.archive == nil || restore.archive.Prelude == nil || restore.archive.Prelude.Header.Sharded == nil {
+		return nil
+	}
+	for _, coll := range restore.archive.Prelude.Header.Sharded.Collections {
+		if coll.Namespace == intent.Namespace() {
+			return &archive.ShardingMetadata{Key: coll.Key, Unique: coll.Unique}
+		}
+	}
+	return nil
+}
+
+// isHashedKey returns whether a shard key has a hashed field, whose chunks
+// are split on hashes rather than on the values of documents.
+func isHashedKey(key bson.D) bool {
+	for _, field := range key {
+		if field.Value == "hashed" {
+			return true
+		}
+	}
+	return false
+}
+
+// shardCollection shards the empty collection of intent with its shard key,
+// splits it at the split points it had in the dump, restores its zone
+// ranges and moves its chunks across the shards, so that its documents are
+// inserted where they belong rather than moved by the balancer afterwards.
+// A collection with a hashed shard key is created with as many chunks as it
+// had, which the server distributes.
+//
+// The index of indexes that supports the shard key is built first, so that
+// it keeps its name and options, and the other indexes are returned.
+func (restore *MongoRestore) shardCollection(intent *intents.Intent, sharding *archive.ShardingMetadata, indexes []IndexDocument, hasNonSimpleCollation bool) ([]IndexDocument, error) {
+	ns := intent.Namespace()
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return nil, fmt.Errorf("error establishing connection: %v", err)
+	}
+
+	if !restore.OutputOptions.NoIndexRestore {
+		for i, index := range indexes {
+			if !bsonutil.IsIndexKeysEqual(index.Key, sharding.Key) || len(restore.filterIndexes(ns, indexes[i:i+1])) == 0 {
+				continue
+			}
+			if err = restore.CreateIndexes(intent.DB, intent.C, []IndexDocument{index}, hasNonSimpleCollation); err != nil {
+				return nil, fmt.Errorf("error creating the shard key index of %v: %v", ns, err)
+			}
+			log.Summary().AddCount("indexes", 1)
+			indexes = append(indexes[:i:i], indexes[i+1:]...)
+			break
+		}
+	}
+	return indexes, restore.distributeCollection(session, intent, sharding)
+}
+
+// distributeCollection shards, splits and distributes the collection of
+// intent for shardCollection.
+func (restore *MongoRestore) distributeCollection(session *mongo.Client, intent *intents.Intent, sharding *archive.ShardingMetadata) error {
+	ns := intent.Namespace()
+
+	// databases are enabled for sharding implicitly as of 6.0, and before
+	// that enabling one again fails with AlreadyInitialized
+	err := runAdminCommand(session, bson.D{{"enableSharding", intent.DB}})
+	if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Code == 23 {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("error enabling sharding on %v: %v", intent.DB, err)
+	}
+
+	hashed := isHashedKey(sharding.Key)
+	command := bson.D{{"shardCollection", ns}, {"key", sharding.Key}}
+	if sharding.Unique {
+		command = append(command, bson.E{"unique", true})
+	}
+	if hashed && len(sharding.SplitPoints) > 0 {
+		command = append(command, bson.E{"numInitialChunks", len(sharding.SplitPoints) + 1})
+	}
+	log.Logvf(log.Always, "sharding %v with key %v", ns, sharding.Key)
+	if err = runAdminCommand(session, command); err != nil {
+		return fmt.Errorf("error sharding %v: %v", ns, err)
+	}
+
+	if !hashed && len(sharding.SplitPoints) > 0 {
+		log.Logvf(log.Always, "splitting %v into %v chunks", ns, len(sharding.SplitPoints)+1)
+		for _, point := range sharding.SplitPoints {
+			if err = runAdminCommand(session, bson.D{{"split", ns}, {"middle", point}}); err != nil {
+				return fmt.Errorf("error splitting %v at %v: %v", ns, point, err)
+			}
+		}
+	}
+	for _, zone := range sharding.Zones {
+		err = runAdminCommand(session, bson.D{{"updateZoneKeyRange", ns}, {"min", zone.Min}, {"max", zone.Max}, {"zone", zone.Zone}})
+		if err != nil {
+			log.Logvf(log.Always, "warning: could not restore the range of zone %v of %v, which may have no shards in the target; assign it with sh.addShardToZone() and sh.updateZoneKeyRange(): %v", zone.Zone, ns, err)
+		}
+	}
+	if hashed {
+		return nil
+	}
+
+	shards, err := restore.readShards()
+	if err != nil {
+		return err
+	}
+	chunks, err := readChunks(session, ns)
+	if err != nil {
+		return err
+	}
+	targets, err := chunkShards(chunks, shards, sharding.Zones)
+	if err != nil {
+		return err
+	}
+	moved := 0
+	for i, chunk := range chunks {
+		if chunk.Shard == targets[i] {
+			continue
+		}
+		err = runAdminCommand(session, bson.D{{"moveChunk", ns}, {"bounds", bson.A{chunk.Min, chunk.Max}}, {"to", targets[i]}})
+		if err != nil {
+			return fmt.Errorf("error moving a chunk of %v to shard %v: %v", ns, targets[i], err)
+		}
+		chunks[i].Shard = targets[i]
+		moved++
+	}
+	if moved > 0 {
+		log.Logvf(log.Always, "moved %v %v of %v across %v %v", moved, util.Pluralize(moved, "chunk", "chunks"),
+			ns, len(shards), util.Pluralize(len(shards), "shard", "shards"))
+	}
+
+	if restore.shardRoutes != nil {
+		routes := newShardRoutes(sharding.Key, chunks)
+		restore.shardRoutesMutex.Lock()
+		restore.shardRoutes[ns] = routes
+		restore.shardRoutesMutex.Unlock()
+	}
+	return nil
+}
+
+// runAdminCommand runs a command on the admin database.
+func runAdminCommand(session *mongo.Client, command bson.D) error {
+	return session.Database("admin").RunCommand(context.Background(), command).Err()
+}
+
+// readShards reads the shards of the target cluster, sorted by ID.
+func (restore *MongoRestore) readShards() ([]configShard, error) {
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return nil, fmt.Errorf("error establishing connection: %v", err)
+	}
+	ctx := context.Background()
+	var shards []configShard
+	cursor, err := session.Database("config").Collection("shards").Find(ctx, bson.D{}, mopt.Find().SetSort(bson.D{{"_id", 1}}))
+	if err == nil {
+		err = cursor.All(ctx, &shards)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading config.shards: %v", err)
+	}
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("the target cluster has no shards")
+	}
+	return shards, nil
+}
+
+// readChunks reads the chunks of a sharded collection, in shard key order.
+// As of 5.0 chunks are found by the UUID of their collection.
+func readChunks(session *mongo.Client, ns string) ([]configChunk, error) {
+	ctx := context.Background()
+	config := session.Database("config")
+	var coll struct {
+		UUID *primitive.Binary `bson:"uuid"`
+	}
+	if err := config.Collection("collections").FindOne(ctx, bson.D{{"_id", ns}}).Decode(&coll); err != nil {
+		return nil, fmt.Errorf("error reading config.collections for %v: %v", ns, err)
+	}
+	filter := bson.D{{"ns", ns}}
+	if coll.UUID != nil {
+		filter = bson.D{{"uuid", *coll.UUID}}
+	}
+	var chunks []configChunk
+	cursor, err := config.Collection("chunks").Find(ctx, filter, mopt.Find().SetSort(bson.D{{"min", 1}}))
+	if err == nil {
+		err = cursor.All(ctx, &chunks)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading the chunks of %v: %v", ns, err)
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("no chunks found for %v", ns)
+	}
+	return chunks, nil
+}
+
+// chunkShards chooses the shard each chunk, sorted by shard key, is moved
+// to. The chunks in the range of a zone with shards are spread across the
+// shards of the zone in turn, and the others across all shards.
+func chunkShards(chunks []configChunk, shards []configShard, zones []archive.ShardZone) ([]string, error) {
+	all := make([]string, len(shards))
+	zoneShards := map[string][]string{}
+	for i, shard := range shards {
+		all[i] = shard.ID
+		for _, tag := range shard.Tags {
+			zoneShards[tag] = append(zoneShards[tag], shard.ID)
+		}
+	}
+	type zoneRange struct {
+		zone     string
+		min, max bson.Raw
+	}
+	var ranges []zoneRange
+	for _, zone := range zones {
+		min, err := bson.Marshal(zone.Min)
+		if err != nil {
+			return nil, err
+		}
+		max, err := bson.Marshal(zone.Max)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, zoneRange{zone.Zone, min, max})
+	}
+
+	turns := map[string]int{}
+	targets := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		zone, candidates := "", all
+		for _, r := range ranges {
+			if len(zoneShards[r.zone]) > 0 && compareDocuments(chunk.Min, r.min) >= 0 && compareDocuments(chunk.Max, r.max) <= 0 {
+				zone, candidates = r.zone, zoneShards[r.zone]
+				break
+			}
+		}
+		targets[i] = candidates[turns[zone]%len(candidates)]
+		turns[zone]++
+	}
+	return targets, nil
+}
+
+// newShardRoutes returns the routes of the chunks, sorted by shard key, of
+// a collection sharded on key.
+func newShardRoutes(key bson.D, chunks []configChunk) *shardRoutes {
+	routes := &shardRoutes{}
+	for _, field := range key {
+		routes.fields = append(routes.fields, field.Key)
+	}
+	for _, chunk := range chunks {
+		routes.mins = append(routes.mins, chunk.Min)
+		routes.shards = append(routes.shards, chunk.Shard)
+	}
+	return routes
+}
+
+// shardFor returns the shard that owns doc. A field of the shard key
+// missing from doc is null.
+func (routes *shardRoutes) shardFor(doc bson.Raw) string {
+	values := make([]bson.RawValue, len(routes.fields))
+	for i, field := range routes.fields {
+		value, err := doc.LookupErr(strings.Split(field, ".")...)
+		if err != nil {
+			value = bson.RawValue{Type: bsontype.Null}
+		}
+		values[i] = value
+	}
+	i := sort.Search(len(routes.mins), func(i int) bool {
+		return compareKey(values, routes.mins[i]) < 0
+	})
+	if i == 0 {
+		return ""
+	}
+	return routes.shards[i-1]
+}
+
+// compareKey compares the shard key values of a document with a chunk
+// bound, whose fields are in the order of the shard key.
+func compareKey(values []bson.RawValue, bound bson.Raw) int {
+	elements, _ := bound.Elements()
+	for i, element := range elements {
+		if i == len(values) {
+			break
+		}
+		if c := compareValues(values[i], element.Value()); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// bsonTypeOrder returns the position of a BSON type in the order values of
+// different types are compared in by the server, with the numeric types
+// compared with each other.
+func bsonTypeOrder(t bsontype.Type) int {
+	switch t {
+	case bsontype.MinKey:
+		return 0
+	case bsontype.Undefined, bsontype.Null:
+		return 1
+	case bsontype.Double, bsontype.Int32, bsontype.Int64, bsontype.Decimal128:
+		return 2
+	case bsontype.String, bsontype.Symbol:
+		return 3
+	case bsontype.EmbeddedDocument:
+		return 4
+	case bsontype.Array:
+		return 5
+	case bsontype.Binary:
+		return 6
+	case bsontype.ObjectID:
+		return 7
+	case bsontype.Boolean:
+		return 8
+	case bsontype.DateTime:
+		return 9
+	case bsontype.Timestamp:
+		return 10
+	case bsontype.Regex:
+		return 11
+	case bsontype.DBPointer:
+		return 12
+	case bsontype.JavaScript:
+		return 13
+	case bsontype.CodeWithScope:
+		return 14
+	case bsontype.MaxKey:
+		return 16
+	}
+	return 15
+}
+
+// compareValues compares BSON values in the order of the server, with the
+// simple collation.
+func compareValues(a, b bson.RawValue) int {
+	if c := bsonTypeOrder(a.Type) - bsonTypeOrder(b.Type); c != 0 {
+		return c
+	}
+	switch a.Type {
+	case bsontype.MinKey, bsontype.MaxKey, bsontype.Undefined, bsontype.Null:
+		return 0
+	case bsontype.Double, bsontype.Int32, bsontype.Int64, bsontype.Decimal128:
+		return compareNumbers(a, b)
+	case bsontype.String, bsontype.Symbol:
+		return strings.Compare(stringValue(a), stringValue(b))
+	case bsontype.EmbeddedDocument, bsontype.Array:
+		return compareDocuments(a.Value, b.Value)
+	case bsontype.Binary:
+		aSubtype, aData := a.Binary()
+		bSubtype, bData := b.Binary()
+		if len(aData) != len(bData) {
+			return len(aData) - len(bData)
+		}
+		if aSubtype != bSubtype {
+			return int(aSubtype) - int(bSubtype)
+		}
+		return bytes.Compare(aData, bData)
+	case bsontype.Boolean:
+		return compareInts(boolInt(a.Boolean()), boolInt(b.Boolean()))
+	case bsontype.DateTime:
+		return compareInts(a.DateTime(), b.DateTime())
+	case bsontype.Timestamp:
+		aT, aI := a.Timestamp()
+		bT, bI := b.Timestamp()
+		if aT != bT {
+			return compareInts(int64(aT), int64(bT))
+		}
+		return compareInts(int64(aI), int64(bI))
+	}
+	return bytes.Compare(a.Value, b.Value)
+}
+
+// compareDocuments compares documents or arrays field by field, by the
+// type, name and value of each.
+func compareDocuments(a, b bson.Raw) int {
+	aElements, _ := a.Elements()
+	bElements, _ := b.Elements()
+	for i := 0; i < len(aElements) && i < len(bElements); i++ {
+		aValue, bValue := aElements[i].Value(), bElements[i].Value()
+		if c := bsonTypeOrder(aValue.Type) - bsonTypeOrder(bValue.Type); c != 0 {
+			return c
+		}
+		if c := strings.Compare(aElements[i].Key(), bElements[i].Key()); c != 0 {
+			return c
+		}
+		if c := compareValues(aValue, bValue); c != 0 {
+			return c
+		}
+	}
+	return len(aElements) - len(bElements)
+}
+
+// compareNumbers compares numbers of any numeric type, exactly for
+// integers. NaN is less than every other number.
+func compareNumbers(a, b bson.RawValue) int {
+	aInt, aIsInt := intValue(a)
+	bInt, bIsInt := intValue(b)
+	if aIsInt && bIsInt {
+		return compareInts(aInt, bInt)
+	}
+	aFloat, bFloat := floatValue(a), floatValue(b)
+	switch {
+	case math.IsNaN(aFloat) && math.IsNaN(bFloat):
+		return 0
+	case math.IsNaN(aFloat) || aFloat < bFloat:
+		return -1
+	case math.IsNaN(bFloat) || aFloat > bFloat:
+		return 1
+	}
+	return 0
+}
+
+func intValue(v bson.RawValue) (int64, bool) {
+	switch v.Type {
+	case bsontype.Int32:
+		return int64(v.Int32()), true
+	case bsontype.Int64:
+		return v.Int64(), true
+	}
+	return 0, false
+}
+
+func floatValue(v bson.RawValue) float64 {
+	switch v.Type {
+	case bsontype.Double:
+		return v.Double()
+	case bsontype.Int32:
+		return float64(v.Int32())
+	case bsontype.Int64:
+		return float64(v.Int64())
+	}
+	f, err := strconv.ParseFloat(v.Decimal128().String(), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+func stringValue(v bson.RawValue) string {
+	if v.Type == bsontype.Symbol {
+		return v.Symbol()
+	}
+	return v.StringValue()
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// shardWriter writes the documents of a collection restored with
+// --writeToShards straight to the primaries of the shards that own them,
+// with a bulk inserter for each shard.
+type shardWriter struct {
+	routes *shardRoutes
+	shards []string
+	bulks  map[string]*db.BufferedBulkInserter
+}
+
+// newShardWriter returns the shard writer of a collection, creating the
+// bulk inserter of each shard with newBulk. It returns nil for collections
+// written through mongos.
+func (restore *MongoRestore) newShardWriter(dbName, colName string, newBulk func(*mongo.Collection) *db.BufferedBulkInserter) (*shardWriter, error) {
+	restore.shardRoutesMutex.Lock()
+	routes := restore.shardRoutes[dbName+"."+colName]
+	restore.shardRoutesMutex.Unlock()
+	if routes == nil {
+		return nil, nil
+	}
+	writer := &shardWriter{routes: routes, bulks: map[string]*db.BufferedBulkInserter{}}
+	for id, provider := range restore.shardSessions {
+		session, err := provider.GetSession()
+		if err != nil {
+			return nil, fmt.Errorf("error establishing connection to shard %v: %v", id, err)
+		}
+		writer.shards = append(writer.shards, id)
+		writer.bulks[id] = newBulk(session.Database(dbName).Collection(colName))
+	}
+	sort.Strings(writer.shards)
+	return writer, nil
+}
+
+// bulkFor returns the bulk inserter of the shard that owns doc, or bulk if
+// the collection is written through mongos.
+func (writer *shardWriter) bulkFor(doc bson.Raw, bulk *db.BufferedBulkInserter) *db.BufferedBulkInserter {
+	if writer == nil {
+		return bulk
+	}
+	if shardBulk, ok := writer.bulks[writer.routes.shardFor(doc)]; ok {
+		return shardBulk
+	}
+	return bulk
+}
+
+// flushBulks flushes bulk and the bulk inserters of the shards of writer,
+// returning the first error.
+func (restore *MongoRestore) flushBulks(writer *shardWriter, bulk *db.BufferedBulkInserter) Result {
+	result := restore.writeResult(bulk.Flush())
+	if writer == nil {
+		return result
+	}
+	for _, id := range writer.shards {
+		err := result.Err
+		result.combineWith(restore.writeResult(writer.bulks[id].Flush()))
+		if err != nil {
+			result.Err = err
+		}
+	}
+	return result
+}
@@ -0,0 +1,178 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// rawValue returns the BSON value of v.
+func rawValue(v interface{}) bson.RawValue {
+	return bson.Raw(mustMarshal(bson.D{{Key: "v", Value: v}})).Lookup("v")
+}
+
+func keyBound(v interface{}) bson.Raw {
+	return mustMarshal(bson.D{{Key: "x", Value: v}})
+}
+
+func TestCompareValues(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Values are compared in the order of the server", t, func() {
+		ordered := []interface{}{
+			primitive.MinKey{},
+			nil,
+			math.NaN(),
+			int32(-5),
+			int64(2),
+			2.5,
+			int32(3),
+			"a",
+			"b",
+			bson.D{{Key: "a", Value: 1}},
+			bson.D{{Key: "a", Value: 2}},
+			bson.D{{Key: "a", Value: 2}, {Key: "b", Value: 1}},
+			bson.A{1},
+			primitive.Binary{Data: []byte{1}},
+			primitive.ObjectID{1},
+			false,
+			true,
+			primitive.DateTime(1),
+			primitive.Timestamp{T: 1, I: 2},
+			primitive.Timestamp{T: 2, I: 1},
+			primitive.MaxKey{},
+		}
+		for i := 0; i+1 < len(ordered); i++ {
+			So(compareValues(rawValue(ordered[i]), rawValue(ordered[i+1])), ShouldBeLessThan, 0)
+			So(compareValues(rawValue(ordered[i+1]), rawValue(ordered[i])), ShouldBeGreaterThan, 0)
+		}
+		So(compareValues(rawValue(int32(2)), rawValue(2.0)), ShouldEqual, 0)
+		So(compareValues(rawValue(int64(2)), rawValue(int32(2))), ShouldEqual, 0)
+		decimal, err := primitive.ParseDecimal128("2.5")
+		So(err, ShouldBeNil)
+		So(compareValues(rawValue(decimal), rawValue(2.5)), ShouldEqual, 0)
+	})
+}
+
+func TestShardRoutes(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	chunks := []configChunk{
+		{Min: keyBound(primitive.MinKey{}), Max: keyBound(10), Shard: "a"},
+		{Min: keyBound(10), Max: keyBound(20), Shard: "b"},
+		{Min: keyBound(20), Max: keyBound(primitive.MaxKey{}), Shard: "c"},
+	}
+
+	Convey("Documents are routed to the shard owning their shard key", t, func() {
+		routes := newShardRoutes(bson.D{{Key: "x", Value: 1}}, chunks)
+		So(routes.shardFor(mustMarshal(bson.D{{Key: "x", Value: 5}})), ShouldEqual, "a")
+		So(routes.shardFor(mustMarshal(bson.D{{Key: "x", Value: 10}})), ShouldEqual, "b")
+		So(routes.shardFor(mustMarshal(bson.D{{Key: "x", Value: 19.5}})), ShouldEqual, "b")
+		So(routes.shardFor(mustMarshal(bson.D{{Key: "x", Value: "a"}})), ShouldEqual, "c")
+		So(routes.shardFor(mustMarshal(bson.D{{Key: "_id", Value: 1}})), ShouldEqual, "a")
+
+		Convey("including by dotted fields", func() {
+			routes := newShardRoutes(bson.D{{Key: "a.x", Value: 1}}, []configChunk{
+				{Min: mustMarshal(bson.D{{Key: "a.x", Value: primitive.MinKey{}}}), Shard: "a"},
+				{Min: mustMarshal(bson.D{{Key: "a.x", Value: 10}}), Shard: "b"},
+			})
+			So(routes.shardFor(mustMarshal(bson.D{{Key: "a", Value: bson.D{{Key: "x", Value: 15}}}})), ShouldEqual, "b")
+		})
+	})
+
+	Convey("Chunks are spread across the shards of their zone", t, func() {
+		shards := []configShard{{ID: "a"}, {ID: "b", Tags: []string{"eu"}}, {ID: "c", Tags: []string{"eu"}}}
+		targets, err := chunkShards(chunks, shards, nil)
+		So(err, ShouldBeNil)
+		So(targets, ShouldResemble, []string{"a", "b", "c"})
+
+		zones := []archive.ShardZone{{Zone: "eu", Min: bson.D{{Key: "x", Value: 10}}, Max: bson.D{{Key: "x", Value: primitive.MaxKey{}}}}}
+		targets, err = chunkShards(chunks, shards, zones)
+		So(err, ShouldBeNil)
+		So(targets, ShouldResemble, []string{"a", "b", "c"})
+
+		zones[0].Min = bson.D{{Key: "x", Value: primitive.MinKey{}}}
+		targets, err = chunkShards(chunks, shards, zones)
+		So(err, ShouldBeNil)
+		So(targets, ShouldResemble, []string{"b", "c", "b"})
+
+		Convey("unless the zone has no shards", func() {
+			zones[0].Zone = "us"
+			targets, err := chunkShards(chunks, shards, zones)
+			So(err, ShouldBeNil)
+			So(targets, ShouldResemble, []string{"a", "b", "c"})
+		})
+	})
+}
+
+func TestShardCollections(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("The sharding options are validated", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{WriteToShards: true}}
+		So(restore.initSharding(), ShouldNotBeNil)
+		restore.OutputOptions = &OutputOptions{ShardCollections: true}
+		So(restore.initSharding(), ShouldNotBeNil)
+		restore.isMongos = true
+		So(restore.initSharding(), ShouldBeNil)
+		restore.OutputOptions = &OutputOptions{ShardCollections: true, WriteToShards: true, MaintainInsertionOrder: true}
+		So(restore.initSharding(), ShouldNotBeNil)
+	})
+
+	Convey("The sharding of a collection is read from its metadata or the archive", t, func() {
+		metadata, err := (&MongoRestore{}).MetadataFromJSON([]byte(`{"indexes": [], "sharding": {
+			"key": {"x": 1}, "unique": true,
+			"splitPoints": [{"x": 10}],
+			"zones": [{"zone": "eu", "min": {"x": {"$minKey": 1}}, "max": {"x": 10}}]}}`))
+		So(err, ShouldBeNil)
+		So(metadata.Sharding, ShouldNotBeNil)
+		So(metadata.Sharding.Key, ShouldResemble, bson.D{{Key: "x", Value: int32(1)}})
+		So(metadata.Sharding.Unique, ShouldBeTrue)
+		So(metadata.Sharding.SplitPoints, ShouldHaveLength, 1)
+		So(metadata.Sharding.Zones[0].Min, ShouldResemble, bson.D{{Key: "x", Value: primitive.MinKey{}}})
+
+		intent := &intents.Intent{DB: "test", C: "c"}
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}}
+		So(restore.collectionSharding(intent, metadata.Sharding), ShouldBeNil)
+		restore.OutputOptions.ShardCollections = true
+		So(restore.collectionSharding(intent, metadata.Sharding), ShouldEqual, metadata.Sharding)
+		So(restore.collectionSharding(intent, nil), ShouldBeNil)
+
+		restore.archive = &archive.Reader{Prelude: &archive.Prelude{Header: &archive.Header{Sharded: &archive.ShardedSnapshot{
+			Collections: []archive.ShardedCollection{{Namespace: "test.c", Key: bson.D{{Key: "y", Value: "hashed"}}}},
+		}}}}
+		So(restore.collectionSharding(intent, nil), ShouldResemble, &archive.ShardingMetadata{Key: bson.D{{Key: "y", Value: "hashed"}}})
+	})
+
+	Convey("Collections are planned to be sharded", t, func() {
+		intent := &intents.Intent{DB: "test", C: "c", Location: "dump/test/c.bson"}
+		sharding := &archive.ShardingMetadata{Key: bson.D{{Key: "x", Value: 1}}, SplitPoints: []bson.D{{{Key: "x", Value: 10}}}}
+		restore := &MongoRestore{OutputOptions: &OutputOptions{ShardCollections: true, WriteToShards: true}}
+		plan := restore.planCollection(collectionPlan{intent: intent, metadata: &Metadata{Sharding: sharding}})
+		So(planOperations(plan), ShouldResemble, []string{planCreate, planShard})
+		So(plan.Steps[1].Detail, ShouldEqual, `on key {"x":1}, 2 chunks moved across the shards, documents written to the shards`)
+
+		sharding.Key = bson.D{{Key: "x", Value: "hashed"}}
+		sharding.Zones = []archive.ShardZone{{Zone: "eu"}}
+		So(shardingDetail(sharding, true), ShouldEqual, `on key {"x":"hashed"}, 2 chunks distributed by the server, 1 zone range`)
+	})
+
+	Convey("Shard key patterns match regardless of numeric type", t, func() {
+		So(bsonutil.IsIndexKeysEqual(bson.D{{Key: "x", Value: int32(1)}}, bson.D{{Key: "x", Value: 1.0}}), ShouldBeTrue)
+		So(bsonutil.IsIndexKeysEqual(bson.D{{Key: "x", Value: 1}}, bson.D{{Key: "x", Value: 1}, {Key: "y", Value: 1}}), ShouldBeFalse)
+		So(bsonutil.IsIndexKeysEqual(bson.D{{Key: "x", Value: 1}}, bson.D{{Key: "x", Value: "hashed"}}), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,131 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// extractTar extracts the dump in the --tar stream to a new temporary
+// directory, which it returns for the caller to remove. A gzipped stream is
+// detected and decompressed.
+func (restore *MongoRestore) extractTar() (string, error) {
+	var in io.Reader
+	location := restore.InputOptions.Tar
+	if location == "-" {
+		in, location = restore.InputReader, "stdin"
+	} else {
+		file, err := openFile(location)
+		if err != nil {
+			return "", fmt.Errorf("error opening tar %v: %v", location, err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	buffered := bufio.NewReader(in)
+	codec, err := compression.Detect(buffered)
+	if err != nil {
+		return "", fmt.Errorf("error reading tar %v: %v", location, err)
+	}
+	in = buffered
+	if !codec.IsNone() {
+		codec.Workers = restore.InputOptions.DecompressionWorkers
+		decompressed, err := codec.NewReader(buffered)
+		if err != nil {
+			return "", fmt.Errorf("error reading tar %v: %v", location, err)
+		}
+		defer decompressed.Close()
+		in = decompressed
+	}
+
+	dir, err := ioutil.TempDir("", "mongorestore-tar")
+	if err != nil {
+		return "", fmt.Errorf("error creating directory to extract tar %v: %v", location, err)
+	}
+	log.Logvf(log.Info, "extracting tar %v to %v", location, dir)
+	if err = extractTarEntries(in, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("error extracting tar %v: %v", location, err)
+	}
+	return dir, nil
+}
+
+// extractTarEntries writes the directories and files of a tar stream under
+// dir. Files stored in segments are joined back into a single file, so each
+// file's segments must be in order.
+func extractTarEntries(in io.Reader, dir string) error {
+	reader := tar.NewReader(in)
+	nextSegments := map[string]int{}
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// names are kept inside dir
+		name := path.Clean("/" + header.Name)[1:]
+		if name == "" {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+			if base, index, ok := util.SplitSegmentPath(target); ok {
+				if index != nextSegments[base] {
+					return fmt.Errorf("segment %v of %v is out of order", index, name)
+				}
+				nextSegments[base] = index + 1
+				if index > 0 {
+					flags = os.O_WRONLY | os.O_APPEND
+				}
+				target = base
+			}
+			if err = extractTarFile(reader, target, flags); err != nil {
+				return err
+			}
+		default:
+			log.Logvf(log.DebugLow, "skipping tar entry %v of type %q", header.Name, header.Typeflag)
+		}
+	}
+}
+
+// extractTarFile copies the current entry of a tar stream to the file at
+// target, opened with the given flags.
+func extractTarFile(reader io.Reader, target string, flags int) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, flags, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, reader); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,85 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeTar returns a tar stream of the given files, in order.
+func writeTar(files [][2]string) []byte {
+	var out bytes.Buffer
+	writer := tar.NewWriter(&out)
+	for _, file := range files {
+		header := &tar.Header{Typeflag: tar.TypeReg, Name: file[0], Mode: 0644, Size: int64(len(file[1]))}
+		So(writer.WriteHeader(header), ShouldBeNil)
+		_, err := writer.Write([]byte(file[1]))
+		So(err, ShouldBeNil)
+	}
+	So(writer.Close(), ShouldBeNil)
+	return out.Bytes()
+}
+
+func TestExtractTar(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a gzipped tar of a dump", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_tar")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		_, err = gz.Write(writeTar([][2]string{
+			{"db/c.bson.part0000", "first "},
+			{"db/c.metadata.json", "{}"},
+			{"db/c.bson.part0001", "second"},
+			{"../outside.bson", "kept inside"},
+		}))
+		So(err, ShouldBeNil)
+		So(gz.Close(), ShouldBeNil)
+		path := filepath.Join(dir, "dump.tar.gz")
+		So(ioutil.WriteFile(path, compressed.Bytes(), 0644), ShouldBeNil)
+
+		restore := &MongoRestore{InputOptions: &InputOptions{Tar: path}}
+		extracted, err := restore.extractTar()
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(extracted)
+
+		Convey("segments are joined into their files", func() {
+			contents, err := ioutil.ReadFile(filepath.Join(extracted, "db", "c.bson"))
+			So(err, ShouldBeNil)
+			So(string(contents), ShouldEqual, "first second")
+			contents, err = ioutil.ReadFile(filepath.Join(extracted, "db", "c.metadata.json"))
+			So(err, ShouldBeNil)
+			So(string(contents), ShouldEqual, "{}")
+		})
+
+		Convey("entries can't be written outside the directory", func() {
+			_, err := os.Stat(filepath.Join(extracted, "outside.bson"))
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Segments out of order fail the extraction", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_tar")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		in := bytes.NewReader(writeTar([][2]string{{"db/c.bson.part0001", "second"}}))
+		So(extractTarEntries(in, dir), ShouldNotBeNil)
+	})
+}
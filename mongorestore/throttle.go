@@ -0,0 +1,191 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// replicationLagInterval is how often the replication lag of the target is
+// checked with --maxReplicationLag, and how long writes pause for before it
+// is checked again.
+const replicationLagInterval = 5 * time.Second
+
+// Replica set member states, as reported by replSetGetStatus
+const (
+	memberStatePrimary   = 1
+	memberStateSecondary = 2
+)
+
+// replSetStatus is the part of a replSetGetStatus reply that replication
+// lag is measured from.
+type replSetStatus struct {
+	Members []struct {
+		ID         int       `bson:"_id"`
+		Name       string    `bson:"name"`
+		State      int       `bson:"state"`
+		OptimeDate time.Time `bson:"optimeDate"`
+	} `bson:"members"`
+}
+
+// replSetConfig is the part of a replSetGetConfig reply that says which
+// members are delayed on purpose.
+type replSetConfig struct {
+	Config struct {
+		Members []struct {
+			ID                 int   `bson:"_id"`
+			SecondaryDelaySecs int64 `bson:"secondaryDelaySecs"`
+			SlaveDelay         int64 `bson:"slaveDelay"`
+		} `bson:"members"`
+	} `bson:"config"`
+}
+
+// replicationLag returns the lag of the secondary furthest behind the
+// primary, and its name. Delayed secondaries are left out, since they are
+// always behind.
+func replicationLag(status replSetStatus, config replSetConfig) (time.Duration, string) {
+	delayed := map[int]bool{}
+	for _, member := range config.Config.Members {
+		if member.SecondaryDelaySecs > 0 || member.SlaveDelay > 0 {
+			delayed[member.ID] = true
+		}
+	}
+	var primary time.Time
+	for _, member := range status.Members {
+		if member.State == memberStatePrimary {
+			primary = member.OptimeDate
+		}
+	}
+	if primary.IsZero() {
+		return 0, ""
+	}
+	var lag time.Duration
+	var name string
+	for _, member := range status.Members {
+		if member.State != memberStateSecondary || delayed[member.ID] {
+			continue
+		}
+		if behind := primary.Sub(member.OptimeDate); behind > lag {
+			lag, name = behind, member.Name
+		}
+	}
+	return lag, name
+}
+
+// lagThrottle pauses the restore while the secondaries of the target fall
+// further behind than --maxReplicationLag. A nil lagThrottle never pauses.
+type lagThrottle struct {
+	session *db.SessionProvider
+	maxLag  time.Duration
+
+	// the mutex is held while checking, so that every writer waits for
+	// the lag to recover
+	mutex     sync.Mutex
+	lastCheck time.Time
+}
+
+// newLagThrottle returns a lagThrottle for the target, checking that its
+// replication lag can be read.
+func newLagThrottle(session *db.SessionProvider, maxLag time.Duration) (*lagThrottle, error) {
+	throttle := &lagThrottle{session: session, maxLag: maxLag}
+	if _, _, err := throttle.lag(); err != nil {
+		return nil, fmt.Errorf("cannot use --maxReplicationLag: %v", err)
+	}
+	return throttle, nil
+}
+
+// lag reads the replication lag of the target.
+func (throttle *lagThrottle) lag() (time.Duration, string, error) {
+	var status replSetStatus
+	if err := throttle.session.Run(bson.D{{Key: "replSetGetStatus", Value: 1}}, &status, "admin"); err != nil {
+		return 0, "", fmt.Errorf("error getting replica set status: %v", err)
+	}
+	var config replSetConfig
+	if err := throttle.session.Run(bson.D{{Key: "replSetGetConfig", Value: 1}}, &config, "admin"); err != nil {
+		return 0, "", fmt.Errorf("error getting replica set config: %v", err)
+	}
+	lag, name := replicationLag(status, config)
+	return lag, name, nil
+}
+
+// wait blocks while the replication lag is more than --maxReplicationLag.
+// The lag is checked at most once per replicationLagInterval.
+func (throttle *lagThrottle) wait() error {
+	if throttle == nil {
+		return nil
+	}
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+	if time.Since(throttle.lastCheck) < replicationLagInterval {
+		return nil
+	}
+	for paused := time.Duration(0); ; paused += replicationLagInterval {
+		lag, name, err := throttle.lag()
+		if err != nil {
+			return err
+		}
+		throttle.lastCheck = time.Now()
+		if lag <= throttle.maxLag {
+			if paused > 0 {
+				log.Logvf(log.Always, "resuming writes after pausing for %v; replication lag is %v", paused, lag)
+			}
+			return nil
+		}
+		if paused == 0 {
+			log.Logvf(log.Always, "pausing writes: secondary %v is %v behind the primary, more than --maxReplicationLag %v",
+				name, lag, throttle.maxLag)
+		}
+		time.Sleep(replicationLagInterval)
+	}
+}
+
+// initThrottles sets up the throttling of writes given by --rateLimit,
+// --maxDocsPerSecond and --maxReplicationLag.
+func (restore *MongoRestore) initThrottles() error {
+	options := restore.OutputOptions
+	switch {
+	case options.RateLimit < 0:
+		return fmt.Errorf("rateLimit must be positive")
+	case options.MaxDocsPerSecond < 0:
+		return fmt.Errorf("maxDocsPerSecond must be positive")
+	}
+	if options.RateLimit > 0 {
+		restore.byteLimiter = util.NewRateLimiter(options.RateLimit * 1024 * 1024)
+	}
+	if options.MaxDocsPerSecond > 0 {
+		restore.docLimiter = util.NewRateLimiter(float64(options.MaxDocsPerSecond))
+	}
+	if options.MaxReplicationLag == "" {
+		return nil
+	}
+	maxLag, err := time.ParseDuration(options.MaxReplicationLag)
+	if err == nil && maxLag <= 0 {
+		err = fmt.Errorf("duration must be positive")
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing --maxReplicationLag: %v", err)
+	}
+	if restore.isMongos {
+		return fmt.Errorf("cannot use --maxReplicationLag with a mongos")
+	}
+	restore.lagThrottle, err = newLagThrottle(restore.SessionProvider, maxLag)
+	return err
+}
+
+// throttle waits before writing documents of the given total size.
+func (restore *MongoRestore) throttle(documents, size int) error {
+	restore.docLimiter.Wait(documents)
+	restore.byteLimiter.Wait(size)
+	return restore.lagThrottle.wait()
+}
@@ -0,0 +1,84 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestReplicationLag(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("The lag of a replica set is that of its furthest secondary", t, func() {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		var status replSetStatus
+		So(bson.Unmarshal(mustMarshal(bson.D{{Key: "members", Value: bson.A{
+			bson.D{{Key: "_id", Value: 0}, {Key: "name", Value: "a:27017"}, {Key: "state", Value: 1}, {Key: "optimeDate", Value: now}},
+			bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "b:27017"}, {Key: "state", Value: 2}, {Key: "optimeDate", Value: now.Add(-3 * time.Second)}},
+			bson.D{{Key: "_id", Value: 2}, {Key: "name", Value: "c:27017"}, {Key: "state", Value: 2}, {Key: "optimeDate", Value: now.Add(-time.Hour)}},
+			bson.D{{Key: "_id", Value: 3}, {Key: "name", Value: "d:27017"}, {Key: "state", Value: 7}},
+		}}}), &status), ShouldBeNil)
+
+		lag, name := replicationLag(status, replSetConfig{})
+		So(lag, ShouldEqual, time.Hour)
+		So(name, ShouldEqual, "c:27017")
+
+		Convey("leaving out delayed secondaries", func() {
+			var config replSetConfig
+			So(bson.Unmarshal(mustMarshal(bson.D{{Key: "config", Value: bson.D{{Key: "members", Value: bson.A{
+				bson.D{{Key: "_id", Value: 2}, {Key: "secondaryDelaySecs", Value: int64(3600)}},
+			}}}}}), &config), ShouldBeNil)
+			lag, name := replicationLag(status, config)
+			So(lag, ShouldEqual, 3*time.Second)
+			So(name, ShouldEqual, "b:27017")
+		})
+
+		Convey("and is unknown without a primary", func() {
+			status.Members = status.Members[1:]
+			lag, _ := replicationLag(status, replSetConfig{})
+			So(lag, ShouldEqual, 0)
+		})
+	})
+
+	Convey("A nil throttle never pauses", t, func() {
+		var throttle *lagThrottle
+		So(throttle.wait(), ShouldBeNil)
+	})
+}
+
+func TestInitThrottles(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("The throttling options are validated", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{RateLimit: 2, MaxDocsPerSecond: 100}}
+		So(restore.initThrottles(), ShouldBeNil)
+		So(restore.byteLimiter, ShouldNotBeNil)
+		So(restore.docLimiter, ShouldNotBeNil)
+		So(restore.lagThrottle, ShouldBeNil)
+
+		restore.OutputOptions = &OutputOptions{RateLimit: -1}
+		So(restore.initThrottles(), ShouldNotBeNil)
+		restore.OutputOptions = &OutputOptions{MaxReplicationLag: "soon"}
+		So(restore.initThrottles(), ShouldNotBeNil)
+		restore.OutputOptions = &OutputOptions{MaxReplicationLag: "10s"}
+		restore.isMongos = true
+		So(restore.initThrottles(), ShouldNotBeNil)
+	})
+}
+
+func mustMarshal(doc bson.D) []byte {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
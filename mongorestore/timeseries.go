@@ -0,0 +1,137 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// timeseriesFields are the fields the measurements of a time-series
+// collection are bucketed by. metaField is "" if the collection has none.
+type timeseriesFields struct {
+	timeField string
+	metaField string
+}
+
+// timeseriesFieldsOf returns the fields of the time-series options of a
+// collection, and false if it isn't a time-series collection.
+func timeseriesFieldsOf(options bson.D) (timeseriesFields, bool) {
+	for _, elem := range options {
+		timeseries, ok := elem.Value.(bson.D)
+		if elem.Key != "timeseries" || !ok {
+			continue
+		}
+		var fields timeseriesFields
+		for _, opt := range timeseries {
+			switch opt.Key {
+			case "timeField":
+				fields.timeField, _ = opt.Value.(string)
+			case "metaField":
+				fields.metaField, _ = opt.Value.(string)
+			}
+		}
+		return fields, fields.timeField != ""
+	}
+	return timeseriesFields{}, false
+}
+
+// checkTimeseriesRestore returns an error if the time-series collection of
+// intent can't be restored with the options given. Raw buckets are only
+// restored with --timeseriesBuckets, to a server of the major and minor
+// version they were dumped from, since their format changes between server
+// versions. Measurements can only be inserted, not upserted or replaced.
+func (restore *MongoRestore) checkTimeseriesRestore(intent *intents.Intent, metadata *Metadata) error {
+	if metadata.TimeseriesBuckets {
+		if !restore.OutputOptions.TimeseriesBuckets {
+			return fmt.Errorf("%v was dumped as raw time-series buckets, which are only restored with %v",
+				intent.Namespace(), TimeseriesBucketsOption)
+		}
+		if metadata.ServerVersion == "" {
+			log.Logvf(log.Always, "warning: the server version the buckets of %v were dumped from is unknown; "+
+				"they may not be readable by the target", intent.Namespace())
+			return nil
+		}
+		target := fmt.Sprintf("%v.%v", restore.serverVersion[0], restore.serverVersion[1])
+		if majorMinor(metadata.ServerVersion) != target {
+			return fmt.Errorf("the buckets of %v were dumped from a %v server, and can only be restored to a %v server, not %v",
+				intent.Namespace(), metadata.ServerVersion, majorMinor(metadata.ServerVersion)+".x", target+".x")
+		}
+		return nil
+	}
+	if _, ok := timeseriesFieldsOf(metadata.Options); ok && (restore.modeUpserts() || restore.OutputOptions.Mode == modeReplace) {
+		return fmt.Errorf("cannot use --mode=%v to restore time-series collection %v, whose measurements can only be inserted",
+			restore.OutputOptions.Mode, intent.Namespace())
+	}
+	return nil
+}
+
+// majorMinor returns the major and minor version of a server version.
+func majorMinor(version string) string {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return version
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// setMeasurementFields records the fields of a time-series collection whose
+// measurements are restored into namespace, for its inserts to be batched by.
+func (restore *MongoRestore) setMeasurementFields(namespace string, fields timeseriesFields) {
+	restore.measurementFieldsMutex.Lock()
+	defer restore.measurementFieldsMutex.Unlock()
+	if restore.measurementFields == nil {
+		restore.measurementFields = map[string]timeseriesFields{}
+	}
+	restore.measurementFields[namespace] = fields
+}
+
+// measurementFieldsOf returns the fields of the time-series collection
+// restored into namespace, or nil if it isn't one.
+func (restore *MongoRestore) measurementFieldsOf(namespace string) *timeseriesFields {
+	restore.measurementFieldsMutex.Lock()
+	defer restore.measurementFieldsMutex.Unlock()
+	fields, ok := restore.measurementFields[namespace]
+	if !ok {
+		return nil
+	}
+	return &fields
+}
+
+// sortMeasurements sorts a batch of measurements by their meta field and
+// then their time, so that the server fills each bucket in turn rather than
+// opening buckets for every series the batch holds. The documents dropped
+// by --transform, which are nil, are moved to the end.
+func sortMeasurements(docs []bson.Raw, fields timeseriesFields) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		if docs[i] == nil || docs[j] == nil {
+			return docs[j] == nil && docs[i] != nil
+		}
+		if fields.metaField != "" {
+			if c := compareValues(measurementField(docs[i], fields.metaField), measurementField(docs[j], fields.metaField)); c != 0 {
+				return c < 0
+			}
+		}
+		return compareValues(measurementField(docs[i], fields.timeField), measurementField(docs[j], fields.timeField)) < 0
+	})
+}
+
+// measurementField returns a field of a measurement, which is null if it
+// is missing.
+func measurementField(doc bson.Raw, field string) bson.RawValue {
+	value, err := doc.LookupErr(field)
+	if err != nil {
+		return bson.RawValue{Type: bsontype.Null}
+	}
+	return value
+}
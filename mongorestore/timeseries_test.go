@@ -0,0 +1,125 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTimeseriesRestore(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	options := bson.D{{Key: "timeseries", Value: bson.D{
+		{Key: "timeField", Value: "ts"},
+		{Key: "metaField", Value: "sensor"},
+		{Key: "granularity", Value: "minutes"},
+	}}}
+	intent := &intents.Intent{DB: "test", C: "readings", Location: "dump/test/readings.bson"}
+
+	Convey("The fields of a time-series collection are read from its options", t, func() {
+		fields, ok := timeseriesFieldsOf(options)
+		So(ok, ShouldBeTrue)
+		So(fields, ShouldResemble, timeseriesFields{timeField: "ts", metaField: "sensor"})
+		_, ok = timeseriesFieldsOf(bson.D{{Key: "capped", Value: true}})
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Raw buckets are only restored with --timeseriesBuckets", t, func() {
+		metadata := &Metadata{Options: options, TimeseriesBuckets: true, ServerVersion: "6.0.4"}
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}, serverVersion: db.Version{6, 0, 12}}
+		err := restore.checkTimeseriesRestore(intent, metadata)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, TimeseriesBucketsOption)
+
+		restore.OutputOptions.TimeseriesBuckets = true
+		So(restore.checkTimeseriesRestore(intent, metadata), ShouldBeNil)
+
+		Convey("to a server of the version they were dumped from", func() {
+			restore.serverVersion = db.Version{7, 0, 2}
+			err := restore.checkTimeseriesRestore(intent, metadata)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "can only be restored to a 6.0.x server, not 7.0.x")
+
+			metadata.ServerVersion = ""
+			So(restore.checkTimeseriesRestore(intent, metadata), ShouldBeNil)
+		})
+
+		Convey("which --dryRun reports", func() {
+			restore.OutputOptions.TimeseriesBuckets = false
+			plan := restore.planCollection(collectionPlan{intent: intent, metadata: metadata})
+			So(plan.Problems, ShouldHaveLength, 1)
+			So(plan.Problems[0], ShouldContainSubstring, "raw time-series buckets")
+		})
+	})
+
+	Convey("Measurements can only be inserted", t, func() {
+		metadata := &Metadata{Options: options}
+		restore := &MongoRestore{OutputOptions: &OutputOptions{Mode: modeInsertIgnore}}
+		So(restore.checkTimeseriesRestore(intent, metadata), ShouldBeNil)
+		for _, mode := range []string{modeUpsert, modeMerge, modeReplace} {
+			restore.OutputOptions.Mode = mode
+			So(restore.checkTimeseriesRestore(intent, metadata), ShouldNotBeNil)
+		}
+		restore.OutputOptions.Mode = modeUpsert
+		So(restore.checkTimeseriesRestore(intent, &Metadata{}), ShouldBeNil)
+	})
+
+	Convey("The fields of the collections restored are recorded by namespace", t, func() {
+		restore := &MongoRestore{}
+		So(restore.measurementFieldsOf("test.readings"), ShouldBeNil)
+		restore.setMeasurementFields("test.readings", timeseriesFields{timeField: "ts"})
+		So(restore.measurementFieldsOf("test.readings"), ShouldResemble, &timeseriesFields{timeField: "ts"})
+	})
+}
+
+func TestSortMeasurements(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	measurement := func(id int, sensor interface{}, ts int64) bson.Raw {
+		doc := bson.D{{Key: "_id", Value: id}, {Key: "ts", Value: primitive.DateTime(ts)}}
+		if sensor != nil {
+			doc = append(doc, bson.E{Key: "sensor", Value: sensor})
+		}
+		return mustMarshal(doc)
+	}
+	ids := func(docs []bson.Raw) []interface{} {
+		var sorted []interface{}
+		for _, doc := range docs {
+			if doc == nil {
+				sorted = append(sorted, nil)
+			} else {
+				sorted = append(sorted, doc.Lookup("_id").Int32())
+			}
+		}
+		return sorted
+	}
+
+	Convey("Measurements are sorted by their meta field and time", t, func() {
+		docs := []bson.Raw{
+			measurement(1, "b", 20),
+			measurement(2, "a", 30),
+			nil,
+			measurement(3, "b", 10),
+			measurement(4, nil, 50),
+			measurement(5, "a", 30),
+		}
+		sortMeasurements(docs, timeseriesFields{timeField: "ts", metaField: "sensor"})
+		So(ids(docs), ShouldResemble, []interface{}{int32(4), int32(2), int32(5), int32(3), int32(1), nil})
+
+		Convey("or only by time without a meta field", func() {
+			sortMeasurements(docs, timeseriesFields{timeField: "ts"})
+			So(ids(docs), ShouldResemble, []interface{}{int32(3), int32(1), int32(2), int32(5), int32(4), nil})
+		})
+	})
+}
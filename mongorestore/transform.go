@@ -0,0 +1,456 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// transformCommandPrefix marks a --transform that runs an external command
+// rather than an expression.
+const transformCommandPrefix = "exec:"
+
+// documentTransform is a stage of --transform. It returns a nil document to
+// drop the document from the restore.
+type documentTransform interface {
+	transform(doc bson.D) (bson.D, error)
+}
+
+// transformSpec is a parsed --transform. Expressions are shared by every
+// insertion worker; each worker runs its own process of a command.
+type transformSpec struct {
+	expression transformExpression
+	command    string
+}
+
+// initTransforms parses the --transform options.
+func (restore *MongoRestore) initTransforms() error {
+	for _, value := range restore.OutputOptions.Transforms {
+		if strings.HasPrefix(value, transformCommandPrefix) {
+			command := strings.TrimSpace(strings.TrimPrefix(value, transformCommandPrefix))
+			if command == "" {
+				return fmt.Errorf("invalid --transform '%v': missing command", value)
+			}
+			restore.transforms = append(restore.transforms, transformSpec{command: command})
+			continue
+		}
+		expression, err := parseTransformExpression(value)
+		if err != nil {
+			return fmt.Errorf("invalid --transform '%v': %v", value, err)
+		}
+		restore.transforms = append(restore.transforms, transformSpec{expression: expression})
+	}
+	return nil
+}
+
+// newTransformer returns the transforms of an insertion worker, or nil if
+// there are none.
+func (restore *MongoRestore) newTransformer() *transformer {
+	if len(restore.transforms) == 0 {
+		return nil
+	}
+	t := &transformer{}
+	for _, spec := range restore.transforms {
+		if spec.command != "" {
+			command := &transformCommand{command: spec.command}
+			t.stages = append(t.stages, command)
+			t.commands = append(t.commands, command)
+		} else {
+			t.stages = append(t.stages, spec.expression)
+		}
+	}
+	return t
+}
+
+// transformer applies the --transform stages to the documents of an
+// insertion worker, between their decoding and the bulk writer. A nil
+// transformer leaves documents unchanged.
+type transformer struct {
+	stages   []documentTransform
+	commands []*transformCommand
+}
+
+// apply returns the transformed document, or nil if it is dropped.
+func (t *transformer) apply(raw bson.Raw) (bson.Raw, error) {
+	if t == nil {
+		return raw, nil
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("error decoding document to transform: %v", err)
+	}
+	for _, stage := range t.stages {
+		var err error
+		if doc, err = stage.transform(doc); err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			return nil, nil
+		}
+	}
+	out, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding transformed document: %v", err)
+	}
+	return out, nil
+}
+
+// close stops the commands of the transformer. It may be called more than
+// once.
+func (t *transformer) close() error {
+	if t == nil {
+		return nil
+	}
+	var firstErr error
+	for _, command := range t.commands {
+		if err := command.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// transformCommand is a --transform=exec:<command>. The command is run with
+// the shell and given one document per line on stdin, as canonical extended
+// JSON; it must answer each with a line holding the transformed document,
+// or an empty line to drop it, before reading the next.
+type transformCommand struct {
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	in      *bufio.Writer
+	out     *bufio.Reader
+}
+
+// start runs the command.
+func (c *transformCommand) start() error {
+	if runtime.GOOS == "windows" {
+		c.cmd = exec.Command("cmd", "/C", c.command)
+	} else {
+		c.cmd = exec.Command("sh", "-c", c.command)
+	}
+	c.cmd.Stderr = os.Stderr
+	var err error
+	if c.stdin, err = c.cmd.StdinPipe(); err != nil {
+		return err
+	}
+	stdout, err := c.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = c.cmd.Start(); err != nil {
+		return fmt.Errorf("error running transform command '%v': %v", c.command, err)
+	}
+	c.in, c.out = bufio.NewWriter(c.stdin), bufio.NewReader(stdout)
+	return nil
+}
+
+func (c *transformCommand) transform(doc bson.D) (bson.D, error) {
+	if c.cmd == nil {
+		if err := c.start(); err != nil {
+			return nil, err
+		}
+	}
+	line, err := bson.MarshalExtJSON(doc, true, false)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding document for transform command: %v", err)
+	}
+	c.in.Write(line)
+	c.in.WriteByte('\n')
+	if err = c.in.Flush(); err != nil {
+		return nil, c.exited(err)
+	}
+	reply, err := c.out.ReadBytes('\n')
+	if err != nil {
+		return nil, c.exited(err)
+	}
+	reply = bytes.TrimSpace(reply)
+	if len(reply) == 0 {
+		return nil, nil
+	}
+	var out bson.D
+	if err = bson.UnmarshalExtJSON(reply, false, &out); err != nil {
+		return nil, fmt.Errorf("error decoding the output of transform command '%v': %v", c.command, err)
+	}
+	return out, nil
+}
+
+// exited returns the error of a command that stopped reading or writing
+// documents.
+func (c *transformCommand) exited(err error) error {
+	if waitErr := c.close(); waitErr != nil {
+		err = waitErr
+	}
+	return fmt.Errorf("transform command '%v' stopped: %v", c.command, err)
+}
+
+func (c *transformCommand) close() error {
+	if c.cmd == nil || c.stdin == nil {
+		return nil
+	}
+	c.stdin.Close()
+	c.stdin = nil
+	if err := c.cmd.Wait(); err != nil {
+		return fmt.Errorf("transform command '%v' failed: %v", c.command, err)
+	}
+	return nil
+}
+
+// transformExpression is a jq-like --transform expression: stages separated
+// by '|', each of which is one of
+//
+//	.a.b = <value>          set a field to a JSON value or another field, e.g. .tenant = "acme"
+//	del(.a, .b.c)           remove fields
+//	select(.a == <value>)   drop documents unless a field has the value (or != it)
+//
+// A field is renamed with '.new = .old | del(.old)'.
+type transformExpression []documentTransform
+
+func (e transformExpression) transform(doc bson.D) (bson.D, error) {
+	for _, stage := range e {
+		var err error
+		if doc, err = stage.transform(doc); err != nil || doc == nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// parseTransformExpression parses a --transform expression.
+func parseTransformExpression(value string) (transformExpression, error) {
+	var expression transformExpression
+	for _, text := range splitTransform(value, '|') {
+		stage, err := parseTransformStage(strings.TrimSpace(text))
+		if err != nil {
+			return nil, err
+		}
+		expression = append(expression, stage)
+	}
+	return expression, nil
+}
+
+func parseTransformStage(text string) (documentTransform, error) {
+	switch {
+	case text == "":
+		return nil, fmt.Errorf("empty stage")
+	case strings.HasPrefix(text, "del(") && strings.HasSuffix(text, ")"):
+		var stage deleteStage
+		for _, arg := range splitTransform(text[len("del("):len(text)-1], ',') {
+			path, err := parseTransformPath(arg)
+			if err != nil {
+				return nil, err
+			}
+			stage = append(stage, path)
+		}
+		return stage, nil
+	case strings.HasPrefix(text, "select(") && strings.HasSuffix(text, ")"):
+		condition := text[len("select(") : len(text)-1]
+		stage := selectStage{equal: true}
+		i := strings.Index(condition, "==")
+		if j := strings.Index(condition, "!="); j >= 0 && (i < 0 || j < i) {
+			i, stage.equal = j, false
+		}
+		if i < 0 {
+			return nil, fmt.Errorf("expected '==' or '!=' in '%v'", text)
+		}
+		var err error
+		if stage.path, err = parseTransformPath(condition[:i]); err != nil {
+			return nil, err
+		}
+		if stage.value, err = parseTransformValue(condition[i+2:]); err != nil {
+			return nil, err
+		}
+		return stage, nil
+	}
+	i := strings.Index(text, "=")
+	if i < 0 {
+		return nil, fmt.Errorf("expected an assignment, del() or select() in '%v'", text)
+	}
+	path, err := parseTransformPath(text[:i])
+	if err != nil {
+		return nil, err
+	}
+	value, err := parseTransformValue(text[i+1:])
+	if err != nil {
+		return nil, err
+	}
+	return setStage{path: path, value: value}, nil
+}
+
+// transformPath is the field path of a transform, e.g. .a.b.
+type transformPath []string
+
+func parseTransformPath(text string) (transformPath, error) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, ".") || len(text) == 1 {
+		return nil, fmt.Errorf("expected a field path like .a.b, got '%v'", text)
+	}
+	path := transformPath(strings.Split(text[1:], "."))
+	for _, key := range path {
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("invalid field path '%v'", text)
+		}
+	}
+	return path, nil
+}
+
+// parseTransformValue parses the value of an assignment or comparison,
+// which is either a field path or extended JSON.
+func parseTransformValue(text string) (interface{}, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, ".") {
+		return parseTransformPath(text)
+	}
+	var wrapper bson.D
+	if err := bson.UnmarshalExtJSON([]byte(`{"v":`+text+`}`), false, &wrapper); err != nil || len(wrapper) != 1 {
+		return nil, fmt.Errorf("invalid value '%v': expected a field path or JSON", text)
+	}
+	return wrapper[0].Value, nil
+}
+
+// resolveTransformValue returns the value of a stage, looking up field paths in doc.
+func resolveTransformValue(doc bson.D, value interface{}) (interface{}, bool) {
+	if path, ok := value.(transformPath); ok {
+		return path.get(doc)
+	}
+	return value, true
+}
+
+func (path transformPath) get(doc bson.D) (interface{}, bool) {
+	for i, key := range path {
+		var value interface{}
+		found := false
+		for _, elem := range doc {
+			if elem.Key == key {
+				value, found = elem.Value, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+		if i == len(path)-1 {
+			return value, true
+		}
+		if doc, found = value.(bson.D); !found {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+func (path transformPath) set(doc bson.D, value interface{}) bson.D {
+	for i := range doc {
+		if doc[i].Key != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			doc[i].Value = value
+		} else {
+			// nested documents are copied, since they may be shared with
+			// values of other stages
+			sub, _ := doc[i].Value.(bson.D)
+			doc[i].Value = path[1:].set(append(bson.D(nil), sub...), value)
+		}
+		return doc
+	}
+	if len(path) == 1 {
+		return append(doc, bson.E{Key: path[0], Value: value})
+	}
+	return append(doc, bson.E{Key: path[0], Value: path[1:].set(bson.D{}, value)})
+}
+
+func (path transformPath) delete(doc bson.D) bson.D {
+	for i := range doc {
+		if doc[i].Key != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return append(doc[:i:i], doc[i+1:]...)
+		}
+		if sub, ok := doc[i].Value.(bson.D); ok {
+			doc[i].Value = path[1:].delete(append(bson.D(nil), sub...))
+		}
+		return doc
+	}
+	return doc
+}
+
+type setStage struct {
+	path  transformPath
+	value interface{}
+}
+
+func (s setStage) transform(doc bson.D) (bson.D, error) {
+	value, ok := resolveTransformValue(doc, s.value)
+	if !ok {
+		// copying a missing field leaves the document as it is
+		return doc, nil
+	}
+	return s.path.set(doc, value), nil
+}
+
+type deleteStage []transformPath
+
+func (s deleteStage) transform(doc bson.D) (bson.D, error) {
+	for _, path := range s {
+		doc = path.delete(doc)
+	}
+	return doc, nil
+}
+
+type selectStage struct {
+	path  transformPath
+	value interface{}
+	equal bool
+}
+
+func (s selectStage) transform(doc bson.D) (bson.D, error) {
+	actual, found := s.path.get(doc)
+	expected, ok := resolveTransformValue(doc, s.value)
+	same := found && ok && sameValue(actual, expected)
+	if same != s.equal {
+		return nil, nil
+	}
+	return doc, nil
+}
+
+// splitTransform splits text on sep, outside of quotes and brackets.
+func splitTransform(text string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	inString, escaped := false, false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, text[start:])
+}
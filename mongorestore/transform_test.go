@@ -0,0 +1,164 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// transformDoc runs the transforms of restore on doc.
+func transformDoc(restore *MongoRestore, doc bson.D) (bson.D, error) {
+	transformer := restore.newTransformer()
+	defer transformer.close()
+	out, err := transformer.apply(mustMarshal(doc))
+	if err != nil || out == nil {
+		return nil, err
+	}
+	var result bson.D
+	if err = bson.Unmarshal(out, &result); err != nil {
+		return nil, err
+	}
+	return result, transformer.close()
+}
+
+func TestTransformExpressions(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	doc := bson.D{
+		{Key: "_id", Value: int32(1)},
+		{Key: "name", Value: "a"},
+		{Key: "ssn", Value: "123"},
+		{Key: "address", Value: bson.D{{Key: "city", Value: "x"}, {Key: "zip", Value: "1"}}},
+	}
+
+	Convey("Transform expressions rewrite documents", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{Transforms: []string{
+			`.tenant = "acme" | .ssn = "REDACTED"`,
+			`.fullName = .name | del(.name, .address.zip)`,
+			`.meta.restored = true`,
+		}}}
+		So(restore.initTransforms(), ShouldBeNil)
+		out, err := transformDoc(restore, doc)
+		So(err, ShouldBeNil)
+		So(out, ShouldResemble, bson.D{
+			{Key: "_id", Value: int32(1)},
+			{Key: "ssn", Value: "REDACTED"},
+			{Key: "address", Value: bson.D{{Key: "city", Value: "x"}}},
+			{Key: "tenant", Value: "acme"},
+			{Key: "fullName", Value: "a"},
+			{Key: "meta", Value: bson.D{{Key: "restored", Value: true}}},
+		})
+
+		Convey("without changing shared values", func() {
+			restore := &MongoRestore{OutputOptions: &OutputOptions{Transforms: []string{
+				`.meta = {"source": "dump"} | .meta.id = ._id`,
+			}}}
+			So(restore.initTransforms(), ShouldBeNil)
+			out, err := transformDoc(restore, doc)
+			So(err, ShouldBeNil)
+			So(out[len(out)-1].Value, ShouldResemble, bson.D{{Key: "source", Value: "dump"}, {Key: "id", Value: int32(1)}})
+			out, err = transformDoc(restore, bson.D{{Key: "_id", Value: int32(2)}})
+			So(err, ShouldBeNil)
+			So(out[1].Value, ShouldResemble, bson.D{{Key: "source", Value: "dump"}, {Key: "id", Value: int32(2)}})
+		})
+	})
+
+	Convey("select drops the documents that don't match", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{Transforms: []string{`select(._id == 1)`}}}
+		So(restore.initTransforms(), ShouldBeNil)
+		out, err := transformDoc(restore, doc)
+		So(err, ShouldBeNil)
+		So(out, ShouldNotBeNil)
+		out, err = transformDoc(restore, bson.D{{Key: "_id", Value: int32(2)}})
+		So(err, ShouldBeNil)
+		So(out, ShouldBeNil)
+
+		restore = &MongoRestore{OutputOptions: &OutputOptions{Transforms: []string{`select(.address.city != "x")`}}}
+		So(restore.initTransforms(), ShouldBeNil)
+		out, err = transformDoc(restore, doc)
+		So(err, ShouldBeNil)
+		So(out, ShouldBeNil)
+	})
+
+	Convey("Invalid expressions are rejected", t, func() {
+		for _, expression := range []string{
+			``,
+			`.a = `,
+			`a = 1`,
+			`.a = nope`,
+			`.a..b = 1`,
+			`del(a)`,
+			`select(.a)`,
+			`.a = 1 | `,
+			`exec:`,
+		} {
+			restore := &MongoRestore{OutputOptions: &OutputOptions{Transforms: []string{expression}}}
+			So(restore.initTransforms(), ShouldNotBeNil)
+		}
+	})
+
+	Convey("Without transforms documents are left as they are", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}}
+		So(restore.initTransforms(), ShouldBeNil)
+		So(restore.newTransformer(), ShouldBeNil)
+		raw := mustMarshal(doc)
+		out, err := restore.newTransformer().apply(raw)
+		So(err, ShouldBeNil)
+		So(out, ShouldResemble, bson.Raw(raw))
+	})
+}
+
+func TestTransformCommands(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+	if runtime.GOOS == "windows" {
+		t.Skip("transform commands are run with sh")
+	}
+
+	doc := bson.D{{Key: "_id", Value: int32(1)}, {Key: "name", Value: "a"}}
+
+	Convey("Transform commands rewrite documents line by line", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{Transforms: []string{
+			`exec:sed -u 's/"name"/"title"/'`,
+			`.tenant = "acme"`,
+		}}}
+		So(restore.initTransforms(), ShouldBeNil)
+		transformer := restore.newTransformer()
+		for i := 0; i < 3; i++ {
+			out, err := transformer.apply(mustMarshal(doc))
+			So(err, ShouldBeNil)
+			var result bson.D
+			So(bson.Unmarshal(out, &result), ShouldBeNil)
+			So(result, ShouldResemble, bson.D{
+				{Key: "_id", Value: int32(1)},
+				{Key: "title", Value: "a"},
+				{Key: "tenant", Value: "acme"},
+			})
+		}
+		So(transformer.close(), ShouldBeNil)
+		So(transformer.close(), ShouldBeNil)
+	})
+
+	Convey("An empty line drops the document", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{Transforms: []string{`exec:while read line; do echo; done`}}}
+		So(restore.initTransforms(), ShouldBeNil)
+		out, err := transformDoc(restore, doc)
+		So(err, ShouldBeNil)
+		So(out, ShouldBeNil)
+	})
+
+	Convey("A command that exits fails the transform", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{Transforms: []string{`exec:exit 3`}}}
+		So(restore.initTransforms(), ShouldBeNil)
+		_, err := transformDoc(restore, doc)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,182 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// parseUUID returns the UUID s, written as 32 hex digits with or without
+// the dashes of its canonical form, as the lowercase hex digits used by the
+// metadata files.
+func parseUUID(s string) (string, error) {
+	digits := strings.ToLower(s)
+	if len(digits) == 36 {
+		if digits[8] != '-' || digits[13] != '-' || digits[18] != '-' || digits[23] != '-' {
+			return "", fmt.Errorf("invalid UUID '%v'", s)
+		}
+		digits = strings.Replace(digits, "-", "", -1)
+	}
+	if _, err := hex.DecodeString(digits); err != nil || len(digits) != 32 {
+		return "", fmt.Errorf("invalid UUID '%v'", s)
+	}
+	return digits, nil
+}
+
+// parseUUIDMap parses the UUIDs of a --uuidMap, which is an object in JSON
+// or YAML whose keys are the namespaces collections are restored to or the
+// UUIDs of the collections in the dump. The keys that are UUIDs are
+// returned in the form of parseUUID.
+func parseUUIDMap(content []byte) (map[string]string, error) {
+	var entries map[string]string
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	if err := decoder.Decode(&entries); err != nil {
+		return nil, fmt.Errorf("expected an object of namespaces or UUIDs to UUIDs: %v", err)
+	}
+	uuids := make(map[string]string, len(entries))
+	targets := make(map[string]string, len(entries))
+	for key, value := range entries {
+		uuid, err := parseUUID(value)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", key, err)
+		}
+		if other, ok := targets[uuid]; ok {
+			return nil, fmt.Errorf("%v and %v are both mapped to UUID %v", other, key, value)
+		}
+		targets[uuid] = key
+		if !strings.Contains(key, ".") {
+			if key, err = parseUUID(key); err != nil {
+				return nil, fmt.Errorf("%v, expected a namespace or the UUID of a collection in the dump", err)
+			}
+		}
+		uuids[key] = uuid
+	}
+	return uuids, nil
+}
+
+// initUUIDs reads the --uuidMap and checks that the target can create
+// collections with the UUIDs they are to keep or be mapped to.
+func (restore *MongoRestore) initUUIDs() error {
+	if restore.OutputOptions.UUIDMap != "" {
+		content, err := util.ReadConfigFile(restore.OutputOptions.UUIDMap)
+		if err != nil {
+			return fmt.Errorf("error reading %v: %v", UUIDMapOption, err)
+		}
+		if restore.uuidMap, err = parseUUIDMap(content); err != nil {
+			return fmt.Errorf("error parsing %v: %v", UUIDMapOption, err)
+		}
+	}
+	if !restore.setsUUIDs() {
+		return nil
+	}
+
+	option := PreserveUUIDOption
+	if !restore.OutputOptions.PreserveUUID {
+		option = UUIDMapOption
+	}
+	if !restore.OutputOptions.Drop {
+		return fmt.Errorf("cannot specify %v without --drop", option)
+	}
+	if restore.isMongos {
+		return fmt.Errorf("cannot specify %v when restoring through mongos, as collections with a given UUID are created with applyOps", option)
+	}
+	ok, err := SupportsCollectionUUID(restore.SessionProvider)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("target host does not support %v", option)
+	}
+	return nil
+}
+
+// setsUUIDs returns whether collections may be created with given UUIDs.
+func (restore *MongoRestore) setsUUIDs() bool {
+	return restore.OutputOptions.PreserveUUID || len(restore.uuidMap) > 0
+}
+
+// collectionUUID returns the UUID the collection of intent is created with,
+// as hex digits, or "" for the server to generate one. A UUID mapped from the
+// namespace takes precedence over one mapped from the dump's UUID, which
+// takes precedence over the dump's UUID with --preserveUUID.
+func (restore *MongoRestore) collectionUUID(intent *intents.Intent, metadata *Metadata) (string, error) {
+	if uuid, ok := restore.uuidMap[intent.Namespace()]; ok {
+		return uuid, nil
+	}
+	if metadata == nil || metadata.UUID == "" {
+		if restore.OutputOptions.PreserveUUID {
+			log.Logvf(log.Always, "%v used but no UUID found in %v, generating new UUID for %v", PreserveUUIDOption, intent.MetadataLocation, intent.Namespace())
+		}
+		return "", nil
+	}
+	dumpUUID, err := parseUUID(metadata.UUID)
+	if err != nil {
+		return "", fmt.Errorf("the UUID of %v in %v: %v", intent.Namespace(), intent.MetadataLocation, err)
+	}
+	if uuid, ok := restore.uuidMap[dumpUUID]; ok {
+		return uuid, nil
+	}
+	if restore.OutputOptions.PreserveUUID {
+		return dumpUUID, nil
+	}
+	return "", nil
+}
+
+// oplogUUID returns the UUID an oplog entry is applied with: the one its
+// collection is mapped to by namespace or by UUID, its own with
+// --preserveUUID, or none for the server to find the collection by name.
+func (restore *MongoRestore) oplogUUID(op db.Oplog) *primitive.Binary {
+	namespace := op.Namespace
+	if op.Operation == "c" && len(op.Object) > 0 {
+		if collection, ok := op.Object[0].Value.(string); ok {
+			namespace = strings.TrimSuffix(op.Namespace, ".$cmd") + "." + collection
+		}
+	}
+	uuid, ok := restore.uuidMap[namespace]
+	if !ok && op.UI != nil {
+		uuid, ok = restore.uuidMap[hex.EncodeToString(op.UI.Data)]
+	}
+	if ok {
+		data, _ := hex.DecodeString(uuid)
+		return &primitive.Binary{Subtype: 0x04, Data: data}
+	}
+	if restore.OutputOptions.PreserveUUID {
+		return op.UI
+	}
+	return nil
+}
+
+// checkCollectionUUID returns an error if the collection of intent wasn't
+// created with the UUID uuid, as when the server ignores the UUID of
+// applyOps.
+func (restore *MongoRestore) checkCollectionUUID(intent *intents.Intent, uuid string) error {
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return fmt.Errorf("error establishing connection: %v", err)
+	}
+	info, err := db.GetCollectionInfo(session.Database(intent.DB).Collection(intent.C))
+	if err != nil {
+		return fmt.Errorf("error checking the UUID of %v: %v", intent.Namespace(), err)
+	}
+	if info == nil {
+		return fmt.Errorf("collection %v was not created", intent.Namespace())
+	}
+	if actual := info.GetUUID(); actual != uuid {
+		return fmt.Errorf("collection %v was created with UUID %v rather than %v", intent.Namespace(), actual, uuid)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	dumpUUID   = "0123456789abcdef0123456789abcdef"
+	mappedUUID = "fedcba9876543210fedcba9876543210"
+	otherUUID  = "00000000000000000000000000000001"
+)
+
+func uuidBinary(uuid string) *primitive.Binary {
+	data, _ := hex.DecodeString(uuid)
+	return &primitive.Binary{Subtype: 0x04, Data: data}
+}
+
+func TestParseUUIDMap(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("UUIDs are parsed with or without dashes", t, func() {
+		uuid, err := parseUUID("FEDCBA98-7654-3210-FEDC-BA9876543210")
+		So(err, ShouldBeNil)
+		So(uuid, ShouldEqual, mappedUUID)
+		uuid, err = parseUUID(mappedUUID)
+		So(err, ShouldBeNil)
+		So(uuid, ShouldEqual, mappedUUID)
+
+		for _, invalid := range []string{"", "abc", mappedUUID + "00", "fedcba98-7654-3210-fedc_ba9876543210", "zedcba9876543210fedcba9876543210"} {
+			_, err = parseUUID(invalid)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("A UUID map has namespaces and dump UUIDs as keys", t, func() {
+		uuids, err := parseUUIDMap([]byte(`{"test.c": "` + mappedUUID + `", "01234567-89ab-cdef-0123-456789abcdef": "` + otherUUID + `"}`))
+		So(err, ShouldBeNil)
+		So(uuids, ShouldResemble, map[string]string{"test.c": mappedUUID, dumpUUID: otherUUID})
+
+		for _, invalid := range []string{
+			`["test.c"]`,
+			`{"test.c": "abc"}`,
+			`{"c": "` + mappedUUID + `"}`,
+			`{"test.c": "` + mappedUUID + `", "test.d": "` + mappedUUID + `"}`,
+		} {
+			_, err = parseUUIDMap([]byte(invalid))
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestInitUUIDs(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a --uuidMap file", t, func() {
+		dir, err := ioutil.TempDir("", "uuid-map")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "uuids.yaml")
+		So(ioutil.WriteFile(path, []byte("test.c: "+mappedUUID+"\n"), 0644), ShouldBeNil)
+
+		Convey("--drop is required", func() {
+			restore := &MongoRestore{OutputOptions: &OutputOptions{UUIDMap: path}}
+			err := restore.initUUIDs()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "cannot specify --uuidMap without --drop")
+			So(restore.uuidMap, ShouldResemble, map[string]string{"test.c": mappedUUID})
+		})
+
+		Convey("restoring through mongos is an error", func() {
+			restore := &MongoRestore{OutputOptions: &OutputOptions{UUIDMap: path, Drop: true}, isMongos: true}
+			err := restore.initUUIDs()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "mongos")
+		})
+
+		Convey("a missing file is an error", func() {
+			restore := &MongoRestore{OutputOptions: &OutputOptions{UUIDMap: filepath.Join(dir, "missing.json")}}
+			So(restore.initUUIDs(), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Without --preserveUUID or --uuidMap nothing is checked", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}}
+		So(restore.initUUIDs(), ShouldBeNil)
+		So(restore.setsUUIDs(), ShouldBeFalse)
+	})
+}
+
+func TestCollectionUUID(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	intent := &intents.Intent{DB: "test", C: "c", MetadataLocation: "dump/test/c.metadata.json"}
+	metadata := &Metadata{UUID: dumpUUID}
+
+	Convey("With --preserveUUID the dump's UUID is kept", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{PreserveUUID: true}}
+		uuid, err := restore.collectionUUID(intent, metadata)
+		So(err, ShouldBeNil)
+		So(uuid, ShouldEqual, dumpUUID)
+
+		uuid, err = restore.collectionUUID(intent, &Metadata{})
+		So(err, ShouldBeNil)
+		So(uuid, ShouldEqual, "")
+
+		_, err = restore.collectionUUID(intent, &Metadata{UUID: "abc"})
+		So(err, ShouldNotBeNil)
+
+		Convey("unless it is mapped", func() {
+			restore.uuidMap = map[string]string{dumpUUID: otherUUID}
+			uuid, err := restore.collectionUUID(intent, metadata)
+			So(err, ShouldBeNil)
+			So(uuid, ShouldEqual, otherUUID)
+
+			restore.uuidMap["test.c"] = mappedUUID
+			uuid, err = restore.collectionUUID(intent, metadata)
+			So(err, ShouldBeNil)
+			So(uuid, ShouldEqual, mappedUUID)
+		})
+	})
+
+	Convey("With only --uuidMap unmapped collections get new UUIDs", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}, uuidMap: map[string]string{"test.d": mappedUUID}}
+		uuid, err := restore.collectionUUID(intent, metadata)
+		So(err, ShouldBeNil)
+		So(uuid, ShouldEqual, "")
+	})
+}
+
+func TestOplogUUIDs(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	insert := db.Oplog{Operation: "i", Namespace: "test.c", UI: uuidBinary(dumpUUID), Object: bson.D{{Key: "_id", Value: 1}}}
+	create := db.Oplog{Operation: "c", Namespace: "test.$cmd", UI: uuidBinary(dumpUUID), Object: bson.D{{Key: "create", Value: "d"}}}
+
+	Convey("Oplog UUIDs are removed by default", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}}
+		op, err := restore.filterUUIDs(insert)
+		So(err, ShouldBeNil)
+		So(op.UI, ShouldBeNil)
+	})
+
+	Convey("Oplog UUIDs are kept with --preserveUUID", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{PreserveUUID: true}}
+		op, err := restore.filterUUIDs(insert)
+		So(err, ShouldBeNil)
+		So(op.UI, ShouldResemble, uuidBinary(dumpUUID))
+	})
+
+	Convey("Oplog UUIDs are mapped by namespace or UUID", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}, uuidMap: map[string]string{dumpUUID: otherUUID, "test.d": mappedUUID}}
+		op, err := restore.filterUUIDs(insert)
+		So(err, ShouldBeNil)
+		So(op.UI, ShouldResemble, uuidBinary(otherUUID))
+
+		op, err = restore.filterUUIDs(create)
+		So(err, ShouldBeNil)
+		So(op.UI, ShouldResemble, uuidBinary(mappedUUID))
+
+		op, err = restore.filterUUIDs(db.Oplog{Operation: "i", Namespace: "test.e", UI: uuidBinary(mappedUUID)})
+		So(err, ShouldBeNil)
+		So(op.UI, ShouldBeNil)
+	})
+}
@@ -0,0 +1,126 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The --validatorAction values: the validator of a collection is created
+// with it, applied once its documents are restored, or not restored at all.
+const (
+	validatorActionRestore   = "restore"
+	validatorActionSkip      = "skip"
+	validatorActionAfterData = "restoreAfterData"
+)
+
+// validatorOptions are the collection options of its document validation.
+var validatorOptions = map[string]bool{"validator": true, "validationLevel": true, "validationAction": true}
+
+// validatorRule is a --validatorAction, which applies to the namespaces
+// matching its pattern like 'test.*:skip', or to all of them.
+type validatorRule struct {
+	// namespace is nil if the rule applies to every namespace
+	namespace *ns.Matcher
+	action    string
+}
+
+// initValidatorActions parses the --validatorAction rules.
+func (restore *MongoRestore) initValidatorActions() error {
+	restore.validatorRules = nil
+	for _, value := range restore.OutputOptions.ValidatorActions {
+		var rule validatorRule
+		action := value
+		if i := strings.LastIndex(value, ":"); i >= 0 {
+			matcher, err := ns.NewMatcher([]string{value[:i]})
+			if err != nil {
+				return fmt.Errorf("invalid %v '%v': %v", ValidatorActionOption, value, err)
+			}
+			rule.namespace, action = matcher, value[i+1:]
+		}
+		switch action {
+		case validatorActionRestore, validatorActionSkip, validatorActionAfterData:
+		default:
+			return fmt.Errorf("invalid %v '%v': the action must be %v, %v or %v", ValidatorActionOption, value,
+				validatorActionRestore, validatorActionSkip, validatorActionAfterData)
+		}
+		rule.action = action
+		restore.validatorRules = append(restore.validatorRules, rule)
+	}
+	return nil
+}
+
+// validatorActionFor returns the --validatorAction of a namespace: that of
+// the first rule with a pattern matching it, or else of the last rule
+// without one.
+func (restore *MongoRestore) validatorActionFor(namespace string) string {
+	action := validatorActionRestore
+	for _, rule := range restore.validatorRules {
+		if rule.namespace == nil {
+			action = rule.action
+		}
+	}
+	for _, rule := range restore.validatorRules {
+		if rule.namespace != nil && rule.namespace.Has(namespace) {
+			return rule.action
+		}
+	}
+	return action
+}
+
+// splitValidator returns the collection options without those of document
+// validation, and those options.
+func splitValidator(options bson.D) (rest bson.D, validator bson.D) {
+	for _, option := range options {
+		if validatorOptions[option.Key] {
+			validator = append(validator, option)
+		} else {
+			rest = append(rest, option)
+		}
+	}
+	return rest, validator
+}
+
+// collectionValidator removes the validator from the options a collection
+// is created with under --validatorAction skip or restoreAfterData,
+// returning the validator to apply once its documents are restored.
+func (restore *MongoRestore) collectionValidator(intent *intents.Intent, options bson.D) (bson.D, bson.D) {
+	action := restore.validatorActionFor(intent.Namespace())
+	if action == validatorActionRestore {
+		return options, nil
+	}
+	rest, validator := splitValidator(options)
+	if len(validator) == 0 {
+		return options, nil
+	}
+	if action == validatorActionSkip {
+		log.Logvf(log.Info, "not restoring the validator of %v", intent.Namespace())
+		return rest, nil
+	}
+	log.Logvf(log.Info, "applying the validator of %v once its documents are restored", intent.Namespace())
+	return rest, validator
+}
+
+// applyValidator sets the validation options of a restored collection.
+func (restore *MongoRestore) applyValidator(intent *intents.Intent, validator bson.D) error {
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return fmt.Errorf("error establishing connection: %v", err)
+	}
+	command := append(bson.D{{Key: "collMod", Value: intent.C}}, validator...)
+	if err = session.Database(intent.DB).RunCommand(context.Background(), command).Err(); err != nil {
+		return fmt.Errorf("error applying the validator of %v: %v", intent.Namespace(), err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestValidatorActions(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("--validatorAction rules are parsed with optional namespace patterns", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{
+			ValidatorActions: []string{"test.legacy*:skip", "restoreAfterData", "test.*:restore"},
+		}}
+		So(restore.initValidatorActions(), ShouldBeNil)
+		So(restore.validatorRules, ShouldHaveLength, 3)
+
+		Convey("and the first matching pattern applies, or else the rule without one", func() {
+			So(restore.validatorActionFor("test.legacyUsers"), ShouldEqual, validatorActionSkip)
+			So(restore.validatorActionFor("test.users"), ShouldEqual, validatorActionRestore)
+			So(restore.validatorActionFor("other.users"), ShouldEqual, validatorActionAfterData)
+		})
+	})
+
+	Convey("Validators are restored with collections by default", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}}
+		So(restore.initValidatorActions(), ShouldBeNil)
+		So(restore.validatorActionFor("test.c"), ShouldEqual, validatorActionRestore)
+	})
+
+	Convey("Invalid actions and patterns are errors", t, func() {
+		for _, value := range []string{"later", "test.*:", "test.*:Skip"} {
+			restore := &MongoRestore{OutputOptions: &OutputOptions{ValidatorActions: []string{value}}}
+			So(restore.initValidatorActions(), ShouldNotBeNil)
+		}
+	})
+}
+
+func TestCollectionValidator(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	validator := bson.D{{Key: "$jsonSchema", Value: bson.D{{Key: "required", Value: bson.A{"name"}}}}}
+	options := bson.D{
+		{Key: "capped", Value: true},
+		{Key: "validator", Value: validator},
+		{Key: "validationLevel", Value: "strict"},
+		{Key: "validationAction", Value: "error"},
+	}
+	intent := &intents.Intent{DB: "test", C: "c", Location: "dump/test/c.bson"}
+
+	Convey("The validation options are split from the other options", t, func() {
+		rest, split := splitValidator(options)
+		So(rest, ShouldResemble, bson.D{{Key: "capped", Value: true}})
+		So(split, ShouldResemble, options[1:])
+	})
+
+	Convey("The validator of a collection", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{}}
+
+		Convey("is created with it by default", func() {
+			created, after := restore.collectionValidator(intent, options)
+			So(created, ShouldResemble, options)
+			So(after, ShouldBeNil)
+		})
+
+		Convey("is dropped with skip", func() {
+			restore.OutputOptions.ValidatorActions = []string{"skip"}
+			So(restore.initValidatorActions(), ShouldBeNil)
+			created, after := restore.collectionValidator(intent, options)
+			So(created, ShouldResemble, bson.D{{Key: "capped", Value: true}})
+			So(after, ShouldBeNil)
+		})
+
+		Convey("is applied after the data with restoreAfterData", func() {
+			restore.OutputOptions.ValidatorActions = []string{"test.c:restoreAfterData"}
+			So(restore.initValidatorActions(), ShouldBeNil)
+			created, after := restore.collectionValidator(intent, options)
+			So(created, ShouldResemble, bson.D{{Key: "capped", Value: true}})
+			So(after, ShouldResemble, options[1:])
+
+			plan := restore.planCollection(collectionPlan{intent: intent, metadata: &Metadata{Options: options}})
+			So(planOperations(plan), ShouldContain, planCreate)
+			So(plan.Steps[0].Detail, ShouldEqual, "with options capped, validator applied after the documents are restored")
+		})
+
+		Convey("is left alone if the collection has none", func() {
+			restore.OutputOptions.ValidatorActions = []string{"restoreAfterData"}
+			So(restore.initValidatorActions(), ShouldBeNil)
+			created, after := restore.collectionValidator(intent, options[:1])
+			So(created, ShouldResemble, options[:1])
+			So(after, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,446 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// verifyManifestFile is the part of an entry of a dump's manifest that
+// --verify checks against.
+type verifyManifestFile struct {
+	Path      string `json:"path"`
+	Documents *int64 `json:"documents"`
+	MD5       string `json:"md5"`
+}
+
+// verifyReport is the outcome of --verify and --verifyAgainst, which is
+// written to --verifyReport. Against names the cluster of --verifyAgainst.
+type verifyReport struct {
+	Passed      bool                `json:"passed"`
+	Source      string              `json:"source"`
+	Against     string              `json:"against,omitempty"`
+	Started     time.Time           `json:"started"`
+	Finished    time.Time           `json:"finished"`
+	Collections []*verifyCollection `json:"collections"`
+}
+
+// verifyCollection is the outcome of the checks of one collection.
+type verifyCollection struct {
+	Namespace string        `json:"ns"`
+	Passed    bool          `json:"passed"`
+	Checks    []verifyCheck `json:"checks"`
+}
+
+// verifyCheck is the outcome of one check of a collection. Detail
+// describes how it failed.
+type verifyCheck struct {
+	Check  string `json:"check"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// collectionState is what --verify compares of a collection in the dump
+// and in the target. Fields that aren't checked are left unset.
+type collectionState struct {
+	// CheckCount is set for the dump when the count is checked, and
+	// Documents is nil if the manifest has no count
+	CheckCount bool
+	Documents  *int64
+	Indexes    []IndexDocument
+	Options    bson.D
+	MD5        string
+}
+
+// validateVerifyOptions checks that --verify can be used with the source of
+// the restore.
+func (restore *MongoRestore) validateVerifyOptions() error {
+	if err := restore.validateVerifyAgainstOptions(); err != nil {
+		return err
+	}
+	if !restore.OutputOptions.Verify {
+		if restore.OutputOptions.VerifyHash {
+			return fmt.Errorf("cannot use %v without %v", VerifyHashOption, VerifyOption)
+		}
+		if restore.OutputOptions.VerifyReport != "" && restore.OutputOptions.VerifyAgainst == "" {
+			return fmt.Errorf("cannot use %v without %v or %v", VerifyReportOption, VerifyOption, VerifyAgainstOption)
+		}
+		return nil
+	}
+	switch {
+	case restore.InputOptions.Archive != "":
+		return fmt.Errorf("cannot use %v with --archive specified", VerifyOption)
+	case restore.TargetDirectory == "-":
+		return fmt.Errorf("cannot use %v when restoring from stdin", VerifyOption)
+	}
+	return nil
+}
+
+// verify compares the restored collections with the dump's manifest and
+// metadata, logs a pass/fail report, and writes it to --verifyReport. It
+// returns an error if any check failed.
+func (restore *MongoRestore) verify() error {
+	report := &verifyReport{Passed: true, Source: restore.TargetDirectory, Started: time.Now().UTC()}
+	root, files, err := restore.readVerifyManifest()
+	if err != nil {
+		return err
+	}
+
+	checkCounts := !restore.OutputOptions.MetadataOnly && !restore.OutputOptions.IndexesOnly
+	for _, intent := range restore.verifyIntents() {
+		expected := collectionState{}
+		file := manifestEntryFor(files, relativeLocation(root, intent.Location))
+		if checkCounts && !intent.IsTimeseries() {
+			// the count of a time series collection is of its
+			// measurements, not of the buckets that were dumped
+			expected.CheckCount = true
+			if file != nil {
+				expected.Documents = file.Documents
+			}
+		}
+		if restore.OutputOptions.VerifyHash && file != nil {
+			expected.MD5 = file.MD5
+		}
+		if intent.MetadataFile != nil {
+			metadata, err := restore.readVerifyMetadata(intent)
+			if err != nil {
+				return err
+			}
+			if metadata != nil {
+				// indexes skipped by --skipIndex are not expected
+				expected.Indexes = restore.filterIndexes(intent.Namespace(), metadata.Indexes)
+				expected.Options = metadata.Options
+				if restore.validatorActionFor(intent.Namespace()) == validatorActionSkip {
+					expected.Options, _ = splitValidator(metadata.Options)
+				}
+			}
+		}
+		actual, err := restore.targetState(intent, expected.CheckCount)
+		if err != nil {
+			return fmt.Errorf("error verifying %v: %v", intent.Namespace(), err)
+		}
+
+		collection := &verifyCollection{Namespace: intent.Namespace(), Passed: true}
+		collection.Checks = restore.compareCollection(expected, actual)
+		for _, check := range collection.Checks {
+			if !check.Passed {
+				collection.Passed, report.Passed = false, false
+				log.Logvf(log.Always, "verify %v: %v failed: %v", intent.Namespace(), check.Check, check.Detail)
+			}
+		}
+		report.Collections = append(report.Collections, collection)
+	}
+	report.Finished = time.Now().UTC()
+
+	failed := 0
+	for _, collection := range report.Collections {
+		if !collection.Passed {
+			failed++
+		}
+	}
+	restore.verification = report
+	if err = restore.writeVerifyReport(); err != nil {
+		return err
+	}
+	if !report.Passed {
+		return fmt.Errorf("verify failed: %v of %v %v did not match the dump", failed,
+			len(report.Collections), util.Pluralize(len(report.Collections), "collection", "collections"))
+	}
+	log.Logvf(log.Always, "verify passed: all %v %v match the dump", len(report.Collections),
+		util.Pluralize(len(report.Collections), "collection", "collections"))
+	return nil
+}
+
+// writeVerifyReport writes the checks of --verify and --verifyAgainst that
+// have run to --verifyReport.
+func (restore *MongoRestore) writeVerifyReport() error {
+	if restore.OutputOptions.VerifyReport == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(restore.verification, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(restore.OutputOptions.VerifyReport, data, 0644); err != nil {
+		return fmt.Errorf("error writing verify report: %v", err)
+	}
+	return nil
+}
+
+// verifyIntents returns the intents of the collections that were restored,
+// sorted by namespace.
+func (restore *MongoRestore) verifyIntents() []*intents.Intent {
+	var collections []*intents.Intent
+	for _, intent := range restore.manager.Intents() {
+		if intent.IsOplog() || intent.IsSpecialCollection() || intent.IsSystemIndexes() || intent.IsView() ||
+			intent.Location == "" {
+			continue
+		}
+		collections = append(collections, intent)
+	}
+	sort.Slice(collections, func(i, j int) bool { return collections[i].Namespace() < collections[j].Namespace() })
+	return collections
+}
+
+// readVerifyManifest reads the manifest of the dump, which is in the
+// target directory or, when restoring a single database's directory, its
+// parent. It returns the directory the manifest's paths are relative to.
+func (restore *MongoRestore) readVerifyManifest() (string, map[string]*verifyManifestFile, error) {
+	root := strings.TrimSuffix(restore.TargetDirectory, "/")
+	if !storage.IsRemote(root) {
+		root = filepath.Clean(root)
+	}
+	data, err := readFile(joinPath(root, archive.ManifestFile))
+	if err != nil {
+		root = parentPath(root)
+		if data, err = readFile(joinPath(root, archive.ManifestFile)); err != nil {
+			return "", nil, fmt.Errorf("%v needs the %v written by mongodump: %v", VerifyOption, archive.ManifestFile, err)
+		}
+	}
+	var m struct {
+		Files []*verifyManifestFile `json:"files"`
+	}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return "", nil, fmt.Errorf("error parsing dump manifest: %v", err)
+	}
+	files := map[string]*verifyManifestFile{}
+	for _, file := range m.Files {
+		files[file.Path] = file
+	}
+	return root, files, nil
+}
+
+// relativeLocation returns the path of a dump file relative to root, with
+// slashes, as it is listed in the manifest.
+func relativeLocation(root, location string) string {
+	if storage.IsRemote(root) {
+		return strings.TrimPrefix(location, root+"/")
+	}
+	if rel, err := filepath.Rel(root, location); err == nil {
+		location = rel
+	}
+	return filepath.ToSlash(location)
+}
+
+// manifestEntryFor returns the manifest entry of the BSON file at rel. The
+// entry of a file split by --splitSize is that of its first segment.
+func manifestEntryFor(files map[string]*verifyManifestFile, rel string) *verifyManifestFile {
+	if file, ok := files[rel]; ok {
+		return file
+	}
+	return files[util.SegmentPath(rel, 0)]
+}
+
+// readVerifyMetadata reads the metadata of an intent again, to compare the
+// indexes and options it gave with those of the restored collection.
+func (restore *MongoRestore) readVerifyMetadata(intent *intents.Intent) (*Metadata, error) {
+	if err := intent.MetadataFile.Open(); err != nil {
+		return nil, err
+	}
+	defer intent.MetadataFile.Close()
+	data, err := ioutil.ReadAll(intent.MetadataFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading metadata from %v: %v", intent.MetadataLocation, err)
+	}
+	metadata, err := restore.MetadataFromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing metadata from %v: %v", intent.MetadataLocation, err)
+	}
+	return metadata, nil
+}
+
+// targetState reads the state of a restored collection from the target.
+func (restore *MongoRestore) targetState(intent *intents.Intent, count bool) (collectionState, error) {
+	var state collectionState
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return state, fmt.Errorf("error establishing connection: %v", err)
+	}
+	collection := session.Database(intent.DB).Collection(intent.C)
+
+	if count {
+		documents, err := collection.CountDocuments(nil, bson.D{})
+		if err != nil {
+			return state, fmt.Errorf("error counting documents: %v", err)
+		}
+		state.Documents = &documents
+	}
+
+	cursor, err := db.GetIndexes(collection)
+	if err != nil {
+		return state, fmt.Errorf("error listing indexes: %v", err)
+	}
+	if err = cursor.All(nil, &state.Indexes); err != nil {
+		return state, fmt.Errorf("error listing indexes: %v", err)
+	}
+
+	// the options are decoded in order, as they are in the metadata
+	cursor, err = db.GetCollections(collection.Database(), intent.C)
+	if err != nil {
+		return state, fmt.Errorf("error reading collection options: %v", err)
+	}
+	var infos []struct {
+		Options bson.D `bson:"options"`
+	}
+	if err = cursor.All(nil, &infos); err != nil {
+		return state, fmt.Errorf("error reading collection options: %v", err)
+	}
+	if len(infos) > 0 {
+		state.Options = infos[0].Options
+	}
+
+	if restore.OutputOptions.VerifyHash {
+		var hashes struct {
+			Collections map[string]string `bson:"collections"`
+		}
+		err = restore.SessionProvider.Run(bson.D{{Key: "dbHash", Value: 1}, {Key: "collections", Value: bson.A{intent.C}}},
+			&hashes, intent.DB)
+		if err != nil {
+			return state, fmt.Errorf("error running dbHash: %v", err)
+		}
+		state.MD5 = hashes.Collections[intent.C]
+	}
+	return state, nil
+}
+
+// compareCollection checks a restored collection against the dump. The
+// indexes and options that restoring changes by design are not compared.
+func (restore *MongoRestore) compareCollection(expected, actual collectionState) []verifyCheck {
+	var checks []verifyCheck
+	if expected.CheckCount {
+		check := verifyCheck{Check: "count", Passed: true}
+		switch {
+		case expected.Documents == nil:
+			check.Passed, check.Detail = false, "the manifest has no document count"
+		case actual.Documents == nil || *actual.Documents != *expected.Documents:
+			var documents int64
+			if actual.Documents != nil {
+				documents = *actual.Documents
+			}
+			check.Passed, check.Detail = false, fmt.Sprintf("%v documents, but the dump has %v", documents, *expected.Documents)
+		}
+		checks = append(checks, check)
+	}
+
+	if !restore.OutputOptions.NoIndexRestore {
+		// converting legacy or dotted hashed indexes changes their keys
+		compareKeys := !restore.OutputOptions.ConvertLegacyIndexes && !restore.OutputOptions.FixDottedHashedIndexes
+		checks = append(checks, compareIndexes(expected.Indexes, actual.Indexes, compareKeys))
+	}
+
+	if !restore.OutputOptions.NoOptionsRestore {
+		checks = append(checks, compareOptions(expected.Options, actual.Options))
+	}
+
+	if restore.OutputOptions.VerifyHash {
+		check := verifyCheck{Check: "dbHash", Passed: true}
+		switch {
+		case expected.MD5 == "":
+			check.Passed, check.Detail = false, "the manifest has no dbHash; dump with --dbHash"
+		case actual.MD5 != expected.MD5:
+			check.Passed, check.Detail = false, fmt.Sprintf("md5 %v, but the dump has %v", actual.MD5, expected.MD5)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// compareIndexes checks that each index of the dump was restored, by name
+// and, if compareKeys is set, key. Indexes the target already had are
+// allowed.
+func compareIndexes(expected, actual []IndexDocument, compareKeys bool) verifyCheck {
+	check := verifyCheck{Check: "indexes", Passed: true}
+	keys := make(map[string]bson.D, len(actual))
+	for _, index := range actual {
+		keys[fmt.Sprint(index.Options["name"])] = index.Key
+	}
+	var problems []string
+	for _, index := range expected {
+		name := fmt.Sprint(index.Options["name"])
+		key, ok := keys[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%v is missing", name))
+		case compareKeys && !sameValue(index.Key, key):
+			problems = append(problems, fmt.Sprintf("%v has a different key", name))
+		}
+	}
+	if len(problems) > 0 {
+		check.Passed, check.Detail = false, strings.Join(problems, "; ")
+	}
+	return check
+}
+
+// compareOptions checks that each collection option of the dump has the
+// same value in the target. Options the server adds are allowed.
+func compareOptions(expected, actual bson.D) verifyCheck {
+	check := verifyCheck{Check: "options", Passed: true}
+	values := actual.Map()
+	var problems []string
+	for _, option := range expected {
+		value, ok := values[option.Key]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%v is missing", option.Key))
+		case !sameValue(option.Value, value):
+			problems = append(problems, fmt.Sprintf("%v is different", option.Key))
+		}
+	}
+	if len(problems) > 0 {
+		check.Passed, check.Detail = false, strings.Join(problems, "; ")
+	}
+	return check
+}
+
+// sameValue compares BSON values, treating numbers of different types with
+// the same value as equal.
+func sameValue(a, b interface{}) bool {
+	if x, err := util.ToFloat64(a); err == nil {
+		y, err := util.ToFloat64(b)
+		return err == nil && x == y
+	}
+	switch x := a.(type) {
+	case bson.D:
+		y, ok := b.(bson.D)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if x[i].Key != y[i].Key || !sameValue(x[i].Value, y[i].Value) {
+				return false
+			}
+		}
+		return true
+	case bson.A:
+		y, ok := b.(bson.A)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !sameValue(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
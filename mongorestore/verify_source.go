@@ -0,0 +1,173 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// clusterState is what --verifyAgainst compares of a collection in the
+// source cluster and in the target. HashErr is set if dbHash failed, as it
+// does through a mongos.
+type clusterState struct {
+	Documents int64
+	MD5       string
+	HashErr   error
+}
+
+// validateVerifyAgainstOptions checks the --verifyAgainst connection
+// string, and that the restore writes documents to compare.
+func (restore *MongoRestore) validateVerifyAgainstOptions() error {
+	if restore.OutputOptions.VerifyAgainst == "" {
+		return nil
+	}
+	if _, err := options.NewURI(restore.OutputOptions.VerifyAgainst); err != nil {
+		return fmt.Errorf("invalid %v: %v", VerifyAgainstOption, err)
+	}
+	switch {
+	case restore.OutputOptions.MetadataOnly:
+		return fmt.Errorf("cannot use %v with %v", VerifyAgainstOption, MetadataOnlyOption)
+	case restore.OutputOptions.IndexesOnly:
+		return fmt.Errorf("cannot use %v with %v", VerifyAgainstOption, IndexesOnlyOption)
+	}
+	return nil
+}
+
+// verifyAgainst compares the document count and dbHash of each restored
+// collection with those of the collection it was dumped from in the
+// --verifyAgainst cluster, adds the checks to the report of --verify, logs
+// them and writes them to --verifyReport. It returns an error if any check
+// failed.
+func (restore *MongoRestore) verifyAgainst() error {
+	opts, err := clusterToolOptions(restore.ToolOptions, restore.OutputOptions.VerifyAgainst)
+	if err != nil {
+		return fmt.Errorf("invalid %v: %v", VerifyAgainstOption, err)
+	}
+	// the source is named by its hosts, so that its credentials aren't
+	// logged or written to the report
+	name := strings.Join(opts.URI.GetConnectionAddrs(), ",")
+	provider, err := db.NewSessionProvider(*opts)
+	if err != nil {
+		return fmt.Errorf("error connecting to %v: %v", name, err)
+	}
+	defer provider.Close()
+
+	report := restore.verification
+	if report == nil {
+		source := restore.TargetDirectory
+		if restore.InputOptions.Archive != "" {
+			source = restore.InputOptions.Archive
+		}
+		report = &verifyReport{Passed: true, Source: source, Started: time.Now().UTC()}
+	}
+	report.Against = name
+	collections := map[string]*verifyCollection{}
+	for _, collection := range report.Collections {
+		collections[collection.Namespace] = collection
+	}
+
+	failed, compared := 0, 0
+	for _, intent := range restore.verifyIntents() {
+		// the hash of a time series collection is of its buckets, which
+		// are written by the server as the measurements are restored
+		checkHash := !intent.IsTimeseries()
+		sourceNS := restore.manager.SourceNamespace(intent)
+		expected, err := readClusterState(provider, sourceNS, checkHash)
+		if err != nil {
+			return fmt.Errorf("error reading %v from %v: %v", sourceNS, name, err)
+		}
+		actual, err := readClusterState(restore.SessionProvider, intent.Namespace(), checkHash)
+		if err != nil {
+			return fmt.Errorf("error verifying %v: %v", intent.Namespace(), err)
+		}
+
+		collection := collections[intent.Namespace()]
+		if collection == nil {
+			collection = &verifyCollection{Namespace: intent.Namespace(), Passed: true}
+			report.Collections = append(report.Collections, collection)
+		}
+		passed := true
+		for _, check := range compareClusterStates(expected, actual, checkHash) {
+			if !check.Passed {
+				passed, collection.Passed, report.Passed = false, false, false
+				log.Logvf(log.Always, "verify %v against %v: %v failed: %v", intent.Namespace(), name, check.Check, check.Detail)
+			}
+			collection.Checks = append(collection.Checks, check)
+		}
+		if !passed {
+			failed++
+		}
+		compared++
+	}
+	report.Finished = time.Now().UTC()
+	restore.verification = report
+	if err = restore.writeVerifyReport(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("verify against %v failed: %v of %v %v did not match the source", name, failed,
+			compared, util.Pluralize(compared, "collection", "collections"))
+	}
+	log.Logvf(log.Always, "verify against %v passed: all %v %v match the source", name, compared,
+		util.Pluralize(compared, "collection", "collections"))
+	return nil
+}
+
+// readClusterState reads the document count and, if hash is set, the
+// dbHash of the collection at namespace.
+func readClusterState(provider *db.SessionProvider, namespace string, hash bool) (clusterState, error) {
+	var state clusterState
+	session, err := provider.GetSession()
+	if err != nil {
+		return state, fmt.Errorf("error establishing connection: %v", err)
+	}
+	dbName, colName := util.SplitNamespace(namespace)
+	state.Documents, err = session.Database(dbName).Collection(colName).CountDocuments(nil, bson.D{})
+	if err != nil {
+		return state, fmt.Errorf("error counting documents: %v", err)
+	}
+	if hash {
+		var hashes struct {
+			Collections map[string]string `bson:"collections"`
+		}
+		state.HashErr = provider.Run(bson.D{{Key: "dbHash", Value: 1}, {Key: "collections", Value: bson.A{colName}}},
+			&hashes, dbName)
+		state.MD5 = hashes.Collections[colName]
+	}
+	return state, nil
+}
+
+// compareClusterStates checks a restored collection against the one in the
+// source cluster.
+func compareClusterStates(expected, actual clusterState, hash bool) []verifyCheck {
+	count := verifyCheck{Check: "source count", Passed: true}
+	if actual.Documents != expected.Documents {
+		count.Passed, count.Detail = false, fmt.Sprintf("%v documents, but the source has %v", actual.Documents, expected.Documents)
+	}
+	checks := []verifyCheck{count}
+	if hash {
+		check := verifyCheck{Check: "source dbHash", Passed: true}
+		switch {
+		case expected.HashErr != nil:
+			check.Passed, check.Detail = false, fmt.Sprintf("error running dbHash on the source: %v", expected.HashErr)
+		case actual.HashErr != nil:
+			check.Passed, check.Detail = false, fmt.Sprintf("error running dbHash: %v", actual.HashErr)
+		case actual.MD5 != expected.MD5:
+			check.Passed, check.Detail = false, fmt.Sprintf("md5 %v, but the source has %v", actual.MD5, expected.MD5)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
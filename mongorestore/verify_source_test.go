@@ -0,0 +1,77 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidateVerifyAgainstOptions(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	validate := func(opts *OutputOptions) error {
+		restore := &MongoRestore{InputOptions: &InputOptions{}, OutputOptions: opts}
+		return restore.validateVerifyOptions()
+	}
+
+	Convey("--verifyAgainst takes a connection string", t, func() {
+		So(validate(&OutputOptions{VerifyAgainst: "mongodb://source:27017"}), ShouldBeNil)
+		So(validate(&OutputOptions{VerifyAgainst: "source:27017"}), ShouldNotBeNil)
+	})
+
+	Convey("--verifyReport can be written for --verifyAgainst alone", t, func() {
+		So(validate(&OutputOptions{VerifyAgainst: "mongodb://source", VerifyReport: "report.json"}), ShouldBeNil)
+		So(validate(&OutputOptions{VerifyReport: "report.json"}), ShouldNotBeNil)
+		So(validate(&OutputOptions{VerifyAgainst: "mongodb://source", VerifyHash: true}), ShouldNotBeNil)
+	})
+
+	Convey("--verifyAgainst needs the documents to be restored", t, func() {
+		So(validate(&OutputOptions{VerifyAgainst: "mongodb://source", MetadataOnly: true}), ShouldNotBeNil)
+		So(validate(&OutputOptions{VerifyAgainst: "mongodb://source", IndexesOnly: true}), ShouldNotBeNil)
+	})
+}
+
+func TestCompareClusterStates(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	passed := func(checks []verifyCheck) map[string]bool {
+		result := map[string]bool{}
+		for _, check := range checks {
+			result[check.Check] = check.Passed
+		}
+		return result
+	}
+	source := clusterState{Documents: 10, MD5: "abc"}
+
+	Convey("A collection matching the source passes", t, func() {
+		So(passed(compareClusterStates(source, clusterState{Documents: 10, MD5: "abc"}, true)), ShouldResemble,
+			map[string]bool{"source count": true, "source dbHash": true})
+		So(passed(compareClusterStates(source, clusterState{Documents: 10}, false)), ShouldResemble,
+			map[string]bool{"source count": true})
+	})
+
+	Convey("Different counts or hashes fail", t, func() {
+		checks := compareClusterStates(source, clusterState{Documents: 9, MD5: "abd"}, true)
+		So(passed(checks), ShouldResemble, map[string]bool{"source count": false, "source dbHash": false})
+		So(checks[0].Detail, ShouldEqual, "9 documents, but the source has 10")
+		So(checks[1].Detail, ShouldEqual, "md5 abd, but the source has abc")
+	})
+
+	Convey("A dbHash that can't be run on either cluster fails its check", t, func() {
+		unsupported := fmt.Errorf("no such command: 'dbHash'")
+		checks := compareClusterStates(clusterState{Documents: 10, HashErr: unsupported}, clusterState{Documents: 10}, true)
+		So(passed(checks), ShouldResemble, map[string]bool{"source count": true, "source dbHash": false})
+		So(checks[1].Detail, ShouldContainSubstring, "on the source")
+
+		checks = compareClusterStates(source, clusterState{Documents: 10, HashErr: unsupported}, true)
+		So(passed(checks)["source dbHash"], ShouldBeFalse)
+	})
+}
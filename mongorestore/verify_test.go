@@ -0,0 +1,137 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCompareCollection(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	count := func(n int64) *int64 { return &n }
+	expected := collectionState{
+		CheckCount: true,
+		Documents:  count(10),
+		Indexes: []IndexDocument{
+			{Options: bson.M{"name": "_id_"}, Key: bson.D{{Key: "_id", Value: int32(1)}}},
+			{Options: bson.M{"name": "a_1"}, Key: bson.D{{Key: "a", Value: 1.0}}},
+		},
+		Options: bson.D{{Key: "capped", Value: true}, {Key: "size", Value: int32(4096)}},
+		MD5:     "abc",
+	}
+	actual := collectionState{
+		Documents: count(10),
+		Indexes: []IndexDocument{
+			{Options: bson.M{"name": "_id_"}, Key: bson.D{{Key: "_id", Value: int32(1)}}},
+			{Options: bson.M{"name": "a_1"}, Key: bson.D{{Key: "a", Value: int64(1)}}},
+			{Options: bson.M{"name": "b_1"}, Key: bson.D{{Key: "b", Value: int32(1)}}},
+		},
+		Options: bson.D{{Key: "capped", Value: true}, {Key: "size", Value: int64(4096)}, {Key: "max", Value: int32(0)}},
+		MD5:     "abc",
+	}
+	passed := func(checks []verifyCheck) map[string]bool {
+		result := map[string]bool{}
+		for _, check := range checks {
+			result[check.Check] = check.Passed
+		}
+		return result
+	}
+
+	Convey("A restored collection matching the dump passes", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{VerifyHash: true}}
+		So(passed(restore.compareCollection(expected, actual)), ShouldResemble, map[string]bool{
+			"count": true, "indexes": true, "options": true, "dbHash": true,
+		})
+
+		Convey("and the checks of what wasn't restored are skipped", func() {
+			restore.OutputOptions = &OutputOptions{NoIndexRestore: true, NoOptionsRestore: true}
+			So(passed(restore.compareCollection(expected, actual)), ShouldResemble, map[string]bool{"count": true})
+		})
+	})
+
+	Convey("Each difference from the dump fails its check", t, func() {
+		restore := &MongoRestore{OutputOptions: &OutputOptions{VerifyHash: true}}
+		differing := actual
+		differing.Documents = count(9)
+		differing.Indexes = actual.Indexes[:1]
+		differing.Options = bson.D{{Key: "capped", Value: false}}
+		differing.MD5 = "def"
+		checks := restore.compareCollection(expected, differing)
+		So(passed(checks), ShouldResemble, map[string]bool{
+			"count": false, "indexes": false, "options": false, "dbHash": false,
+		})
+		So(checks[0].Detail, ShouldEqual, "9 documents, but the dump has 10")
+		So(checks[1].Detail, ShouldEqual, "a_1 is missing")
+
+		Convey("as does a dump without a count or hash in its manifest", func() {
+			unrecorded := expected
+			unrecorded.Documents, unrecorded.MD5 = nil, ""
+			So(passed(restore.compareCollection(unrecorded, actual)), ShouldResemble, map[string]bool{
+				"count": false, "indexes": true, "options": true, "dbHash": false,
+			})
+		})
+	})
+
+	Convey("Index keys aren't compared when restoring converts them", t, func() {
+		changed := []IndexDocument{{Options: bson.M{"name": "a_1"}, Key: bson.D{{Key: "a", Value: "hashed"}}}}
+		So(compareIndexes(expected.Indexes[1:], changed, true).Passed, ShouldBeFalse)
+		So(compareIndexes(expected.Indexes[1:], changed, false).Passed, ShouldBeTrue)
+	})
+}
+
+func TestReadVerifyManifest(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a dump directory and its manifest", t, func() {
+		dir, err := ioutil.TempDir("", "mongorestore_verify")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(os.Mkdir(filepath.Join(dir, "db"), 0755), ShouldBeNil)
+		manifest := `{"files": [
+			{"path": "db/c.bson", "documents": 3, "md5": "abc"},
+			{"path": "db/split.bson.part0000", "documents": 5}
+		]}`
+		So(ioutil.WriteFile(filepath.Join(dir, "manifest.json"), []byte(manifest), 0644), ShouldBeNil)
+
+		Convey("the entries of BSON files are found by their paths", func() {
+			restore := &MongoRestore{TargetDirectory: dir + "/"}
+			root, files, err := restore.readVerifyManifest()
+			So(err, ShouldBeNil)
+			So(root, ShouldEqual, dir)
+			file := manifestEntryFor(files, relativeLocation(root, filepath.Join(dir, "db", "c.bson")))
+			So(file, ShouldNotBeNil)
+			So(*file.Documents, ShouldEqual, 3)
+			So(file.MD5, ShouldEqual, "abc")
+			file = manifestEntryFor(files, relativeLocation(root, filepath.Join(dir, "db", "split.bson")))
+			So(file, ShouldNotBeNil)
+			So(*file.Documents, ShouldEqual, 5)
+			So(manifestEntryFor(files, "db/missing.bson"), ShouldBeNil)
+		})
+
+		Convey("the manifest of a database's directory is in its parent", func() {
+			restore := &MongoRestore{TargetDirectory: filepath.Join(dir, "db")}
+			root, _, err := restore.readVerifyManifest()
+			So(err, ShouldBeNil)
+			So(root, ShouldEqual, dir)
+		})
+
+		Convey("a dump without a manifest can't be verified", func() {
+			So(os.Remove(filepath.Join(dir, "manifest.json")), ShouldBeNil)
+			restore := &MongoRestore{TargetDirectory: dir}
+			_, _, err := restore.readVerifyManifest()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,219 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// pendingView is a view whose creation is deferred until the collections
+// have been restored, so that views can be created after what they read from.
+type pendingView struct {
+	intent  *intents.Intent
+	options bson.D
+	// dependencies are the destination namespaces the view reads from
+	dependencies []string
+}
+
+// isViewOptions returns whether collection options define a view.
+func isViewOptions(options bson.D) bool {
+	_, err := bsonutil.FindValueByKey("viewOn", &options)
+	return err == nil
+}
+
+// newPendingView returns a view to be created by createViews. The
+// namespaces the view reads from come from the metadata of newer dumps; for
+// older ones they are found in its viewOn and pipeline.
+func (restore *MongoRestore) newPendingView(intent *intents.Intent, options bson.D, sources []string) *pendingView {
+	view := &pendingView{intent: intent, options: options}
+	for _, source := range sources {
+		if restore.renamer != nil {
+			source = restore.renamer.Get(source)
+		}
+		view.dependencies = append(view.dependencies, source)
+	}
+	if len(sources) == 0 {
+		// the view is created in the database it is restored to, which
+		// names in its options without a database refer to
+		view.dependencies = viewSources(intent.DB, options)
+	}
+	return view
+}
+
+// deferView records a view to be created by createViews.
+func (restore *MongoRestore) deferView(intent *intents.Intent, options bson.D, sources []string) {
+	view := restore.newPendingView(intent, options, sources)
+	restore.pendingViewsMutex.Lock()
+	defer restore.pendingViewsMutex.Unlock()
+	restore.pendingViews = append(restore.pendingViews, view)
+}
+
+// viewSources returns the namespaces the view with options reads from: the
+// collection or view it is defined on, and those its pipeline looks up with
+// $lookup, $graphLookup or $unionWith, including in nested pipelines.
+func viewSources(dbName string, options bson.D) []string {
+	var sources []string
+	seen := map[string]bool{}
+	add := func(source interface{}) {
+		ns := ""
+		switch source := source.(type) {
+		case string:
+			ns = dbName + "." + source
+		case bson.D:
+			// the {db, coll} form of $lookup and $unionWith
+			coll, _ := bsonutil.FindStringValueByKey("coll", &source)
+			if sourceDB, _ := bsonutil.FindStringValueByKey("db", &source); coll != "" && sourceDB != "" {
+				ns = sourceDB + "." + coll
+			} else if coll != "" {
+				ns = dbName + "." + coll
+			}
+		}
+		if ns != "" && !seen[ns] {
+			seen[ns] = true
+			sources = append(sources, ns)
+		}
+	}
+
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch value := value.(type) {
+		case bson.D:
+			for _, elem := range value {
+				switch elem.Key {
+				case "$lookup", "$graphLookup":
+					if stage, ok := elem.Value.(bson.D); ok {
+						from, _ := bsonutil.FindValueByKey("from", &stage)
+						add(from)
+					}
+				case "$unionWith":
+					add(elem.Value)
+				}
+				walk(elem.Value)
+			}
+		case bson.A:
+			for _, elem := range value {
+				walk(elem)
+			}
+		}
+	}
+
+	viewOn, _ := bsonutil.FindValueByKey("viewOn", &options)
+	add(viewOn)
+	pipeline, _ := bsonutil.FindValueByKey("pipeline", &options)
+	walk(pipeline)
+	return sources
+}
+
+// orderViews returns the views so that each comes after the views it reads
+// from, keeping their order otherwise. Views that read from each other in a
+// cycle, or that read from views with a different collation, which the
+// server rejects, are an error.
+func orderViews(views []*pendingView) ([]*pendingView, error) {
+	byNamespace := map[string]*pendingView{}
+	for _, view := range views {
+		byNamespace[view.intent.Namespace()] = view
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	// path is the chain of views being visited, for reporting a cycle
+	var path []string
+	ordered := make([]*pendingView, 0, len(views))
+	var visit func(view *pendingView) error
+	visit = func(view *pendingView) error {
+		ns := view.intent.Namespace()
+		switch state[ns] {
+		case visiting:
+			for i, name := range path {
+				if name == ns {
+					path = append(path[i:], ns)
+					break
+				}
+			}
+			return fmt.Errorf("views read from each other in a cycle: %v", strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+		state[ns] = visiting
+		path = append(path, ns)
+		for _, dependency := range view.dependencies {
+			if source, ok := byNamespace[dependency]; ok {
+				if err := checkViewCollation(view, source); err != nil {
+					return err
+				}
+				if err := visit(source); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[ns] = visited
+		ordered = append(ordered, view)
+		return nil
+	}
+	for _, view := range views {
+		if err := visit(view); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// viewCollation returns the default collation of a view as extended JSON,
+// or "simple" if it has none.
+func viewCollation(options bson.D) string {
+	collation, err := bsonutil.FindSubdocumentByKey("collation", &options)
+	if err != nil {
+		return "simple"
+	}
+	if locale, _ := bsonutil.FindStringValueByKey("locale", &collation); locale == "simple" {
+		return "simple"
+	}
+	out, err := bson.MarshalExtJSON(collation, false, false)
+	if err != nil {
+		return fmt.Sprint(collation)
+	}
+	return string(out)
+}
+
+// checkViewCollation checks that a view has the same collation as a view it
+// reads from, as the server requires.
+func checkViewCollation(view, source *pendingView) error {
+	collation, sourceCollation := viewCollation(view.options), viewCollation(source.options)
+	if collation != sourceCollation {
+		return fmt.Errorf("view %v has the collation %v, but the view %v it reads from has the collation %v; "+
+			"views that read from views must have the same collation", view.intent.Namespace(), collation,
+			source.intent.Namespace(), sourceCollation)
+	}
+	return nil
+}
+
+// createViews creates the views deferred while restoring the collections,
+// each one after the views it reads from.
+func (restore *MongoRestore) createViews() error {
+	views, err := orderViews(restore.pendingViews)
+	if err != nil {
+		return err
+	}
+	for _, view := range views {
+		log.Logvf(log.Info, "creating view %v on %v", view.intent.Namespace(), view.dependencies)
+		if err = restore.CreateCollection(view.intent, view.options, ""); err != nil {
+			return fmt.Errorf("error creating view %v: %v", view.intent.Namespace(), err)
+		}
+		restore.addToKnownCollections(view.intent)
+	}
+	return nil
+}
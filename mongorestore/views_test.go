@@ -0,0 +1,93 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func viewNamespaces(views []*pendingView) []string {
+	namespaces := []string{}
+	for _, view := range views {
+		namespaces = append(namespaces, view.intent.Namespace())
+	}
+	return namespaces
+}
+
+func TestViewOrder(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With views on views, deferred in the wrong order", t, func() {
+		restore := newMongoRestore()
+		restore.deferView(&intents.Intent{DB: "app", C: "top"}, bson.D{{Key: "viewOn", Value: "middle"}}, []string{"app.middle", "app.orders"})
+		restore.deferView(&intents.Intent{DB: "app", C: "middle"}, bson.D{{Key: "viewOn", Value: "bottom"}}, nil)
+		restore.deferView(&intents.Intent{DB: "app", C: "bottom"}, bson.D{{Key: "viewOn", Value: "orders"}}, nil)
+		restore.deferView(&intents.Intent{DB: "app", C: "other"}, bson.D{{Key: "viewOn", Value: "orders"}}, nil)
+
+		Convey("each view is created after the views it reads from", func() {
+			views, err := orderViews(restore.pendingViews)
+			So(err, ShouldBeNil)
+			So(viewNamespaces(views), ShouldResemble, []string{"app.bottom", "app.middle", "app.top", "app.other"})
+		})
+
+		Convey("a cycle is an error", func() {
+			restore.deferView(&intents.Intent{DB: "app", C: "orders"}, bson.D{{Key: "viewOn", Value: "top"}}, nil)
+			_, err := orderViews(restore.pendingViews)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "app.top -> app.middle -> app.bottom -> app.orders -> app.top")
+		})
+
+		Convey("a view with a different collation than a view it reads from is an error", func() {
+			restore.pendingViews[1].options = append(restore.pendingViews[1].options,
+				bson.E{Key: "collation", Value: bson.D{{Key: "locale", Value: "fr"}}})
+			_, err := orderViews(restore.pendingViews)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, `view app.top has the collation simple, but the view app.middle it reads from has the collation {"locale":"fr"}`)
+
+			restore.pendingViews[0].options = append(restore.pendingViews[0].options,
+				bson.E{Key: "collation", Value: bson.D{{Key: "locale", Value: "fr"}}})
+			restore.pendingViews[2].options = append(restore.pendingViews[2].options,
+				bson.E{Key: "collation", Value: bson.D{{Key: "locale", Value: "fr"}}})
+			_, err = orderViews(restore.pendingViews)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Without recorded dependencies, those of the pipeline are found", t, func() {
+		restore := newMongoRestore()
+		restore.deferView(&intents.Intent{DB: "app", C: "v"}, bson.D{
+			{Key: "viewOn", Value: "orders"},
+			{Key: "pipeline", Value: bson.A{
+				bson.D{{Key: "$lookup", Value: bson.D{
+					{Key: "from", Value: "customers"},
+					{Key: "pipeline", Value: bson.A{
+						bson.D{{Key: "$unionWith", Value: bson.D{{Key: "coll", Value: "archived"}}}},
+					}},
+				}}},
+				bson.D{{Key: "$unionWith", Value: "orders"}},
+				bson.D{{Key: "$graphLookup", Value: bson.D{{Key: "from", Value: "regions"}}}},
+			}},
+		}, nil)
+		So(restore.pendingViews[0].dependencies, ShouldResemble,
+			[]string{"app.orders", "app.customers", "app.archived", "app.regions"})
+	})
+
+	Convey("Recorded dependencies are renamed with the views", t, func() {
+		restore := newMongoRestore()
+		var err error
+		restore.renamer, err = ns.NewRenamer([]string{"app.*"}, []string{"copy.*"})
+		So(err, ShouldBeNil)
+		restore.deferView(&intents.Intent{DB: "copy", C: "v"}, bson.D{{Key: "viewOn", Value: "c"}}, []string{"app.c", "reports.monthly"})
+		So(restore.pendingViews[0].dependencies, ShouldResemble, []string{"copy.c", "reports.monthly"})
+	})
+}
@@ -0,0 +1,185 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/util"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopt "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// nsWriteEntry is an entry of --nsWriteOptions, setting how the documents
+// of the namespaces matching its pattern are inserted.
+type nsWriteEntry struct {
+	NS                       string          `json:"ns"`
+	WriteConcern             json.RawMessage `json:"writeConcern"`
+	Ordered                  *bool           `json:"ordered"`
+	BypassDocumentValidation *bool           `json:"bypassDocumentValidation"`
+}
+
+// nsWriteRule is a parsed nsWriteEntry. The settings it leaves nil are
+// taken from the command line.
+type nsWriteRule struct {
+	pattern                  string
+	matcher                  *ns.Matcher
+	writeConcern             *writeconcern.WriteConcern
+	writeConcernText         string
+	ordered                  *bool
+	bypassDocumentValidation *bool
+}
+
+// nsWrites are the settings the documents of a namespace are inserted with.
+type nsWrites struct {
+	ordered                  bool
+	bypassDocumentValidation bool
+	// writeConcern is nil to insert with the write concern of --writeConcern
+	writeConcern *writeconcern.WriteConcern
+	// rule is the --nsWriteOptions rule the settings come from, if any
+	rule *nsWriteRule
+}
+
+// parseNSWriteOptions parses the rules of an --nsWriteOptions file, which is
+// a list of them in JSON or YAML. A write concern is given as to
+// --writeConcern, either as a string or a number for w, or as a document.
+func parseNSWriteOptions(content []byte) ([]nsWriteRule, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	var entries []nsWriteEntry
+	if err := decoder.Decode(&entries); err != nil {
+		return nil, fmt.Errorf("expected a list of namespaces with writeConcern, ordered or bypassDocumentValidation: %v", err)
+	}
+	rules := make([]nsWriteRule, 0, len(entries))
+	for i, entry := range entries {
+		if entry.NS == "" {
+			return nil, fmt.Errorf("entry %v has no ns", i+1)
+		}
+		if entry.WriteConcern == nil && entry.Ordered == nil && entry.BypassDocumentValidation == nil {
+			return nil, fmt.Errorf("%v sets none of writeConcern, ordered and bypassDocumentValidation", entry.NS)
+		}
+		matcher, err := ns.NewMatcher([]string{entry.NS})
+		if err != nil {
+			return nil, fmt.Errorf("invalid ns %v: %v", entry.NS, err)
+		}
+		rule := nsWriteRule{
+			pattern:                  entry.NS,
+			matcher:                  matcher,
+			ordered:                  entry.Ordered,
+			bypassDocumentValidation: entry.BypassDocumentValidation,
+		}
+		if entry.WriteConcern != nil {
+			var text string
+			if err = json.Unmarshal(entry.WriteConcern, &text); err != nil {
+				text = string(entry.WriteConcern)
+			}
+			if strings.TrimSpace(text) == "" {
+				return nil, fmt.Errorf("%v has an empty writeConcern", entry.NS)
+			}
+			if rule.writeConcern, err = db.NewMongoWriteConcern(text, nil); err != nil {
+				return nil, fmt.Errorf("invalid writeConcern of %v: %v", entry.NS, err)
+			}
+			rule.writeConcernText = text
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// initNSWriteOptions reads the rules of --nsWriteOptions.
+func (restore *MongoRestore) initNSWriteOptions() error {
+	if restore.OutputOptions.NSWriteOptions == "" {
+		return nil
+	}
+	content, err := util.ReadConfigFile(restore.OutputOptions.NSWriteOptions)
+	if err != nil {
+		return fmt.Errorf("error reading %v: %v", NSWriteOptionsOption, err)
+	}
+	if restore.nsWriteRules, err = parseNSWriteOptions(content); err != nil {
+		return fmt.Errorf("error parsing %v: %v", NSWriteOptionsOption, err)
+	}
+	if restore.OutputOptions.WriteToShards {
+		for _, rule := range restore.nsWriteRules {
+			if rule.ordered != nil && *rule.ordered {
+				return fmt.Errorf("cannot insert %v in order with %v, as its documents are written to each shard at once", rule.pattern, WriteToShardsOption)
+			}
+		}
+	}
+	return nil
+}
+
+// writesFor returns the settings the documents of a namespace are inserted
+// with: those of the first --nsWriteOptions rule matching it, falling back
+// to --maintainInsertionOrder, --bypassDocumentValidation and --writeConcern.
+func (restore *MongoRestore) writesFor(namespace string) nsWrites {
+	writes := nsWrites{
+		ordered:                  restore.OutputOptions.MaintainInsertionOrder,
+		bypassDocumentValidation: restore.OutputOptions.BypassDocumentValidation,
+	}
+	for i := range restore.nsWriteRules {
+		rule := &restore.nsWriteRules[i]
+		if !rule.matcher.Has(namespace) {
+			continue
+		}
+		if rule.ordered != nil {
+			writes.ordered = *rule.ordered
+		}
+		if rule.bypassDocumentValidation != nil {
+			writes.bypassDocumentValidation = *rule.bypassDocumentValidation
+		}
+		writes.writeConcern = rule.writeConcern
+		writes.rule = rule
+		break
+	}
+	return writes
+}
+
+// collection returns collection with the write concern of writes.
+func (writes nsWrites) collection(collection *mongo.Collection) *mongo.Collection {
+	if writes.writeConcern == nil {
+		return collection
+	}
+	// Clone returns no error setting a write concern
+	clone, err := collection.Clone(mopt.Collection().SetWriteConcern(writes.writeConcern))
+	if err != nil {
+		return collection
+	}
+	return clone
+}
+
+// String describes the settings of writes that come from --nsWriteOptions,
+// for --dryRun.
+func (writes nsWrites) String() string {
+	if writes.rule == nil {
+		return ""
+	}
+	var settings []string
+	if writes.rule.writeConcern != nil {
+		settings = append(settings, "write concern "+writes.rule.writeConcernText)
+	}
+	if writes.rule.ordered != nil {
+		if writes.ordered {
+			settings = append(settings, "ordered")
+		} else {
+			settings = append(settings, "unordered")
+		}
+	}
+	if writes.rule.bypassDocumentValidation != nil {
+		if writes.bypassDocumentValidation {
+			settings = append(settings, "bypassing document validation")
+		} else {
+			settings = append(settings, "validating documents")
+		}
+	}
+	return strings.Join(settings, ", ") + " by " + NSWriteOptionsOption + " " + writes.rule.pattern
+}
@@ -0,0 +1,114 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseNSWriteOptions(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Write concerns are given as to --writeConcern", t, func() {
+		rules, err := parseNSWriteOptions([]byte(`[
+			{"ns": "prod.orders", "writeConcern": "majority", "ordered": true},
+			{"ns": "analytics.*", "writeConcern": 1, "bypassDocumentValidation": true},
+			{"ns": "logs.*", "writeConcern": {"w": 2, "wtimeout": 500}}]`))
+		So(err, ShouldBeNil)
+		So(rules, ShouldHaveLength, 3)
+		So(rules[0].writeConcern.GetW(), ShouldEqual, "majority")
+		So(*rules[0].ordered, ShouldBeTrue)
+		So(rules[0].bypassDocumentValidation, ShouldBeNil)
+		So(rules[1].writeConcern.GetW(), ShouldEqual, 1)
+		So(rules[1].writeConcernText, ShouldEqual, "1")
+		So(rules[2].writeConcern.GetW(), ShouldEqual, 2)
+		So(rules[2].writeConcern.GetWTimeout(), ShouldEqual, 500*time.Millisecond)
+	})
+
+	Convey("Invalid rules are errors", t, func() {
+		for _, invalid := range []string{
+			`{"ns": "test.c", "ordered": true}`,
+			`[{"ordered": true}]`,
+			`[{"ns": "test.c"}]`,
+			`[{"ns": "test.c", "ordered": "yes"}]`,
+			`[{"ns": "test.c", "writeConcern": ""}]`,
+			`[{"ns": "test.c", "writeConcern": -1}]`,
+			`[{"ns": "test.c", "writeConcern": "majority", "unordered": true}]`,
+		} {
+			_, err := parseNSWriteOptions([]byte(invalid))
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestNSWrites(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With an --nsWriteOptions file", t, func() {
+		dir, err := ioutil.TempDir("", "ns-write-options")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "writes.yaml")
+		So(ioutil.WriteFile(path, []byte(`
+- ns: prod.orders
+  writeConcern: majority
+  ordered: true
+- ns: prod.*
+  bypassDocumentValidation: false
+- ns: analytics.*
+  writeConcern: {w: 1}
+  ordered: false
+`), 0644), ShouldBeNil)
+		restore := &MongoRestore{OutputOptions: &OutputOptions{NSWriteOptions: path, BypassDocumentValidation: true}}
+		So(restore.initNSWriteOptions(), ShouldBeNil)
+		So(restore.nsWriteRules, ShouldHaveLength, 3)
+
+		Convey("the first matching rule applies", func() {
+			writes := restore.writesFor("prod.orders")
+			So(writes.ordered, ShouldBeTrue)
+			So(writes.bypassDocumentValidation, ShouldBeTrue)
+			So(writes.writeConcern.GetW(), ShouldEqual, "majority")
+			So(writes.String(), ShouldEqual, "write concern majority, ordered by --nsWriteOptions prod.orders")
+
+			writes = restore.writesFor("prod.users")
+			So(writes.ordered, ShouldBeFalse)
+			So(writes.bypassDocumentValidation, ShouldBeFalse)
+			So(writes.writeConcern, ShouldBeNil)
+			So(writes.String(), ShouldEqual, "validating documents by --nsWriteOptions prod.*")
+		})
+
+		Convey("other namespaces keep the command line settings", func() {
+			restore.OutputOptions.MaintainInsertionOrder = true
+			writes := restore.writesFor("test.c")
+			So(writes.ordered, ShouldBeTrue)
+			So(writes.bypassDocumentValidation, ShouldBeTrue)
+			So(writes.writeConcern, ShouldBeNil)
+			So(writes.String(), ShouldEqual, "")
+			So(restore.writesFor("analytics.events").ordered, ShouldBeFalse)
+		})
+
+		Convey("ordered inserts can't be written to the shards", func() {
+			restore.OutputOptions.WriteToShards = true
+			So(restore.initNSWriteOptions(), ShouldNotBeNil)
+		})
+
+		Convey("the settings are planned with --dryRun", func() {
+			intent := &intents.Intent{DB: "analytics", C: "events", Location: "dump/analytics/events.bson", BSONFile: &realBSONFile{}}
+			plan := restore.planCollection(collectionPlan{intent: intent, metadata: &Metadata{}})
+			So(plan.Steps[len(plan.Steps)-1].Operation, ShouldEqual, planInsert)
+			So(plan.Steps[len(plan.Steps)-1].Detail, ShouldEndWith, `, write concern {"w":1}, unordered by --nsWriteOptions analytics.*`)
+		})
+	})
+}
@@ -12,10 +12,10 @@ import (
 	"strings"
 	"time"
 
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/password"
-	"github.com/mongodb/mongo-tools-common/signals"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/password"
+	"github.com/mongodb/mongo-tools/common/signals"
+	"github.com/mongodb/mongo-tools/common/util"
 	"github.com/mongodb/mongo-tools/mongostat"
 	"github.com/mongodb/mongo-tools/mongostat/stat_consumer"
 	"github.com/mongodb/mongo-tools/mongostat/stat_consumer/line"
@@ -60,8 +60,10 @@ func main() {
 	if err != nil {
 		log.Logvf(log.Always, "error parsing command line options: %s", err.Error())
 		log.Logvf(log.Always, util.ShortUsage("mongostat"))
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitBadOptions)
 	}
+	defer util.Shutdown()
+	defer util.RecoverCrash()
 
 	log.SetVerbosity(opts.Verbosity)
 	signals.Handle()
@@ -83,31 +85,31 @@ func main() {
 		// add logic to have different error if using uri
 		if opts.URI != nil && opts.URI.ConnectionString != "" {
 			log.Logvf(log.Always, "authSource is required when authenticating against a non $external database")
-			os.Exit(util.ExitFailure)
+			util.Exit(util.ExitFailure)
 		}
 
 		log.Logvf(log.Always, "--authenticationDatabase is required when authenticating against a non $external database")
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitFailure)
 	}
 
 	if opts.Interactive && opts.Json {
 		log.Logvf(log.Always, "cannot use output formats --json and --interactive together")
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitFailure)
 	}
 
 	if opts.Deprecated && !opts.Json {
 		log.Logvf(log.Always, "--useDeprecatedJsonKeys can only be used when --json is also specified")
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitFailure)
 	}
 
 	if opts.Columns != "" && opts.AppendColumns != "" {
 		log.Logvf(log.Always, "-O cannot be used if -o is also specified")
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitFailure)
 	}
 
 	if opts.HumanReadable != "true" && opts.HumanReadable != "false" {
 		log.Logvf(log.Always, "--humanReadable must be set to either 'true' or 'false'")
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitFailure)
 	}
 
 	// we have to check this here, otherwise the user will be prompted
@@ -115,8 +117,7 @@ func main() {
 	if opts.Auth.ShouldAskForPassword() {
 		pass, err := password.Prompt()
 		if err != nil {
-			log.Logvf(log.Always, "Failed: %v", err)
-			os.Exit(util.ExitFailure)
+			util.Exit(util.LogFailure(err))
 		}
 		opts.Auth.Password = pass
 	}
@@ -212,7 +213,7 @@ func main() {
 	for _, v := range seedHosts {
 		if err := stat.AddNewNode(v); err != nil {
 			log.Logv(log.Always, err.Error())
-			os.Exit(util.ExitFailure)
+			util.Exit(util.ExitFailure)
 		}
 	}
 
@@ -223,7 +224,6 @@ func main() {
 	}
 	formatter.Finish()
 	if err != nil {
-		log.Logvf(log.Always, "Failed: %v", err)
-		os.Exit(util.ExitFailure)
+		util.Exit(util.LogFailure(err))
 	}
 }
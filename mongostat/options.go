@@ -10,7 +10,7 @@ import (
 	"fmt"
 	"strconv"
 
-	"github.com/mongodb/mongo-tools-common/options"
+	"github.com/mongodb/mongo-tools/common/options"
 	"github.com/mongodb/mongo-tools/mongostat/stat_consumer"
 )
 
@@ -69,6 +69,9 @@ func ParseOptions(rawArgs []string, versionStr, gitCommit string) (Options, erro
 	if err != nil {
 		return Options{}, err
 	}
+	if opts.LogsToStdout() {
+		return Options{}, fmt.Errorf("--logSplitStreams and --progressJson=- cannot be used with mongostat, which writes its output to stdout")
+	}
 
 	if len(args) > 1 {
 		return Options{}, fmt.Errorf("error parsing positional arguments: " +
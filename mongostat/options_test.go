@@ -10,8 +10,8 @@ import (
 	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
 	"testing"
 
-	"github.com/mongodb/mongo-tools-common/options"
-	"github.com/mongodb/mongo-tools-common/testtype"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testtype"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -154,6 +154,10 @@ func TestPositionalArgumentParsing(t *testing.T) {
 				InputArgs: []string{"mongodb://foo", "2", "--uri=mongodb://bar"},
 				ExpectErr: "illegal argument combination: cannot specify a URI in a positional argument and --uri",
 			},
+			{
+				InputArgs: []string{"--logSplitStreams"},
+				ExpectErr: "--logSplitStreams and --progressJson=- cannot be used with mongostat, which writes its output to stdout",
+			},
 		}
 
 		for _, tc := range positionalArgumentTestCases {
@@ -11,7 +11,7 @@ import (
 	"io"
 	"os"
 
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/util"
 	"github.com/mongodb/mongo-tools/mongostat/stat_consumer/line"
 	"github.com/mongodb/mongo-tools/mongostat/status"
 )
@@ -87,7 +87,7 @@ func (sc *StatConsumer) FormatLines(lines []*line.StatLine) bool {
 	_, err := fmt.Fprintf(sc.writer, "%s", str)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error writing formatted output: %v", err)
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitFailure)
 	}
 	return sc.formatter.IsFinished()
 }
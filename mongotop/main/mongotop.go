@@ -11,10 +11,10 @@ import (
 	"os"
 	"time"
 
-	"github.com/mongodb/mongo-tools-common/db"
-	"github.com/mongodb/mongo-tools-common/log"
-	"github.com/mongodb/mongo-tools-common/signals"
-	"github.com/mongodb/mongo-tools-common/util"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/signals"
+	"github.com/mongodb/mongo-tools/common/util"
 	"github.com/mongodb/mongo-tools/mongotop"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
@@ -30,8 +30,10 @@ func main() {
 	if err != nil {
 		log.Logvf(log.Always, "error parsing command line options: %s", err.Error())
 		log.Logvf(log.Always, util.ShortUsage("mongotop"))
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitBadOptions)
 	}
+	defer util.Shutdown()
+	defer util.RecoverCrash()
 
 	// print help, if specified
 	if opts.PrintHelp(false) {
@@ -51,16 +53,16 @@ func main() {
 
 	if opts.RowCount < 0 {
 		log.Logvf(log.Always, "invalid value for --rowcount: %v", opts.RowCount)
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitFailure)
 	}
 
 	if opts.Auth.Username != "" && opts.Auth.Source == "" && !opts.Auth.RequiresExternalDB() {
 		if opts.URI != nil && opts.URI.ConnectionString != "" {
 			log.Logvf(log.Always, "authSource is required when authenticating against a non $external database")
-			os.Exit(util.ExitFailure)
+			util.Exit(util.ExitFailure)
 		}
 		log.Logvf(log.Always, "--authenticationDatabase is required when authenticating against a non $external database")
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitFailure)
 	}
 
 	if opts.ReplicaSetName == "" {
@@ -71,18 +73,17 @@ func main() {
 	sessionProvider, err := db.NewSessionProvider(*opts.ToolOptions)
 	if err != nil {
 		log.Logvf(log.Always, "error connecting to host: %v", err)
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitFailure)
 	}
 
 	// fail fast if connecting to a mongos
 	isMongos, err := sessionProvider.IsMongos()
 	if err != nil {
-		log.Logvf(log.Always, "Failed: %v", err)
-		os.Exit(util.ExitFailure)
+		util.Exit(util.LogFailure(err))
 	}
 	if isMongos {
 		log.Logvf(log.Always, "cannot run mongotop against a mongos")
-		os.Exit(util.ExitFailure)
+		util.Exit(util.ExitFailure)
 	}
 
 	// instantiate a mongotop instance
@@ -95,7 +96,6 @@ func main() {
 
 	// kick it off
 	if err := top.Run(); err != nil {
-		log.Logvf(log.Always, "Failed: %v", err)
-		os.Exit(util.ExitFailure)
+		util.Exit(util.LogFailure(err))
 	}
 }
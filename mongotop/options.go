@@ -10,7 +10,7 @@ import (
 	"fmt"
 	"strconv"
 
-	"github.com/mongodb/mongo-tools-common/options"
+	"github.com/mongodb/mongo-tools/common/options"
 )
 
 var Usage = `<options> <connection-string> <polling interval in seconds>
@@ -52,6 +52,9 @@ func ParseOptions(rawArgs []string, versionStr, gitCommit string) (Options, erro
 	if err != nil {
 		return Options{}, err
 	}
+	if opts.LogsToStdout() {
+		return Options{}, fmt.Errorf("--logSplitStreams and --progressJson=- cannot be used with mongotop, which writes its output to stdout")
+	}
 
 	if len(extraArgs) > 1 {
 		return Options{}, fmt.Errorf("error parsing positional arguments: " +
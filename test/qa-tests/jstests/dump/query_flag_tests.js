@@ -114,5 +114,41 @@
 
   restoreTest();
 
+  // Running mongodump with '--namespaceQueryFile' and '--collection' should fail
+  dumpArgs = ['dump',
+    '--namespaceQueryFile', 'jstests/dump/testdata/namespace_query.json',
+    '--db', 'foo',
+    '--collection', 'bar']
+    .concat(getDumpTarget(targetPath))
+    .concat(commonToolArgs);
+  assert(toolTest.runTool.apply(toolTest, dumpArgs) !== 0,
+    'mongodump should exit with a non-zero status when --namespaceQueryFile ' +
+    'and --collection are both specified');
+
+  // Running mongodump with a '--namespaceQueryFile' naming a namespace that
+  // isn't dumped should fail
+  dumpArgs = ['dump',
+    '--namespaceQueryFile', 'jstests/dump/testdata/namespace_query_unmatched.json',
+    '--db', 'foo']
+    .concat(getDumpTarget(targetPath))
+    .concat(commonToolArgs);
+  assert(toolTest.runTool.apply(toolTest, dumpArgs) !== 0,
+    'mongodump should exit with a non-zero status when --namespaceQueryFile ' +
+    'has a namespace that isn\'t dumped');
+
+  // Running mongodump with '--namespaceQueryFile' should only get matching
+  // documents of the namespaces in the file
+  resetDbpath(targetPath);
+  dumpArgs = ['dump',
+    '--namespaceQueryFile', 'jstests/dump/testdata/namespace_query.json',
+    '--db', 'foo']
+    .concat(getDumpTarget(targetPath))
+    .concat(commonToolArgs);
+  assert.eq(toolTest.runTool.apply(toolTest, dumpArgs), 0,
+    'mongodump should return exit status 0 when --db and ' +
+    '--namespaceQueryFile are specified');
+
+  restoreTest();
+
   toolTest.stop();
 }());